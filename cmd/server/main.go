@@ -2,46 +2,121 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/demo"
 	"github.com/The-iyed/go-load-balancer/internal/logger"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 func main() {
+	// "check -c <file>" validates a configuration, like --validate
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		check := flag.NewFlagSet("check", flag.ExitOnError)
+		checkPath := check.String("config", "conf/loadbalancer.conf", "configuration file to check")
+		check.StringVar(checkPath, "c", *checkPath, "configuration file to check (shorthand)")
+		resolve := check.Bool("resolve", true, "check that backend hosts resolve")
+		check.Parse(os.Args[2:])
+		os.Exit(validateConfig(*checkPath, *resolve))
+	}
+
+	// "demo" runs the load balancer in front of mock backends with a sample
+	// configuration, to explore it without writing a configuration
+	demo := len(os.Args) > 1 && os.Args[1] == "demo"
+	if demo {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
 	var configPath string
 	var algorithm string
 	var persistence string
 	var enablePathRouting bool
 	var port int
 	var adminPort int
+	var validate bool
+	var resolve bool
+	var demoBackends int
+	var logLevel string
+	var logFormat string
+	var logOutput string
 
 	flag.StringVar(&configPath, "config", "conf/loadbalancer.conf", "accessing configuration file")
 	flag.StringVar(&algorithm, "algorithm", "", "override load balancing algorithm: round-robin, weighted-round-robin, least-connections")
-	flag.StringVar(&persistence, "persistence", "", "override persistence method: none, cookie, ip_hash, consistent_hash")
+	flag.StringVar(&persistence, "persistence", "", "override persistence method: none, cookie, ip_hash, consistent_hash, fingerprint, upload_session")
 	flag.BoolVar(&enablePathRouting, "path-routing", false, "enable path-based routing")
 	flag.IntVar(&port, "port", 8080, "port to listen on")
 	flag.IntVar(&adminPort, "admin-port", 8081, "port for admin API server")
+	flag.BoolVar(&validate, "validate", false, "check the configuration file and exit without starting servers")
+	flag.BoolVar(&resolve, "resolve", true, "with --validate, check that backend hosts resolve")
+	flag.IntVar(&demoBackends, "backends", 4, "with demo, number of mock backends to start")
+	flag.StringVar(&logLevel, "log-level", "", "override log level: debug, info, warn, error")
+	flag.StringVar(&logFormat, "log-format", "", "override log format: json, console")
+	flag.StringVar(&logOutput, "log-output", "", "override log output: stderr, stdout or a file")
 	flag.Parse()
 
+	if validate {
+		os.Exit(validateConfig(configPath, resolve))
+	}
+
 	logger.InitLogger()
 
+	// The log flags apply from the start, before the configuration sets up
+	// the log
+	logConfig := logger.DefaultConfig()
+	if err := applyLogFlags(&logConfig, logLevel, logFormat, logOutput); err != nil {
+		logger.Log.Fatal("Invalid log flag", zap.Error(err))
+	}
+	if err := logger.Configure(logConfig); err != nil {
+		logger.Log.Fatal("Failed to set up the log", zap.Error(err))
+	}
+
+	// Critical events go to the journal, syslog or event log as well, in case
+	// log shipping is broken
+	if err := logger.InitSystemLog(); err != nil {
+		logger.Log.Warn("System log unavailable", zap.Error(err))
+	}
+	defer logger.CloseSystemLog()
+
+	if demo {
+		demoConfig, stopDemo, err := startDemo(demoBackends)
+		if err != nil {
+			logger.Log.Fatal("Failed to start demo environment", zap.Error(err))
+		}
+		defer stopDemo()
+		configPath = demoConfig
+	}
+
 	config, err := balancer.ParseConfig(configPath)
 	if err != nil {
-		logger.Log.Fatal("Failed to parse configuration", zap.Error(err))
+		logger.Event(zapcore.FatalLevel, "config_invalid", "Failed to parse configuration",
+			zap.String("config", configPath),
+			zap.Error(err))
+	}
+	if !config.SystemLog {
+		logger.CloseSystemLog()
+	}
+	if err := applyLogFlags(&config.Log, logLevel, logFormat, logOutput); err != nil {
+		logger.Log.Fatal("Invalid log flag", zap.Error(err))
+	}
+	if err := logger.Configure(config.Log); err != nil {
+		logger.Log.Fatal("Failed to set up the log", zap.Error(err))
 	}
 
 	var lb balancer.LoadBalancerStrategy
 
-	if enablePathRouting || len(config.Routes) > 0 {
+	if enablePathRouting || len(config.Routes) > 0 || len(config.Failovers) > 0 || len(config.Mirrors) > 0 || len(config.SNI.Routes) > 0 || len(config.Listeners) > 0 {
 		// Path-based routing mode
 		logger.Log.Info("Using path-based routing")
 		lb, err = balancer.CreatePathRouter(config)
@@ -68,6 +143,8 @@ func main() {
 				method = balancer.WeightedRoundRobin
 			case "least_connections", "least-connections":
 				method = balancer.LeastConnections
+			case "geo", "geo_weighted", "geo-weighted":
+				method = balancer.GeoWeighted
 			default:
 				logger.Log.Fatal("Unknown algorithm", zap.String("algorithm", algorithm))
 			}
@@ -85,18 +162,27 @@ func main() {
 				persistenceMethod = balancer.IPHashPersistence
 			case "consistent_hash":
 				persistenceMethod = balancer.ConsistentHashPersistence
+			case "fingerprint":
+				persistenceMethod = balancer.FingerprintPersistence
+			case "upload_session":
+				persistenceMethod = balancer.UploadSessionPersistence
 			default:
-				logger.Log.Fatal("Unknown persistence method", zap.String("persistence", persistence))
+				custom, ok := balancer.LookupPersistenceMethod(persistence)
+				if !ok {
+					logger.Log.Fatal("Unknown persistence method", zap.String("persistence", persistence))
+				}
+				persistenceMethod = custom
 			}
 		} else {
 			persistenceMethod = config.PersistenceType
 			persistenceAttrs = config.PersistenceAttrs
 		}
 
-		lb, err = balancer.CreateLoadBalancer(method, config.Backends, persistenceMethod, persistenceAttrs)
+		lb, err = balancer.CreateLoadBalancer(method, config.PoolBackends("backend"), persistenceMethod, persistenceAttrs)
 		if err != nil {
 			logger.Log.Fatal("Failed to create load balancer", zap.Error(err))
 		}
+		lb = balancer.ApplyPoolMiddleware(lb, config, "backend")
 
 		logger.Log.Info("Load balancer configured",
 			zap.String("algorithm", algorithm),
@@ -104,39 +190,175 @@ func main() {
 			zap.Int("backends", len(config.Backends)))
 	}
 
+	// Let backends ask to be drained before they restart
+	balancer.EnableDrainSignal(config.DrainSignal)
+	balancer.SetRevival(config.Revival)
+	balancer.SetRetry(config.Retry)
+	balancer.SetRealIP(config.RealIP)
+
+	// Global middleware wraps every pool
+	lb = balancer.ApplyGlobalMiddleware(lb, config)
+
+	// Tracing is outermost so rejected requests are traced as well
+	tracer := balancer.NewTracer(config.Tracing)
+	lb = balancer.NewTracingMiddleware(lb, tracer)
+
+	// The access log is outermost so rejected requests are logged as well
+	lb, err = balancer.NewAccessLogger(lb, config.AccessLog)
+	if err != nil {
+		logger.Log.Fatal("Failed to open access log", zap.Error(err))
+	}
+
+	logger.Event(zapcore.InfoLevel, "config_applied", "Configuration applied",
+		zap.String("config", configPath),
+		zap.Int("pools", len(config.BackendPools)),
+		zap.Int("routes", len(config.Routes)))
+
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", port),
-		Handler: http.HandlerFunc(lb.ProxyRequest),
+		Handler: balancer.WithHealthEndpoints(http.HandlerFunc(lb.ProxyRequest), lb, config.Readiness),
 	}
+	// Slow clients cannot hold connections open forever
+	balancer.ConfigureClientLimits(server, config.ClientLimits)
+
+	// Sockets are handed over to a new binary on upgrade, so an upgraded
+	// process takes them over instead of listening again
+	handover := balancer.NewSocketHandover()
 
-	// Create a listener first if using dynamic port
-	var listener net.Listener
-	var actualPort int
+	// Create the listener up front so connections can be throttled before HTTP parsing
+	listener, err := handover.Listen("http", server.Addr)
+	if err != nil {
+		logger.Log.Fatal("Failed to create listener", zap.Error(err))
+	}
+	actualPort := listener.Addr().(*net.TCPAddr).Port
 	if port == 0 {
-		listener, err = net.Listen("tcp", ":0")
+		logger.Log.Info("Load balancer listening", zap.Int("port", actualPort))
+	}
+
+	if config.ConnRateLimit > 0 {
+		logger.Log.Info("Connection rate throttling enabled",
+			zap.Float64("rate", config.ConnRateLimit),
+			zap.Int("burst", config.ConnRateBurst))
+	}
+	listener = balancer.NewConnThrottleListener(listener, config.ConnRateLimit, config.ConnRateBurst)
+	listener = balancer.NewConnLimitListener(listener, config.ClientLimits.MaxConnsPerIP)
+
+	// Terminate TLS if a certificate is configured, fingerprinting clients
+	// for cookie-less persistence. Certificates are picked by server name
+	// and reloaded when their files change.
+	if len(config.TLSCertificates) > 0 {
+		var stopReloads func()
+		listener, stopReloads, err = listenTLS(listener, server, config.TLSCertificates, config.TLSReload)
 		if err != nil {
-			logger.Log.Fatal("Failed to create listener", zap.Error(err))
+			logger.Log.Fatal("Failed to load TLS certificate", zap.Error(err))
 		}
-		actualPort = listener.Addr().(*net.TCPAddr).Port
-		logger.Log.Info("Load balancer listening", zap.Int("port", actualPort))
+		defer stopReloads()
+		logger.Log.Info("TLS termination enabled")
 	}
 
 	// Start the main proxy server
 	go func() {
 		logger.Log.Info("Starting load balancer", zap.Int("port", port))
 
-		var err error
-		if listener != nil {
-			err = server.Serve(listener)
-		} else {
-			err = server.ListenAndServe()
-		}
-
-		if err != nil && err != http.ErrServerClosed {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
 			logger.Log.Fatal("Failed to start server", zap.Error(err))
 		}
 	}()
 
+	// Serve the other listeners, each with its own routes over the same pools
+	var listenerServers []*http.Server
+	for _, lc := range config.Listeners {
+		name := lc.Name
+		listenerServer := &http.Server{
+			Addr: lc.Listen,
+			Handler: balancer.WithHealthEndpoints(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lb.ProxyRequest(w, balancer.WithListener(r, name))
+			}), lb, config.Readiness),
+		}
+		balancer.ConfigureClientLimits(listenerServer, config.ClientLimits)
+
+		ln, err := handover.Listen("listener:"+name, lc.Listen)
+		if err != nil {
+			logger.Log.Fatal("Failed to create listener", zap.String("listener", name), zap.Error(err))
+		}
+		ln = balancer.NewConnThrottleListener(ln, config.ConnRateLimit, config.ConnRateBurst)
+		ln = balancer.NewConnLimitListener(ln, config.ClientLimits.MaxConnsPerIP)
+		useTLS := len(lc.TLSCertificates) > 0
+		if useTLS {
+			var stopReloads func()
+			ln, stopReloads, err = listenTLS(ln, listenerServer, lc.TLSCertificates, config.TLSReload)
+			if err != nil {
+				logger.Log.Fatal("Failed to load TLS certificate", zap.String("listener", name), zap.Error(err))
+			}
+			defer stopReloads()
+		}
+
+		go func() {
+			logger.Log.Info("Starting listener",
+				zap.String("listener", name),
+				zap.String("addr", ln.Addr().String()),
+				zap.Bool("tls", useTLS))
+			if err := listenerServer.Serve(ln); err != nil && err != http.ErrServerClosed {
+				logger.Log.Fatal("Failed to start listener", zap.String("listener", name), zap.Error(err))
+			}
+		}()
+		listenerServers = append(listenerServers, listenerServer)
+	}
+
+	pinger := balancer.NewKeepAlivePinger(lb, config.KeepAlive)
+	pinger.Start()
+	defer pinger.Stop()
+
+	// Follow backends configured by host name to the addresses they move to
+	dnsRefresher := balancer.NewDNSRefresher(lb, config.DNSRefresh)
+	dnsRefresher.Start()
+	defer dnsRefresher.Stop()
+
+	// Open connections to the backends before the first requests need them
+	preconnector := balancer.NewPreconnector(lb, config.Preconnect)
+	preconnector.Start()
+	defer preconnector.Stop()
+
+	// Push metrics to a StatsD or DogStatsD agent
+	statsd, err := balancer.NewStatsdExporter(lb, config.Statsd)
+	if err != nil {
+		logger.Log.Fatal("Failed to create StatsD exporter", zap.Error(err))
+	}
+	statsd.Start()
+	defer statsd.Stop()
+
+	// Stop trying backends that have been dead for too long
+	deregisterer := balancer.NewDeregisterer(lb, config.DeregisterAfter)
+	deregisterer.Start()
+	defer deregisterer.Stop()
+
+	// Answer DNS queries with healthy backends for clients that bypass the proxy
+	dnsResponder := balancer.NewDNSResponder(lb, config.DNS)
+	if dnsResponder != nil {
+		dnsConn, err := handover.ListenPacket("dns", config.DNS.Listen)
+		if err != nil {
+			logger.Log.Fatal("Failed to start DNS responder", zap.Error(err))
+		}
+		dnsResponder.Serve(dnsConn)
+		logger.Log.Info("DNS responder enabled", zap.String("addr", dnsResponder.Addr().String()))
+	}
+	defer dnsResponder.Stop()
+
+	// Forward TLS connections by server name, without terminating them
+	sniRouter, err := balancer.NewSNIRouter(lb, config.SNI)
+	if err != nil {
+		logger.Log.Fatal("Failed to create SNI router", zap.Error(err))
+	}
+	if sniRouter != nil {
+		sniListener, err := handover.Listen("sni", config.SNI.Listen)
+		if err != nil {
+			logger.Log.Fatal("Failed to start SNI router", zap.Error(err))
+		}
+		sniRouter.Serve(sniListener)
+		logger.Log.Info("TLS passthrough enabled", zap.String("addr", sniRouter.Addr().String()))
+	}
+	defer sniRouter.Stop()
+
 	// Create the admin API server
 	adminServer := &http.Server{
 		Addr: fmt.Sprintf(":%d", adminPort),
@@ -151,15 +373,67 @@ func main() {
 		w.Write([]byte(`{"status":"healthy"}`))
 	})
 
+	// Liveness and readiness checks for orchestrators and upstream load balancers
+	adminMux.HandleFunc("/healthz", balancer.HealthzHandler())
+	adminMux.HandleFunc("/readyz", balancer.ReadyzHandler(lb, config.Readiness))
+
 	adminMux.HandleFunc("/api/stats", balancer.APIHandler(lb))
+	adminMux.HandleFunc("/metrics", balancer.MetricsHandler())
+	adminMux.HandleFunc("/api/config", balancer.ConfigHandler(lb, config))
+	adminMux.HandleFunc("/api/backends", balancer.BackendsHandler(lb))
+	adminMux.HandleFunc("/api/backends/", balancer.BackendOverrideHandler(lb))
+	adminMux.HandleFunc("/api/backends/drain", balancer.DrainHandler(lb))
+	adminMux.HandleFunc("/api/sessions", balancer.SessionsHandler(lb))
+	adminMux.HandleFunc("/api/sessions/migrate", balancer.SessionMigrationHandler(lb))
+	adminMux.HandleFunc("/api/routes/switch", balancer.BlueGreenHandler(lb))
+	adminMux.HandleFunc("/api/route/explain", balancer.RouteExplainHandler(lb))
+	adminMux.HandleFunc("/api/cache/purge", balancer.CachePurgeHandler())
+
+	// Report pool health to a global server load balancer, polled or pushed
+	adminMux.HandleFunc("/api/gslb", balancer.GSLBHandler(lb, config.GSLB))
+	adminMux.HandleFunc("/api/gslb/", balancer.GSLBHandler(lb, config.GSLB))
+	gslbPusher := balancer.NewGSLBPusher(lb, config.GSLB)
+	gslbPusher.Start()
+	defer gslbPusher.Stop()
+
+	// Change the log level and flip features without a restart
+	adminMux.HandleFunc("/api/log-level", balancer.LogLevelHandler())
+	adminMux.HandleFunc("/api/loglevel", balancer.LogLevelHandler())
+	adminMux.HandleFunc("/api/features", balancer.FeatureHandler())
+
+	// Everything a bug report needs, in one download
+	adminMux.HandleFunc("/api/support-bundle", balancer.SupportBundleHandler(lb, configPath))
+
+	// Keep five minutes of per-backend in-flight request samples
+	sampler := balancer.NewConcurrencySampler(lb, 300)
+	sampler.Start()
+	defer sampler.Stop()
+	adminMux.HandleFunc("/api/backends/concurrency", balancer.ConcurrencyHandler(sampler))
+
+	// Keep a day of per-minute stats snapshots for charts
+	statsRecorder := balancer.NewStatsRecorder(lb, time.Minute, 1440)
+	statsRecorder.Start()
+	defer statsRecorder.Stop()
+	adminMux.HandleFunc("/api/stats/history", balancer.StatsHistoryHandler(statsRecorder))
+
+	// Push backend changes, request rates and lifecycle events to admin
+	// clients; open streams end when the admin server shuts down
+	events := balancer.NewEventStream(lb, time.Second)
+	adminMux.Handle("/api/events", events)
+	adminServer.RegisterOnShutdown(events.Close)
 
 	adminServer.Handler = adminMux
 
+	adminListener, err := handover.Listen("admin", adminServer.Addr)
+	if err != nil {
+		logger.Admin.Fatal("Failed to create admin listener", zap.Error(err))
+	}
+
 	// Start the admin API server
 	go func() {
-		logger.Log.Info("Starting admin API server", zap.Int("port", adminPort))
-		if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Log.Error("Failed to start admin server", zap.Error(err))
+		logger.Admin.Info("Starting admin API server", zap.Int("port", adminPort))
+		if err := adminServer.Serve(adminListener); err != nil && err != http.ErrServerClosed {
+			logger.Admin.Error("Failed to start admin server", zap.Error(err))
 		}
 	}()
 
@@ -169,12 +443,60 @@ func main() {
 		port = actualPort
 	}
 
+	// Every socket is open, so the process being upgraded can stop accepting
+	if err := handover.Ready(); err != nil {
+		logger.Log.Error("Failed to stop the upgraded process", zap.Error(err))
+	}
+
+	logger.Event(zapcore.InfoLevel, "startup", "Load balancer started",
+		zap.Int("port", port),
+		zap.Int("adminPort", adminPort),
+		zap.Int("pid", os.Getpid()))
+
+	if demo {
+		printDemoGuide(port, adminPort, configPath)
+	}
+
+	// Report pools losing all their backends
+	outages := balancer.NewOutageMonitor(lb)
+	outages.Start()
+	defer outages.Stop()
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
 
+	upgrade := make(chan os.Signal, 1)
+	if signals := balancer.UpgradeSignals(); len(signals) > 0 {
+		signal.Notify(upgrade, signals...)
+	}
+
+	// Start a new binary on the upgrade signal; it shuts this process down
+	// once it serves on the handed over sockets
+	for waiting := true; waiting; {
+		select {
+		case <-upgrade:
+			process, err := handover.Upgrade()
+			if err != nil {
+				logger.Log.Error("Failed to start upgraded binary", zap.Error(err))
+				continue
+			}
+			logger.Log.Info("Started upgraded binary", zap.Int("pid", process.Pid))
+			process.Release()
+		case <-quit:
+			waiting = false
+		}
+	}
+
+	logger.Event(zapcore.InfoLevel, "shutdown", "Load balancer shutting down",
+		zap.Int("pid", os.Getpid()))
 	logger.Log.Info("Shutting down servers...")
 
+	// Give WebSocket sessions a chance to close cleanly; the HTTP server
+	// does not track hijacked connections and would cut them off
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), config.WebSocketDrain)
+	balancer.DrainWebSockets(drainCtx)
+	drainCancel()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -183,9 +505,179 @@ func main() {
 		logger.Log.Fatal("Main server forced to shutdown", zap.Error(err))
 	}
 
+	for _, listenerServer := range listenerServers {
+		if err := listenerServer.Shutdown(ctx); err != nil {
+			logger.Log.Error("Listener forced to shutdown", zap.String("addr", listenerServer.Addr), zap.Error(err))
+		}
+	}
+
 	if err := adminServer.Shutdown(ctx); err != nil {
-		logger.Log.Error("Admin server forced to shutdown", zap.Error(err))
+		logger.Admin.Error("Admin server forced to shutdown", zap.Error(err))
 	}
 
+	tracer.Shutdown(ctx)
+	balancer.StopRevivals(lb)
+
 	logger.Log.Info("Servers exiting")
 }
+
+// listenTLS terminates TLS on a listener with certificates picked by server
+// name and reloaded when their files change, fingerprinting clients for
+// cookie-less persistence. It returns the TLS listener and a function
+// stopping the reloads.
+func listenTLS(listener net.Listener, server *http.Server, certs []balancer.TLSCertificateConfig, reload time.Duration) (net.Listener, func(), error) {
+	certificates, err := balancer.NewCertificateStore(certs, reload)
+	if err != nil {
+		return nil, nil, err
+	}
+	certificates.Start()
+
+	tlsConfig := &tls.Config{
+		NextProtos: []string{"http/1.1"},
+	}
+	certificates.Configure(tlsConfig)
+	balancer.NewTLSFingerprinter().Configure(tlsConfig, server)
+	balancer.CountClientTLS(tlsConfig)
+	return tls.NewListener(listener, tlsConfig), certificates.Stop, nil
+}
+
+// applyLogFlags overrides the log settings with the log flags that are set
+func applyLogFlags(config *logger.Config, level, format, output string) error {
+	if level != "" {
+		parsed, err := zapcore.ParseLevel(level)
+		if err != nil {
+			return fmt.Errorf("invalid log level: %s", level)
+		}
+		config.Level = parsed
+	}
+	if format != "" {
+		if format != "json" && format != "console" {
+			return fmt.Errorf("invalid log format, expected json or console: %s", format)
+		}
+		config.Format = format
+	}
+	if output != "" {
+		config.Output = output
+	}
+	return nil
+}
+
+// validateConfig reports every error of a configuration file on stderr, one
+// per line as file:line: message, and returns the exit code
+func validateConfig(path string, resolve bool) int {
+	// Only errors are logged, so the report is not drowned in startup logs
+	logger.Level.SetLevel(zapcore.ErrorLevel)
+	logger.InitLogger()
+
+	errs := balancer.ValidateConfig(path, resolve)
+	for _, err := range errs {
+		var invalid balancer.ErrInvalidConfig
+		if errors.As(err, &invalid) && invalid.Line > 0 {
+			fmt.Fprintf(os.Stderr, "%s:%d: %s\n", path, invalid.Line, invalid.Message)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "%s: %d error(s)\n", path, len(errs))
+		return 1
+	}
+	fmt.Printf("%s: configuration OK\n", path)
+	return 0
+}
+
+// demoConfig is the sample configuration of the demo environment: a default
+// web pool and an API pool reached by path or header. The balancing method
+// and persistence are global, so both pools balance by least connections
+// with cookie persistence. It is formatted with the backend lines of each
+// pool.
+const demoConfig = `# Demo configuration: mock backends started by the load balancer
+# method and persistence apply to every pool
+method least_connections
+persistence cookie
+
+upstream web {
+%s}
+
+upstream api {
+%s}
+
+route path /api/ api
+route header X-Pool api api
+
+default_backend web
+`
+
+// startDemo starts mock backends on the loopback interface and writes a
+// sample configuration for them. It returns the path of the configuration
+// and a function stopping the backends and removing the configuration.
+func startDemo(backends int) (string, func(), error) {
+	if backends < 2 {
+		return "", nil, fmt.Errorf("demo needs at least 2 backends, got %d", backends)
+	}
+
+	// The first half serves the web pool and the rest the API pool, whose
+	// backends get slower and slower so least connections has work to do
+	webBackends := (backends + 1) / 2
+	delays := make([]time.Duration, backends)
+	for i := webBackends; i < backends; i++ {
+		delays[i] = time.Duration(i-webBackends) * 20 * time.Millisecond
+	}
+	cluster, err := demo.StartCluster(backends, delays)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var web, api strings.Builder
+	for i, url := range cluster.URLs() {
+		if i < webBackends {
+			fmt.Fprintf(&web, "    server %s weight=%d\n", url, webBackends-i)
+		} else {
+			fmt.Fprintf(&api, "    server %s weight=1\n", url)
+		}
+	}
+
+	file, err := os.CreateTemp("", "loadbalancer-demo-*.conf")
+	if err != nil {
+		cluster.Close()
+		return "", nil, err
+	}
+	_, err = fmt.Fprintf(file, demoConfig, web.String(), api.String())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cluster.Close()
+		os.Remove(file.Name())
+		return "", nil, err
+	}
+
+	stop := func() {
+		cluster.Close()
+		os.Remove(file.Name())
+	}
+	return file.Name(), stop, nil
+}
+
+// printDemoGuide tells what to try in the demo environment
+func printDemoGuide(port, adminPort int, configPath string) {
+	fmt.Printf(`
+Demo environment running with the configuration in %[3]s
+
+Try:
+  curl -i http://localhost:%[1]d/                    web pool
+  curl -i http://localhost:%[1]d/api/users           API pool, by path
+  curl -i -H 'X-Pool: api' http://localhost:%[1]d/   API pool, routed by header
+Both pools balance by least connections and pin sessions with a cookie;
+send the GOLB_SESSION cookie back (curl -b) to stay on the same backend. The
+X-Backend-ID response header tells which backend answered.
+
+Admin API:
+  http://localhost:%[2]d/api/stats     traffic and backend statistics
+  http://localhost:%[2]d/api/config    the configuration in effect
+  http://localhost:%[2]d/api/features  features that can be flipped at runtime
+
+Press Ctrl+C to stop.
+`, port, adminPort, configPath)
+}
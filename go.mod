@@ -2,9 +2,9 @@ module github.com/The-iyed/go-load-balancer
 
 go 1.21.3
 
-require go.uber.org/zap v1.27.0
-
 require (
-	github.com/gorilla/websocket v1.5.3 // indirect
-	go.uber.org/multierr v1.10.0 // indirect
+	github.com/gorilla/websocket v1.5.3
+	go.uber.org/zap v1.27.0
 )
+
+require go.uber.org/multierr v1.10.0 // indirect
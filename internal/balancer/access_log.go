@@ -0,0 +1,213 @@
+package balancer
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// accessRecord collects what the balancer did with a request for its access
+// log record. Retries run nested inside the failed attempt, so the upstream
+// timing covers every attempt while connect and header times are those of
+// the attempt that answered.
+type accessRecord struct {
+	mu            sync.Mutex
+	route         string
+	pool          string
+	backend       string
+	retries       int
+	hedges        int
+	connectTime   time.Duration
+	headerTime    time.Duration
+	upstreamStart time.Time
+	upstreamEnd   time.Time
+}
+
+type accessRecordContextKey struct{}
+
+func accessRecordFromRequest(r *http.Request) *accessRecord {
+	record, _ := r.Context().Value(accessRecordContextKey{}).(*accessRecord)
+	return record
+}
+
+// annotateRoute records the route and pool chosen for a request. Later calls
+// override the pool, e.g. when a canary or failover pool takes the request.
+func annotateRoute(r *http.Request, route, pool string) {
+	if record := accessRecordFromRequest(r); record != nil {
+		record.mu.Lock()
+		if route != "" {
+			record.route = route
+		}
+		record.pool = pool
+		record.mu.Unlock()
+	}
+	if recorder := requestInfoFromRequest(r); recorder != nil {
+		recorder.mu.Lock()
+		if route != "" {
+			recorder.info.Route = route
+		}
+		recorder.info.Pool = pool
+		recorder.mu.Unlock()
+	}
+}
+
+// traceUpstream returns the request with a client trace timing the upstream
+// connect and time to first byte of an attempt, if the request is logged
+func traceUpstream(r *http.Request) *http.Request {
+	record := accessRecordFromRequest(r)
+	if record == nil {
+		return r
+	}
+
+	start := time.Now()
+	record.mu.Lock()
+	if record.upstreamStart.IsZero() {
+		record.upstreamStart = start
+	}
+	record.connectTime = 0
+	record.headerTime = 0
+	record.mu.Unlock()
+
+	var connectStart time.Time
+	trace := &httptrace.ClientTrace{
+		ConnectStart: func(network, addr string) {
+			connectStart = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			record.mu.Lock()
+			record.connectTime = time.Since(connectStart)
+			record.mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			record.mu.Lock()
+			record.connectTime = time.Since(connectStart)
+			record.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			record.mu.Lock()
+			record.headerTime = time.Since(start)
+			record.mu.Unlock()
+		},
+	}
+
+	return r.WithContext(httptrace.WithClientTrace(r.Context(), trace))
+}
+
+// finishUpstream marks the end of an upstream attempt
+func finishUpstream(r *http.Request) {
+	if record := accessRecordFromRequest(r); record != nil {
+		record.mu.Lock()
+		record.upstreamEnd = time.Now()
+		record.mu.Unlock()
+	}
+}
+
+// AccessLogConfig holds the settings of the access log
+type AccessLogConfig struct {
+	// Path is a file, stdout or stderr; the access log is off if empty
+	Path string
+}
+
+// AccessLogger writes a JSON record for every request with the route, pool
+// and backend that served it, the retries attempted and the upstream timing,
+// like the nginx $upstream_* variables. Comparing the request time with the
+// upstream time tells balancer latency apart from backend latency.
+type AccessLogger struct {
+	next LoadBalancerStrategy
+	log  *zap.Logger
+}
+
+// NewAccessLogger wraps a strategy with access logging.
+// The strategy is returned unchanged if no access log is configured.
+func NewAccessLogger(next LoadBalancerStrategy, config AccessLogConfig) (LoadBalancerStrategy, error) {
+	if config.Path == "" || config.Path == "off" {
+		return next, nil
+	}
+
+	zapConfig := zap.NewProductionConfig()
+	zapConfig.OutputPaths = []string{config.Path}
+	zapConfig.Sampling = nil
+	zapConfig.DisableCaller = true
+	zapConfig.DisableStacktrace = true
+	zapConfig.EncoderConfig.MessageKey = ""
+	zapConfig.EncoderConfig.LevelKey = ""
+
+	log, err := zapConfig.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccessLogger{
+		next: next,
+		log:  log,
+	}, nil
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (al *AccessLogger) GetNextInstance(r *http.Request) (*url.URL, error) {
+	return al.next.GetNextInstance(r)
+}
+
+// ProxyRequest proxies the request and writes its access log record
+func (al *AccessLogger) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	if !featureEnabled(FeatureAccessLog) {
+		al.next.ProxyRequest(w, r)
+		return
+	}
+
+	record := &accessRecord{}
+	r = r.WithContext(context.WithValue(r.Context(), accessRecordContextKey{}, record))
+
+	start := time.Now()
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	al.next.ProxyRequest(recorder, r)
+	duration := time.Since(start)
+
+	record.mu.Lock()
+	defer record.mu.Unlock()
+
+	fields := []zap.Field{
+		zap.String("client_ip", getClientIP(r)),
+		zap.String("method", r.Method),
+		zap.String("uri", r.URL.RequestURI()),
+		zap.Int("status", recorder.status),
+		zap.Duration("request_time", duration),
+		zap.String("route", record.route),
+		zap.String("pool", record.pool),
+		zap.String("upstream", record.backend),
+		zap.Int("upstream_retries", record.retries),
+	}
+	if record.hedges > 0 {
+		fields = append(fields, zap.Int("upstream_hedges", record.hedges))
+	}
+	if r.TLS != nil {
+		fields = append(fields,
+			zap.String("ssl_protocol", tls.VersionName(r.TLS.Version)),
+			zap.String("ssl_cipher", tls.CipherSuiteName(r.TLS.CipherSuite)),
+			zap.String("alpn", alpnName(r.TLS.NegotiatedProtocol)))
+	}
+	if !record.upstreamStart.IsZero() {
+		fields = append(fields,
+			zap.Duration("upstream_connect_time", record.connectTime),
+			zap.Duration("upstream_header_time", record.headerTime),
+			zap.Duration("upstream_response_time", record.upstreamEnd.Sub(record.upstreamStart)))
+	}
+
+	al.log.Info("", fields...)
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (al *AccessLogger) SupportsWebSockets() bool {
+	return al.next.SupportsWebSockets()
+}
+
+// Unwrap returns the wrapped strategy
+func (al *AccessLogger) Unwrap() LoadBalancerStrategy {
+	return al.next
+}
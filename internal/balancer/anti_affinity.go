@@ -0,0 +1,45 @@
+package balancer
+
+import (
+	"net/http"
+)
+
+// avoidedZones returns the failure domains a request should stay away from
+// when anti-affinity is enabled: the zones of the backends that already
+// failed it. Backends without a zone are not in any failure domain. It
+// returns nil when anti-affinity is disabled.
+func avoidedZones(r *http.Request, enabled bool) map[string]bool {
+	if !enabled {
+		return nil
+	}
+
+	zones := make(map[string]bool)
+	for p := range triedBackends(r) {
+		if p.Zone != "" {
+			zones[p.Zone] = true
+		}
+	}
+	return zones
+}
+
+// pickAvoidingZones picks a backend able to serve a request, of priority
+// tier or below, outside the avoided zones if one is eligible, and any
+// backend able to serve it otherwise: anti-affinity is a preference, not a
+// reason to fail a request
+func pickAvoidingZones(r *http.Request, avoid map[string]bool, tier int, pick func(eligible func(*Process) bool, tier int) *Process) *Process {
+	webSocket := IsWebSocketRequest(r)
+	if len(avoid) > 0 {
+		if p := pick(func(p *Process) bool { return canServe(webSocket, p) && !avoid[p.Zone] }, tier); p != nil {
+			return p
+		}
+	}
+	return pick(servable(webSocket), tier)
+}
+
+// setAntiAffinity makes the balancer behind a strategy steer retries and
+// persistence fallbacks away from the zones of the backends that failed
+func setAntiAffinity(strategy LoadBalancerStrategy, enabled bool) {
+	if ps, ok := strategy.(*PoolStrategy); ok && enabled {
+		ps.pool.Settings().AntiAffinity = true
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/The-iyed/go-load-balancer/internal/logger"
@@ -13,33 +14,51 @@ import (
 
 // Stats holds the statistics for the load balancer
 type Stats struct {
-	Backends        []BackendStats    `json:"backends"`
-	Method          string            `json:"method"`
-	TotalRequests   int64             `json:"totalRequests"`
-	PersistenceType string            `json:"persistenceType"`
-	RouteStats      map[string]string `json:"routeStats,omitempty"`
-	StartTime       time.Time         `json:"startTime"`
-	Uptime          string            `json:"uptime"`
+	Backends         []BackendStats                  `json:"backends"`
+	Method           string                          `json:"method"`
+	TotalRequests    int64                           `json:"totalRequests"`
+	PersistenceType  string                          `json:"persistenceType"`
+	RouteStats       map[string]string               `json:"routeStats,omitempty"`
+	Rejections       map[string]int64                `json:"rejections"`
+	Blocks           map[string]int64                `json:"blocks,omitempty"`
+	Overload         *OverloadStats                  `json:"overload,omitempty"`
+	ConnsRefused     int64                           `json:"connsRefused"`
+	LimitPolicies    map[string]RateLimitPolicyStats `json:"limitPolicies,omitempty"`
+	LimitWarnings    map[string]LimitWarningStats    `json:"limitWarnings,omitempty"`
+	SchemaViolations map[string]SchemaViolationStats `json:"schemaViolations,omitempty"`
+	Transforms       map[string]TransformStats       `json:"transforms,omitempty"`
+	SessionRepins    map[string]int64                `json:"sessionRepins,omitempty"`
+	SessionTables    map[string]SessionTableStats    `json:"sessionTables,omitempty"`
+	Canaries         map[string]CanaryStats          `json:"canaries,omitempty"`
+	Splits           map[string]map[string]int64     `json:"splits,omitempty"`
+	Caches           map[string]CacheStats           `json:"caches,omitempty"`
+	Mirrors          map[string]MirrorStats          `json:"mirrors,omitempty"`
+	TLS              TLSStats                        `json:"tls"`
+	Connections      BackendConnectionStats          `json:"connections"`
+	WebSockets       WebSocketStats                  `json:"webSockets"`
+	StartTime        time.Time                       `json:"startTime"`
+	Uptime           string                          `json:"uptime"`
 }
 
 // BackendStats holds the statistics for a backend server
 type BackendStats struct {
-	URL             string  `json:"url"`
-	Alive           bool    `json:"alive"`
-	Weight          int     `json:"weight"`
-	RequestCount    int64   `json:"requestCount"`
-	ErrorCount      int32   `json:"errorCount"`
-	LoadPercentage  float64 `json:"loadPercentage"`
-	ResponseTimeAvg int64   `json:"responseTimeAvg"`
+	URL             string           `json:"url"`
+	Alive           bool             `json:"alive"`
+	Draining        bool             `json:"draining"`
+	Weight          int              `json:"weight"`
+	RequestCount    int64            `json:"requestCount"`
+	ErrorCount      int32            `json:"errorCount"`
+	WebSockets      int              `json:"webSockets"`
+	StatusCodes     map[string]int64 `json:"statusCodes"`
+	LoadPercentage  float64          `json:"loadPercentage"`
+	ResponseTimeAvg int64            `json:"responseTimeAvg"`
 }
 
 var (
 	// Global stats instance
-	globalStats     Stats
-	globalStatsMu   sync.RWMutex
-	startTime       = time.Now()
-	totalRequests   int64
-	requestCountsMu sync.RWMutex
+	globalStats   Stats
+	globalStatsMu sync.RWMutex
+	startTime     = time.Now()
 )
 
 // GetStats returns the current statistics
@@ -58,47 +77,67 @@ func GetStats(lb LoadBalancerStrategy) Stats {
 
 // UpdateStats updates the global statistics
 func UpdateStats(lb LoadBalancerStrategy) {
-	// Update total requests
-	requestCountsMu.RLock()
-	globalStats.TotalRequests = totalRequests
-	requestCountsMu.RUnlock()
-
 	// Update start time
 	globalStats.StartTime = startTime
 
+	globalStats.Rejections = GetRejectionCounts()
+	globalStats.LimitPolicies = GetRateLimitPolicyStats()
+	globalStats.LimitWarnings = GetLimitWarnings()
+	globalStats.SchemaViolations = GetSchemaViolations()
+	globalStats.Blocks = GetBlockCounts()
+	globalStats.Overload = GetOverloadStats()
+	globalStats.ConnsRefused = GetConnsRefused()
+	globalStats.Transforms = GetTransformStats()
+	globalStats.SessionRepins = GetSessionRepins()
+	globalStats.SessionTables = GetSessionTableStats(lb)
+	globalStats.Canaries = GetCanaryStats()
+	globalStats.Splits = GetSplitStats()
+	globalStats.Caches = GetCacheStats()
+	globalStats.Mirrors = GetMirrorStats()
+	globalStats.TLS = GetTLSStats()
+	globalStats.Connections = GetBackendConnectionStats()
+	globalStats.WebSockets = GetWebSocketStats()
+
 	// Handle different types of load balancers
 	switch typedLB := lb.(type) {
-	case *SessionPersistenceBalancer:
-		updateSessionPersistenceStats(typedLB)
 	case *PathRouter:
 		updatePathRouterStats(typedLB)
-	case *LegacyLoadBalancerAdapter:
-		updateLegacyAdapterStats(typedLB)
+	case *PoolStrategy:
+		updatePoolStats(typedLB.pool)
+	case Pool:
+		updatePoolStats(typedLB)
+	case strategyWrapper:
+		UpdateStats(typedLB.Unwrap())
+		return
 	default:
-		logger.Log.Warn("Unknown load balancer type for statistics")
+		logger.Admin.Warn("Unknown load balancer type for statistics")
+		return
 	}
-}
 
-// updateSessionPersistenceStats updates statistics for session persistence balancers
-func updateSessionPersistenceStats(lb *SessionPersistenceBalancer) {
-	globalStats.Method = getMethodName(lb.BaseLB)
-	globalStats.PersistenceType = getPersistenceMethodName(lb.PersistenceMethod)
+	globalStats.Backends, globalStats.TotalRequests = collectBackendStats(strategyProcesses(lb), strategyWeights(lb))
+}
 
-	// Get backends stats
+// collectBackendStats builds the statistics of the given backends, with
+// their weights, and returns them with the total number of requests they
+// served
+func collectBackendStats(processes []*Process, weights map[*Process]int) ([]BackendStats, int64) {
 	totalRequests := int64(0)
-	backends := make([]BackendStats, 0, len(lb.ProcessPack))
+	backends := make([]BackendStats, 0, len(processes))
 
-	for _, process := range lb.ProcessPack {
+	for _, process := range processes {
 		reqCount := process.GetRequestCount()
 		totalRequests += reqCount
 
 		backends = append(backends, BackendStats{
 			URL:             process.URL.String(),
 			Alive:           process.IsAlive(),
-			Weight:          process.Weight,
+			Draining:        process.IsDraining(),
+			Weight:          weights[process],
 			RequestCount:    reqCount,
-			ErrorCount:      process.ErrorCount,
-			ResponseTimeAvg: 0, // We don't track this yet
+			ErrorCount:      atomic.LoadInt32(&process.ErrorCount),
+			WebSockets:      webSocketConnections.CountByBackend(process),
+			StatusCodes:     process.GetStatusCounts(),
+			ResponseTimeAvg: process.GetAverageLatency().Milliseconds(),
 		})
 	}
 
@@ -109,7 +148,7 @@ func updateSessionPersistenceStats(lb *SessionPersistenceBalancer) {
 		}
 	}
 
-	globalStats.Backends = backends
+	return backends, totalRequests
 }
 
 // updatePathRouterStats updates statistics for path router
@@ -123,45 +162,12 @@ func updatePathRouterStats(lb *PathRouter) {
 		routeStats[fmt.Sprintf("route_%d", i)] = route.Pattern
 	}
 	globalStats.RouteStats = routeStats
-
-	// We don't have direct access to backend stats in path router
-	// This would need to be implemented in the PathRouter to track properly
-	globalStats.Backends = []BackendStats{}
 }
 
-// updateLegacyAdapterStats updates statistics for legacy adapter
-func updateLegacyAdapterStats(lb *LegacyLoadBalancerAdapter) {
-	// Use method mapping from adapter.go
-	switch lb.wrappedBalancer.(type) {
-	case *WeightedRoundRobinBalancer:
-		globalStats.Method = "Weighted Round Robin"
-	case *LeastConnectionsBalancer:
-		globalStats.Method = "Least Connections"
-	case *SessionPersistenceBalancer:
-		spb := lb.wrappedBalancer.(*SessionPersistenceBalancer)
-		globalStats.Method = getMethodName(spb.BaseLB)
-		globalStats.PersistenceType = getPersistenceMethodName(spb.PersistenceMethod)
-		return
-	default:
-		globalStats.Method = "Round Robin"
-	}
-
-	globalStats.PersistenceType = "None"
-
-	// This is just a stub - we'd need to implement request tracking in the adapter
-	globalStats.Backends = []BackendStats{}
-}
-
-// getMethodName returns the name of the load balancing method
-func getMethodName(lb interface{}) string {
-	switch lb.(type) {
-	case *WeightedRoundRobinBalancer:
-		return "Weighted Round Robin"
-	case *LeastConnectionsBalancer:
-		return "Least Connections"
-	default:
-		return "Round Robin"
-	}
+// updatePoolStats updates statistics for a single pool
+func updatePoolStats(pool Pool) {
+	globalStats.Method = pool.Method()
+	globalStats.PersistenceType = getPersistenceMethodName(pool.Persistence())
 }
 
 // getPersistenceMethodName returns the name of the persistence method
@@ -173,20 +179,20 @@ func getPersistenceMethodName(method PersistenceMethod) string {
 		return "IP Hash"
 	case ConsistentHashPersistence:
 		return "Consistent Hash"
+	case FingerprintPersistence:
+		return "Fingerprint"
+	case UploadSessionPersistence:
+		return "Upload Session"
 	case NoPersistence:
 		return "None"
 	default:
+		if registration, ok := lookupPersistenceRegistration(method); ok {
+			return registration.name
+		}
 		return "Unknown"
 	}
 }
 
-// IncrementRequestCount increments the total request count
-func IncrementRequestCount() {
-	requestCountsMu.Lock()
-	defer requestCountsMu.Unlock()
-	totalRequests++
-}
-
 // APIHandler handles API requests for stats
 func APIHandler(lb LoadBalancerStrategy) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -213,16 +219,9 @@ func APIHandler(lb LoadBalancerStrategy) http.HandlerFunc {
 
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(stats); err != nil {
-			logger.Log.Error("Failed to encode stats", zap.Error(err))
+			logger.Admin.Error("Failed to encode stats", zap.Error(err))
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
 	}
 }
-
-// Add a method to Process to get request count
-func (p *Process) GetRequestCount() int64 {
-	// We'll need to add a proper request counter in the Process struct later
-	// For now, return 0
-	return 0
-}
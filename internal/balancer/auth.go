@@ -0,0 +1,314 @@
+package balancer
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// AuthConfig holds the settings of a named authentication policy
+type AuthConfig struct {
+	Name string
+	// Type is basic, checking credentials against an htpasswd file, or
+	// request, asking an external service about each request
+	Type string
+	// File and Realm are the htpasswd file and realm of basic authentication
+	File  string
+	Realm string
+	// URL, Timeout and Headers are the authorization service of request
+	// authentication, how long to wait for it and the headers of its
+	// response copied to the proxied request
+	URL     string
+	Timeout time.Duration
+	Headers []string
+}
+
+// parseAuth parses an auth directive defining a named authentication policy
+func parseAuth(parts []string) (AuthConfig, error) {
+	if len(parts) < 3 {
+		return AuthConfig{}, fmt.Errorf("auth directive requires a name and a type")
+	}
+
+	config := AuthConfig{Name: parts[1], Type: parts[2], Realm: "Restricted", Timeout: 5 * time.Second}
+
+	for i := 3; i < len(parts); i++ {
+		if strings.HasPrefix(parts[i], "file=") {
+			config.File = strings.TrimPrefix(parts[i], "file=")
+		} else if strings.HasPrefix(parts[i], "realm=") {
+			config.Realm = strings.TrimPrefix(parts[i], "realm=")
+		} else if strings.HasPrefix(parts[i], "url=") {
+			config.URL = strings.TrimPrefix(parts[i], "url=")
+		} else if strings.HasPrefix(parts[i], "timeout=") {
+			timeoutStr := strings.TrimPrefix(parts[i], "timeout=")
+			timeout, err := time.ParseDuration(timeoutStr)
+			if err != nil || timeout <= 0 {
+				return AuthConfig{}, fmt.Errorf("invalid auth timeout: %s", timeoutStr)
+			}
+			config.Timeout = timeout
+		} else if strings.HasPrefix(parts[i], "set_headers=") {
+			config.Headers = strings.Split(strings.TrimPrefix(parts[i], "set_headers="), ",")
+		}
+	}
+
+	switch config.Type {
+	case "basic":
+		if config.File == "" {
+			return AuthConfig{}, fmt.Errorf("basic auth requires an htpasswd file")
+		}
+	case "request":
+		if u, err := url.Parse(config.URL); err != nil || u.Host == "" {
+			return AuthConfig{}, fmt.Errorf("request auth requires a service url: %s", config.URL)
+		}
+	default:
+		return AuthConfig{}, fmt.Errorf("unknown auth type: %s", config.Type)
+	}
+
+	return config, nil
+}
+
+// loadHtpasswd reads the users of an htpasswd file. Passwords hashed with
+// apr1 (htpasswd -m) and SHA-1 (htpasswd -s) are supported.
+func loadHtpasswd(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, hash, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid htpasswd line for %s", user)
+		}
+		if !strings.HasPrefix(hash, "$apr1$") && !strings.HasPrefix(hash, "{SHA}") {
+			return nil, fmt.Errorf("unsupported password hash for user %s, use htpasswd -m or -s", user)
+		}
+		users[user] = hash
+	}
+
+	return users, scanner.Err()
+}
+
+// checkPassword reports whether a password matches an htpasswd hash
+func checkPassword(password, hash string) bool {
+	var computed string
+	switch {
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		computed = "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	case strings.HasPrefix(hash, "$apr1$"):
+		salt, _, _ := strings.Cut(strings.TrimPrefix(hash, "$apr1$"), "$")
+		computed = apr1(password, salt)
+	default:
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+}
+
+// apr1 computes the Apache variant of the MD5-based crypt of a password
+func apr1(password, salt string) string {
+	const magic = "$apr1$"
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alternate := md5.Sum([]byte(password + salt + password))
+
+	h := md5.New()
+	h.Write([]byte(password + magic + salt))
+	for i := len(pw); i > 0; i -= 16 {
+		h.Write(alternate[:min(i, 16)])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(pw[:1])
+		}
+	}
+	final := h.Sum(nil)
+
+	// Stretch the hash to slow down guessing
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 == 1 {
+			round.Write(pw)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 == 1 {
+			round.Write(final)
+		} else {
+			round.Write(pw)
+		}
+		final = round.Sum(nil)
+	}
+
+	var encoded []byte
+	encode := func(a, b, c byte, n int) {
+		v := uint(a)<<16 | uint(b)<<8 | uint(c)
+		for ; n > 0; n-- {
+			encoded = append(encoded, itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	encode(final[0], final[6], final[12], 4)
+	encode(final[1], final[7], final[13], 4)
+	encode(final[2], final[8], final[14], 4)
+	encode(final[3], final[9], final[15], 4)
+	encode(final[4], final[10], final[5], 4)
+	encode(0, 0, final[11], 2)
+
+	return magic + salt + "$" + string(encoded)
+}
+
+// Authenticator lets a request through to a route only once it is
+// authenticated, either with HTTP basic credentials from an htpasswd file or,
+// like nginx's auth_request, by an external service. The service is sent the
+// request's headers without its body: a 2xx answer lets the request through,
+// 401 and 403 are passed on to the client, and anything else is an error.
+type Authenticator struct {
+	next   LoadBalancerStrategy
+	config AuthConfig
+	users  map[string]string
+	client *http.Client
+}
+
+// NewAuthenticator wraps a route's strategy with authentication.
+// The strategy is returned unchanged if no authentication is configured.
+func NewAuthenticator(next LoadBalancerStrategy, config AuthConfig) (LoadBalancerStrategy, error) {
+	auth := &Authenticator{
+		next:   next,
+		config: config,
+	}
+
+	switch config.Type {
+	case "basic":
+		users, err := loadHtpasswd(config.File)
+		if err != nil {
+			return nil, fmt.Errorf("auth %s: %v", config.Name, err)
+		}
+		auth.users = users
+	case "request":
+		auth.client = &http.Client{
+			Timeout: config.Timeout,
+			// Redirects are answers of the authorization service, not to be followed
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	default:
+		return next, nil
+	}
+
+	return auth, nil
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (a *Authenticator) GetNextInstance(r *http.Request) (*url.URL, error) {
+	return a.next.GetNextInstance(r)
+}
+
+// ProxyRequest proxies the request if it is authenticated
+func (a *Authenticator) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	if a.config.Type == "basic" {
+		user, password, ok := r.BasicAuth()
+		hash, known := a.users[user]
+		if !ok || !known || !checkPassword(password, hash) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", a.config.Realm))
+			rejectRequest(w, RejectUnauthorized, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		a.next.ProxyRequest(w, r)
+		return
+	}
+
+	resp, err := a.askService(r)
+	if err != nil {
+		logger.Log.Error("Authorization service failed",
+			zap.String("auth", a.config.Name),
+			zap.Error(err))
+		rejectRequest(w, RejectAuthUnavailable, "Authorization service unavailable", http.StatusInternalServerError)
+		return
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		r = r.Clone(r.Context())
+		for _, name := range a.config.Headers {
+			if value := resp.Header.Get(name); value != "" {
+				r.Header.Set(name, value)
+			}
+		}
+		a.next.ProxyRequest(w, r)
+	case resp.StatusCode == http.StatusUnauthorized:
+		if challenge := resp.Header.Get("WWW-Authenticate"); challenge != "" {
+			w.Header().Set("WWW-Authenticate", challenge)
+		}
+		rejectRequest(w, RejectUnauthorized, "Unauthorized", http.StatusUnauthorized)
+	case resp.StatusCode == http.StatusForbidden:
+		rejectRequest(w, RejectForbidden, "Forbidden", http.StatusForbidden)
+	default:
+		logger.Log.Error("Unexpected authorization service response",
+			zap.String("auth", a.config.Name),
+			zap.Int("status", resp.StatusCode))
+		rejectRequest(w, RejectAuthUnavailable, "Authorization service unavailable", http.StatusInternalServerError)
+	}
+}
+
+// askService sends the request's headers to the authorization service
+func (a *Authenticator) askService(r *http.Request) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, a.config.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = r.Header.Clone()
+	req.Header.Del("Content-Length")
+	req.Header.Set("X-Original-URI", r.URL.RequestURI())
+	req.Header.Set("X-Original-Method", r.Method)
+	req.Header.Set("X-Forwarded-For", getClientIP(r))
+	if r.Host != "" {
+		req.Header.Set("X-Forwarded-Host", r.Host)
+	}
+
+	return a.client.Do(req)
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (a *Authenticator) SupportsWebSockets() bool {
+	return a.next.SupportsWebSockets()
+}
+
+// Unwrap returns the wrapped strategy
+func (a *Authenticator) Unwrap() LoadBalancerStrategy {
+	return a.next
+}
@@ -0,0 +1,170 @@
+package balancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// BackendHealth is the health of a backend as served by /api/backends
+type BackendHealth struct {
+	// ID identifies the backend in /api/backends/{id}/... requests
+	ID   string `json:"id"`
+	Pool string `json:"pool"`
+	URL  string `json:"url"`
+	// Weight is the backend's share of new sessions and requests, which
+	// /api/backends/{id}/weight changes
+	Weight int `json:"weight"`
+	// State is alive, dead or draining
+	State    string `json:"state"`
+	Override string `json:"override"`
+	// ErrorCount counts the failures in a row, Failures every failure since
+	// startup
+	ErrorCount  int32           `json:"errorCount"`
+	Failures    int64           `json:"failures"`
+	LastFailure *BackendFailure `json:"lastFailure,omitempty"`
+}
+
+// backendOverrides maps the actions of /api/backends/{id}/{action} to the
+// override they set
+var backendOverrides = map[string]BackendOverride{
+	"drain":  DrainOverride,
+	"enable": EnableOverride,
+	"auto":   NoOverride,
+}
+
+// poolBackend is a backend with its identifier
+type poolBackend struct {
+	id      string
+	pool    string
+	process *Process
+}
+
+// poolBackends lists the backends of every pool, identified by pool name
+// and position in the pool, sorted by pool
+func poolBackends(lb LoadBalancerStrategy) []poolBackend {
+	pools := namedPools(lb)
+	names := make([]string, 0, len(pools))
+	for name := range pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var backends []poolBackend
+	for _, name := range names {
+		for i, p := range strategyProcesses(ownStrategy(pools[name])) {
+			backends = append(backends, poolBackend{id: fmt.Sprintf("%s-%d", name, i), pool: name, process: p})
+		}
+	}
+	return backends
+}
+
+// GetBackendHealth returns the health of every backend
+func GetBackendHealth(lb LoadBalancerStrategy) []BackendHealth {
+	backends := poolBackends(lb)
+	weights := strategyWeights(lb)
+	health := make([]BackendHealth, 0, len(backends))
+	for _, backend := range backends {
+		p := backend.process
+		state := "alive"
+		if !p.IsAlive() {
+			state = "dead"
+		} else if p.IsDraining() {
+			state = "draining"
+		}
+
+		health = append(health, BackendHealth{
+			ID:          backend.id,
+			Pool:        backend.pool,
+			URL:         p.URL.Redacted(),
+			Weight:      weights[p],
+			State:       state,
+			Override:    p.GetOverride().String(),
+			ErrorCount:  atomic.LoadInt32(&p.ErrorCount),
+			Failures:    p.GetFailures(),
+			LastFailure: p.LastFailure(),
+		})
+	}
+	return health
+}
+
+// BackendsHandler serves the health of every backend
+func BackendsHandler(lb LoadBalancerStrategy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(GetBackendHealth(lb))
+	}
+}
+
+// BackendOverrideHandler lets operators override the health of a backend:
+// POST /api/backends/{id}/drain takes it out of rotation, /enable puts it
+// back even if health checks marked it dead, and /auto hands it back to
+// health checks. Overrides stick until changed. POST
+// /api/backends/{id}/weight with weight=<N> reweights the backend.
+func BackendOverrideHandler(lb LoadBalancerStrategy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/backends/"), "/")
+		override, ok := backendOverrides[action]
+		if !ok && action != "weight" {
+			http.Error(w, "action must be drain, enable, auto or weight", http.StatusNotFound)
+			return
+		}
+
+		var target *Process
+		for _, backend := range poolBackends(lb) {
+			if backend.id == id {
+				target = backend.process
+				break
+			}
+		}
+		if target == nil {
+			apiError(w, fmt.Errorf("%w: %s", ErrBackendNotFound, id))
+			return
+		}
+
+		if action == "weight" {
+			weight, err := strconv.Atoi(r.FormValue("weight"))
+			if err != nil || weight < 1 {
+				http.Error(w, "weight must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			if !setBackendWeight(lb, target, weight) {
+				http.Error(w, "The backend's pool does not support weights", http.StatusBadRequest)
+				return
+			}
+			logger.Admin.Info("Backend reweighted through the admin API",
+				zap.String("backend", target.URL.Redacted()),
+				zap.Int("weight", weight))
+		} else {
+			target.SetOverride(override)
+			logger.Admin.Info("Backend health overridden through the admin API",
+				zap.String("backend", target.URL.Redacted()),
+				zap.String("override", override.String()))
+		}
+
+		for _, health := range GetBackendHealth(lb) {
+			if health.ID == id {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(health)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package balancer
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// backendTransport is the connection pool shared by every proxy to the backends
+var backendTransport = newBackendTransport()
+
+// newBackendTransport creates a connection pool to the backends that counts
+// the TLS parameters negotiated with them
+func newBackendTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{VerifyConnection: countBackendTLS}
+	return transport
+}
+
+// serverNameTransports holds one connection pool per TLS server name that a
+// backend overrides, since the server name is a setting of the transport
+var serverNameTransports sync.Map
+
+// addressTransports holds the connection pool of each backend dialing the
+// addresses its host name last resolved to, keyed by backend
+var addressTransports sync.Map
+
+// transportFor returns the transport used to reach a backend
+func transportFor(p *Process) http.RoundTripper {
+	if p.transport != nil {
+		return p.transport
+	}
+	if transport, ok := addressTransports.Load(p); ok {
+		return transport.(*http.Transport)
+	}
+	return baseTransportFor(p)
+}
+
+// baseTransportFor returns the shared connection pool of a backend, the
+// one of its TLS server name if it overrides it
+func baseTransportFor(p *Process) *http.Transport {
+	if p.ServerName == "" {
+		return backendTransport
+	}
+
+	if transport, ok := serverNameTransports.Load(p.ServerName); ok {
+		return transport.(*http.Transport)
+	}
+
+	transport := backendTransport.Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.ServerName = p.ServerName
+	actual, _ := serverNameTransports.LoadOrStore(p.ServerName, transport)
+	return actual.(*http.Transport)
+}
+
+// closeIdleBackendConnections closes the idle connections of every backend
+// connection pool
+func closeIdleBackendConnections() {
+	backendTransport.CloseIdleConnections()
+	serverNameTransports.Range(func(_, transport interface{}) bool {
+		transport.(*http.Transport).CloseIdleConnections()
+		return true
+	})
+	addressTransports.Range(func(_, transport interface{}) bool {
+		transport.(*http.Transport).CloseIdleConnections()
+		return true
+	})
+}
+
+// probeBackend sends a HEAD request for path to a backend, with the Host
+// header and TLS server name the backend is configured with
+func probeBackend(p *Process, path string, timeout time.Duration) (*http.Response, error) {
+	return probeBackendContext(context.Background(), p, path, timeout)
+}
+
+// probeBackendContext is probeBackend with a context, such as one tracing
+// the connection of the probe
+func probeBackendContext(ctx context.Context, p *Process, path string, timeout time.Duration) (*http.Response, error) {
+	target := *p.URL
+	target.Path = path
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.Host != "" {
+		req.Host = p.Host
+	}
+
+	client := &http.Client{
+		Transport: transportFor(p),
+		Timeout:   timeout,
+	}
+	resp, err := client.Do(req)
+	// A probe answered with a server error does not show the backend is back
+	if err == nil && resp.StatusCode < http.StatusInternalServerError {
+		p.markServed()
+	}
+	return resp, err
+}
+
+// backendDirector wraps a reverse proxy director so requests carry the Host
+// header a backend is configured with instead of the client's, and its path
+// prefix
+func backendDirector(director func(*http.Request), p *Process) func(*http.Request) {
+	if p.Host == "" && p.Prefix == "" {
+		return director
+	}
+	return func(req *http.Request) {
+		if p.Prefix != "" {
+			req.URL.Path = prefixPath(p.Prefix, req.URL.Path)
+			if req.URL.RawPath != "" {
+				req.URL.RawPath = prefixPath(p.Prefix, req.URL.RawPath)
+			}
+		}
+		director(req)
+		if p.Host != "" {
+			req.Host = p.Host
+		}
+	}
+}
+
+// prefixPath puts a backend's path prefix in front of a request path
+func prefixPath(prefix, path string) string {
+	if path == "" {
+		return prefix + "/"
+	}
+	return prefix + path
+}
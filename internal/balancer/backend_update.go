@@ -0,0 +1,132 @@
+package balancer
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// mergeBackends builds the next generation of a pool's backends from the
+// current one and freshly parsed ones. Backends already in the pool are kept
+// with their health and counters and take their new settings; new backends
+// are registered and the ones left out are deregistered, so they are no
+// longer revived, and drained: the requests and WebSockets in flight to them
+// go on until they finish or drain runs out. It returns the new generation,
+// the kept backends and the removed ones.
+func mergeBackends(current, fresh []*Process, drain time.Duration) (processes, kept, removed []*Process) {
+	previous := make(map[string]*Process, len(current))
+	for _, p := range current {
+		previous[p.URL.String()] = p
+	}
+
+	processes = make([]*Process, 0, len(fresh))
+	for _, p := range fresh {
+		if old, ok := previous[p.URL.String()]; ok {
+			old.Weight = p.Weight
+			old.Zone = p.Zone
+			old.Region = p.Region
+			old.Priority = p.Priority
+			atomic.StoreInt32(&old.MaxConns, p.MaxConns)
+			atomic.StoreInt32(&old.MaxWebSockets, p.MaxWebSockets)
+			delete(previous, p.URL.String())
+
+			kept = append(kept, old)
+			processes = append(processes, old)
+			continue
+		}
+		recordHealthEvent(p, "registered")
+		processes = append(processes, p)
+	}
+
+	// Kept in pool order, so the outcome does not depend on map order
+	for _, p := range current {
+		if previous[p.URL.String()] == p {
+			p.deregister()
+			drainRemoved(p, drain)
+			removed = append(removed, p)
+		}
+	}
+	return processes, kept, removed
+}
+
+// weightSetter is implemented by pools whose backends can be reweighted
+// while they serve
+type weightSetter interface {
+	SetWeight(p *Process, weight int)
+}
+
+// weightReader is implemented by pools whose backends can be reweighted
+// while they serve, to read the weights under the lock they change under
+type weightReader interface {
+	Weights() map[*Process]int
+}
+
+// processWeights returns the weights of backends that are not reweighted
+// while they serve
+func processWeights(processes []*Process) map[*Process]int {
+	weights := make(map[*Process]int, len(processes))
+	for _, p := range processes {
+		weights[p] = p.Weight
+	}
+	return weights
+}
+
+// strategyWeights returns the weights of the backends behind a strategy,
+// read under the locks of the pools they are reweighted in
+func strategyWeights(lb LoadBalancerStrategy) map[*Process]int {
+	weights := make(map[*Process]int)
+	walkBalancers(lb, func(balancer interface{}) {
+		for p, weight := range balancerWeights(balancer) {
+			weights[p] = weight
+		}
+	})
+	return weights
+}
+
+// balancerWeights returns the weights of the backends of a balancer visited
+// by walkBalancers
+func balancerWeights(balancer interface{}) map[*Process]int {
+	switch typed := balancer.(type) {
+	case *SessionPersistenceBalancer:
+		return typed.backendWeights()
+	case weightReader:
+		return typed.Weights()
+	}
+	return processWeights(balancerProcesses(balancer))
+}
+
+// backendUpdater is implemented by pools whose backends can be swapped
+// while they serve
+type backendUpdater interface {
+	UpdateBackends(configs []BackendConfig) uint64
+}
+
+// setBackendWeight changes the weight of a backend in the pools holding it
+// and reports whether any of them could be reweighted
+func setBackendWeight(lb LoadBalancerStrategy, target *Process, weight int) bool {
+	changed := false
+	walkBalancers(lb, func(balancer interface{}) {
+		// Persistence shares the backends of the pool picking new sessions
+		spb, _ := balancer.(*SessionPersistenceBalancer)
+		if spb != nil {
+			balancer = spb.BaseLB
+		}
+		setter, ok := balancer.(weightSetter)
+		if !ok {
+			return
+		}
+		for _, p := range balancerProcesses(balancer) {
+			if p != target {
+				continue
+			}
+			setter.SetWeight(p, weight)
+			changed = true
+
+			// A node takes one replica per unit of weight on hash rings
+			if spb != nil && spb.ConsistentHashRing.RemoveNode(p) {
+				spb.ConsistentHashRing.AddNode(p)
+			}
+			return
+		}
+	})
+	return changed
+}
@@ -0,0 +1,321 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// defaultBlockBan is how long a client exceeding a block rate stays blocked
+// when the rule sets no ban
+const defaultBlockBan = time.Minute
+
+// scannerPaths are paths probed by vulnerability scanners that no backend
+// should serve, blocked by the scanner_paths rule
+var scannerPaths = []string{
+	"/.env",
+	"/.git",
+	"/.svn",
+	"/.aws",
+	"/.ssh",
+	"/.DS_Store",
+	"/.htaccess",
+	"/.htpasswd",
+	"/wp-admin",
+	"/wp-login.php",
+	"/xmlrpc.php",
+	"/phpmyadmin",
+	"/pma",
+	"/cgi-bin",
+	"/server-status",
+	"/config.php",
+	"/vendor/phpunit",
+	"/boaform",
+}
+
+// BlockRule is a rule of the bot and scanner filter
+type BlockRule struct {
+	// Kind is user_agent, path, scanner_paths or rate
+	Kind string
+	// Pattern is the User-Agent regular expression or the path of the rule
+	Pattern string
+	// Drop closes the connection without a response instead of answering 403
+	Drop bool
+	// Rate and Burst bound the requests of a client under a rate rule, and
+	// Ban is how long a client exceeding them is blocked
+	Rate  float64
+	Burst int
+	Ban   time.Duration
+
+	userAgent *regexp.Regexp
+}
+
+// String names the rule in the block counts
+func (rule BlockRule) String() string {
+	if rule.Pattern == "" {
+		return rule.Kind
+	}
+	return rule.Kind + " " + rule.Pattern
+}
+
+// parseBlockRule parses a block directive. The pattern of a user_agent rule
+// is quoted if it holds spaces.
+func parseBlockRule(line string) (BlockRule, error) {
+	parts, err := quotedFields(strings.TrimSuffix(line, ";"))
+	if err != nil {
+		return BlockRule{}, err
+	}
+	if len(parts) < 2 {
+		return BlockRule{}, fmt.Errorf("block directive requires a rule")
+	}
+
+	rule := BlockRule{Kind: parts[1]}
+	options := parts[2:]
+	switch rule.Kind {
+	case "user_agent", "path":
+		if len(parts) < 3 {
+			return BlockRule{}, fmt.Errorf("block %s requires a pattern", rule.Kind)
+		}
+		rule.Pattern, options = parts[2], parts[3:]
+		if rule.Kind == "user_agent" {
+			if rule.userAgent, err = regexp.Compile("(?i)" + rule.Pattern); err != nil {
+				return BlockRule{}, fmt.Errorf("invalid user_agent pattern: %v", err)
+			}
+		} else if !strings.HasPrefix(rule.Pattern, "/") {
+			return BlockRule{}, fmt.Errorf("invalid block path, expected an absolute path: %s", rule.Pattern)
+		}
+	case "scanner_paths":
+	case "rate":
+		if len(parts) < 3 {
+			return BlockRule{}, fmt.Errorf("block rate requires a rate")
+		}
+		if rule.Rate, err = parseRate(parts[2]); err != nil {
+			return BlockRule{}, err
+		}
+		rule.Pattern, options = parts[2], parts[3:]
+		rule.Ban = defaultBlockBan
+	default:
+		return BlockRule{}, fmt.Errorf("unknown block rule: %s", rule.Kind)
+	}
+
+	for _, option := range options {
+		switch {
+		case option == "drop":
+			rule.Drop = true
+		case strings.HasPrefix(option, "burst=") && rule.Kind == "rate":
+			burst, err := strconv.Atoi(strings.TrimPrefix(option, "burst="))
+			if err != nil || burst <= 0 {
+				return BlockRule{}, fmt.Errorf("invalid burst: %s", strings.TrimPrefix(option, "burst="))
+			}
+			rule.Burst = burst
+		case strings.HasPrefix(option, "ban=") && rule.Kind == "rate":
+			ban, err := time.ParseDuration(strings.TrimPrefix(option, "ban="))
+			if err != nil || ban <= 0 {
+				return BlockRule{}, fmt.Errorf("invalid ban: %s", strings.TrimPrefix(option, "ban="))
+			}
+			rule.Ban = ban
+		default:
+			return BlockRule{}, fmt.Errorf("unknown block option: %s", option)
+		}
+	}
+
+	return rule, nil
+}
+
+// matchesPath reports whether a path is a blocked path or below it. A
+// pattern ending in * matches any path it starts.
+func matchesPath(pattern, requestPath string) bool {
+	pattern, requestPath = strings.ToLower(pattern), strings.ToLower(requestPath)
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(requestPath, prefix)
+	}
+	return requestPath == pattern || strings.HasPrefix(requestPath, strings.TrimSuffix(pattern, "/")+"/")
+}
+
+// matches reports whether a request matches a user agent or path rule
+func (rule BlockRule) matches(r *http.Request, cleanPath string) bool {
+	switch rule.Kind {
+	case "user_agent":
+		return rule.userAgent.MatchString(r.UserAgent())
+	case "path":
+		return matchesPath(rule.Pattern, cleanPath)
+	case "scanner_paths":
+		for _, scanner := range scannerPaths {
+			if matchesPath(scanner, cleanPath) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var (
+	blockCounts   = make(map[string]int64)
+	blockCountsMu sync.Mutex
+)
+
+func countBlock(rule BlockRule) {
+	blockCountsMu.Lock()
+	blockCounts[rule.String()]++
+	blockCountsMu.Unlock()
+}
+
+// GetBlockCounts returns the number of requests blocked per rule
+func GetBlockCounts() map[string]int64 {
+	blockCountsMu.Lock()
+	defer blockCountsMu.Unlock()
+
+	counts := make(map[string]int64, len(blockCounts))
+	for rule, count := range blockCounts {
+		counts[rule] = count
+	}
+	return counts
+}
+
+// rateBlock tracks the clients of a rate rule and the ones it banned
+type rateBlock struct {
+	rule    BlockRule
+	buckets *bucketSet
+	mu      sync.Mutex
+	banned  map[string]time.Time
+}
+
+// exceeded reports whether a client is banned, banning it if it exceeds
+// the rate
+func (rb *rateBlock) exceeded(client string) bool {
+	now := time.Now()
+
+	rb.mu.Lock()
+	until, ok := rb.banned[client]
+	if ok && now.After(until) {
+		delete(rb.banned, client)
+		ok = false
+	}
+	rb.mu.Unlock()
+	if ok {
+		return true
+	}
+
+	if allowed, _, _ := rb.buckets.take(client); allowed {
+		return false
+	}
+
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	// Forget expired bans while adding one
+	for banned, until := range rb.banned {
+		if now.After(until) {
+			delete(rb.banned, banned)
+		}
+	}
+	rb.banned[client] = now.Add(rb.rule.Ban)
+	logger.Log.Warn("Client banned for exceeding the block rate",
+		zap.String("client", client),
+		zap.String("rate", rb.rule.Pattern),
+		zap.Duration("ban", rb.rule.Ban))
+	return true
+}
+
+// BotFilter blocks bots and vulnerability scanners before a request reaches
+// anything else: requests whose User-Agent or path matches a rule, and
+// clients sending requests faster than a rate rule allows, which stay
+// blocked for the rule's ban. Blocked requests are answered 403 or, for
+// drop rules, their connection is closed without a response.
+type BotFilter struct {
+	next  LoadBalancerStrategy
+	rules []BlockRule
+	rates []*rateBlock
+}
+
+// NewBotFilter wraps a strategy with the bot and scanner filter.
+// The strategy is returned unchanged if there are no rules.
+func NewBotFilter(next LoadBalancerStrategy, rules []BlockRule) LoadBalancerStrategy {
+	if len(rules) == 0 {
+		return next
+	}
+
+	filter := &BotFilter{next: next}
+	for _, rule := range rules {
+		if rule.Kind == "rate" {
+			filter.rates = append(filter.rates, &rateBlock{
+				rule:    rule,
+				buckets: newBucketSet(rule.Rate, rule.Burst),
+				banned:  make(map[string]time.Time),
+			})
+		} else {
+			filter.rules = append(filter.rules, rule)
+		}
+	}
+	return filter
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (f *BotFilter) GetNextInstance(r *http.Request) (*url.URL, error) {
+	return f.next.GetNextInstance(r)
+}
+
+// ProxyRequest blocks the request if it matches a rule, and proxies it
+// otherwise
+func (f *BotFilter) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	cleanPath := path.Clean("/" + r.URL.Path)
+	for _, rule := range f.rules {
+		if rule.matches(r, cleanPath) {
+			f.block(w, r, rule)
+			return
+		}
+	}
+
+	client := getClientIP(r)
+	for _, rate := range f.rates {
+		if rate.exceeded(client) {
+			f.block(w, r, rate.rule)
+			return
+		}
+	}
+
+	f.next.ProxyRequest(w, r)
+}
+
+// block answers a blocked request with a 403, or closes its connection
+func (f *BotFilter) block(w http.ResponseWriter, r *http.Request, rule BlockRule) {
+	countBlock(rule)
+	logger.Log.Debug("Request blocked",
+		zap.String("rule", rule.String()),
+		zap.String("client", getClientIP(r)),
+		zap.String("path", r.URL.Path),
+		zap.String("user_agent", r.UserAgent()))
+
+	if !rule.Drop {
+		rejectRequest(w, RejectBlocked, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	countRejection(RejectBlocked)
+	if hijacker, ok := w.(http.Hijacker); ok {
+		if conn, _, err := hijacker.Hijack(); err == nil {
+			conn.Close()
+			return
+		}
+	}
+	// Resets the stream of HTTP/2 requests
+	panic(http.ErrAbortHandler)
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (f *BotFilter) SupportsWebSockets() bool {
+	return f.next.SupportsWebSockets()
+}
+
+// Unwrap returns the wrapped strategy
+func (f *BotFilter) Unwrap() LoadBalancerStrategy {
+	return f.next
+}
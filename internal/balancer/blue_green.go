@@ -0,0 +1,286 @@
+package balancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// blueSide and greenSide index the pools of a blue/green route
+	blueSide  = 0
+	greenSide = 1
+
+	// defaultProbation is how long a switch is watched for errors unless the
+	// switch request says otherwise
+	defaultProbation = time.Minute
+	// defaultMaxErrorRate is the percentage of 5xx responses during probation
+	// above which a switch is rolled back
+	defaultMaxErrorRate = 5.0
+	// minProbationRequests is how many requests the new pool must answer
+	// before its error rate can trigger a rollback
+	minProbationRequests = 10
+)
+
+var blueGreenColors = [2]string{"blue", "green"}
+
+// blueGreenProbation watches the error rate of the pool a route switched to
+type blueGreenProbation struct {
+	from, to     int32
+	until        time.Time
+	maxErrorRate float64
+	requests     int64
+	errors       int64
+}
+
+// BlueGreenStatus reports which pool a blue/green route sends traffic to
+type BlueGreenStatus struct {
+	Route  string `json:"route"`
+	Blue   string `json:"blue"`
+	Green  string `json:"green"`
+	Active string `json:"active"`
+	// ProbationUntil is set while a switch may still be rolled back
+	ProbationUntil *time.Time `json:"probationUntil,omitempty"`
+	// Requests and Errors are the responses of the new pool during probation
+	Requests int64 `json:"requests,omitempty"`
+	Errors   int64 `json:"errors,omitempty"`
+	// RolledBack is set when the last switch was rolled back
+	RolledBack bool `json:"rolledBack"`
+}
+
+// BlueGreenSwitch sends all of a route's traffic to one of two pools, blue
+// (the route's pool) or green, and lets the admin API switch between them
+// at once. A switch is on probation for a while: if the error rate of the
+// new pool exceeds a threshold, traffic goes back to the previous pool.
+type BlueGreenSwitch struct {
+	pools      [2]LoadBalancerStrategy
+	names      [2]string
+	route      string
+	active     int32
+	probation  atomic.Pointer[blueGreenProbation]
+	rolledBack atomic.Bool
+}
+
+// NewBlueGreenSwitch wraps a route's pool with a switch to a green pool.
+// The pool is returned unchanged if there is no green pool.
+func NewBlueGreenSwitch(blue, green LoadBalancerStrategy, route, bluePool, greenPool string) LoadBalancerStrategy {
+	if green == nil {
+		return blue
+	}
+	return &BlueGreenSwitch{
+		pools: [2]LoadBalancerStrategy{blue, green},
+		names: [2]string{bluePool, greenPool},
+		route: route,
+	}
+}
+
+// Switch sends the route's traffic to the blue or green pool, or to the
+// other one if to is empty, and puts the switch on probation
+func (bg *BlueGreenSwitch) Switch(to string, probation time.Duration, maxErrorRate float64) error {
+	for {
+		from := atomic.LoadInt32(&bg.active)
+		target := 1 - from
+		switch to {
+		case "":
+		case "blue":
+			target = blueSide
+		case "green":
+			target = greenSide
+		default:
+			return fmt.Errorf("to must be blue or green: %s", to)
+		}
+
+		if !atomic.CompareAndSwapInt32(&bg.active, from, target) {
+			continue
+		}
+
+		bg.rolledBack.Store(false)
+		if target == from || probation <= 0 {
+			bg.probation.Store(nil)
+		} else {
+			bg.probation.Store(&blueGreenProbation{
+				from:         from,
+				to:           target,
+				until:        time.Now().Add(probation),
+				maxErrorRate: maxErrorRate,
+			})
+		}
+
+		logger.Log.Info("Route switched pools",
+			zap.String("route", bg.route),
+			zap.String("from", bg.names[from]),
+			zap.String("to", bg.names[target]),
+			zap.Duration("probation", probation))
+		publishConfigChange("route", bg.route, bg.names[target])
+		return nil
+	}
+}
+
+// Status returns which pool the route sends traffic to
+func (bg *BlueGreenSwitch) Status() BlueGreenStatus {
+	status := BlueGreenStatus{
+		Route:      bg.route,
+		Blue:       bg.names[blueSide],
+		Green:      bg.names[greenSide],
+		Active:     blueGreenColors[atomic.LoadInt32(&bg.active)],
+		RolledBack: bg.rolledBack.Load(),
+	}
+	if p := bg.probation.Load(); p != nil && time.Now().Before(p.until) {
+		until := p.until
+		status.ProbationUntil = &until
+		status.Requests = atomic.LoadInt64(&p.requests)
+		status.Errors = atomic.LoadInt64(&p.errors)
+	}
+	return status
+}
+
+// record counts a response of the pool on probation and rolls the switch
+// back if the pool fails too often
+func (bg *BlueGreenSwitch) record(p *blueGreenProbation, status int) {
+	requests := atomic.AddInt64(&p.requests, 1)
+	errors := atomic.LoadInt64(&p.errors)
+	if status >= http.StatusInternalServerError {
+		errors = atomic.AddInt64(&p.errors, 1)
+	}
+
+	if requests < minProbationRequests || float64(errors)*100/float64(requests) <= p.maxErrorRate {
+		return
+	}
+	if !bg.probation.CompareAndSwap(p, nil) || !atomic.CompareAndSwapInt32(&bg.active, p.to, p.from) {
+		return
+	}
+
+	bg.rolledBack.Store(true)
+	logger.Log.Warn("Route switch rolled back after errors on probation",
+		zap.String("route", bg.route),
+		zap.String("from", bg.names[p.to]),
+		zap.String("to", bg.names[p.from]),
+		zap.Int64("requests", requests),
+		zap.Int64("errors", errors))
+	publishConfigChange("route", bg.route, bg.names[p.from])
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (bg *BlueGreenSwitch) GetNextInstance(r *http.Request) (*url.URL, error) {
+	return bg.pools[atomic.LoadInt32(&bg.active)].GetNextInstance(r)
+}
+
+// ProxyRequest proxies the request to the active pool
+func (bg *BlueGreenSwitch) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	active := atomic.LoadInt32(&bg.active)
+	annotateRoute(r, "", bg.names[active])
+
+	p := bg.probation.Load()
+	if p != nil && !time.Now().Before(p.until) {
+		// The new pool made it through probation
+		bg.probation.CompareAndSwap(p, nil)
+		p = nil
+	}
+	if p == nil || p.to != active {
+		bg.pools[active].ProxyRequest(w, r)
+		return
+	}
+
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	bg.pools[active].ProxyRequest(recorder, r)
+	bg.record(p, recorder.status)
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (bg *BlueGreenSwitch) SupportsWebSockets() bool {
+	return bg.pools[blueSide].SupportsWebSockets() && bg.pools[greenSide].SupportsWebSockets()
+}
+
+// Unwrap returns the blue pool
+func (bg *BlueGreenSwitch) Unwrap() LoadBalancerStrategy {
+	return bg.pools[blueSide]
+}
+
+// blueGreenSwitches returns the blue/green switches of a strategy's routes
+// by route name
+func blueGreenSwitches(lb LoadBalancerStrategy) map[string]*BlueGreenSwitch {
+	switches := make(map[string]*BlueGreenSwitch)
+	for _, router := range pathRouters(lb) {
+		for _, chain := range router.routeChains {
+			for chain != nil {
+				if bg, ok := chain.(*BlueGreenSwitch); ok {
+					switches[bg.route] = bg
+					break
+				}
+				wrapper, ok := chain.(strategyWrapper)
+				if !ok {
+					break
+				}
+				chain = wrapper.Unwrap()
+			}
+		}
+	}
+	return switches
+}
+
+// BlueGreenHandler reports the active pool of every blue/green route on GET
+// and switches a route on POST route=<route>[&to=blue|green]
+// [&probation=<duration>][&max_error_rate=<percent>]
+func BlueGreenHandler(lb LoadBalancerStrategy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switches := blueGreenSwitches(lb)
+
+		switch r.Method {
+		case http.MethodGet:
+			statuses := make([]BlueGreenStatus, 0, len(switches))
+			for _, bg := range switches {
+				statuses = append(statuses, bg.Status())
+			}
+			sort.Slice(statuses, func(i, j int) bool { return statuses[i].Route < statuses[j].Route })
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(statuses)
+			return
+		case http.MethodPost:
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		route := r.FormValue("route")
+		bg, ok := switches[route]
+		if !ok {
+			apiError(w, fmt.Errorf("%w: %s", ErrRouteNotFound, route))
+			return
+		}
+
+		probation := defaultProbation
+		if value := r.FormValue("probation"); value != "" {
+			var err error
+			if probation, err = time.ParseDuration(value); err != nil || probation < 0 {
+				http.Error(w, "invalid probation: "+value, http.StatusBadRequest)
+				return
+			}
+		}
+		maxErrorRate := defaultMaxErrorRate
+		if value := r.FormValue("max_error_rate"); value != "" {
+			var err error
+			maxErrorRate, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+			if err != nil || maxErrorRate < 0 || maxErrorRate > 100 {
+				http.Error(w, "invalid max_error_rate: "+value, http.StatusBadRequest)
+				return
+			}
+		}
+
+		if err := bg.Switch(r.FormValue("to"), probation, maxErrorRate); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(bg.Status())
+	}
+}
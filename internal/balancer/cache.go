@@ -0,0 +1,475 @@
+package balancer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheHeader tells clients whether a response came from the cache
+const CacheHeader = "X-Cache"
+
+// CacheConfig holds the settings of a named cache zone
+type CacheConfig struct {
+	Name string
+	// TTL applies to responses without a max-age or s-maxage
+	TTL     time.Duration
+	MaxSize int64
+	// Dir keeps the responses on disk instead of in memory, if set
+	Dir string
+}
+
+// parseByteSize parses a size such as 512KB, 256MB or 1GB
+func parseByteSize(value string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+
+	upper := strings.ToUpper(value)
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper = strings.TrimSuffix(upper, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	size, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid size: %s", value)
+	}
+	return size * multiplier, nil
+}
+
+// parseCacheZone parses a cache directive defining a named cache zone
+func parseCacheZone(parts []string) (CacheConfig, error) {
+	if len(parts) < 2 {
+		return CacheConfig{}, fmt.Errorf("cache directive requires a name")
+	}
+
+	config := CacheConfig{Name: parts[1], TTL: time.Minute, MaxSize: 64 << 20}
+
+	for i := 2; i < len(parts); i++ {
+		if strings.HasPrefix(parts[i], "ttl=") {
+			ttlStr := strings.TrimPrefix(parts[i], "ttl=")
+			ttl, err := time.ParseDuration(ttlStr)
+			if err != nil || ttl <= 0 {
+				return CacheConfig{}, fmt.Errorf("invalid cache ttl: %s", ttlStr)
+			}
+			config.TTL = ttl
+		} else if strings.HasPrefix(parts[i], "max_size=") {
+			size, err := parseByteSize(strings.TrimPrefix(parts[i], "max_size="))
+			if err != nil {
+				return CacheConfig{}, fmt.Errorf("invalid cache max_size: %v", err)
+			}
+			config.MaxSize = size
+		} else if strings.HasPrefix(parts[i], "dir=") {
+			config.Dir = strings.TrimPrefix(parts[i], "dir=")
+		}
+	}
+
+	return config, nil
+}
+
+// cacheZone is a cache shared by the routes it is attached to
+type cacheZone struct {
+	config      CacheConfig
+	store       CacheStore
+	hits        int64
+	misses      int64
+	revalidated int64
+}
+
+var (
+	cacheZones   = make(map[string]*cacheZone)
+	cacheZonesMu sync.Mutex
+)
+
+// getCacheZone returns the zone with the given settings, creating it on first
+// use. Zones are kept across router rebuilds unless their settings change.
+func getCacheZone(config CacheConfig) (*cacheZone, error) {
+	cacheZonesMu.Lock()
+	defer cacheZonesMu.Unlock()
+
+	if zone, ok := cacheZones[config.Name]; ok && zone.config == config {
+		return zone, nil
+	}
+
+	var store CacheStore
+	if config.Dir != "" {
+		var err error
+		if store, err = NewDiskCacheStore(config.Dir, config.MaxSize); err != nil {
+			return nil, err
+		}
+	} else {
+		store = NewMemoryCacheStore(config.MaxSize)
+	}
+
+	zone := &cacheZone{config: config, store: store}
+	cacheZones[config.Name] = zone
+	return zone, nil
+}
+
+// CacheStats holds the counters and usage of a cache zone
+type CacheStats struct {
+	Hits        int64 `json:"hits"`
+	Misses      int64 `json:"misses"`
+	Revalidated int64 `json:"revalidated"`
+	Entries     int   `json:"entries"`
+	Size        int64 `json:"size"`
+}
+
+// GetCacheStats returns the statistics of each cache zone
+func GetCacheStats() map[string]CacheStats {
+	cacheZonesMu.Lock()
+	defer cacheZonesMu.Unlock()
+
+	stats := make(map[string]CacheStats, len(cacheZones))
+	for name, zone := range cacheZones {
+		entries, size := zone.store.Len()
+		stats[name] = CacheStats{
+			Hits:        atomic.LoadInt64(&zone.hits),
+			Misses:      atomic.LoadInt64(&zone.misses),
+			Revalidated: atomic.LoadInt64(&zone.revalidated),
+			Entries:     entries,
+			Size:        size,
+		}
+	}
+	return stats
+}
+
+// PurgeCache removes the cached responses whose path starts with prefix from
+// every zone and returns how many were removed
+func PurgeCache(prefix string) int {
+	cacheZonesMu.Lock()
+	defer cacheZonesMu.Unlock()
+
+	purged := 0
+	for _, zone := range cacheZones {
+		purged += zone.store.Purge(prefix)
+	}
+	return purged
+}
+
+// CachePurgeHandler purges cached responses by path prefix:
+// POST prefix=<path prefix>
+func CachePurgeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		prefix := r.FormValue("prefix")
+		if !strings.HasPrefix(prefix, "/") {
+			http.Error(w, "prefix must be a path starting with /", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"prefix": prefix,
+			"purged": PurgeCache(prefix),
+		})
+	}
+}
+
+// parseCacheControl returns the directives of a Cache-Control header
+func parseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name != "" {
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return directives
+}
+
+// ResponseCache serves GET responses from a cache zone, honoring
+// Cache-Control and revalidating stale responses with their ETag or
+// Last-Modified validators
+type ResponseCache struct {
+	next LoadBalancerStrategy
+	zone *cacheZone
+}
+
+// NewResponseCache wraps a strategy with the response cache of a zone.
+// The strategy is returned unchanged if no zone is configured.
+func NewResponseCache(next LoadBalancerStrategy, config CacheConfig) (LoadBalancerStrategy, error) {
+	if config.Name == "" {
+		return next, nil
+	}
+
+	zone, err := getCacheZone(config)
+	if err != nil {
+		return nil, err
+	}
+	return &ResponseCache{
+		next: next,
+		zone: zone,
+	}, nil
+}
+
+func cacheKey(r *http.Request) string {
+	return r.Host + r.URL.RequestURI()
+}
+
+// matchesVary reports whether a request has the header values the cached
+// response was varied on
+func (c *CachedResponse) matchesVary(r *http.Request) bool {
+	for name, value := range c.Vary {
+		if r.Header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (rc *ResponseCache) GetNextInstance(r *http.Request) (*url.URL, error) {
+	return rc.next.GetNextInstance(r)
+}
+
+// ProxyRequest serves the request from the cache or proxies it, storing
+// cacheable responses
+func (rc *ResponseCache) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	requestControl := parseCacheControl(r.Header.Get("Cache-Control"))
+	_, noStore := requestControl["no-store"]
+
+	// Responses to authorized requests are private to the client
+	if r.Method != http.MethodGet || IsWebSocketRequest(r) || noStore || r.Header.Get("Authorization") != "" {
+		rc.next.ProxyRequest(w, r)
+		return
+	}
+
+	key := cacheKey(r)
+	cached, found := rc.zone.store.Get(key)
+	if found && !cached.matchesVary(r) {
+		found = false
+	}
+
+	_, noCache := requestControl["no-cache"]
+	if found && !noCache && time.Now().Before(cached.Expires) {
+		atomic.AddInt64(&rc.zone.hits, 1)
+		serveCachedResponse(w, r, cached, "HIT")
+		return
+	}
+
+	// Stale responses with validators are revalidated instead of refetched
+	upstream := r
+	etag, lastModified := "", ""
+	if found {
+		etag, lastModified = cached.Header.Get("ETag"), cached.Header.Get("Last-Modified")
+	}
+	revalidating := etag != "" || lastModified != ""
+	if revalidating {
+		upstream = r.Clone(r.Context())
+		upstream.Header.Del("If-None-Match")
+		upstream.Header.Del("If-Modified-Since")
+		if etag != "" {
+			upstream.Header.Set("If-None-Match", etag)
+		}
+		if lastModified != "" {
+			upstream.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	cw := &cacheWriter{
+		ResponseWriter: w,
+		header:         make(http.Header),
+		revalidating:   revalidating,
+		limit:          rc.zone.config.MaxSize / 10,
+	}
+	rc.next.ProxyRequest(cw, upstream)
+
+	if cw.notModified {
+		atomic.AddInt64(&rc.zone.revalidated, 1)
+		refreshed := *cached
+		refreshed.Stored = time.Now()
+		refreshed.Expires = refreshed.Stored.Add(rc.ttl(cw.header))
+		rc.zone.store.Set(key, &refreshed)
+		serveCachedResponse(w, r, &refreshed, "REVALIDATED")
+		return
+	}
+
+	atomic.AddInt64(&rc.zone.misses, 1)
+	if response := rc.cacheableResponse(r, cw); response != nil {
+		rc.zone.store.Set(key, response)
+	}
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (rc *ResponseCache) SupportsWebSockets() bool {
+	return rc.next.SupportsWebSockets()
+}
+
+// Unwrap returns the wrapped strategy
+func (rc *ResponseCache) Unwrap() LoadBalancerStrategy {
+	return rc.next
+}
+
+// ttl returns how long a response may be served from the cache, preferring
+// its s-maxage and max-age over the zone's TTL
+func (rc *ResponseCache) ttl(header http.Header) time.Duration {
+	control := parseCacheControl(header.Get("Cache-Control"))
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if value, ok := control[directive]; ok {
+			if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+				return time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return rc.zone.config.TTL
+}
+
+// cacheableResponse returns the response written through cw for storing, or
+// nil if it must not be cached
+func (rc *ResponseCache) cacheableResponse(r *http.Request, cw *cacheWriter) *CachedResponse {
+	if cw.status != http.StatusOK || cw.overflow || cw.header.Get("Set-Cookie") != "" {
+		return nil
+	}
+
+	control := parseCacheControl(cw.header.Get("Cache-Control"))
+	for _, directive := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := control[directive]; ok {
+			return nil
+		}
+	}
+
+	ttl := rc.ttl(cw.header)
+	if ttl <= 0 {
+		return nil
+	}
+
+	vary := make(map[string]string)
+	for _, value := range cw.header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return nil
+			}
+			if name != "" {
+				vary[name] = r.Header.Get(name)
+			}
+		}
+	}
+
+	header := cw.header.Clone()
+	header.Del(CacheHeader)
+
+	now := time.Now()
+	return &CachedResponse{
+		Status:  cw.status,
+		Header:  header,
+		Body:    bytes.Clone(cw.body.Bytes()),
+		Path:    r.URL.Path,
+		Vary:    vary,
+		Stored:  now,
+		Expires: now.Add(ttl),
+	}
+}
+
+// serveCachedResponse writes a cached response, answering conditional
+// requests that match it with 304 Not Modified
+func serveCachedResponse(w http.ResponseWriter, r *http.Request, cached *CachedResponse, state string) {
+	header := w.Header()
+	for name, values := range cached.Header {
+		header[name] = append([]string(nil), values...)
+	}
+	header.Set(CacheHeader, state)
+	header.Set("Age", strconv.Itoa(int(time.Since(cached.Stored).Seconds())))
+
+	if etag := cached.Header.Get("ETag"); etag != "" && r.Header.Get("If-None-Match") == etag {
+		header.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(cached.Status)
+	w.Write(cached.Body)
+}
+
+// cacheWriter passes a response through to the client while keeping a copy
+// of it for the cache. A 304 answering the cache's own revalidation is kept
+// from the client, which gets the refreshed cached response instead.
+type cacheWriter struct {
+	http.ResponseWriter
+	header       http.Header
+	revalidating bool
+	limit        int64
+	status       int
+	wroteHeader  bool
+	notModified  bool
+	body         bytes.Buffer
+	overflow     bool
+}
+
+func (cw *cacheWriter) Header() http.Header {
+	return cw.header
+}
+
+func (cw *cacheWriter) WriteHeader(statusCode int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = statusCode
+
+	if cw.revalidating && statusCode == http.StatusNotModified {
+		cw.notModified = true
+		return
+	}
+
+	header := cw.ResponseWriter.Header()
+	for name, values := range cw.header {
+		header[name] = values
+	}
+	header.Set(CacheHeader, "MISS")
+	cw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (cw *cacheWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.notModified {
+		return len(b), nil
+	}
+
+	if !cw.overflow {
+		if int64(cw.body.Len()+len(b)) > cw.limit {
+			cw.overflow = true
+			cw.body = bytes.Buffer{}
+		} else {
+			cw.body.Write(b)
+		}
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *cacheWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.notModified {
+		return
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,226 @@
+package balancer
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// CachedResponse is a response stored by the response cache
+type CachedResponse struct {
+	Status  int
+	Header  http.Header
+	Body    []byte
+	Path    string
+	Vary    map[string]string
+	Stored  time.Time
+	Expires time.Time
+}
+
+// size estimates the memory or disk space taken by the response
+func (c *CachedResponse) size() int64 {
+	size := int64(len(c.Body) + len(c.Path))
+	for name, values := range c.Header {
+		size += int64(len(name))
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+	return size
+}
+
+// CacheStore holds the responses of a cache zone
+type CacheStore interface {
+	// Get returns the response stored under key
+	Get(key string) (*CachedResponse, bool)
+	// Set stores a response under key, evicting others if the store is full
+	Set(key string, resp *CachedResponse)
+	// Purge removes the responses whose path starts with prefix and returns
+	// how many were removed
+	Purge(prefix string) int
+	// Len returns the number of stored responses and their total size
+	Len() (int, int64)
+}
+
+type cacheItem struct {
+	key  string
+	path string
+	size int64
+	// resp is kept in memory unless the store writes to disk
+	resp *CachedResponse
+}
+
+// lruCacheStore is a CacheStore bounded by size that evicts the least
+// recently used responses. Responses are kept in memory, or written to files
+// in dir if it is set so large caches do not take memory.
+type lruCacheStore struct {
+	dir     string
+	maxSize int64
+	mu      sync.Mutex
+	items   map[string]*list.Element
+	lru     *list.List
+	size    int64
+}
+
+// NewMemoryCacheStore creates a store keeping up to maxSize bytes in memory
+func NewMemoryCacheStore(maxSize int64) CacheStore {
+	return &lruCacheStore{
+		maxSize: maxSize,
+		items:   make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// NewDiskCacheStore creates a store keeping up to maxSize bytes in files
+// under dir. Files left over from a previous run are removed, since the index
+// of the store is only kept in memory.
+func NewDiskCacheStore(dir string, maxSize int64) (CacheStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	for _, pattern := range []string{"*.cache", "*.tmp"} {
+		stale, _ := filepath.Glob(filepath.Join(dir, pattern))
+		for _, file := range stale {
+			os.Remove(file)
+		}
+	}
+
+	return &lruCacheStore{
+		dir:     dir,
+		maxSize: maxSize,
+		items:   make(map[string]*list.Element),
+		lru:     list.New(),
+	}, nil
+}
+
+func (s *lruCacheStore) filename(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".cache")
+}
+
+// Get implements the CacheStore interface
+func (s *lruCacheStore) Get(key string) (*CachedResponse, bool) {
+	s.mu.Lock()
+	element, ok := s.items[key]
+	if !ok {
+		s.mu.Unlock()
+		return nil, false
+	}
+	s.lru.MoveToFront(element)
+	item := element.Value.(*cacheItem)
+	s.mu.Unlock()
+
+	if s.dir == "" {
+		return item.resp, true
+	}
+
+	data, err := os.ReadFile(s.filename(key))
+	if err != nil {
+		return nil, false
+	}
+	var resp CachedResponse
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&resp); err != nil {
+		return nil, false
+	}
+	return &resp, true
+}
+
+// Set implements the CacheStore interface
+func (s *lruCacheStore) Set(key string, resp *CachedResponse) {
+	item := &cacheItem{key: key, path: resp.Path, size: resp.size()}
+	if item.size > s.maxSize {
+		return
+	}
+
+	if s.dir == "" {
+		item.resp = resp
+	} else {
+		var data bytes.Buffer
+		if err := gob.NewEncoder(&data).Encode(resp); err != nil {
+			return
+		}
+		item.size = int64(data.Len())
+
+		// Write to a temporary file first so readers never see a partial entry
+		tmp, err := os.CreateTemp(s.dir, "*.tmp")
+		if err != nil {
+			logger.Log.Warn("Failed to write cache entry", zap.Error(err))
+			return
+		}
+		_, err = tmp.Write(data.Bytes())
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), s.filename(key))
+		}
+		if err != nil {
+			logger.Log.Warn("Failed to write cache entry", zap.Error(err))
+			os.Remove(tmp.Name())
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.items[key]; ok {
+		s.size -= element.Value.(*cacheItem).size
+		s.lru.Remove(element)
+	}
+	s.items[key] = s.lru.PushFront(item)
+	s.size += item.size
+
+	for s.size > s.maxSize {
+		s.removeLocked(s.lru.Back())
+	}
+}
+
+// removeLocked removes an item; the caller holds the lock
+func (s *lruCacheStore) removeLocked(element *list.Element) {
+	item := element.Value.(*cacheItem)
+	s.lru.Remove(element)
+	delete(s.items, item.key)
+	s.size -= item.size
+
+	if s.dir != "" {
+		os.Remove(s.filename(item.key))
+	}
+}
+
+// Purge implements the CacheStore interface
+func (s *lruCacheStore) Purge(prefix string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := 0
+	for element := s.lru.Front(); element != nil; {
+		next := element.Next()
+		if strings.HasPrefix(element.Value.(*cacheItem).path, prefix) {
+			s.removeLocked(element)
+			purged++
+		}
+		element = next
+	}
+	return purged
+}
+
+// Len implements the CacheStore interface
+func (s *lruCacheStore) Len() (int, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.items), s.size
+}
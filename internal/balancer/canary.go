@@ -0,0 +1,172 @@
+package balancer
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CanaryConfig sends a stable share of a route's users to a canary pool
+type CanaryConfig struct {
+	Pool    string
+	Percent float64
+	// Key identifies a user: client_ip, header:<name> or cookie:<name>
+	Key string
+}
+
+// parseCanary parses a canary=<pool>:<percent> route option
+func parseCanary(value string) (CanaryConfig, error) {
+	pool, percentStr, found := strings.Cut(value, ":")
+	if !found || pool == "" {
+		return CanaryConfig{}, fmt.Errorf("canary requires a pool and a percentage: %s", value)
+	}
+
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(percentStr, "%"), 64)
+	if err != nil || percent < 0 || percent > 100 {
+		return CanaryConfig{}, fmt.Errorf("invalid canary percentage: %s", percentStr)
+	}
+
+	return CanaryConfig{Pool: pool, Percent: percent, Key: "client_ip"}, nil
+}
+
+// validUserKey reports whether a canary_key or split_key route option is
+// supported
+func validUserKey(key string) bool {
+	return key == "client_ip" || strings.HasPrefix(key, "header:") || strings.HasPrefix(key, "cookie:")
+}
+
+// userKey returns what identifies the user of a request: its client IP, or
+// the value of a header:<name> or cookie:<name>
+func userKey(r *http.Request, key string) string {
+	switch {
+	case strings.HasPrefix(key, "header:"):
+		return r.Header.Get(strings.TrimPrefix(key, "header:"))
+	case strings.HasPrefix(key, "cookie:"):
+		if cookie, err := r.Cookie(strings.TrimPrefix(key, "cookie:")); err == nil {
+			return cookie.Value
+		}
+		return ""
+	default:
+		return getClientIP(r)
+	}
+}
+
+// CanaryStats holds how many requests of a route went to each side of a canary
+type CanaryStats struct {
+	Canary int64 `json:"canary"`
+	Stable int64 `json:"stable"`
+}
+
+var (
+	canaryStats   = make(map[string]*CanaryStats)
+	canaryStatsMu sync.Mutex
+)
+
+func countCanaryDecision(route string, canary bool) {
+	canaryStatsMu.Lock()
+	defer canaryStatsMu.Unlock()
+
+	stats, ok := canaryStats[route]
+	if !ok {
+		stats = &CanaryStats{}
+		canaryStats[route] = stats
+	}
+	if canary {
+		stats.Canary++
+	} else {
+		stats.Stable++
+	}
+}
+
+// GetCanaryStats returns the canary split of each route with a canary
+func GetCanaryStats() map[string]CanaryStats {
+	canaryStatsMu.Lock()
+	defer canaryStatsMu.Unlock()
+
+	stats := make(map[string]CanaryStats, len(canaryStats))
+	for route, s := range canaryStats {
+		stats[route] = *s
+	}
+	return stats
+}
+
+// CanarySplitter sends the users whose key hashes into the canary percentage
+// to the canary pool and everyone else to the stable pool. Because users are
+// selected by key rather than per request, a user stays on the same side for
+// the whole canary. Requests without a key always go to the stable pool.
+type CanarySplitter struct {
+	next   LoadBalancerStrategy
+	canary LoadBalancerStrategy
+	route  string
+	config CanaryConfig
+}
+
+// NewCanarySplitter wraps a route's pool with a canary split.
+// The pool is returned unchanged if the percentage is not positive.
+func NewCanarySplitter(next, canary LoadBalancerStrategy, route string, config CanaryConfig) LoadBalancerStrategy {
+	if config.Percent <= 0 || canary == nil {
+		return next
+	}
+	return &CanarySplitter{
+		next:   next,
+		canary: canary,
+		route:  route,
+		config: config,
+	}
+}
+
+// inCanary reports whether the request's user belongs to the canary. The
+// canary pool is part of the hash so different canaries select different users.
+func (cs *CanarySplitter) inCanary(r *http.Request) bool {
+	key := userKey(r, cs.config.Key)
+	if key == "" {
+		return false
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(cs.config.Pool))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+
+	// Buckets of a hundredth of a percent allow fractional percentages
+	return float64(h.Sum32()%10000) < cs.config.Percent*100
+}
+
+func (cs *CanarySplitter) pick(r *http.Request) LoadBalancerStrategy {
+	if cs.inCanary(r) {
+		return cs.canary
+	}
+	return cs.next
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (cs *CanarySplitter) GetNextInstance(r *http.Request) (*url.URL, error) {
+	return cs.pick(r).GetNextInstance(r)
+}
+
+// ProxyRequest proxies the request to the canary or the stable pool
+func (cs *CanarySplitter) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	canary := cs.inCanary(r)
+	countCanaryDecision(cs.route, canary)
+
+	if canary {
+		annotateRoute(r, "", cs.config.Pool)
+		cs.canary.ProxyRequest(w, r)
+		return
+	}
+	cs.next.ProxyRequest(w, r)
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (cs *CanarySplitter) SupportsWebSockets() bool {
+	return cs.next.SupportsWebSockets() && cs.canary.SupportsWebSockets()
+}
+
+// Unwrap returns the stable pool
+func (cs *CanarySplitter) Unwrap() LoadBalancerStrategy {
+	return cs.next
+}
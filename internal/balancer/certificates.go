@@ -0,0 +1,174 @@
+package balancer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// defaultTLSReload is how often certificate files are checked for changes
+// when no tls_reload directive is configured
+const defaultTLSReload = 10 * time.Second
+
+// TLSCertificateConfig is a certificate and key pair served to clients
+type TLSCertificateConfig struct {
+	CertFile string
+	KeyFile  string
+}
+
+// certificateFiles is a loaded certificate with the state of its files
+type certificateFiles struct {
+	config TLSCertificateConfig
+	cert   *tls.Certificate
+	// stamp identifies the versions of the files the certificate was
+	// loaded from
+	stamp string
+}
+
+// CertificateStore serves the certificate matching the server name each
+// client asks for, out of several, and reloads certificates whose files
+// change, so rotated certificates are served without a restart
+type CertificateStore struct {
+	// mu serializes reloads
+	mu       sync.Mutex
+	files    []certificateFiles
+	current  atomic.Pointer[[]*tls.Certificate]
+	interval time.Duration
+	stop     chan struct{}
+}
+
+// NewCertificateStore loads certificates, checking their files for changes
+// every interval, or never if it is 0. The first certificate is served to
+// clients that no certificate matches.
+func NewCertificateStore(configs []TLSCertificateConfig, interval time.Duration) (*CertificateStore, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no TLS certificate configured")
+	}
+
+	store := &CertificateStore{interval: interval, stop: make(chan struct{})}
+	for _, config := range configs {
+		stamp, err := certificateStamp(config)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate %s: %w", config.CertFile, err)
+		}
+		store.files = append(store.files, certificateFiles{config: config, cert: &cert, stamp: stamp})
+	}
+	store.publish()
+	return store, nil
+}
+
+// Configure makes a TLS config serve the certificates of the store
+func (s *CertificateStore) Configure(tlsConfig *tls.Config) {
+	tlsConfig.Certificates = nil
+	tlsConfig.GetCertificate = s.GetCertificate
+}
+
+// GetCertificate returns the first certificate valid for the server name
+// and algorithms of a ClientHello, or the first certificate if none is
+func (s *CertificateStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certs := *s.current.Load()
+	for _, cert := range certs {
+		if hello.SupportsCertificate(cert) == nil {
+			return cert, nil
+		}
+	}
+	return certs[0], nil
+}
+
+// Start begins checking the certificate files for changes in the background
+func (s *CertificateStore) Start() {
+	if s == nil || s.interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.Reload()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends checking for changes
+func (s *CertificateStore) Stop() {
+	if s == nil || s.interval <= 0 {
+		return
+	}
+	close(s.stop)
+}
+
+// Reload loads the certificates whose files changed since they were loaded.
+// A certificate that fails to load, for instance because its key has not
+// been written yet, keeps being served until its files are valid.
+func (s *CertificateStore) Reload() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	for i := range s.files {
+		files := &s.files[i]
+		stamp, err := certificateStamp(files.config)
+		if err != nil {
+			logger.Log.Warn("Failed to check TLS certificate", zap.String("cert", files.config.CertFile), zap.Error(err))
+			continue
+		}
+		if stamp == files.stamp {
+			continue
+		}
+
+		cert, err := tls.LoadX509KeyPair(files.config.CertFile, files.config.KeyFile)
+		if err != nil {
+			logger.Log.Warn("Failed to reload TLS certificate", zap.String("cert", files.config.CertFile), zap.Error(err))
+			continue
+		}
+		files.cert, files.stamp = &cert, stamp
+		changed = true
+		logger.Log.Info("TLS certificate reloaded",
+			zap.String("cert", files.config.CertFile),
+			zap.Strings("names", cert.Leaf.DNSNames),
+			zap.Time("not_after", cert.Leaf.NotAfter))
+	}
+	if changed {
+		s.publish()
+	}
+}
+
+// publish makes the loaded certificates the ones served
+func (s *CertificateStore) publish() {
+	certs := make([]*tls.Certificate, len(s.files))
+	for i, files := range s.files {
+		certs[i] = files.cert
+	}
+	s.current.Store(&certs)
+}
+
+// certificateStamp identifies the versions of the files of a certificate by
+// their size and modification time
+func certificateStamp(config TLSCertificateConfig) (string, error) {
+	var stamp string
+	for _, name := range []string{config.CertFile, config.KeyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return "", err
+		}
+		stamp += fmt.Sprintf("%d/%d;", info.Size(), info.ModTime().UnixNano())
+	}
+	return stamp, nil
+}
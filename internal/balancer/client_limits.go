@@ -0,0 +1,169 @@
+package balancer
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// ClientLimitsConfig holds the limits the listener puts on client
+// connections, so slow or greedy clients cannot tie up the server
+type ClientLimitsConfig struct {
+	// HeaderTimeout bounds the time a client takes to send the request
+	// headers, the defence against slowloris
+	HeaderTimeout time.Duration
+	// ReadTimeout and WriteTimeout bound the time spent reading a whole
+	// request and writing its response, if set. Streaming routes lift the
+	// write timeout.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// IdleTimeout bounds the time a keep-alive connection waits for its
+	// next request
+	IdleTimeout time.Duration
+	// MaxHeaderBytes bounds the size of the request headers
+	MaxHeaderBytes int
+	// MaxConnsPerIP bounds the connections a client IP holds open at once,
+	// if set
+	MaxConnsPerIP int
+}
+
+// defaultClientLimits applies when no client_ directive sets a limit
+var defaultClientLimits = ClientLimitsConfig{
+	HeaderTimeout:  10 * time.Second,
+	IdleTimeout:    2 * time.Minute,
+	MaxHeaderBytes: http.DefaultMaxHeaderBytes,
+}
+
+// parseClientTimeouts parses the arguments of a client_timeouts directive
+// into the limits. A timeout of 0 disables it.
+func parseClientTimeouts(parts []string, limits *ClientLimitsConfig) error {
+	if len(parts) < 2 {
+		return fmt.Errorf("client_timeouts directive requires a timeout")
+	}
+
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(strings.TrimSuffix(part, ";"), "=")
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			return fmt.Errorf("invalid client %s timeout: %s", key, value)
+		}
+		switch key {
+		case "header":
+			limits.HeaderTimeout = timeout
+		case "read":
+			limits.ReadTimeout = timeout
+		case "write":
+			limits.WriteTimeout = timeout
+		case "idle":
+			limits.IdleTimeout = timeout
+		default:
+			return fmt.Errorf("unknown client_timeouts option: %s", part)
+		}
+	}
+	return nil
+}
+
+// ConfigureClientLimits sets the timeouts and header size limit of the
+// server clients connect to
+func ConfigureClientLimits(server *http.Server, limits ClientLimitsConfig) {
+	server.ReadHeaderTimeout = limits.HeaderTimeout
+	server.ReadTimeout = limits.ReadTimeout
+	server.WriteTimeout = limits.WriteTimeout
+	server.IdleTimeout = limits.IdleTimeout
+	server.MaxHeaderBytes = limits.MaxHeaderBytes
+}
+
+// connsRefused counts the connections closed because their client IP held
+// too many
+var connsRefused int64
+
+// GetConnsRefused returns the number of connections closed because their
+// client IP was at its connection limit
+func GetConnsRefused() int64 {
+	return atomic.LoadInt64(&connsRefused)
+}
+
+// ConnLimitListener bounds the connections each client IP holds open at
+// once. Connections over the limit are closed at accept time, before any
+// HTTP parsing happens.
+type ConnLimitListener struct {
+	net.Listener
+	max   int
+	mu    sync.Mutex
+	conns map[string]int
+}
+
+// NewConnLimitListener wraps a listener with a per-IP connection limit.
+// The listener is returned unchanged if max is not positive.
+func NewConnLimitListener(l net.Listener, max int) net.Listener {
+	if max <= 0 {
+		return l
+	}
+	return &ConnLimitListener{
+		Listener: l,
+		max:      max,
+		conns:    make(map[string]int),
+	}
+}
+
+// Accept waits for the next connection from a client under its limit
+func (l *ConnLimitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+		if err != nil {
+			return conn, nil
+		}
+
+		l.mu.Lock()
+		open := l.conns[ip]
+		if open < l.max {
+			l.conns[ip] = open + 1
+		}
+		l.mu.Unlock()
+		if open < l.max {
+			return &limitedConn{Conn: conn, release: func() { l.release(ip) }}, nil
+		}
+
+		atomic.AddInt64(&connsRefused, 1)
+		logger.Log.Debug("Connection refused over the per-IP limit",
+			zap.String("ip", ip),
+			zap.Int("limit", l.max))
+		conn.Close()
+	}
+}
+
+// release gives back the slot of a closed connection
+func (l *ConnLimitListener) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conns[ip] <= 1 {
+		delete(l.conns, ip)
+	} else {
+		l.conns[ip]--
+	}
+}
+
+// limitedConn releases its slot when it is first closed
+type limitedConn struct {
+	net.Conn
+	release func()
+	once    sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
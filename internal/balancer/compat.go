@@ -0,0 +1,240 @@
+package balancer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// maxCompatBodySize bounds the request bodies buffered to avoid chunked
+// encoding towards legacy backends
+const maxCompatBodySize = 16 << 20
+
+// CompatConfig holds the compatibility settings of a pool of legacy backends
+// that do not cope with the default behavior of the Go HTTP client
+type CompatConfig struct {
+	// HTTP10 sends requests as HTTP/1.0 over a new connection each
+	HTTP10 bool
+	// NoChunked sends request bodies with a Content-Length instead of chunked
+	NoChunked bool
+	// HeaderCase maps canonical header names to the exact casing to send
+	HeaderCase map[string]string
+}
+
+func (c CompatConfig) enabled() bool {
+	return c.HTTP10 || c.NoChunked || len(c.HeaderCase) > 0
+}
+
+// parseCompat parses the arguments of a compat directive
+func parseCompat(parts []string) (CompatConfig, error) {
+	config := CompatConfig{}
+
+	for i := 1; i < len(parts); i++ {
+		switch {
+		case parts[i] == "http1.0":
+			config.HTTP10 = true
+		case parts[i] == "no_chunked":
+			config.NoChunked = true
+		case strings.HasPrefix(parts[i], "header_case="):
+			config.HeaderCase = make(map[string]string)
+			for _, name := range strings.Split(strings.TrimPrefix(parts[i], "header_case="), ",") {
+				if name == "" {
+					continue
+				}
+				config.HeaderCase[textproto.CanonicalMIMEHeaderKey(name)] = name
+			}
+		default:
+			return CompatConfig{}, fmt.Errorf("unknown compat option: %s", parts[i])
+		}
+	}
+
+	if !config.enabled() {
+		return CompatConfig{}, fmt.Errorf("compat directive requires an option")
+	}
+	return config, nil
+}
+
+// setCompat puts the backends behind a pool's strategy in compatibility mode
+func setCompat(lb LoadBalancerStrategy, config CompatConfig) {
+	if !config.enabled() {
+		return
+	}
+	for _, p := range strategyProcesses(lb) {
+		p.transport = &compatTransport{
+			base:    transportFor(p),
+			process: p,
+			config:  config,
+		}
+	}
+}
+
+// hopHeaders are the headers that only apply to a single connection
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// compatTransport adapts requests to legacy backends
+type compatTransport struct {
+	base    http.RoundTripper
+	process *Process
+	config  CompatConfig
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *compatTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+
+	// HTTP/1.0 has no chunked encoding, so the body length must be known
+	if (t.config.NoChunked || t.config.HTTP10) && req.Body != nil && req.ContentLength < 0 {
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxCompatBodySize+1))
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > maxCompatBodySize {
+			return nil, fmt.Errorf("request body exceeds %d bytes", maxCompatBodySize)
+		}
+		req.ContentLength = int64(len(body))
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	// The Go client writes header names as they are stored, so renaming a
+	// header sets the casing on the wire
+	for canonical, exact := range t.config.HeaderCase {
+		if values, ok := req.Header[canonical]; ok && canonical != exact {
+			delete(req.Header, canonical)
+			req.Header[exact] = values
+		}
+	}
+
+	if !t.config.HTTP10 {
+		return t.base.RoundTrip(req)
+	}
+	return t.roundTripHTTP10(req)
+}
+
+// roundTripHTTP10 sends the request as HTTP/1.0 over a new connection, which
+// the backend closes after responding
+func (t *compatTransport) roundTripHTTP10(req *http.Request) (*http.Response, error) {
+	conn, err := t.dial(req.Context(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cancelling the request closes the connection, interrupting any read
+	stop := context.AfterFunc(req.Context(), func() {
+		conn.Close()
+	})
+
+	if err := writeHTTP10Request(conn, req); err != nil {
+		stop()
+		conn.Close()
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		stop()
+		conn.Close()
+		return nil, err
+	}
+	resp.Body = &connClosingBody{ReadCloser: resp.Body, conn: conn, stop: stop}
+	return resp, nil
+}
+
+func (t *compatTransport) dial(ctx context.Context, req *http.Request) (net.Conn, error) {
+	addr := req.URL.Host
+	if req.URL.Port() == "" {
+		if req.URL.Scheme == "https" {
+			addr = net.JoinHostPort(req.URL.Hostname(), "443")
+		} else {
+			addr = net.JoinHostPort(req.URL.Hostname(), "80")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil || req.URL.Scheme != "https" {
+		return conn, err
+	}
+
+	serverName := t.process.ServerName
+	if serverName == "" {
+		serverName = req.URL.Hostname()
+	}
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: serverName})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// writeHTTP10Request writes a request as HTTP/1.0 without hop-by-hop headers
+func writeHTTP10Request(conn net.Conn, req *http.Request) error {
+	header := req.Header.Clone()
+	for _, name := range header.Values("Connection") {
+		for _, token := range strings.Split(name, ",") {
+			header.Del(strings.TrimSpace(token))
+		}
+	}
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
+	header.Del("Host")
+	header.Del("Content-Length")
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "%s %s HTTP/1.0\r\n", req.Method, req.URL.RequestURI())
+	fmt.Fprintf(w, "Host: %s\r\n", host)
+	if err := header.Write(w); err != nil {
+		return err
+	}
+	if req.ContentLength > 0 || req.Method == http.MethodPost || req.Method == http.MethodPut || req.Method == http.MethodPatch {
+		fmt.Fprintf(w, "Content-Length: %d\r\n", max(req.ContentLength, 0))
+	}
+	w.WriteString("Connection: close\r\n\r\n")
+
+	if req.Body != nil {
+		if _, err := io.Copy(w, req.Body); err != nil {
+			return err
+		}
+		req.Body.Close()
+	}
+	return w.Flush()
+}
+
+// connClosingBody closes the connection of an HTTP/1.0 response with its body
+type connClosingBody struct {
+	io.ReadCloser
+	conn net.Conn
+	stop func() bool
+}
+
+func (b *connClosingBody) Close() error {
+	b.stop()
+	err := b.ReadCloser.Close()
+	b.conn.Close()
+	return err
+}
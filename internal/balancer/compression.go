@@ -0,0 +1,301 @@
+package balancer
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CompressionEncoder creates a writer compressing to w. The level is -1 for
+// the encoder's default.
+type CompressionEncoder func(w io.Writer, level int) (io.WriteCloser, error)
+
+var (
+	compressionEncoders = map[string]CompressionEncoder{
+		"gzip": func(w io.Writer, level int) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, level)
+		},
+		"deflate": func(w io.Writer, level int) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		},
+	}
+	compressionEncodersMu sync.RWMutex
+)
+
+// RegisterCompressionEncoder registers a content encoding, such as br, so it
+// can be listed in the compression directive. Registering an existing name
+// replaces its encoder.
+func RegisterCompressionEncoder(name string, encoder CompressionEncoder) {
+	compressionEncodersMu.Lock()
+	defer compressionEncodersMu.Unlock()
+
+	compressionEncoders[strings.ToLower(name)] = encoder
+}
+
+func lookupCompressionEncoder(name string) (CompressionEncoder, bool) {
+	compressionEncodersMu.RLock()
+	defer compressionEncodersMu.RUnlock()
+
+	encoder, ok := compressionEncoders[name]
+	return encoder, ok
+}
+
+// CompressionConfig holds the settings of response compression
+type CompressionConfig struct {
+	// Encodings in order of preference; compression is off if empty
+	Encodings []string
+	MinSize   int
+	Level     int
+	// Types are the compressed media types; a type ending in /* matches all
+	// of its subtypes
+	Types []string
+}
+
+var defaultCompressionTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// parseCompression parses the arguments of a compression directive
+func parseCompression(parts []string) (CompressionConfig, error) {
+	config := CompressionConfig{MinSize: 1024, Level: -1, Types: defaultCompressionTypes}
+
+	for i := 1; i < len(parts); i++ {
+		if strings.HasPrefix(parts[i], "min_size=") {
+			size, err := parseByteSize(strings.TrimPrefix(parts[i], "min_size="))
+			if err != nil {
+				return CompressionConfig{}, fmt.Errorf("invalid compression min_size: %v", err)
+			}
+			config.MinSize = int(size)
+		} else if strings.HasPrefix(parts[i], "level=") {
+			levelStr := strings.TrimPrefix(parts[i], "level=")
+			level, err := strconv.Atoi(levelStr)
+			if err != nil || level < 1 || level > 9 {
+				return CompressionConfig{}, fmt.Errorf("invalid compression level: %s", levelStr)
+			}
+			config.Level = level
+		} else if strings.HasPrefix(parts[i], "types=") {
+			config.Types = strings.Split(strings.TrimPrefix(parts[i], "types="), ",")
+		} else {
+			encoding := strings.ToLower(parts[i])
+			if _, ok := lookupCompressionEncoder(encoding); !ok {
+				return CompressionConfig{}, fmt.Errorf("unknown compression encoding: %s", parts[i])
+			}
+			config.Encodings = append(config.Encodings, encoding)
+		}
+	}
+
+	if len(config.Encodings) == 0 {
+		return CompressionConfig{}, fmt.Errorf("compression directive requires an encoding")
+	}
+	return config, nil
+}
+
+// Compressor compresses upstream responses on the fly with an encoding the
+// client accepts, so backends do not each need to implement compression
+type Compressor struct {
+	next   LoadBalancerStrategy
+	config CompressionConfig
+}
+
+// NewCompressor wraps a strategy with response compression.
+// The strategy is returned unchanged if no encoding is configured.
+func NewCompressor(next LoadBalancerStrategy, config CompressionConfig) LoadBalancerStrategy {
+	if len(config.Encodings) == 0 {
+		return next
+	}
+	return &Compressor{
+		next:   next,
+		config: config,
+	}
+}
+
+// negotiate returns the preferred configured encoding the client accepts, or
+// "" if it accepts none of them
+func (c *Compressor) negotiate(acceptEncoding string) string {
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[strings.ToLower(coding)] = q
+	}
+
+	for _, encoding := range c.config.Encodings {
+		q, ok := accepted[encoding]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > 0 {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// compressible reports whether responses of a content type are compressed
+func (c *Compressor) compressible(contentType string) bool {
+	return mediaTypeMatches(contentType, c.config.Types)
+}
+
+// mediaTypeMatches reports whether a content type is one of a list of media
+// types, where a type ending in /* matches all of its subtypes
+func mediaTypeMatches(contentType string, types []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, allowed := range types {
+		if mediaType == allowed {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok && strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (c *Compressor) GetNextInstance(r *http.Request) (*url.URL, error) {
+	return c.next.GetNextInstance(r)
+}
+
+// ProxyRequest proxies the request, compressing the response if the client
+// accepts a configured encoding
+func (c *Compressor) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	encoding := c.negotiate(r.Header.Get("Accept-Encoding"))
+	if encoding == "" || r.Method == http.MethodHead || IsWebSocketRequest(r) {
+		c.next.ProxyRequest(w, r)
+		return
+	}
+
+	cw := &compressWriter{
+		ResponseWriter: w,
+		compressor:     c,
+		encoding:       encoding,
+	}
+	c.next.ProxyRequest(cw, r)
+	cw.close()
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (c *Compressor) SupportsWebSockets() bool {
+	return c.next.SupportsWebSockets()
+}
+
+// Unwrap returns the wrapped strategy
+func (c *Compressor) Unwrap() LoadBalancerStrategy {
+	return c.next
+}
+
+// compressWriter decides whether to compress when the response starts. Like
+// nginx's gzip_min_length, the minimum size is checked against Content-Length,
+// so responses of unknown length, such as streams, are always compressed.
+type compressWriter struct {
+	http.ResponseWriter
+	compressor  *Compressor
+	encoding    string
+	wroteHeader bool
+	encoder     io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(statusCode int) {
+	// Informational responses precede the final one
+	if statusCode < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	if statusCode != http.StatusNoContent && statusCode != http.StatusNotModified {
+		cw.start()
+	}
+	cw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// start sets up compression if the response qualifies
+func (cw *compressWriter) start() {
+	header := cw.ResponseWriter.Header()
+	if !cw.compressor.compressible(header.Get("Content-Type")) {
+		return
+	}
+	header.Add("Vary", "Accept-Encoding")
+
+	if header.Get("Content-Encoding") != "" ||
+		header.Get("Content-Range") != "" ||
+		strings.Contains(header.Get("Cache-Control"), "no-transform") {
+		return
+	}
+	if length, err := strconv.Atoi(header.Get("Content-Length")); err == nil && length < cw.compressor.config.MinSize {
+		return
+	}
+
+	encoder, _ := lookupCompressionEncoder(cw.encoding)
+	writer, err := encoder(cw.ResponseWriter, cw.compressor.config.Level)
+	if err != nil {
+		return
+	}
+	cw.encoder = writer
+	header.Set("Content-Encoding", cw.encoding)
+	header.Del("Content-Length")
+	// A strong ETag would wrongly match the uncompressed representation
+	if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		header.Set("ETag", "W/"+etag)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// close finishes the compressed stream
+func (cw *compressWriter) close() {
+	if cw.encoder != nil {
+		cw.encoder.Close()
+	}
+}
+
+// Flush sends what was compressed so far, so streamed responses such as
+// event streams are not held back by the encoder
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := cw.encoder.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,204 @@
+package balancer
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// strategyProcesses returns every backend process reachable from a strategy,
+// leaving out backends deregistered for being dead too long
+func strategyProcesses(lb LoadBalancerStrategy) []*Process {
+	seen := make(map[*Process]bool)
+	var processes []*Process
+
+	walkBalancers(lb, func(balancer interface{}) {
+		for _, p := range balancerProcesses(balancer) {
+			if !seen[p] && !p.IsDeregistered() {
+				seen[p] = true
+				processes = append(processes, p)
+			}
+		}
+	})
+
+	return processes
+}
+
+// balancerProcesses returns the backends of a balancer visited by walkBalancers
+func balancerProcesses(balancer interface{}) []*Process {
+	if pool, ok := balancer.(Pool); ok {
+		return pool.Backends()
+	}
+	return nil
+}
+
+// walkBalancers calls visit with every balancer holding backends that is
+// reachable from a strategy
+func walkBalancers(lb LoadBalancerStrategy, visit func(balancer interface{})) {
+	switch typed := lb.(type) {
+	case *PathRouter:
+		for _, pool := range typed.backendPools {
+			walkBalancers(pool, visit)
+		}
+	case *FailoverChain:
+		for _, m := range typed.members {
+			walkBalancers(m.lb, visit)
+		}
+	case *PoolStrategy:
+		visit(typed.pool)
+	case Pool:
+		visit(typed)
+	case strategyWrapper:
+		walkBalancers(typed.Unwrap(), visit)
+	}
+}
+
+// ConcurrencyHistory holds the in-flight request samples of every backend
+type ConcurrencyHistory struct {
+	IntervalSeconds int                `json:"intervalSeconds"`
+	Timestamps      []int64            `json:"timestamps"`
+	Backends        map[string][]int32 `json:"backends"`
+}
+
+// ConcurrencySampler samples the in-flight requests of every backend once
+// per second into a fixed-size in-memory ring buffer
+type ConcurrencySampler struct {
+	lb         LoadBalancerStrategy
+	size       int
+	mu         sync.RWMutex
+	timestamps []int64
+	samples    map[string][]int32
+	next       int
+	count      int
+	stop       chan struct{}
+}
+
+// NewConcurrencySampler creates a sampler keeping the given number of samples
+func NewConcurrencySampler(lb LoadBalancerStrategy, size int) *ConcurrencySampler {
+	if size <= 0 {
+		size = 300
+	}
+	return &ConcurrencySampler{
+		lb:         lb,
+		size:       size,
+		timestamps: make([]int64, size),
+		samples:    make(map[string][]int32),
+		stop:       make(chan struct{}),
+	}
+}
+
+// Start begins sampling in the background
+func (cs *ConcurrencySampler) Start() {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				cs.Sample(now)
+			case <-cs.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends sampling
+func (cs *ConcurrencySampler) Stop() {
+	close(cs.stop)
+}
+
+// Sample records the in-flight requests of every backend at a time. Backends
+// no longer in the pool sample zero, and are dropped once every sample they
+// had in flight has left the ring.
+func (cs *ConcurrencySampler) Sample(now time.Time) {
+	processes := strategyProcesses(cs.lb)
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.timestamps[cs.next] = now.Unix()
+	seen := make(map[string]bool, len(processes))
+	for _, p := range processes {
+		key := p.URL.String()
+		seen[key] = true
+		series, ok := cs.samples[key]
+		if !ok {
+			series = make([]int32, cs.size)
+			cs.samples[key] = series
+		}
+		series[cs.next] = p.GetActiveConnections()
+	}
+	for key, series := range cs.samples {
+		if seen[key] {
+			continue
+		}
+		series[cs.next] = 0
+		if allZero(series) {
+			delete(cs.samples, key)
+		}
+	}
+
+	cs.next = (cs.next + 1) % cs.size
+	if cs.count < cs.size {
+		cs.count++
+	}
+}
+
+// allZero reports whether a series holds no in-flight request
+func allZero(series []int32) bool {
+	for _, n := range series {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// History returns the samples in chronological order
+func (cs *ConcurrencySampler) History() ConcurrencyHistory {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	history := ConcurrencyHistory{
+		IntervalSeconds: 1,
+		Timestamps:      make([]int64, 0, cs.count),
+		Backends:        make(map[string][]int32, len(cs.samples)),
+	}
+
+	start := (cs.next - cs.count + cs.size) % cs.size
+	for i := 0; i < cs.count; i++ {
+		history.Timestamps = append(history.Timestamps, cs.timestamps[(start+i)%cs.size])
+	}
+	for key, series := range cs.samples {
+		ordered := make([]int32, 0, cs.count)
+		for i := 0; i < cs.count; i++ {
+			ordered = append(ordered, series[(start+i)%cs.size])
+		}
+		history.Backends[key] = ordered
+	}
+
+	return history
+}
+
+// ConcurrencyHandler serves the in-flight request history of every backend
+func ConcurrencyHandler(cs *ConcurrencySampler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(cs.History()); err != nil {
+			logger.Log.Error("Failed to encode concurrency history", zap.Error(err))
+		}
+	}
+}
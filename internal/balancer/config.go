@@ -2,10 +2,14 @@ package balancer
 
 import (
 	"bufio"
-	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
 )
 
 // RouteType defines the type of routing rule
@@ -18,12 +22,34 @@ const (
 	RegexRoute
 	// HeaderRoute matches based on HTTP headers
 	HeaderRoute
+	// ExprRoute matches requests with an expression over their attributes
+	ExprRoute
+	// GeoRoute matches requests by the country or continent of the client
+	GeoRoute
 )
 
 type BackendConfig struct {
 	URL      string
 	Weight   int
 	MaxConns int
+	// MaxWebSockets limits the WebSockets open to the backend at once
+	MaxWebSockets int
+	// Host overrides the Host header sent to the backend, and ServerName the
+	// TLS server name, which defaults to Host
+	Host       string
+	ServerName string
+	// Prefix is put in front of the path of the requests sent to the backend
+	Prefix string
+	// Zone is the failure domain of the backend, such as a rack or an
+	// availability zone
+	Zone string
+	// Region lists the country and continent codes of the clients the
+	// backend is close to, comma separated, for geo-weighted balancing
+	Region string
+	// Priority is the failover tier of the backend, 0 for the primary tier
+	Priority int
+	// Line is where the server is declared in the configuration file
+	Line int
 }
 
 type RouteConfig struct {
@@ -32,6 +58,52 @@ type RouteConfig struct {
 	HeaderName  string
 	HeaderValue string
 	BackendPool string
+	// Static serves the route's requests from a directory in place of
+	// BackendPool, if set
+	Static StaticConfig
+	// RateLimit is the name of the limit policy applied to the route, if any
+	RateLimit string
+	// RequestSchema and ResponseSchema are JSON Schema files validating the
+	// route's request and response bodies, if any
+	RequestSchema  string
+	ResponseSchema string
+	// Canary sends a share of the route's users to another pool, if set
+	Canary CanaryConfig
+	// Split divides the route's traffic between pools, if set, in which case
+	// BackendPool is the first of them
+	Split SplitConfig
+	// Green is the pool the admin API can switch the route to from
+	// BackendPool, if set
+	Green string
+	// Cache is the name of the cache zone serving the route, if any
+	Cache string
+	// Auth is the name of the authentication policy protecting the route, if any
+	Auth string
+	// ErrorPage is the name of the page replacing the route's gateway errors, if any
+	ErrorPage string
+	// Transform is the name of the response transform rewriting the route's
+	// response bodies, if any
+	Transform string
+	// Middlewares names the registered middleware the route's requests go
+	// through, outermost first
+	Middlewares []string
+	// Streaming flushes the route's responses through as they arrive and
+	// exempts its requests from deadlines, for event streams and long polling
+	Streaming bool
+	// Timeout bounds the time spent on the route's requests in place of the
+	// global RequestTimeout, if set
+	Timeout time.Duration
+	// Class is the priority of the route's requests when the server sheds
+	// load or requests queue for a backend: low, normal or critical; empty
+	// means normal
+	Class string
+	// Line is the configuration file line the route is defined on
+	Line int
+
+	// expr is the compiled Pattern of an expr route
+	expr *routeExpr
+	// geo is the parsed Pattern of a geo route
+	geo *geoRoute
 }
 
 type Config struct {
@@ -42,6 +114,86 @@ type Config struct {
 	Method           LoadBalancerAlgorithm
 	PersistenceType  PersistenceMethod
 	PersistenceAttrs map[string]string
+	Headers          HeaderRules
+	PoolHeaders      map[string]HeaderRules
+	ConnRateLimit    float64
+	ConnRateBurst    int
+	ClientLimits     ClientLimitsConfig
+	RateLimit        RateLimitConfig
+	BlockRules       []BlockRule
+	RoutingOverride  RoutingOverrideConfig
+	PoolRateLimits   map[string]RateLimitConfig
+	LimitPolicies    map[string]RateLimitConfig
+	CacheZones       map[string]CacheConfig
+	AuthPolicies     map[string]AuthConfig
+	ErrorPages       map[string]ErrorPageConfig
+	Transforms       map[string]ResponseTransformConfig
+	Plugins          map[string]PluginConfig
+	PoolQueues       map[string]QueueConfig
+	PoolCompat       map[string]CompatConfig
+	TLSCertFile      string
+	TLSKeyFile       string
+	TLSCertificates  []TLSCertificateConfig
+	TLSReload        time.Duration
+	PoolSubsets      map[string]SubsetConfig
+	PoolLimits       map[string]PoolLimitConfig
+	// PoolAntiAffinity holds the pools whose retries and persistence
+	// fallbacks avoid the zone of the backend that failed
+	PoolAntiAffinity map[string]bool
+	PoolMinAlive     map[string]int
+	Failovers        map[string]FailoverConfig
+	Mirrors          map[string]MirrorConfig
+	Tracing          TracingConfig
+	KeepAlive        KeepAliveConfig
+	Preconnect       PreconnectConfig
+	Metrics          MetricsConfig
+	Statsd           StatsdConfig
+	DNS              DNSConfig
+	DNSRefresh       DNSRefreshConfig
+	WebSocketDrain   time.Duration
+	DeregisterAfter  time.Duration
+	DrainSignal      DrainSignalConfig
+	Revival          RevivalConfig
+	// PoolRevivals overrides Revival for the pools with a revive directive
+	PoolRevivals map[string]RevivalConfig
+	Retry        RetryConfig
+	// PoolRetries overrides Retry for the pools with a retries directive
+	PoolRetries map[string]RetryConfig
+	// PoolRedirects holds the pools whose redirects to their backends are
+	// pointed back at the balancer
+	PoolRedirects map[string]bool
+	// PoolWebSockets holds the WebSocket limits of the pools with a
+	// websocket directive
+	PoolWebSockets map[string]WebSocketConfig
+	Readiness      ReadinessConfig
+	// SystemLog sends critical lifecycle events to the systemd journal,
+	// syslog or the Windows event log as well as the usual log
+	SystemLog   bool
+	AccessLog   AccessLogConfig
+	Compression CompressionConfig
+	GSLB        GSLBConfig
+	Log         logger.Config
+	// RequestTimeout bounds the time spent on a request, if set
+	RequestTimeout time.Duration
+	// Overload sheds requests of low priority routes while the server is
+	// overloaded
+	Overload OverloadConfig
+	// GeoIP locates clients for geo routes and geo-weighted pools
+	GeoIP *GeoIPDatabase
+	// SNI forwards TLS connections to pools by server name without
+	// terminating them
+	SNI SNIConfig
+	// RealIP holds the trusted proxies whose headers give the client IP
+	RealIP RealIPConfig
+	// Listeners are the addresses served besides the main one, each with
+	// its own routes
+	Listeners []ListenerConfig
+}
+
+// PoolBackends returns the backends of a pool, limited to this instance's
+// subset when subsetting is configured
+func (c *Config) PoolBackends(pool string) []BackendConfig {
+	return SubsetBackends(c.BackendPools[pool], c.PoolSubsets[pool])
 }
 
 func ParseConfig(filename string) (*Config, error) {
@@ -51,6 +203,11 @@ func ParseConfig(filename string) (*Config, error) {
 	}
 	defer file.Close()
 
+	return parseConfig(file)
+}
+
+// parseConfig parses a configuration read from r
+func parseConfig(r io.Reader) (*Config, error) {
 	cfg := &Config{
 		Backends:         []BackendConfig{},
 		BackendPools:     make(map[string][]BackendConfig),
@@ -59,11 +216,54 @@ func ParseConfig(filename string) (*Config, error) {
 		Method:           RoundRobin,
 		PersistenceType:  NoPersistence,
 		PersistenceAttrs: make(map[string]string),
+		PoolHeaders:      make(map[string]HeaderRules),
+		PoolRateLimits:   make(map[string]RateLimitConfig),
+		LimitPolicies:    make(map[string]RateLimitConfig),
+		CacheZones:       make(map[string]CacheConfig),
+		AuthPolicies:     make(map[string]AuthConfig),
+		ErrorPages:       make(map[string]ErrorPageConfig),
+		Transforms:       make(map[string]ResponseTransformConfig),
+		Plugins:          make(map[string]PluginConfig),
+		PoolQueues:       make(map[string]QueueConfig),
+		PoolCompat:       make(map[string]CompatConfig),
+		PoolSubsets:      make(map[string]SubsetConfig),
+		PoolLimits:       make(map[string]PoolLimitConfig),
+		PoolAntiAffinity: make(map[string]bool),
+		PoolRedirects:    make(map[string]bool),
+		PoolMinAlive:     make(map[string]int),
+		Failovers:        make(map[string]FailoverConfig),
+		Mirrors:          make(map[string]MirrorConfig),
+		Tracing: TracingConfig{
+			SampleRate: 1,
+			Headers:    make(map[string]string),
+		},
+		DNS: DNSConfig{
+			Names: make(map[string]DNSName),
+		},
+		ClientLimits:   defaultClientLimits,
+		Metrics:        defaultMetrics,
+		Revival:        defaultRevival,
+		PoolRevivals:   make(map[string]RevivalConfig),
+		Retry:          defaultRetry,
+		PoolRetries:    make(map[string]RetryConfig),
+		PoolWebSockets: make(map[string]WebSocketConfig),
+		Readiness: ReadinessConfig{
+			MinBackends: 1,
+		},
+		SystemLog: true,
+		Log:       logger.DefaultConfig(),
+		TLSReload: defaultTLSReload,
+		GSLB: GSLBConfig{
+			MinScore: 0.5,
+			Interval: 30 * time.Second,
+		},
 	}
 
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(r)
 	var currentUpstream string
 	isInsideUpstream := false
+	isInsideTracing := false
+	var currentListener *ListenerConfig
 
 	lineNum := 0
 	for scanner.Scan() {
@@ -76,10 +276,33 @@ func ParseConfig(filename string) (*Config, error) {
 		parts := strings.Fields(line)
 		directive := parts[0]
 
+		if isInsideTracing {
+			if directive == "}" {
+				isInsideTracing = false
+			} else if err := parseTracingDirective(&cfg.Tracing, parts); err != nil {
+				return nil, configError(lineNum, err)
+			}
+			continue
+		}
+
+		// Routes of a listener are parsed like the global ones, below
+		if currentListener != nil && directive != "route" {
+			if directive == "}" {
+				if currentListener.Listen == "" {
+					return nil, configErrorf(currentListener.Line, "listener %s requires a listen address", currentListener.Name)
+				}
+				cfg.Listeners = append(cfg.Listeners, *currentListener)
+				currentListener = nil
+			} else if err := parseListenerDirective(currentListener, parts); err != nil {
+				return nil, configError(lineNum, err)
+			}
+			continue
+		}
+
 		switch directive {
 		case "upstream":
 			if len(parts) < 2 {
-				return nil, fmt.Errorf("line %d: upstream directive requires a name", lineNum)
+				return nil, configErrorf(lineNum, "upstream directive requires a name")
 			}
 			currentUpstream = parts[1]
 			isInsideUpstream = true
@@ -89,45 +312,88 @@ func ParseConfig(filename string) (*Config, error) {
 
 		case "server":
 			if !isInsideUpstream {
-				return nil, fmt.Errorf("line %d: server directive must be inside an upstream block", lineNum)
+				return nil, configErrorf(lineNum, "server directive must be inside an upstream block")
 			}
 			if len(parts) < 2 {
-				return nil, fmt.Errorf("line %d: server directive requires an URL", lineNum)
+				return nil, configErrorf(lineNum, "server directive requires an URL")
 			}
 
-			backend := BackendConfig{URL: parts[1], Weight: 1, MaxConns: 0}
+			urls, err := expandServerURL(parts[1])
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+
+			backend := BackendConfig{Weight: 1, MaxConns: 0, Line: lineNum}
 
 			for i := 2; i < len(parts); i++ {
 				if strings.HasPrefix(parts[i], "weight=") {
 					weightStr := strings.TrimPrefix(parts[i], "weight=")
 					weight, err := strconv.Atoi(weightStr)
 					if err != nil {
-						return nil, fmt.Errorf("line %d: invalid weight: %s", lineNum, weightStr)
+						return nil, configErrorf(lineNum, "invalid weight: %s", weightStr)
 					}
 					backend.Weight = weight
 				} else if strings.HasPrefix(parts[i], "max_conn=") {
 					maxConnStr := strings.TrimPrefix(parts[i], "max_conn=")
 					maxConn, err := strconv.Atoi(maxConnStr)
 					if err != nil {
-						return nil, fmt.Errorf("line %d: invalid max_conn: %s", lineNum, maxConnStr)
+						return nil, configErrorf(lineNum, "invalid max_conn: %s", maxConnStr)
 					}
 					backend.MaxConns = maxConn
+				} else if strings.HasPrefix(parts[i], "max_ws=") {
+					maxWSStr := strings.TrimPrefix(parts[i], "max_ws=")
+					maxWS, err := strconv.Atoi(maxWSStr)
+					if err != nil || maxWS < 0 {
+						return nil, configErrorf(lineNum, "invalid max_ws: %s", maxWSStr)
+					}
+					backend.MaxWebSockets = maxWS
+				} else if strings.HasPrefix(parts[i], "host=") {
+					backend.Host = strings.TrimSuffix(strings.TrimPrefix(parts[i], "host="), ";")
+				} else if strings.HasPrefix(parts[i], "prefix=") {
+					prefix := strings.TrimSuffix(strings.TrimPrefix(parts[i], "prefix="), ";")
+					if !strings.HasPrefix(prefix, "/") || (&url.URL{Path: prefix}).EscapedPath() != prefix {
+						return nil, configErrorf(lineNum, "invalid prefix, expected a path like /v2: %s", prefix)
+					}
+					backend.Prefix = strings.TrimRight(prefix, "/")
+				} else if strings.HasPrefix(parts[i], "sni=") {
+					backend.ServerName = strings.TrimSuffix(strings.TrimPrefix(parts[i], "sni="), ";")
+				} else if strings.HasPrefix(parts[i], "zone=") {
+					backend.Zone = strings.TrimSuffix(strings.TrimPrefix(parts[i], "zone="), ";")
+				} else if strings.HasPrefix(parts[i], "priority=") {
+					priorityStr := strings.TrimSuffix(strings.TrimPrefix(parts[i], "priority="), ";")
+					priority, err := strconv.Atoi(priorityStr)
+					if err != nil || priority < 0 {
+						return nil, configErrorf(lineNum, "invalid priority: %s", priorityStr)
+					}
+					backend.Priority = priority
+				} else if strings.TrimSuffix(parts[i], ";") == "backup" {
+					backend.Priority = 1
+				} else if strings.HasPrefix(parts[i], "region=") {
+					backend.Region = strings.ToUpper(strings.TrimSuffix(strings.TrimPrefix(parts[i], "region="), ";"))
 				}
 			}
+			if backend.ServerName == "" {
+				backend.ServerName, _, _ = strings.Cut(backend.Host, ":")
+			}
+
+			// A templated URL declares a server for each of its expansions
+			for _, u := range urls {
+				backend.URL = u
 
-			// If this is the default backend pool, add to both
-			if currentUpstream == "backend" {
-				cfg.Backends = append(cfg.Backends, backend)
+				// If this is the default backend pool, add to both
+				if currentUpstream == "backend" {
+					cfg.Backends = append(cfg.Backends, backend)
+				}
+				// Add to the named backend pool
+				cfg.BackendPools[currentUpstream] = append(cfg.BackendPools[currentUpstream], backend)
 			}
-			// Add to the named backend pool
-			cfg.BackendPools[currentUpstream] = append(cfg.BackendPools[currentUpstream], backend)
 
 		case "}":
 			isInsideUpstream = false
 
 		case "method":
 			if len(parts) < 2 {
-				return nil, fmt.Errorf("line %d: method directive requires a value", lineNum)
+				return nil, configErrorf(lineNum, "method directive requires a value")
 			}
 
 			method := strings.ToLower(parts[1])
@@ -138,13 +404,15 @@ func ParseConfig(filename string) (*Config, error) {
 				cfg.Method = WeightedRoundRobin
 			case "least_connections", "least_conn":
 				cfg.Method = LeastConnections
+			case "geo", "geo_weighted":
+				cfg.Method = GeoWeighted
 			default:
-				return nil, fmt.Errorf("line %d: unknown load balancing method: %s", lineNum, method)
+				return nil, configErrorf(lineNum, "unknown load balancing method: %s", method)
 			}
 
 		case "persistence":
 			if len(parts) < 2 {
-				return nil, fmt.Errorf("line %d: persistence directive requires a method", lineNum)
+				return nil, configErrorf(lineNum, "persistence directive requires a method")
 			}
 
 			method := strings.ToLower(parts[1])
@@ -153,26 +421,101 @@ func ParseConfig(filename string) (*Config, error) {
 				cfg.PersistenceType = NoPersistence
 			case "cookie":
 				cfg.PersistenceType = CookiePersistence
-				if len(parts) > 2 {
-					for i := 2; i < len(parts); i++ {
-						if strings.HasPrefix(parts[i], "name=") {
-							cfg.PersistenceAttrs["cookie_name"] = strings.TrimPrefix(parts[i], "name=")
-						} else if strings.HasPrefix(parts[i], "ttl=") {
-							cfg.PersistenceAttrs["cookie_ttl"] = strings.TrimPrefix(parts[i], "ttl=")
-						}
+				for i := 2; i < len(parts); i++ {
+					key, value, _ := strings.Cut(strings.TrimSuffix(parts[i], ";"), "=")
+					switch key {
+					case "name", "ttl", "domain", "path", "samesite", "secure":
+						cfg.PersistenceAttrs["cookie_"+key] = value
+					case "rebalance", "max_sessions":
+						cfg.PersistenceAttrs[key] = value
+					default:
+						return nil, configErrorf(lineNum, "unknown cookie persistence option: %s", parts[i])
+					}
+				}
+				if _, err := parseSessionCookie(cfg.PersistenceAttrs); err != nil {
+					return nil, configError(lineNum, err)
+				}
+				if rebalance, ok := cfg.PersistenceAttrs["rebalance"]; ok {
+					if _, err := parseSessionRebalance(rebalance); err != nil {
+						return nil, configError(lineNum, err)
+					}
+				}
+				if maxSessions, ok := cfg.PersistenceAttrs["max_sessions"]; ok {
+					if _, err := parseMaxSessions(maxSessions); err != nil {
+						return nil, configError(lineNum, err)
 					}
 				}
 			case "ip_hash":
 				cfg.PersistenceType = IPHashPersistence
+				for i := 2; i < len(parts); i++ {
+					if strings.HasPrefix(parts[i], "rebalance=") {
+						rebalance := strings.TrimSuffix(strings.TrimPrefix(parts[i], "rebalance="), ";")
+						if _, err := parseSessionRebalance(rebalance); err != nil {
+							return nil, configError(lineNum, err)
+						}
+						cfg.PersistenceAttrs["rebalance"] = rebalance
+					} else if strings.HasPrefix(parts[i], "ttl=") {
+						ttl := strings.TrimSuffix(strings.TrimPrefix(parts[i], "ttl="), ";")
+						if d, err := time.ParseDuration(ttl); err != nil || d <= 0 {
+							return nil, configErrorf(lineNum, "invalid ip_hash session ttl: %s", ttl)
+						}
+						cfg.PersistenceAttrs["ip_ttl"] = ttl
+					} else if strings.HasPrefix(parts[i], "max_sessions=") {
+						maxSessions := strings.TrimSuffix(strings.TrimPrefix(parts[i], "max_sessions="), ";")
+						if _, err := parseMaxSessions(maxSessions); err != nil {
+							return nil, configError(lineNum, err)
+						}
+						cfg.PersistenceAttrs["max_sessions"] = maxSessions
+					}
+				}
 			case "consistent_hash":
 				cfg.PersistenceType = ConsistentHashPersistence
+				for i := 2; i < len(parts); i++ {
+					if strings.HasPrefix(parts[i], "max_hops=") {
+						cfg.PersistenceAttrs["max_hops"] = strings.TrimPrefix(parts[i], "max_hops=")
+					} else if strings.HasPrefix(parts[i], "bounded_load=") {
+						cfg.PersistenceAttrs["bounded_load"] = strings.TrimPrefix(parts[i], "bounded_load=")
+					}
+				}
+			case "fingerprint":
+				cfg.PersistenceType = FingerprintPersistence
+			case "upload_session":
+				cfg.PersistenceType = UploadSessionPersistence
+				for i := 2; i < len(parts); i++ {
+					if strings.HasPrefix(parts[i], "header=") {
+						cfg.PersistenceAttrs["upload_header"] = strings.TrimPrefix(parts[i], "header=")
+					} else if strings.HasPrefix(parts[i], "query=") {
+						cfg.PersistenceAttrs["upload_query"] = strings.TrimPrefix(parts[i], "query=")
+					} else if strings.HasPrefix(parts[i], "ttl=") {
+						cfg.PersistenceAttrs["upload_ttl"] = strings.TrimPrefix(parts[i], "ttl=")
+					}
+				}
 			default:
-				return nil, fmt.Errorf("line %d: unknown persistence method: %s", lineNum, method)
+				custom, ok := LookupPersistenceMethod(method)
+				if !ok {
+					return nil, configErrorf(lineNum, "unknown persistence method: %s", method)
+				}
+				cfg.PersistenceType = custom
+
+				// Pass key=value arguments through to the custom provider
+				for i := 2; i < len(parts); i++ {
+					if key, value, found := strings.Cut(parts[i], "="); found {
+						cfg.PersistenceAttrs[key] = value
+					}
+				}
 			}
 
 		case "route":
+			// The expression of an expr route is quoted and may hold spaces
+			if len(parts) > 2 && strings.ToLower(parts[1]) == "expr" {
+				expr, rest, err := cutQuotedExpr(line)
+				if err != nil {
+					return nil, configError(lineNum, err)
+				}
+				parts = append([]string{parts[0], parts[1], expr}, rest...)
+			}
 			if len(parts) < 4 {
-				return nil, fmt.Errorf("line %d: route directive requires type, pattern, and backend", lineNum)
+				return nil, configErrorf(lineNum, "route directive requires type, pattern, and backend")
 			}
 
 			routeType := strings.ToLower(parts[1])
@@ -196,7 +539,7 @@ func ParseConfig(filename string) (*Config, error) {
 				}
 			case "header":
 				if len(parts) < 5 {
-					return nil, fmt.Errorf("line %d: header route requires name, value, and backend", lineNum)
+					return nil, configErrorf(lineNum, "header route requires name, value, and backend")
 				}
 				routeConfig = RouteConfig{
 					Type:        HeaderRoute,
@@ -205,26 +548,779 @@ func ParseConfig(filename string) (*Config, error) {
 					HeaderValue: parts[3],
 					BackendPool: parts[4],
 				}
+			case "expr":
+				expr, err := compileRouteExpr(pattern)
+				if err != nil {
+					return nil, configErrorf(lineNum, "invalid route expression: %v", err)
+				}
+				routeConfig = RouteConfig{
+					Type:        ExprRoute,
+					Pattern:     pattern,
+					BackendPool: backendPool,
+					expr:        expr,
+				}
+			case "geo":
+				geo, err := parseGeoRoute(pattern)
+				if err != nil {
+					return nil, configError(lineNum, err)
+				}
+				routeConfig = RouteConfig{
+					Type:        GeoRoute,
+					Pattern:     pattern,
+					BackendPool: backendPool,
+					geo:         geo,
+				}
 			default:
-				return nil, fmt.Errorf("line %d: unknown route type: %s", lineNum, routeType)
+				return nil, configErrorf(lineNum, "unknown route type: %s", routeType)
+			}
+
+			routeConfig.Line = lineNum
+
+			// A split route lists pools with their percentages instead of a pool
+			options := parts[4:]
+			poolIndex := 3
+			if routeConfig.Type == HeaderRoute {
+				poolIndex = 4
+			}
+			if parts[poolIndex] == "split" {
+				split, used, err := parseSplit(parts[poolIndex+1:])
+				if err != nil {
+					return nil, configError(lineNum, err)
+				}
+				routeConfig.Split = split
+				routeConfig.BackendPool = split.Pools[0]
+				options = parts[poolIndex+1+used:]
+			}
+			if strings.HasPrefix(routeConfig.BackendPool, staticPrefix) {
+				routeConfig.Static = StaticConfig{
+					Root:   strings.TrimPrefix(routeConfig.BackendPool, staticPrefix),
+					MaxAge: defaultStaticMaxAge,
+				}
+				if routeConfig.Static.Root == "" {
+					return nil, configErrorf(lineNum, "static route requires a directory")
+				}
+			}
+
+			canaryKey := ""
+			for _, part := range options {
+				if strings.HasPrefix(part, "canary=") {
+					canary, err := parseCanary(strings.TrimPrefix(part, "canary="))
+					if err != nil {
+						return nil, configError(lineNum, err)
+					}
+					routeConfig.Canary = canary
+				} else if strings.HasPrefix(part, "canary_key=") {
+					canaryKey = strings.TrimPrefix(part, "canary_key=")
+					if !validUserKey(canaryKey) {
+						return nil, configErrorf(lineNum, "invalid canary key: %s", canaryKey)
+					}
+				} else if strings.HasPrefix(part, "sticky=") {
+					sticky := strings.TrimPrefix(part, "sticky=")
+					if sticky != "cookie" && !strings.HasPrefix(sticky, "cookie:") {
+						return nil, configErrorf(lineNum, "invalid sticky option, expected cookie or cookie:<name>: %s", sticky)
+					}
+					routeConfig.Split.Cookie = "GOLB_SPLIT"
+					if name := strings.TrimPrefix(sticky, "cookie:"); name != sticky && name != "" {
+						routeConfig.Split.Cookie = name
+					}
+				} else if strings.HasPrefix(part, "split_key=") {
+					routeConfig.Split.Key = strings.TrimPrefix(part, "split_key=")
+					if !validUserKey(routeConfig.Split.Key) {
+						return nil, configErrorf(lineNum, "invalid split key: %s", routeConfig.Split.Key)
+					}
+				} else if strings.HasPrefix(part, "green=") {
+					routeConfig.Green = strings.TrimPrefix(part, "green=")
+				} else if strings.HasPrefix(part, "cache=") {
+					routeConfig.Cache = strings.TrimPrefix(part, "cache=")
+				} else if strings.HasPrefix(part, "auth=") {
+					routeConfig.Auth = strings.TrimPrefix(part, "auth=")
+				} else if strings.HasPrefix(part, "error_page=") {
+					routeConfig.ErrorPage = strings.TrimPrefix(part, "error_page=")
+				} else if strings.HasPrefix(part, "transform=") {
+					routeConfig.Transform = strings.TrimPrefix(part, "transform=")
+				} else if strings.HasPrefix(part, "limit=") {
+					routeConfig.RateLimit = strings.TrimPrefix(part, "limit=")
+				} else if strings.HasPrefix(part, "request_schema=") {
+					routeConfig.RequestSchema = strings.TrimPrefix(part, "request_schema=")
+				} else if strings.HasPrefix(part, "response_schema=") {
+					routeConfig.ResponseSchema = strings.TrimPrefix(part, "response_schema=")
+				} else if strings.HasPrefix(part, "middlewares=") {
+					routeConfig.Middlewares = strings.Split(strings.TrimPrefix(part, "middlewares="), ",")
+				} else if strings.HasPrefix(part, "streaming=") {
+					switch value := strings.TrimPrefix(part, "streaming="); value {
+					case "on", "off":
+						routeConfig.Streaming = value == "on"
+					default:
+						return nil, configErrorf(lineNum, "invalid streaming option, expected on or off: %s", value)
+					}
+				} else if strings.HasPrefix(part, "max_age=") {
+					if routeConfig.Static.Root == "" {
+						return nil, configErrorf(lineNum, "max_age requires a static route")
+					}
+					maxAge, err := time.ParseDuration(strings.TrimPrefix(part, "max_age="))
+					if err != nil || maxAge < 0 {
+						return nil, configErrorf(lineNum, "invalid static route max_age: %s", strings.TrimPrefix(part, "max_age="))
+					}
+					routeConfig.Static.MaxAge = maxAge
+				} else if strings.HasPrefix(part, "class=") {
+					routeConfig.Class = strings.TrimPrefix(part, "class=")
+					if !validRouteClass(routeConfig.Class) {
+						return nil, configErrorf(lineNum, "invalid route class, expected low, normal or critical: %s", routeConfig.Class)
+					}
+				} else if strings.HasPrefix(part, "timeout=") {
+					timeoutStr := strings.TrimPrefix(part, "timeout=")
+					timeout, err := time.ParseDuration(timeoutStr)
+					if err != nil || timeout <= 0 {
+						return nil, configErrorf(lineNum, "invalid route timeout: %s", timeoutStr)
+					}
+					routeConfig.Timeout = timeout
+				}
+			}
+
+			if canaryKey != "" && routeConfig.Canary.Pool != "" {
+				routeConfig.Canary.Key = canaryKey
 			}
+			if routeConfig.Split.Cookie != "" && len(routeConfig.Split.Pools) == 0 {
+				return nil, configErrorf(lineNum, "sticky requires a split route")
+			}
+			// Both hold the whole response before sending it
+			if routeConfig.Streaming && (routeConfig.Cache != "" || routeConfig.ResponseSchema != "") {
+				return nil, configErrorf(lineNum, "streaming routes cannot be cached or have a response schema")
+			}
+			if routeConfig.Streaming && routeConfig.Transform != "" {
+				return nil, configErrorf(lineNum, "streaming routes cannot have a response transform")
+			}
+			if routeConfig.Streaming && routeConfig.Timeout > 0 {
+				return nil, configErrorf(lineNum, "streaming routes have no deadline and cannot have a timeout")
+			}
+			if routeConfig.Split.Key != "" && len(routeConfig.Split.Pools) == 0 {
+				return nil, configErrorf(lineNum, "split_key requires a split route")
+			}
+			if routeConfig.Split.Key != "" && routeConfig.Split.Cookie == "" {
+				// Clients keep their bucket when their key changes, e.g. on login
+				routeConfig.Split.Cookie = "GOLB_SPLIT"
+			}
+			if routeConfig.Green != "" && len(routeConfig.Split.Pools) > 0 {
+				return nil, configErrorf(lineNum, "a split route cannot have a green pool")
+			}
+			if routeConfig.Static.Root != "" && (routeConfig.Canary.Pool != "" || routeConfig.Green != "") {
+				return nil, configErrorf(lineNum, "static routes cannot have a canary or green pool")
+			}
+
+			if currentListener != nil {
+				currentListener.Routes = append(currentListener.Routes, routeConfig)
+			} else {
+				cfg.Routes = append(cfg.Routes, routeConfig)
+			}
+
+		case "set_header", "add_header", "remove_header",
+			"set_response_header", "add_response_header", "hide_header":
+			action, response, err := parseHeaderDirective(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+
+			// Header rules inside an upstream block only apply to that pool
+			rules := cfg.Headers
+			if isInsideUpstream {
+				rules = cfg.PoolHeaders[currentUpstream]
+			}
+			if response {
+				rules.Response = append(rules.Response, action)
+			} else {
+				rules.Request = append(rules.Request, action)
+			}
+			if isInsideUpstream {
+				cfg.PoolHeaders[currentUpstream] = rules
+			} else {
+				cfg.Headers = rules
+			}
+
+		case "conn_rate_limit":
+			if len(parts) < 2 {
+				return nil, configErrorf(lineNum, "conn_rate_limit directive requires a rate")
+			}
+			rate, err := parseRate(parts[1])
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.ConnRateLimit = rate
+
+			for i := 2; i < len(parts); i++ {
+				if strings.HasPrefix(parts[i], "burst=") {
+					burstStr := strings.TrimPrefix(parts[i], "burst=")
+					burst, err := strconv.Atoi(burstStr)
+					if err != nil || burst <= 0 {
+						return nil, configErrorf(lineNum, "invalid burst: %s", burstStr)
+					}
+					cfg.ConnRateBurst = burst
+				}
+			}
+
+		case "client_timeouts":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "client_timeouts directive must not be inside an upstream block")
+			}
+			if err := parseClientTimeouts(parts, &cfg.ClientLimits); err != nil {
+				return nil, configError(lineNum, err)
+			}
+
+		case "client_max_header_size":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "client_max_header_size directive must not be inside an upstream block")
+			}
+			if len(parts) < 2 {
+				return nil, configErrorf(lineNum, "client_max_header_size directive requires a size")
+			}
+			sizeStr := strings.TrimSuffix(parts[1], ";")
+			size, err := parseByteSize(sizeStr)
+			if err != nil || size <= 0 || size > 1<<30 {
+				return nil, configErrorf(lineNum, "invalid client_max_header_size: %s", sizeStr)
+			}
+			cfg.ClientLimits.MaxHeaderBytes = int(size)
+
+		case "client_conn_limit":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "client_conn_limit directive must not be inside an upstream block")
+			}
+			if len(parts) < 2 {
+				return nil, configErrorf(lineNum, "client_conn_limit directive requires a number of connections")
+			}
+			limitStr := strings.TrimSuffix(parts[1], ";")
+			limit, err := strconv.Atoi(limitStr)
+			if err != nil || limit <= 0 {
+				return nil, configErrorf(lineNum, "invalid client_conn_limit: %s", limitStr)
+			}
+			cfg.ClientLimits.MaxConnsPerIP = limit
+
+		case "block":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "block directive must not be inside an upstream block")
+			}
+			rule, err := parseBlockRule(line)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.BlockRules = append(cfg.BlockRules, rule)
+
+		case "routing_override":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "routing_override directive must not be inside an upstream block")
+			}
+			override, err := parseRoutingOverride(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.RoutingOverride = override
+
+		case "rate_limit":
+			limit, err := parseRateLimit(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			if isInsideUpstream {
+				limit.Name = "rate_limit:" + currentUpstream
+				cfg.PoolRateLimits[currentUpstream] = limit
+			} else {
+				limit.Name = "rate_limit"
+				cfg.RateLimit = limit
+			}
+
+		case "limit":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "limit directive must not be inside an upstream block")
+			}
+			policy, err := parseLimitPolicy(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.LimitPolicies[policy.Policy] = policy
+
+		case "cache":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "cache directive must not be inside an upstream block")
+			}
+			zone, err := parseCacheZone(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.CacheZones[zone.Name] = zone
+
+		case "auth":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "auth directive must not be inside an upstream block")
+			}
+			auth, err := parseAuth(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.AuthPolicies[auth.Name] = auth
 
-			cfg.Routes = append(cfg.Routes, routeConfig)
+		case "error_page":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "error_page directive must not be inside an upstream block")
+			}
+			page, err := parseErrorPage(line)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.ErrorPages[page.Name] = page
+
+		case "response_transform":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "response_transform directive must not be inside an upstream block")
+			}
+			transform, err := parseResponseTransform(line, cfg.Transforms)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.Transforms[transform.Name] = transform
+
+		case "plugin":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "plugin directive must not be inside an upstream block")
+			}
+			plugin, err := parsePlugin(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.Plugins[plugin.Name] = plugin
+
+		case "geoip":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "geoip directive must not be inside an upstream block")
+			}
+			if len(parts) < 2 {
+				return nil, configErrorf(lineNum, "geoip directive requires a database file")
+			}
+			db, err := OpenGeoIPDatabase(parts[1])
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.GeoIP = db
+
+		case "queue":
+			if !isInsideUpstream {
+				return nil, configErrorf(lineNum, "queue directive must be inside an upstream block")
+			}
+			queue, err := parseQueue(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			queue.Name = "queue:" + currentUpstream
+			cfg.PoolQueues[currentUpstream] = queue
+
+		case "anti_affinity":
+			if !isInsideUpstream {
+				return nil, configErrorf(lineNum, "anti_affinity directive must be inside an upstream block")
+			}
+			if len(parts) < 2 {
+				return nil, configErrorf(lineNum, "anti_affinity directive requires on or off")
+			}
+			switch value := strings.TrimSuffix(parts[1], ";"); value {
+			case "on", "off":
+				cfg.PoolAntiAffinity[currentUpstream] = value == "on"
+			default:
+				return nil, configErrorf(lineNum, "invalid anti_affinity value: %s", value)
+			}
+
+		case "rewrite_redirects":
+			if !isInsideUpstream {
+				return nil, configErrorf(lineNum, "rewrite_redirects directive must be inside an upstream block")
+			}
+			if len(parts) < 2 {
+				return nil, configErrorf(lineNum, "rewrite_redirects directive requires on or off")
+			}
+			switch value := strings.TrimSuffix(parts[1], ";"); value {
+			case "on", "off":
+				cfg.PoolRedirects[currentUpstream] = value == "on"
+			default:
+				return nil, configErrorf(lineNum, "invalid rewrite_redirects value: %s", value)
+			}
+
+		case "min_alive":
+			if !isInsideUpstream {
+				return nil, configErrorf(lineNum, "min_alive directive must be inside an upstream block")
+			}
+			if len(parts) < 2 {
+				return nil, configErrorf(lineNum, "min_alive directive requires a number of backends")
+			}
+			minAliveStr := strings.TrimSuffix(parts[1], ";")
+			minAlive, err := strconv.Atoi(minAliveStr)
+			if err != nil || minAlive <= 0 {
+				return nil, configErrorf(lineNum, "invalid min_alive: %s", minAliveStr)
+			}
+			cfg.PoolMinAlive[currentUpstream] = minAlive
+
+		case "compat":
+			if !isInsideUpstream {
+				return nil, configErrorf(lineNum, "compat directive must be inside an upstream block")
+			}
+			compat, err := parseCompat(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.PoolCompat[currentUpstream] = compat
+
+		case "websocket":
+			if !isInsideUpstream {
+				return nil, configErrorf(lineNum, "websocket directive must be inside an upstream block")
+			}
+			limits, err := parseWebSocketLimits(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.PoolWebSockets[currentUpstream] = limits
+
+		case "pool_limit":
+			if !isInsideUpstream {
+				return nil, configErrorf(lineNum, "pool_limit directive must be inside an upstream block")
+			}
+			limit, err := parsePoolLimit(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			limit.Name = "pool_limit:" + currentUpstream
+			cfg.PoolLimits[currentUpstream] = limit
+
+		case "keepalive_probe":
+			keepAlive, err := parseKeepAlive(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.KeepAlive = keepAlive
+
+		case "preconnect":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "preconnect directive must be outside upstream blocks")
+			}
+			preconnect, err := parsePreconnect(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.Preconnect = preconnect
+
+		case "websocket_drain_timeout":
+			if len(parts) < 2 {
+				return nil, configErrorf(lineNum, "websocket_drain_timeout directive requires a duration")
+			}
+			timeout, err := time.ParseDuration(parts[1])
+			if err != nil || timeout < 0 {
+				return nil, configErrorf(lineNum, "invalid websocket_drain_timeout: %s", parts[1])
+			}
+			cfg.WebSocketDrain = timeout
+
+		case "deregister_after":
+			if len(parts) < 2 {
+				return nil, configErrorf(lineNum, "deregister_after directive requires a duration")
+			}
+			after, err := time.ParseDuration(parts[1])
+			if err != nil || after <= 0 {
+				return nil, configErrorf(lineNum, "invalid deregister_after: %s", parts[1])
+			}
+			cfg.DeregisterAfter = after
+
+		case "compression":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "compression directive must not be inside an upstream block")
+			}
+			compression, err := parseCompression(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.Compression = compression
+
+		case "access_log":
+			if len(parts) < 2 {
+				return nil, configErrorf(lineNum, "access_log directive requires a path")
+			}
+			cfg.AccessLog.Path = strings.TrimSuffix(parts[1], ";")
+
+		case "gslb":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "gslb directive must not be inside an upstream block")
+			}
+			gslb, err := parseGSLB(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.GSLB = gslb
+
+		case "readiness":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "readiness directive must not be inside an upstream block")
+			}
+			readiness, err := parseReadiness(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.Readiness = readiness
+
+		case "revive":
+			revive, err := parseRevival(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			if isInsideUpstream {
+				cfg.PoolRevivals[currentUpstream] = revive
+			} else {
+				cfg.Revival = revive
+			}
+
+		case "timeout":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "timeout directive must not be inside an upstream block")
+			}
+			if len(parts) < 2 {
+				return nil, configErrorf(lineNum, "timeout directive requires a duration")
+			}
+			timeoutStr := strings.TrimSuffix(parts[1], ";")
+			timeout, err := time.ParseDuration(timeoutStr)
+			if err != nil || timeout <= 0 {
+				return nil, configErrorf(lineNum, "invalid timeout: %s", timeoutStr)
+			}
+			cfg.RequestTimeout = timeout
+
+		case "retries":
+			retry, err := parseRetry(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			if isInsideUpstream {
+				cfg.PoolRetries[currentUpstream] = retry
+			} else {
+				cfg.Retry = retry
+			}
+
+		case "system_log":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "system_log directive must not be inside an upstream block")
+			}
+			if len(parts) < 2 {
+				return nil, configErrorf(lineNum, "system_log directive requires on or off")
+			}
+			switch value := strings.TrimSuffix(parts[1], ";"); value {
+			case "on", "off":
+				cfg.SystemLog = value == "on"
+			default:
+				return nil, configErrorf(lineNum, "invalid system_log value: %s", value)
+			}
+
+		case "metrics":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "metrics directive must not be inside an upstream block")
+			}
+			metrics, err := parseMetrics(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.Metrics = metrics
+
+		case "overload":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "overload directive must not be inside an upstream block")
+			}
+			overload, err := parseOverload(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.Overload = overload
+
+		case "dns_refresh":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "dns_refresh directive must not be inside an upstream block")
+			}
+			dnsRefresh, err := parseDNSRefresh(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.DNSRefresh = dnsRefresh
+
+		case "statsd":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "statsd directive must not be inside an upstream block")
+			}
+			statsd, err := parseStatsd(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.Statsd = statsd
+
+		case "log":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "log directive must not be inside an upstream block")
+			}
+			if err := parseLog(parts, &cfg.Log); err != nil {
+				return nil, configError(lineNum, err)
+			}
+
+		case "drain_signal":
+			drain, err := parseDrainSignal(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.DrainSignal = drain
+
+		case "dns_listen":
+			if len(parts) < 2 {
+				return nil, configErrorf(lineNum, "dns_listen directive requires an address")
+			}
+			cfg.DNS.Listen = parts[1]
+
+		case "dns_name":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "dns_name directive must not be inside an upstream block")
+			}
+			name, record, err := parseDNSName(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.DNS.Names[name] = record
+
+		case "trusted_proxies":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "trusted_proxies directive must not be inside an upstream block")
+			}
+			networks, err := parseTrustedProxies(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.RealIP.TrustedProxies = append(cfg.RealIP.TrustedProxies, networks...)
+
+		case "real_ip_headers":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "real_ip_headers directive must not be inside an upstream block")
+			}
+			headers, err := parseRealIPHeaders(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.RealIP.Headers = headers
+
+		case "sni_listen":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "sni_listen directive must not be inside an upstream block")
+			}
+			if len(parts) < 2 {
+				return nil, configErrorf(lineNum, "sni_listen directive requires an address")
+			}
+			cfg.SNI.Listen = strings.TrimSuffix(parts[1], ";")
+
+		case "sni_route":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "sni_route directive must not be inside an upstream block")
+			}
+			route, err := parseSNIRoute(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			for _, existing := range cfg.SNI.Routes {
+				if existing.Name == route.Name {
+					return nil, configErrorf(lineNum, "duplicate sni route: %s", route.Name)
+				}
+			}
+			route.Line = lineNum
+			cfg.SNI.Routes = append(cfg.SNI.Routes, route)
+
+		case "listener":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "listener block must not be inside an upstream block")
+			}
+			if len(parts) < 2 || parts[1] == "{" {
+				return nil, configErrorf(lineNum, "listener directive requires a name")
+			}
+			for _, existing := range cfg.Listeners {
+				if existing.Name == parts[1] {
+					return nil, configErrorf(lineNum, "duplicate listener: %s", parts[1])
+				}
+			}
+			currentListener = &ListenerConfig{Name: parts[1], Line: lineNum}
+
+		case "tracing":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "tracing block must not be inside an upstream block")
+			}
+			isInsideTracing = true
+			cfg.Tracing.Enabled = true
+
+		case "failover":
+			failover, err := parseFailover(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.Failovers[failover.Pools[0]] = failover
+
+		case "mirror":
+			if isInsideUpstream {
+				return nil, configErrorf(lineNum, "mirror directive must not be inside an upstream block")
+			}
+			mirror, err := parseMirror(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.Mirrors[mirror.Pool] = mirror
+
+		case "subset":
+			if !isInsideUpstream {
+				return nil, configErrorf(lineNum, "subset directive must be inside an upstream block")
+			}
+			subset, err := parseSubset(parts)
+			if err != nil {
+				return nil, configError(lineNum, err)
+			}
+			cfg.PoolSubsets[currentUpstream] = subset
+
+		case "tls_certificate":
+			if len(parts) < 3 {
+				return nil, configErrorf(lineNum, "tls_certificate directive requires a certificate and key file")
+			}
+			// The first certificate is served to clients no other matches
+			if cfg.TLSCertFile == "" {
+				cfg.TLSCertFile = parts[1]
+				cfg.TLSKeyFile = parts[2]
+			}
+			cfg.TLSCertificates = append(cfg.TLSCertificates, TLSCertificateConfig{
+				CertFile: parts[1],
+				KeyFile:  strings.TrimSuffix(parts[2], ";"),
+			})
+
+		case "tls_reload":
+			if len(parts) < 2 {
+				return nil, configErrorf(lineNum, "tls_reload directive requires an interval or off")
+			}
+			if value := strings.TrimSuffix(parts[1], ";"); value == "off" {
+				cfg.TLSReload = 0
+			} else {
+				interval, err := time.ParseDuration(value)
+				if err != nil || interval <= 0 {
+					return nil, configErrorf(lineNum, "invalid tls_reload interval: %s", value)
+				}
+				cfg.TLSReload = interval
+			}
 
 		case "default_backend":
 			if len(parts) < 2 {
-				return nil, fmt.Errorf("line %d: default_backend directive requires a backend pool name", lineNum)
+				return nil, configErrorf(lineNum, "default_backend directive requires a backend pool name")
 			}
 			cfg.DefaultBackend = parts[1]
 
 		default:
-			return nil, fmt.Errorf("line %d: unknown directive: %s", lineNum, directive)
+			return nil, configErrorf(lineNum, "unknown directive: %s", directive)
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
 		return nil, err
 	}
+	if currentListener != nil {
+		return nil, configErrorf(currentListener.Line, "listener %s is not closed", currentListener.Name)
+	}
+
+	// Limit policies, cache zones, auth policies, plugins and the GeoIP
+	// database may be referenced before they are defined
+	if err := cfg.resolveLimitPolicies(); err != nil {
+		return nil, err
+	}
 
 	// If no default backend specified, use "backend" if available
 	if cfg.DefaultBackend == "" {
@@ -237,9 +1333,65 @@ func ParseConfig(filename string) (*Config, error) {
 				break
 			}
 		} else {
-			return nil, fmt.Errorf("no backend pools defined in configuration")
+			return nil, ErrInvalidConfig{Message: "no backend pools defined in configuration"}
 		}
 	}
 
 	return cfg, nil
 }
+
+// allRoutes returns the global routes followed by the routes of every
+// listener
+func (c *Config) allRoutes() []RouteConfig {
+	routes := append([]RouteConfig(nil), c.Routes...)
+	for _, listener := range c.Listeners {
+		routes = append(routes, listener.Routes...)
+	}
+	return routes
+}
+
+// resolveLimitPolicies replaces references to limit policies with their
+// settings and checks that the cache zones and auth policies routes refer to
+// exist
+func (c *Config) resolveLimitPolicies() error {
+	var err error
+
+	if c.RateLimit, err = resolveRateLimit(c.RateLimit, c.LimitPolicies); err != nil {
+		return err
+	}
+	for pool, limit := range c.PoolRateLimits {
+		if c.PoolRateLimits[pool], err = resolveRateLimit(limit, c.LimitPolicies); err != nil {
+			return err
+		}
+	}
+	for _, route := range c.allRoutes() {
+		if _, ok := c.LimitPolicies[route.RateLimit]; route.RateLimit != "" && !ok {
+			return configErrorf(route.Line, "unknown limit policy: %s", route.RateLimit)
+		}
+		if _, ok := c.CacheZones[route.Cache]; route.Cache != "" && !ok {
+			return configErrorf(route.Line, "unknown cache zone: %s", route.Cache)
+		}
+		if _, ok := c.AuthPolicies[route.Auth]; route.Auth != "" && !ok {
+			return configErrorf(route.Line, "unknown auth policy: %s", route.Auth)
+		}
+		if _, ok := c.ErrorPages[route.ErrorPage]; route.ErrorPage != "" && !ok {
+			return configErrorf(route.Line, "unknown error page: %s", route.ErrorPage)
+		}
+		if _, ok := c.Transforms[route.Transform]; route.Transform != "" && !ok {
+			return configErrorf(route.Line, "unknown response transform: %s", route.Transform)
+		}
+		for _, name := range route.Middlewares {
+			if _, ok := c.lookupMiddleware(name); !ok {
+				return configErrorf(route.Line, "unknown middleware: %s", name)
+			}
+		}
+		if route.Type == GeoRoute {
+			if c.GeoIP == nil {
+				return configErrorf(route.Line, "geo route requires a geoip database")
+			}
+			route.geo.db = c.GeoIP
+		}
+	}
+
+	return nil
+}
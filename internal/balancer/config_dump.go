@@ -0,0 +1,308 @@
+package balancer
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync/atomic"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+)
+
+// EffectiveConfig is the configuration the load balancer runs with: the
+// configuration file as resolved when it was loaded, with the values changed
+// since through the admin API or by backend health
+type EffectiveConfig struct {
+	DefaultPool string            `json:"defaultPool"`
+	Pools       []EffectivePool   `json:"pools"`
+	Routes      []EffectiveRoute  `json:"routes"`
+	Timeouts    EffectiveTimeouts `json:"timeouts"`
+	Features    map[string]bool   `json:"features"`
+	LogLevel    string            `json:"logLevel"`
+}
+
+// EffectivePool is a backend pool as it runs
+type EffectivePool struct {
+	Name        string `json:"name"`
+	Method      string `json:"method"`
+	Persistence string `json:"persistence"`
+	// Failover lists the pools the pool fails over to, in order
+	Failover []string `json:"failover,omitempty"`
+	// MirrorTo is the shadow pool the pool's requests are copied to
+	MirrorTo string `json:"mirrorTo,omitempty"`
+	// AntiAffinity is set when retries avoid the zones of failed backends
+	AntiAffinity bool `json:"antiAffinity"`
+	// RewriteRedirects is set when redirects to the backends are pointed
+	// back at the balancer
+	RewriteRedirects bool `json:"rewriteRedirects,omitempty"`
+	// Revive holds the revive settings of the pool when it has its own
+	Revive string `json:"revive,omitempty"`
+	// WebSocket holds the WebSocket limits of the pool when it has its own
+	WebSocket string `json:"webSocket,omitempty"`
+	// Retries holds the retry settings of the pool when it has its own
+	Retries  string             `json:"retries,omitempty"`
+	Backends []EffectiveBackend `json:"backends"`
+}
+
+// EffectiveBackend is a backend as it runs
+type EffectiveBackend struct {
+	URL           string `json:"url"`
+	Weight        int    `json:"weight"`
+	MaxConns      int32  `json:"maxConns,omitempty"`
+	MaxWebSockets int32  `json:"maxWebSockets,omitempty"`
+	Host          string `json:"host,omitempty"`
+	ServerName    string `json:"serverName,omitempty"`
+	Prefix        string `json:"prefix,omitempty"`
+	Zone          string `json:"zone,omitempty"`
+	Region        string `json:"region,omitempty"`
+	Priority      int    `json:"priority,omitempty"`
+	Alive         bool   `json:"alive"`
+	Draining      bool   `json:"draining"`
+}
+
+// EffectiveRoute is a route as it runs
+type EffectiveRoute struct {
+	// Listener is the listener block of the route, empty for global routes
+	Listener    string             `json:"listener,omitempty"`
+	Name        string             `json:"name"`
+	Line        int                `json:"line"`
+	Type        string             `json:"type"`
+	Pattern     string             `json:"pattern,omitempty"`
+	HeaderName  string             `json:"headerName,omitempty"`
+	HeaderValue string             `json:"headerValue,omitempty"`
+	Pool        string             `json:"pool"`
+	Static      string             `json:"static,omitempty"`
+	Canary      *CanaryConfig      `json:"canary,omitempty"`
+	Split       map[string]float64 `json:"split,omitempty"`
+	Green       string             `json:"green,omitempty"`
+	// ActivePool is the pool a blue/green route currently sends traffic to
+	ActivePool  string   `json:"activePool,omitempty"`
+	RateLimit   string   `json:"rateLimit,omitempty"`
+	Cache       string   `json:"cache,omitempty"`
+	Auth        string   `json:"auth,omitempty"`
+	ErrorPage   string   `json:"errorPage,omitempty"`
+	Transform   string   `json:"transform,omitempty"`
+	Middlewares []string `json:"middlewares,omitempty"`
+	Streaming   bool     `json:"streaming,omitempty"`
+	Timeout     string   `json:"timeout,omitempty"`
+}
+
+// EffectiveTimeouts holds the timeouts and delays in effect
+type EffectiveTimeouts struct {
+	WebSocketDrain   string  `json:"webSocketDrain"`
+	Request          string  `json:"request,omitempty"`
+	DeregisterAfter  string  `json:"deregisterAfter,omitempty"`
+	ReviveInitial    string  `json:"reviveInitial"`
+	ReviveMax        string  `json:"reviveMax"`
+	ReviveMultiplier float64 `json:"reviveMultiplier"`
+	ReviveJitter     float64 `json:"reviveJitter"`
+	// ReviveProbe is the path probed before reviving a backend, or off
+	ReviveProbe string `json:"reviveProbe"`
+	// DrainSignalRecheck is set when backends can ask to be drained
+	DrainSignalRecheck string `json:"drainSignalRecheck,omitempty"`
+	// ClientHeader, ClientRead, ClientWrite and ClientIdle are the timeouts
+	// of client connections, set unless disabled
+	ClientHeader string `json:"clientHeader,omitempty"`
+	ClientRead   string `json:"clientRead,omitempty"`
+	ClientWrite  string `json:"clientWrite,omitempty"`
+	ClientIdle   string `json:"clientIdle,omitempty"`
+}
+
+var routeTypeNames = map[RouteType]string{
+	PathRoute:   "path",
+	RegexRoute:  "regex",
+	HeaderRoute: "header",
+	ExprRoute:   "expr",
+	GeoRoute:    "geo",
+}
+
+// GetEffectiveConfig returns the configuration a strategy built from config
+// runs with
+func GetEffectiveConfig(lb LoadBalancerStrategy, config *Config) EffectiveConfig {
+	effective := EffectiveConfig{
+		DefaultPool: config.DefaultBackend,
+		Pools:       []EffectivePool{},
+		Routes:      []EffectiveRoute{},
+		Features:    GetFeatures(),
+		LogLevel:    logger.Level.String(),
+	}
+
+	for name, pool := range namedPools(lb) {
+		effective.Pools = append(effective.Pools, effectivePool(name, pool, config))
+	}
+	sort.Slice(effective.Pools, func(i, j int) bool { return effective.Pools[i].Name < effective.Pools[j].Name })
+
+	switches := blueGreenSwitches(lb)
+	listeners := make([]string, len(config.Routes))
+	for _, listener := range config.Listeners {
+		for range listener.Routes {
+			listeners = append(listeners, listener.Name)
+		}
+	}
+	for i, route := range config.allRoutes() {
+		er := EffectiveRoute{
+			Listener:    listeners[i],
+			Name:        routeName(route),
+			Line:        route.Line,
+			Type:        routeTypeNames[route.Type],
+			Pattern:     route.Pattern,
+			HeaderName:  route.HeaderName,
+			HeaderValue: route.HeaderValue,
+			Pool:        route.BackendPool,
+			Static:      route.Static.Root,
+			Green:       route.Green,
+			RateLimit:   route.RateLimit,
+			Cache:       route.Cache,
+			Auth:        route.Auth,
+			ErrorPage:   route.ErrorPage,
+			Transform:   route.Transform,
+			Middlewares: route.Middlewares,
+			Streaming:   route.Streaming,
+		}
+		if route.Timeout > 0 {
+			er.Timeout = route.Timeout.String()
+		}
+		if route.Canary.Pool != "" {
+			canary := route.Canary
+			er.Canary = &canary
+		}
+		if len(route.Split.Pools) > 0 {
+			er.Split = make(map[string]float64, len(route.Split.Pools))
+			for i, pool := range route.Split.Pools {
+				er.Split[pool] = route.Split.Percents[i]
+			}
+		}
+		if bg, ok := switches[er.Name]; ok {
+			er.ActivePool = bg.names[atomic.LoadInt32(&bg.active)]
+		}
+		effective.Routes = append(effective.Routes, er)
+	}
+
+	revive := revivalConfig()
+	effective.Timeouts = EffectiveTimeouts{
+		WebSocketDrain:   config.WebSocketDrain.String(),
+		ReviveInitial:    revive.Initial.String(),
+		ReviveMax:        revive.Max.String(),
+		ReviveMultiplier: revive.Multiplier,
+		ReviveJitter:     revive.Jitter,
+		ReviveProbe:      "off",
+	}
+	if revive.Probe {
+		effective.Timeouts.ReviveProbe = revive.ProbePath
+	}
+	if config.RequestTimeout > 0 {
+		effective.Timeouts.Request = config.RequestTimeout.String()
+	}
+	if config.DeregisterAfter > 0 {
+		effective.Timeouts.DeregisterAfter = config.DeregisterAfter.String()
+	}
+	if drain := drainSignal.Load(); drain != nil {
+		effective.Timeouts.DrainSignalRecheck = drain.Recheck.String()
+	}
+	if config.ClientLimits.HeaderTimeout > 0 {
+		effective.Timeouts.ClientHeader = config.ClientLimits.HeaderTimeout.String()
+	}
+	if config.ClientLimits.ReadTimeout > 0 {
+		effective.Timeouts.ClientRead = config.ClientLimits.ReadTimeout.String()
+	}
+	if config.ClientLimits.WriteTimeout > 0 {
+		effective.Timeouts.ClientWrite = config.ClientLimits.WriteTimeout.String()
+	}
+	if config.ClientLimits.IdleTimeout > 0 {
+		effective.Timeouts.ClientIdle = config.ClientLimits.IdleTimeout.String()
+	}
+
+	return effective
+}
+
+// effectivePool describes a pool from its running balancers
+func effectivePool(name string, pool LoadBalancerStrategy, config *Config) EffectivePool {
+	ep := EffectivePool{
+		Name:             name,
+		Persistence:      getPersistenceMethodName(NoPersistence),
+		AntiAffinity:     config.PoolAntiAffinity[name],
+		RewriteRedirects: config.PoolRedirects[name],
+		Backends:         []EffectiveBackend{},
+	}
+	if failover, ok := config.Failovers[name]; ok {
+		ep.Failover = failover.Pools[1:]
+	}
+	if mirror, ok := config.Mirrors[name]; ok {
+		ep.MirrorTo = mirror.Shadow
+	}
+	if revive, ok := config.PoolRevivals[name]; ok {
+		ep.Revive = revive.String()
+	}
+	if limits, ok := config.PoolWebSockets[name]; ok {
+		ep.WebSocket = limits.String()
+	}
+	if retry, ok := config.PoolRetries[name]; ok {
+		ep.Retries = retry.String()
+	}
+
+	own := ownStrategy(pool)
+	found := false
+	walkBalancers(own, func(balancer interface{}) {
+		pool, ok := balancer.(Pool)
+		if found || !ok {
+			return
+		}
+		found = true
+		ep.Method = pool.Method()
+		if persistence := pool.Persistence(); persistence != NoPersistence {
+			ep.Persistence = getPersistenceMethodName(persistence)
+		}
+	})
+
+	weights := strategyWeights(own)
+	for _, p := range strategyProcesses(own) {
+		ep.Backends = append(ep.Backends, EffectiveBackend{
+			URL:           p.URL.Redacted(),
+			Weight:        weights[p],
+			MaxConns:      p.MaxConns,
+			MaxWebSockets: p.MaxWebSockets,
+			Host:          p.Host,
+			ServerName:    p.ServerName,
+			Prefix:        p.Prefix,
+			Zone:          p.Zone,
+			Region:        p.Region,
+			Priority:      p.Priority,
+			Alive:         p.IsAlive(),
+			Draining:      p.IsDraining(),
+		})
+	}
+
+	return ep
+}
+
+// ownStrategy returns the strategy of a pool's own backends: a failover
+// chain stands in for its primary pool, whose backends alone belong to the
+// pool
+func ownStrategy(pool LoadBalancerStrategy) LoadBalancerStrategy {
+	own := pool
+	for {
+		switch typed := own.(type) {
+		case *FailoverChain:
+			return typed.members[0].lb
+		case strategyWrapper:
+			own = typed.Unwrap()
+		default:
+			return pool
+		}
+	}
+}
+
+// ConfigHandler serves the effective configuration as JSON, to tell where
+// the running configuration drifted from the configuration file
+func ConfigHandler(lb LoadBalancerStrategy, config *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(GetEffectiveConfig(lb, config))
+	}
+}
@@ -0,0 +1,261 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// QueueConfig holds the settings of the queue used when every backend of a
+// pool is at its connection limit
+type QueueConfig struct {
+	Size    int
+	Timeout time.Duration
+	// Warn is the share of the queue in use at which a warning is emitted
+	Warn float64
+	// Name identifies the queue in warnings
+	Name string
+	// Priority weighs the share of freed slots handed to each route class
+	Priority map[string]int
+}
+
+// RequestQueue holds requests waiting for a backend connection slot. Each
+// route class waits in its own line, and freed slots are shared between the
+// lines by the weights of their classes.
+type RequestQueue struct {
+	config  QueueConfig
+	waiting int32
+	warn    *softLimit
+
+	mu      sync.Mutex
+	lines   map[string][]*queueWaiter
+	credits map[string]int
+}
+
+// NewRequestQueue creates a request queue, or returns nil if queueing is disabled
+func NewRequestQueue(config QueueConfig) *RequestQueue {
+	if config.Size <= 0 {
+		return nil
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.Priority == nil {
+		config.Priority = defaultClassWeights
+	}
+	return &RequestQueue{
+		config:  config,
+		warn:    newSoftLimit(config.Name, config.Warn),
+		lines:   make(map[string][]*queueWaiter),
+		credits: make(map[string]int),
+	}
+}
+
+// Waiting returns the number of queued requests
+func (q *RequestQueue) Waiting() int {
+	if q == nil {
+		return 0
+	}
+	return int(atomic.LoadInt32(&q.waiting))
+}
+
+// wake hands a freed slot to one waiting request, if any
+func (q *RequestQueue) wake() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	q.wakeLocked()
+	q.mu.Unlock()
+}
+
+// parseQueue parses the arguments of a queue directive
+func parseQueue(parts []string) (QueueConfig, error) {
+	if len(parts) < 2 {
+		return QueueConfig{}, fmt.Errorf("queue directive requires a size")
+	}
+
+	size, err := strconv.Atoi(parts[1])
+	if err != nil || size < 0 {
+		return QueueConfig{}, fmt.Errorf("invalid queue size: %s", parts[1])
+	}
+
+	queue := QueueConfig{Size: size}
+
+	for i := 2; i < len(parts); i++ {
+		if strings.HasPrefix(parts[i], "timeout=") {
+			timeoutStr := strings.TrimPrefix(parts[i], "timeout=")
+			timeout, err := time.ParseDuration(timeoutStr)
+			if err != nil || timeout <= 0 {
+				return QueueConfig{}, fmt.Errorf("invalid queue timeout: %s", timeoutStr)
+			}
+			queue.Timeout = timeout
+		} else if strings.HasPrefix(parts[i], "warn=") {
+			warn, err := parseWarnThreshold(strings.TrimPrefix(parts[i], "warn="))
+			if err != nil {
+				return QueueConfig{}, err
+			}
+			queue.Warn = warn
+		} else if strings.HasPrefix(parts[i], "priority=") {
+			priority, err := parseClassWeights(strings.TrimPrefix(parts[i], "priority="))
+			if err != nil {
+				return QueueConfig{}, err
+			}
+			queue.Priority = priority
+		}
+	}
+
+	return queue, nil
+}
+
+// acquireProcess picks a backend and reserves a connection slot on it. When
+// every healthy backend is at its connection limit the request waits in the
+// queue, if one is configured, in the line of its route's class.
+func acquireProcess(queue *RequestQueue, processes []*Process, r *http.Request, pick func() *Process) (*Process, RejectReason) {
+	if reason := contextRejection(r); reason != "" {
+		return nil, reason
+	}
+	if p := tryAcquireProcess(r, processes, pick); p != nil {
+		return p, ""
+	}
+
+	if !anyAlive(processes) {
+		return nil, RejectNoBackend
+	}
+	if queue == nil {
+		return nil, RejectBackendsSaturated
+	}
+
+	waiting := atomic.AddInt32(&queue.waiting, 1)
+	queue.warn.observe(float64(waiting) / float64(queue.config.Size))
+	if waiting > int32(queue.config.Size) {
+		atomic.AddInt32(&queue.waiting, -1)
+		return nil, RejectQueueFull
+	}
+	defer func() {
+		waiting := atomic.AddInt32(&queue.waiting, -1)
+		queue.warn.observe(float64(waiting) / float64(queue.config.Size))
+	}()
+
+	class := requestClass(r)
+	waiter := queue.enqueue(class)
+	defer queue.leave(waiter)
+
+	// A slot may have been freed before the request joined its line
+	if p := tryAcquireProcess(r, processes, pick); p != nil {
+		return p, ""
+	}
+
+	deadline := time.NewTimer(queue.config.Timeout)
+	defer deadline.Stop()
+
+	// Poll as well for slots that free up without a release, such as when
+	// a backend recovers, leaving them to higher classes waiting
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-waiter.ready:
+			queue.rearm(waiter)
+		case <-ticker.C:
+			if queue.ahead(class) {
+				continue
+			}
+		case <-deadline.C:
+			return nil, RejectQueueTimeout
+		case <-r.Context().Done():
+			return nil, contextRejection(r)
+		}
+
+		if p := tryAcquireProcess(r, processes, pick); p != nil {
+			return p, ""
+		}
+		if !anyAlive(processes) {
+			return nil, RejectNoBackend
+		}
+	}
+}
+
+func tryAcquireProcess(r *http.Request, processes []*Process, pick func() *Process) *Process {
+	webSocket := IsWebSocketRequest(r)
+
+	// Another request may take the last slot between picking and acquiring,
+	// so try a few picks before giving up
+	for attempt := 0; attempt <= len(processes); attempt++ {
+		p := pick()
+		if p == nil {
+			return nil
+		}
+		if !p.TryAcquire() {
+			continue
+		}
+		// A WebSocket also takes one of the backend's WebSocket slots
+		if !webSocket || p.tryAcquireWebSocket() {
+			return p
+		}
+		p.DecrementConnections()
+	}
+	return nil
+}
+
+// releaseProcess frees the connection slot reserved by acquireProcess
+func releaseProcess(queue *RequestQueue, p *Process) {
+	p.DecrementConnections()
+	queue.wake()
+}
+
+// releaseOnce returns a function freeing the connection slot reserved by
+// acquireProcess the first time it is called. An attempt releases its slot
+// when its response is complete, or as soon as it fails so the backend does
+// not count the retry on another backend as one of its connections.
+func releaseOnce(queue *RequestQueue, p *Process) func() {
+	var released int32
+	return func() {
+		if atomic.CompareAndSwapInt32(&released, 0, 1) {
+			releaseProcess(queue, p)
+		}
+	}
+}
+
+func anyAlive(processes []*Process) bool {
+	for _, p := range processes {
+		if p.IsAlive() && !p.IsDraining() {
+			return true
+		}
+	}
+	return false
+}
+
+// rejectionMessage returns the message and HTTP status used for a reject reason
+func rejectionMessage(reason RejectReason) (string, int) {
+	switch reason {
+	case RejectBackendsSaturated:
+		return "All backends are at their connection limit", http.StatusServiceUnavailable
+	case RejectHashHopsExhausted:
+		return "No backend within max_hops of the key is available", http.StatusServiceUnavailable
+	case RejectQueueFull:
+		return "Request queue is full", http.StatusServiceUnavailable
+	case RejectQueueTimeout:
+		return "Timed out waiting for a backend", http.StatusServiceUnavailable
+	case RejectDeadlineExceeded:
+		return "Request deadline exceeded", http.StatusGatewayTimeout
+	case RejectCanceled:
+		return "Request canceled", http.StatusBadGateway
+	case RejectRetriesExhausted:
+		return "Backend failed and retries are exhausted", http.StatusBadGateway
+	default:
+		return "No healthy backends available", http.StatusServiceUnavailable
+	}
+}
+
+// setRequestQueue attaches a request queue to the balancer behind a strategy
+func setRequestQueue(strategy LoadBalancerStrategy, queue *RequestQueue) {
+	if ps, ok := strategy.(*PoolStrategy); ok {
+		ps.pool.Settings().Queue = queue
+	}
+}
@@ -0,0 +1,48 @@
+package balancer
+
+import (
+	"net"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// ConnThrottleListener limits the rate of new connections per client IP.
+// Connections over the limit are closed before any HTTP parsing happens.
+type ConnThrottleListener struct {
+	net.Listener
+	buckets *bucketSet
+}
+
+// NewConnThrottleListener wraps a listener with per-IP connection rate throttling.
+// The listener is returned unchanged if rate is not positive.
+func NewConnThrottleListener(l net.Listener, rate float64, burst int) net.Listener {
+	if rate <= 0 {
+		return l
+	}
+	return &ConnThrottleListener{
+		Listener: l,
+		buckets:  newBucketSet(rate, burst),
+	}
+}
+
+// Accept waits for the next connection from a client within its rate limit
+func (l *ConnThrottleListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+		if err != nil {
+			return conn, nil
+		}
+		if allowed, _, _ := l.buckets.take(ip); allowed {
+			return conn, nil
+		}
+
+		logger.Log.Debug("Connection throttled", zap.String("ip", ip))
+		conn.Close()
+	}
+}
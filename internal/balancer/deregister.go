@@ -0,0 +1,83 @@
+package balancer
+
+import (
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// Deregisterer removes backends that have been dead for longer than a set
+// time, such as decommissioned hosts left in the configuration. They are
+// no longer revived and tried, are left out of stats and health exports, and
+// are taken off consistent hash rings so lookups stop walking past them.
+// Restarting the load balancer registers them again.
+type Deregisterer struct {
+	lb    LoadBalancerStrategy
+	after time.Duration
+	stop  chan struct{}
+}
+
+// NewDeregisterer creates a deregisterer, or returns nil if the policy is
+// disabled
+func NewDeregisterer(lb LoadBalancerStrategy, after time.Duration) *Deregisterer {
+	if after <= 0 {
+		return nil
+	}
+	return &Deregisterer{
+		lb:    lb,
+		after: after,
+		stop:  make(chan struct{}),
+	}
+}
+
+// Start begins checking backends in the background
+func (d *Deregisterer) Start() {
+	if d == nil {
+		return
+	}
+
+	// Check often enough that a backend is removed soon after its time is up
+	interval := min(d.after/4, time.Minute)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				d.check()
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends checking
+func (d *Deregisterer) Stop() {
+	if d == nil {
+		return
+	}
+	close(d.stop)
+}
+
+func (d *Deregisterer) check() {
+	for _, p := range strategyProcesses(d.lb) {
+		deadFor := p.DeadFor()
+		if deadFor < d.after || !p.deregister() {
+			continue
+		}
+
+		walkBalancers(d.lb, func(balancer interface{}) {
+			if spb, ok := balancer.(*SessionPersistenceBalancer); ok && spb.ConsistentHashRing != nil {
+				spb.ConsistentHashRing.RemoveNode(p)
+			}
+		})
+
+		logger.Health.Warn("Backend deregistered after being dead too long",
+			zap.String("backend", p.URL.Redacted()),
+			zap.Duration("deadFor", deadFor))
+	}
+}
@@ -0,0 +1,365 @@
+package balancer
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// DNS record types, classes and response codes used by the responder
+const (
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+	dnsTypeANY  = 255
+	dnsClassIN  = 1
+	dnsClassANY = 255
+
+	dnsRcodeFormatError    = 1
+	dnsRcodeServerFailure  = 2
+	dnsRcodeNameError      = 3
+	dnsRcodeNotImplemented = 4
+
+	// dnsMaxUDPSize is the largest response sent without EDNS
+	dnsMaxUDPSize = 512
+)
+
+// DNSName maps a DNS name to a backend pool
+type DNSName struct {
+	Pool string
+	TTL  uint32
+}
+
+// DNSConfig holds the settings of the built-in DNS responder
+type DNSConfig struct {
+	Listen string
+	Names  map[string]DNSName
+}
+
+// normalizeDNSName lowercases a name and removes its trailing dot
+func normalizeDNSName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+// parseDNSName parses the arguments of a dns_name directive
+func parseDNSName(parts []string) (string, DNSName, error) {
+	if len(parts) < 2 {
+		return "", DNSName{}, fmt.Errorf("dns_name directive requires a name")
+	}
+
+	name := normalizeDNSName(parts[1])
+	if name == "" {
+		return "", DNSName{}, fmt.Errorf("invalid dns name: %s", parts[1])
+	}
+
+	record := DNSName{TTL: 5}
+
+	for i := 2; i < len(parts); i++ {
+		if strings.HasPrefix(parts[i], "pool=") {
+			record.Pool = strings.TrimPrefix(parts[i], "pool=")
+		} else if strings.HasPrefix(parts[i], "ttl=") {
+			ttlStr := strings.TrimPrefix(parts[i], "ttl=")
+			ttl, err := strconv.ParseUint(ttlStr, 10, 32)
+			if err != nil {
+				return "", DNSName{}, fmt.Errorf("invalid dns ttl: %s", ttlStr)
+			}
+			record.TTL = uint32(ttl)
+		}
+	}
+
+	return name, record, nil
+}
+
+// dnsQuestion is the single question of a DNS query
+type dnsQuestion struct {
+	name  string
+	qtype uint16
+	class uint16
+	// raw holds the question as it appeared on the wire
+	raw []byte
+}
+
+var errMalformedQuery = errors.New("malformed dns query")
+
+// parseDNSQuery parses the header and first question of a query
+func parseDNSQuery(msg []byte) (uint16, uint16, dnsQuestion, error) {
+	if len(msg) < 12 {
+		return 0, 0, dnsQuestion{}, errMalformedQuery
+	}
+
+	id := binary.BigEndian.Uint16(msg[0:2])
+	flags := binary.BigEndian.Uint16(msg[2:4])
+	if flags&0x8000 != 0 || binary.BigEndian.Uint16(msg[4:6]) != 1 {
+		return id, flags, dnsQuestion{}, errMalformedQuery
+	}
+
+	var labels []string
+	offset := 12
+	for {
+		if offset >= len(msg) {
+			return id, flags, dnsQuestion{}, errMalformedQuery
+		}
+		length := int(msg[offset])
+		offset++
+		if length == 0 {
+			break
+		}
+		// Queries never compress their only question
+		if length > 63 || offset+length > len(msg) {
+			return id, flags, dnsQuestion{}, errMalformedQuery
+		}
+		labels = append(labels, string(msg[offset:offset+length]))
+		offset += length
+	}
+
+	if offset+4 > len(msg) {
+		return id, flags, dnsQuestion{}, errMalformedQuery
+	}
+
+	question := dnsQuestion{
+		name:  normalizeDNSName(strings.Join(labels, ".")),
+		qtype: binary.BigEndian.Uint16(msg[offset : offset+2]),
+		class: binary.BigEndian.Uint16(msg[offset+2 : offset+4]),
+		raw:   msg[12 : offset+4],
+	}
+
+	return id, flags, question, nil
+}
+
+// buildDNSResponse builds an authoritative response carrying the given addresses
+func buildDNSResponse(id, queryFlags uint16, question dnsQuestion, rcode uint16, ips []net.IP, ttl uint32) []byte {
+	// QR, AA and the query's opcode and RD bit
+	flags := uint16(0x8400) | queryFlags&0x7900 | rcode
+
+	msg := make([]byte, 12, dnsMaxUDPSize)
+	binary.BigEndian.PutUint16(msg[0:2], id)
+	binary.BigEndian.PutUint16(msg[2:4], flags)
+
+	if question.raw == nil {
+		return msg
+	}
+	binary.BigEndian.PutUint16(msg[4:6], 1)
+	msg = append(msg, question.raw...)
+
+	answers := 0
+	for _, ip := range ips {
+		rdata := []byte(ip.To4())
+		rtype := uint16(dnsTypeA)
+		if rdata == nil {
+			rdata = ip.To16()
+			rtype = dnsTypeAAAA
+		}
+
+		// Drop the remaining records rather than exceeding the UDP size
+		if len(msg)+12+len(rdata) > dnsMaxUDPSize {
+			break
+		}
+
+		// The name is a pointer to the question at offset 12
+		msg = binary.BigEndian.AppendUint16(msg, 0xC00C)
+		msg = binary.BigEndian.AppendUint16(msg, rtype)
+		msg = binary.BigEndian.AppendUint16(msg, dnsClassIN)
+		msg = binary.BigEndian.AppendUint32(msg, ttl)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(rdata)))
+		msg = append(msg, rdata...)
+		answers++
+	}
+	binary.BigEndian.PutUint16(msg[6:8], uint16(answers))
+
+	return msg
+}
+
+// DNSResponder answers DNS queries for configured names with the addresses
+// of the currently healthy backends of their pool, so clients that cannot go
+// through the proxy can balance across the same backends themselves
+type DNSResponder struct {
+	lb     LoadBalancerStrategy
+	config DNSConfig
+	conn   net.PacketConn
+}
+
+// NewDNSResponder creates a responder, or returns nil if it is disabled
+func NewDNSResponder(lb LoadBalancerStrategy, config DNSConfig) *DNSResponder {
+	if config.Listen == "" || len(config.Names) == 0 {
+		return nil
+	}
+	return &DNSResponder{
+		lb:     lb,
+		config: config,
+	}
+}
+
+// Start listens for queries over UDP and answers them in the background
+func (d *DNSResponder) Start() error {
+	if d == nil {
+		return nil
+	}
+
+	conn, err := net.ListenPacket("udp", d.config.Listen)
+	if err != nil {
+		return err
+	}
+
+	d.Serve(conn)
+	return nil
+}
+
+// Serve answers queries received on an already open socket
+func (d *DNSResponder) Serve(conn net.PacketConn) {
+	if d == nil {
+		return
+	}
+
+	d.conn = conn
+	go d.serve()
+}
+
+// Addr returns the address the responder listens on
+func (d *DNSResponder) Addr() net.Addr {
+	if d == nil || d.conn == nil {
+		return nil
+	}
+	return d.conn.LocalAddr()
+}
+
+// Stop closes the responder's socket
+func (d *DNSResponder) Stop() {
+	if d == nil || d.conn == nil {
+		return
+	}
+	d.conn.Close()
+}
+
+func (d *DNSResponder) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := d.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Log.Warn("Failed to read DNS query", zap.Error(err))
+			continue
+		}
+
+		response := d.answer(buf[:n])
+		if response == nil {
+			continue
+		}
+		if _, err := d.conn.WriteTo(response, addr); err != nil {
+			logger.Log.Debug("Failed to write DNS response", zap.Error(err))
+		}
+	}
+}
+
+// answer builds the response to a query, or nil if it must be dropped
+func (d *DNSResponder) answer(query []byte) []byte {
+	id, flags, question, err := parseDNSQuery(query)
+	if err != nil {
+		if len(query) < 12 || flags&0x8000 != 0 {
+			return nil
+		}
+		return buildDNSResponse(id, flags, dnsQuestion{}, dnsRcodeFormatError, nil, 0)
+	}
+
+	if opcode := flags >> 11 & 0xF; opcode != 0 {
+		return buildDNSResponse(id, flags, question, dnsRcodeNotImplemented, nil, 0)
+	}
+
+	record, ok := d.config.Names[question.name]
+	if !ok || (question.class != dnsClassIN && question.class != dnsClassANY) {
+		return buildDNSResponse(id, flags, question, dnsRcodeNameError, nil, 0)
+	}
+
+	ips := d.healthyIPs(record.Pool)
+	if len(ips) == 0 {
+		return buildDNSResponse(id, flags, question, dnsRcodeServerFailure, nil, 0)
+	}
+
+	// Only return the addresses of the requested family
+	matching := ips[:0]
+	for _, ip := range ips {
+		isV4 := ip.To4() != nil
+		if question.qtype == dnsTypeANY ||
+			(question.qtype == dnsTypeA && isV4) ||
+			(question.qtype == dnsTypeAAAA && !isV4) {
+			matching = append(matching, ip)
+		}
+	}
+
+	// Rotate the order so clients picking the first address spread out
+	rand.Shuffle(len(matching), func(i, j int) {
+		matching[i], matching[j] = matching[j], matching[i]
+	})
+
+	return buildDNSResponse(id, flags, question, 0, matching, record.TTL)
+}
+
+// healthyIPs returns the addresses of the healthy backends of a pool
+func (d *DNSResponder) healthyIPs(pool string) []net.IP {
+	strategy := poolStrategy(d.lb, pool)
+	if strategy == nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var ips []net.IP
+
+	for _, p := range strategyProcesses(strategy) {
+		if !p.IsAlive() || p.IsDraining() {
+			continue
+		}
+
+		for _, ip := range resolveBackendHost(p.URL.Hostname()) {
+			if !seen[ip.String()] {
+				seen[ip.String()] = true
+				ips = append(ips, ip)
+			}
+		}
+	}
+
+	return ips
+}
+
+// resolveBackendHost returns the addresses of a backend host
+func resolveBackendHost(host string) []net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		logger.Log.Warn("Failed to resolve backend host", zap.String("host", host), zap.Error(err))
+		return nil
+	}
+	return ips
+}
+
+// poolStrategy returns the strategy of a named pool. Without path routing
+// there is a single pool, returned whatever the name.
+func poolStrategy(lb LoadBalancerStrategy, pool string) LoadBalancerStrategy {
+	for {
+		switch typed := lb.(type) {
+		case *PathRouter:
+			if pool == "" {
+				return typed.defaultPool
+			}
+			return typed.backendPools[pool]
+		case strategyWrapper:
+			lb = typed.Unwrap()
+		default:
+			return lb
+		}
+	}
+}
@@ -0,0 +1,449 @@
+package balancer
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// DNSRefreshConfig holds the settings of the re-resolution of backends
+// configured by host name
+type DNSRefreshConfig struct {
+	// Interval is how often a host is re-resolved when the TTL of its
+	// records is unknown, or 0 if backends are not re-resolved
+	Interval time.Duration
+	// MinTTL and MaxTTL bound the time the addresses of a host are kept
+	MinTTL time.Duration
+	MaxTTL time.Duration
+	// Nameserver is the host:port asked for the records and their TTL, by
+	// default the first nameserver of /etc/resolv.conf
+	Nameserver string
+}
+
+// parseDNSRefresh parses the arguments of a dns_refresh directive
+func parseDNSRefresh(parts []string) (DNSRefreshConfig, error) {
+	config := DNSRefreshConfig{Interval: 30 * time.Second, MinTTL: 5 * time.Second, MaxTTL: 5 * time.Minute}
+
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(strings.TrimSuffix(part, ";"), "=")
+		switch key {
+		case "interval", "min_ttl", "max_ttl":
+			duration, err := time.ParseDuration(value)
+			if err != nil || duration <= 0 {
+				return DNSRefreshConfig{}, fmt.Errorf("invalid dns_refresh %s: %s", key, value)
+			}
+			switch key {
+			case "interval":
+				config.Interval = duration
+			case "min_ttl":
+				config.MinTTL = duration
+			case "max_ttl":
+				config.MaxTTL = duration
+			}
+		case "nameserver":
+			if _, _, err := net.SplitHostPort(value); err != nil {
+				value = net.JoinHostPort(value, "53")
+			}
+			config.Nameserver = value
+		default:
+			return DNSRefreshConfig{}, fmt.Errorf("unknown dns_refresh option: %s", part)
+		}
+	}
+	if config.MinTTL > config.MaxTTL {
+		return DNSRefreshConfig{}, fmt.Errorf("dns_refresh min_ttl must not be above max_ttl")
+	}
+
+	return config, nil
+}
+
+// systemNameserver returns the first nameserver of /etc/resolv.conf, or ""
+func systemNameserver() string {
+	file, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53")
+		}
+	}
+	return ""
+}
+
+var errNoAddresses = errors.New("no addresses in dns response")
+
+// queryAddresses asks a nameserver for the records of a type of a host and
+// returns the addresses with the lowest TTL of the answers
+func queryAddresses(ctx context.Context, nameserver, host string, qtype uint16) ([]net.IP, time.Duration, error) {
+	query := make([]byte, 12, 512)
+	id := uint16(rand.Intn(1 << 16))
+	binary.BigEndian.PutUint16(query[0:2], id)
+	// Recursion desired
+	binary.BigEndian.PutUint16(query[2:4], 0x0100)
+	binary.BigEndian.PutUint16(query[4:6], 1)
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, 0, fmt.Errorf("invalid host name: %s", host)
+		}
+		query = append(query, byte(len(label)))
+		query = append(query, label...)
+	}
+	query = append(query, 0)
+	query = binary.BigEndian.AppendUint16(query, qtype)
+	query = binary.BigEndian.AppendUint16(query, dnsClassIN)
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", nameserver)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, 0, err
+	}
+
+	msg := make([]byte, 4096)
+	for {
+		n, err := conn.Read(msg)
+		if err != nil {
+			return nil, 0, err
+		}
+		if n >= 12 && binary.BigEndian.Uint16(msg[0:2]) == id {
+			return parseAddresses(msg[:n], qtype)
+		}
+	}
+}
+
+// parseAddresses returns the addresses of the answers of a response and the
+// lowest TTL of the answers, CNAME records included
+func parseAddresses(msg []byte, qtype uint16) ([]net.IP, time.Duration, error) {
+	flags := binary.BigEndian.Uint16(msg[2:4])
+	if flags&0x8000 == 0 {
+		return nil, 0, errMalformedQuery
+	}
+	if rcode := flags & 0x000F; rcode != 0 {
+		return nil, 0, fmt.Errorf("dns response code %d", rcode)
+	}
+
+	offset := 12
+	for i := 0; i < int(binary.BigEndian.Uint16(msg[4:6])); i++ {
+		if offset = skipDNSName(msg, offset); offset < 0 || offset+4 > len(msg) {
+			return nil, 0, errMalformedQuery
+		}
+		offset += 4
+	}
+
+	var ips []net.IP
+	var ttl uint32
+	for i := 0; i < int(binary.BigEndian.Uint16(msg[6:8])); i++ {
+		if offset = skipDNSName(msg, offset); offset < 0 || offset+10 > len(msg) {
+			return nil, 0, errMalformedQuery
+		}
+		rtype := binary.BigEndian.Uint16(msg[offset : offset+2])
+		recordTTL := binary.BigEndian.Uint32(msg[offset+4 : offset+8])
+		length := int(binary.BigEndian.Uint16(msg[offset+8 : offset+10]))
+		offset += 10
+		if offset+length > len(msg) {
+			return nil, 0, errMalformedQuery
+		}
+
+		if i == 0 || recordTTL < ttl {
+			ttl = recordTTL
+		}
+		if rtype == qtype && (length == net.IPv4len || length == net.IPv6len) {
+			ips = append(ips, net.IP(append([]byte(nil), msg[offset:offset+length]...)))
+		}
+		offset += length
+	}
+
+	if len(ips) == 0 {
+		return nil, 0, errNoAddresses
+	}
+	return ips, time.Duration(ttl) * time.Second, nil
+}
+
+// skipDNSName returns the offset after a possibly compressed name, or -1
+func skipDNSName(msg []byte, offset int) int {
+	for offset < len(msg) {
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			return offset + 1
+		case length&0xC0 == 0xC0:
+			return offset + 2
+		default:
+			offset += 1 + length
+		}
+	}
+	return -1
+}
+
+// DNSRefresher re-resolves the backends configured by host name once the
+// TTL of their records runs out, and right away when one goes down, since
+// DNS-based failover may have moved it. A backend's connections go to the
+// addresses last resolved; when they change, the backend gets a new
+// connection pool so no request keeps using the old addresses.
+type DNSRefresher struct {
+	lb         LoadBalancerStrategy
+	config     DNSRefreshConfig
+	nameserver string
+	stop       func()
+	done       chan struct{}
+
+	mu     sync.Mutex
+	states map[*Process]*dnsRefreshState
+}
+
+// dnsRefreshState holds the addresses last resolved for a backend
+type dnsRefreshState struct {
+	ips []net.IP
+	due time.Time
+}
+
+// NewDNSRefresher creates a refresher, or returns nil if re-resolution is
+// disabled
+func NewDNSRefresher(lb LoadBalancerStrategy, config DNSRefreshConfig) *DNSRefresher {
+	if config.Interval <= 0 {
+		return nil
+	}
+	nameserver := config.Nameserver
+	if nameserver == "" {
+		nameserver = systemNameserver()
+	}
+	return &DNSRefresher{
+		lb:         lb,
+		config:     config,
+		nameserver: nameserver,
+		done:       make(chan struct{}),
+		states:     make(map[*Process]*dnsRefreshState),
+	}
+}
+
+// Start resolves every backend configured by host name, then re-resolves
+// them in the background
+func (dr *DNSRefresher) Start() {
+	if dr == nil {
+		return
+	}
+
+	events, stop := SubscribeBackendEvents()
+	dr.stop = stop
+	dr.refreshDue(time.Now())
+
+	go func() {
+		defer close(dr.done)
+		ticker := time.NewTicker(dr.config.MinTTL)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				dr.refreshDue(now)
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				if event.State != "down" {
+					continue
+				}
+				for _, p := range strategyProcesses(dr.lb) {
+					if p.URL.Redacted() == event.Backend && isHostName(p) {
+						dr.refresh(p)
+					}
+				}
+			}
+		}
+	}()
+}
+
+// Stop ends re-resolving backends
+func (dr *DNSRefresher) Stop() {
+	if dr == nil {
+		return
+	}
+	dr.stop()
+	<-dr.done
+}
+
+// isHostName reports whether a backend is configured by host name and uses
+// the shared connection pools
+func isHostName(p *Process) bool {
+	return net.ParseIP(p.URL.Hostname()) == nil && p.transport == nil
+}
+
+// refreshDue re-resolves the backends whose addresses expired
+func (dr *DNSRefresher) refreshDue(now time.Time) {
+	for _, p := range strategyProcesses(dr.lb) {
+		if !isHostName(p) {
+			continue
+		}
+		dr.mu.Lock()
+		state := dr.states[p]
+		dr.mu.Unlock()
+		if state == nil || !now.Before(state.due) {
+			dr.refresh(p)
+		}
+	}
+}
+
+// Refresh re-resolves every backend configured by host name now
+func (dr *DNSRefresher) Refresh() {
+	for _, p := range strategyProcesses(dr.lb) {
+		if isHostName(p) {
+			dr.refresh(p)
+		}
+	}
+}
+
+// refresh resolves a backend and, if its addresses changed, moves it to a
+// connection pool dialing the new addresses
+func (dr *DNSRefresher) refresh(p *Process) {
+	ips, ttl, err := dr.resolve(p.URL.Hostname())
+	now := time.Now()
+
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	state := dr.states[p]
+	if state == nil {
+		state = &dnsRefreshState{}
+		dr.states[p] = state
+	}
+	if err != nil {
+		// Keep the addresses that worked and try again soon
+		state.due = now.Add(dr.config.MinTTL)
+		logger.Health.Warn("Failed to re-resolve backend",
+			zap.String("backend", p.URL.Redacted()),
+			zap.Error(err))
+		return
+	}
+
+	switch {
+	case ttl <= 0:
+		ttl = dr.config.Interval
+	case ttl < dr.config.MinTTL:
+		ttl = dr.config.MinTTL
+	case ttl > dr.config.MaxTTL:
+		ttl = dr.config.MaxTTL
+	}
+	state.due = now.Add(ttl)
+
+	if sameAddresses(state.ips, ips) {
+		return
+	}
+	if state.ips != nil {
+		logger.Health.Info("Backend addresses changed",
+			zap.String("backend", p.URL.Redacted()),
+			zap.Stringers("from", ipStringers(state.ips)),
+			zap.Stringers("to", ipStringers(ips)))
+	}
+	state.ips = ips
+
+	if previous, loaded := addressTransports.Swap(p, addressTransport(p, ips)); loaded {
+		previous.(*http.Transport).CloseIdleConnections()
+	}
+}
+
+// resolve returns the addresses of a host with their TTL, asking the
+// nameserver directly since the system resolver hides the TTL. The system
+// resolver is the fallback, such as for names in /etc/hosts, with a TTL
+// of 0 meaning unknown.
+func (dr *DNSRefresher) resolve(host string) ([]net.IP, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if dr.nameserver != "" {
+		var ips []net.IP
+		var ttl time.Duration
+		for _, qtype := range []uint16{dnsTypeA, dnsTypeAAAA} {
+			found, foundTTL, err := queryAddresses(ctx, dr.nameserver, host, qtype)
+			if err != nil {
+				continue
+			}
+			if ips == nil || foundTTL < ttl {
+				ttl = foundTTL
+			}
+			ips = append(ips, found...)
+		}
+		if len(ips) > 0 {
+			return ips, ttl, nil
+		}
+	}
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ips, 0, nil
+}
+
+// sameAddresses reports whether two address lists hold the same addresses
+func sameAddresses(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]int, len(a))
+	for _, ip := range a {
+		seen[ip.String()]++
+	}
+	for _, ip := range b {
+		if seen[ip.String()] == 0 {
+			return false
+		}
+		seen[ip.String()]--
+	}
+	return true
+}
+
+func ipStringers(ips []net.IP) []fmt.Stringer {
+	stringers := make([]fmt.Stringer, len(ips))
+	for i, ip := range ips {
+		stringers[i] = ip
+	}
+	return stringers
+}
+
+// addressTransport returns a connection pool like the one of a backend
+// that dials the given addresses in turn instead of resolving the host
+func addressTransport(p *Process, ips []net.IP) *http.Transport {
+	transport := baseTransportFor(p).Clone()
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+	var next uint32
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		start := int(atomic.AddUint32(&next, 1))
+		for i := range ips {
+			ip := ips[(start+i)%len(ips)]
+			var conn net.Conn
+			if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+	return transport
+}
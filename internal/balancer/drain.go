@@ -0,0 +1,152 @@
+package balancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// DrainSignalConfig holds the settings of backend-initiated draining
+type DrainSignalConfig struct {
+	Header  string
+	Recheck time.Duration
+	Path    string
+}
+
+// parseDrainSignal parses the arguments of a drain_signal directive
+func parseDrainSignal(parts []string) (DrainSignalConfig, error) {
+	config := DrainSignalConfig{Header: "X-Drain", Recheck: 5 * time.Second, Path: "/"}
+
+	for i := 1; i < len(parts); i++ {
+		if strings.HasPrefix(parts[i], "header=") {
+			config.Header = strings.TrimPrefix(parts[i], "header=")
+		} else if strings.HasPrefix(parts[i], "recheck=") {
+			recheckStr := strings.TrimPrefix(parts[i], "recheck=")
+			recheck, err := time.ParseDuration(recheckStr)
+			if err != nil || recheck <= 0 {
+				return DrainSignalConfig{}, fmt.Errorf("invalid drain_signal recheck: %s", recheckStr)
+			}
+			config.Recheck = recheck
+		} else if strings.HasPrefix(parts[i], "path=") {
+			config.Path = strings.TrimPrefix(parts[i], "path=")
+		}
+	}
+
+	return config, nil
+}
+
+// drainSignal is the drain header honored in backend responses, if enabled
+var drainSignal atomic.Pointer[DrainSignalConfig]
+
+// EnableDrainSignal makes backends able to ask to be drained by setting the
+// configured header to "true" in a response. A drained backend gets no new
+// requests and is rechecked until it answers without the header.
+func EnableDrainSignal(config DrainSignalConfig) {
+	if config.Header == "" {
+		drainSignal.Store(nil)
+		return
+	}
+	drainSignal.Store(&config)
+}
+
+// checkDrainSignal drains a backend whose response carries the drain header.
+// The header is internal to the load balancer and is not passed to clients.
+func checkDrainSignal(p *Process, resp *http.Response) {
+	config := drainSignal.Load()
+	if config == nil {
+		return
+	}
+
+	value := resp.Header.Get(config.Header)
+	if value == "" {
+		return
+	}
+	resp.Header.Del(config.Header)
+
+	// An operator override takes precedence over the backend's own signal
+	if p.GetOverride() != NoOverride {
+		return
+	}
+
+	if strings.EqualFold(value, "true") && p.SetDraining(true) {
+		logger.Health.Info("Backend asked to be drained", zap.String("backend", p.URL.String()))
+		go awaitReadiness(p, *config)
+	}
+}
+
+// awaitReadiness probes a drained backend until it answers without asking to
+// be drained, then puts it back in rotation
+func awaitReadiness(p *Process, config DrainSignalConfig) {
+	ticker := time.NewTicker(config.Recheck)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// Readiness was announced through the admin API meanwhile, or an
+		// operator took over
+		if !p.IsDraining() || p.GetOverride() != NoOverride {
+			return
+		}
+
+		resp, err := probeBackend(p, config.Path, 5*time.Second)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode < http.StatusInternalServerError && !strings.EqualFold(resp.Header.Get(config.Header), "true") {
+			if p.SetDraining(false) {
+				logger.Health.Info("Drained backend is ready again", zap.String("backend", p.URL.String()))
+			}
+			return
+		}
+	}
+}
+
+// DrainHandler lets backends announce that they are about to restart or are
+// ready again: POST backend=<URL>&state=drain|ready. Backends overridden by
+// an operator keep their state.
+func DrainHandler(lb LoadBalancerStrategy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		backend := r.FormValue("backend")
+		state := r.FormValue("state")
+		if state != "drain" && state != "ready" {
+			http.Error(w, "state must be drain or ready", http.StatusBadRequest)
+			return
+		}
+
+		var target *Process
+		for _, p := range strategyProcesses(lb) {
+			if p.URL.String() == backend {
+				target = p
+				break
+			}
+		}
+		if target == nil {
+			apiError(w, fmt.Errorf("%w: %s", ErrBackendNotFound, backend))
+			return
+		}
+
+		if target.GetOverride() == NoOverride && target.SetDraining(state == "drain") {
+			logger.Admin.Info("Backend drain state changed through the admin API",
+				zap.String("backend", backend),
+				zap.Bool("draining", state == "drain"))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"backend":  backend,
+			"draining": target.IsDraining(),
+		})
+	}
+}
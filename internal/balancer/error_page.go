@@ -0,0 +1,278 @@
+package balancer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// defaultJSONErrorPage is the page of error_page directives using json
+// without a template of their own
+const defaultJSONErrorPage = `{"error":{{json .Message}},"status":{{.Status}},"reason":{{json .Reason}}}` + "\n"
+
+// maxErrorMessageSize bounds the original error message kept for a page
+const maxErrorMessageSize = 4096
+
+// ErrorPageConfig holds a named error page replacing the plain text of the
+// gateway errors the balancer returns itself
+type ErrorPageConfig struct {
+	Name string
+	// File is the path of the page template, and Inline the template itself
+	// when it is given in the configuration
+	File   string
+	Inline string
+	// ContentType of the page, guessed from the file name if not set
+	ContentType string
+	// Codes are the status codes the page is used for
+	Codes map[int]bool
+}
+
+// parseErrorPage parses an error_page directive. The template of an inline
+// page is the rest of the line after the inline keyword, spaces included.
+func parseErrorPage(line string) (ErrorPageConfig, error) {
+	parts := strings.Fields(line)
+	if len(parts) < 3 {
+		return ErrorPageConfig{}, fmt.Errorf("error_page directive requires a name and a page")
+	}
+
+	config := ErrorPageConfig{
+		Name:  parts[1],
+		Codes: map[int]bool{http.StatusBadGateway: true, http.StatusServiceUnavailable: true, http.StatusGatewayTimeout: true},
+	}
+
+	// offset follows the end of each part in the line
+	offset := len(parts[0]) + strings.Index(line[len(parts[0]):], parts[1]) + len(parts[1])
+	for i := 2; i < len(parts); i++ {
+		offset += strings.Index(line[offset:], parts[i]) + len(parts[i])
+
+		if parts[i] == "inline" {
+			config.Inline = strings.TrimSpace(line[offset:])
+			if config.Inline == "" {
+				return ErrorPageConfig{}, fmt.Errorf("inline error page requires a template")
+			}
+			break
+		} else if parts[i] == "json" {
+			config.Inline = defaultJSONErrorPage
+			config.ContentType = "application/json"
+		} else if strings.HasPrefix(parts[i], "file=") {
+			config.File = strings.TrimPrefix(parts[i], "file=")
+		} else if strings.HasPrefix(parts[i], "type=") {
+			config.ContentType = strings.TrimPrefix(parts[i], "type=")
+		} else if strings.HasPrefix(parts[i], "codes=") {
+			config.Codes = make(map[int]bool)
+			for _, codeStr := range strings.Split(strings.TrimPrefix(parts[i], "codes="), ",") {
+				code, err := strconv.Atoi(codeStr)
+				if err != nil || code < http.StatusBadGateway || code > http.StatusGatewayTimeout {
+					return ErrorPageConfig{}, fmt.Errorf("invalid error page code, expected 502, 503 or 504: %s", codeStr)
+				}
+				config.Codes[code] = true
+			}
+		}
+	}
+
+	if config.File == "" && config.Inline == "" {
+		return ErrorPageConfig{}, fmt.Errorf("error_page directive requires file=, json or inline")
+	}
+	if config.ContentType == "" {
+		config.ContentType = "text/html; charset=utf-8"
+		if config.File != "" {
+			if guessed := mime.TypeByExtension(filepath.Ext(config.File)); guessed != "" {
+				config.ContentType = guessed
+			}
+		}
+	}
+
+	return config, nil
+}
+
+// ErrorPageData is what an error page template is rendered with
+type ErrorPageData struct {
+	Status     int
+	StatusText string
+	// Reason is the rejection reason code, also sent in X-LB-Reject-Reason
+	Reason  string
+	Message string
+	Method  string
+	Path    string
+}
+
+// errorTemplate is satisfied by both text and HTML templates
+type errorTemplate interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// loadErrorTemplate parses the template of an error page. HTML pages escape
+// what they are rendered with, as the path comes from the client.
+func loadErrorTemplate(config ErrorPageConfig) (errorTemplate, error) {
+	text := config.Inline
+	if config.File != "" {
+		data, err := os.ReadFile(config.File)
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	}
+
+	funcs := map[string]interface{}{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}
+
+	if strings.HasPrefix(config.ContentType, "text/html") {
+		return htmltemplate.New(config.Name).Funcs(funcs).Parse(text)
+	}
+	return template.New(config.Name).Funcs(funcs).Parse(text)
+}
+
+// ErrorPages replaces the plain text of the 502, 503 and 504 responses the
+// balancer returns itself, such as when no backend is available, with a
+// templated page. Error responses from backends are passed through.
+type ErrorPages struct {
+	next     LoadBalancerStrategy
+	config   ErrorPageConfig
+	template errorTemplate
+}
+
+// NewErrorPages wraps a route's strategy with an error page.
+// The strategy is returned unchanged if no page is configured.
+func NewErrorPages(next LoadBalancerStrategy, config ErrorPageConfig) (LoadBalancerStrategy, error) {
+	if config.Name == "" {
+		return next, nil
+	}
+
+	tmpl, err := loadErrorTemplate(config)
+	if err != nil {
+		return nil, fmt.Errorf("error_page %s: %v", config.Name, err)
+	}
+
+	return &ErrorPages{
+		next:     next,
+		config:   config,
+		template: tmpl,
+	}, nil
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (e *ErrorPages) GetNextInstance(r *http.Request) (*url.URL, error) {
+	return e.next.GetNextInstance(r)
+}
+
+// ProxyRequest proxies the request, rendering the error page if the balancer
+// fails it
+func (e *ErrorPages) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	ew := &errorPageWriter{ResponseWriter: w, codes: e.config.Codes}
+	e.next.ProxyRequest(ew, r)
+
+	if ew.intercepted {
+		e.render(w, r, ew)
+	}
+}
+
+func (e *ErrorPages) render(w http.ResponseWriter, r *http.Request, ew *errorPageWriter) {
+	data := ErrorPageData{
+		Status:     ew.status,
+		StatusText: http.StatusText(ew.status),
+		Reason:     w.Header().Get(RejectReasonHeader),
+		Message:    strings.TrimSpace(ew.message.String()),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+	}
+
+	var body bytes.Buffer
+	contentType := e.config.ContentType
+	if err := e.template.Execute(&body, data); err != nil {
+		logger.Proxy.Error("Failed to render error page",
+			zap.String("error_page", e.config.Name),
+			zap.Error(err))
+		body.Reset()
+		body.WriteString(data.Message + "\n")
+		contentType = "text/plain; charset=utf-8"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Del("Content-Length")
+	w.WriteHeader(ew.status)
+	w.Write(body.Bytes())
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (e *ErrorPages) SupportsWebSockets() bool {
+	return e.next.SupportsWebSockets()
+}
+
+// Unwrap returns the wrapped strategy
+func (e *ErrorPages) Unwrap() LoadBalancerStrategy {
+	return e.next
+}
+
+// errorPageWriter holds back the error responses the balancer writes itself,
+// recognized by their rejection reason, so a page can replace them
+type errorPageWriter struct {
+	http.ResponseWriter
+	codes       map[int]bool
+	wroteHeader bool
+	intercepted bool
+	status      int
+	message     bytes.Buffer
+}
+
+func (w *errorPageWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if w.codes[statusCode] && w.Header().Get(RejectReasonHeader) != "" {
+			w.intercepted = true
+			w.status = statusCode
+			return
+		}
+	}
+	if w.intercepted {
+		return
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *errorPageWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.intercepted {
+		if room := maxErrorMessageSize - w.message.Len(); room > 0 {
+			w.message.Write(b[:min(len(b), room)])
+		}
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *errorPageWriter) Flush() {
+	if w.intercepted {
+		return
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *errorPageWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, fmt.Errorf("response writer does not implement http.Hijacker")
+}
@@ -0,0 +1,103 @@
+package balancer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Errors returned by the balancer, to be matched with errors.Is
+var (
+	// ErrNoHealthyBackend is returned when no backend can take a request
+	ErrNoHealthyBackend = errors.New("no healthy backend available")
+	// ErrPoolNotFound is returned when a backend pool is not defined
+	ErrPoolNotFound = errors.New("backend pool not found")
+	// ErrBackendNotFound is returned when a backend is not part of any pool
+	ErrBackendNotFound = errors.New("backend not found")
+	// ErrRouteNotFound is returned when a route is not defined
+	ErrRouteNotFound = errors.New("route not found")
+	// ErrConfigInvalid matches every configuration error; use errors.As with
+	// ErrInvalidConfig for the line at fault
+	ErrConfigInvalid = errors.New("invalid configuration")
+)
+
+// ErrInvalidConfig represents a configuration error
+type ErrInvalidConfig struct {
+	// Line is the configuration file line at fault, or 0 if the error is not
+	// tied to a line
+	Line    int
+	Message string
+	// Err is the underlying error, if any
+	Err error
+}
+
+func (e ErrInvalidConfig) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("invalid configuration: line %d: %s", e.Line, e.Message)
+	}
+	return fmt.Sprintf("invalid configuration: %s", e.Message)
+}
+
+// Is makes every configuration error match ErrConfigInvalid
+func (e ErrInvalidConfig) Is(target error) bool {
+	return target == ErrConfigInvalid
+}
+
+// Unwrap returns the underlying error
+func (e ErrInvalidConfig) Unwrap() error {
+	return e.Err
+}
+
+// configError ties an error to the configuration line it was found on
+func configError(line int, err error) error {
+	return ErrInvalidConfig{Line: line, Message: err.Error(), Err: err}
+}
+
+// configErrorf returns a configuration error found on a line
+func configErrorf(line int, format string, args ...interface{}) error {
+	return ErrInvalidConfig{Line: line, Message: fmt.Sprintf(format, args...)}
+}
+
+// poolNotFound returns the error of a configuration referring to a pool that
+// is not defined
+func poolNotFound(line int, what, pool string) error {
+	return ErrInvalidConfig{Line: line, Message: what + " not found: " + pool, Err: ErrPoolNotFound}
+}
+
+// ErrorCode returns a machine-readable code for the category of an error,
+// as sent by the admin API in the X-LB-Error-Code header
+func ErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrNoHealthyBackend):
+		return "no_healthy_backend"
+	case errors.Is(err, ErrPoolNotFound):
+		return "pool_not_found"
+	case errors.Is(err, ErrBackendNotFound):
+		return "backend_not_found"
+	case errors.Is(err, ErrRouteNotFound):
+		return "route_not_found"
+	case errors.Is(err, ErrConfigInvalid):
+		return "config_invalid"
+	}
+	return "internal"
+}
+
+// ErrorCodeHeader is the admin API response header carrying the code of an error
+const ErrorCodeHeader = "X-LB-Error-Code"
+
+// apiError writes an admin API error response with the status and code of
+// the error's category
+func apiError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrNoHealthyBackend):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, ErrPoolNotFound), errors.Is(err, ErrBackendNotFound), errors.Is(err, ErrRouteNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrConfigInvalid):
+		status = http.StatusBadRequest
+	}
+
+	w.Header().Set(ErrorCodeHeader, ErrorCode(err))
+	http.Error(w, err.Error(), status)
+}
@@ -0,0 +1,255 @@
+package balancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap/zapcore"
+)
+
+// Types of the events pushed by /api/events
+const (
+	// BackendEventType is a HealthEvent: a backend went up, down, draining,
+	// ready or was registered or deregistered
+	BackendEventType = "backend"
+	// StatsEventType is a RequestRateSnapshot, pushed at a fixed interval
+	StatsEventType = "stats"
+	// ConfigEventType is a ConfigChange made at runtime
+	ConfigEventType = "config"
+	// LifecycleEventType is a LifecycleEvent, such as a configuration being
+	// applied, startup, shutdown or a pool losing all its backends
+	LifecycleEventType = "lifecycle"
+)
+
+// RequestRateSnapshot is the request rate over the last stats interval
+type RequestRateSnapshot struct {
+	Time              time.Time `json:"time"`
+	TotalRequests     int64     `json:"totalRequests"`
+	RequestsPerSecond float64   `json:"requestsPerSecond"`
+	ActiveConnections int32     `json:"activeConnections"`
+	// Backends holds the requests per second of each backend
+	Backends map[string]float64 `json:"backends"`
+}
+
+// ConfigChange is a change of the running configuration through the admin
+// API or by the load balancer itself, such as a blue/green rollback
+type ConfigChange struct {
+	Time time.Time `json:"time"`
+	// Kind is feature or route
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// LifecycleEvent is a critical event also sent to the system log
+type LifecycleEvent struct {
+	Time    time.Time         `json:"time"`
+	Level   string            `json:"level"`
+	Event   string            `json:"event"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// adminEvent is an event waiting to be pushed to a stream
+type adminEvent struct {
+	eventType string
+	data      interface{}
+}
+
+// eventBufferSize is the number of events a slow stream may lag behind
+// before events are dropped for it
+const eventBufferSize = 64
+
+var (
+	eventSubscribers   = make(map[chan adminEvent]struct{})
+	eventSubscribersMu sync.Mutex
+)
+
+func init() {
+	logger.OnEvent(func(level zapcore.Level, event, message string, fields map[string]string) {
+		publishEvent(LifecycleEventType, LifecycleEvent{
+			Time:    time.Now(),
+			Level:   level.String(),
+			Event:   event,
+			Message: message,
+			Fields:  fields,
+		})
+	})
+}
+
+// publishEvent pushes an event to every open stream, never blocking on a
+// slow one
+func publishEvent(eventType string, data interface{}) {
+	eventSubscribersMu.Lock()
+	defer eventSubscribersMu.Unlock()
+
+	for events := range eventSubscribers {
+		select {
+		case events <- adminEvent{eventType: eventType, data: data}:
+		default:
+		}
+	}
+}
+
+// publishConfigChange pushes a change of the running configuration
+func publishConfigChange(kind, name, value string) {
+	publishEvent(ConfigEventType, ConfigChange{Time: time.Now(), Kind: kind, Name: name, Value: value})
+}
+
+func subscribeEvents() (chan adminEvent, func()) {
+	events := make(chan adminEvent, eventBufferSize)
+
+	eventSubscribersMu.Lock()
+	eventSubscribers[events] = struct{}{}
+	eventSubscribersMu.Unlock()
+
+	return events, func() {
+		eventSubscribersMu.Lock()
+		delete(eventSubscribers, events)
+		eventSubscribersMu.Unlock()
+	}
+}
+
+// SubscribeBackendEvents returns a channel receiving the state changes of
+// every backend, and a function ending the subscription and closing the
+// channel. Like streams, a subscriber lagging too far behind misses events.
+func SubscribeBackendEvents() (<-chan HealthEvent, func()) {
+	events, unsubscribe := subscribeEvents()
+	health := make(chan HealthEvent, eventBufferSize)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(health)
+		for {
+			select {
+			case event := <-events:
+				if typed, ok := event.data.(HealthEvent); ok {
+					select {
+					case health <- typed:
+					default:
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return health, func() {
+		once.Do(func() {
+			unsubscribe()
+			close(done)
+		})
+	}
+}
+
+// EventStream pushes backend state changes, request rate snapshots,
+// configuration changes and lifecycle events to admin clients as
+// server-sent events, so they do not have to poll /api/stats
+type EventStream struct {
+	lb        LoadBalancerStrategy
+	interval  time.Duration
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewEventStream creates a stream pushing a request rate snapshot of a
+// strategy's backends every interval
+func NewEventStream(lb LoadBalancerStrategy, interval time.Duration) *EventStream {
+	return &EventStream{
+		lb:       lb,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+}
+
+// Close ends every open stream, so a server shutdown does not wait for them
+func (es *EventStream) Close() {
+	es.closeOnce.Do(func() { close(es.done) })
+}
+
+// ServeHTTP streams events until the client goes away or the stream is
+// closed
+func (es *EventStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	events, unsubscribe := subscribeEvents()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(es.interval)
+	defer ticker.Stop()
+
+	// The first snapshot only gives totals to compute rates from
+	counts := make(map[*Process]int64)
+	last := time.Now()
+	event := adminEvent{eventType: StatsEventType, data: es.snapshot(counts, 0)}
+
+	for {
+		data, err := json.Marshal(event.data)
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.eventType, data); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case event = <-events:
+		case now := <-ticker.C:
+			event = adminEvent{eventType: StatsEventType, data: es.snapshot(counts, now.Sub(last))}
+			last = now
+		case <-r.Context().Done():
+			return
+		case <-es.done:
+			return
+		}
+	}
+}
+
+// snapshot computes the request rates since the request counts in counts,
+// taken elapsed ago, and updates them
+func (es *EventStream) snapshot(counts map[*Process]int64, elapsed time.Duration) RequestRateSnapshot {
+	snapshot := RequestRateSnapshot{
+		Time:     time.Now(),
+		Backends: make(map[string]float64),
+	}
+
+	var delta int64
+	for _, p := range strategyProcesses(es.lb) {
+		requests := p.GetRequestCount()
+		snapshot.TotalRequests += requests
+		snapshot.ActiveConnections += p.GetActiveConnections()
+
+		rate := 0.0
+		if elapsed > 0 {
+			delta += requests - counts[p]
+			rate = float64(requests-counts[p]) / elapsed.Seconds()
+		}
+		snapshot.Backends[p.URL.Redacted()] += rate
+		counts[p] = requests
+	}
+	if elapsed > 0 {
+		snapshot.RequestsPerSecond = float64(delta) / elapsed.Seconds()
+	}
+
+	return snapshot
+}
@@ -0,0 +1,223 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// FailoverConfig defines an ordered chain of pools for a primary pool
+type FailoverConfig struct {
+	Pools        []string
+	StaticStatus int
+	FailAfter    int
+	RecoverAfter time.Duration
+}
+
+// parseFailover parses the arguments of a failover directive
+func parseFailover(parts []string) (FailoverConfig, error) {
+	if len(parts) < 3 {
+		return FailoverConfig{}, fmt.Errorf("failover directive requires a primary pool and at least one fallback")
+	}
+
+	failover := FailoverConfig{
+		FailAfter:    3,
+		RecoverAfter: 30 * time.Second,
+	}
+
+	for _, part := range parts[1:] {
+		switch {
+		case strings.HasPrefix(part, "static:"):
+			statusStr := strings.TrimPrefix(part, "static:")
+			status, err := strconv.Atoi(statusStr)
+			if err != nil || status < 100 || status > 599 {
+				return FailoverConfig{}, fmt.Errorf("invalid static status: %s", statusStr)
+			}
+			failover.StaticStatus = status
+		case strings.HasPrefix(part, "fail_after="):
+			valueStr := strings.TrimPrefix(part, "fail_after=")
+			value, err := strconv.Atoi(valueStr)
+			if err != nil || value <= 0 {
+				return FailoverConfig{}, fmt.Errorf("invalid fail_after: %s", valueStr)
+			}
+			failover.FailAfter = value
+		case strings.HasPrefix(part, "recover_after="):
+			valueStr := strings.TrimPrefix(part, "recover_after=")
+			value, err := time.ParseDuration(valueStr)
+			if err != nil || value < 0 {
+				return FailoverConfig{}, fmt.Errorf("invalid recover_after: %s", valueStr)
+			}
+			failover.RecoverAfter = value
+		default:
+			failover.Pools = append(failover.Pools, part)
+		}
+	}
+
+	if len(failover.Pools) < 2 && failover.StaticStatus == 0 {
+		return FailoverConfig{}, fmt.Errorf("failover chain requires a fallback pool or static response")
+	}
+
+	return failover, nil
+}
+
+// healthReporter is implemented by strategies that know whether any of their
+// backends can currently take traffic
+type healthReporter interface {
+	HasHealthyBackend() bool
+}
+
+// strategyHealthy reports whether a strategy has a backend that can take traffic
+func strategyHealthy(lb LoadBalancerStrategy) bool {
+	for {
+		if reporter, ok := lb.(healthReporter); ok {
+			return reporter.HasHealthyBackend()
+		}
+		wrapper, ok := lb.(strategyWrapper)
+		if !ok {
+			return true
+		}
+		lb = wrapper.Unwrap()
+	}
+}
+
+type failoverMember struct {
+	name         string
+	lb           LoadBalancerStrategy
+	down         bool
+	failures     int
+	healthySince time.Time
+}
+
+// FailoverChain sends traffic to the first usable pool of an ordered chain.
+// A pool that fails is only used again after it has been healthy for the
+// recovery period, so traffic does not flap while the primary is marginal.
+type FailoverChain struct {
+	members      []*failoverMember
+	staticStatus int
+	failAfter    int
+	recoverAfter time.Duration
+	mu           sync.Mutex
+}
+
+// NewFailoverChain creates a failover chain over the given pools
+func NewFailoverChain(config FailoverConfig, pools map[string]LoadBalancerStrategy) (*FailoverChain, error) {
+	chain := &FailoverChain{
+		staticStatus: config.StaticStatus,
+		failAfter:    config.FailAfter,
+		recoverAfter: config.RecoverAfter,
+	}
+
+	for _, name := range config.Pools {
+		lb, exists := pools[name]
+		if !exists {
+			return nil, poolNotFound(0, "failover pool", name)
+		}
+		chain.members = append(chain.members, &failoverMember{name: name, lb: lb})
+	}
+
+	return chain, nil
+}
+
+// current returns the member that should take the next request
+func (fc *FailoverChain) current() *failoverMember {
+	now := time.Now()
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	var selected *failoverMember
+	for _, m := range fc.members {
+		healthy := strategyHealthy(m.lb)
+
+		switch {
+		case !healthy:
+			if !m.down {
+				logger.Proxy.Warn("Failover pool unavailable", zap.String("pool", m.name))
+			}
+			m.down = true
+			m.healthySince = time.Time{}
+		case m.healthySince.IsZero():
+			m.healthySince = now
+		}
+
+		if m.down && healthy && now.Sub(m.healthySince) >= fc.recoverAfter {
+			m.down = false
+			m.failures = 0
+			logger.Proxy.Info("Failover pool recovered", zap.String("pool", m.name))
+		}
+
+		if selected == nil && !m.down {
+			selected = m
+		}
+	}
+
+	return selected
+}
+
+// record updates a member with the outcome of a request
+func (fc *FailoverChain) record(m *failoverMember, status int) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if status < http.StatusBadGateway || status > http.StatusGatewayTimeout {
+		m.failures = 0
+		return
+	}
+
+	m.failures++
+	if m.failures >= fc.failAfter && !m.down {
+		m.down = true
+		m.healthySince = time.Now()
+		logger.Proxy.Warn("Failing over from pool", zap.String("pool", m.name), zap.Int("failures", m.failures))
+	}
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (fc *FailoverChain) GetNextInstance(r *http.Request) (*url.URL, error) {
+	m := fc.current()
+	if m == nil {
+		return nil, ErrNoHealthyBackend
+	}
+	return m.lb.GetNextInstance(r)
+}
+
+// ProxyRequest proxies the request to the first usable pool in the chain
+func (fc *FailoverChain) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	m := fc.current()
+	if m == nil {
+		status := fc.staticStatus
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		rejectRequest(w, RejectFailoverExhausted, http.StatusText(status), status)
+		return
+	}
+
+	annotateRoute(r, "", m.name)
+
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	m.lb.ProxyRequest(recorder, r)
+	fc.record(m, recorder.status)
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (fc *FailoverChain) SupportsWebSockets() bool {
+	for _, m := range fc.members {
+		if !m.lb.SupportsWebSockets() {
+			return false
+		}
+	}
+	return true
+}
+
+// HasHealthyBackend implements the healthReporter interface
+func (fc *FailoverChain) HasHealthyBackend() bool {
+	return fc.current() != nil
+}
@@ -0,0 +1,93 @@
+package balancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// Features that can be switched on and off at runtime through the admin API
+const (
+	// FeatureTracing starts spans for requests when tracing is configured
+	FeatureTracing = "tracing"
+	// FeatureAccessLog writes access log records when an access log is configured
+	FeatureAccessLog = "access_log"
+	// FeatureDebugHeaders adds the backend that served a request to its response
+	FeatureDebugHeaders = "debug_headers"
+)
+
+// DebugBackendHeader carries the backend that served a response when debug
+// headers are on
+const DebugBackendHeader = "X-LB-Backend"
+
+var features = map[string]*atomic.Bool{
+	FeatureTracing:      newFeature(true),
+	FeatureAccessLog:    newFeature(true),
+	FeatureDebugHeaders: newFeature(false),
+}
+
+func newFeature(enabled bool) *atomic.Bool {
+	feature := &atomic.Bool{}
+	feature.Store(enabled)
+	return feature
+}
+
+// featureEnabled reports whether a feature is on
+func featureEnabled(name string) bool {
+	return features[name].Load()
+}
+
+// SetFeature switches a feature on or off
+func SetFeature(name string, enabled bool) error {
+	feature, ok := features[name]
+	if !ok {
+		return fmt.Errorf("unknown feature: %s", name)
+	}
+	feature.Store(enabled)
+	return nil
+}
+
+// GetFeatures returns whether each feature is on
+func GetFeatures() map[string]bool {
+	states := make(map[string]bool, len(features))
+	for name, feature := range features {
+		states[name] = feature.Load()
+	}
+	return states
+}
+
+// FeatureHandler reports the feature toggles on GET and flips one on
+// POST name=<feature>&enabled=true|false, without a restart
+func FeatureHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			name := r.FormValue("name")
+			enabled, err := strconv.ParseBool(r.FormValue("enabled"))
+			if err != nil {
+				http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+				return
+			}
+			if err := SetFeature(name, enabled); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			logger.Admin.Info("Feature toggled through the admin API",
+				zap.String("feature", name),
+				zap.Bool("enabled", enabled))
+			publishConfigChange("feature", name, strconv.FormatBool(enabled))
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(GetFeatures())
+	}
+}
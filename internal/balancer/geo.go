@@ -0,0 +1,141 @@
+package balancer
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// geoLocate locates the client of a request, or returns an empty location
+// without a GeoIP database
+func geoLocate(db *GeoIPDatabase, r *http.Request) GeoLocation {
+	if db == nil {
+		return GeoLocation{}
+	}
+	ip := net.ParseIP(getClientIP(r))
+	if ip == nil {
+		return GeoLocation{}
+	}
+	return db.Lookup(ip)
+}
+
+// geoRoute is the selector of a geo route: the countries or continents its
+// clients are located in
+type geoRoute struct {
+	countries  map[string]bool
+	continents map[string]bool
+
+	// db is the GeoIP database of the configuration, set once it is parsed
+	db *GeoIPDatabase
+}
+
+// parseGeoRoute parses the selector of a geo route: country=DE,AT or
+// continent=EU
+func parseGeoRoute(selector string) (*geoRoute, error) {
+	key, value, _ := strings.Cut(selector, "=")
+	key = strings.ToLower(key)
+	if (key != "country" && key != "continent") || value == "" {
+		return nil, fmt.Errorf("invalid geo route selector, expected country=<codes> or continent=<codes>: %s", selector)
+	}
+
+	codes := make(map[string]bool)
+	for _, code := range strings.Split(value, ",") {
+		if len(code) != 2 {
+			return nil, fmt.Errorf("invalid %s code: %s", key, code)
+		}
+		codes[strings.ToUpper(code)] = true
+	}
+
+	if key == "country" {
+		return &geoRoute{countries: codes}, nil
+	}
+	return &geoRoute{continents: codes}, nil
+}
+
+// match returns true if the client of a request is located in one of the
+// route's countries or continents
+func (g *geoRoute) match(r *http.Request) bool {
+	location := geoLocate(g.db, r)
+	return (location.Country != "" && g.countries[location.Country]) ||
+		(location.Continent != "" && g.continents[location.Continent])
+}
+
+// inRegion returns true if a backend is tagged with the country or the
+// continent of a location
+func (p *Process) inRegion(location GeoLocation) bool {
+	for rest := p.Region; rest != ""; {
+		var code string
+		code, rest, _ = strings.Cut(rest, ",")
+		if code != "" && (code == location.Country || code == location.Continent) {
+			return true
+		}
+	}
+	return false
+}
+
+// GeoBalancer is a weighted round robin preferring the backends tagged with
+// the region of the client, and falling back to every backend when none of
+// them can serve it
+type GeoBalancer struct {
+	*WeightedRoundRobinBalancer
+}
+
+// NewGeoBalancer creates a geo-weighted balancer
+func NewGeoBalancer(configs []BackendConfig) *GeoBalancer {
+	return &GeoBalancer{WeightedRoundRobinBalancer: NewLoadBalancer(configs)}
+}
+
+// NewGeoWeighted creates a geo-weighted load balancer. It balances like
+// weighted round robin until the pool is given a GeoIP database.
+func NewGeoWeighted(backends []BackendConfig) LoadBalancerStrategy {
+	return &PoolStrategy{pool: NewGeoBalancer(backends)}
+}
+
+// GetNextInstance picks a backend of the client's region if one is
+// available, with the weighted round robin schedule of the region's backends
+func (lb *GeoBalancer) GetNextInstance(r *http.Request) *Process {
+	location := geoLocate(lb.GeoIP, r)
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	return lb.nextLocked(r, location)
+}
+
+// nextLocked picks the next backend for a client location; the caller holds
+// the lock
+func (lb *GeoBalancer) nextLocked(r *http.Request, location GeoLocation) *Process {
+	avoid := avoidedZones(r, lb.AntiAffinity)
+	tier := lb.priorityTier(lb.ProcessPack)
+	if location != (GeoLocation{}) {
+		local := pickAvoidingZones(r, avoid, tier, func(eligible func(*Process) bool, tier int) *Process {
+			return lb.nextWeighted(func(p *Process) bool { return eligible(p) && p.inRegion(location) }, tier)
+		})
+		if local != nil {
+			return local
+		}
+	}
+	return pickAvoidingZones(r, avoid, tier, lb.nextWeighted)
+}
+
+// Pick implements the Picker interface
+func (lb *GeoBalancer) Pick(r *http.Request) *Process {
+	return lb.GetNextInstance(r)
+}
+
+func (lb *GeoBalancer) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	proxyToPool(lb, w, r)
+}
+
+func (lb *GeoBalancer) Method() string {
+	return "Geo Weighted"
+}
+
+// setGeoIP gives the pool behind a strategy the GeoIP database locating its
+// clients
+func setGeoIP(strategy LoadBalancerStrategy, db *GeoIPDatabase) {
+	if ps, ok := strategy.(*PoolStrategy); ok && db != nil {
+		ps.pool.Settings().GeoIP = db
+	}
+}
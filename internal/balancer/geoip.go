@@ -0,0 +1,325 @@
+package balancer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// GeoIPDatabase locates client IPs with a MaxMind DB file, such as a
+// GeoLite2 or GeoIP2 Country or City database. The file is read into memory
+// when it is opened.
+type GeoIPDatabase struct {
+	// Path is the file the database was read from
+	Path string
+
+	buffer     []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+}
+
+// GeoLocation is where a client IP is located
+type GeoLocation struct {
+	// Country is the ISO 3166-1 alpha-2 code of the country, such as DE
+	Country string
+	// Continent is the two letter code of the continent, such as EU
+	Continent string
+}
+
+// metadataMarker starts the metadata section at the end of the file
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// errGeoIPFormat reports a file that is not a valid MaxMind DB
+var errGeoIPFormat = errors.New("invalid MaxMind DB file")
+
+// OpenGeoIPDatabase reads a MaxMind DB file
+func OpenGeoIPDatabase(path string) (*GeoIPDatabase, error) {
+	buffer, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	start := bytes.LastIndex(buffer, metadataMarker)
+	if start < 0 {
+		return nil, fmt.Errorf("%s: %w: metadata not found", path, errGeoIPFormat)
+	}
+	metadata, _, err := decodeMMDB(buffer[start+len(metadataMarker):], 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %v", path, errGeoIPFormat, err)
+	}
+	fields, _ := metadata.(map[string]interface{})
+
+	db := &GeoIPDatabase{
+		Path:       path,
+		buffer:     buffer,
+		nodeCount:  uint(mmdbUint(fields["node_count"])),
+		recordSize: uint(mmdbUint(fields["record_size"])),
+		ipVersion:  uint(mmdbUint(fields["ip_version"])),
+	}
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("%s: %w: unsupported record size %d", path, errGeoIPFormat, db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("%s: %w: unsupported IP version %d", path, errGeoIPFormat, db.ipVersion)
+	}
+
+	// The search tree is followed by 16 zero bytes and the data section
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+16 > uint(start) {
+		return nil, fmt.Errorf("%s: %w: search tree exceeds the file", path, errGeoIPFormat)
+	}
+	db.data = buffer[treeSize+16 : start]
+
+	// IPv4 addresses are found under ::/96 of an IPv6 tree
+	if db.ipVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+
+	return db, nil
+}
+
+// Lookup locates an IP address. The location is empty when the database
+// does not know the address.
+func (db *GeoIPDatabase) Lookup(ip net.IP) GeoLocation {
+	record := db.search(ip)
+	if record < db.nodeCount+16 {
+		return GeoLocation{}
+	}
+
+	value, _, err := decodeMMDB(db.data, record-db.nodeCount-16, 0)
+	if err != nil {
+		return GeoLocation{}
+	}
+	fields, _ := value.(map[string]interface{})
+
+	location := GeoLocation{
+		Country:   mmdbPath(fields, "country", "iso_code"),
+		Continent: mmdbPath(fields, "continent", "code"),
+	}
+	// Anonymous proxies and satellite providers only have a registered country
+	if location.Country == "" {
+		location.Country = mmdbPath(fields, "registered_country", "iso_code")
+	}
+	return location
+}
+
+// search walks the search tree along the bits of an IP address and returns
+// the record it ends on: the node count when the address is unknown, a data
+// pointer above it otherwise
+func (db *GeoIPDatabase) search(ip net.IP) uint {
+	node, bits := uint(0), 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 32
+		if db.ipVersion == 6 {
+			node = db.ipv4Start
+		}
+	} else if ip = ip.To16(); ip == nil || db.ipVersion == 4 {
+		return db.nodeCount
+	}
+
+	for i := 0; i < bits && node < db.nodeCount; i++ {
+		bit := uint(ip[i>>3]>>(7-uint(i&7))) & 1
+		node = db.record(node, bit)
+	}
+	return node
+}
+
+// record returns the left (0) or right (1) record of a search tree node
+func (db *GeoIPDatabase) record(node, bit uint) uint {
+	tree := db.buffer
+	switch db.recordSize {
+	case 24:
+		offset := node*6 + bit*3
+		return uint(tree[offset])<<16 | uint(tree[offset+1])<<8 | uint(tree[offset+2])
+	case 28:
+		offset := node * 7
+		if bit == 0 {
+			return uint(tree[offset+3]&0xf0)<<20 | uint(tree[offset])<<16 | uint(tree[offset+1])<<8 | uint(tree[offset+2])
+		}
+		return uint(tree[offset+3]&0x0f)<<24 | uint(tree[offset+4])<<16 | uint(tree[offset+5])<<8 | uint(tree[offset+6])
+	default:
+		offset := node*8 + bit*4
+		return uint(binary.BigEndian.Uint32(tree[offset:]))
+	}
+}
+
+// MaxMind DB data types
+const (
+	mmdbExtended = iota
+	mmdbPointer
+	mmdbString
+	mmdbDouble
+	mmdbBytes
+	mmdbUint16
+	mmdbUint32
+	mmdbMap
+	mmdbInt32
+	mmdbUint64
+	mmdbUint128
+	mmdbArray
+	mmdbContainer
+	mmdbEndMarker
+	mmdbBool
+	mmdbFloat
+)
+
+// maxMMDBDepth bounds the nesting of maps, arrays and pointers, so a corrupt
+// file cannot recurse forever
+const maxMMDBDepth = 32
+
+// decodeMMDB decodes the value at an offset of a data section and returns
+// it with the offset following it
+func decodeMMDB(data []byte, offset uint, depth int) (interface{}, uint, error) {
+	if depth > maxMMDBDepth {
+		return nil, 0, errors.New("data nested too deeply")
+	}
+	next := func(n uint) ([]byte, error) {
+		if offset+n > uint(len(data)) {
+			return nil, errors.New("data section truncated")
+		}
+		b := data[offset : offset+n]
+		offset += n
+		return b, nil
+	}
+
+	b, err := next(1)
+	if err != nil {
+		return nil, 0, err
+	}
+	control := b[0]
+	kind := uint(control >> 5)
+
+	if kind == mmdbPointer {
+		size := uint(control>>3) & 3
+		b, err := next(size + 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		var pointer uint
+		if size < 3 {
+			pointer = uint(control & 7)
+		}
+		for _, c := range b {
+			pointer = pointer<<8 | uint(c)
+		}
+		pointer += [4]uint{0, 2048, 526336, 0}[size]
+		value, _, err := decodeMMDB(data, pointer, depth+1)
+		return value, offset, err
+	}
+
+	if kind == mmdbExtended {
+		b, err := next(1)
+		if err != nil {
+			return nil, 0, err
+		}
+		kind = 7 + uint(b[0])
+	}
+
+	size := uint(control & 0x1f)
+	if size >= 29 {
+		b, err := next(size - 28)
+		if err != nil {
+			return nil, 0, err
+		}
+		extra := uint(0)
+		for _, c := range b {
+			extra = extra<<8 | uint(c)
+		}
+		size = [3]uint{29, 285, 65821}[size-29] + extra
+	}
+
+	switch kind {
+	case mmdbMap:
+		fields := make(map[string]interface{})
+		for i := uint(0); i < size; i++ {
+			var key, value interface{}
+			if key, offset, err = decodeMMDB(data, offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			if value, offset, err = decodeMMDB(data, offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			fields[name] = value
+		}
+		return fields, offset, nil
+	case mmdbArray:
+		var values []interface{}
+		for i := uint(0); i < size; i++ {
+			var value interface{}
+			if value, offset, err = decodeMMDB(data, offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			values = append(values, value)
+		}
+		return values, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	}
+
+	b, err = next(size)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch kind {
+	case mmdbString:
+		return string(b), offset, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float size")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64, mmdbInt32:
+		if size > 8 {
+			return nil, 0, errors.New("invalid integer size")
+		}
+		value := uint64(0)
+		for _, c := range b {
+			value = value<<8 | uint64(c)
+		}
+		if kind == mmdbInt32 {
+			return int64(int32(value)), offset, nil
+		}
+		return value, offset, nil
+	case mmdbBytes, mmdbUint128:
+		return b, offset, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", kind)
+}
+
+// mmdbUint returns a decoded unsigned integer, or 0 if the value is not one
+func mmdbUint(value interface{}) uint64 {
+	n, _ := value.(uint64)
+	return n
+}
+
+// mmdbPath returns the string found under a path of map keys, or "" if
+// there is none
+func mmdbPath(fields map[string]interface{}, path ...string) string {
+	for i, key := range path {
+		value := fields[key]
+		if i == len(path)-1 {
+			s, _ := value.(string)
+			return s
+		}
+		fields, _ = value.(map[string]interface{})
+	}
+	return ""
+}
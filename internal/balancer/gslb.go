@@ -0,0 +1,284 @@
+package balancer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// GSLBConfig holds the settings of the health export consumed by a global
+// server load balancer
+type GSLBConfig struct {
+	// MinScore is the score below which a pool is reported unhealthy
+	MinScore float64
+	// PushURL receives the health report every Interval, if set
+	PushURL  string
+	Interval time.Duration
+	// Instance identifies this balancer in the report
+	Instance string
+}
+
+// parseGSLB parses the arguments of a gslb directive
+func parseGSLB(parts []string) (GSLBConfig, error) {
+	config := GSLBConfig{MinScore: 0.5, Interval: 30 * time.Second}
+
+	for i := 1; i < len(parts); i++ {
+		if strings.HasPrefix(parts[i], "min_score=") {
+			scoreStr := strings.TrimPrefix(parts[i], "min_score=")
+			score, err := strconv.ParseFloat(scoreStr, 64)
+			if err != nil || score < 0 || score > 1 {
+				return GSLBConfig{}, fmt.Errorf("invalid gslb min_score: %s", scoreStr)
+			}
+			config.MinScore = score
+		} else if strings.HasPrefix(parts[i], "push=") {
+			config.PushURL = strings.TrimPrefix(parts[i], "push=")
+		} else if strings.HasPrefix(parts[i], "interval=") {
+			intervalStr := strings.TrimPrefix(parts[i], "interval=")
+			interval, err := time.ParseDuration(intervalStr)
+			if err != nil || interval <= 0 {
+				return GSLBConfig{}, fmt.Errorf("invalid gslb interval: %s", intervalStr)
+			}
+			config.Interval = interval
+		} else if strings.HasPrefix(parts[i], "instance=") {
+			config.Instance = strings.TrimPrefix(parts[i], "instance=")
+		}
+	}
+
+	return config, nil
+}
+
+// PoolHealth describes how much of a pool's capacity is available.
+// HealthyFraction is the share of the pool's weight on backends that are
+// alive and not draining, and Capacity the share of the connection slots of
+// those backends, and of the pool's concurrency ceiling, still free. Score is
+// their product: the share of the pool's full capacity this instance can
+// serve right now.
+type PoolHealth struct {
+	Pool            string  `json:"pool"`
+	Backends        int     `json:"backends"`
+	HealthyBackends int     `json:"healthyBackends"`
+	HealthyFraction float64 `json:"healthyFraction"`
+	Capacity        float64 `json:"capacity"`
+	Score           float64 `json:"score"`
+	Healthy         bool    `json:"healthy"`
+}
+
+// GSLBReport is the health of every pool of this instance
+type GSLBReport struct {
+	Instance  string       `json:"instance"`
+	Timestamp time.Time    `json:"timestamp"`
+	MinScore  float64      `json:"minScore"`
+	Pools     []PoolHealth `json:"pools"`
+}
+
+// GetGSLBReport computes the health of every pool
+func GetGSLBReport(lb LoadBalancerStrategy, config GSLBConfig) GSLBReport {
+	report := GSLBReport{
+		Instance:  config.Instance,
+		Timestamp: time.Now(),
+		MinScore:  config.MinScore,
+		Pools:     []PoolHealth{},
+	}
+	if report.Instance == "" {
+		report.Instance, _ = os.Hostname()
+	}
+
+	pools := namedPools(lb)
+	names := make([]string, 0, len(pools))
+	for name := range pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		report.Pools = append(report.Pools, poolHealth(name, pools[name], config.MinScore))
+	}
+	return report
+}
+
+// namedPools returns the strategy of every pool by name. Without path
+// routing there is a single pool, named backend.
+func namedPools(lb LoadBalancerStrategy) map[string]LoadBalancerStrategy {
+	for {
+		switch typed := lb.(type) {
+		case *PathRouter:
+			return typed.backendPools
+		case strategyWrapper:
+			lb = typed.Unwrap()
+		default:
+			return map[string]LoadBalancerStrategy{"backend": lb}
+		}
+	}
+}
+
+// poolHealth computes the health of a pool
+func poolHealth(name string, pool LoadBalancerStrategy, minScore float64) PoolHealth {
+	health := PoolHealth{Pool: name}
+
+	var totalWeight, healthyWeight, freeWeight float64
+	weights := strategyWeights(pool)
+	for _, p := range strategyProcesses(pool) {
+		weight := float64(max(weights[p], 1))
+		totalWeight += weight
+		health.Backends++
+
+		if !p.IsAlive() || p.IsDraining() {
+			continue
+		}
+		health.HealthyBackends++
+		healthyWeight += weight
+
+		free := 1.0
+		if p.MaxConns > 0 {
+			free = max(0, 1-float64(p.GetActiveConnections())/float64(p.MaxConns))
+		}
+		freeWeight += weight * free
+	}
+
+	if totalWeight > 0 {
+		health.HealthyFraction = healthyWeight / totalWeight
+	}
+	if healthyWeight > 0 {
+		health.Capacity = freeWeight / healthyWeight
+	}
+
+	// A pool ceiling bounds the capacity whatever the backends could take
+	if limiter := findPoolLimiter(pool); limiter != nil && limiter.config.MaxConcurrent > 0 {
+		free := max(0, 1-float64(atomic.LoadInt32(&limiter.inFlight))/float64(limiter.config.MaxConcurrent))
+		health.Capacity = min(health.Capacity, free)
+	}
+
+	health.Score = health.HealthyFraction * health.Capacity
+	health.Healthy = health.Backends > 0 && health.Score >= minScore
+	return health
+}
+
+// findPoolLimiter returns the ceilings wrapping a pool's strategy, if any
+func findPoolLimiter(lb LoadBalancerStrategy) *PoolLimiter {
+	for {
+		switch typed := lb.(type) {
+		case *PoolLimiter:
+			return typed
+		case strategyWrapper:
+			lb = typed.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// GSLBHandler serves the health report of every pool at /api/gslb and the
+// health of a single pool at /api/gslb/<pool>. A pool answers 200 when its
+// score reaches the minimum and 503 otherwise, so it can be the target of a
+// plain HTTP health check such as Route 53's.
+func GSLBHandler(lb LoadBalancerStrategy, config GSLBConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		report := GetGSLBReport(lb, config)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+
+		pool := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/gslb"), "/")
+		if pool == "" {
+			json.NewEncoder(w).Encode(report)
+			return
+		}
+
+		for _, health := range report.Pools {
+			if health.Pool != pool {
+				continue
+			}
+			if !health.Healthy {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			json.NewEncoder(w).Encode(health)
+			return
+		}
+		apiError(w, fmt.Errorf("%w: %s", ErrPoolNotFound, pool))
+	}
+}
+
+// GSLBPusher periodically posts the health report to a global server load
+// balancer that does not poll instances itself
+type GSLBPusher struct {
+	lb     LoadBalancerStrategy
+	config GSLBConfig
+	client *http.Client
+	stop   chan struct{}
+}
+
+// NewGSLBPusher creates a pusher, or returns nil if pushing is disabled
+func NewGSLBPusher(lb LoadBalancerStrategy, config GSLBConfig) *GSLBPusher {
+	if config.PushURL == "" {
+		return nil
+	}
+	return &GSLBPusher{
+		lb:     lb,
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+		stop:   make(chan struct{}),
+	}
+}
+
+// Start begins pushing in the background, starting with an immediate push
+func (gp *GSLBPusher) Start() {
+	if gp == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(gp.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			gp.push()
+
+			select {
+			case <-ticker.C:
+			case <-gp.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends pushing
+func (gp *GSLBPusher) Stop() {
+	if gp == nil {
+		return
+	}
+	close(gp.stop)
+}
+
+func (gp *GSLBPusher) push() {
+	body, err := json.Marshal(GetGSLBReport(gp.lb, gp.config))
+	if err != nil {
+		logger.Log.Error("Failed to encode GSLB report", zap.Error(err))
+		return
+	}
+
+	resp, err := gp.client.Post(gp.config.PushURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logger.Log.Warn("Failed to push GSLB report", zap.Error(err))
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		logger.Log.Warn("GSLB report rejected", zap.Int("status", resp.StatusCode))
+	}
+}
@@ -0,0 +1,183 @@
+package balancer
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// HeaderOp defines the operation applied to a header
+type HeaderOp int
+
+const (
+	// HeaderSet replaces any existing values of the header
+	HeaderSet HeaderOp = iota
+	// HeaderAdd appends a value to the header
+	HeaderAdd
+	// HeaderRemove deletes the header
+	HeaderRemove
+)
+
+// HeaderAction is a single header manipulation
+type HeaderAction struct {
+	Op    HeaderOp
+	Name  string
+	Value string
+}
+
+// HeaderRules holds the header manipulations applied to requests and responses
+type HeaderRules struct {
+	Request  []HeaderAction
+	Response []HeaderAction
+}
+
+// IsEmpty returns true if there are no header manipulations
+func (h HeaderRules) IsEmpty() bool {
+	return len(h.Request) == 0 && len(h.Response) == 0
+}
+
+func applyHeaderActions(header http.Header, actions []HeaderAction) {
+	for _, action := range actions {
+		switch action.Op {
+		case HeaderSet:
+			header.Set(action.Name, action.Value)
+		case HeaderAdd:
+			header.Add(action.Name, action.Value)
+		case HeaderRemove:
+			header.Del(action.Name)
+		}
+	}
+}
+
+// parseHeaderDirective parses a header manipulation directive into an action
+func parseHeaderDirective(parts []string) (HeaderAction, bool, error) {
+	directive := parts[0]
+
+	var op HeaderOp
+	var response bool
+
+	switch directive {
+	case "set_header":
+		op = HeaderSet
+	case "add_header":
+		op = HeaderAdd
+	case "remove_header":
+		op = HeaderRemove
+	case "set_response_header":
+		op, response = HeaderSet, true
+	case "add_response_header":
+		op, response = HeaderAdd, true
+	case "hide_header":
+		op, response = HeaderRemove, true
+	}
+
+	if op == HeaderRemove {
+		if len(parts) < 2 {
+			return HeaderAction{}, false, fmt.Errorf("%s directive requires a header name", directive)
+		}
+		return HeaderAction{Op: op, Name: parts[1]}, response, nil
+	}
+
+	if len(parts) < 3 {
+		return HeaderAction{}, false, fmt.Errorf("%s directive requires a header name and value", directive)
+	}
+
+	value := parts[2]
+	for _, p := range parts[3:] {
+		value += " " + p
+	}
+
+	return HeaderAction{Op: op, Name: parts[1], Value: value}, response, nil
+}
+
+// HeaderRewriter applies header rules around another load balancing strategy
+type HeaderRewriter struct {
+	next  LoadBalancerStrategy
+	rules HeaderRules
+}
+
+// NewHeaderRewriter wraps a strategy with header manipulation.
+// The strategy is returned unchanged if there are no rules.
+func NewHeaderRewriter(next LoadBalancerStrategy, rules HeaderRules) LoadBalancerStrategy {
+	if rules.IsEmpty() {
+		return next
+	}
+	return &HeaderRewriter{
+		next:  next,
+		rules: rules,
+	}
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (h *HeaderRewriter) GetNextInstance(r *http.Request) (*url.URL, error) {
+	return h.next.GetNextInstance(r)
+}
+
+// ProxyRequest applies the request rules, proxies the request and applies the
+// response rules before the response headers are written
+func (h *HeaderRewriter) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	if len(h.rules.Request) > 0 {
+		r = r.Clone(r.Context())
+		applyHeaderActions(r.Header, h.rules.Request)
+	}
+
+	if len(h.rules.Response) > 0 {
+		w = &headerRewriteWriter{
+			ResponseWriter: w,
+			actions:        h.rules.Response,
+		}
+	}
+
+	h.next.ProxyRequest(w, r)
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (h *HeaderRewriter) SupportsWebSockets() bool {
+	return h.next.SupportsWebSockets()
+}
+
+// Unwrap returns the wrapped strategy
+func (h *HeaderRewriter) Unwrap() LoadBalancerStrategy {
+	return h.next
+}
+
+type headerRewriteWriter struct {
+	http.ResponseWriter
+	actions     []HeaderAction
+	wroteHeader bool
+}
+
+func (w *headerRewriteWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		applyHeaderActions(w.ResponseWriter.Header(), w.actions)
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *headerRewriteWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *headerRewriteWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *headerRewriteWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *headerRewriteWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, fmt.Errorf("response writer does not implement http.Hijacker")
+}
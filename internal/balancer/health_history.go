@@ -0,0 +1,55 @@
+package balancer
+
+import (
+	"sync"
+	"time"
+)
+
+// HealthEvent is a change in the health or drain state of a backend
+type HealthEvent struct {
+	Time    time.Time `json:"time"`
+	Backend string    `json:"backend"`
+	// State is up, down, draining, ready, registered or deregistered
+	State string `json:"state"`
+}
+
+// healthHistorySize is the number of health events kept
+const healthHistorySize = 500
+
+var (
+	healthHistory      = make([]HealthEvent, healthHistorySize)
+	healthHistoryNext  int
+	healthHistoryCount int
+	healthHistoryMu    sync.Mutex
+)
+
+func recordHealthEvent(p *Process, state string) {
+	event := HealthEvent{Time: time.Now(), State: state}
+	if p.URL != nil {
+		event.Backend = p.URL.Redacted()
+	}
+
+	publishEvent(BackendEventType, event)
+
+	healthHistoryMu.Lock()
+	defer healthHistoryMu.Unlock()
+
+	healthHistory[healthHistoryNext] = event
+	healthHistoryNext = (healthHistoryNext + 1) % healthHistorySize
+	if healthHistoryCount < healthHistorySize {
+		healthHistoryCount++
+	}
+}
+
+// GetHealthHistory returns the most recent backend health changes, oldest first
+func GetHealthHistory() []HealthEvent {
+	healthHistoryMu.Lock()
+	defer healthHistoryMu.Unlock()
+
+	events := make([]HealthEvent, 0, healthHistoryCount)
+	start := (healthHistoryNext - healthHistoryCount + healthHistorySize) % healthHistorySize
+	for i := 0; i < healthHistoryCount; i++ {
+		events = append(events, healthHistory[(start+i)%healthHistorySize])
+	}
+	return events
+}
@@ -17,6 +17,9 @@ const (
 	LeastConnections
 	// PathBasedRouting routes requests based on URL paths, headers, or patterns
 	PathBasedRouting
+	// GeoWeighted distributes requests proportionally to backend weights,
+	// preferring the backends in the region of the client
+	GeoWeighted
 )
 
 // PersistenceMethod represents the session persistence method
@@ -31,6 +34,12 @@ const (
 	IPHashPersistence
 	// ConsistentHashPersistence uses a consistent hashing algorithm
 	ConsistentHashPersistence
+	// FingerprintPersistence hashes the client IP together with its TLS or
+	// header fingerprint, for clients that strip cookies behind shared NAT
+	FingerprintPersistence
+	// UploadSessionPersistence pins the requests sharing an upload session
+	// identifier to one backend for the session duration
+	UploadSessionPersistence
 )
 
 // LoadBalancerStrategy defines the interface for load balancing strategies
@@ -43,6 +52,11 @@ type LoadBalancerStrategy interface {
 	SupportsWebSockets() bool
 }
 
+// strategyWrapper is implemented by strategies that wrap another strategy
+type strategyWrapper interface {
+	Unwrap() LoadBalancerStrategy
+}
+
 // CreateLoadBalancer creates a load balancer with the specified algorithm
 func CreateLoadBalancer(
 	algorithm LoadBalancerAlgorithm,
@@ -61,6 +75,8 @@ func CreateLoadBalancer(
 		baseBalancer = NewWeightedRoundRobin(backends)
 	case LeastConnections:
 		baseBalancer = NewLeastConnections(backends)
+	case GeoWeighted:
+		baseBalancer = NewGeoWeighted(backends)
 	default:
 		return nil, ErrInvalidConfig{Message: "unsupported load balancing algorithm"}
 	}
@@ -84,40 +100,173 @@ func CreatePathRouter(
 	backendPools := make(map[string]LoadBalancerStrategy)
 
 	// First create the default backend pool
-	defaultPool, exists := config.BackendPools[config.DefaultBackend]
-	if !exists {
-		return nil, ErrInvalidConfig{Message: "default backend pool not found: " + config.DefaultBackend}
+	if _, exists := config.BackendPools[config.DefaultBackend]; !exists {
+		return nil, poolNotFound(0, "default backend pool", config.DefaultBackend)
 	}
 
 	defaultLB, err := CreateLoadBalancer(
 		config.Method,
-		defaultPool,
+		config.PoolBackends(config.DefaultBackend),
 		config.PersistenceType,
 		config.PersistenceAttrs,
 	)
 	if err != nil {
 		return nil, err
 	}
-	backendPools[config.DefaultBackend] = defaultLB
+	backendPools[config.DefaultBackend] = ApplyPoolMiddleware(defaultLB, config, config.DefaultBackend)
 
 	// Create load balancers for all other backend pools
-	for name, pool := range config.BackendPools {
+	for name := range config.BackendPools {
 		if name == config.DefaultBackend {
 			continue // Already created
 		}
 
 		lb, err := CreateLoadBalancer(
 			config.Method,
-			pool,
+			config.PoolBackends(name),
 			config.PersistenceType,
 			config.PersistenceAttrs,
 		)
 		if err != nil {
 			return nil, err
 		}
-		backendPools[name] = lb
+		backendPools[name] = ApplyPoolMiddleware(lb, config, name)
+	}
+
+	// Replace the primary pool of each failover chain with the chain itself
+	chains := make(map[string]LoadBalancerStrategy)
+	for primary, failover := range config.Failovers {
+		chain, err := NewFailoverChain(failover, backendPools)
+		if err != nil {
+			return nil, err
+		}
+		chains[primary] = chain
+	}
+	for primary, chain := range chains {
+		backendPools[primary] = chain
+	}
+
+	// Mirror pools to their shadow pools as configured, not as mirrored
+	shadows := make(map[string]LoadBalancerStrategy, len(backendPools))
+	for name, pool := range backendPools {
+		shadows[name] = pool
+	}
+	for pool, mirror := range config.Mirrors {
+		if _, exists := backendPools[pool]; !exists {
+			return nil, poolNotFound(0, "mirrored pool", pool)
+		}
+		if _, exists := shadows[mirror.Shadow]; !exists {
+			return nil, poolNotFound(0, "shadow pool", mirror.Shadow)
+		}
+		backendPools[pool] = NewMirror(backendPools[pool], shadows[mirror.Shadow], mirror)
 	}
 
 	// Create the path router with all backend pools
-	return NewPathRouter(config.Routes, backendPools, config.DefaultBackend)
+	router, err := NewPathRouter(config.Routes, backendPools, config.DefaultBackend)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := router.applyRouteMiddleware(config); err != nil {
+		return nil, err
+	}
+
+	// Other listeners route over the same pools
+	if len(config.Listeners) > 0 {
+		listeners, err := newListenerRouter(router, config)
+		if err != nil {
+			return nil, err
+		}
+		return listeners, nil
+	}
+	return router, nil
+}
+
+// ApplyPoolMiddleware wraps a backend pool's strategy with the middleware
+// configured inside its upstream block
+func ApplyPoolMiddleware(lb LoadBalancerStrategy, config *Config, pool string) LoadBalancerStrategy {
+	setRequestQueue(lb, NewRequestQueue(config.PoolQueues[pool]))
+	setCompat(lb, config.PoolCompat[pool])
+	setAntiAffinity(lb, config.PoolAntiAffinity[pool])
+	setRewriteRedirects(lb, config.PoolRedirects[pool])
+	setGeoIP(lb, config.GeoIP)
+	setMinAlive(lb, config.PoolMinAlive[pool])
+	if revival, ok := config.PoolRevivals[pool]; ok {
+		setRevival(lb, revival)
+	}
+	if limits, ok := config.PoolWebSockets[pool]; ok {
+		setWebSocketLimits(lb, limits)
+	}
+	if retry, ok := config.PoolRetries[pool]; ok {
+		setRetry(lb, retry)
+	}
+	lb = NewHeaderRewriter(lb, config.PoolHeaders[pool])
+	lb = NewPoolLimiter(lb, config.PoolLimits[pool])
+	lb = NewRateLimiter(lb, config.PoolRateLimits[pool])
+	return lb
+}
+
+// ApplyRouteMiddleware wraps the pool a route leads to with the middleware
+// configured on the route
+func ApplyRouteMiddleware(lb LoadBalancerStrategy, config *Config, route RouteConfig) (LoadBalancerStrategy, error) {
+	var request, response *JSONSchema
+	var err error
+
+	if route.RequestSchema != "" {
+		if request, err = LoadJSONSchema(route.RequestSchema); err != nil {
+			return nil, err
+		}
+	}
+	if route.ResponseSchema != "" {
+		if response, err = LoadJSONSchema(route.ResponseSchema); err != nil {
+			return nil, err
+		}
+	}
+
+	// Bodies are rewritten as they come from the backend, before anything
+	// else sees them
+	lb = NewResponseTransformer(lb, config.Transforms[route.Transform])
+	lb = NewSchemaValidator(lb, routeName(route), request, response)
+
+	// Responses are validated before they are cached, not on every hit
+	if lb, err = NewResponseCache(lb, config.CacheZones[route.Cache]); err != nil {
+		return nil, err
+	}
+	// Cached responses are only served to authenticated clients
+	if lb, err = NewAuthenticator(lb, config.AuthPolicies[route.Auth]); err != nil {
+		return nil, err
+	}
+	if route.RateLimit != "" {
+		lb = NewRateLimiter(lb, config.LimitPolicies[route.RateLimit])
+	}
+	// Custom middleware sees every request of the route, limited or not
+	if lb, err = newMiddlewareChain(lb, route.Middlewares, config.lookupMiddleware); err != nil {
+		return nil, err
+	}
+	// The timeout covers the route's middleware, and an error page may
+	// replace its response
+	lb = newRouteTimeout(lb, route.Timeout)
+	// Outermost, so the page covers every gateway error of the route
+	if lb, err = NewErrorPages(lb, config.ErrorPages[route.ErrorPage]); err != nil {
+		return nil, err
+	}
+	// Deadlines are lifted before any middleware waits on them
+	return NewStreamingRoute(lb, route.Streaming), nil
+}
+
+// ApplyGlobalMiddleware wraps the top-level strategy with the middleware
+// configured outside of any upstream block
+func ApplyGlobalMiddleware(lb LoadBalancerStrategy, config *Config) LoadBalancerStrategy {
+	lb = NewRoutingOverride(lb, config.RoutingOverride)
+	lb = NewRequestTimeout(lb, config.RequestTimeout)
+	lb = NewCompressor(lb, config.Compression)
+	lb = NewHeaderRewriter(lb, config.Headers)
+	lb = NewRateLimiter(lb, config.RateLimit)
+	// Bots are turned away before they use up anyone's rate limit
+	lb = NewBotFilter(lb, config.BlockRules)
+	// Shedding is the first thing done under overload, and the cheapest
+	lb = NewLoadShedder(lb, config.Overload)
+	// Blocked requests are measured too
+	lb = NewRequestMetrics(lb, config.Metrics)
+	return lb
 }
@@ -0,0 +1,112 @@
+package balancer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// KeepAliveConfig holds the settings of idle connection probing
+type KeepAliveConfig struct {
+	Interval time.Duration
+	Path     string
+}
+
+// parseKeepAlive parses the arguments of a keepalive_probe directive
+func parseKeepAlive(parts []string) (KeepAliveConfig, error) {
+	config := KeepAliveConfig{Interval: 30 * time.Second, Path: "/"}
+
+	for i := 1; i < len(parts); i++ {
+		if strings.HasPrefix(parts[i], "interval=") {
+			intervalStr := strings.TrimPrefix(parts[i], "interval=")
+			interval, err := time.ParseDuration(intervalStr)
+			if err != nil || interval <= 0 {
+				return KeepAliveConfig{}, fmt.Errorf("invalid keepalive_probe interval: %s", intervalStr)
+			}
+			config.Interval = interval
+		} else if strings.HasPrefix(parts[i], "path=") {
+			config.Path = strings.TrimPrefix(parts[i], "path=")
+		}
+	}
+
+	return config, nil
+}
+
+// KeepAlivePinger periodically sends a light HEAD request to every backend
+// over the shared connection pool, so idle connections silently broken by
+// middleboxes are found and discarded before a real request uses them
+type KeepAlivePinger struct {
+	lb     LoadBalancerStrategy
+	config KeepAliveConfig
+	stop   chan struct{}
+}
+
+// NewKeepAlivePinger creates a pinger, or returns nil if probing is disabled
+func NewKeepAlivePinger(lb LoadBalancerStrategy, config KeepAliveConfig) *KeepAlivePinger {
+	if config.Interval <= 0 {
+		return nil
+	}
+	return &KeepAlivePinger{
+		lb:     lb,
+		config: config,
+		stop:   make(chan struct{}),
+	}
+}
+
+// Start begins probing in the background
+func (kp *KeepAlivePinger) Start() {
+	if kp == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(kp.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				kp.probe()
+			case <-kp.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends probing
+func (kp *KeepAlivePinger) Stop() {
+	if kp == nil {
+		return
+	}
+	close(kp.stop)
+}
+
+func (kp *KeepAlivePinger) probe() {
+	stale := false
+
+	for _, p := range strategyProcesses(kp.lb) {
+		if !p.IsAlive() {
+			continue
+		}
+
+		resp, err := probeBackend(p, kp.config.Path, 5*time.Second)
+		if err != nil {
+			logger.Health.Warn("Keep-alive probe failed",
+				zap.String("backend", p.URL.String()),
+				zap.Error(err))
+			stale = true
+			continue
+		}
+		resp.Body.Close()
+	}
+
+	// Other idle connections opened alongside the broken one are likely
+	// broken as well, so start over with fresh connections
+	if stale {
+		closeIdleBackendConnections()
+	}
+}
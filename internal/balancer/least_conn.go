@@ -1,22 +1,20 @@
 package balancer
 
 import (
-	"bufio"
-	"fmt"
 	"math"
-	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
-	"sync/atomic"
-	"time"
+	"sync"
 
 	"github.com/The-iyed/go-load-balancer/internal/logger"
 	"go.uber.org/zap"
 )
 
 type LeastConnectionsBalancer struct {
+	Generation  uint64
 	ProcessPack []*Process
+	PoolSettings
+	mu sync.RWMutex
 }
 
 func NewLeastConnectionsBalancer(configs []BackendConfig) *LeastConnectionsBalancer {
@@ -35,22 +33,41 @@ func NewLeastConnectionsBalancer(configs []BackendConfig) *LeastConnectionsBalan
 			ErrorCount:        0,
 			Weight:            config.Weight,
 			ActiveConnections: 0,
+			MaxConns:          int32(config.MaxConns),
+			MaxWebSockets:     int32(config.MaxWebSockets),
+			Host:              config.Host,
+			ServerName:        config.ServerName,
+			Prefix:            config.Prefix,
+			Zone:              config.Zone,
+			Region:            config.Region,
+			Priority:          config.Priority,
 		}
 
 		processes = append(processes, process)
 	}
 
-	return &LeastConnectionsBalancer{
+	lb := &LeastConnectionsBalancer{
 		ProcessPack: processes,
 	}
+	lb.tiered = hasPriorities(processes)
+	return lb
 }
 
 func (lb *LeastConnectionsBalancer) GetNextInstance(r *http.Request) *Process {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	return pickAvoidingZones(r, avoidedZones(r, lb.AntiAffinity), lb.priorityTier(lb.ProcessPack), lb.leastConnected)
+}
+
+// leastConnected returns the eligible backend of priority tier or below with
+// the fewest connections
+func (lb *LeastConnectionsBalancer) leastConnected(eligible func(*Process) bool, tier int) *Process {
 	var minConnections int32 = math.MaxInt32
 	var selectedIndex = -1
 
 	for i, p := range lb.ProcessPack {
-		if !p.IsAlive() {
+		if p.Priority > tier || !p.Available() || !eligible(p) {
 			continue
 		}
 
@@ -73,88 +90,72 @@ func (lb *LeastConnectionsBalancer) GetNextInstance(r *http.Request) *Process {
 	return lb.ProcessPack[selectedIndex]
 }
 
-func (lb *LeastConnectionsBalancer) ProxyRequest(w http.ResponseWriter, r *http.Request) {
-	target := lb.GetNextInstance(r)
-	if target == nil {
-		http.Error(w, "No healthy backends available", http.StatusServiceUnavailable)
-		return
-	}
+// UpdateBackends swaps in a new generation of backends and returns its
+// number. Backends that survive the swap keep their health and counters.
+func (lb *LeastConnectionsBalancer) UpdateBackends(configs []BackendConfig) uint64 {
+	fresh := NewLeastConnectionsBalancer(configs).ProcessPack
 
-	if IsWebSocketRequest(r) && lb.SupportsWebSockets() {
-		wsProxy := NewWebSocketProxy(target, func(p *Process) {
-			go lb.reviveLater(p)
-		})
-		wsProxy.ProxyWebSocket(w, r)
-		return
-	}
-
-	target.IncrementConnections()
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
 
-	proxy := httputil.NewSingleHostReverseProxy(target.URL)
-
-	rwWriter := &responseWriterInterceptor{
-		ResponseWriter: w,
-		process:        target,
-	}
+	processes, kept, removed := mergeBackends(lb.ProcessPack, fresh, lb.DrainTimeout)
+	lb.ProcessPack = processes
+	lb.tiered = hasPriorities(processes)
+	lb.Generation++
+	lb.forgetRevivals(removed)
 
-	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
-		logger.Log.Error("Request failed",
-			zap.String("backend", target.URL.String()),
-			zap.Error(err),
-		)
+	logger.Log.Info("Backend pool swapped",
+		zap.Uint64("generation", lb.Generation),
+		zap.Int("backends", len(processes)),
+		zap.Int("kept", len(kept)))
 
-		target.DecrementConnections()
+	return lb.Generation
+}
 
-		atomic.AddInt32(&target.ErrorCount, 1)
-		if atomic.LoadInt32(&target.ErrorCount) >= 3 {
-			target.SetAlive(false)
-			logger.Log.Warn("Backend marked dead", zap.String("backend", target.URL.String()))
-			go lb.reviveLater(target)
-		}
+// SetWeight changes the weight of one of the pool's backends, which breaks
+// ties between equally loaded backends
+func (lb *LeastConnectionsBalancer) SetWeight(p *Process, weight int) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
 
-		lb.ProxyRequest(w, r)
-	}
+	p.Weight = weight
+}
 
-	proxy.ServeHTTP(rwWriter, r)
+// Weights returns the weights of the pool's backends
+func (lb *LeastConnectionsBalancer) Weights() map[*Process]int {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return processWeights(lb.ProcessPack)
 }
 
-func (lb *LeastConnectionsBalancer) reviveLater(p *Process) {
-	time.Sleep(10 * time.Second)
-	p.SetAlive(true)
-	atomic.StoreInt32(&p.ErrorCount, 0)
-	logger.Log.Info("Backend revived", zap.String("backend", p.URL.String()))
+func (lb *LeastConnectionsBalancer) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	proxyToPool(lb, w, r)
 }
 
 func (lb *LeastConnectionsBalancer) SupportsWebSockets() bool {
 	return true
 }
 
-type responseWriterInterceptor struct {
-	http.ResponseWriter
-	process *Process
+// Pick implements the Picker interface
+func (lb *LeastConnectionsBalancer) Pick(r *http.Request) *Process {
+	return lb.GetNextInstance(r)
 }
 
-func (w *responseWriterInterceptor) WriteHeader(statusCode int) {
-	w.ResponseWriter.WriteHeader(statusCode)
+// Backends returns the backends of the current generation
+func (lb *LeastConnectionsBalancer) Backends() []*Process {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return lb.ProcessPack
 }
 
-func (w *responseWriterInterceptor) Write(b []byte) (int, error) {
-	n, err := w.ResponseWriter.Write(b)
-	if err == nil {
-		w.process.DecrementConnections()
-	}
-	return n, err
+func (lb *LeastConnectionsBalancer) Method() string {
+	return "Least Connections"
 }
 
-func (w *responseWriterInterceptor) Flush() {
-	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
-		flusher.Flush()
-	}
+func (lb *LeastConnectionsBalancer) Persistence() PersistenceMethod {
+	return NoPersistence
 }
 
-func (w *responseWriterInterceptor) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
-		return hijacker.Hijack()
-	}
-	return nil, nil, fmt.Errorf("response writer does not implement http.Hijacker")
+func (lb *LeastConnectionsBalancer) Settings() *PoolSettings {
+	return &lb.PoolSettings
 }
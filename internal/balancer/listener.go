@@ -0,0 +1,164 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ListenerConfig is a listener block: another address the balancer serves,
+// plain or with TLS, with its own routes and default pool
+type ListenerConfig struct {
+	Name string
+	// Listen is the address to listen on, such as :8443
+	Listen string
+	// TLSCertificates terminate TLS on the listener if any are set
+	TLSCertificates []TLSCertificateConfig
+	Routes          []RouteConfig
+	// DefaultBackend is the pool of the requests no route matches, the
+	// global default pool if empty
+	DefaultBackend string
+	// Line is the configuration file line the listener is defined on
+	Line int
+}
+
+// parseListenerDirective parses a directive inside a listener block. Routes
+// are parsed like the global ones.
+func parseListenerDirective(listener *ListenerConfig, parts []string) error {
+	switch parts[0] {
+	case "listen":
+		if len(parts) < 2 {
+			return fmt.Errorf("listen directive requires an address")
+		}
+		addr := strings.TrimSuffix(parts[1], ";")
+		// A bare port listens on every interface
+		if _, err := strconv.Atoi(addr); err == nil {
+			addr = ":" + addr
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("invalid listen address: %s", parts[1])
+		}
+		listener.Listen = addr
+	case "tls_certificate":
+		if len(parts) < 3 {
+			return fmt.Errorf("tls_certificate directive requires a certificate and key file")
+		}
+		listener.TLSCertificates = append(listener.TLSCertificates, TLSCertificateConfig{
+			CertFile: parts[1],
+			KeyFile:  strings.TrimSuffix(parts[2], ";"),
+		})
+	case "default_backend":
+		if len(parts) < 2 {
+			return fmt.Errorf("default_backend directive requires a backend pool name")
+		}
+		listener.DefaultBackend = strings.TrimSuffix(parts[1], ";")
+	default:
+		return fmt.Errorf("%s directive must not be inside a listener block", parts[0])
+	}
+	return nil
+}
+
+type listenerContextKey struct{}
+
+// WithListener returns the request tagged with the name of the listener it
+// arrived on, so it takes the routes of that listener
+func WithListener(r *http.Request, name string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), listenerContextKey{}, name))
+}
+
+// listenerName returns the listener a request arrived on, or "" for the
+// main one
+func listenerName(r *http.Request) string {
+	name, _ := r.Context().Value(listenerContextKey{}).(string)
+	return name
+}
+
+// ListenerRouter routes the requests of each listener with the routes of
+// that listener, and the requests of the main listener with the global
+// routes. Every listener shares the pools of the main router, so backends
+// are balanced, health checked and counted once however many listeners
+// reach them.
+type ListenerRouter struct {
+	main      *PathRouter
+	listeners map[string]*PathRouter
+}
+
+// newListenerRouter creates the routers of the listeners of a configuration
+// over the pools of the main router
+func newListenerRouter(main *PathRouter, config *Config) (*ListenerRouter, error) {
+	lr := &ListenerRouter{main: main, listeners: make(map[string]*PathRouter, len(config.Listeners))}
+	for _, listener := range config.Listeners {
+		defaultPool := listener.DefaultBackend
+		if defaultPool == "" {
+			defaultPool = main.defaultPoolID
+		}
+		if _, exists := main.backendPools[defaultPool]; !exists {
+			return nil, poolNotFound(listener.Line, "listener default backend pool", defaultPool)
+		}
+
+		router, err := NewPathRouter(listener.Routes, main.backendPools, defaultPool)
+		if err != nil {
+			return nil, err
+		}
+		if err := router.applyRouteMiddleware(config); err != nil {
+			return nil, err
+		}
+		lr.listeners[listener.Name] = router
+	}
+	return lr, nil
+}
+
+// router returns the router of the listener a request arrived on
+func (lr *ListenerRouter) router(r *http.Request) *PathRouter {
+	if router, ok := lr.listeners[listenerName(r)]; ok {
+		return router
+	}
+	return lr.main
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (lr *ListenerRouter) GetNextInstance(r *http.Request) (*url.URL, error) {
+	return lr.router(r).GetNextInstance(r)
+}
+
+// ProxyRequest routes the request with the routes of its listener
+func (lr *ListenerRouter) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	lr.router(r).ProxyRequest(w, r)
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (lr *ListenerRouter) SupportsWebSockets() bool {
+	return lr.main.SupportsWebSockets()
+}
+
+// Unwrap returns the main router, which holds every pool
+func (lr *ListenerRouter) Unwrap() LoadBalancerStrategy {
+	return lr.main
+}
+
+// pathRouters returns the path routers behind a strategy by listener name,
+// the main one under ""
+func pathRouters(lb LoadBalancerStrategy) map[string]*PathRouter {
+	for lb != nil {
+		switch router := lb.(type) {
+		case *PathRouter:
+			return map[string]*PathRouter{"": router}
+		case *ListenerRouter:
+			routers := map[string]*PathRouter{"": router.main}
+			for name, listener := range router.listeners {
+				routers[name] = listener
+			}
+			return routers
+		}
+		wrapper, ok := lb.(strategyWrapper)
+		if !ok {
+			break
+		}
+		lb = wrapper.Unwrap()
+	}
+	return nil
+}
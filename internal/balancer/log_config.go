@@ -0,0 +1,157 @@
+package balancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// parseLog parses the arguments of a log directive into the log settings.
+// A component name as key sets the level of that component.
+func parseLog(parts []string, config *logger.Config) error {
+	if len(parts) < 2 {
+		return fmt.Errorf("log directive requires an option")
+	}
+
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(strings.TrimSuffix(part, ";"), "=")
+		switch key {
+		case "level":
+			level, err := zapcore.ParseLevel(value)
+			if err != nil {
+				return fmt.Errorf("invalid log level: %s", value)
+			}
+			config.Level = level
+		case "format":
+			if value != "json" && value != "console" {
+				return fmt.Errorf("invalid log format, expected json or console: %s", value)
+			}
+			config.Format = value
+		case "output":
+			if value == "" {
+				return fmt.Errorf("log output requires stderr, stdout or a file")
+			}
+			config.Output = value
+		case "max_size":
+			size, err := parseByteSize(value)
+			if err != nil || size <= 0 {
+				return fmt.Errorf("invalid log max_size: %s", value)
+			}
+			config.MaxSize = size
+		case "max_age":
+			age, err := time.ParseDuration(value)
+			if err != nil || age <= 0 {
+				return fmt.Errorf("invalid log max_age: %s", value)
+			}
+			config.MaxAge = age
+		case "max_backups":
+			backups, err := strconv.Atoi(value)
+			if err != nil || backups < 0 {
+				return fmt.Errorf("invalid log max_backups: %s", value)
+			}
+			config.MaxBackups = backups
+		default:
+			if !isLogComponent(key) {
+				return fmt.Errorf("unknown log option: %s", part)
+			}
+			level, err := zapcore.ParseLevel(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s log level: %s", key, value)
+			}
+			if config.Components == nil {
+				config.Components = make(map[string]zapcore.Level)
+			}
+			config.Components[key] = level
+		}
+	}
+	return nil
+}
+
+func isLogComponent(name string) bool {
+	for _, component := range logger.Components {
+		if name == component {
+			return true
+		}
+	}
+	return false
+}
+
+// LogLevels are the global log level and the levels of the components that
+// have their own
+type LogLevels struct {
+	Level      string            `json:"level"`
+	Components map[string]string `json:"components"`
+}
+
+// GetLogLevels returns the log levels in use
+func GetLogLevels() LogLevels {
+	levels := LogLevels{Level: logger.Level.String(), Components: make(map[string]string)}
+	for component, level := range logger.ComponentLevels() {
+		levels.Components[component] = level.String()
+	}
+	return levels
+}
+
+// LogLevelHandler reads the log levels and, on PUT, changes the global
+// level or the level of a component without a restart. The level is passed
+// as JSON, e.g. {"level":"debug","component":"proxy"}, or as form values. An
+// empty level makes a component follow the global level again.
+func LogLevelHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var request struct {
+				Level     string `json:"level"`
+				Component string `json:"component"`
+			}
+			if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+				request.Level, request.Component = r.FormValue("level"), r.FormValue("component")
+			} else if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if request.Component != "" && request.Level == "" {
+				if err := logger.ClearComponentLevel(request.Component); err != nil {
+					http.Error(w, err.Error(), http.StatusNotFound)
+					return
+				}
+			} else {
+				level, err := zapcore.ParseLevel(request.Level)
+				if err != nil {
+					http.Error(w, "invalid log level: "+request.Level, http.StatusBadRequest)
+					return
+				}
+				if request.Component == "" {
+					logger.Level.SetLevel(level)
+				} else if err := logger.SetComponentLevel(request.Component, level); err != nil {
+					http.Error(w, err.Error(), http.StatusNotFound)
+					return
+				}
+			}
+
+			name := request.Component
+			if name == "" {
+				name = "global"
+			}
+			logger.Admin.Info("Log level changed through the admin API",
+				zap.String("component", name),
+				zap.String("level", request.Level))
+			publishConfigChange("log_level", name, request.Level)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(GetLogLevels())
+	}
+}
@@ -0,0 +1,306 @@
+package balancer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultMetricsBuckets are the upper bounds of the request latency
+// histogram, in seconds, when the metrics directive sets none
+var defaultMetricsBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metricsLabels are the dimensions the request latency histogram can be
+// split by
+var metricsLabels = []string{"route", "backend", "status", "method"}
+
+// MetricsConfig holds the settings of the request latency histogram
+type MetricsConfig struct {
+	// Buckets are the upper bounds of the histogram buckets, in seconds
+	Buckets []float64
+	// Labels are the dimensions requests are split by: route, backend,
+	// status, which is the status class such as 2xx, and method. Every
+	// label multiplies the number of series.
+	Labels []string
+}
+
+var defaultMetrics = MetricsConfig{
+	Buckets: defaultMetricsBuckets,
+	Labels:  []string{"route", "status"},
+}
+
+// parseMetrics parses the arguments of a metrics directive
+func parseMetrics(parts []string) (MetricsConfig, error) {
+	if len(parts) < 2 {
+		return MetricsConfig{}, fmt.Errorf("metrics directive requires buckets or labels")
+	}
+
+	config := defaultMetrics
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(strings.TrimSuffix(part, ";"), "=")
+		switch key {
+		case "buckets":
+			config.Buckets = nil
+			for _, bound := range strings.Split(value, ",") {
+				seconds, err := time.ParseDuration(bound)
+				if err != nil || seconds <= 0 {
+					return MetricsConfig{}, fmt.Errorf("invalid metrics bucket: %s", bound)
+				}
+				upper := seconds.Seconds()
+				if n := len(config.Buckets); n > 0 && upper <= config.Buckets[n-1] {
+					return MetricsConfig{}, fmt.Errorf("metrics buckets must be in increasing order: %s", value)
+				}
+				config.Buckets = append(config.Buckets, upper)
+			}
+		case "labels":
+			config.Labels = nil
+			if value == "none" {
+				continue
+			}
+			for _, label := range strings.Split(value, ",") {
+				if !containsString(metricsLabels, label) {
+					return MetricsConfig{}, fmt.Errorf("unknown metrics label, expected route, backend, status or method: %s", label)
+				}
+				if containsString(config.Labels, label) {
+					return MetricsConfig{}, fmt.Errorf("duplicate metrics label: %s", label)
+				}
+				config.Labels = append(config.Labels, label)
+			}
+		default:
+			return MetricsConfig{}, fmt.Errorf("unknown metrics option: %s", part)
+		}
+	}
+
+	return config, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// standardMethods are reported as they are in the method label; any other
+// method is reported as OTHER, so clients cannot create series at will
+var standardMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// requestLabels returns the values of the configured labels for a request
+// answered with a status
+func requestLabels(labels []string, r *http.Request, info RequestInfo, status int) []string {
+	values := make([]string, len(labels))
+	for i, label := range labels {
+		switch label {
+		case "route":
+			values[i] = info.Route
+		case "backend":
+			if n := len(info.Attempts); n > 0 {
+				values[i] = redactedURL(info.Attempts[n-1].Backend)
+			}
+		case "status":
+			values[i] = strconv.Itoa(status/100) + "xx"
+		case "method":
+			values[i] = r.Method
+			if !standardMethods[r.Method] {
+				values[i] = "OTHER"
+			}
+		}
+	}
+	return values
+}
+
+// redactedURL returns a URL without its password
+func redactedURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
+}
+
+// latencyHistogram counts the requests of one label combination by the
+// first bucket their duration fits in
+type latencyHistogram struct {
+	labels []string
+	counts []int64
+	sum    float64
+	count  int64
+}
+
+// latencyHistograms holds the request latency histogram of every label
+// combination seen
+type latencyHistograms struct {
+	config     MetricsConfig
+	mu         sync.Mutex
+	histograms map[string]*latencyHistogram
+}
+
+func newLatencyHistograms(config MetricsConfig) *latencyHistograms {
+	return &latencyHistograms{
+		config:     config,
+		histograms: make(map[string]*latencyHistogram),
+	}
+}
+
+func (lh *latencyHistograms) observe(labels []string, duration time.Duration) {
+	seconds := duration.Seconds()
+	bucket := sort.SearchFloat64s(lh.config.Buckets, seconds)
+	key := strings.Join(labels, "\x00")
+
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+
+	histogram, ok := lh.histograms[key]
+	if !ok {
+		histogram = &latencyHistogram{labels: labels, counts: make([]int64, len(lh.config.Buckets)+1)}
+		lh.histograms[key] = histogram
+	}
+	histogram.counts[bucket]++
+	histogram.sum += seconds
+	histogram.count++
+}
+
+// writePrometheus writes the histograms in the Prometheus text format
+func (lh *latencyHistograms) writePrometheus(w io.Writer) {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+
+	keys := make([]string, 0, len(lh.histograms))
+	for key := range lh.histograms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintln(w, "# HELP lb_request_duration_seconds Time taken to answer requests.")
+	fmt.Fprintln(w, "# TYPE lb_request_duration_seconds histogram")
+	for _, key := range keys {
+		histogram := lh.histograms[key]
+		labels := make([]string, len(lh.config.Labels))
+		for i, label := range lh.config.Labels {
+			labels[i] = label + `="` + escapeLabelValue(histogram.labels[i]) + `"`
+		}
+
+		var cumulative int64
+		for i, count := range histogram.counts {
+			cumulative += count
+			le := "+Inf"
+			if i < len(lh.config.Buckets) {
+				le = strconv.FormatFloat(lh.config.Buckets[i], 'g', -1, 64)
+			}
+			fmt.Fprintf(w, "lb_request_duration_seconds_bucket{%s} %d\n", strings.Join(append(labels, `le="`+le+`"`), ","), cumulative)
+		}
+		fmt.Fprintf(w, "lb_request_duration_seconds_sum%s %s\n", labelSet(labels), strconv.FormatFloat(histogram.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "lb_request_duration_seconds_count%s %d\n", labelSet(labels), histogram.count)
+	}
+}
+
+func labelSet(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+var (
+	requestLatency   = newLatencyHistograms(defaultMetrics)
+	requestLatencyMu sync.RWMutex
+)
+
+// RequestMetrics records the time taken to answer every request in a
+// latency histogram, split by the configured labels, for the Prometheus
+// metrics endpoint. WebSocket connections are not measured.
+type RequestMetrics struct {
+	next      LoadBalancerStrategy
+	histogram *latencyHistograms
+}
+
+// NewRequestMetrics wraps a strategy with request latency metrics. The
+// histograms start empty.
+func NewRequestMetrics(next LoadBalancerStrategy, config MetricsConfig) LoadBalancerStrategy {
+	histogram := newLatencyHistograms(config)
+	requestLatencyMu.Lock()
+	requestLatency = histogram
+	requestLatencyMu.Unlock()
+
+	return &RequestMetrics{
+		next:      next,
+		histogram: histogram,
+	}
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (m *RequestMetrics) GetNextInstance(r *http.Request) (*url.URL, error) {
+	return m.next.GetNextInstance(r)
+}
+
+// ProxyRequest proxies the request and records the time taken to answer it
+func (m *RequestMetrics) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	if IsWebSocketRequest(r) {
+		m.next.ProxyRequest(w, r)
+		return
+	}
+
+	r = r.WithContext(WithRequestInfo(r.Context()))
+	start := time.Now()
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	m.next.ProxyRequest(recorder, r)
+	duration := time.Since(start)
+
+	info, _ := RequestInfoFromContext(r.Context())
+	labels := requestLabels(m.histogram.config.Labels, r, info, recorder.status)
+	m.histogram.observe(labels, duration)
+	recordStatsdRequest(m.histogram.config.Labels, labels, duration)
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (m *RequestMetrics) SupportsWebSockets() bool {
+	return m.next.SupportsWebSockets()
+}
+
+// Unwrap returns the wrapped strategy
+func (m *RequestMetrics) Unwrap() LoadBalancerStrategy {
+	return m.next
+}
+
+// MetricsHandler serves the request latency histograms and the rejection
+// counts in the Prometheus text format
+func MetricsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		requestLatencyMu.RLock()
+		histogram := requestLatency
+		requestLatencyMu.RUnlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		histogram.writePrometheus(w)
+		writeRejectionsPrometheus(w)
+	}
+}
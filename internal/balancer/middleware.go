@@ -0,0 +1,99 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Middleware wraps the handler serving requests, such as to authenticate or
+// transform them. It calls next to pass a request on to the balancer.
+type Middleware func(next http.Handler) http.Handler
+
+var (
+	middlewares   = make(map[string]Middleware)
+	middlewaresMu sync.RWMutex
+)
+
+// RegisterMiddleware registers a middleware under the given name so it can
+// be listed in the middlewares= route option. Registering an existing name
+// replaces its middleware.
+func RegisterMiddleware(name string, middleware Middleware) {
+	middlewaresMu.Lock()
+	defer middlewaresMu.Unlock()
+
+	middlewares[strings.ToLower(name)] = middleware
+}
+
+func lookupMiddleware(name string) (Middleware, bool) {
+	middlewaresMu.RLock()
+	defer middlewaresMu.RUnlock()
+
+	middleware, ok := middlewares[strings.ToLower(name)]
+	return middleware, ok
+}
+
+// lookupMiddleware returns the middleware named in a middlewares= route
+// option: a plugin of the configuration, or else a registered middleware
+func (c *Config) lookupMiddleware(name string) (Middleware, bool) {
+	if plugin, ok := c.Plugins[name]; ok {
+		return plugin.middleware, true
+	}
+	return lookupMiddleware(name)
+}
+
+// MiddlewareChain passes requests through registered middleware before
+// they reach the wrapped strategy
+type MiddlewareChain struct {
+	next    LoadBalancerStrategy
+	names   []string
+	handler http.Handler
+}
+
+// NewMiddlewareChain wraps a strategy with the middleware registered under
+// the given names, the first being outermost, or returns it unchanged if
+// there are none
+func NewMiddlewareChain(next LoadBalancerStrategy, names []string) (LoadBalancerStrategy, error) {
+	return newMiddlewareChain(next, names, lookupMiddleware)
+}
+
+// newMiddlewareChain wraps a strategy with the middleware lookup returns
+// for the given names
+func newMiddlewareChain(next LoadBalancerStrategy, names []string, lookup func(name string) (Middleware, bool)) (LoadBalancerStrategy, error) {
+	if len(names) == 0 {
+		return next, nil
+	}
+
+	var handler http.Handler = http.HandlerFunc(next.ProxyRequest)
+	for i := len(names) - 1; i >= 0; i-- {
+		middleware, ok := lookup(names[i])
+		if !ok {
+			return nil, fmt.Errorf("unknown middleware: %s", names[i])
+		}
+		handler = middleware(handler)
+	}
+
+	return &MiddlewareChain{next: next, names: names, handler: handler}, nil
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (m *MiddlewareChain) GetNextInstance(r *http.Request) (*url.URL, error) {
+	return m.next.GetNextInstance(r)
+}
+
+// ProxyRequest passes the request through the middleware
+func (m *MiddlewareChain) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	m.handler.ServeHTTP(w, r)
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (m *MiddlewareChain) SupportsWebSockets() bool {
+	return m.next.SupportsWebSockets()
+}
+
+// Unwrap returns the wrapped strategy
+func (m *MiddlewareChain) Unwrap() LoadBalancerStrategy {
+	return m.next
+}
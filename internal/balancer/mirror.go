@@ -0,0 +1,221 @@
+package balancer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxMirrorBodySize bounds the request bodies copied to a shadow pool;
+	// requests with larger bodies are not mirrored
+	maxMirrorBodySize = 1 << 20
+	// maxMirrorsInFlight bounds the mirrored requests awaiting a shadow
+	// response, so a slow shadow pool cannot pile up goroutines
+	maxMirrorsInFlight = 64
+	// mirrorTimeout bounds how long a mirrored request may take
+	mirrorTimeout = 30 * time.Second
+	// MirrorHeader marks the requests sent to a shadow pool
+	MirrorHeader = "X-LB-Mirror"
+)
+
+// MirrorConfig duplicates a share of a pool's requests to a shadow pool
+type MirrorConfig struct {
+	Pool    string
+	Shadow  string
+	Percent float64
+}
+
+// parseMirror parses the arguments of a mirror directive
+func parseMirror(parts []string) (MirrorConfig, error) {
+	if len(parts) < 3 {
+		return MirrorConfig{}, fmt.Errorf("mirror directive requires a pool and a shadow pool")
+	}
+
+	config := MirrorConfig{Pool: parts[1], Shadow: parts[2], Percent: 100}
+	if config.Pool == config.Shadow {
+		return MirrorConfig{}, fmt.Errorf("a pool cannot mirror to itself: %s", config.Pool)
+	}
+
+	for _, part := range parts[3:] {
+		if strings.HasPrefix(part, "percent=") {
+			percentStr := strings.TrimPrefix(part, "percent=")
+			percent, err := strconv.ParseFloat(strings.TrimSuffix(percentStr, "%"), 64)
+			if err != nil || percent <= 0 || percent > 100 {
+				return MirrorConfig{}, fmt.Errorf("invalid mirror percentage: %s", percentStr)
+			}
+			config.Percent = percent
+		}
+	}
+
+	return config, nil
+}
+
+// MirrorStats holds how many requests of a pool were mirrored
+type MirrorStats struct {
+	Mirrored int64 `json:"mirrored"`
+	// Skipped requests had a body too large or found the mirror at capacity
+	Skipped int64 `json:"skipped"`
+	// Failed mirrored requests got a 5xx from the shadow pool
+	Failed int64 `json:"failed"`
+}
+
+var (
+	mirrorStats   = make(map[string]*MirrorStats)
+	mirrorStatsMu sync.Mutex
+)
+
+func countMirror(pool string, count func(*MirrorStats)) {
+	mirrorStatsMu.Lock()
+	defer mirrorStatsMu.Unlock()
+
+	stats, ok := mirrorStats[pool]
+	if !ok {
+		stats = &MirrorStats{}
+		mirrorStats[pool] = stats
+	}
+	count(stats)
+}
+
+// GetMirrorStats returns the mirroring counters of each mirrored pool
+func GetMirrorStats() map[string]MirrorStats {
+	mirrorStatsMu.Lock()
+	defer mirrorStatsMu.Unlock()
+
+	stats := make(map[string]MirrorStats, len(mirrorStats))
+	for pool, s := range mirrorStats {
+		stats[pool] = *s
+	}
+	return stats
+}
+
+// Mirror proxies requests to a pool and sends a copy of a share of them to a
+// shadow pool in the background. Shadow responses are discarded, so a new
+// version of a service can be tried with real traffic without affecting
+// clients. WebSocket upgrades are not mirrored.
+type Mirror struct {
+	next     LoadBalancerStrategy
+	shadow   LoadBalancerStrategy
+	config   MirrorConfig
+	inFlight int32
+}
+
+// NewMirror wraps a pool with a mirror to a shadow pool.
+// The pool is returned unchanged if there is no shadow pool.
+func NewMirror(next, shadow LoadBalancerStrategy, config MirrorConfig) LoadBalancerStrategy {
+	if shadow == nil || config.Percent <= 0 {
+		return next
+	}
+	return &Mirror{
+		next:   next,
+		shadow: shadow,
+		config: config,
+	}
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (m *Mirror) GetNextInstance(r *http.Request) (*url.URL, error) {
+	return m.next.GetNextInstance(r)
+}
+
+// ProxyRequest proxies the request, mirroring it if it is in the share
+func (m *Mirror) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	if !IsWebSocketRequest(r) && rand.Float64()*100 < m.config.Percent {
+		r = m.mirror(r)
+	}
+	m.next.ProxyRequest(w, r)
+}
+
+// mirror sends a copy of the request to the shadow pool and returns the
+// request to proxy, whose body may have been read to copy it
+func (m *Mirror) mirror(r *http.Request) *http.Request {
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(io.LimitReader(r.Body, maxMirrorBodySize+1))
+		if err != nil || len(body) > maxMirrorBodySize {
+			countMirror(m.config.Pool, func(s *MirrorStats) { s.Skipped++ })
+			// The original request keeps the whole body
+			r.Body = &replayBody{Reader: io.MultiReader(bytes.NewReader(body), r.Body), Closer: r.Body}
+			return r
+		}
+		r.Body = &replayBody{Reader: bytes.NewReader(body), Closer: r.Body}
+	}
+
+	if atomic.AddInt32(&m.inFlight, 1) > maxMirrorsInFlight {
+		atomic.AddInt32(&m.inFlight, -1)
+		countMirror(m.config.Pool, func(s *MirrorStats) { s.Skipped++ })
+		return r
+	}
+
+	// The copy gets a context of its own: it outlives the original request
+	// and must not touch its access log and tracing annotations
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorTimeout)
+	shadow := r.Clone(ctx)
+	shadow.Header.Set(MirrorHeader, "true")
+	if body != nil {
+		shadow.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	countMirror(m.config.Pool, func(s *MirrorStats) { s.Mirrored++ })
+
+	go func() {
+		defer cancel()
+		defer atomic.AddInt32(&m.inFlight, -1)
+
+		discard := &discardResponseWriter{header: make(http.Header), status: http.StatusOK}
+		m.shadow.ProxyRequest(discard, shadow)
+		if discard.status >= http.StatusInternalServerError {
+			countMirror(m.config.Pool, func(s *MirrorStats) { s.Failed++ })
+		}
+	}()
+
+	return r
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (m *Mirror) SupportsWebSockets() bool {
+	return m.next.SupportsWebSockets()
+}
+
+// Unwrap returns the mirrored pool
+func (m *Mirror) Unwrap() LoadBalancerStrategy {
+	return m.next
+}
+
+// replayBody is a request body partly or wholly read into memory
+type replayBody struct {
+	io.Reader
+	io.Closer
+}
+
+// discardResponseWriter records the status of a response and drops the rest
+type discardResponseWriter struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardResponseWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = statusCode
+	}
+}
+
+func (w *discardResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return len(b), nil
+}
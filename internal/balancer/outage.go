@@ -0,0 +1,89 @@
+package balancer
+
+import (
+	"sort"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// outageCheckInterval is how often pools are checked for an outage
+const outageCheckInterval = time.Second
+
+// OutageMonitor reports pools that lose all their backends, and when they
+// get one back, as critical events for the system log
+type OutageMonitor struct {
+	lb   LoadBalancerStrategy
+	down map[string]time.Time
+	stop chan struct{}
+}
+
+// NewOutageMonitor creates a monitor of the pools of a strategy
+func NewOutageMonitor(lb LoadBalancerStrategy) *OutageMonitor {
+	return &OutageMonitor{
+		lb:   lb,
+		down: make(map[string]time.Time),
+		stop: make(chan struct{}),
+	}
+}
+
+// Start begins checking pools in the background
+func (o *OutageMonitor) Start() {
+	go func() {
+		ticker := time.NewTicker(outageCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				o.Check()
+			case <-o.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends checking
+func (o *OutageMonitor) Stop() {
+	close(o.stop)
+}
+
+// Check reports the pools whose backends all went down or one came back
+// since the last check, and returns the pools that are down
+func (o *OutageMonitor) Check() []string {
+	now := time.Now()
+	for name, pool := range namedPools(o.lb) {
+		backends, alive := 0, 0
+		for _, p := range strategyProcesses(pool) {
+			backends++
+			if p.IsAlive() {
+				alive++
+			}
+		}
+
+		since, wasDown := o.down[name]
+		switch {
+		case backends > 0 && alive == 0 && !wasDown:
+			o.down[name] = now
+			logger.Event(zapcore.ErrorLevel, "all_backends_down", "All backends of a pool are down",
+				zap.String("pool", name),
+				zap.Int("backends", backends))
+		case alive > 0 && wasDown:
+			delete(o.down, name)
+			logger.Event(zapcore.InfoLevel, "backends_recovered", "A pool has live backends again",
+				zap.String("pool", name),
+				zap.Int("aliveBackends", alive),
+				zap.Duration("downFor", now.Sub(since)))
+		}
+	}
+
+	down := make([]string, 0, len(o.down))
+	for name := range o.down {
+		down = append(down, name)
+	}
+	sort.Strings(down)
+	return down
+}
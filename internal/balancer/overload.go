@@ -0,0 +1,302 @@
+package balancer
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// Route classes, from the first to the last shed under overload
+const (
+	ClassLow      = "low"
+	ClassNormal   = "normal"
+	ClassCritical = "critical"
+)
+
+// validRouteClass reports whether a route class is known
+func validRouteClass(class string) bool {
+	return class == ClassLow || class == ClassNormal || class == ClassCritical
+}
+
+// OverloadConfig holds the thresholds past which the server is overloaded
+// and the share of each route class shed while it is
+type OverloadConfig struct {
+	// MaxInFlight, MaxGoroutines and MaxMemory are the in-flight requests,
+	// goroutines and bytes of heap in use past which the server is
+	// overloaded, if set
+	MaxInFlight   int64
+	MaxGoroutines int
+	MaxMemory     int64
+	// Shed is the fraction of the requests of each class rejected while
+	// the server is overloaded
+	Shed map[string]float64
+	// RetryAfter is sent to the clients of shed requests
+	RetryAfter time.Duration
+	// Interval is how often goroutines and memory are measured
+	Interval time.Duration
+}
+
+// parseOverload parses the arguments of an overload directive
+func parseOverload(parts []string) (OverloadConfig, error) {
+	config := OverloadConfig{
+		Shed:       map[string]float64{ClassLow: 1},
+		RetryAfter: 5 * time.Second,
+		Interval:   time.Second,
+	}
+
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(strings.TrimSuffix(part, ";"), "=")
+		switch key {
+		case "max_inflight":
+			max, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || max <= 0 {
+				return OverloadConfig{}, fmt.Errorf("invalid overload max_inflight: %s", value)
+			}
+			config.MaxInFlight = max
+		case "max_goroutines":
+			max, err := strconv.Atoi(value)
+			if err != nil || max <= 0 {
+				return OverloadConfig{}, fmt.Errorf("invalid overload max_goroutines: %s", value)
+			}
+			config.MaxGoroutines = max
+		case "max_memory":
+			max, err := parseByteSize(value)
+			if err != nil || max <= 0 {
+				return OverloadConfig{}, fmt.Errorf("invalid overload max_memory: %s", value)
+			}
+			config.MaxMemory = max
+		case "shed":
+			shed, err := parseShedFractions(value)
+			if err != nil {
+				return OverloadConfig{}, err
+			}
+			config.Shed = shed
+		case "retry_after", "interval":
+			duration, err := time.ParseDuration(value)
+			if err != nil || duration <= 0 {
+				return OverloadConfig{}, fmt.Errorf("invalid overload %s: %s", key, value)
+			}
+			if key == "retry_after" {
+				config.RetryAfter = duration
+			} else {
+				config.Interval = duration
+			}
+		default:
+			return OverloadConfig{}, fmt.Errorf("unknown overload option: %s", part)
+		}
+	}
+
+	if config.MaxInFlight == 0 && config.MaxGoroutines == 0 && config.MaxMemory == 0 {
+		return OverloadConfig{}, fmt.Errorf("overload directive requires max_inflight, max_goroutines or max_memory")
+	}
+	return config, nil
+}
+
+// parseShedFractions parses the shed option, a list of class:percent
+func parseShedFractions(value string) (map[string]float64, error) {
+	shed := make(map[string]float64)
+	for _, entry := range strings.Split(value, ",") {
+		class, percentStr, _ := strings.Cut(entry, ":")
+		if !validRouteClass(class) || class == ClassCritical {
+			return nil, fmt.Errorf("invalid overload shed class, expected low or normal: %s", class)
+		}
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(percentStr, "%"), 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return nil, fmt.Errorf("invalid overload shed percentage: %s", entry)
+		}
+		shed[class] = percent / 100
+	}
+	return shed, nil
+}
+
+// OverloadStats describes the overload state and the requests shed
+type OverloadStats struct {
+	Active bool `json:"active"`
+	// Reason names the thresholds crossed while active
+	Reason      string `json:"reason,omitempty"`
+	InFlight    int64  `json:"inFlight"`
+	Goroutines  int    `json:"goroutines"`
+	MemoryBytes int64  `json:"memoryBytes"`
+	// Shed counts the requests shed per route class
+	Shed map[string]int64 `json:"shed"`
+}
+
+// activeShedder is the load shedder of the running server, if any
+var activeShedder atomic.Pointer[LoadShedder]
+
+// GetOverloadStats returns the overload state, or nil if load shedding is
+// not configured
+func GetOverloadStats() *OverloadStats {
+	ls := activeShedder.Load()
+	if ls == nil {
+		return nil
+	}
+
+	stats := &OverloadStats{
+		InFlight:    atomic.LoadInt64(&ls.inFlight),
+		Goroutines:  int(atomic.LoadInt64(&ls.goroutines)),
+		MemoryBytes: atomic.LoadInt64(&ls.memory),
+		Shed:        make(map[string]int64),
+	}
+	if ls.active.Load() {
+		stats.Active = true
+		stats.Reason, _ = ls.reason.Load().(string)
+	}
+	ls.shedMu.Lock()
+	for class, count := range ls.shed {
+		stats.Shed[class] = count
+	}
+	ls.shedMu.Unlock()
+	return stats
+}
+
+// LoadShedder protects the server from collapsing under overload: while the
+// in-flight requests, goroutines or heap in use are past their thresholds,
+// it rejects a share of the requests of low priority routes with 503 and a
+// Retry-After, so the rest are still served. Routes are normal unless their
+// class says otherwise; critical routes are never shed.
+type LoadShedder struct {
+	next   LoadBalancerStrategy
+	config OverloadConfig
+	// routers are the path routers of each listener, if routes are
+	// configured
+	routers map[string]*PathRouter
+
+	inFlight int64
+	// goroutines and memory are measured at most once per interval
+	goroutines int64
+	memory     int64
+	measuredAt int64
+	active     atomic.Bool
+	// reason names the thresholds crossed by the last overloaded request
+	reason atomic.Value
+
+	shedMu sync.Mutex
+	shed   map[string]int64
+}
+
+// NewLoadShedder wraps a strategy with load shedding. The strategy is
+// returned unchanged if no overload threshold is set.
+func NewLoadShedder(next LoadBalancerStrategy, config OverloadConfig) LoadBalancerStrategy {
+	if config.MaxInFlight == 0 && config.MaxGoroutines == 0 && config.MaxMemory == 0 {
+		return next
+	}
+
+	ls := &LoadShedder{next: next, config: config, routers: pathRouters(next), shed: make(map[string]int64)}
+	activeShedder.Store(ls)
+	return ls
+}
+
+// measure updates the goroutine count and heap in use once per interval
+func (ls *LoadShedder) measure() {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&ls.measuredAt)
+	if now-last < int64(ls.config.Interval) || !atomic.CompareAndSwapInt64(&ls.measuredAt, last, now) {
+		return
+	}
+
+	atomic.StoreInt64(&ls.goroutines, int64(runtime.NumGoroutine()))
+	if ls.config.MaxMemory > 0 {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		atomic.StoreInt64(&ls.memory, int64(mem.HeapInuse))
+	}
+}
+
+// overloaded returns the thresholds the server is past, if any
+func (ls *LoadShedder) overloaded(inFlight int64) []string {
+	var reasons []string
+	if ls.config.MaxInFlight > 0 && inFlight > ls.config.MaxInFlight {
+		reasons = append(reasons, "in-flight requests")
+	}
+	if ls.config.MaxGoroutines > 0 && atomic.LoadInt64(&ls.goroutines) > int64(ls.config.MaxGoroutines) {
+		reasons = append(reasons, "goroutines")
+	}
+	if ls.config.MaxMemory > 0 && atomic.LoadInt64(&ls.memory) > ls.config.MaxMemory {
+		reasons = append(reasons, "memory")
+	}
+	return reasons
+}
+
+// class returns the class of the route of a request
+func (ls *LoadShedder) class(r *http.Request) string {
+	router, ok := ls.routers[listenerName(r)]
+	if !ok {
+		router = ls.routers[""]
+	}
+	if router != nil {
+		if i := router.matchRoute(r); i >= 0 && router.routes[i].Class != "" {
+			return router.routes[i].Class
+		}
+	}
+	return ClassNormal
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (ls *LoadShedder) GetNextInstance(r *http.Request) (*url.URL, error) {
+	return ls.next.GetNextInstance(r)
+}
+
+// ProxyRequest sheds the request if the server is overloaded and the
+// request's class is picked to be shed, and proxies it otherwise
+func (ls *LoadShedder) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	// WebSockets stay open far longer than requests are in flight
+	var inFlight int64
+	if IsWebSocketRequest(r) {
+		inFlight = atomic.LoadInt64(&ls.inFlight)
+	} else {
+		inFlight = atomic.AddInt64(&ls.inFlight, 1)
+		defer atomic.AddInt64(&ls.inFlight, -1)
+	}
+
+	ls.measure()
+	reasons := ls.overloaded(inFlight)
+	if len(reasons) == 0 {
+		if ls.active.CompareAndSwap(true, false) {
+			logger.Proxy.Info("Overload ended, no longer shedding requests")
+		}
+		ls.next.ProxyRequest(w, r)
+		return
+	}
+	ls.reason.Store(strings.Join(reasons, ", "))
+	if ls.active.CompareAndSwap(false, true) {
+		logger.Proxy.Warn("Server overloaded, shedding low priority requests",
+			zap.Strings("reasons", reasons),
+			zap.Int64("inFlight", inFlight),
+			zap.Int64("goroutines", atomic.LoadInt64(&ls.goroutines)),
+			zap.Int64("memory", atomic.LoadInt64(&ls.memory)))
+	}
+
+	class := ls.class(r)
+	if fraction := ls.config.Shed[class]; fraction <= 0 || rand.Float64() >= fraction {
+		ls.next.ProxyRequest(w, r)
+		return
+	}
+
+	ls.shedMu.Lock()
+	ls.shed[class]++
+	ls.shedMu.Unlock()
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(ls.config.RetryAfter.Seconds()))))
+	rejectRequest(w, RejectOverloaded, "Service overloaded, retry later", http.StatusServiceUnavailable)
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (ls *LoadShedder) SupportsWebSockets() bool {
+	return ls.next.SupportsWebSockets()
+}
+
+// Unwrap returns the wrapped strategy
+func (ls *LoadShedder) Unwrap() LoadBalancerStrategy {
+	return ls.next
+}
@@ -1,7 +1,6 @@
 package balancer
 
 import (
-	"fmt"
 	"net/http"
 	"net/url"
 	"regexp"
@@ -13,20 +12,12 @@ import (
 // PathRouter handles routing requests to different backend pools based on rules
 type PathRouter struct {
 	routes        []RouteConfig
+	routeChains   map[int]LoadBalancerStrategy
 	backendPools  map[string]LoadBalancerStrategy
 	defaultPool   LoadBalancerStrategy
 	defaultPoolID string
 }
 
-// ErrInvalidConfig represents a configuration error
-type ErrInvalidConfig struct {
-	Message string
-}
-
-func (e ErrInvalidConfig) Error() string {
-	return fmt.Sprintf("invalid configuration: %s", e.Message)
-}
-
 // NewPathRouter creates a new path-based router
 func NewPathRouter(
 	routes []RouteConfig,
@@ -36,28 +27,61 @@ func NewPathRouter(
 	// Validate that the default pool exists
 	defaultLB, exists := backendPools[defaultPool]
 	if !exists {
-		return nil, ErrInvalidConfig{Message: "default backend pool not found"}
+		return nil, poolNotFound(0, "default backend pool", defaultPool)
 	}
 
 	// Validate that all route backend pools exist
 	for _, route := range routes {
-		if _, exists := backendPools[route.BackendPool]; !exists {
-			return nil, ErrInvalidConfig{Message: "route references non-existent backend pool: " + route.BackendPool}
+		if _, exists := backendPools[route.BackendPool]; !exists && route.Static.Root == "" {
+			return nil, poolNotFound(route.Line, "route backend pool", route.BackendPool)
+		}
+		if route.Canary.Pool != "" {
+			if _, exists := backendPools[route.Canary.Pool]; !exists {
+				return nil, poolNotFound(route.Line, "route canary pool", route.Canary.Pool)
+			}
+		}
+		if route.Green != "" {
+			if _, exists := backendPools[route.Green]; !exists {
+				return nil, poolNotFound(route.Line, "route green pool", route.Green)
+			}
+		}
+		for _, pool := range route.Split.Pools {
+			if _, exists := backendPools[pool]; !exists {
+				return nil, poolNotFound(route.Line, "route split pool", pool)
+			}
 		}
 	}
 
-	// Precompile regex patterns for regex routes
-	for _, route := range routes {
+	// Precompile regex patterns for regex routes, and the expressions of
+	// expr routes and selectors of geo routes not read from a configuration
+	// file
+	routes = append([]RouteConfig(nil), routes...)
+	for i, route := range routes {
 		if route.Type == RegexRoute {
 			_, err := regexp.Compile(route.Pattern)
 			if err != nil {
-				return nil, ErrInvalidConfig{Message: "invalid regex pattern: " + route.Pattern}
+				return nil, ErrInvalidConfig{Line: route.Line, Message: "invalid regex pattern: " + route.Pattern, Err: err}
+			}
+		}
+		if route.Type == ExprRoute && route.expr == nil {
+			expr, err := compileRouteExpr(route.Pattern)
+			if err != nil {
+				return nil, ErrInvalidConfig{Line: route.Line, Message: "invalid route expression: " + route.Pattern, Err: err}
+			}
+			routes[i].expr = expr
+		}
+		if route.Type == GeoRoute && route.geo == nil {
+			geo, err := parseGeoRoute(route.Pattern)
+			if err != nil {
+				return nil, ErrInvalidConfig{Line: route.Line, Message: "invalid geo route: " + route.Pattern, Err: err}
 			}
+			routes[i].geo = geo
 		}
 	}
 
 	return &PathRouter{
 		routes:        routes,
+		routeChains:   make(map[int]LoadBalancerStrategy),
 		backendPools:  backendPools,
 		defaultPool:   defaultLB,
 		defaultPoolID: defaultPool,
@@ -66,8 +90,23 @@ func NewPathRouter(
 
 // Route determines which backend pool should handle the request
 func (pr *PathRouter) Route(r *http.Request) LoadBalancerStrategy {
+	i := pr.matchRoute(r)
+	if i < 0 {
+		// Default to the default backend pool
+		return pr.defaultPool
+	}
+
+	if chain, ok := pr.routeChains[i]; ok {
+		return chain
+	}
+	return pr.backendPools[pr.routes[i].BackendPool]
+}
+
+// matchRoute returns the index of the first route matching the request, or
+// -1 if none does
+func (pr *PathRouter) matchRoute(r *http.Request) int {
 	// Check each route in order
-	for _, route := range pr.routes {
+	for i, route := range pr.routes {
 		var matched bool
 
 		switch route.Type {
@@ -84,15 +123,63 @@ func (pr *PathRouter) Route(r *http.Request) LoadBalancerStrategy {
 			// Match based on HTTP header
 			headerValue := r.Header.Get(route.HeaderName)
 			matched = headerValue == route.HeaderValue
+
+		case ExprRoute:
+			matched = route.expr.match(r)
+
+		case GeoRoute:
+			matched = route.geo.match(r)
 		}
 
 		if matched {
-			return pr.backendPools[route.BackendPool]
+			return i
+		}
+	}
+
+	return -1
+}
+
+// applyRouteMiddleware wraps the pool of each route with the middleware
+// configured on the route itself
+func (pr *PathRouter) applyRouteMiddleware(config *Config) error {
+	for i, route := range pr.routes {
+		pool := pr.backendPools[route.BackendPool]
+		chain := pool
+		if route.Static.Root != "" {
+			static, err := NewStaticFiles(route)
+			if err != nil {
+				return ErrInvalidConfig{Line: route.Line, Message: err.Error(), Err: err}
+			}
+			chain = static
+		}
+		if len(route.Split.Pools) > 0 {
+			pools := make([]LoadBalancerStrategy, len(route.Split.Pools))
+			for j, name := range route.Split.Pools {
+				pools[j] = pr.backendPools[name]
+			}
+			chain = NewTrafficSplitter(pools, routeName(route), route.Split)
+		}
+		if route.Green != "" {
+			chain = NewBlueGreenSwitch(chain, pr.backendPools[route.Green], routeName(route), route.BackendPool, route.Green)
+		}
+		chain = NewCanarySplitter(chain, pr.backendPools[route.Canary.Pool], routeName(route), route.Canary)
+		chain, err := ApplyRouteMiddleware(chain, config, route)
+		if err != nil {
+			return err
+		}
+		if chain != pool {
+			pr.routeChains[i] = chain
 		}
 	}
+	return nil
+}
 
-	// Default to the default backend pool
-	return pr.defaultPool
+// routeName returns a readable identifier of a route
+func routeName(route RouteConfig) string {
+	if route.Type == HeaderRoute {
+		return route.HeaderName + ": " + route.HeaderValue
+	}
+	return route.Pattern
 }
 
 // GetNextInstance selects the appropriate backend pool and gets the next instance
@@ -103,6 +190,13 @@ func (pr *PathRouter) GetNextInstance(r *http.Request) (*url.URL, error) {
 
 // ProxyRequest routes the request to the appropriate backend pool
 func (pr *PathRouter) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	if i := pr.matchRoute(r); i >= 0 {
+		annotateRoute(r, routeName(pr.routes[i]), pr.routes[i].BackendPool)
+		r = withRouteClass(r, pr.routes[i].Class)
+	} else {
+		annotateRoute(r, "", pr.defaultPoolID)
+	}
+
 	lb := pr.Route(r)
 	lb.ProxyRequest(w, r)
 }
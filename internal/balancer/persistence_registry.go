@@ -0,0 +1,81 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// PersistenceProvider implements a custom session persistence method
+type PersistenceProvider interface {
+	// Select returns the backend the request is bound to, or nil to let the
+	// base algorithm choose
+	Select(r *http.Request, backends []*Process) *Process
+	// Bind is called with the backend chosen for the request so the provider
+	// can record or advertise the binding
+	Bind(w http.ResponseWriter, r *http.Request, backend *Process)
+}
+
+// PersistenceFactory creates a persistence provider from the persistence
+// attributes of the configuration
+type PersistenceFactory func(attrs map[string]string) (PersistenceProvider, error)
+
+type registeredPersistence struct {
+	name    string
+	factory PersistenceFactory
+}
+
+// Custom persistence methods are numbered after the built-in ones
+const firstCustomPersistence PersistenceMethod = 100
+
+var (
+	persistenceRegistry   = make(map[PersistenceMethod]registeredPersistence)
+	persistenceByName     = make(map[string]PersistenceMethod)
+	persistenceRegistryMu sync.RWMutex
+)
+
+// RegisterPersistenceMethod registers a custom persistence method under the
+// given name so it can be selected with "persistence <name>" in the
+// configuration. Registering an existing name replaces its factory.
+func RegisterPersistenceMethod(name string, factory PersistenceFactory) PersistenceMethod {
+	name = strings.ToLower(name)
+
+	persistenceRegistryMu.Lock()
+	defer persistenceRegistryMu.Unlock()
+
+	method, exists := persistenceByName[name]
+	if !exists {
+		method = firstCustomPersistence + PersistenceMethod(len(persistenceByName))
+		persistenceByName[name] = method
+	}
+	persistenceRegistry[method] = registeredPersistence{name: name, factory: factory}
+
+	return method
+}
+
+// LookupPersistenceMethod returns the custom persistence method registered under name
+func LookupPersistenceMethod(name string) (PersistenceMethod, bool) {
+	persistenceRegistryMu.RLock()
+	defer persistenceRegistryMu.RUnlock()
+
+	method, ok := persistenceByName[strings.ToLower(name)]
+	return method, ok
+}
+
+func lookupPersistenceRegistration(method PersistenceMethod) (registeredPersistence, bool) {
+	persistenceRegistryMu.RLock()
+	defer persistenceRegistryMu.RUnlock()
+
+	registration, ok := persistenceRegistry[method]
+	return registration, ok
+}
+
+// newPersistenceProvider creates the provider of a custom persistence method
+func newPersistenceProvider(method PersistenceMethod, attrs map[string]string) (PersistenceProvider, error) {
+	registration, ok := lookupPersistenceRegistration(method)
+	if !ok {
+		return nil, ErrInvalidConfig{Message: fmt.Sprintf("unknown persistence method: %d", method)}
+	}
+	return registration.factory(attrs)
+}
@@ -0,0 +1,80 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http"
+	"plugin"
+	"strings"
+)
+
+// PluginConfig is a middleware loaded from a Go plugin by a plugin directive
+type PluginConfig struct {
+	Name string
+	Path string
+	// Params are passed to the plugin's NewMiddleware function
+	Params map[string]string
+
+	middleware Middleware
+}
+
+// parsePlugin parses the arguments of a plugin directive and loads the
+// plugin: plugin <name> <path> [key=value...]
+func parsePlugin(parts []string) (PluginConfig, error) {
+	if len(parts) < 3 {
+		return PluginConfig{}, fmt.Errorf("plugin directive requires a name and a path")
+	}
+
+	config := PluginConfig{Name: parts[1], Path: parts[2], Params: make(map[string]string)}
+	for _, part := range parts[3:] {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || key == "" {
+			return PluginConfig{}, fmt.Errorf("invalid plugin parameter, expected key=value: %s", part)
+		}
+		config.Params[key] = value
+	}
+
+	middleware, err := loadPlugin(config.Path, config.Params)
+	if err != nil {
+		return PluginConfig{}, fmt.Errorf("plugin %s: %w", config.Name, err)
+	}
+	config.middleware = middleware
+	return config, nil
+}
+
+// loadPlugin opens a Go plugin and returns the middleware it exports, either
+// as a NewMiddleware function given the plugin's parameters or as a
+// Middleware function
+func loadPlugin(path string, params map[string]string) (Middleware, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if symbol, err := p.Lookup("NewMiddleware"); err == nil {
+		var newMiddleware func(map[string]string) (func(http.Handler) http.Handler, error)
+		switch typed := symbol.(type) {
+		case func(map[string]string) (func(http.Handler) http.Handler, error):
+			newMiddleware = typed
+		case *func(map[string]string) (func(http.Handler) http.Handler, error):
+			newMiddleware = *typed
+		default:
+			return nil, fmt.Errorf("NewMiddleware has type %T, expected func(map[string]string) (func(http.Handler) http.Handler, error)", symbol)
+		}
+		return newMiddleware(params)
+	}
+
+	symbol, err := p.Lookup("Middleware")
+	if err != nil {
+		return nil, fmt.Errorf("plugin exports neither NewMiddleware nor Middleware")
+	}
+	if len(params) > 0 {
+		return nil, fmt.Errorf("plugin takes no parameters without a NewMiddleware function")
+	}
+	switch typed := symbol.(type) {
+	case func(http.Handler) http.Handler:
+		return typed, nil
+	case *func(http.Handler) http.Handler:
+		return *typed, nil
+	}
+	return nil, fmt.Errorf("Middleware has type %T, expected func(http.Handler) http.Handler", symbol)
+}
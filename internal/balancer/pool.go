@@ -0,0 +1,390 @@
+package balancer
+
+import (
+	"math"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// Picker is a balancing algorithm: it selects the backend of a pool that
+// serves a request, or returns nil when none is available
+type Picker interface {
+	Pick(r *http.Request) *Process
+}
+
+// Pool is a pool of backends balanced by a Picker. Proxying, retries,
+// connection limits, persistence, stats and health checks are written once
+// against it, whatever the balancing method.
+type Pool interface {
+	Picker
+	// Backends returns the backends of the pool
+	Backends() []*Process
+	// Method names the balancing method
+	Method() string
+	// Persistence returns the session persistence method of the pool
+	Persistence() PersistenceMethod
+	// Settings returns the settings the pool is proxied with
+	Settings() *PoolSettings
+}
+
+// PoolSettings are the settings every pool honors
+type PoolSettings struct {
+	// Queue holds requests while every backend is at its connection limit
+	Queue *RequestQueue
+	// AntiAffinity steers retries away from the zones of failed backends
+	AntiAffinity bool
+	// Revival overrides how the dead backends of the pool are revived
+	Revival *RevivalConfig
+	// WebSocket overrides the limits of the WebSockets proxied to the pool
+	WebSocket *WebSocketConfig
+	// GeoIP locates the clients of a geo-weighted pool
+	GeoIP *GeoIPDatabase
+	// Retry overrides how the requests of the pool are retried and hedged
+	Retry *RetryConfig
+	// MinAlive is how many backends the priority tiers before a backup tier
+	// need alive for the backup tier to stay out of rotation
+	MinAlive int
+	// RewriteRedirects points the redirects of the pool's backends back at
+	// the balancer
+	RewriteRedirects bool
+	// DrainTimeout is how long backends removed from the pool keep the
+	// requests and WebSockets in flight to them, 30 seconds if unset
+	DrainTimeout time.Duration
+
+	supervisor atomic.Pointer[revivalSupervisor]
+	// tiered is set while a backend of the pool is in a backup tier, so
+	// pools without backup tiers skip working out the active one
+	tiered bool
+	// tier is the last active priority tier, to log failovers
+	tier atomic.Int32
+}
+
+// revivals returns the supervisor reviving the dead backends of the pool
+func (s *PoolSettings) revivals() *revivalSupervisor {
+	if supervisor := s.supervisor.Load(); supervisor != nil {
+		return supervisor
+	}
+	s.supervisor.CompareAndSwap(nil, newRevivalSupervisor(s))
+	return s.supervisor.Load()
+}
+
+// forgetRevivals stops reviving backends removed from the pool
+func (s *PoolSettings) forgetRevivals(removed []*Process) {
+	if supervisor := s.supervisor.Load(); supervisor != nil && len(removed) > 0 {
+		supervisor.forget(removed)
+	}
+}
+
+// stopRevivals stops reviving the dead backends of the pool
+func (s *PoolSettings) stopRevivals() {
+	s.revivals().stop()
+}
+
+// sessionBinder is a pool pinning sessions to the backend that serves them
+type sessionBinder interface {
+	bindSession(w http.ResponseWriter, r *http.Request, p *Process)
+}
+
+// PoolStrategy is the LoadBalancerStrategy of a single pool
+type PoolStrategy struct {
+	pool Pool
+}
+
+// LegacyLoadBalancerAdapter is the former name of PoolStrategy.
+//
+// Deprecated: use PoolStrategy.
+type LegacyLoadBalancerAdapter = PoolStrategy
+
+// NewPoolStrategy returns the LoadBalancerStrategy of a pool
+func NewPoolStrategy(pool Pool) LoadBalancerStrategy {
+	return &PoolStrategy{pool: pool}
+}
+
+// NewRoundRobin creates a round robin load balancer. It honors weights like
+// weighted round robin; backends weigh the same unless configured otherwise.
+func NewRoundRobin(backends []BackendConfig) LoadBalancerStrategy {
+	return NewWeightedRoundRobin(backends)
+}
+
+// NewWeightedRoundRobin creates a weighted round robin load balancer
+func NewWeightedRoundRobin(backends []BackendConfig) LoadBalancerStrategy {
+	return &PoolStrategy{pool: NewLoadBalancer(backends)}
+}
+
+// NewLeastConnections creates a least connections load balancer
+func NewLeastConnections(backends []BackendConfig) LoadBalancerStrategy {
+	return &PoolStrategy{pool: NewLeastConnectionsBalancer(backends)}
+}
+
+// NewSessionPersistence pins sessions to the backends of a strategy's pool,
+// picking a backend with the pool's balancing method for new sessions
+func NewSessionPersistence(strategy LoadBalancerStrategy, method PersistenceMethod, attrs map[string]string) (LoadBalancerStrategy, error) {
+	var base Pool = NewLoadBalancer(nil)
+	if ps, ok := strategy.(*PoolStrategy); ok {
+		base = ps.pool
+	}
+
+	lb := newSessionPersistenceBalancer(base, method)
+
+	maxSessions := defaultMaxSessions
+	if value, ok := attrs["max_sessions"]; ok {
+		var err error
+		if maxSessions, err = parseMaxSessions(value); err != nil {
+			return nil, ErrInvalidConfig{Message: err.Error()}
+		}
+	}
+
+	if method == CookiePersistence {
+		cookie, err := parseSessionCookie(attrs)
+		if err != nil {
+			return nil, ErrInvalidConfig{Message: err.Error()}
+		}
+		lb.CookieName, lb.CookieTTL = cookie.Name, cookie.TTL
+		lb.CookieDomain, lb.CookiePath = cookie.Domain, cookie.Path
+		lb.CookieSameSite, lb.CookieSecure = cookie.SameSite, cookie.Secure
+		lb.CookieSessions = newSessionTable(cookie.TTL, maxSessions)
+	}
+
+	if method == IPHashPersistence {
+		ttl := defaultIPSessionTTL
+		if ttlStr, ok := attrs["ip_ttl"]; ok {
+			var err error
+			if ttl, err = time.ParseDuration(ttlStr); err != nil || ttl <= 0 {
+				return nil, ErrInvalidConfig{Message: "invalid ip_hash session ttl: " + ttlStr}
+			}
+		}
+		lb.IPSessions = newSessionTable(ttl, maxSessions)
+	}
+
+	if rebalance, ok := attrs["rebalance"]; ok && (method == CookiePersistence || method == IPHashPersistence) {
+		rate, err := parseSessionRebalance(rebalance)
+		if err != nil {
+			return nil, ErrInvalidConfig{Message: err.Error()}
+		}
+		lb.Rebalance = newSessionRebalance(rate, lb.ProcessPack)
+	}
+
+	if maxHops, ok := attrs["max_hops"]; ok {
+		hops, err := strconv.Atoi(maxHops)
+		if err != nil || hops < 0 {
+			return nil, ErrInvalidConfig{Message: "invalid max_hops: " + maxHops}
+		}
+		lb.MaxHops = hops
+	}
+
+	if boundedLoad, ok := attrs["bounded_load"]; ok {
+		factor, err := strconv.ParseFloat(boundedLoad, 64)
+		if err != nil || !(factor >= 1) || math.IsInf(factor, 0) {
+			return nil, ErrInvalidConfig{Message: "invalid bounded_load, expected a factor of at least 1: " + boundedLoad}
+		}
+		lb.ConsistentHashRing.SetLoadFactor(factor)
+	}
+
+	if method == UploadSessionPersistence {
+		uploads, err := newUploadSessions(attrs)
+		if err != nil {
+			return nil, err
+		}
+		lb.Uploads = uploads
+	}
+
+	if method >= firstCustomPersistence {
+		provider, err := newPersistenceProvider(method, attrs)
+		if err != nil {
+			return nil, err
+		}
+		lb.Provider = provider
+	}
+
+	return &PoolStrategy{pool: lb}, nil
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (ps *PoolStrategy) GetNextInstance(r *http.Request) (*url.URL, error) {
+	if err := r.Context().Err(); err != nil {
+		return nil, err
+	}
+
+	process := ps.pool.Pick(r)
+	if process == nil {
+		return nil, ErrNoHealthyBackend
+	}
+
+	return process.URL, nil
+}
+
+// Pool returns the pool of the strategy
+func (ps *PoolStrategy) Pool() Pool {
+	return ps.pool
+}
+
+// ProxyRequest implements the LoadBalancerStrategy interface
+func (ps *PoolStrategy) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	proxyToPool(ps.pool, w, r)
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (ps *PoolStrategy) SupportsWebSockets() bool {
+	return true
+}
+
+// HasHealthyBackend returns true if any backend of the pool is alive
+func (ps *PoolStrategy) HasHealthyBackend() bool {
+	return anyAlive(ps.pool.Backends())
+}
+
+// proxyToPool proxies a request to a backend picked from a pool. A request
+// whose backend fails is picked a backend again, knowing the backends it
+// already failed on: persistence falls back to another backend, consistent
+// hashing spills over clockwise on the ring and anti-affinity avoids their
+// zones. Requests are retried up to the pool's retry limit, and hedged on
+// other backends when their backend is slow if hedging is on. A request an
+// operator forced to a backend goes to that backend alone, even if it is
+// down, and is neither retried, hedged nor bound to a session.
+func proxyToPool(pool Pool, w http.ResponseWriter, r *http.Request) {
+	settings := pool.Settings()
+	queue := settings.Queue
+	pick := func() *Process {
+		return pool.Pick(r)
+	}
+	forced := routingOverride(r)
+	if forced != "" {
+		p := overrideBackend(pool.Backends(), forced)
+		if p == nil {
+			rejectRequest(w, RejectUnknownBackend, "Unknown backend", http.StatusBadRequest)
+			return
+		}
+		pick = func() *Process {
+			return p
+		}
+	}
+	target, reason := acquireProcess(queue, pool.Backends(), r, pick)
+	if reason == RejectBackendsSaturated && forced == "" {
+		if spb, ok := pool.(*SessionPersistenceBalancer); ok && spb.hopsExhausted(r) {
+			reason = RejectHashHopsExhausted
+		}
+	}
+	if target == nil {
+		message, status := rejectionMessage(reason)
+		rejectRequest(w, reason, message, status)
+		return
+	}
+	release := releaseOnce(queue, target)
+	annotateBackend(r, target.URL)
+
+	// WebSockets are bound too, so reconnecting sockets return to the same
+	// backend
+	if binder, ok := pool.(sessionBinder); ok && forced == "" {
+		binder.bindSession(w, r, target)
+	}
+
+	if IsWebSocketRequest(r) {
+		// The socket holds its slots until it closes, so open WebSockets
+		// count toward the load of their backend
+		limits := defaultWebSocketConfig
+		if settings.WebSocket != nil {
+			limits = *settings.WebSocket
+		}
+		wsProxy := newWebSocketProxy(target, settings.revivals().watch, limits)
+		wsProxy.onClose = func() {
+			target.releaseWebSocket()
+			release()
+		}
+		wsProxy.ProxyWebSocket(w, r)
+		return
+	}
+	defer release()
+
+	proxy := httputil.NewSingleHostReverseProxy(target.URL)
+	retry := settings.retry()
+	if retry.HedgeAfter > 0 && pool.Persistence() == NoPersistence && hedgeable(r) && forced == "" {
+		proxy.Transport = &hedgingTransport{pool: pool, primary: target, inbound: r, config: retry}
+	}
+	failed := false
+	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		failed = true
+		logger.Proxy.Error("Request failed",
+			zap.String("backend", target.URL.String()),
+			zap.Error(err),
+		)
+		if !attemptFailed(w, r, err) {
+			return
+		}
+
+		if target.recordFailure(err) {
+			logger.Proxy.Warn("Backend marked dead", zap.String("backend", target.URL.String()))
+			settings.revivals().watch(target)
+		}
+
+		if retry.exhausted(r) || forced != "" {
+			message, status := rejectionMessage(RejectRetriesExhausted)
+			rejectRequest(w, RejectRetriesExhausted, message, status)
+			return
+		}
+
+		annotateRetry(r)
+		release()
+		proxyToPool(pool, w, withTriedBackend(r, target))
+	}
+
+	if settings.RewriteRedirects {
+		proxy.ModifyResponse = func(resp *http.Response) error {
+			rewriteRedirect(resp, r, pool.Backends())
+			return nil
+		}
+	}
+	serveAndRecord(proxy, w, r, target, &failed)
+}
+
+// pickerPool is a pool balanced by a custom Picker
+type pickerPool struct {
+	PoolSettings
+	method    string
+	processes []*Process
+	picker    Picker
+}
+
+// NewPickerPool creates a load balancer whose backends are picked by a
+// custom balancing algorithm. newPicker is given the backends of the pool;
+// the picker must only pick available ones. Proxying, retries, connection
+// limits, persistence, stats and health checks work as with the built-in
+// methods, and method names the algorithm in stats.
+func NewPickerPool(method string, backends []BackendConfig, newPicker func(backends []*Process) Picker) LoadBalancerStrategy {
+	processes := NewLeastConnectionsBalancer(backends).ProcessPack
+	return &PoolStrategy{pool: &pickerPool{
+		method:    method,
+		processes: processes,
+		picker:    newPicker(processes),
+	}}
+}
+
+func (pp *pickerPool) Pick(r *http.Request) *Process {
+	p := pp.picker.Pick(r)
+	if p == nil || !p.Available() || !canServe(IsWebSocketRequest(r), p) {
+		return nil
+	}
+	return p
+}
+
+func (pp *pickerPool) Backends() []*Process {
+	return pp.processes
+}
+
+func (pp *pickerPool) Method() string {
+	return pp.method
+}
+
+func (pp *pickerPool) Persistence() PersistenceMethod {
+	return NoPersistence
+}
+
+func (pp *pickerPool) Settings() *PoolSettings {
+	return &pp.PoolSettings
+}
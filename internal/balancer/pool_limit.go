@@ -0,0 +1,131 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// PoolLimitConfig holds the ceilings applied to a whole backend pool
+type PoolLimitConfig struct {
+	MaxConcurrent int
+	MaxQPS        float64
+	// Warn is the share of a ceiling in use at which a warning is emitted
+	Warn float64
+	// Name identifies the ceilings in warnings
+	Name string
+}
+
+// parsePoolLimit parses the arguments of a pool_limit directive
+func parsePoolLimit(parts []string) (PoolLimitConfig, error) {
+	limit := PoolLimitConfig{}
+
+	for i := 1; i < len(parts); i++ {
+		if strings.HasPrefix(parts[i], "max_concurrent=") {
+			valueStr := strings.TrimPrefix(parts[i], "max_concurrent=")
+			value, err := strconv.Atoi(valueStr)
+			if err != nil || value <= 0 {
+				return PoolLimitConfig{}, fmt.Errorf("invalid max_concurrent: %s", valueStr)
+			}
+			limit.MaxConcurrent = value
+		} else if strings.HasPrefix(parts[i], "max_qps=") {
+			valueStr := strings.TrimPrefix(parts[i], "max_qps=")
+			value, err := strconv.ParseFloat(valueStr, 64)
+			if err != nil || value <= 0 {
+				return PoolLimitConfig{}, fmt.Errorf("invalid max_qps: %s", valueStr)
+			}
+			limit.MaxQPS = value
+		} else if strings.HasPrefix(parts[i], "warn=") {
+			warn, err := parseWarnThreshold(strings.TrimPrefix(parts[i], "warn="))
+			if err != nil {
+				return PoolLimitConfig{}, err
+			}
+			limit.Warn = warn
+		}
+	}
+
+	if limit.MaxConcurrent == 0 && limit.MaxQPS == 0 {
+		return PoolLimitConfig{}, fmt.Errorf("pool_limit directive requires max_concurrent or max_qps")
+	}
+
+	return limit, nil
+}
+
+// PoolLimiter sheds requests above a pool's concurrency and QPS ceilings,
+// independently of the limits of individual backends
+type PoolLimiter struct {
+	next     LoadBalancerStrategy
+	config   PoolLimitConfig
+	inFlight int32
+	qps      *bucketSet
+	// Each ceiling has its own warning threshold
+	warnConcurrent *softLimit
+	warnQPS        *softLimit
+}
+
+// NewPoolLimiter wraps a strategy with pool-level ceilings.
+// The strategy is returned unchanged if no ceiling is set.
+func NewPoolLimiter(next LoadBalancerStrategy, config PoolLimitConfig) LoadBalancerStrategy {
+	if config.MaxConcurrent <= 0 && config.MaxQPS <= 0 {
+		return next
+	}
+
+	limiter := &PoolLimiter{
+		next:   next,
+		config: config,
+	}
+	if config.MaxConcurrent > 0 {
+		limiter.warnConcurrent = newSoftLimit(config.Name+":concurrency", config.Warn)
+	}
+	if config.MaxQPS > 0 {
+		limiter.qps = newBucketSet(config.MaxQPS, 0)
+		limiter.warnQPS = newSoftLimit(config.Name+":qps", config.Warn)
+	}
+	return limiter
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (pl *PoolLimiter) GetNextInstance(r *http.Request) (*url.URL, error) {
+	return pl.next.GetNextInstance(r)
+}
+
+// ProxyRequest proxies the request if the pool is below its ceilings
+func (pl *PoolLimiter) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	if pl.config.MaxConcurrent > 0 {
+		inFlight := atomic.AddInt32(&pl.inFlight, 1)
+		pl.warnConcurrent.observe(float64(inFlight) / float64(pl.config.MaxConcurrent))
+		if inFlight > int32(pl.config.MaxConcurrent) {
+			atomic.AddInt32(&pl.inFlight, -1)
+			rejectRequest(w, RejectPoolConcurrency, "Backend pool is at its concurrency limit", http.StatusServiceUnavailable)
+			return
+		}
+		defer func() {
+			inFlight := atomic.AddInt32(&pl.inFlight, -1)
+			pl.warnConcurrent.observe(float64(inFlight) / float64(pl.config.MaxConcurrent))
+		}()
+	}
+
+	if pl.qps != nil {
+		allowed, _, usage := pl.qps.take("")
+		pl.warnQPS.observe(usage)
+		if !allowed {
+			rejectRequest(w, RejectPoolQPS, "Backend pool is at its request rate limit", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	pl.next.ProxyRequest(w, r)
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (pl *PoolLimiter) SupportsWebSockets() bool {
+	return pl.next.SupportsWebSockets()
+}
+
+// Unwrap returns the wrapped strategy
+func (pl *PoolLimiter) Unwrap() LoadBalancerStrategy {
+	return pl.next
+}
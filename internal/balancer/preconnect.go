@@ -0,0 +1,251 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// PreconnectConfig holds the settings of backend connection warm-up
+type PreconnectConfig struct {
+	// Connections is the number of idle connections opened to every
+	// backend, or 0 if warm-up is disabled
+	Connections int
+	Path        string
+	Timeout     time.Duration
+}
+
+// parsePreconnect parses the arguments of a preconnect directive
+func parsePreconnect(parts []string) (PreconnectConfig, error) {
+	config := PreconnectConfig{Connections: 2, Path: "/", Timeout: 5 * time.Second}
+
+	for i := 1; i < len(parts); i++ {
+		key, value, _ := strings.Cut(strings.TrimSuffix(parts[i], ";"), "=")
+		switch key {
+		case "connections":
+			connections, err := strconv.Atoi(value)
+			if err != nil || connections < 1 {
+				return PreconnectConfig{}, fmt.Errorf("invalid preconnect connections: %s", value)
+			}
+			config.Connections = connections
+		case "path":
+			config.Path = value
+		case "timeout":
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return PreconnectConfig{}, fmt.Errorf("invalid preconnect timeout: %s", value)
+			}
+			config.Timeout = timeout
+		default:
+			return PreconnectConfig{}, fmt.Errorf("unknown preconnect option: %s", parts[i])
+		}
+	}
+
+	return config, nil
+}
+
+// Preconnector warms up the connections to the backends on startup and
+// whenever a backend comes back: it resolves the backend's name and opens
+// idle keep-alive connections in the shared connection pool, so the first
+// requests do not pay for DNS, TCP and TLS setup
+type Preconnector struct {
+	lb     LoadBalancerStrategy
+	config PreconnectConfig
+	stop   func()
+	wg     sync.WaitGroup
+}
+
+// NewPreconnector creates a preconnector, or returns nil if warm-up is
+// disabled. The connection pools keep at least the warmed connections idle.
+func NewPreconnector(lb LoadBalancerStrategy, config PreconnectConfig) *Preconnector {
+	if config.Connections <= 0 {
+		return nil
+	}
+	if backendTransport.MaxIdleConnsPerHost < config.Connections {
+		backendTransport.MaxIdleConnsPerHost = config.Connections
+	}
+	return &Preconnector{lb: lb, config: config}
+}
+
+// Start warms up every backend, then the backends that come back, in the
+// background
+func (pc *Preconnector) Start() {
+	if pc == nil {
+		return
+	}
+
+	events, stop := SubscribeBackendEvents()
+	pc.stop = stop
+
+	for _, p := range strategyProcesses(pc.lb) {
+		if p.IsAlive() {
+			pc.warm(p)
+		}
+	}
+
+	go func() {
+		for event := range events {
+			if event.State != "up" && event.State != "registered" {
+				continue
+			}
+			for _, p := range strategyProcesses(pc.lb) {
+				if p.URL.Redacted() == event.Backend {
+					pc.warm(p)
+				}
+			}
+		}
+	}()
+}
+
+// Stop ends warming up revived backends and waits for warm-ups in progress
+func (pc *Preconnector) Stop() {
+	if pc == nil {
+		return
+	}
+	pc.stop()
+	pc.wg.Wait()
+}
+
+// warm warms up a backend in the background
+func (pc *Preconnector) warm(p *Process) {
+	pc.wg.Add(1)
+	go func() {
+		defer pc.wg.Done()
+		pc.Warm(p)
+	}()
+}
+
+// Warm resolves the name of a backend and opens its idle connections, and
+// returns how many connections were opened
+func (pc *Preconnector) Warm(p *Process) int {
+	ctx, cancel := context.WithTimeout(context.Background(), pc.config.Timeout)
+	defer cancel()
+
+	if host := p.URL.Hostname(); net.ParseIP(host) == nil {
+		if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+			atomic.AddInt64(&preconnectFailures, 1)
+			logger.Health.Warn("Failed to resolve backend for preconnect",
+				zap.String("backend", p.URL.Redacted()),
+				zap.Error(err))
+			return 0
+		}
+	}
+
+	// Requests sent at once each need a connection of their own, which
+	// stays idle in the pool once they are answered
+	var opened int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < pc.config.Connections; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			trace := &httptrace.ClientTrace{
+				GotConn: func(info httptrace.GotConnInfo) {
+					if !info.Reused {
+						atomic.AddInt32(&opened, 1)
+					}
+				},
+			}
+			resp, err := probeBackendContext(httptrace.WithClientTrace(ctx, trace), p, pc.config.Path, pc.config.Timeout)
+			if err != nil {
+				atomic.AddInt64(&preconnectFailures, 1)
+				logger.Health.Warn("Preconnect to backend failed",
+					zap.String("backend", p.URL.Redacted()),
+					zap.Error(err))
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	atomic.AddInt64(&preconnectConnections, int64(opened))
+	logger.Health.Debug("Backend connections warmed up",
+		zap.String("backend", p.URL.Redacted()),
+		zap.Int32("connections", opened))
+	return int(opened)
+}
+
+var (
+	preconnectConnections int64
+	preconnectFailures    int64
+	connectionPools       sync.Map
+)
+
+// connectionPoolCounters counts the requests to a backend by whether they
+// got an idle connection from the pool
+type connectionPoolCounters struct {
+	reused int64
+	opened int64
+}
+
+// traceConnectionPool returns the request with a client trace counting
+// whether its attempts on a backend reuse a pooled connection
+func traceConnectionPool(r *http.Request, p *Process) *http.Request {
+	value, _ := connectionPools.LoadOrStore(p.URL.Redacted(), &connectionPoolCounters{})
+	counters := value.(*connectionPoolCounters)
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				atomic.AddInt64(&counters.reused, 1)
+			} else {
+				atomic.AddInt64(&counters.opened, 1)
+			}
+		},
+	}
+	return r.WithContext(httptrace.WithClientTrace(r.Context(), trace))
+}
+
+// ConnectionPoolStats tells how often requests to a backend found an idle
+// connection in the pool
+type ConnectionPoolStats struct {
+	Reused  int64   `json:"reused"`
+	Opened  int64   `json:"opened"`
+	HitRate float64 `json:"hitRate"`
+}
+
+// BackendConnectionStats holds the connection pool hit rate of every
+// backend and the connections opened ahead of requests
+type BackendConnectionStats struct {
+	Preconnected       int64 `json:"preconnected"`
+	PreconnectFailures int64 `json:"preconnectFailures"`
+	// Pools is keyed by backend URL, without credentials
+	Pools map[string]ConnectionPoolStats `json:"pools"`
+}
+
+// GetBackendConnectionStats returns the connection pool statistics
+func GetBackendConnectionStats() BackendConnectionStats {
+	stats := BackendConnectionStats{
+		Preconnected:       atomic.LoadInt64(&preconnectConnections),
+		PreconnectFailures: atomic.LoadInt64(&preconnectFailures),
+		Pools:              make(map[string]ConnectionPoolStats),
+	}
+	connectionPools.Range(func(backend, value interface{}) bool {
+		counters := value.(*connectionPoolCounters)
+		pool := ConnectionPoolStats{
+			Reused: atomic.LoadInt64(&counters.reused),
+			Opened: atomic.LoadInt64(&counters.opened),
+		}
+		if total := pool.Reused + pool.Opened; total > 0 {
+			pool.HitRate = float64(pool.Reused) / float64(total)
+		}
+		stats.Pools[backend.(string)] = pool
+		return true
+	})
+	return stats
+}
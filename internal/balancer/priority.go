@@ -0,0 +1,86 @@
+package balancer
+
+import (
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// activeTier returns the lowest priority whose tier, with the tiers before
+// it, has at least minAlive alive backends, or the highest priority of the
+// pool if none has. Backends that drain are not counted.
+func activeTier(backends []*Process, minAlive int) int {
+	if minAlive <= 0 {
+		minAlive = 1
+	}
+
+	// lowest holds the lowest priorities of the alive backends in order, up
+	// to minAlive of them; the last is the tier reaching minAlive
+	var buf [8]int
+	lowest := buf[:0]
+	if minAlive > len(buf) {
+		lowest = make([]int, 0, minAlive)
+	}
+	highest := 0
+	for _, p := range backends {
+		if p.Priority > highest {
+			highest = p.Priority
+		}
+		if !p.IsAlive() || p.IsDraining() {
+			continue
+		}
+		if len(lowest) == minAlive && p.Priority >= lowest[minAlive-1] {
+			continue
+		}
+		if len(lowest) < minAlive {
+			lowest = append(lowest, 0)
+		}
+		i := len(lowest) - 1
+		for ; i > 0 && lowest[i-1] > p.Priority; i-- {
+			lowest[i] = lowest[i-1]
+		}
+		lowest[i] = p.Priority
+	}
+
+	if len(lowest) < minAlive {
+		return highest
+	}
+	return lowest[minAlive-1]
+}
+
+// hasPriorities reports whether any backend is in a backup tier
+func hasPriorities(backends []*Process) bool {
+	for _, p := range backends {
+		if p.Priority > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// priorityTier returns the highest priority a pick may use: the active
+// tier, or 0 in a pool without backup tiers. Backup tiers join as the tiers
+// before them lose backends, and leave again as they recover. The caller
+// holds the pool's lock.
+func (s *PoolSettings) priorityTier(backends []*Process) int {
+	if !s.tiered {
+		return 0
+	}
+
+	tier := activeTier(backends, s.MinAlive)
+	if previous := s.tier.Swap(int32(tier)); int(previous) != tier {
+		if tier > int(previous) {
+			logger.Log.Warn("Pool failed over to a backup tier", zap.Int("priority", tier), zap.Int("min_alive", s.MinAlive))
+		} else {
+			logger.Log.Info("Pool failed back", zap.Int("priority", tier))
+		}
+	}
+	return tier
+}
+
+// setMinAlive sets how many backends the tiers of the pool behind a strategy
+// need alive before backup tiers join them
+func setMinAlive(strategy LoadBalancerStrategy, minAlive int) {
+	if ps, ok := strategy.(*PoolStrategy); ok && minAlive > 0 {
+		ps.pool.Settings().MinAlive = minAlive
+	}
+}
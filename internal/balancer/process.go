@@ -1,18 +1,133 @@
 package balancer
 
 import (
+	"context"
+	"net/http"
 	"net/url"
+	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
 type Process struct {
+	// 64-bit counters come first so they stay aligned for atomic access on
+	// 32-bit platforms
+	RequestCount  int64
+	statusClasses [5]int64
+	// downSince is when the backend was marked dead without serving a request
+	// since, in Unix nanoseconds, or zero
+	downSince int64
+	// failures counts the requests the backend failed since startup
+	failures int64
+
 	URL               *url.URL
 	Alive             bool
 	ErrorCount        int32
 	Weight            int
 	Current           int
 	ActiveConnections int32
+	MaxConns          int32
+	// MaxWebSockets limits the WebSockets open to the backend at once, and
+	// webSockets counts them
+	MaxWebSockets int32
+	webSockets    int32
+	// Host and ServerName override the Host header and TLS server name sent
+	// to the backend
+	Host       string
+	ServerName string
+	// Prefix is put in front of the path of the requests sent to the backend
+	Prefix string
+	// Zone is the failure domain of the backend, if any
+	Zone string
+	// Region lists the country and continent codes of the clients the
+	// backend is close to, comma separated
+	Region string
+	// Priority is the tier of the backend: backends above 0 are backups that
+	// only take traffic when the tiers before them are short of backends
+	Priority int
+	// transport replaces the shared connection pool, e.g. for legacy backends
+	transport http.RoundTripper
+	draining  int32
+	// deregistered backends were removed or dead for too long and are never
+	// revived
+	deregistered int32
+	// retired is canceled when the drain timeout of a removed backend
+	// passes, cutting off the requests still in flight to it
+	retireOnce sync.Once
+	retired    context.Context
+	retire     context.CancelFunc
+	// reviveAttempts counts the revivals since the backend last served a
+	// request
+	reviveAttempts int32
+	// override is the BackendOverride set by an operator
+	override    int32
+	lastFailure atomic.Pointer[BackendFailure]
+	latency     latencyWindow
+	// period counts the requests since the last stats snapshot
+	period periodStats
+}
+
+// BackendFailure is a failure of a backend to serve a request
+type BackendFailure struct {
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+}
+
+// BackendOverride is a manual override of the health of a backend, which
+// health checks and drain signals do not undo
+type BackendOverride int32
+
+const (
+	// NoOverride leaves the backend to health checks
+	NoOverride BackendOverride = iota
+	// DrainOverride keeps the backend out of rotation
+	DrainOverride
+	// EnableOverride keeps the backend in rotation even when it fails
+	EnableOverride
+)
+
+func (o BackendOverride) String() string {
+	switch o {
+	case DrainOverride:
+		return "drain"
+	case EnableOverride:
+		return "enable"
+	default:
+		return "none"
+	}
+}
+
+// latencyWindow keeps the most recent response times of a backend
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples [128]time.Duration
+	next    int
+	count   int
+}
+
+func (lw *latencyWindow) add(d time.Duration) {
+	lw.mu.Lock()
+	lw.samples[lw.next] = d
+	lw.next = (lw.next + 1) % len(lw.samples)
+	if lw.count < len(lw.samples) {
+		lw.count++
+	}
+	lw.mu.Unlock()
+}
+
+func (lw *latencyWindow) average() time.Duration {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	if lw.count == 0 {
+		return 0
+	}
+	var total time.Duration
+	for i := 0; i < lw.count; i++ {
+		total += lw.samples[i]
+	}
+	return total / time.Duration(lw.count)
 }
 
 func (p *Process) IsAlive() bool {
@@ -22,9 +137,107 @@ func (p *Process) IsAlive() bool {
 func (p *Process) SetAlive(alive bool) {
 	var val uint32
 	if alive {
+		if p.IsDeregistered() {
+			return
+		}
 		val = 1
 	}
-	atomic.StoreUint32((*uint32)(unsafe.Pointer(&p.Alive)), val)
+	if atomic.SwapUint32((*uint32)(unsafe.Pointer(&p.Alive)), val) != val {
+		if alive {
+			recordHealthEvent(p, "up")
+		} else {
+			atomic.CompareAndSwapInt64(&p.downSince, 0, time.Now().UnixNano())
+			recordHealthEvent(p, "down")
+		}
+	}
+}
+
+// DeadFor returns how long the backend has been failing: the time since it
+// was marked dead without serving a request in between. Being revived to be
+// tried again does not count as serving.
+func (p *Process) DeadFor() time.Duration {
+	since := atomic.LoadInt64(&p.downSince)
+	if since == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, since))
+}
+
+// markServed records that the backend answered a request
+func (p *Process) markServed() {
+	if atomic.LoadInt64(&p.downSince) != 0 {
+		atomic.StoreInt64(&p.downSince, 0)
+	}
+	if atomic.LoadInt32(&p.reviveAttempts) != 0 {
+		atomic.StoreInt32(&p.reviveAttempts, 0)
+	}
+}
+
+// recordFailure records that a request to the backend failed, and marks the
+// backend dead after three failures in a row unless an operator enabled it.
+// It reports whether the backend was marked dead.
+func (p *Process) recordFailure(err error) bool {
+	atomic.AddInt64(&p.failures, 1)
+	p.lastFailure.Store(&BackendFailure{Time: time.Now(), Reason: err.Error()})
+
+	if atomic.AddInt32(&p.ErrorCount, 1) < 3 || p.GetOverride() == EnableOverride || !p.IsAlive() {
+		return false
+	}
+	p.SetAlive(false)
+	return true
+}
+
+// GetFailures returns the number of requests the backend failed since startup
+func (p *Process) GetFailures() int64 {
+	return atomic.LoadInt64(&p.failures)
+}
+
+// LastFailure returns the most recent failure of the backend, or nil
+func (p *Process) LastFailure() *BackendFailure {
+	return p.lastFailure.Load()
+}
+
+// GetOverride returns the manual override of the backend's health
+func (p *Process) GetOverride() BackendOverride {
+	return BackendOverride(atomic.LoadInt32(&p.override))
+}
+
+// SetOverride overrides the health of the backend. DrainOverride drains it,
+// EnableOverride puts it back in rotation even if it was dead, and
+// NoOverride hands it back to health checks as it is, except that a backend
+// drained by an operator is undrained.
+func (p *Process) SetOverride(override BackendOverride) {
+	previous := BackendOverride(atomic.SwapInt32(&p.override, int32(override)))
+
+	switch override {
+	case DrainOverride:
+		p.SetDraining(true)
+	case EnableOverride:
+		p.SetDraining(false)
+		atomic.StoreInt32(&p.ErrorCount, 0)
+		atomic.StoreInt64(&p.downSince, 0)
+		p.SetAlive(true)
+	case NoOverride:
+		if previous == DrainOverride {
+			p.SetDraining(false)
+		}
+	}
+}
+
+// IsDeregistered returns true if the backend was removed from its pool or
+// for being dead too long
+func (p *Process) IsDeregistered() bool {
+	return atomic.LoadInt32(&p.deregistered) != 0
+}
+
+// deregister marks the backend dead for good and reports whether it changed
+func (p *Process) deregister() bool {
+	if !atomic.CompareAndSwapInt32(&p.deregistered, 0, 1) {
+		return false
+	}
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(&p.Alive)), 0)
+	recordHealthEvent(p, "deregistered")
+	return true
 }
 
 func (p *Process) ResetCurrentWeight() {
@@ -42,3 +255,112 @@ func (p *Process) DecrementConnections() {
 func (p *Process) GetActiveConnections() int32 {
 	return atomic.LoadInt32(&p.ActiveConnections)
 }
+
+// HasCapacity returns true if the process is below its connection limit
+func (p *Process) HasCapacity() bool {
+	maxConns := atomic.LoadInt32(&p.MaxConns)
+	return maxConns <= 0 || p.GetActiveConnections() < maxConns
+}
+
+// HasWebSocketCapacity returns true if the process is below its WebSocket
+// limit
+func (p *Process) HasWebSocketCapacity() bool {
+	maxWebSockets := atomic.LoadInt32(&p.MaxWebSockets)
+	return maxWebSockets <= 0 || atomic.LoadInt32(&p.webSockets) < maxWebSockets
+}
+
+// tryAcquireWebSocket reserves a WebSocket slot, failing if the process is
+// at its WebSocket limit
+func (p *Process) tryAcquireWebSocket() bool {
+	for {
+		current := atomic.LoadInt32(&p.webSockets)
+		if maxWebSockets := atomic.LoadInt32(&p.MaxWebSockets); maxWebSockets > 0 && current >= maxWebSockets {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&p.webSockets, current, current+1) {
+			return true
+		}
+	}
+}
+
+// releaseWebSocket frees the WebSocket slot reserved by tryAcquireWebSocket
+func (p *Process) releaseWebSocket() {
+	atomic.AddInt32(&p.webSockets, -1)
+}
+
+// IsDraining returns true if the backend asked not to receive new requests
+func (p *Process) IsDraining() bool {
+	return atomic.LoadInt32(&p.draining) != 0
+}
+
+// SetDraining changes the drain state and reports whether it changed
+func (p *Process) SetDraining(draining bool) bool {
+	var val int32
+	if draining {
+		val = 1
+	}
+	if atomic.SwapInt32(&p.draining, val) == val {
+		return false
+	}
+	if draining {
+		recordHealthEvent(p, "draining")
+	} else {
+		recordHealthEvent(p, "ready")
+	}
+	return true
+}
+
+// Available returns true if the process can take a new request: it is alive,
+// not draining and below its connection limit
+func (p *Process) Available() bool {
+	return p.IsAlive() && !p.IsDraining() && p.HasCapacity()
+}
+
+// TryAcquire reserves a connection slot, failing if the process is at its
+// connection limit
+func (p *Process) TryAcquire() bool {
+	maxConns := atomic.LoadInt32(&p.MaxConns)
+	if maxConns <= 0 {
+		p.IncrementConnections()
+		return true
+	}
+
+	for {
+		current := atomic.LoadInt32(&p.ActiveConnections)
+		if current >= maxConns {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&p.ActiveConnections, current, current+1) {
+			return true
+		}
+	}
+}
+
+// RecordRequest records the outcome of a request proxied to the process
+func (p *Process) RecordRequest(status int, duration time.Duration) {
+	atomic.AddInt64(&p.RequestCount, 1)
+	if class := status/100 - 1; class >= 0 && class < len(p.statusClasses) {
+		atomic.AddInt64(&p.statusClasses[class], 1)
+	}
+	p.latency.add(duration)
+	p.period.add(status, duration)
+}
+
+// GetRequestCount returns the number of requests proxied to the process
+func (p *Process) GetRequestCount() int64 {
+	return atomic.LoadInt64(&p.RequestCount)
+}
+
+// GetStatusCounts returns the number of responses per status code class
+func (p *Process) GetStatusCounts() map[string]int64 {
+	counts := make(map[string]int64, len(p.statusClasses))
+	for i := range p.statusClasses {
+		counts[string(rune('1'+i))+"xx"] = atomic.LoadInt64(&p.statusClasses[i])
+	}
+	return counts
+}
+
+// GetAverageLatency returns the average response time over recent requests
+func (p *Process) GetAverageLatency() time.Duration {
+	return p.latency.average()
+}
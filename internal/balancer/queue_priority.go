@@ -0,0 +1,151 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// routeClasses lists the route classes from the highest priority down
+var routeClasses = []string{ClassCritical, ClassNormal, ClassLow}
+
+// defaultClassWeights are the shares of freed connection slots handed to the
+// queued requests of each class, when every class has requests waiting
+var defaultClassWeights = map[string]int{ClassCritical: 8, ClassNormal: 4, ClassLow: 1}
+
+type routeClassContextKey struct{}
+
+// withRouteClass returns the request tagged with the class of its route
+func withRouteClass(r *http.Request, class string) *http.Request {
+	if class == "" {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), routeClassContextKey{}, class))
+}
+
+// requestClass returns the class of the route of a request, normal if the
+// route has none
+func requestClass(r *http.Request) string {
+	if class, ok := r.Context().Value(routeClassContextKey{}).(string); ok {
+		return class
+	}
+	return ClassNormal
+}
+
+// parseClassWeights parses the priority option of a queue, a list of
+// class:weight
+func parseClassWeights(value string) (map[string]int, error) {
+	weights := make(map[string]int, len(defaultClassWeights))
+	for class, weight := range defaultClassWeights {
+		weights[class] = weight
+	}
+	for _, entry := range strings.Split(value, ",") {
+		class, weightStr, _ := strings.Cut(entry, ":")
+		if !validRouteClass(class) {
+			return nil, fmt.Errorf("invalid queue priority class, expected low, normal or critical: %s", class)
+		}
+		weight, err := strconv.Atoi(weightStr)
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("invalid queue priority weight: %s", entry)
+		}
+		weights[class] = weight
+	}
+	return weights, nil
+}
+
+// queueWaiter is a request waiting in a queue for a connection slot
+type queueWaiter struct {
+	class string
+	ready chan struct{}
+	// woken is set while the waiter holds a wake-up it has not used yet
+	woken bool
+}
+
+// enqueue adds a request of a class at the back of its class's line
+func (q *RequestQueue) enqueue(class string) *queueWaiter {
+	w := &queueWaiter{class: class, ready: make(chan struct{}, 1)}
+	q.mu.Lock()
+	q.lines[class] = append(q.lines[class], w)
+	q.mu.Unlock()
+	return w
+}
+
+// leave removes a request from the queue
+func (q *RequestQueue) leave(w *queueWaiter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	line := q.lines[w.class]
+	for i, queued := range line {
+		if queued == w {
+			q.lines[w.class] = append(line[:i], line[i+1:]...)
+			break
+		}
+	}
+	// A wake-up the request did not use goes to the next one
+	if w.woken {
+		q.wakeLocked()
+	}
+}
+
+// rearm marks a woken request as waiting again
+func (q *RequestQueue) rearm(w *queueWaiter) {
+	q.mu.Lock()
+	w.woken = false
+	q.mu.Unlock()
+}
+
+// ahead reports whether requests of a higher class than the given one are
+// waiting, to which free slots go first
+func (q *RequestQueue) ahead(class string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, c := range routeClasses {
+		if c == class {
+			return false
+		}
+		if len(q.lines[c]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// wakeLocked hands a freed slot to the first request of a class picked by
+// smooth weighted round robin among the classes with requests waiting
+func (q *RequestQueue) wakeLocked() {
+	total := 0
+	var best string
+	for _, class := range routeClasses {
+		if firstWaiting(q.lines[class]) == nil {
+			continue
+		}
+		weight := q.config.Priority[class]
+		q.credits[class] += weight
+		total += weight
+		if best == "" || q.credits[class] > q.credits[best] {
+			best = class
+		}
+	}
+	if best == "" {
+		return
+	}
+
+	q.credits[best] -= total
+	w := firstWaiting(q.lines[best])
+	w.woken = true
+	w.ready <- struct{}{}
+}
+
+// firstWaiting returns the first request of a line not already woken
+func firstWaiting(line []*queueWaiter) *queueWaiter {
+	for _, w := range line {
+		if !w.woken {
+			return w
+		}
+	}
+	return nil
+}
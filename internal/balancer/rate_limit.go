@@ -0,0 +1,305 @@
+package balancer
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig holds the settings of a request rate limit
+type RateLimitConfig struct {
+	Rate  float64
+	Burst int
+	Key   string
+	// Policy is the name of the limit policy the settings come from, if any
+	Policy string
+	// Warn is the share of the burst in use at which a warning is emitted
+	Warn float64
+	// Name identifies the limit in warnings
+	Name string
+}
+
+// RateLimitPolicyStats counts the decisions made under a named limit policy
+type RateLimitPolicyStats struct {
+	Allowed int64 `json:"allowed"`
+	Limited int64 `json:"limited"`
+}
+
+var (
+	policyStats   = make(map[string]*RateLimitPolicyStats)
+	policyStatsMu sync.Mutex
+)
+
+func countPolicyDecision(policy string, allowed bool) {
+	policyStatsMu.Lock()
+	defer policyStatsMu.Unlock()
+
+	stats, ok := policyStats[policy]
+	if !ok {
+		stats = &RateLimitPolicyStats{}
+		policyStats[policy] = stats
+	}
+	if allowed {
+		stats.Allowed++
+	} else {
+		stats.Limited++
+	}
+}
+
+// GetRateLimitPolicyStats returns the decisions made under each named limit policy
+func GetRateLimitPolicyStats() map[string]RateLimitPolicyStats {
+	policyStatsMu.Lock()
+	defer policyStatsMu.Unlock()
+
+	stats := make(map[string]RateLimitPolicyStats, len(policyStats))
+	for policy, counts := range policyStats {
+		stats[policy] = *counts
+	}
+	return stats
+}
+
+// tokenBucket is a simple token bucket refilled at a fixed rate
+type tokenBucket struct {
+	tokens   float64
+	lastFill time.Time
+}
+
+// take refills the bucket and consumes a token if one is available.
+// When no token is available it returns the time until the next one.
+// It also returns the share of the burst in use.
+func (b *tokenBucket) take(now time.Time, rate float64, burst int) (bool, time.Duration, float64) {
+	if b.lastFill.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens += now.Sub(b.lastFill).Seconds() * rate
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+	}
+	b.lastFill = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, 1 - b.tokens/float64(burst)
+	}
+
+	wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	return false, wait, 1
+}
+
+// full reports whether the bucket would be completely refilled at the given time
+func (b *tokenBucket) full(now time.Time, rate float64, burst int) bool {
+	return b.tokens+now.Sub(b.lastFill).Seconds()*rate >= float64(burst)
+}
+
+// bucketSet keeps one token bucket per key and forgets idle keys
+type bucketSet struct {
+	rate      float64
+	burst     int
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newBucketSet(rate float64, burst int) *bucketSet {
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return &bucketSet{
+		rate:      rate,
+		burst:     burst,
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// take consumes a token from the bucket of the given key
+func (s *bucketSet) take(key string) (bool, time.Duration, float64) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Forget keys whose buckets have fully refilled
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, bucket := range s.buckets {
+			if bucket.full(now, s.rate, s.burst) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{}
+		s.buckets[key] = bucket
+	}
+
+	return bucket.take(now, s.rate, s.burst)
+}
+
+// parseRate parses a rate such as "100r/s", "20/s" or "600r/m" into events per second
+func parseRate(value string) (float64, error) {
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid rate: %s", value)
+	}
+
+	count, err := strconv.ParseFloat(strings.TrimSuffix(parts[0], "r"), 64)
+	if err != nil || count <= 0 {
+		return 0, fmt.Errorf("invalid rate: %s", value)
+	}
+
+	switch parts[1] {
+	case "s":
+		return count, nil
+	case "m":
+		return count / 60, nil
+	case "h":
+		return count / 3600, nil
+	default:
+		return 0, fmt.Errorf("invalid rate unit: %s", value)
+	}
+}
+
+// parseRateLimit parses the arguments of a rate_limit directive
+func parseRateLimit(parts []string) (RateLimitConfig, error) {
+	if len(parts) < 2 {
+		return RateLimitConfig{}, fmt.Errorf("rate_limit directive requires a rate")
+	}
+
+	rate, err := parseRate(parts[1])
+	if err != nil {
+		// A lone name refers to a limit policy, resolved once all are parsed
+		if len(parts) == 2 && !strings.Contains(parts[1], "/") {
+			return RateLimitConfig{Policy: parts[1]}, nil
+		}
+		return RateLimitConfig{}, err
+	}
+
+	limit := RateLimitConfig{Rate: rate, Key: "client_ip"}
+
+	for i := 2; i < len(parts); i++ {
+		if strings.HasPrefix(parts[i], "burst=") {
+			burstStr := strings.TrimPrefix(parts[i], "burst=")
+			burst, err := strconv.Atoi(burstStr)
+			if err != nil || burst <= 0 {
+				return RateLimitConfig{}, fmt.Errorf("invalid burst: %s", burstStr)
+			}
+			limit.Burst = burst
+		} else if strings.HasPrefix(parts[i], "key=") {
+			key := strings.TrimPrefix(parts[i], "key=")
+			if key != "client_ip" && key != "global" && !strings.HasPrefix(key, "header:") {
+				return RateLimitConfig{}, fmt.Errorf("invalid rate limit key: %s", key)
+			}
+			limit.Key = key
+		} else if strings.HasPrefix(parts[i], "warn=") {
+			warn, err := parseWarnThreshold(strings.TrimPrefix(parts[i], "warn="))
+			if err != nil {
+				return RateLimitConfig{}, err
+			}
+			limit.Warn = warn
+		}
+	}
+
+	return limit, nil
+}
+
+// parseLimitPolicy parses a limit directive defining a named rate limit policy
+func parseLimitPolicy(parts []string) (RateLimitConfig, error) {
+	if len(parts) < 3 {
+		return RateLimitConfig{}, fmt.Errorf("limit directive requires a name and a rate")
+	}
+
+	policy, err := parseRateLimit(parts[1:])
+	if err != nil {
+		return RateLimitConfig{}, err
+	}
+	policy.Policy = parts[1]
+	policy.Name = "limit:" + parts[1]
+
+	return policy, nil
+}
+
+// resolveRateLimit replaces a reference to a limit policy with its settings
+func resolveRateLimit(limit RateLimitConfig, policies map[string]RateLimitConfig) (RateLimitConfig, error) {
+	if limit.Policy == "" || limit.Rate > 0 {
+		return limit, nil
+	}
+
+	policy, ok := policies[limit.Policy]
+	if !ok {
+		return RateLimitConfig{}, ErrInvalidConfig{Message: "unknown limit policy: " + limit.Policy}
+	}
+	return policy, nil
+}
+
+// RateLimiter rejects requests exceeding a rate limit with 429 Too Many Requests
+type RateLimiter struct {
+	next    LoadBalancerStrategy
+	config  RateLimitConfig
+	buckets *bucketSet
+	warn    *softLimit
+}
+
+// NewRateLimiter wraps a strategy with request rate limiting.
+// The strategy is returned unchanged if the rate is not positive.
+func NewRateLimiter(next LoadBalancerStrategy, config RateLimitConfig) LoadBalancerStrategy {
+	if config.Rate <= 0 {
+		return next
+	}
+	return &RateLimiter{
+		next:    next,
+		config:  config,
+		buckets: newBucketSet(config.Rate, config.Burst),
+		warn:    newSoftLimit(config.Name, config.Warn),
+	}
+}
+
+func (rl *RateLimiter) requestKey(r *http.Request) string {
+	switch {
+	case rl.config.Key == "global":
+		return ""
+	case strings.HasPrefix(rl.config.Key, "header:"):
+		return r.Header.Get(strings.TrimPrefix(rl.config.Key, "header:"))
+	default:
+		return getClientIP(r)
+	}
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (rl *RateLimiter) GetNextInstance(r *http.Request) (*url.URL, error) {
+	return rl.next.GetNextInstance(r)
+}
+
+// ProxyRequest proxies the request if it is within the rate limit
+func (rl *RateLimiter) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	allowed, wait, usage := rl.buckets.take(rl.requestKey(r))
+	rl.warn.observe(usage)
+	if rl.config.Policy != "" {
+		countPolicyDecision(rl.config.Policy, allowed)
+	}
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		rejectRequest(w, RejectRateLimited, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	rl.next.ProxyRequest(w, r)
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (rl *RateLimiter) SupportsWebSockets() bool {
+	return rl.next.SupportsWebSockets()
+}
+
+// Unwrap returns the wrapped strategy
+func (rl *RateLimiter) Unwrap() LoadBalancerStrategy {
+	return rl.next
+}
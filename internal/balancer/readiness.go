@@ -0,0 +1,132 @@
+package balancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// ReadinessConfig holds when the load balancer reports itself ready
+type ReadinessConfig struct {
+	// MinBackends is how many backends of every pool must be alive
+	MinBackends int
+	// OnMain serves /healthz and /readyz on the proxy port as well as the
+	// admin port
+	OnMain bool
+}
+
+// parseReadiness parses the arguments of a readiness directive
+func parseReadiness(parts []string) (ReadinessConfig, error) {
+	config := ReadinessConfig{MinBackends: 1}
+
+	for _, part := range parts[1:] {
+		if strings.HasPrefix(part, "min_backends=") {
+			minStr := strings.TrimPrefix(part, "min_backends=")
+			minBackends, err := strconv.Atoi(minStr)
+			if err != nil || minBackends < 0 {
+				return ReadinessConfig{}, fmt.Errorf("invalid readiness min_backends: %s", minStr)
+			}
+			config.MinBackends = minBackends
+		} else if part == "on_main" {
+			config.OnMain = true
+		}
+	}
+
+	return config, nil
+}
+
+// PoolReadiness reports whether a pool has enough live backends
+type PoolReadiness struct {
+	Pool          string `json:"pool"`
+	AliveBackends int    `json:"aliveBackends"`
+	Ready         bool   `json:"ready"`
+}
+
+// Readiness reports whether the load balancer can serve traffic
+type Readiness struct {
+	Ready bool `json:"ready"`
+	// ShuttingDown is set once the load balancer starts draining
+	ShuttingDown bool            `json:"shuttingDown"`
+	MinBackends  int             `json:"minBackends"`
+	Pools        []PoolReadiness `json:"pools"`
+}
+
+// GetReadiness checks every pool for enough live, non-draining backends
+func GetReadiness(lb LoadBalancerStrategy, config ReadinessConfig) Readiness {
+	readiness := Readiness{
+		Ready:        true,
+		ShuttingDown: atomic.LoadInt32(&webSocketDraining) == 1,
+		MinBackends:  config.MinBackends,
+		Pools:        []PoolReadiness{},
+	}
+	if readiness.ShuttingDown {
+		readiness.Ready = false
+	}
+
+	for name, pool := range namedPools(lb) {
+		health := poolHealth(name, pool, 0)
+		pr := PoolReadiness{
+			Pool:          name,
+			AliveBackends: health.HealthyBackends,
+			Ready:         health.HealthyBackends >= config.MinBackends,
+		}
+		if !pr.Ready {
+			readiness.Ready = false
+		}
+		readiness.Pools = append(readiness.Pools, pr)
+	}
+	sort.Slice(readiness.Pools, func(i, j int) bool { return readiness.Pools[i].Pool < readiness.Pools[j].Pool })
+
+	return readiness
+}
+
+// HealthzHandler answers 200 as long as the load balancer is running, for
+// liveness checks
+func HealthzHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte(`{"status":"ok"}` + "\n"))
+	}
+}
+
+// ReadyzHandler answers 200 when every pool has enough live backends and
+// 503 otherwise or once the load balancer shuts down, for orchestrators and
+// upstream load balancers to stop sending it traffic
+func ReadyzHandler(lb LoadBalancerStrategy, config ReadinessConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		readiness := GetReadiness(lb, config)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if !readiness.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(readiness)
+	}
+}
+
+// WithHealthEndpoints serves /healthz and /readyz ahead of the proxy when
+// the readiness configuration asks for them on the proxy port
+func WithHealthEndpoints(next http.Handler, lb LoadBalancerStrategy, config ReadinessConfig) http.Handler {
+	if !config.OnMain {
+		return next
+	}
+
+	healthz := HealthzHandler()
+	readyz := ReadyzHandler(lb, config)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			healthz(w, r)
+		case "/readyz":
+			readyz(w, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,169 @@
+package balancer
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// defaultRealIPHeaders is the order in which the headers of trusted proxies
+// are read when no real_ip_headers directive is configured
+var defaultRealIPHeaders = []string{"CF-Connecting-IP", "X-Real-IP", "X-Forwarded-For"}
+
+// RealIPConfig holds how the IP of the client behind a request is found.
+// Only the headers of requests from trusted proxies are believed, since
+// anyone else can set them to any address.
+type RealIPConfig struct {
+	// TrustedProxies are the networks of the proxies in front of the
+	// balancer
+	TrustedProxies []*net.IPNet
+	// Headers are read in order until one holds a client IP. An
+	// X-Forwarded-For list is read right to left, skipping trusted proxies.
+	Headers []string
+}
+
+// parseTrustedProxies parses the arguments of a trusted_proxies directive,
+// addresses or CIDR networks
+func parseTrustedProxies(parts []string) ([]*net.IPNet, error) {
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("trusted_proxies directive requires at least one address or network")
+	}
+
+	var networks []*net.IPNet
+	for _, part := range parts[1:] {
+		part = strings.TrimSuffix(part, ";")
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, "/") {
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy: %s", part)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %s", part)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// parseRealIPHeaders parses the arguments of a real_ip_headers directive
+func parseRealIPHeaders(parts []string) ([]string, error) {
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("real_ip_headers directive requires at least one header")
+	}
+
+	var headers []string
+	for _, part := range parts[1:] {
+		if part = strings.TrimSuffix(part, ";"); part != "" {
+			headers = append(headers, http.CanonicalHeaderKey(part))
+		}
+	}
+	return headers, nil
+}
+
+var realIP atomic.Pointer[RealIPConfig]
+
+// SetRealIP sets the trusted proxies and the headers they pass the client
+// IP in
+func SetRealIP(config RealIPConfig) {
+	realIP.Store(&config)
+}
+
+func realIPConfig() RealIPConfig {
+	config := RealIPConfig{}
+	if stored := realIP.Load(); stored != nil {
+		config = *stored
+	}
+	if len(config.Headers) == 0 {
+		config.Headers = defaultRealIPHeaders
+	}
+	return config
+}
+
+// trusted returns true if an address belongs to a trusted proxy
+func (c RealIPConfig) trusted(ip net.IP) bool {
+	for _, network := range c.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// getClientIP returns the IP of the client behind a request: the address the
+// request came from or, if that is a trusted proxy, the address the proxy
+// passed in the first of the real IP headers holding one. It is used for
+// persistence, rate limiting, routing and logging alike.
+func getClientIP(r *http.Request) string {
+	peer := parseForwardedIP(r.RemoteAddr)
+	if peer == nil {
+		return ""
+	}
+
+	config := realIPConfig()
+	if !config.trusted(peer) {
+		return peer.String()
+	}
+
+	for _, header := range config.Headers {
+		if http.CanonicalHeaderKey(header) == "X-Forwarded-For" {
+			if ip := forwardedForIP(r.Header.Values(header), config); ip != nil {
+				return ip.String()
+			}
+			continue
+		}
+		if ip := parseForwardedIP(r.Header.Get(header)); ip != nil {
+			return ip.String()
+		}
+	}
+	return peer.String()
+}
+
+// forwardedForIP returns the client address of X-Forwarded-For lists: the
+// last address that is not a trusted proxy, as every proxy appends the
+// address it got the request from. Addresses left of a malformed one are
+// not believed.
+func forwardedForIP(values []string, config RealIPConfig) net.IP {
+	var addrs []string
+	for _, value := range values {
+		addrs = append(addrs, strings.Split(value, ",")...)
+	}
+
+	var client net.IP
+	for i := len(addrs) - 1; i >= 0; i-- {
+		ip := parseForwardedIP(addrs[i])
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !config.trusted(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// parseForwardedIP parses an address with or without a port, or returns nil
+// if it is not one
+func parseForwardedIP(addr string) net.IP {
+	addr = strings.TrimSpace(addr)
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return net.ParseIP(host)
+	}
+	return nil
+}
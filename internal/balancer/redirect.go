@@ -0,0 +1,115 @@
+package balancer
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// rewriteRedirect points the Location of a redirect to a backend of a pool
+// back at the address and scheme the client used, so clients never see the
+// internal addresses of backends. The path prefix of the backend is taken
+// off, and redirects elsewhere are left alone.
+func rewriteRedirect(resp *http.Response, r *http.Request, backends []*Process) {
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		return
+	}
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return
+	}
+
+	var backend *Process
+	switch {
+	case u.Host != "":
+		if backend = backendAt(backends, u.Scheme, u.Host); backend == nil {
+			return
+		}
+		u.Scheme, u.Host = publicScheme(r), r.Host
+	case strings.HasPrefix(u.Path, "/") && resp.Request != nil:
+		// Relative to the backend that answered
+		backend = backendAt(backends, resp.Request.URL.Scheme, resp.Request.URL.Host)
+	}
+	if backend == nil {
+		return
+	}
+
+	if backend.Prefix != "" {
+		u.Path = trimPrefixPath(backend.Prefix, u.Path)
+		if u.RawPath != "" {
+			u.RawPath = trimPrefixPath(backend.Prefix, u.RawPath)
+		}
+	}
+	resp.Header.Set("Location", u.String())
+}
+
+// backendAt returns the backend of a pool listening on an address or
+// addressed by its Host override, or nil. A missing scheme is the one of
+// the backend.
+func backendAt(backends []*Process, scheme, host string) *Process {
+	for _, p := range backends {
+		addressScheme := scheme
+		if addressScheme == "" {
+			addressScheme = p.URL.Scheme
+		}
+		address := hostWithPort(addressScheme, host)
+		if address == hostWithPort(p.URL.Scheme, p.URL.Host) ||
+			(p.Host != "" && address == hostWithPort(p.URL.Scheme, p.Host)) {
+			return p
+		}
+	}
+	return nil
+}
+
+// hostWithPort returns a lowercase host with its port, the default port of
+// the scheme if it has none
+func hostWithPort(scheme, host string) string {
+	host = strings.ToLower(host)
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	port := "80"
+	if strings.EqualFold(scheme, "https") || strings.EqualFold(scheme, "wss") {
+		port = "443"
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+// trimPrefixPath takes a backend's path prefix off the front of a path
+func trimPrefixPath(prefix, path string) string {
+	switch {
+	case path == prefix:
+		return "/"
+	case strings.HasPrefix(path, prefix+"/"):
+		return strings.TrimPrefix(path, prefix)
+	}
+	return path
+}
+
+// publicScheme returns the scheme the client reached the balancer with,
+// as passed in X-Forwarded-Proto by a trusted proxy in front of it
+func publicScheme(r *http.Request) string {
+	if peer := parseForwardedIP(r.RemoteAddr); peer != nil && realIPConfig().trusted(peer) {
+		switch proto := strings.ToLower(r.Header.Get("X-Forwarded-Proto")); proto {
+		case "http", "https":
+			return proto
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// setRewriteRedirects makes the balancer behind a strategy point the
+// redirects of its backends back at the balancer
+func setRewriteRedirects(strategy LoadBalancerStrategy, enabled bool) {
+	if ps, ok := strategy.(*PoolStrategy); ok && enabled {
+		ps.pool.Settings().RewriteRedirects = true
+	}
+}
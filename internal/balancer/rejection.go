@@ -0,0 +1,116 @@
+package balancer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// RejectReasonHeader is the response header carrying the reason code when
+// the load balancer itself rejects a request
+const RejectReasonHeader = "X-LB-Reject-Reason"
+
+// RejectReason is a machine-readable code explaining why a request was rejected
+type RejectReason string
+
+const (
+	// RejectRateLimited is used when a request exceeds a rate limit
+	RejectRateLimited RejectReason = "rate_limited"
+	// RejectNoBackend is used when no healthy backend is available
+	RejectNoBackend RejectReason = "no_backend"
+	// RejectBackendsSaturated is used when every healthy backend is at its connection limit
+	RejectBackendsSaturated RejectReason = "backends_saturated"
+	// RejectHashHopsExhausted is used when no backend within max_hops of a
+	// consistent hash key can take the request, though others could
+	RejectHashHopsExhausted RejectReason = "hash_hops_exhausted"
+	// RejectQueueFull is used when the request queue of a saturated pool is full
+	RejectQueueFull RejectReason = "queue_full"
+	// RejectQueueTimeout is used when a queued request waits too long for a backend
+	RejectQueueTimeout RejectReason = "queue_timeout"
+	// RejectPoolConcurrency is used when a pool is at its concurrency ceiling
+	RejectPoolConcurrency RejectReason = "pool_concurrency_limit"
+	// RejectPoolQPS is used when a pool is at its request rate ceiling
+	RejectPoolQPS RejectReason = "pool_qps_limit"
+	// RejectFailoverExhausted is used when every pool of a failover chain is down
+	RejectFailoverExhausted RejectReason = "failover_exhausted"
+	// RejectDeadlineExceeded is used when a request's deadline passes before a backend answers
+	RejectDeadlineExceeded RejectReason = "deadline_exceeded"
+	// RejectRetriesExhausted is used when a request failed on its backend and
+	// its retries are used up
+	RejectRetriesExhausted RejectReason = "retries_exhausted"
+	// RejectCanceled is used when a request is canceled, such as by the client going away
+	RejectCanceled RejectReason = "canceled"
+	// RejectDraining is used for WebSocket upgrades while the server shuts down
+	RejectDraining RejectReason = "draining"
+	// RejectSchemaViolation is used when a request body does not match the route's schema
+	RejectSchemaViolation RejectReason = "schema_violation"
+	// RejectInvalidResponse is used when a backend response does not match the route's schema
+	RejectInvalidResponse RejectReason = "invalid_response"
+	// RejectBodyTooLarge is used when a request body on a route with a request
+	// schema is larger than the validator reads
+	RejectBodyTooLarge RejectReason = "body_too_large"
+	// RejectUnauthorized is used when a request to a protected route is not authenticated
+	RejectUnauthorized RejectReason = "unauthorized"
+	// RejectForbidden is used when the authorization service denies a request
+	RejectForbidden RejectReason = "forbidden"
+	// RejectAuthUnavailable is used when the authorization service fails to answer
+	RejectAuthUnavailable RejectReason = "auth_unavailable"
+	// RejectBlocked is used when a request matches a bot or scanner block rule
+	RejectBlocked RejectReason = "blocked"
+	// RejectUnknownBackend is used when a routing override names a backend
+	// the pool serving the request does not have
+	RejectUnknownBackend RejectReason = "unknown_backend"
+	// RejectOverloaded is used when a request is shed while the server is overloaded
+	RejectOverloaded RejectReason = "overloaded"
+)
+
+var (
+	rejectionCounts   = make(map[RejectReason]int64)
+	rejectionCountsMu sync.Mutex
+)
+
+// rejectRequest writes an error response tagged with a reason code and
+// counts the rejection
+func rejectRequest(w http.ResponseWriter, reason RejectReason, message string, status int) {
+	countRejection(reason)
+
+	w.Header().Set(RejectReasonHeader, string(reason))
+	http.Error(w, message, status)
+}
+
+func countRejection(reason RejectReason) {
+	rejectionCountsMu.Lock()
+	rejectionCounts[reason]++
+	rejectionCountsMu.Unlock()
+}
+
+// GetRejectionCounts returns the number of rejected requests per reason code
+func GetRejectionCounts() map[string]int64 {
+	rejectionCountsMu.Lock()
+	defer rejectionCountsMu.Unlock()
+
+	counts := make(map[string]int64, len(rejectionCounts))
+	for reason, count := range rejectionCounts {
+		counts[string(reason)] = count
+	}
+	return counts
+}
+
+// writeRejectionsPrometheus writes the rejection counts in the Prometheus
+// text format
+func writeRejectionsPrometheus(w io.Writer) {
+	counts := GetRejectionCounts()
+	reasons := make([]string, 0, len(counts))
+	for reason := range counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	fmt.Fprintln(w, "# HELP lb_rejections_total Requests rejected by the load balancer, by reason.")
+	fmt.Fprintln(w, "# TYPE lb_rejections_total counter")
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "lb_rejections_total{reason=\"%s\"} %d\n", escapeLabelValue(reason), counts[reason])
+	}
+}
@@ -0,0 +1,92 @@
+package balancer
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// defaultRemovalDrain is how long a removed backend keeps the requests and
+// WebSockets in flight to it when its pool sets no DrainTimeout
+const defaultRemovalDrain = 30 * time.Second
+
+// retirement returns a context canceled once the backend is removed from
+// its pool and its drain timeout has passed
+func (p *Process) retirement() context.Context {
+	p.retireOnce.Do(func() {
+		p.retired, p.retire = context.WithCancel(context.Background())
+	})
+	return p.retired
+}
+
+// untilRetired returns the request bound to the backend's retirement, so it
+// is cut off if still in flight when a removed backend's drain timeout
+// passes, and a function releasing the binding
+func untilRetired(r *http.Request, p *Process) (*http.Request, func()) {
+	ctx, cancel := context.WithCancel(r.Context())
+	stop := context.AfterFunc(p.retirement(), cancel)
+	return r.WithContext(ctx), func() {
+		stop()
+		cancel()
+	}
+}
+
+// drainRemoved lets a backend removed from its pool finish the requests and
+// WebSockets in flight to it, then cuts off the ones left after timeout
+func drainRemoved(p *Process, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultRemovalDrain
+	}
+
+	go func() {
+		deadline := time.NewTimer(timeout)
+		defer deadline.Stop()
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+
+	wait:
+		for p.GetActiveConnections() > 0 {
+			select {
+			case <-ticker.C:
+			case <-deadline.C:
+				break wait
+			}
+		}
+
+		if active := p.GetActiveConnections(); active > 0 {
+			logger.Proxy.Warn("Cutting off connections to removed backend that did not drain in time",
+				zap.String("backend", p.URL.Redacted()),
+				zap.Int32("connections", active),
+				zap.Duration("timeout", timeout))
+			closeWebSocketsTo(p, "backend removed")
+		} else {
+			logger.Proxy.Info("Removed backend drained", zap.String("backend", p.URL.Redacted()))
+		}
+		p.retirement()
+		p.retire()
+
+		if transport, ok := addressTransports.LoadAndDelete(p); ok {
+			transport.(*http.Transport).CloseIdleConnections()
+		}
+	}()
+}
+
+// closeWebSocketsTo asks both ends of the WebSockets open to a backend to
+// close and closes them
+func closeWebSocketsTo(p *Process, reason string) {
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseGoingAway, reason)
+	deadline := time.Now().Add(time.Second)
+	for _, conn := range webSocketConnections.All() {
+		if conn.Backend != p {
+			continue
+		}
+		conn.ClientConn.WriteControl(websocket.CloseMessage, closeMessage, deadline)
+		conn.BackendConn.WriteControl(websocket.CloseMessage, closeMessage, deadline)
+		conn.ClientConn.Close()
+		conn.BackendConn.Close()
+	}
+}
@@ -0,0 +1,114 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// RequestInfo is what the balancer decided about a request so far: the
+// route and pool it took and the backends it was tried on. It travels in the
+// request's context alongside the deadline and cancellation of the request.
+type RequestInfo struct {
+	Route    string
+	Pool     string
+	Attempts []Attempt
+}
+
+// Attempt is one try of a request on a backend
+type Attempt struct {
+	Backend string
+	Start   time.Time
+	// Err is why the attempt failed, or nil if it did not fail (yet)
+	Err error
+}
+
+// requestInfoRecorder collects the RequestInfo of a request in flight
+type requestInfoRecorder struct {
+	mu   sync.Mutex
+	info RequestInfo
+}
+
+type requestInfoContextKey struct{}
+
+// WithRequestInfo returns a context recording what the balancer decides
+// about the requests made with it, for RequestInfoFromContext
+func WithRequestInfo(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(requestInfoContextKey{}).(*requestInfoRecorder); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, requestInfoContextKey{}, &requestInfoRecorder{})
+}
+
+// RequestInfoFromContext returns what the balancer decided about a request
+// made with a context from WithRequestInfo
+func RequestInfoFromContext(ctx context.Context) (RequestInfo, bool) {
+	recorder, ok := ctx.Value(requestInfoContextKey{}).(*requestInfoRecorder)
+	if !ok {
+		return RequestInfo{}, false
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	info := recorder.info
+	info.Attempts = append([]Attempt(nil), recorder.info.Attempts...)
+	return info, true
+}
+
+func requestInfoFromRequest(r *http.Request) *requestInfoRecorder {
+	recorder, _ := r.Context().Value(requestInfoContextKey{}).(*requestInfoRecorder)
+	return recorder
+}
+
+// GetNextInstanceContext selects a backend for a request within a context,
+// such as one with a deadline, and fails with the context's error once it
+// is done
+func GetNextInstanceContext(ctx context.Context, lb LoadBalancerStrategy, r *http.Request) (*url.URL, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return lb.GetNextInstance(r.WithContext(WithRequestInfo(ctx)))
+}
+
+// ProxyRequestContext proxies a request within a context. The request is
+// neither queued nor retried past the context's deadline or cancellation.
+func ProxyRequestContext(ctx context.Context, lb LoadBalancerStrategy, w http.ResponseWriter, r *http.Request) {
+	lb.ProxyRequest(w, r.WithContext(WithRequestInfo(ctx)))
+}
+
+// contextRejection returns the reason to reject a request whose context is
+// done, or "" if it is not
+func contextRejection(r *http.Request) RejectReason {
+	switch err := r.Context().Err(); {
+	case errors.Is(err, context.DeadlineExceeded):
+		return RejectDeadlineExceeded
+	case err != nil:
+		return RejectCanceled
+	}
+	return ""
+}
+
+// attemptFailed records the failure of the last attempt of a request and
+// reports whether the backend is to blame. It is not when the request's
+// context is done, as the client went away or the deadline passed: the
+// request is rejected instead of retried.
+func attemptFailed(w http.ResponseWriter, r *http.Request, err error) bool {
+	if recorder := requestInfoFromRequest(r); recorder != nil {
+		recorder.mu.Lock()
+		if n := len(recorder.info.Attempts); n > 0 {
+			recorder.info.Attempts[n-1].Err = err
+		}
+		recorder.mu.Unlock()
+	}
+
+	if reason := contextRejection(r); reason != "" {
+		message, status := rejectionMessage(reason)
+		rejectRequest(w, reason, message, status)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+package balancer
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"time"
+)
+
+// statusRecorder records the status code written to a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusRecorder) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, fmt.Errorf("response writer does not implement http.Hijacker")
+}
+
+// serveAndRecord proxies a request to a backend, with the Host header and TLS
+// server name it is configured with, and records its status and response
+// time, honoring drain signals in the response and adding debug headers if
+// they are on. Requests the proxy failed to deliver count as 502s
+// even if a retry on another backend answered, and the responses of
+// streaming routes are flushed after every write. The response of a hedged
+// request is recorded for the backend that sent it. Responses are
+// rewritten by the transform of their route, and the proxy's own
+// ModifyResponse, if any, runs last.
+func serveAndRecord(proxy *httputil.ReverseProxy, w http.ResponseWriter, r *http.Request, p *Process, failed *bool) {
+	if proxy.Transport == nil {
+		proxy.Transport = transportFor(p)
+	}
+	if isStreaming(r) {
+		proxy.FlushInterval = -1
+	}
+	proxy.Director = backendDirector(proxy.Director, p)
+	modifyResponse := proxy.ModifyResponse
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if hedged := hedgedBackend(resp.Request); hedged != nil {
+			p = hedged
+			annotateBackend(r, p.URL)
+		}
+		checkDrainSignal(p, resp)
+		if featureEnabled(FeatureDebugHeaders) {
+			resp.Header.Set(DebugBackendHeader, p.URL.String())
+		}
+		if err := transformResponseFor(r, resp); err != nil {
+			return err
+		}
+		if modifyResponse != nil {
+			return modifyResponse(resp)
+		}
+		return nil
+	}
+
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	start := time.Now()
+	// A request still in flight when its backend is removed and drained is
+	// cut off
+	outreq, stop := untilRetired(traceConnectionPool(traceUpstream(r), p), p)
+	proxy.ServeHTTP(recorder, outreq)
+	stop()
+	finishUpstream(r)
+
+	status := recorder.status
+	if *failed {
+		status = http.StatusBadGateway
+	} else {
+		p.markServed()
+	}
+	p.RecordRequest(status, time.Since(start))
+}
@@ -0,0 +1,323 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// RetryConfig holds how requests are retried when their backend fails, and
+// hedged when it is slow
+type RetryConfig struct {
+	// MaxRetries is how many times a request whose backend failed is sent to
+	// a backend again, or -1 to retry as long as a backend is alive
+	MaxRetries int
+	// HedgeAfter is how long the backend of an idempotent request without a
+	// body has to start answering before a copy of the request is sent to
+	// another backend. The first to answer serves the request. 0 disables
+	// hedging.
+	HedgeAfter time.Duration
+	// MaxHedges is how many copies of a request may be sent
+	MaxHedges int
+}
+
+// defaultRetry is used when no retries directive is configured
+var defaultRetry = RetryConfig{MaxRetries: -1}
+
+// parseRetry parses the arguments of a retries directive:
+// retries <N|unlimited> [hedge=<duration>] [max_hedges=<N>]
+func parseRetry(parts []string) (RetryConfig, error) {
+	if len(parts) < 2 {
+		return RetryConfig{}, fmt.Errorf("retries directive requires a number of retries or unlimited")
+	}
+
+	config := defaultRetry
+	if maxStr := strings.TrimSuffix(parts[1], ";"); maxStr != "unlimited" {
+		maxRetries, err := strconv.Atoi(maxStr)
+		if err != nil || maxRetries < 0 {
+			return RetryConfig{}, fmt.Errorf("invalid retries: %s", maxStr)
+		}
+		config.MaxRetries = maxRetries
+	}
+
+	for _, part := range parts[2:] {
+		part = strings.TrimSuffix(part, ";")
+		if strings.HasPrefix(part, "hedge=") {
+			hedgeStr := strings.TrimPrefix(part, "hedge=")
+			hedge, err := time.ParseDuration(hedgeStr)
+			if err != nil || hedge <= 0 {
+				return RetryConfig{}, fmt.Errorf("invalid hedge delay: %s", hedgeStr)
+			}
+			config.HedgeAfter = hedge
+		} else if strings.HasPrefix(part, "max_hedges=") {
+			maxStr := strings.TrimPrefix(part, "max_hedges=")
+			maxHedges, err := strconv.Atoi(maxStr)
+			if err != nil || maxHedges <= 0 {
+				return RetryConfig{}, fmt.Errorf("invalid max_hedges: %s", maxStr)
+			}
+			config.MaxHedges = maxHedges
+		} else {
+			return RetryConfig{}, fmt.Errorf("unknown retries option: %s", part)
+		}
+	}
+
+	if config.HedgeAfter > 0 && config.MaxHedges == 0 {
+		config.MaxHedges = 1
+	}
+	return config, nil
+}
+
+// String formats the settings as the arguments of a retries directive
+func (c RetryConfig) String() string {
+	s := "unlimited"
+	if c.MaxRetries >= 0 {
+		s = strconv.Itoa(c.MaxRetries)
+	}
+	if c.HedgeAfter > 0 {
+		s += fmt.Sprintf(" hedge=%s max_hedges=%d", c.HedgeAfter, c.MaxHedges)
+	}
+	return s
+}
+
+// exhausted returns true if a request that failed on a backend may not be
+// retried
+func (c RetryConfig) exhausted(r *http.Request) bool {
+	return c.MaxRetries >= 0 && len(triedBackends(r)) >= c.MaxRetries
+}
+
+var retry atomic.Pointer[RetryConfig]
+
+// SetRetry sets how requests are retried and hedged in the pools without a
+// retries directive of their own
+func SetRetry(config RetryConfig) {
+	retry.Store(&config)
+}
+
+func retryConfig() RetryConfig {
+	if config := retry.Load(); config != nil {
+		return *config
+	}
+	return defaultRetry
+}
+
+// retry returns how the requests of the pool are retried and hedged
+func (s *PoolSettings) retry() RetryConfig {
+	if s.Retry != nil {
+		return *s.Retry
+	}
+	return retryConfig()
+}
+
+// setRetry makes the balancer behind a strategy retry and hedge requests
+// with its own settings instead of the global ones
+func setRetry(strategy LoadBalancerStrategy, config RetryConfig) {
+	if ps, ok := strategy.(*PoolStrategy); ok {
+		ps.pool.Settings().Retry = &config
+	}
+}
+
+// hedgeable returns true if copies of a request may be sent to several
+// backends: it is idempotent, has no body to replay and does not stream
+func hedgeable(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodDelete:
+	default:
+		return false
+	}
+	return r.ContentLength == 0 && (r.Body == nil || r.Body == http.NoBody) && !isStreaming(r)
+}
+
+// hedgedBackendKey marks the copies of a hedged request with their backend
+type hedgedBackendKey struct{}
+
+// hedgedBackend returns the backend a copy of a hedged request was sent to,
+// or nil if the request is not a copy
+func hedgedBackend(r *http.Request) *Process {
+	if r == nil {
+		return nil
+	}
+	p, _ := r.Context().Value(hedgedBackendKey{}).(*Process)
+	return p
+}
+
+// hedgingTransport sends a request to its backend and, while the backend has
+// not started answering, copies of it to other backends of the pool, one per
+// hedge delay. The first response wins and the other requests are canceled.
+type hedgingTransport struct {
+	pool    Pool
+	primary *Process
+	// inbound is the request as the balancer received it, to address copies
+	inbound *http.Request
+	config  RetryConfig
+}
+
+// hedgeAttempt is the outcome of sending a request to one backend
+type hedgeAttempt struct {
+	p      *Process
+	resp   *http.Response
+	err    error
+	cancel context.CancelFunc
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *hedgingTransport) RoundTrip(outreq *http.Request) (*http.Response, error) {
+	attempts := make(chan hedgeAttempt, t.config.MaxHedges+1)
+	cancels := make(map[*Process]context.CancelFunc)
+	send := func(p *Process, req *http.Request) {
+		ctx, cancel := context.WithCancel(req.Context())
+		cancels[p] = cancel
+		go func() {
+			resp, err := transportFor(p).RoundTrip(req.WithContext(ctx))
+			attempts <- hedgeAttempt{p: p, resp: resp, err: err, cancel: cancel}
+		}()
+	}
+	send(t.primary, outreq)
+
+	timer := time.NewTimer(t.config.HedgeAfter)
+	defer timer.Stop()
+
+	pending, hedges := 1, 0
+	var primaryErr error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if hedges >= t.config.MaxHedges {
+				continue
+			}
+			p := t.pickHedge(cancels)
+			if p == nil {
+				continue
+			}
+			hedges++
+			pending++
+			annotateHedge(t.inbound)
+			logger.Proxy.Debug("Hedging slow request",
+				zap.String("backend", t.primary.URL.String()),
+				zap.String("hedge", p.URL.String()))
+			send(p, t.hedgedRequest(outreq, p))
+			if hedges < t.config.MaxHedges {
+				timer.Reset(t.config.HedgeAfter)
+			}
+
+		case attempt := <-attempts:
+			pending--
+			if attempt.err != nil {
+				if attempt.p == t.primary {
+					primaryErr = attempt.err
+				} else {
+					t.finishHedge(attempt)
+				}
+				continue
+			}
+
+			// The winner's request is canceled once its body is read
+			for p, cancel := range cancels {
+				if p != attempt.p {
+					cancel()
+				}
+			}
+			if primaryErr != nil && t.primary.recordFailure(primaryErr) {
+				logger.Proxy.Warn("Backend marked dead", zap.String("backend", t.primary.URL.String()))
+				t.pool.Settings().revivals().watch(t.primary)
+			}
+			attempt.resp.Body = &hedgedBody{ReadCloser: attempt.resp.Body, done: func() {
+				attempt.cancel()
+				if attempt.p != t.primary {
+					releaseProcess(t.pool.Settings().Queue, attempt.p)
+				}
+			}}
+			go t.drain(attempts, pending)
+			return attempt.resp, nil
+		}
+	}
+
+	// Every backend failed; the primary's error is handled like an
+	// unhedged failure
+	return nil, primaryErr
+}
+
+// pickHedge picks and reserves a backend of the pool not yet sent the
+// request, or returns nil if there is none
+func (t *hedgingTransport) pickHedge(used map[*Process]context.CancelFunc) *Process {
+	backends := t.pool.Backends()
+	for i := 0; i < len(backends); i++ {
+		p := t.pool.Pick(t.inbound)
+		if p == nil {
+			return nil
+		}
+		if _, ok := used[p]; ok || !p.TryAcquire() {
+			continue
+		}
+		return p
+	}
+	return nil
+}
+
+// hedgedRequest addresses a copy of the request sent to the primary backend
+// to another backend
+func (t *hedgingTransport) hedgedRequest(outreq *http.Request, p *Process) *http.Request {
+	req := outreq.Clone(context.WithValue(outreq.Context(), hedgedBackendKey{}, p))
+	target := *t.inbound.URL
+	req.URL = &target
+	req.Host = t.inbound.Host
+	backendDirector(httputil.NewSingleHostReverseProxy(p.URL).Director, p)(req)
+	return req
+}
+
+// finishHedge releases the backend of a copy that did not serve the request
+// and records its failure
+func (t *hedgingTransport) finishHedge(attempt hedgeAttempt) {
+	attempt.cancel()
+	if attempt.resp != nil {
+		io.Copy(io.Discard, attempt.resp.Body)
+		attempt.resp.Body.Close()
+	}
+	if attempt.err != nil && t.inbound.Context().Err() == nil && !errors.Is(attempt.err, context.Canceled) {
+		if attempt.p.recordFailure(attempt.err) {
+			logger.Proxy.Warn("Backend marked dead", zap.String("backend", attempt.p.URL.String()))
+			t.pool.Settings().revivals().watch(attempt.p)
+		}
+	}
+	releaseProcess(t.pool.Settings().Queue, attempt.p)
+}
+
+// drain finishes the requests that lost the race as they return
+func (t *hedgingTransport) drain(attempts chan hedgeAttempt, pending int) {
+	for ; pending > 0; pending-- {
+		attempt := <-attempts
+		if attempt.p == t.primary {
+			// The primary's slot is released by the proxy
+			attempt.cancel()
+			if attempt.resp != nil {
+				attempt.resp.Body.Close()
+			}
+			continue
+		}
+		t.finishHedge(attempt)
+	}
+}
+
+// hedgedBody runs done once the body of the winning response is closed
+type hedgedBody struct {
+	io.ReadCloser
+	done func()
+	once atomic.Bool
+}
+
+func (b *hedgedBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.once.CompareAndSwap(false, true) {
+		b.done()
+	}
+	return err
+}
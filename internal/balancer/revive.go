@@ -0,0 +1,350 @@
+package balancer
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// RevivalConfig holds how dead backends are brought back into rotation
+type RevivalConfig struct {
+	// Initial is the wait before the first revival attempt, multiplied by
+	// Multiplier after each failed attempt up to Max
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	// Jitter spreads each wait randomly by up to this fraction of it, so
+	// backends that died together are not all probed at once
+	Jitter float64
+	// Probe requires a successful HEAD request to ProbePath before a backend
+	// is revived. Without it a revived backend is on trial: its first
+	// failure marks it dead again.
+	Probe     bool
+	ProbePath string
+}
+
+// defaultRevival is used when no revive directive is configured
+var defaultRevival = RevivalConfig{
+	Initial:    10 * time.Second,
+	Max:        5 * time.Minute,
+	Multiplier: 2,
+	Jitter:     0.2,
+	Probe:      true,
+	ProbePath:  "/",
+}
+
+// parseRevival parses the arguments of a revive directive
+func parseRevival(parts []string) (RevivalConfig, error) {
+	config := defaultRevival
+
+	for _, part := range parts[1:] {
+		if strings.HasPrefix(part, "initial=") {
+			initialStr := strings.TrimPrefix(part, "initial=")
+			initial, err := time.ParseDuration(initialStr)
+			if err != nil || initial <= 0 {
+				return RevivalConfig{}, fmt.Errorf("invalid revive initial delay: %s", initialStr)
+			}
+			config.Initial = initial
+		} else if strings.HasPrefix(part, "max=") {
+			maxStr := strings.TrimPrefix(part, "max=")
+			maxDelay, err := time.ParseDuration(maxStr)
+			if err != nil || maxDelay <= 0 {
+				return RevivalConfig{}, fmt.Errorf("invalid revive max delay: %s", maxStr)
+			}
+			config.Max = maxDelay
+		} else if strings.HasPrefix(part, "multiplier=") {
+			multiplierStr := strings.TrimPrefix(part, "multiplier=")
+			multiplier, err := strconv.ParseFloat(multiplierStr, 64)
+			if err != nil || multiplier < 1 {
+				return RevivalConfig{}, fmt.Errorf("invalid revive multiplier, expected at least 1: %s", multiplierStr)
+			}
+			config.Multiplier = multiplier
+		} else if strings.HasPrefix(part, "jitter=") {
+			jitterStr := strings.TrimPrefix(part, "jitter=")
+			jitter, err := strconv.ParseFloat(jitterStr, 64)
+			if err != nil || jitter < 0 || jitter > 1 {
+				return RevivalConfig{}, fmt.Errorf("invalid revive jitter, expected between 0 and 1: %s", jitterStr)
+			}
+			config.Jitter = jitter
+		} else if strings.HasPrefix(part, "probe=") {
+			probe := strings.TrimPrefix(part, "probe=")
+			if probe == "off" {
+				config.Probe = false
+			} else if strings.HasPrefix(probe, "/") {
+				config.ProbePath = probe
+			} else {
+				return RevivalConfig{}, fmt.Errorf("invalid revive probe, expected a path or off: %s", probe)
+			}
+		}
+	}
+
+	if config.Max < config.Initial {
+		config.Max = config.Initial
+	}
+	return config, nil
+}
+
+// delay returns the wait before a revival attempt
+func (c RevivalConfig) delay(attempt int32) time.Duration {
+	delay := float64(c.Initial)
+	for i := int32(0); i < attempt && delay < float64(c.Max); i++ {
+		delay *= c.Multiplier
+	}
+	delay = min(delay, float64(c.Max))
+	return time.Duration(delay * (1 + c.Jitter*(2*rand.Float64()-1)))
+}
+
+// String formats the settings as the arguments of a revive directive
+func (c RevivalConfig) String() string {
+	probe := "off"
+	if c.Probe {
+		probe = c.ProbePath
+	}
+	return fmt.Sprintf("initial=%s max=%s multiplier=%g jitter=%g probe=%s", c.Initial, c.Max, c.Multiplier, c.Jitter, probe)
+}
+
+var revival atomic.Pointer[RevivalConfig]
+
+// SetRevival sets how dead backends are brought back into rotation
+func SetRevival(config RevivalConfig) {
+	revival.Store(&config)
+}
+
+// setRevival makes the balancer behind a strategy revive its dead backends
+// with its own settings instead of the global ones
+func setRevival(strategy LoadBalancerStrategy, config RevivalConfig) {
+	if ps, ok := strategy.(*PoolStrategy); ok {
+		ps.pool.Settings().Revival = &config
+	}
+}
+
+func revivalConfig() RevivalConfig {
+	if config := revival.Load(); config != nil {
+		return *config
+	}
+	return defaultRevival
+}
+
+// revivalSupervisor brings the dead backends of a pool back into rotation.
+// A single goroutine runs while the pool has dead backends, scheduling each
+// revival attempt with exponential backoff. The backoff keeps growing across
+// revivals until the backend answers a request or probe. The goroutine
+// ends once the supervisor is stopped, when the pool is torn down.
+type revivalSupervisor struct {
+	settings *PoolSettings
+	mu       sync.Mutex
+	// due holds the next revival attempt of every dead backend
+	due     map[*Process]time.Time
+	running bool
+	stopped bool
+	wake    chan struct{}
+}
+
+func newRevivalSupervisor(settings *PoolSettings) *revivalSupervisor {
+	return &revivalSupervisor{
+		settings: settings,
+		due:      make(map[*Process]time.Time),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// config returns the revival settings of the pool
+func (s *revivalSupervisor) config() RevivalConfig {
+	if config := s.settings.Revival; config != nil {
+		return *config
+	}
+	return revivalConfig()
+}
+
+// watch schedules the revival of a backend marked dead. A backend already
+// waiting for its revival keeps its schedule.
+func (s *revivalSupervisor) watch(p *Process) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.due[p]; ok || s.stopped {
+		return
+	}
+	s.schedule(p)
+
+	if !s.running {
+		s.running = true
+		go s.run()
+		return
+	}
+	s.notify()
+}
+
+// forget drops the revivals of backends removed from the pool
+func (s *revivalSupervisor) forget(processes []*Process) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range processes {
+		delete(s.due, p)
+	}
+	s.notify()
+}
+
+// stop drops every pending revival and ends the supervisor's goroutine.
+// Backends marked dead afterwards are not revived.
+func (s *revivalSupervisor) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stopped = true
+	s.due = make(map[*Process]time.Time)
+	s.notify()
+}
+
+// notify wakes the goroutine up to look at the schedule again; s.mu must be
+// held
+func (s *revivalSupervisor) notify() {
+	if !s.running {
+		return
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// schedule sets the next revival attempt of a backend; s.mu must be held
+func (s *revivalSupervisor) schedule(p *Process) {
+	attempt := atomic.AddInt32(&p.reviveAttempts, 1) - 1
+	s.due[p] = time.Now().Add(s.config().delay(attempt))
+}
+
+// run attempts the revivals as they fall due, and returns once no backend
+// is left to revive
+func (s *revivalSupervisor) run() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		if len(s.due) == 0 {
+			s.running = false
+			s.mu.Unlock()
+			return
+		}
+		var next time.Time
+		for _, at := range s.due {
+			if next.IsZero() || at.Before(next) {
+				next = at
+			}
+		}
+		s.mu.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-s.wake:
+			continue
+		}
+
+		s.reviveDue()
+	}
+}
+
+// reviveDue attempts the revivals that fell due, probing the backends in
+// parallel so a slow backend does not delay the others
+func (s *revivalSupervisor) reviveDue() {
+	now := time.Now()
+	var due []*Process
+	s.mu.Lock()
+	for p, at := range s.due {
+		if !at.After(now) {
+			due = append(due, p)
+		}
+	}
+	s.mu.Unlock()
+
+	config := s.config()
+	revived := make([]bool, len(due))
+	var wg sync.WaitGroup
+	for i, p := range due {
+		wg.Add(1)
+		go func(i int, p *Process) {
+			defer wg.Done()
+			revived[i] = revive(p, config)
+		}(i, p)
+	}
+	wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, p := range due {
+		if _, ok := s.due[p]; !ok {
+			// Forgotten or stopped while it was probed
+			continue
+		}
+		if revived[i] {
+			delete(s.due, p)
+		} else {
+			s.schedule(p)
+		}
+	}
+}
+
+// StopRevivals stops reviving the dead backends of every pool behind a
+// strategy, ending the goroutines that revive them. It is called once the
+// strategy no longer serves requests.
+func StopRevivals(lb LoadBalancerStrategy) {
+	walkBalancers(lb, func(balancer interface{}) {
+		if pool, ok := balancer.(Pool); ok {
+			pool.Settings().stopRevivals()
+		}
+	})
+}
+
+// revive attempts to bring a dead backend back into rotation and reports
+// whether it no longer needs reviving
+func revive(p *Process, config RevivalConfig) bool {
+	attempt := atomic.LoadInt32(&p.reviveAttempts)
+	if p.IsDeregistered() || p.IsAlive() {
+		return true
+	}
+
+	if !config.Probe {
+		// On trial: one more failure marks the backend dead again
+		atomic.StoreInt32(&p.ErrorCount, 2)
+		p.SetAlive(true)
+		logger.Health.Info("Backend revived on trial",
+			zap.String("backend", p.URL.String()),
+			zap.Int32("attempt", attempt))
+		return true
+	}
+
+	resp, err := probeBackend(p, config.ProbePath, 5*time.Second)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode < http.StatusInternalServerError {
+			atomic.StoreInt32(&p.ErrorCount, 0)
+			p.SetAlive(true)
+			logger.Health.Info("Backend revived", zap.String("backend", p.URL.String()))
+			return true
+		}
+		err = fmt.Errorf("probe answered %d", resp.StatusCode)
+	}
+
+	logger.Health.Debug("Backend revival probe failed",
+		zap.String("backend", p.URL.String()),
+		zap.Int32("attempt", attempt),
+		zap.Error(err))
+	return false
+}
@@ -0,0 +1,338 @@
+package balancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// ExplainRequest describes a request to explain the routing of
+type ExplainRequest struct {
+	Method string `json:"method"`
+	// Path is the path of the request, with its query if any
+	Path    string            `json:"path"`
+	Host    string            `json:"host"`
+	Headers map[string]string `json:"headers"`
+	// ClientIP is the address the request comes from, which is a trusted
+	// proxy if the headers carry the client's
+	ClientIP string `json:"clientIp"`
+	// Listener is the listener block the request arrives on, empty for the
+	// main listener
+	Listener string `json:"listener"`
+}
+
+// ExplainedRoute is the route rule a request matched
+type ExplainedRoute struct {
+	// Index is the position of the route in the configuration
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Line  int    `json:"line,omitempty"`
+}
+
+// RouteExplanation tells where a request would go and why
+type RouteExplanation struct {
+	// Route is the route the request matches, or nil for the default pool
+	Route       *ExplainedRoute `json:"route"`
+	Pool        string          `json:"pool"`
+	Method      string          `json:"method,omitempty"`
+	Persistence string          `json:"persistence,omitempty"`
+	// Backend is the backend that would serve the request, without
+	// credentials, or empty if none would
+	Backend string `json:"backend,omitempty"`
+	// Steps are the decisions taken on the way, in order
+	Steps []string `json:"steps"`
+}
+
+// newExplainRequest builds the request an ExplainRequest describes
+func newExplainRequest(er ExplainRequest) (*http.Request, error) {
+	if er.Method == "" {
+		er.Method = http.MethodGet
+	}
+	if er.Path == "" {
+		er.Path = "/"
+	}
+	if !strings.HasPrefix(er.Path, "/") {
+		return nil, fmt.Errorf("path must start with /: %s", er.Path)
+	}
+
+	r, err := http.NewRequest(er.Method, er.Path, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.RequestURI = er.Path
+	r.Host = er.Host
+	for name, value := range er.Headers {
+		r.Header.Set(name, value)
+	}
+	if er.Host == "" {
+		r.Host = r.Header.Get("Host")
+	}
+
+	if er.ClientIP == "" {
+		er.ClientIP = "127.0.0.1"
+	}
+	ip := net.ParseIP(er.ClientIP)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid client IP: %s", er.ClientIP)
+	}
+	r.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+	if er.Listener != "" {
+		r = WithListener(r, er.Listener)
+	}
+	return r, nil
+}
+
+// ExplainRoute tells which route, pool and backend a strategy would send a
+// request to, and why, without sending it. Balancing state is left as it
+// is: round robin schedules do not move on and no session is pinned.
+func ExplainRoute(lb LoadBalancerStrategy, r *http.Request) RouteExplanation {
+	explanation := RouteExplanation{Steps: []string{}}
+	step := func(format string, args ...interface{}) {
+		explanation.Steps = append(explanation.Steps, fmt.Sprintf(format, args...))
+	}
+	forced := ""
+
+	for lb != nil {
+		switch strategy := lb.(type) {
+		case *RoutingOverride:
+			if backend := r.Header.Get(strategy.config.Header); backend != "" {
+				if strategy.config.authorized(r) {
+					forced = backend
+					step("routing override %s: %s forces backend %q", strategy.config.Header, getClientIP(r), backend)
+				} else {
+					step("routing override %s ignored: client %s is not authorized", strategy.config.Header, getClientIP(r))
+				}
+			}
+			lb = strategy.next
+
+		case *ListenerRouter:
+			if name := listenerName(r); name != "" {
+				if _, ok := strategy.listeners[name]; !ok {
+					step("there is no listener %s, so the routes of the main listener apply", name)
+				} else {
+					step("the request arrives on listener %s, so its routes apply", name)
+				}
+			}
+			lb = strategy.router(r)
+
+		case *PathRouter:
+			i := strategy.matchRoute(r)
+			if i < 0 {
+				explanation.Pool = strategy.defaultPoolID
+				step("no route matches, so the default pool %s takes the request", strategy.defaultPoolID)
+				lb = strategy.defaultPool
+				continue
+			}
+			route := strategy.routes[i]
+			explanation.Route = &ExplainedRoute{Index: i, Name: routeName(route), Type: routeTypeNames[route.Type], Line: route.Line}
+			if route.Static.Root != "" {
+				step("%s route %q is the first route to match, serving files from %s", routeTypeNames[route.Type], routeName(route), route.Static.Root)
+				return explanation
+			}
+			explanation.Pool = route.BackendPool
+			step("%s route %q is the first route to match, sending the request to pool %s", routeTypeNames[route.Type], routeName(route), route.BackendPool)
+			lb = strategy.Route(r)
+
+		case *CanarySplitter:
+			if strategy.inCanary(r) {
+				explanation.Pool = strategy.config.Pool
+				step("canary: the user %s falls into the %g%% sent to pool %s", strategy.config.Key, strategy.config.Percent, strategy.config.Pool)
+				lb = strategy.canary
+			} else {
+				step("canary: the user %s is outside the %g%% sent to pool %s", strategy.config.Key, strategy.config.Percent, strategy.config.Pool)
+				lb = strategy.next
+			}
+
+		case *TrafficSplitter:
+			bucket, sticky := strategy.bucket(r)
+			i := strategy.pick(bucket)
+			explanation.Pool = strategy.config.Pools[i]
+			switch {
+			case sticky:
+				step("split: the %s cookie holds bucket %d, of pool %s", strategy.config.Cookie, bucket, explanation.Pool)
+			case strategy.config.Key != "" && userKey(r, strategy.config.Key) != "":
+				step("split: the user %s hashes into bucket %d, of pool %s", strategy.config.Key, bucket, explanation.Pool)
+			default:
+				step("split: random bucket %d, of pool %s; other requests may go to another pool", bucket, explanation.Pool)
+			}
+			lb = strategy.pools[i]
+
+		case *BlueGreenSwitch:
+			active := atomic.LoadInt32(&strategy.active)
+			explanation.Pool = strategy.names[active]
+			step("blue/green: pool %s is active", explanation.Pool)
+			lb = strategy.pools[active]
+
+		case *FailoverChain:
+			member := strategy.current()
+			if member == nil {
+				step("failover: every pool of the chain is down")
+				return explanation
+			}
+			explanation.Pool = member.name
+			step("failover: pool %s is the first usable pool of the chain", member.name)
+			lb = member.lb
+
+		case *PoolStrategy:
+			pool := strategy.pool
+			explanation.Method = pool.Method()
+			if persistence := pool.Persistence(); persistence != NoPersistence {
+				explanation.Persistence = getPersistenceMethodName(persistence)
+			}
+
+			var p *Process
+			if forced != "" {
+				if p = overrideBackend(pool.Backends(), forced); p == nil {
+					step("the pool has no backend %q, so the request is rejected", forced)
+					return explanation
+				}
+				step("the routing override picks the backend")
+			} else {
+				var reason string
+				p, reason = previewPick(pool, r)
+				step("%s", reason)
+			}
+			if p != nil {
+				explanation.Backend = p.URL.Redacted()
+			}
+			return explanation
+
+		default:
+			wrapper, ok := lb.(strategyWrapper)
+			if !ok {
+				step("%T cannot explain its decision", lb)
+				return explanation
+			}
+			lb = wrapper.Unwrap()
+		}
+	}
+	return explanation
+}
+
+// previewPick returns the backend a pool would pick for a request and why,
+// leaving the pool's balancing state as it is
+func previewPick(pool Pool, r *http.Request) (*Process, string) {
+	var p *Process
+	var reason string
+
+	switch pool := pool.(type) {
+	case *GeoBalancer:
+		location := geoLocate(pool.GeoIP, r)
+		p = pool.peek(func() *Process { return pool.nextLocked(r, location) })
+		if location == (GeoLocation{}) {
+			reason = "geo weighted: the client is not located, so the next backend of the weighted round robin schedule"
+		} else {
+			reason = fmt.Sprintf("geo weighted: the next backend of the client's region %s/%s, or of the whole pool if none is available", location.Country, location.Continent)
+		}
+	case *WeightedRoundRobinBalancer:
+		p = pool.peek(func() *Process { return pool.nextLocked(r) })
+		reason = "weighted round robin: the next backend of the schedule"
+	case *LeastConnectionsBalancer:
+		p = pool.Pick(r)
+		reason = "least connections: the available backend with the fewest active connections"
+	case *SessionPersistenceBalancer:
+		return previewSession(pool, r)
+	default:
+		return nil, fmt.Sprintf("%s: the balancing method cannot pick without taking a turn, so no backend is shown", pool.Method())
+	}
+
+	if p == nil {
+		return nil, reason + "; no backend is available"
+	}
+	return p, reason
+}
+
+// previewSession returns the backend a session's requests go to and why,
+// or the one a new session would get. Session rebalancing and migrations
+// are not taken into account.
+func previewSession(lb *SessionPersistenceBalancer, r *http.Request) (*Process, string) {
+	var pinned *Process
+	var found string
+
+	switch lb.PersistenceMethod {
+	case CookiePersistence:
+		pinned = lb.pinnedBackend(r)
+		if pinned != nil && lb.CookieSessions.evicted(clientFingerprint(r), pinned) {
+			return previewNewSession(lb, r, fmt.Sprintf("the session of cookie %s was evicted from %s", lb.CookieName, pinned.URL.Redacted()))
+		}
+		found = "the session cookie " + lb.CookieName
+	case IPHashPersistence:
+		ip := getClientIP(r)
+		if ip != "" {
+			pinned = lb.IPSessions.lookup(ip)
+		}
+		found = "the session of client IP " + ip
+	case ConsistentHashPersistence:
+		if r.URL.Path != "" {
+			if p := lb.ConsistentHashRing.GetNodeWithin(r.URL.Path, nil, lb.MaxHops); p != nil {
+				return p, fmt.Sprintf("consistent hash: path %s hashes to the backend", r.URL.Path)
+			}
+		}
+		return previewNewSession(lb, r, "no backend of the hash ring is available")
+	case FingerprintPersistence:
+		if p := lb.ConsistentHashRing.GetNode(clientFingerprint(r)); p != nil {
+			return p, "fingerprint: the client IP and fingerprint hash to the backend"
+		}
+		return previewNewSession(lb, r, "no backend of the hash ring is available")
+	case UploadSessionPersistence:
+		if lb.Uploads != nil {
+			if id := lb.Uploads.sessionID(r); id != "" {
+				if p := lb.Uploads.lookup(id); p != nil && p.IsAlive() {
+					return p, fmt.Sprintf("upload session %s is pinned to the backend", id)
+				}
+				return previewNewSession(lb, r, fmt.Sprintf("upload session %s has no live backend", id))
+			}
+		}
+		return previewNewSession(lb, r, "no upload session")
+	default:
+		if lb.Provider == nil {
+			return previewPick(lb.BaseLB, r)
+		}
+		pinned = lb.Provider.Select(r, lb.Backends())
+		found = getPersistenceMethodName(lb.PersistenceMethod) + " persistence"
+	}
+
+	switch {
+	case pinned == nil:
+		return previewNewSession(lb, r, "no session")
+	case !pinned.Available():
+		return previewNewSession(lb, r, fmt.Sprintf("%s pins unavailable backend %s", found, pinned.URL.Redacted()))
+	}
+	return pinned, found + " pins the backend"
+}
+
+// previewNewSession returns the backend a new session would get and why
+func previewNewSession(lb *SessionPersistenceBalancer, r *http.Request, why string) (*Process, string) {
+	p, reason := previewPick(lb.BaseLB, r)
+	return p, why + ", so a new session is balanced by " + reason
+}
+
+// RouteExplainHandler explains the routing of the request described in the
+// JSON body of a POST, without sending it
+func RouteExplainHandler(lb LoadBalancerStrategy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var er ExplainRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&er); err != nil {
+			http.Error(w, "invalid request description: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		req, err := newExplainRequest(er)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(ExplainRoute(lb, req))
+	}
+}
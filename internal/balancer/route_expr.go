@@ -0,0 +1,634 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// routeExpr is the compiled expression of an expr route. Expressions are a
+// small, CEL-like language over the attributes of a request:
+//
+//	request.path.startsWith("/api") && request.header["X-Region"] == "eu"
+//
+// They are type checked when compiled, so evaluating one cannot fail.
+type routeExpr struct {
+	root exprNode
+}
+
+// exprType is the type of the value of an expression
+type exprType int
+
+const (
+	exprString exprType = iota
+	exprNumber
+	exprBool
+	exprList
+)
+
+var exprTypeNames = map[exprType]string{
+	exprString: "string",
+	exprNumber: "number",
+	exprBool:   "bool",
+	exprList:   "list",
+}
+
+func (t exprType) String() string {
+	return exprTypeNames[t]
+}
+
+// exprNode is a node of a compiled expression. eval returns a string,
+// float64, bool or []interface{} according to the node's type.
+type exprNode interface {
+	typ() exprType
+	eval(r *http.Request) interface{}
+}
+
+// cutQuotedExpr returns the quoted expression of an expr route directive
+// and the fields following it
+func cutQuotedExpr(line string) (string, []string, error) {
+	// Skip the directive and route type
+	rest := line
+	for i := 0; i < 2; i++ {
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+		rest = strings.TrimLeftFunc(rest, func(c rune) bool { return !unicode.IsSpace(c) })
+	}
+	rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+
+	if rest == "" || (rest[0] != '\'' && rest[0] != '"') {
+		return "", nil, fmt.Errorf("route expression must be quoted")
+	}
+	end := strings.IndexByte(rest[1:], rest[0])
+	if end < 0 {
+		return "", nil, fmt.Errorf("unterminated route expression")
+	}
+	return rest[1 : end+1], strings.Fields(rest[end+2:]), nil
+}
+
+// compileRouteExpr parses and type checks the expression of an expr route
+func compileRouteExpr(source string) (*routeExpr, error) {
+	tokens, err := tokenizeExpr(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.pos)
+	}
+	if root.typ() != exprBool {
+		return nil, fmt.Errorf("route expression must be a bool, got a %s", root.typ())
+	}
+	return &routeExpr{root: root}, nil
+}
+
+// match reports whether a request matches the expression
+func (e *routeExpr) match(r *http.Request) bool {
+	return e.root.eval(r).(bool)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOp
+)
+
+type exprToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// exprOperators are the operators and punctuation, longest first
+var exprOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "[", "]", ".", ","}
+
+func tokenizeExpr(source string) ([]exprToken, error) {
+	var tokens []exprToken
+
+	for i := 0; i < len(source); {
+		c := rune(source[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(source) && source[end] != byte(c) {
+				if source[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(source) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			literal := source[i : end+1]
+			if c == '\'' {
+				literal = `"` + strings.ReplaceAll(literal[1:len(literal)-1], `"`, `\"`) + `"`
+			}
+			value, err := strconv.Unquote(literal)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at offset %d", i)
+			}
+			tokens = append(tokens, exprToken{kind: tokenString, text: value, pos: i})
+			i = end + 1
+		case unicode.IsDigit(c):
+			end := i
+			for end < len(source) && (unicode.IsDigit(rune(source[end])) || source[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, exprToken{kind: tokenNumber, text: source[i:end], pos: i})
+			i = end
+		case unicode.IsLetter(c) || c == '_':
+			end := i
+			for end < len(source) && (unicode.IsLetter(rune(source[end])) || unicode.IsDigit(rune(source[end])) || source[end] == '_') {
+				end++
+			}
+			tokens = append(tokens, exprToken{kind: tokenIdent, text: source[i:end], pos: i})
+			i = end
+		default:
+			matched := false
+			for _, op := range exprOperators {
+				if strings.HasPrefix(source[i:], op) {
+					tokens = append(tokens, exprToken{kind: tokenOp, text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected %q at offset %d", c, i)
+			}
+		}
+	}
+
+	return append(tokens, exprToken{kind: tokenEOF, text: "end of expression", pos: len(source)}), nil
+}
+
+type exprParser struct {
+	tokens []exprToken
+	next   int
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.next]
+}
+
+func (p *exprParser) advance() exprToken {
+	tok := p.tokens[p.next]
+	if tok.kind != tokenEOF {
+		p.next++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is the given operator or keyword
+func (p *exprParser) accept(text string) bool {
+	if tok := p.peek(); (tok.kind == tokenOp || tok.kind == tokenIdent) && tok.text == text {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(text string) error {
+	if !p.accept(text) {
+		tok := p.peek()
+		return fmt.Errorf("expected %q at offset %d, got %q", text, tok.pos, tok.text)
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = newLogical("||", left, right); err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = newLogical("&&", left, right); err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">", "in"} {
+		if !p.accept(op) {
+			continue
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return newComparison(op, left, right)
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if operand.typ() != exprBool {
+			return nil, fmt.Errorf("! expects a bool, got a %s", operand.typ())
+		}
+		return notNode{operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+// parsePostfix parses a primary expression followed by method calls
+func (p *exprParser) parsePostfix() (exprNode, error) {
+	node, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept(".") {
+		tok := p.advance()
+		if tok.kind != tokenIdent {
+			return nil, fmt.Errorf("expected a method name at offset %d, got %q", tok.pos, tok.text)
+		}
+		args, err := p.parseArgs()
+		if err != nil {
+			return nil, err
+		}
+		if node, err = newMethodCall(node, tok.text, args); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+func (p *exprParser) parseArgs() ([]exprNode, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []exprNode
+	for !p.accept(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.advance()
+	switch tok.kind {
+	case tokenString:
+		return literalNode{value: tok.text, t: exprString}, nil
+	case tokenNumber:
+		number, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at offset %d", tok.text, tok.pos)
+		}
+		return literalNode{value: number, t: exprNumber}, nil
+	case tokenOp:
+		switch tok.text {
+		case "(":
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return node, p.expect(")")
+		case "[":
+			return p.parseList()
+		}
+	case tokenIdent:
+		switch tok.text {
+		case "true", "false":
+			return literalNode{value: tok.text == "true", t: exprBool}, nil
+		case "request":
+			return p.parseAttribute()
+		case "int":
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			if len(args) != 1 || args[0].typ() != exprString {
+				return nil, fmt.Errorf("int expects one string")
+			}
+			return intNode{args[0]}, nil
+		}
+		return nil, fmt.Errorf("unknown identifier %q at offset %d", tok.text, tok.pos)
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.pos)
+}
+
+// parseList parses the elements of a list literal, which share a type
+func (p *exprParser) parseList() (exprNode, error) {
+	var elements []exprNode
+	for !p.accept("]") {
+		if len(elements) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		element, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if len(elements) > 0 && element.typ() != elements[0].typ() {
+			return nil, fmt.Errorf("list mixes %s and %s elements", elements[0].typ(), element.typ())
+		}
+		elements = append(elements, element)
+	}
+	return listNode{elements}, nil
+}
+
+// requestAttributes are the attributes of a request, by name
+var requestAttributes = map[string]func(r *http.Request) string{
+	"path":   func(r *http.Request) string { return r.URL.Path },
+	"method": func(r *http.Request) string { return r.Method },
+	"host":   func(r *http.Request) string { return r.Host },
+	"scheme": func(r *http.Request) string {
+		if r.TLS != nil {
+			return "https"
+		}
+		return "http"
+	},
+	"client_ip": getClientIP,
+}
+
+// requestMaps are the attributes of a request indexed by a name
+var requestMaps = map[string]func(r *http.Request, key string) string{
+	"header": func(r *http.Request, key string) string { return r.Header.Get(key) },
+	"query":  func(r *http.Request, key string) string { return r.URL.Query().Get(key) },
+	"cookie": func(r *http.Request, key string) string {
+		if cookie, err := r.Cookie(key); err == nil {
+			return cookie.Value
+		}
+		return ""
+	},
+}
+
+func (p *exprParser) parseAttribute() (exprNode, error) {
+	if err := p.expect("."); err != nil {
+		return nil, err
+	}
+	tok := p.advance()
+
+	if get, ok := requestAttributes[tok.text]; ok && tok.kind == tokenIdent {
+		return attributeNode{get: func(r *http.Request) string { return get(r) }}, nil
+	}
+	if get, ok := requestMaps[tok.text]; ok && tok.kind == tokenIdent {
+		if err := p.expect("["); err != nil {
+			return nil, err
+		}
+		key := p.advance()
+		if key.kind != tokenString {
+			return nil, fmt.Errorf("request.%s must be indexed with a string at offset %d", tok.text, key.pos)
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		return attributeNode{get: func(r *http.Request) string { return get(r, key.text) }}, nil
+	}
+	return nil, fmt.Errorf("unknown request attribute %q at offset %d", tok.text, tok.pos)
+}
+
+type literalNode struct {
+	value interface{}
+	t     exprType
+}
+
+func (n literalNode) typ() exprType                  { return n.t }
+func (n literalNode) eval(*http.Request) interface{} { return n.value }
+
+type listNode struct {
+	elements []exprNode
+}
+
+func (n listNode) typ() exprType { return exprList }
+func (n listNode) eval(r *http.Request) interface{} {
+	values := make([]interface{}, len(n.elements))
+	for i, element := range n.elements {
+		values[i] = element.eval(r)
+	}
+	return values
+}
+
+type attributeNode struct {
+	get func(r *http.Request) string
+}
+
+func (n attributeNode) typ() exprType                    { return exprString }
+func (n attributeNode) eval(r *http.Request) interface{} { return n.get(r) }
+
+type notNode struct {
+	operand exprNode
+}
+
+func (n notNode) typ() exprType                    { return exprBool }
+func (n notNode) eval(r *http.Request) interface{} { return !n.operand.eval(r).(bool) }
+
+// intNode converts a string to a number, or 0 if it is not one
+type intNode struct {
+	operand exprNode
+}
+
+func (n intNode) typ() exprType { return exprNumber }
+func (n intNode) eval(r *http.Request) interface{} {
+	number, _ := strconv.ParseFloat(strings.TrimSpace(n.operand.eval(r).(string)), 64)
+	return number
+}
+
+type logicalNode struct {
+	and         bool
+	left, right exprNode
+}
+
+func newLogical(op string, left, right exprNode) (exprNode, error) {
+	if left.typ() != exprBool || right.typ() != exprBool {
+		return nil, fmt.Errorf("%s expects bools, got a %s and a %s", op, left.typ(), right.typ())
+	}
+	return logicalNode{and: op == "&&", left: left, right: right}, nil
+}
+
+func (n logicalNode) typ() exprType { return exprBool }
+func (n logicalNode) eval(r *http.Request) interface{} {
+	if n.left.eval(r).(bool) != n.and {
+		return !n.and
+	}
+	return n.right.eval(r).(bool)
+}
+
+type comparisonNode struct {
+	op          string
+	left, right exprNode
+}
+
+func newComparison(op string, left, right exprNode) (exprNode, error) {
+	switch {
+	case op == "in":
+		list, ok := right.(listNode)
+		if !ok {
+			return nil, fmt.Errorf("in expects a list")
+		}
+		if len(list.elements) > 0 && list.elements[0].typ() != left.typ() {
+			return nil, fmt.Errorf("in compares a %s with a list of %s", left.typ(), list.elements[0].typ())
+		}
+	case left.typ() != right.typ():
+		return nil, fmt.Errorf("%s compares a %s with a %s", op, left.typ(), right.typ())
+	case left.typ() == exprList:
+		return nil, fmt.Errorf("%s cannot compare lists", op)
+	case left.typ() == exprBool && op != "==" && op != "!=":
+		return nil, fmt.Errorf("%s cannot compare bools", op)
+	}
+	return comparisonNode{op: op, left: left, right: right}, nil
+}
+
+func (n comparisonNode) typ() exprType { return exprBool }
+func (n comparisonNode) eval(r *http.Request) interface{} {
+	left, right := n.left.eval(r), n.right.eval(r)
+
+	switch n.op {
+	case "in":
+		for _, element := range right.([]interface{}) {
+			if element == left {
+				return true
+			}
+		}
+		return false
+	case "==":
+		return left == right
+	case "!=":
+		return left != right
+	}
+
+	var order int
+	switch typed := left.(type) {
+	case string:
+		order = strings.Compare(typed, right.(string))
+	case float64:
+		switch {
+		case typed < right.(float64):
+			order = -1
+		case typed > right.(float64):
+			order = 1
+		}
+	}
+	switch n.op {
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	}
+	return order >= 0
+}
+
+// methodNode calls a string method
+type methodNode struct {
+	t    exprType
+	call func(receiver string, r *http.Request) interface{}
+	recv exprNode
+}
+
+func newMethodCall(receiver exprNode, name string, args []exprNode) (exprNode, error) {
+	if receiver.typ() != exprString {
+		return nil, fmt.Errorf("%s is not a method of %s", name, receiver.typ())
+	}
+
+	switch name {
+	case "startsWith", "endsWith", "contains":
+		if len(args) != 1 || args[0].typ() != exprString {
+			return nil, fmt.Errorf("%s expects one string", name)
+		}
+		test := map[string]func(s, substr string) bool{
+			"startsWith": strings.HasPrefix,
+			"endsWith":   strings.HasSuffix,
+			"contains":   strings.Contains,
+		}[name]
+		arg := args[0]
+		return methodNode{t: exprBool, recv: receiver, call: func(s string, r *http.Request) interface{} {
+			return test(s, arg.eval(r).(string))
+		}}, nil
+	case "matches":
+		var literal literalNode
+		if len(args) == 1 {
+			literal, _ = args[0].(literalNode)
+		}
+		if literal.t != exprString || literal.value == nil {
+			return nil, fmt.Errorf("matches expects one string literal")
+		}
+		re, err := regexp.Compile(literal.value.(string))
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %v", literal.value, err)
+		}
+		return methodNode{t: exprBool, recv: receiver, call: func(s string, _ *http.Request) interface{} {
+			return re.MatchString(s)
+		}}, nil
+	case "lower", "upper":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("%s expects no arguments", name)
+		}
+		convert := strings.ToLower
+		if name == "upper" {
+			convert = strings.ToUpper
+		}
+		return methodNode{t: exprString, recv: receiver, call: func(s string, _ *http.Request) interface{} {
+			return convert(s)
+		}}, nil
+	case "size":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("size expects no arguments")
+		}
+		return methodNode{t: exprNumber, recv: receiver, call: func(s string, _ *http.Request) interface{} {
+			return float64(len(s))
+		}}, nil
+	}
+	return nil, fmt.Errorf("unknown method %s", name)
+}
+
+func (n methodNode) typ() exprType { return n.t }
+func (n methodNode) eval(r *http.Request) interface{} {
+	return n.call(n.recv.eval(r).(string), r)
+}
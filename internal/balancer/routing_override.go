@@ -0,0 +1,171 @@
+package balancer
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// RoutingOverrideConfig holds the settings of the header operators send to
+// force a request to a backend, such as to reproduce a bug only one
+// backend has
+type RoutingOverrideConfig struct {
+	// Header names the backend: its position in the pool serving the
+	// request, as in the ids of /api/backends, or its URL or host:port
+	Header string
+	// Token is the secret operators send in TokenHeader to use the
+	// override, and Allow the client networks that may use it without one
+	Token       string
+	TokenHeader string
+	Allow       []*net.IPNet
+}
+
+// parseRoutingOverride parses the arguments of a routing_override directive
+func parseRoutingOverride(parts []string) (RoutingOverrideConfig, error) {
+	config := RoutingOverrideConfig{Header: "X-GOLB-Backend", TokenHeader: "X-GOLB-Token"}
+
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(strings.TrimSuffix(part, ";"), "=")
+		switch key {
+		case "header":
+			config.Header = http.CanonicalHeaderKey(value)
+		case "token":
+			config.Token = value
+		case "token_header":
+			config.TokenHeader = http.CanonicalHeaderKey(value)
+		case "allow":
+			networks, err := parseTrustedProxies(append([]string{key}, strings.Split(value, ",")...))
+			if err != nil {
+				return RoutingOverrideConfig{}, fmt.Errorf("invalid routing_override allow: %s", value)
+			}
+			config.Allow = append(config.Allow, networks...)
+		default:
+			return RoutingOverrideConfig{}, fmt.Errorf("unknown routing_override option: %s", part)
+		}
+	}
+
+	if config.Header == "" || config.TokenHeader == "" {
+		return RoutingOverrideConfig{}, fmt.Errorf("routing_override header names must not be empty")
+	}
+	// Anyone could pick the backend of their requests otherwise
+	if config.Token == "" && len(config.Allow) == 0 {
+		return RoutingOverrideConfig{}, fmt.Errorf("routing_override requires a token or allowed networks")
+	}
+	return config, nil
+}
+
+// authorized reports whether a request may override its routing, by its
+// token or by the network of its client
+func (c RoutingOverrideConfig) authorized(r *http.Request) bool {
+	if c.Token != "" {
+		token := r.Header.Get(c.TokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(c.Token)) == 1 {
+			return true
+		}
+	}
+	if ip := net.ParseIP(getClientIP(r)); ip != nil {
+		for _, network := range c.Allow {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type routingOverrideContextKey struct{}
+
+// RoutingOverride lets operators force a request to a backend with a
+// header. The header of clients that are neither allowed nor send the
+// token is ignored. The headers never reach the backend.
+type RoutingOverride struct {
+	next   LoadBalancerStrategy
+	config RoutingOverrideConfig
+}
+
+// NewRoutingOverride wraps a strategy with the routing override header.
+// The strategy is returned unchanged if the override is not configured.
+func NewRoutingOverride(next LoadBalancerStrategy, config RoutingOverrideConfig) LoadBalancerStrategy {
+	if config.Header == "" {
+		return next
+	}
+	return &RoutingOverride{next: next, config: config}
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (o *RoutingOverride) GetNextInstance(r *http.Request) (*url.URL, error) {
+	return o.next.GetNextInstance(r)
+}
+
+// ProxyRequest proxies the request, to the backend named in the override
+// header if the client may override its routing
+func (o *RoutingOverride) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	backend := r.Header.Get(o.config.Header)
+	token := r.Header.Get(o.config.TokenHeader)
+	if backend == "" && token == "" {
+		o.next.ProxyRequest(w, r)
+		return
+	}
+
+	authorized := backend != "" && o.config.authorized(r)
+	r = r.Clone(r.Context())
+	r.Header.Del(o.config.Header)
+	r.Header.Del(o.config.TokenHeader)
+	if !authorized {
+		if backend != "" {
+			logger.Proxy.Debug("Routing override ignored for unauthorized client",
+				zap.String("client", getClientIP(r)),
+				zap.String("backend", backend))
+		}
+		o.next.ProxyRequest(w, r)
+		return
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), routingOverrideContextKey{}, backend))
+	o.next.ProxyRequest(w, r)
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (o *RoutingOverride) SupportsWebSockets() bool {
+	return o.next.SupportsWebSockets()
+}
+
+// Unwrap returns the wrapped strategy
+func (o *RoutingOverride) Unwrap() LoadBalancerStrategy {
+	return o.next
+}
+
+// routingOverride returns the backend an operator forced a request to, or
+// "" if the request is balanced as usual
+func routingOverride(r *http.Request) string {
+	backend, _ := r.Context().Value(routingOverrideContextKey{}).(string)
+	return backend
+}
+
+// overrideBackend returns the backend of a pool named by a routing
+// override: its position in the pool, its URL or its host:port
+func overrideBackend(backends []*Process, name string) *Process {
+	if i, err := strconv.Atoi(name); err == nil {
+		if i >= 0 && i < len(backends) {
+			return backends[i]
+		}
+		return nil
+	}
+	name = strings.TrimSuffix(name, "/")
+	for _, p := range backends {
+		if name == strings.TrimSuffix(p.URL.String(), "/") ||
+			name == strings.TrimSuffix(p.URL.Redacted(), "/") ||
+			strings.EqualFold(name, p.URL.Host) {
+			return p
+		}
+	}
+	return nil
+}
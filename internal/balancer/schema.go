@@ -0,0 +1,433 @@
+package balancer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxValidatedBodySize is the largest body the schema validator reads
+const maxValidatedBodySize = 1 << 20
+
+// JSONSchema is the subset of JSON Schema supported by the validator: type,
+// enum, const, properties, required, additionalProperties, items, minItems,
+// maxItems, minLength, maxLength, pattern, minimum and maximum
+type JSONSchema struct {
+	Type                 interface{}            `json:"type"`
+	Enum                 []interface{}          `json:"enum"`
+	Const                interface{}            `json:"const"`
+	Properties           map[string]*JSONSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties"`
+	Items                *JSONSchema            `json:"items"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Pattern              string                 `json:"pattern"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+
+	pattern        *regexp.Regexp
+	additionalSpec *JSONSchema
+	noAdditional   bool
+}
+
+// LoadJSONSchema reads and compiles a JSON Schema file
+func LoadJSONSchema(filename string) (*JSONSchema, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var schema JSONSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("invalid schema %s: %v", filename, err)
+	}
+	if err := schema.compile(); err != nil {
+		return nil, fmt.Errorf("invalid schema %s: %v", filename, err)
+	}
+
+	return &schema, nil
+}
+
+func (s *JSONSchema) compile() error {
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return err
+		}
+		s.pattern = re
+	}
+
+	if len(s.AdditionalProperties) > 0 {
+		var allowed bool
+		if err := json.Unmarshal(s.AdditionalProperties, &allowed); err == nil {
+			s.noAdditional = !allowed
+		} else {
+			s.additionalSpec = &JSONSchema{}
+			if err := json.Unmarshal(s.AdditionalProperties, s.additionalSpec); err != nil {
+				return err
+			}
+		}
+	}
+
+	children := make([]*JSONSchema, 0, len(s.Properties)+2)
+	for _, property := range s.Properties {
+		children = append(children, property)
+	}
+	children = append(children, s.Items, s.additionalSpec)
+	for _, child := range children {
+		if child == nil {
+			continue
+		}
+		if err := child.compile(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Validate returns the violations of a decoded JSON value, each prefixed with
+// the path of the offending value
+func (s *JSONSchema) Validate(value interface{}) []string {
+	var violations []string
+	s.validate(value, "$", &violations)
+	return violations
+}
+
+func (s *JSONSchema) validate(value interface{}, path string, violations *[]string) {
+	fail := func(format string, args ...interface{}) {
+		*violations = append(*violations, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if s.Type != nil && !s.matchesType(value) {
+		fail("expected %s, got %s", strings.Join(s.typeNames(), " or "), jsonTypeName(value))
+		return
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			if jsonEqual(value, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("value is not one of the allowed values")
+		}
+	}
+	if s.Const != nil && !jsonEqual(value, s.Const) {
+		fail("value does not match the expected constant")
+	}
+
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := typed[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+
+		names := make([]string, 0, len(typed))
+		for name := range typed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			childPath := path + "." + name
+			if property, ok := s.Properties[name]; ok {
+				property.validate(typed[name], childPath, violations)
+			} else if s.additionalSpec != nil {
+				s.additionalSpec.validate(typed[name], childPath, violations)
+			} else if s.noAdditional {
+				fail("unexpected property %q", name)
+			}
+		}
+
+	case []interface{}:
+		if s.MinItems != nil && len(typed) < *s.MinItems {
+			fail("expected at least %d items, got %d", *s.MinItems, len(typed))
+		}
+		if s.MaxItems != nil && len(typed) > *s.MaxItems {
+			fail("expected at most %d items, got %d", *s.MaxItems, len(typed))
+		}
+		if s.Items != nil {
+			for i, item := range typed {
+				s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i), violations)
+			}
+		}
+
+	case string:
+		length := len([]rune(typed))
+		if s.MinLength != nil && length < *s.MinLength {
+			fail("expected at least %d characters, got %d", *s.MinLength, length)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			fail("expected at most %d characters, got %d", *s.MaxLength, length)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(typed) {
+			fail("value does not match pattern %q", s.Pattern)
+		}
+
+	case float64:
+		if s.Minimum != nil && typed < *s.Minimum {
+			fail("value %v is less than the minimum %v", typed, *s.Minimum)
+		}
+		if s.Maximum != nil && typed > *s.Maximum {
+			fail("value %v is greater than the maximum %v", typed, *s.Maximum)
+		}
+	}
+}
+
+func (s *JSONSchema) typeNames() []string {
+	switch typed := s.Type.(type) {
+	case string:
+		return []string{typed}
+	case []interface{}:
+		names := make([]string, 0, len(typed))
+		for _, name := range typed {
+			if str, ok := name.(string); ok {
+				names = append(names, str)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+func (s *JSONSchema) matchesType(value interface{}) bool {
+	actual := jsonTypeName(value)
+	for _, name := range s.typeNames() {
+		if name == actual {
+			return true
+		}
+		if name == "integer" && actual == "number" && value.(float64) == math.Trunc(value.(float64)) {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+func jsonEqual(a, b interface{}) bool {
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
+
+// SchemaViolationStats counts the payloads of a route rejected by its schemas
+type SchemaViolationStats struct {
+	Request  int64 `json:"request"`
+	Response int64 `json:"response"`
+}
+
+var (
+	schemaViolations   = make(map[string]*SchemaViolationStats)
+	schemaViolationsMu sync.Mutex
+)
+
+func countSchemaViolation(route string, response bool) {
+	schemaViolationsMu.Lock()
+	defer schemaViolationsMu.Unlock()
+
+	stats, ok := schemaViolations[route]
+	if !ok {
+		stats = &SchemaViolationStats{}
+		schemaViolations[route] = stats
+	}
+	if response {
+		stats.Response++
+	} else {
+		stats.Request++
+	}
+}
+
+// GetSchemaViolations returns the number of schema violations per route
+func GetSchemaViolations() map[string]SchemaViolationStats {
+	schemaViolationsMu.Lock()
+	defer schemaViolationsMu.Unlock()
+
+	violations := make(map[string]SchemaViolationStats, len(schemaViolations))
+	for route, stats := range schemaViolations {
+		violations[route] = *stats
+	}
+	return violations
+}
+
+// SchemaValidator validates the JSON request and response bodies of a route
+type SchemaValidator struct {
+	next     LoadBalancerStrategy
+	route    string
+	request  *JSONSchema
+	response *JSONSchema
+}
+
+// NewSchemaValidator wraps a strategy with schema validation.
+// The strategy is returned unchanged if there is no schema.
+func NewSchemaValidator(next LoadBalancerStrategy, route string, request, response *JSONSchema) LoadBalancerStrategy {
+	if request == nil && response == nil {
+		return next
+	}
+	return &SchemaValidator{
+		next:     next,
+		route:    route,
+		request:  request,
+		response: response,
+	}
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (sv *SchemaValidator) GetNextInstance(r *http.Request) (*url.URL, error) {
+	return sv.next.GetNextInstance(r)
+}
+
+// ProxyRequest validates the request body, proxies the request and validates
+// the response body before it is sent to the client
+func (sv *SchemaValidator) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	if IsWebSocketRequest(r) {
+		sv.next.ProxyRequest(w, r)
+		return
+	}
+
+	if sv.request != nil && r.Body != nil && r.Body != http.NoBody {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxValidatedBodySize+1))
+		r.Body.Close()
+		if err != nil {
+			rejectRequest(w, RejectSchemaViolation, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxValidatedBodySize {
+			rejectRequest(w, RejectBodyTooLarge, "Request body exceeds the validation size limit", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if violations := validatePayload(sv.request, body, r.Header.Get("Content-Type")); len(violations) > 0 {
+			countSchemaViolation(sv.route, false)
+			rejectSchemaViolation(w, RejectSchemaViolation, "Request body does not match the schema", http.StatusBadRequest, violations)
+			return
+		}
+
+		r = r.Clone(r.Context())
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	if sv.response == nil {
+		sv.next.ProxyRequest(w, r)
+		return
+	}
+
+	buffered := &bufferedResponseWriter{header: make(http.Header), status: http.StatusOK}
+	sv.next.ProxyRequest(buffered, r)
+
+	if buffered.status >= 200 && buffered.status < 300 {
+		if violations := validatePayload(sv.response, buffered.body.Bytes(), buffered.header.Get("Content-Type")); len(violations) > 0 {
+			countSchemaViolation(sv.route, true)
+			rejectSchemaViolation(w, RejectInvalidResponse, "Response body does not match the schema", http.StatusBadGateway, violations)
+			return
+		}
+	}
+
+	for name, values := range buffered.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(buffered.status)
+	w.Write(buffered.body.Bytes())
+}
+
+// validatePayload validates a JSON body against a schema
+func validatePayload(schema *JSONSchema, body []byte, contentType string) []string {
+	if len(body) > maxValidatedBodySize {
+		return []string{"$: body exceeds the validation size limit"}
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil ||
+		(mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		return []string{fmt.Sprintf("$: expected a JSON body, got content type %q", contentType)}
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return []string{"$: invalid JSON: " + err.Error()}
+	}
+
+	return schema.Validate(value)
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (sv *SchemaValidator) SupportsWebSockets() bool {
+	return sv.next.SupportsWebSockets()
+}
+
+// Unwrap returns the wrapped strategy
+func (sv *SchemaValidator) Unwrap() LoadBalancerStrategy {
+	return sv.next
+}
+
+// rejectSchemaViolation rejects a request with a JSON body listing the violations
+func rejectSchemaViolation(w http.ResponseWriter, reason RejectReason, message string, status int, violations []string) {
+	countRejection(reason)
+
+	w.Header().Set(RejectReasonHeader, string(reason))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":      message,
+		"violations": violations,
+	})
+}
+
+// bufferedResponseWriter holds a response in memory until it is validated
+type bufferedResponseWriter struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (b *bufferedResponseWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponseWriter) WriteHeader(statusCode int) {
+	if !b.wroteHeader {
+		b.wroteHeader = true
+		b.status = statusCode
+	}
+}
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
@@ -0,0 +1,94 @@
+package balancer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxServerExpansion bounds the servers a single templated server directive
+// expands into, catching typos such as {8000-80000}
+const maxServerExpansion = 1024
+
+// expandServerURL expands the templates of a server URL into the URLs of
+// the servers it stands for. A template is a range of numbers, as in
+// http://10.0.0.5:{8001-8008}, or a list, as in http://{web1,web2}:80.
+// Several templates expand into every combination, and a range whose start
+// has leading zeros keeps its width, so {01-10} gives 01 to 10.
+func expandServerURL(rawURL string) ([]string, error) {
+	start := strings.Index(rawURL, "{")
+	if start < 0 {
+		if strings.Contains(rawURL, "}") {
+			return nil, fmt.Errorf("unmatched } in server URL: %s", rawURL)
+		}
+		return []string{rawURL}, nil
+	}
+
+	length := strings.Index(rawURL[start:], "}")
+	if length < 0 {
+		return nil, fmt.Errorf("unmatched { in server URL: %s", rawURL)
+	}
+	end := start + length
+
+	values, err := expandTemplate(rawURL[start+1 : end])
+	if err != nil {
+		return nil, fmt.Errorf("invalid template in server URL %s: %v", rawURL, err)
+	}
+
+	rests, err := expandServerURL(rawURL[end+1:])
+	if err != nil {
+		return nil, err
+	}
+	if len(values)*len(rests) > maxServerExpansion {
+		return nil, fmt.Errorf("server URL %s expands into more than %d servers", rawURL, maxServerExpansion)
+	}
+
+	urls := make([]string, 0, len(values)*len(rests))
+	for _, value := range values {
+		for _, rest := range rests {
+			urls = append(urls, rawURL[:start]+value+rest)
+		}
+	}
+	return urls, nil
+}
+
+// expandTemplate expands the inside of a template: a list of values
+// separated by commas or a range of numbers
+func expandTemplate(template string) ([]string, error) {
+	if strings.Contains(template, ",") {
+		values := strings.Split(template, ",")
+		for _, value := range values {
+			if value == "" {
+				return nil, fmt.Errorf("empty value in {%s}", template)
+			}
+		}
+		return values, nil
+	}
+
+	from, to, found := strings.Cut(template, "-")
+	if !found {
+		return nil, fmt.Errorf("{%s} is neither a list nor a range", template)
+	}
+	first, err := strconv.Atoi(from)
+	if err != nil || first < 0 {
+		return nil, fmt.Errorf("invalid range start: %s", from)
+	}
+	last, err := strconv.Atoi(to)
+	if err != nil || last < first {
+		return nil, fmt.Errorf("invalid range end: %s", to)
+	}
+	if last-first >= maxServerExpansion {
+		return nil, fmt.Errorf("range {%s} has more than %d values", template, maxServerExpansion)
+	}
+
+	width := 0
+	if len(from) > 1 && from[0] == '0' {
+		width = len(from)
+	}
+
+	values := make([]string, 0, last-first+1)
+	for n := first; n <= last; n++ {
+		values = append(values, fmt.Sprintf("%0*d", width, n))
+	}
+	return values, nil
+}
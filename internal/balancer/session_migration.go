@@ -0,0 +1,155 @@
+package balancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// sessionMigration spreads the sessions of a backend over the backends they
+// were migrated to
+type sessionMigration struct {
+	targets []*Process
+	next    uint32
+}
+
+// pick returns the next available target, or nil if none is available
+func (m *sessionMigration) pick() *Process {
+	for i := 0; i < len(m.targets); i++ {
+		target := m.targets[int(atomic.AddUint32(&m.next, 1)-1)%len(m.targets)]
+		if target.Available() {
+			return target
+		}
+	}
+	return nil
+}
+
+// migrationTarget returns the backend a session pinned to a migrated backend
+// moves to, or nil if the backend was not migrated
+func (lb *SessionPersistenceBalancer) migrationTarget(from *Process) *Process {
+	if from == nil {
+		return nil
+	}
+	migration, ok := lb.migrations.Load(from)
+	if !ok {
+		return nil
+	}
+	return migration.(*sessionMigration).pick()
+}
+
+// migrateSessions moves the sessions pinned to a backend to the targets. The
+// IP hash and upload session tables are rewritten at once; cookies, which
+// the balancer does not keep, are re-pinned on the next request carrying
+// them. It returns how many table entries moved.
+func (lb *SessionPersistenceBalancer) migrateSessions(from *Process, targets []*Process) (int, int) {
+	migration := &sessionMigration{targets: targets}
+	lb.migrations.Store(from, migration)
+
+	ipSessions := lb.IPSessions.migrate(from, migration.pick)
+
+	uploadSessions := 0
+	if lb.Uploads != nil {
+		uploadSessions = lb.Uploads.migrate(from, migration.pick)
+	}
+
+	return ipSessions, uploadSessions
+}
+
+// SessionMigrationResult reports the sessions moved off a backend
+type SessionMigrationResult struct {
+	Backend        string   `json:"backend"`
+	Targets        []string `json:"targets"`
+	IPSessions     int      `json:"ipSessions"`
+	UploadSessions int      `json:"uploadSessions"`
+}
+
+// MigrateSessions drains a backend and moves its sessions to other backends
+// of its pool: the given ones, or every other backend if none is given
+func MigrateSessions(lb LoadBalancerStrategy, backend string, to []string) (SessionMigrationResult, bool) {
+	result := SessionMigrationResult{Backend: backend, Targets: []string{}}
+	wanted := make(map[string]bool, len(to))
+	for _, target := range to {
+		wanted[target] = true
+	}
+
+	found := false
+	seenTarget := make(map[string]bool)
+
+	walkBalancers(lb, func(balancer interface{}) {
+		var from *Process
+		var targets []*Process
+		for _, p := range balancerProcesses(balancer) {
+			switch {
+			case p.URL.String() == backend:
+				from = p
+			case !p.IsDeregistered() && (len(wanted) == 0 || wanted[p.URL.String()]):
+				targets = append(targets, p)
+			}
+		}
+		if from == nil {
+			return
+		}
+		found = true
+		if len(targets) == 0 {
+			return
+		}
+
+		// New sessions stay off the backend as well
+		from.SetDraining(true)
+
+		for _, target := range targets {
+			if !seenTarget[target.URL.String()] {
+				seenTarget[target.URL.String()] = true
+				result.Targets = append(result.Targets, target.URL.String())
+			}
+		}
+		if spb, ok := balancer.(*SessionPersistenceBalancer); ok {
+			ipSessions, uploadSessions := spb.migrateSessions(from, targets)
+			result.IPSessions += ipSessions
+			result.UploadSessions += uploadSessions
+		}
+	})
+
+	return result, found
+}
+
+// SessionMigrationHandler bulk-migrates the sessions of a backend ahead of
+// maintenance: POST backend=<URL>[&to=<URL>,<URL>]
+func SessionMigrationHandler(lb LoadBalancerStrategy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		backend := r.FormValue("backend")
+		var to []string
+		if targets := r.FormValue("to"); targets != "" {
+			to = strings.Split(targets, ",")
+		}
+
+		result, found := MigrateSessions(lb, backend, to)
+		if !found {
+			apiError(w, fmt.Errorf("%w: %s", ErrBackendNotFound, backend))
+			return
+		}
+		if len(result.Targets) == 0 {
+			http.Error(w, "No target backends in the backend's pool", http.StatusBadRequest)
+			return
+		}
+
+		logger.Log.Info("Sessions migrated through the admin API",
+			zap.String("backend", backend),
+			zap.Strings("targets", result.Targets),
+			zap.Int("ipSessions", result.IPSessions),
+			zap.Int("uploadSessions", result.UploadSessions))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
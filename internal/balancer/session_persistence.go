@@ -1,19 +1,18 @@
 package balancer
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
 	"hash/crc32"
-	"net"
+	"math"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/The-iyed/go-load-balancer/internal/logger"
@@ -22,156 +21,348 @@ import (
 
 type SessionPersistenceBalancer struct {
 	ProcessPack        []*Process
-	BaseLB             interface{}
+	BaseLB             Pool
 	PersistenceMethod  PersistenceMethod
 	ConsistentHashRing *ConsistentHashRing
 	CookieName         string
 	CookieTTL          time.Duration
-	IPToBackendMap     sync.Map
-	BackendToIndexMap  map[string]int
+	CookieDomain       string
+	CookiePath         string
+	CookieSameSite     http.SameSite
+	// CookieSecure is "on", "off", or "auto" to mark the cookie Secure on
+	// connections over TLS only
+	CookieSecure string
+	// CookieSessions and IPSessions hold the clients of cookie and IP hash
+	// sessions and the backends they are pinned to
+	CookieSessions    *sessionTable
+	IPSessions        *sessionTable
+	BackendToIndexMap map[string]int
+	Provider          PersistenceProvider
+	MaxHops           int
+	Uploads           *uploadSessions
+	// Rebalance moves cookie and IP hash sessions after the weights of the
+	// backends change, or is nil to keep them pinned
+	Rebalance *sessionRebalance
+	// migrations holds the backends the sessions of a backend were migrated to
+	migrations sync.Map
+	// mu guards ProcessPack and BackendToIndexMap, which UpdateBackends
+	// rebuilds when the backends of the base pool are swapped
+	mu sync.RWMutex
 }
 
 func NewSessionPersistenceBalancer(configs []BackendConfig, algorithm LoadBalancerAlgorithm, persistenceMethod PersistenceMethod) *SessionPersistenceBalancer {
-	var baseLB interface{}
-
+	var base Pool
 	switch algorithm {
 	case LeastConnections:
-		baseLB = NewLeastConnectionsBalancer(configs)
-	case WeightedRoundRobin, RoundRobin:
-		baseLB = NewLoadBalancer(configs)
+		base = NewLeastConnectionsBalancer(configs)
 	default:
-		baseLB = NewLoadBalancer(configs)
-	}
-
-	var processes []*Process
-	backendToIndexMap := make(map[string]int)
-
-	for _, config := range configs {
-		parsed, err := url.Parse(config.URL)
-		if err != nil {
-			logger.Log.Warn("Invalid backend URL", zap.String("url", config.URL), zap.Error(err))
-			continue
-		}
-
-		weight := config.Weight
-		if weight <= 0 {
-			weight = 1
-		}
-
-		process := &Process{
-			URL:        parsed,
-			Alive:      true,
-			ErrorCount: 0,
-			Weight:     weight,
-		}
-
-		processes = append(processes, process)
-		backendToIndexMap[parsed.String()] = len(processes) - 1
+		base = NewLoadBalancer(configs)
 	}
+	return newSessionPersistenceBalancer(base, persistenceMethod)
+}
 
-	consistentHashRing := NewConsistentHashRing(configs)
+// newSessionPersistenceBalancer pins sessions to the backends of a pool,
+// which picks the backends of new sessions. The backends are shared with
+// the pool so health and connection counts are tracked in one place.
+func newSessionPersistenceBalancer(base Pool, persistenceMethod PersistenceMethod) *SessionPersistenceBalancer {
+	processes := base.Backends()
 
 	return &SessionPersistenceBalancer{
 		ProcessPack:        processes,
-		BaseLB:             baseLB,
+		BaseLB:             base,
 		PersistenceMethod:  persistenceMethod,
-		ConsistentHashRing: consistentHashRing,
-		CookieName:         "GOLB_SESSION",
-		CookieTTL:          24 * time.Hour,
-		BackendToIndexMap:  backendToIndexMap,
+		ConsistentHashRing: newConsistentHashRing(processes),
+		CookieName:         defaultSessionCookie.Name,
+		CookieTTL:          defaultSessionCookie.TTL,
+		CookiePath:         defaultSessionCookie.Path,
+		CookieSecure:       defaultSessionCookie.Secure,
+		CookieSessions:     newSessionTable(defaultSessionCookie.TTL, defaultMaxSessions),
+		IPSessions:         newSessionTable(defaultIPSessionTTL, defaultMaxSessions),
+		BackendToIndexMap:  backendIndexes(processes),
 	}
 }
 
+// backendIndexes maps the URLs of backends to their index in the pool, which
+// session cookies carry
+func backendIndexes(processes []*Process) map[string]int {
+	indexes := make(map[string]int, len(processes))
+	for i, process := range processes {
+		indexes[process.URL.String()] = i
+	}
+	return indexes
+}
+
 func (lb *SessionPersistenceBalancer) GetNextInstance(r *http.Request) (*url.URL, error) {
-	var process *Process
+	if err := r.Context().Err(); err != nil {
+		return nil, err
+	}
+
+	process := lb.nextProcess(r)
+	if process == nil {
+		return nil, ErrNoHealthyBackend
+	}
 
+	return process.URL, nil
+}
+
+func (lb *SessionPersistenceBalancer) nextProcess(r *http.Request) *Process {
 	switch lb.PersistenceMethod {
 	case CookiePersistence:
-		process = lb.getInstanceByCookie(r)
+		return lb.getInstanceByCookie(r)
 	case IPHashPersistence:
-		process = lb.getInstanceByIPHash(r)
+		return lb.getInstanceByIPHash(r)
 	case ConsistentHashPersistence:
-		process = lb.getInstanceByConsistentHash(r)
+		return lb.getInstanceByConsistentHash(r)
+	case FingerprintPersistence:
+		return lb.getInstanceByFingerprint(r)
+	case UploadSessionPersistence:
+		return lb.getInstanceByUploadSession(r)
 	default:
-		if adapter, ok := lb.BaseLB.(*LegacyLoadBalancerAdapter); ok {
-			return adapter.GetNextInstance(r)
+		if lb.Provider != nil {
+			return lb.getInstanceByProvider(r)
 		}
+		return lb.baseInstance(r)
+	}
+}
+
+// baseInstance gets the next instance from the underlying implementation
+func (lb *SessionPersistenceBalancer) baseInstance(r *http.Request) *Process {
+	return lb.BaseLB.Pick(r)
+}
 
-		// Get from the underlying implementation directly
-		switch base := lb.BaseLB.(type) {
-		case *WeightedRoundRobinBalancer:
-			process = base.GetNextInstance(r)
-		case *LeastConnectionsBalancer:
-			process = base.GetNextInstance(r)
+func (lb *SessionPersistenceBalancer) getInstanceByCookie(r *http.Request) *Process {
+	backend := lb.pinnedBackend(r)
+	// Sessions evicted through the admin API are balanced afresh
+	if backend != nil && lb.CookieSessions.evicted(clientFingerprint(r), backend) {
+		return lb.baseInstance(r)
+	}
+	if backend != nil && backend.Available() {
+		if target := lb.rebalanceTarget(r, backend, clientFingerprint(r)); target != nil {
+			return target
 		}
+		return backend
 	}
 
-	if process == nil {
-		return nil, fmt.Errorf("no available backends")
+	// Sessions migrated off their backend are re-pinned to the chosen ones
+	if target := lb.migrationTarget(backend); target != nil {
+		return target
 	}
 
-	return process.URL, nil
+	return lb.fallbackInstance(r, backend)
 }
 
-func (lb *SessionPersistenceBalancer) getInstanceByCookie(r *http.Request) *Process {
+// fallbackInstance picks a backend for a request whose session backend is
+// unavailable, away from that backend's zone with anti-affinity
+func (lb *SessionPersistenceBalancer) fallbackInstance(r *http.Request, unavailable *Process) *Process {
+	if unavailable != nil && lb.Settings().AntiAffinity && !unavailable.IsAlive() {
+		r = withTriedBackend(r, unavailable)
+	}
+	return lb.baseInstance(r)
+}
+
+// pinnedBackend returns the backend the request's session cookie points to,
+// or nil if there is no valid cookie
+func (lb *SessionPersistenceBalancer) pinnedBackend(r *http.Request) *Process {
 	cookie, err := r.Cookie(lb.CookieName)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
 
-	if err == nil && cookie.Value != "" {
-		parts := strings.Split(cookie.Value, ":")
-		if len(parts) == 2 {
-			index, err := strconv.Atoi(parts[0])
-			if err == nil && index >= 0 && index < len(lb.ProcessPack) {
-				backend := lb.ProcessPack[index]
-				if backend.IsAlive() {
-					return backend
-				}
-			}
+	parts := strings.SplitN(cookie.Value, ":", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+
+	index, err := strconv.Atoi(parts[0])
+	if err != nil || index < 0 {
+		return nil
+	}
+
+	processes := lb.Backends()
+	if index < len(processes) && sessionCookieValue(index, processes[index].URL) == cookie.Value {
+		return processes[index]
+	}
+
+	// The backend moved to another index when the pool was updated
+	for _, backend := range processes {
+		if backendHash(backend.URL) == parts[1] {
+			return backend
 		}
 	}
+	return nil
+}
+
+// sessionCookieValue returns the cookie value pinning a session to a backend
+func sessionCookieValue(index int, backend *url.URL) string {
+	return fmt.Sprintf("%d:%s", index, backendHash(backend))
+}
 
-	// Get from the underlying implementation
-	var process *Process
-	switch base := lb.BaseLB.(type) {
-	case *WeightedRoundRobinBalancer:
-		process = base.GetNextInstance(r)
-	case *LeastConnectionsBalancer:
-		process = base.GetNextInstance(r)
+// backendHash identifies a backend in session cookies without revealing its URL
+func backendHash(backend *url.URL) string {
+	hash := md5.Sum([]byte(backend.String()))
+	return hex.EncodeToString(hash[:])
+}
+
+// issueSessionCookie pins the session to the backend serving the request.
+// When a session moves off its pinned backend, because that backend failed or
+// is draining, the new cookie replaces the stale one so later requests go
+// straight to the new backend, and the move is counted.
+func (lb *SessionPersistenceBalancer) issueSessionCookie(w http.ResponseWriter, r *http.Request, process *Process) {
+	lb.mu.RLock()
+	index, ok := lb.BackendToIndexMap[process.URL.String()]
+	lb.mu.RUnlock()
+	if !ok {
+		return
 	}
-	return process
+	lb.CookieSessions.pin(clientFingerprint(r), process)
+
+	if pinned := lb.pinnedBackend(r); pinned != nil && pinned != process {
+		countSessionRepin(pinned)
+		logger.Proxy.Debug("Session moved to another backend",
+			zap.String("from", pinned.URL.String()),
+			zap.String("to", process.URL.String()))
+	}
+
+	// A retry after a failed backend replaces the cookie set for that backend
+	header := w.Header()
+	cookies := header["Set-Cookie"][:0]
+	for _, line := range header["Set-Cookie"] {
+		if !strings.HasPrefix(line, lb.CookieName+"=") {
+			cookies = append(cookies, line)
+		}
+	}
+	header["Set-Cookie"] = cookies
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     lb.CookieName,
+		Value:    sessionCookieValue(index, process.URL),
+		Path:     lb.CookiePath,
+		Domain:   lb.CookieDomain,
+		HttpOnly: true,
+		Secure:   lb.CookieSecure == "on" || (lb.CookieSecure != "off" && r.TLS != nil),
+		SameSite: lb.CookieSameSite,
+		MaxAge:   int(lb.CookieTTL.Seconds()),
+	})
+}
+
+// SessionCookieConfig holds the attributes of the cookie pinning sessions
+type SessionCookieConfig struct {
+	Name     string
+	TTL      time.Duration
+	Domain   string
+	Path     string
+	SameSite http.SameSite
+	// Secure is "on", "off", or "auto" to mark the cookie Secure on
+	// connections over TLS only
+	Secure string
+}
+
+// defaultSessionCookie is used for the attributes cookie persistence is not
+// configured with
+var defaultSessionCookie = SessionCookieConfig{
+	Name:   "GOLB_SESSION",
+	TTL:    24 * time.Hour,
+	Path:   "/",
+	Secure: "auto",
+}
+
+// parseSessionCookie reads the session cookie attributes of the persistence
+// directive's cookie_ attributes
+func parseSessionCookie(attrs map[string]string) (SessionCookieConfig, error) {
+	config := defaultSessionCookie
+
+	if name, ok := attrs["cookie_name"]; ok {
+		config.Name = name
+	}
+	if ttlStr, ok := attrs["cookie_ttl"]; ok {
+		ttl, err := time.ParseDuration(ttlStr)
+		if err != nil || ttl < time.Second {
+			return SessionCookieConfig{}, fmt.Errorf("invalid cookie ttl, expected a duration of at least 1s: %s", ttlStr)
+		}
+		config.TTL = ttl
+	}
+	if domain, ok := attrs["cookie_domain"]; ok {
+		config.Domain = domain
+	}
+	if path, ok := attrs["cookie_path"]; ok {
+		if !strings.HasPrefix(path, "/") {
+			return SessionCookieConfig{}, fmt.Errorf("invalid cookie path, expected a path starting with /: %s", path)
+		}
+		config.Path = path
+	}
+	if sameSite, ok := attrs["cookie_samesite"]; ok {
+		switch strings.ToLower(sameSite) {
+		case "strict":
+			config.SameSite = http.SameSiteStrictMode
+		case "lax":
+			config.SameSite = http.SameSiteLaxMode
+		case "none":
+			config.SameSite = http.SameSiteNoneMode
+		default:
+			return SessionCookieConfig{}, fmt.Errorf("invalid cookie samesite, expected strict, lax or none: %s", sameSite)
+		}
+	}
+	if secure, ok := attrs["cookie_secure"]; ok {
+		switch secure {
+		case "on", "off", "auto":
+			config.Secure = secure
+		default:
+			return SessionCookieConfig{}, fmt.Errorf("invalid cookie secure, expected on, off or auto: %s", secure)
+		}
+	}
+
+	// Browsers drop SameSite=None cookies that are not Secure
+	if config.SameSite == http.SameSiteNoneMode && config.Secure != "on" {
+		return SessionCookieConfig{}, fmt.Errorf("cookie samesite=none requires secure=on")
+	}
+	cookie := &http.Cookie{Name: config.Name, Value: "0", Domain: config.Domain, Path: config.Path}
+	if err := cookie.Valid(); err != nil {
+		return SessionCookieConfig{}, fmt.Errorf("invalid session cookie: %w", err)
+	}
+	return config, nil
+}
+
+var (
+	sessionRepins   = make(map[string]int64)
+	sessionRepinsMu sync.Mutex
+)
+
+func countSessionRepin(from *Process) {
+	sessionRepinsMu.Lock()
+	sessionRepins[from.URL.String()]++
+	sessionRepinsMu.Unlock()
+}
+
+// GetSessionRepins returns the number of cookie sessions moved off each backend
+func GetSessionRepins() map[string]int64 {
+	sessionRepinsMu.Lock()
+	defer sessionRepinsMu.Unlock()
+
+	repins := make(map[string]int64, len(sessionRepins))
+	for backend, count := range sessionRepins {
+		repins[backend] = count
+	}
+	return repins
 }
 
 func (lb *SessionPersistenceBalancer) getInstanceByIPHash(r *http.Request) *Process {
 	ip := getClientIP(r)
 	if ip == "" {
-		// Get from the underlying implementation
-		var process *Process
-		switch base := lb.BaseLB.(type) {
-		case *WeightedRoundRobinBalancer:
-			process = base.GetNextInstance(r)
-		case *LeastConnectionsBalancer:
-			process = base.GetNextInstance(r)
-		}
-		return process
+		return lb.baseInstance(r)
 	}
 
-	if target, ok := lb.IPToBackendMap.Load(ip); ok {
-		index := target.(int)
-		if index >= 0 && index < len(lb.ProcessPack) && lb.ProcessPack[index].IsAlive() {
-			return lb.ProcessPack[index]
+	pinned := lb.IPSessions.lookup(ip)
+	if pinned != nil && pinned.Available() {
+		if target := lb.rebalanceTarget(r, pinned, ip); target != nil {
+			pinned = target
 		}
+		lb.IPSessions.pin(ip, pinned)
+		return pinned
 	}
 
-	// Get from the underlying implementation
-	var target *Process
-	switch base := lb.BaseLB.(type) {
-	case *WeightedRoundRobinBalancer:
-		target = base.GetNextInstance(r)
-	case *LeastConnectionsBalancer:
-		target = base.GetNextInstance(r)
-	}
-
+	target := lb.fallbackInstance(r, pinned)
 	if target != nil {
-		lb.IPToBackendMap.Store(ip, lb.BackendToIndexMap[target.URL.String()])
+		lb.IPSessions.pin(ip, target)
 	}
 
 	return target
@@ -181,116 +372,144 @@ func (lb *SessionPersistenceBalancer) getInstanceByConsistentHash(r *http.Reques
 	key := r.URL.Path
 
 	if key == "" {
-		// Get from the underlying implementation
-		var process *Process
-		switch base := lb.BaseLB.(type) {
-		case *WeightedRoundRobinBalancer:
-			process = base.GetNextInstance(r)
-		case *LeastConnectionsBalancer:
-			process = base.GetNextInstance(r)
-		}
-		return process
+		return lb.baseInstance(r)
 	}
 
-	return lb.ConsistentHashRing.GetNode(key)
+	return lb.ConsistentHashRing.getNodeAvoiding(key, triedBackends(r), avoidedZones(r, lb.Settings().AntiAffinity), lb.MaxHops)
 }
 
-func (lb *SessionPersistenceBalancer) ProxyRequest(w http.ResponseWriter, r *http.Request) {
-	target, err := lb.GetNextInstance(r)
-	if err != nil || target == nil {
-		http.Error(w, "No healthy backends available", http.StatusServiceUnavailable)
-		return
+// hopsExhausted reports whether a consistent hash request found no backend
+// only because max_hops cut its walk short
+func (lb *SessionPersistenceBalancer) hopsExhausted(r *http.Request) bool {
+	if lb.PersistenceMethod != ConsistentHashPersistence || lb.MaxHops <= 0 || r.URL.Path == "" {
+		return false
 	}
+	return lb.ConsistentHashRing.GetNodeWithin(r.URL.Path, triedBackends(r), 0) != nil
+}
 
-	var process *Process
-	for _, p := range lb.ProcessPack {
-		if p.URL.String() == target.String() {
-			process = p
-			break
-		}
+func (lb *SessionPersistenceBalancer) getInstanceByFingerprint(r *http.Request) *Process {
+	process := lb.ConsistentHashRing.GetNode(clientFingerprint(r))
+	if process == nil {
+		return lb.baseInstance(r)
 	}
 
-	if process == nil {
-		http.Error(w, "Backend not found", http.StatusInternalServerError)
-		return
+	return process
+}
+
+// getInstanceByUploadSession keeps an upload session on its backend even while
+// the backend drains, since the staged parts of the upload only exist there
+func (lb *SessionPersistenceBalancer) getInstanceByUploadSession(r *http.Request) *Process {
+	if lb.Uploads != nil {
+		if id := lb.Uploads.sessionID(r); id != "" {
+			backend := lb.Uploads.lookup(id)
+			if backend != nil && backend.IsAlive() {
+				return backend
+			}
+			return lb.fallbackInstance(r, backend)
+		}
 	}
 
-	if IsWebSocketRequest(r) && lb.SupportsWebSockets() {
-		wsProxy := NewWebSocketProxy(process, func(p *Process) {
-			go lb.reviveLater(p)
-		})
-		wsProxy.ProxyWebSocket(w, r)
-		return
+	return lb.baseInstance(r)
+}
+
+func (lb *SessionPersistenceBalancer) getInstanceByProvider(r *http.Request) *Process {
+	backend := lb.Provider.Select(r, lb.Backends())
+	if backend != nil && backend.Available() {
+		return backend
 	}
 
+	return lb.fallbackInstance(r, backend)
+}
+
+func (lb *SessionPersistenceBalancer) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	proxyToPool(lb, w, r)
+}
+
+// bindSession pins the session of a request to the backend serving it
+func (lb *SessionPersistenceBalancer) bindSession(w http.ResponseWriter, r *http.Request, process *Process) {
 	if lb.PersistenceMethod == CookiePersistence {
-		index := -1
-		for i, backend := range lb.ProcessPack {
-			if backend.URL.String() == target.String() {
-				index = i
-				break
-			}
-		}
+		lb.issueSessionCookie(w, r, process)
+	}
 
-		if index >= 0 {
-			hash := md5.Sum([]byte(target.String()))
-			cookie := &http.Cookie{
-				Name:     lb.CookieName,
-				Value:    fmt.Sprintf("%d:%s", index, hex.EncodeToString(hash[:])),
-				Path:     "/",
-				HttpOnly: true,
-				Secure:   r.TLS != nil,
-				MaxAge:   int(lb.CookieTTL.Seconds()),
-			}
-			http.SetCookie(w, cookie)
+	if lb.Uploads != nil {
+		if id := lb.Uploads.sessionID(r); id != "" {
+			lb.Uploads.pin(id, process)
 		}
 	}
 
-	proxy := httputil.NewSingleHostReverseProxy(target)
-	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
-		logger.Log.Error("Request failed",
-			zap.String("backend", target.String()),
-			zap.Error(err),
-		)
+	if lb.Provider != nil {
+		lb.Provider.Bind(w, r, process)
+	}
+}
 
-		if process != nil {
-			atomic.AddInt32(&process.ErrorCount, 1)
-			if atomic.LoadInt32(&process.ErrorCount) >= 3 {
-				process.SetAlive(false)
-				logger.Log.Warn("Backend marked dead", zap.String("backend", target.String()))
-				go lb.reviveLater(process)
-			}
-		}
+func (lb *SessionPersistenceBalancer) SupportsWebSockets() bool {
+	return true
+}
 
-		lb.ProxyRequest(w, r)
+// Pick implements the Picker interface. A WebSocket whose session backend
+// is at its WebSocket limit falls back to another backend.
+func (lb *SessionPersistenceBalancer) Pick(r *http.Request) *Process {
+	p := lb.nextProcess(r)
+	if p != nil && !canServe(IsWebSocketRequest(r), p) {
+		return lb.fallbackInstance(r, p)
 	}
+	return p
+}
 
-	proxy.ServeHTTP(w, r)
+func (lb *SessionPersistenceBalancer) Backends() []*Process {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return lb.ProcessPack
 }
 
-func (lb *SessionPersistenceBalancer) reviveLater(p *Process) {
-	time.Sleep(10 * time.Second)
-	p.SetAlive(true)
-	atomic.StoreInt32(&p.ErrorCount, 0)
-	logger.Log.Info("Backend revived", zap.String("backend", p.URL.String()))
+// UpdateBackends swaps in a new generation of backends in the base pool and
+// returns its number. The session cookie indexes and the hash ring are
+// rebuilt over the new generation: sessions pinned to a kept backend stay
+// on it, while those of a removed backend are balanced afresh.
+func (lb *SessionPersistenceBalancer) UpdateBackends(configs []BackendConfig) uint64 {
+	updater, ok := lb.BaseLB.(backendUpdater)
+	if !ok {
+		return 0
+	}
+	generation := updater.UpdateBackends(configs)
+	processes := lb.BaseLB.Backends()
+
+	lb.mu.Lock()
+	lb.ProcessPack = processes
+	lb.BackendToIndexMap = backendIndexes(processes)
+	lb.mu.Unlock()
+
+	lb.ConsistentHashRing.reset(processes)
+	return generation
 }
 
-func (lb *SessionPersistenceBalancer) SupportsWebSockets() bool {
-	return true
+// Method names the balancing method of new sessions
+func (lb *SessionPersistenceBalancer) Method() string {
+	return lb.BaseLB.Method()
+}
+
+func (lb *SessionPersistenceBalancer) Persistence() PersistenceMethod {
+	return lb.PersistenceMethod
+}
+
+// Settings returns the settings of the base pool, which persistence shares
+func (lb *SessionPersistenceBalancer) Settings() *PoolSettings {
+	return lb.BaseLB.Settings()
 }
 
 type ConsistentHashRing struct {
+	mu           sync.RWMutex
 	ring         map[uint32]*Process
 	sortedHashes []uint32
 	replicaCount int
 	processes    []*Process
+	// loadFactor bounds the load of a node to loadFactor times the average
+	// load of the ring, or is 0 for unbounded loads
+	loadFactor float64
 }
 
 func NewConsistentHashRing(configs []BackendConfig) *ConsistentHashRing {
-	ch := &ConsistentHashRing{
-		ring:         make(map[uint32]*Process),
-		replicaCount: 100,
-	}
+	var processes []*Process
 
 	for _, config := range configs {
 		parsed, err := url.Parse(config.URL)
@@ -304,31 +523,148 @@ func NewConsistentHashRing(configs []BackendConfig) *ConsistentHashRing {
 			weight = 1
 		}
 
-		process := &Process{
-			URL:        parsed,
-			Alive:      true,
-			ErrorCount: 0,
-			Weight:     weight,
-		}
+		processes = append(processes, &Process{
+			URL:           parsed,
+			Alive:         true,
+			ErrorCount:    0,
+			Weight:        weight,
+			MaxConns:      int32(config.MaxConns),
+			MaxWebSockets: int32(config.MaxWebSockets),
+			Host:          config.Host,
+			ServerName:    config.ServerName,
+			Prefix:        config.Prefix,
+			Zone:          config.Zone,
+		})
+	}
 
-		ch.processes = append(ch.processes, process)
+	return newConsistentHashRing(processes)
+}
 
-		for i := 0; i < ch.replicaCount*weight; i++ {
-			key := fmt.Sprintf("%s:%d", parsed.String(), i)
-			hash := crc32.ChecksumIEEE([]byte(key))
-			ch.ring[hash] = process
-			ch.sortedHashes = append(ch.sortedHashes, hash)
-		}
+func newConsistentHashRing(processes []*Process) *ConsistentHashRing {
+	ch := &ConsistentHashRing{
+		ring:         make(map[uint32]*Process),
+		replicaCount: 100,
+	}
+
+	for _, process := range processes {
+		ch.addLocked(process)
 	}
+	ch.sortLocked()
+
+	return ch
+}
 
+// addLocked places a node and its replicas on the ring, one replica per
+// unit of weight, without sorting it; the caller holds the lock
+func (ch *ConsistentHashRing) addLocked(p *Process) {
+	ch.processes = append(ch.processes, p)
+	for i := 0; i < ch.replicaCount*p.Weight; i++ {
+		key := fmt.Sprintf("%s:%d", p.URL.String(), i)
+		hash := crc32.ChecksumIEEE([]byte(key))
+		ch.ring[hash] = p
+		ch.sortedHashes = append(ch.sortedHashes, hash)
+	}
+}
+
+// sortLocked sorts the ring after nodes were added; the caller holds the lock
+func (ch *ConsistentHashRing) sortLocked() {
 	sort.Slice(ch.sortedHashes, func(i, j int) bool {
 		return ch.sortedHashes[i] < ch.sortedHashes[j]
 	})
+}
 
-	return ch
+// reset places a new set of nodes on the ring, keeping its load factor
+func (ch *ConsistentHashRing) reset(processes []*Process) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	ch.ring = make(map[uint32]*Process)
+	ch.sortedHashes = nil
+	ch.processes = nil
+	for _, process := range processes {
+		ch.addLocked(process)
+	}
+	ch.sortLocked()
+}
+
+// AddNode places a node on the ring, for backends registered at runtime,
+// and reports whether it was not on the ring yet. Only the keys landing on
+// the new node's replicas move to it.
+func (ch *ConsistentHashRing) AddNode(p *Process) bool {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	for _, process := range ch.processes {
+		if process == p {
+			return false
+		}
+	}
+	ch.addLocked(p)
+	ch.sortLocked()
+	return true
+}
+
+// SetLoadFactor bounds the load of every node, counted in active
+// connections, to factor times the average load of the ring, rounded up.
+// Keys whose node is full spill to the next node on the ring, so a hot key
+// cannot overload one backend. A factor of 0 leaves loads unbounded.
+func (ch *ConsistentHashRing) SetLoadFactor(factor float64) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	ch.loadFactor = factor
+}
+
+// Nodes returns the nodes on the ring
+func (ch *ConsistentHashRing) Nodes() []*Process {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	return append([]*Process(nil), ch.processes...)
+}
+
+// capacityLocked returns the load a node may take on, or 0 if loads are
+// unbounded; the caller holds the lock
+func (ch *ConsistentHashRing) capacityLocked() int32 {
+	if ch.loadFactor <= 0 {
+		return 0
+	}
+
+	var total int32
+	nodes := 0
+	for _, p := range ch.processes {
+		if p.Available() {
+			total += p.GetActiveConnections()
+			nodes++
+		}
+	}
+	if nodes == 0 {
+		return 0
+	}
+	// The request being placed counts towards the load
+	return int32(math.Ceil(ch.loadFactor * float64(total+1) / float64(nodes)))
 }
 
 func (ch *ConsistentHashRing) GetNode(key string) *Process {
+	return ch.GetNodeWithin(key, nil, 0)
+}
+
+// GetNodeWithin walks clockwise from the key's position on the ring and
+// returns the first healthy node that is not excluded. At most maxHops nodes
+// after the key's own node are considered; zero means the whole ring.
+func (ch *ConsistentHashRing) GetNodeWithin(key string, exclude map[*Process]bool, maxHops int) *Process {
+	return ch.getNodeAvoiding(key, exclude, nil, maxHops)
+}
+
+// getNodeAvoiding is GetNodeWithin preferring nodes outside the avoided
+// zones and, unless avoid is nil, outside the zone of the key's own node when
+// it is down, and nodes under their bounded load. Only if there is none
+// within maxHops does it settle for a node in one of the zones or at its
+// bound.
+func (ch *ConsistentHashRing) getNodeAvoiding(key string, exclude map[*Process]bool, avoid map[string]bool, maxHops int) *Process {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
 	if len(ch.ring) == 0 {
 		return nil
 	}
@@ -343,37 +679,88 @@ func (ch *ConsistentHashRing) GetNode(key string) *Process {
 		idx = 0
 	}
 
-	process := ch.ring[ch.sortedHashes[idx]]
+	if maxHops <= 0 || maxHops >= len(ch.processes) {
+		maxHops = len(ch.processes) - 1
+	}
 
-	if !process.IsAlive() {
-		for i := 0; i < len(ch.processes); i++ {
-			nextIdx := (idx + i) % len(ch.sortedHashes)
-			process = ch.ring[ch.sortedHashes[nextIdx]]
-			if process.IsAlive() {
-				return process
-			}
+	// Replicas of the same node sit next to each other on the ring, so only
+	// count a hop when the walk reaches a node that has not been seen yet
+	visited := make(map[*Process]bool)
+	capacity := ch.capacityLocked()
+	var ownerZone string
+	var fallback *Process
+	for i := 0; i < len(ch.sortedHashes); i++ {
+		process := ch.ring[ch.sortedHashes[(idx+i)%len(ch.sortedHashes)]]
+		if visited[process] {
+			continue
+		}
+		if len(visited) > maxHops {
+			break
+		}
+		visited[process] = true
+
+		if avoid != nil && len(visited) == 1 && !process.IsAlive() {
+			ownerZone = process.Zone
+		}
+		if exclude[process] || !process.Available() {
+			continue
+		}
+		full := capacity > 0 && process.GetActiveConnections() >= capacity
+		if !avoid[process.Zone] && (ownerZone == "" || process.Zone != ownerZone) && !full {
+			return process
+		}
+		if fallback == nil {
+			fallback = process
 		}
-		return nil
 	}
 
-	return process
+	return fallback
 }
 
-func getClientIP(r *http.Request) string {
-	xForwardedFor := r.Header.Get("X-Forwarded-For")
-	if xForwardedFor != "" {
-		ips := strings.Split(xForwardedFor, ",")
-		if len(ips) > 0 {
-			return strings.TrimSpace(ips[0])
+// RemoveNode takes a node and its replicas off the ring, so walks no longer
+// spend hops on it, and reports whether it was on the ring. Only the keys of
+// the removed node move.
+func (ch *ConsistentHashRing) RemoveNode(p *Process) bool {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	kept := ch.processes[:0:0]
+	for _, process := range ch.processes {
+		if process != p {
+			kept = append(kept, process)
 		}
 	}
-
-	if r.RemoteAddr != "" {
-		ip, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err == nil {
-			return ip
+	if len(kept) == len(ch.processes) {
+		return false
+	}
+	ch.processes = kept
+
+	hashes := ch.sortedHashes[:0:0]
+	for _, hash := range ch.sortedHashes {
+		if ch.ring[hash] == p {
+			delete(ch.ring, hash)
+		} else {
+			hashes = append(hashes, hash)
 		}
 	}
+	ch.sortedHashes = hashes
+	return true
+}
+
+type triedBackendsKey struct{}
 
-	return ""
+// triedBackends returns the backends that already failed for this request
+func triedBackends(r *http.Request) map[*Process]bool {
+	tried, _ := r.Context().Value(triedBackendsKey{}).(map[*Process]bool)
+	return tried
+}
+
+// withTriedBackend records a failed backend on the request so retries skip it
+func withTriedBackend(r *http.Request, p *Process) *http.Request {
+	tried := make(map[*Process]bool)
+	for process := range triedBackends(r) {
+		tried[process] = true
+	}
+	tried[p] = true
+	return r.WithContext(context.WithValue(r.Context(), triedBackendsKey{}, tried))
 }
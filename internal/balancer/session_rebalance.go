@@ -0,0 +1,222 @@
+package balancer
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// sessionRebalanceCheck is how often the weights of a pool's backends are
+// compared with the ones its sessions were spread by
+const sessionRebalanceCheck = time.Second
+
+// sessionRebalanceClock holds the clock rebalances are timed by, if not the
+// wall clock
+var sessionRebalanceClock atomic.Pointer[func() time.Time]
+
+// SetSessionRebalanceClock replaces the clock session rebalances are timed
+// by, so tests can step through a rebalance; nil restores the wall clock
+func SetSessionRebalanceClock(now func() time.Time) {
+	if now == nil {
+		sessionRebalanceClock.Store(nil)
+		return
+	}
+	sessionRebalanceClock.Store(&now)
+}
+
+// rebalanceNow returns the time on the clock rebalances are timed by
+func rebalanceNow() time.Time {
+	if now := sessionRebalanceClock.Load(); now != nil {
+		return (*now)()
+	}
+	return time.Now()
+}
+
+// parseSessionRebalance parses the rebalance=<N>% persistence option, the
+// percentage of the sessions to move that are moved per minute
+func parseSessionRebalance(value string) (float64, error) {
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil || !strings.HasSuffix(value, "%") || percent <= 0 || math.IsInf(percent, 0) {
+		return 0, fmt.Errorf("invalid rebalance rate, expected a percentage of sessions per minute: %s", value)
+	}
+	return percent / 100, nil
+}
+
+// sessionRebalance moves sessions off backends whose share of the pool
+// shrank, because they were reweighted or deregistered, to the backends
+// whose share grew. Without it sessions stay pinned where they started, and
+// backends added by a scale-out only get new sessions. Sessions are moved
+// gradually, so the backends taking them on are not flooded.
+type sessionRebalance struct {
+	// rate is the fraction of the sessions to move that is moved per minute
+	rate    float64
+	checked int64
+
+	mu     sync.Mutex
+	layout string
+	shares map[*Process]float64
+	moves  atomic.Pointer[sessionMoves]
+}
+
+// sessionMoves is a rebalance in progress
+type sessionMoves struct {
+	// seed is the layout the sessions are moved towards, so each rebalance
+	// picks its own sessions
+	seed    string
+	started time.Time
+	// leave holds the fraction of the sessions of each backend whose share
+	// shrank that moves off it
+	leave map[*Process]float64
+	// targets are the backends whose share grew, picked in proportion to
+	// the cumulative growth in gains
+	targets []*Process
+	gains   []float64
+}
+
+func newSessionRebalance(rate float64, processes []*Process) *sessionRebalance {
+	rb := &sessionRebalance{rate: rate, checked: rebalanceNow().UnixNano()}
+	rb.shares, rb.layout = backendShares(processes, processWeights(processes))
+	return rb
+}
+
+// backendShares returns the share of sessions each backend should hold,
+// given their weights, and a fingerprint of them
+func backendShares(processes []*Process, weights map[*Process]int) (map[*Process]float64, string) {
+	effective := make(map[*Process]int, len(processes))
+	var layout strings.Builder
+	total := 0
+	for _, p := range processes {
+		weight := 0
+		if !p.IsDeregistered() {
+			weight = max(weights[p], 1)
+		}
+		effective[p] = weight
+		total += weight
+		fmt.Fprintf(&layout, "%s=%d;", p.URL, weight)
+	}
+
+	shares := make(map[*Process]float64, len(processes))
+	for p, weight := range effective {
+		if total > 0 {
+			shares[p] = float64(weight) / float64(total)
+		}
+	}
+	return shares, layout.String()
+}
+
+// check starts a rebalance if the shares of the backends changed since they
+// were last checked. The weights are only read once a check is due.
+func (rb *sessionRebalance) check(processes []*Process, weights func() map[*Process]int) {
+	now := rebalanceNow()
+	last := atomic.LoadInt64(&rb.checked)
+	if now.UnixNano()-last < int64(sessionRebalanceCheck) || !atomic.CompareAndSwapInt64(&rb.checked, last, now.UnixNano()) {
+		return
+	}
+
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	shares, layout := backendShares(processes, weights())
+	if layout == rb.layout {
+		return
+	}
+	previous := rb.shares
+	rb.shares, rb.layout = shares, layout
+
+	moves := &sessionMoves{seed: layout, started: now, leave: make(map[*Process]float64)}
+	total := 0.0
+	for p, share := range previous {
+		if shares[p] < share {
+			moves.leave[p] = 1 - shares[p]/share
+		}
+	}
+	for _, p := range processes {
+		if gain := shares[p] - previous[p]; gain > 0 {
+			total += gain
+			moves.targets = append(moves.targets, p)
+			moves.gains = append(moves.gains, total)
+		}
+	}
+	if len(moves.leave) == 0 || len(moves.targets) == 0 {
+		rb.moves.Store(nil)
+		return
+	}
+	rb.moves.Store(moves)
+
+	logger.Log.Info("Rebalancing sessions after a backend weight change",
+		zap.Int("shrunk", len(moves.leave)),
+		zap.Int("grown", len(moves.targets)),
+		zap.Duration("duration", time.Duration(float64(time.Minute)/rb.rate)))
+}
+
+// target returns the backend a session pinned to a backend moves to, or nil
+// if it stays. A session's key decides whether and where it moves, so it
+// moves once and stays moved.
+func (rb *sessionRebalance) target(r *http.Request, processes []*Process, weights func() map[*Process]int, pinned *Process, key string) *Process {
+	rb.check(processes, weights)
+	moves := rb.moves.Load()
+	if moves == nil {
+		return nil
+	}
+
+	progress := rb.rate * rebalanceNow().Sub(moves.started).Minutes()
+	if progress >= 1 {
+		// Sessions not seen during the rebalance keep their backend
+		rb.moves.CompareAndSwap(moves, nil)
+		progress = 1
+	}
+
+	leave, ok := moves.leave[pinned]
+	if !ok {
+		return nil
+	}
+	hash := md5.Sum([]byte(moves.seed + key))
+	if unitFloat(hash[:8]) >= leave*progress {
+		return nil
+	}
+
+	pick := unitFloat(hash[8:]) * moves.gains[len(moves.gains)-1]
+	for i, gain := range moves.gains {
+		if pick < gain {
+			target := moves.targets[i]
+			if !target.Available() || triedBackends(r)[target] {
+				return nil
+			}
+			return target
+		}
+	}
+	return nil
+}
+
+// unitFloat maps 8 hash bytes to [0, 1)
+func unitFloat(b []byte) float64 {
+	return float64(binary.BigEndian.Uint64(b)>>11) / (1 << 53)
+}
+
+// rebalanceTarget returns the backend a session pinned to an available
+// backend moves to, or nil if it stays or rebalancing is off
+func (lb *SessionPersistenceBalancer) rebalanceTarget(r *http.Request, pinned *Process, key string) *Process {
+	if lb.Rebalance == nil || key == "" {
+		return nil
+	}
+	return lb.Rebalance.target(r, lb.Backends(), lb.backendWeights, pinned, key)
+}
+
+// backendWeights returns the weights of the backends, read under the lock
+// of the pool they are reweighted in
+func (lb *SessionPersistenceBalancer) backendWeights() map[*Process]int {
+	if reader, ok := lb.BaseLB.(weightReader); ok {
+		return reader.Weights()
+	}
+	return processWeights(lb.Backends())
+}
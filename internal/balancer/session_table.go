@@ -0,0 +1,366 @@
+package balancer
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// defaultIPSessionTTL is how long an IP hash session is kept without
+// requests when the persistence directive sets no ttl
+const defaultIPSessionTTL = 24 * time.Hour
+
+// defaultMaxSessions is how many sessions a session table holds when the
+// persistence directive sets no max_sessions
+const defaultMaxSessions = 100000
+
+// parseMaxSessions parses the max_sessions persistence option
+func parseMaxSessions(value string) (int, error) {
+	maxSessions, err := strconv.Atoi(value)
+	if err != nil || maxSessions < 1 {
+		return 0, fmt.Errorf("invalid max_sessions, expected a positive number: %s", value)
+	}
+	return maxSessions, nil
+}
+
+// sessionTable maps the clients of a pool to the backends their sessions are
+// pinned to. A session is forgotten once no request used it for the TTL, and
+// the least recently used sessions are forgotten when the table is full, so
+// memory does not grow with every client ever seen.
+type sessionTable struct {
+	ttl        time.Duration
+	maxEntries int
+	mu         sync.Mutex
+	items      map[string]*list.Element
+	lru        *list.List
+	// dropped counts the live sessions forgotten because the table was full
+	dropped int64
+}
+
+// sessionPin is the backend a session is pinned to
+type sessionPin struct {
+	key      string
+	backend  *Process
+	lastSeen time.Time
+	// evicted is set when the session was evicted through the admin API, to
+	// pin it afresh on its next request
+	evicted bool
+}
+
+func newSessionTable(ttl time.Duration, maxEntries int) *sessionTable {
+	return &sessionTable{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		items:      make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// getLocked returns the live pin of a session, or nil, forgetting it if it
+// expired; the caller holds the lock
+func (t *sessionTable) getLocked(key string, now time.Time) *sessionPin {
+	element, ok := t.items[key]
+	if !ok {
+		return nil
+	}
+	pin := element.Value.(*sessionPin)
+	if now.Sub(pin.lastSeen) > t.ttl {
+		t.removeLocked(element)
+		return nil
+	}
+	return pin
+}
+
+// removeLocked forgets a session; the caller holds the lock
+func (t *sessionTable) removeLocked(element *list.Element) {
+	t.lru.Remove(element)
+	delete(t.items, element.Value.(*sessionPin).key)
+}
+
+// lookup returns the backend a session is pinned to, or nil if the session
+// is unknown, expired or evicted
+func (t *sessionTable) lookup(key string) *Process {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pin := t.getLocked(key, time.Now())
+	if pin == nil || pin.evicted {
+		return nil
+	}
+	return pin.backend
+}
+
+// evicted reports whether the session of a client pinned to a backend was
+// evicted
+func (t *sessionTable) evicted(key string, backend *Process) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pin := t.getLocked(key, time.Now())
+	return pin != nil && pin.backend == backend && pin.evicted
+}
+
+// pin binds a session to a backend, keeping it for the TTL from now
+func (t *sessionTable) pin(key string, backend *Process) {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if pin := t.getLocked(key, now); pin != nil {
+		pin.backend, pin.lastSeen, pin.evicted = backend, now, false
+		t.lru.MoveToFront(t.items[key])
+	} else {
+		t.items[key] = t.lru.PushFront(&sessionPin{key: key, backend: backend, lastSeen: now})
+	}
+
+	// The least recently used sessions are at the back
+	for back := t.lru.Back(); back != nil && now.Sub(back.Value.(*sessionPin).lastSeen) > t.ttl; back = t.lru.Back() {
+		t.removeLocked(back)
+	}
+	for t.maxEntries > 0 && t.lru.Len() > t.maxEntries {
+		t.removeLocked(t.lru.Back())
+		t.dropped++
+	}
+}
+
+// liveLocked calls visit with every live session, most recently used
+// first, forgetting the expired ones; the caller holds the lock
+func (t *sessionTable) liveLocked(now time.Time, visit func(pin *sessionPin)) {
+	for element := t.lru.Front(); element != nil; {
+		next := element.Next()
+		if pin := element.Value.(*sessionPin); now.Sub(pin.lastSeen) > t.ttl {
+			t.removeLocked(element)
+		} else {
+			visit(pin)
+		}
+		element = next
+	}
+}
+
+// migrate moves the sessions pinned to a backend to the backends chosen by
+// pick and returns how many moved. Sessions pick has no backend for are
+// forgotten.
+func (t *sessionTable) migrate(from *Process, pick func() *Process) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	moved := 0
+	t.liveLocked(time.Now(), func(pin *sessionPin) {
+		if pin.backend != from {
+			return
+		}
+		if target := pick(); target != nil {
+			pin.backend, pin.evicted = target, false
+			moved++
+		} else {
+			t.removeLocked(t.items[pin.key])
+		}
+	})
+	return moved
+}
+
+// evict marks the sessions pinned to a backend to be pinned afresh by the
+// balancing method on their next request and returns how many there were
+func (t *sessionTable) evict(backend *Process) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	evicted := 0
+	t.liveLocked(time.Now(), func(pin *sessionPin) {
+		if pin.backend == backend && !pin.evicted {
+			pin.evicted = true
+			evicted++
+		}
+	})
+	return evicted
+}
+
+// entries lists the live sessions of the table
+func (t *sessionTable) entries() []SessionEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var entries []SessionEntry
+	t.liveLocked(time.Now(), func(pin *sessionPin) {
+		if !pin.evicted {
+			entries = append(entries, SessionEntry{
+				Client:   pin.key,
+				Backend:  pin.backend.URL.String(),
+				LastSeen: pin.lastSeen,
+			})
+		}
+	})
+	return entries
+}
+
+// stats returns the size of the table
+func (t *sessionTable) stats() SessionTableStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return SessionTableStats{Entries: t.lru.Len(), MaxEntries: t.maxEntries, Dropped: t.dropped}
+}
+
+// SessionTableStats is the size of the session table of a pool
+type SessionTableStats struct {
+	Persistence string `json:"persistence"`
+	Entries     int    `json:"entries"`
+	MaxEntries  int    `json:"maxEntries"`
+	// Dropped counts the sessions forgotten before they expired because the
+	// table was full
+	Dropped int64 `json:"dropped"`
+}
+
+// GetSessionTableStats returns the size of the session table of every pool
+// with cookie or IP hash persistence
+func GetSessionTableStats(lb LoadBalancerStrategy) map[string]SessionTableStats {
+	stats := make(map[string]SessionTableStats)
+	sessionTables(lb, func(pool string, spb *SessionPersistenceBalancer, table *sessionTable) {
+		tableStats := table.stats()
+		tableStats.Persistence = getPersistenceMethodName(spb.PersistenceMethod)
+		stats[pool] = tableStats
+	})
+	return stats
+}
+
+// SessionEntry is a session pinned to a backend
+type SessionEntry struct {
+	// Client is the client IP of IP hash sessions and the client
+	// fingerprint of cookie sessions
+	Client   string    `json:"client"`
+	Backend  string    `json:"backend"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// PoolSessions is the session table of a pool with persistence
+type PoolSessions struct {
+	Pool        string         `json:"pool"`
+	Persistence string         `json:"persistence"`
+	Counts      map[string]int `json:"counts"`
+	Sessions    []SessionEntry `json:"sessions"`
+}
+
+// sessionTables calls visit with the session table of every pool pinning
+// cookie or IP hash sessions, sorted by pool
+func sessionTables(lb LoadBalancerStrategy, visit func(pool string, spb *SessionPersistenceBalancer, table *sessionTable)) {
+	pools := namedPools(lb)
+	names := make([]string, 0, len(pools))
+	for name := range pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		walkBalancers(pools[name], func(balancer interface{}) {
+			spb, ok := balancer.(*SessionPersistenceBalancer)
+			if !ok {
+				return
+			}
+			switch spb.PersistenceMethod {
+			case CookiePersistence:
+				visit(name, spb, spb.CookieSessions)
+			case IPHashPersistence:
+				visit(name, spb, spb.IPSessions)
+			}
+		})
+	}
+}
+
+// GetSessions returns the sessions of every pool with cookie or IP hash
+// persistence, or only the ones pinned to a backend if it is not empty
+func GetSessions(lb LoadBalancerStrategy, backend string) []PoolSessions {
+	result := []PoolSessions{}
+	sessionTables(lb, func(pool string, spb *SessionPersistenceBalancer, table *sessionTable) {
+		sessions := PoolSessions{
+			Pool:        pool,
+			Persistence: getPersistenceMethodName(spb.PersistenceMethod),
+			Counts:      make(map[string]int),
+			Sessions:    []SessionEntry{},
+		}
+		for _, entry := range table.entries() {
+			if backend != "" && entry.Backend != backend {
+				continue
+			}
+			sessions.Counts[entry.Backend]++
+			sessions.Sessions = append(sessions.Sessions, entry)
+		}
+		sort.Slice(sessions.Sessions, func(i, j int) bool {
+			return sessions.Sessions[i].Client < sessions.Sessions[j].Client
+		})
+		result = append(result, sessions)
+	})
+	return result
+}
+
+// SessionEvictionResult reports the sessions evicted from a backend
+type SessionEvictionResult struct {
+	Backend  string `json:"backend"`
+	Sessions int    `json:"sessions"`
+}
+
+// EvictSessions evicts the sessions pinned to a backend, so their next
+// requests are balanced afresh, and reports whether the backend was found
+func EvictSessions(lb LoadBalancerStrategy, backend string) (SessionEvictionResult, bool) {
+	result := SessionEvictionResult{Backend: backend}
+	found := false
+	sessionTables(lb, func(pool string, spb *SessionPersistenceBalancer, table *sessionTable) {
+		for _, p := range spb.Backends() {
+			if p.URL.String() == backend {
+				found = true
+				result.Sessions += table.evict(p)
+			}
+		}
+	})
+	if !found {
+		for _, p := range strategyProcesses(lb) {
+			found = found || p.URL.String() == backend
+		}
+	}
+	return result, found
+}
+
+// SessionsHandler serves the session tables: GET lists the sessions of
+// every pool with their count per backend, optionally only the ones of
+// backend=<URL>, and DELETE backend=<URL> evicts the sessions pinned to a
+// backend so they are balanced afresh
+func SessionsHandler(lb LoadBalancerStrategy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		backend := r.FormValue("backend")
+
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(GetSessions(lb, backend))
+
+		case http.MethodDelete:
+			if backend == "" {
+				http.Error(w, "backend is required", http.StatusBadRequest)
+				return
+			}
+			result, found := EvictSessions(lb, backend)
+			if !found {
+				apiError(w, fmt.Errorf("%w: %s", ErrBackendNotFound, backend))
+				return
+			}
+
+			logger.Log.Info("Sessions evicted through the admin API",
+				zap.String("backend", backend),
+				zap.Int("sessions", result.Sessions))
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(result)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package balancer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// sniHelloTimeout is how long a client has to send its ClientHello
+	sniHelloTimeout = 10 * time.Second
+	// sniDialTimeout is how long connecting to a backend may take
+	sniDialTimeout = 5 * time.Second
+)
+
+// SNIRoute sends the TLS connections for a server name to a pool. The name
+// may be a wildcard like *.example.com, matching one label, or * for every
+// connection no other route matches, including those without a server name.
+type SNIRoute struct {
+	Name string
+	Pool string
+	Line int
+}
+
+// SNIConfig holds the settings of the TLS passthrough listener
+type SNIConfig struct {
+	Listen string
+	Routes []SNIRoute
+}
+
+// parseSNIRoute parses the arguments of an sni_route directive:
+// sni_route <name> <pool>
+func parseSNIRoute(parts []string) (SNIRoute, error) {
+	if len(parts) < 3 {
+		return SNIRoute{}, fmt.Errorf("sni_route directive requires a server name and a backend pool")
+	}
+
+	name := normalizeDNSName(parts[1])
+	if name != "*" {
+		host := strings.TrimPrefix(name, "*.")
+		if host == "" || strings.ContainsAny(host, "*/:") {
+			return SNIRoute{}, fmt.Errorf("invalid sni server name: %s", parts[1])
+		}
+	}
+	return SNIRoute{Name: name, Pool: strings.TrimSuffix(parts[2], ";")}, nil
+}
+
+// sniTarget is a route with its pool
+type sniTarget struct {
+	name string
+	pool Pool
+}
+
+// SNIRouter balances TLS connections across pools by the server name of
+// their ClientHello, without terminating TLS: the connection is forwarded
+// as it is, so the client and the backend keep end-to-end encryption
+type SNIRouter struct {
+	exact     map[string]Pool
+	wildcards []sniTarget
+	fallback  Pool
+	listener  net.Listener
+}
+
+// NewSNIRouter creates a router sending connections to the pools of a
+// strategy, or returns nil if TLS passthrough is disabled
+func NewSNIRouter(lb LoadBalancerStrategy, config SNIConfig) (*SNIRouter, error) {
+	if config.Listen == "" || len(config.Routes) == 0 {
+		return nil, nil
+	}
+
+	router := &SNIRouter{exact: make(map[string]Pool)}
+	for _, route := range config.Routes {
+		var pool Pool
+		if strategy := poolStrategy(lb, route.Pool); strategy != nil {
+			walkBalancers(strategy, func(balancer interface{}) {
+				if p, ok := balancer.(Pool); ok && pool == nil {
+					pool = p
+				}
+			})
+		}
+		if pool == nil {
+			return nil, poolNotFound(route.Line, "sni route backend pool", route.Pool)
+		}
+
+		switch {
+		case route.Name == "*":
+			router.fallback = pool
+		case strings.HasPrefix(route.Name, "*."):
+			router.wildcards = append(router.wildcards, sniTarget{name: route.Name[1:], pool: pool})
+		default:
+			router.exact[route.Name] = pool
+		}
+	}
+	return router, nil
+}
+
+// route returns the pool of a server name, or nil if no route matches
+func (s *SNIRouter) route(serverName string) Pool {
+	name := normalizeDNSName(serverName)
+	if pool, ok := s.exact[name]; ok {
+		return pool
+	}
+	for _, target := range s.wildcards {
+		if label, ok := strings.CutSuffix(name, target.name); ok && label != "" && !strings.Contains(label, ".") {
+			return target.pool
+		}
+	}
+	return s.fallback
+}
+
+// Serve forwards the connections accepted on an already open listener in
+// the background
+func (s *SNIRouter) Serve(listener net.Listener) {
+	if s == nil {
+		return
+	}
+
+	s.listener = listener
+	go s.serve()
+}
+
+// Addr returns the address the router listens on
+func (s *SNIRouter) Addr() net.Addr {
+	if s == nil || s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Stop stops accepting connections. Forwarded connections stay open until
+// either side closes them.
+func (s *SNIRouter) Stop() {
+	if s == nil || s.listener == nil {
+		return
+	}
+	s.listener.Close()
+}
+
+func (s *SNIRouter) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Log.Warn("Failed to accept TLS connection", zap.Error(err))
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle reads the ClientHello of a connection and forwards the connection
+// to a backend of the pool its server name is routed to
+func (s *SNIRouter) handle(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(sniHelloTimeout))
+	serverName, hello, err := readClientHello(conn)
+	if err != nil {
+		logger.Log.Debug("Failed to read TLS ClientHello",
+			zap.String("client", conn.RemoteAddr().String()),
+			zap.Error(err))
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	pool := s.route(serverName)
+	if pool == nil {
+		logger.Log.Debug("No SNI route for server name",
+			zap.String("server_name", serverName),
+			zap.String("client", conn.RemoteAddr().String()))
+		return
+	}
+
+	backend, p, release := dialPassthrough(pool, conn.RemoteAddr(), serverName)
+	if backend == nil {
+		return
+	}
+	defer release()
+	defer backend.Close()
+
+	if _, err := backend.Write(hello); err != nil {
+		logger.Log.Debug("Failed to forward TLS ClientHello",
+			zap.String("backend", p.URL.String()),
+			zap.Error(err))
+		return
+	}
+	splice(conn, backend)
+}
+
+// dialPassthrough connects to a backend of a pool for a TLS connection. A
+// backend that cannot be reached is marked failed and another is tried, up
+// to the pool's retry limit. It returns a nil connection if every attempt
+// failed.
+func dialPassthrough(pool Pool, client net.Addr, serverName string) (net.Conn, *Process, func()) {
+	settings := pool.Settings()
+	retry := settings.retry()
+
+	// Pickers and connection limits work on requests, so the connection is
+	// described by one carrying its client address and server name
+	ctx, cancel := context.WithTimeout(context.Background(), sniDialTimeout)
+	defer cancel()
+	r := (&http.Request{
+		Method:     http.MethodConnect,
+		URL:        &url.URL{Host: serverName},
+		Host:       serverName,
+		Header:     make(http.Header),
+		RemoteAddr: client.String(),
+	}).WithContext(ctx)
+
+	for {
+		target, reason := acquireProcess(settings.Queue, pool.Backends(), r, func() *Process {
+			return pool.Pick(r)
+		})
+		if target == nil {
+			logger.Log.Warn("No backend for TLS connection",
+				zap.String("server_name", serverName),
+				zap.String("reason", string(reason)))
+			return nil, nil, nil
+		}
+		release := releaseOnce(settings.Queue, target)
+
+		backend, err := net.DialTimeout("tcp", passthroughAddr(target.URL), sniDialTimeout)
+		if err == nil {
+			return backend, target, release
+		}
+		release()
+
+		logger.Log.Error("TLS connection failed",
+			zap.String("backend", target.URL.String()),
+			zap.Error(err))
+		if target.recordFailure(err) {
+			logger.Log.Warn("Backend marked dead", zap.String("backend", target.URL.String()))
+			settings.revivals().watch(target)
+		}
+		if retry.exhausted(r) {
+			return nil, nil, nil
+		}
+		r = withTriedBackend(r, target)
+	}
+}
+
+// passthroughAddr returns the address TLS connections to a backend are
+// forwarded to, on port 443 unless its URL has a port
+func passthroughAddr(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), "443")
+}
+
+// errHelloRead stops the TLS handshake once the ClientHello is read
+var errHelloRead = errors.New("client hello read")
+
+// helloConn feeds a TLS handshake from a reader and refuses to write, so
+// the ClientHello is parsed without answering it
+type helloConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c helloConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c helloConn) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+// readClientHello reads the ClientHello of a TLS connection, returning its
+// server name and the bytes read, to be replayed to the backend
+func readClientHello(conn net.Conn) (string, []byte, error) {
+	var read bytes.Buffer
+	var hello *tls.ClientHelloInfo
+	err := tls.Server(helloConn{Conn: conn, reader: io.TeeReader(conn, &read)}, &tls.Config{
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = info
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	if hello == nil {
+		return "", nil, err
+	}
+	return hello.ServerName, read.Bytes(), nil
+}
+
+// splice copies data both ways between two connections until both
+// directions are closed
+func splice(client, backend net.Conn) {
+	done := make(chan struct{}, 2)
+	forward := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		// Pass the end of the stream on, so the other side can finish
+		if closer, ok := dst.(interface{ CloseWrite() error }); ok {
+			closer.CloseWrite()
+		} else {
+			dst.Close()
+		}
+		done <- struct{}{}
+	}
+	go forward(backend, client)
+	go forward(client, backend)
+	<-done
+	<-done
+}
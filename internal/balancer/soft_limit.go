@@ -0,0 +1,114 @@
+package balancer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// softLimitLogInterval is the minimum time between warnings logged for a limit
+const softLimitLogInterval = 10 * time.Second
+
+// parseWarnThreshold parses a warn=<percent> option into a fraction of the limit
+func parseWarnThreshold(value string) (float64, error) {
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil || percent <= 0 || percent >= 100 {
+		return 0, fmt.Errorf("invalid warn threshold: %s", value)
+	}
+	return percent / 100, nil
+}
+
+// LimitWarningStats describes the warning threshold of a limit and how often
+// its use crossed it
+type LimitWarningStats struct {
+	Threshold   float64   `json:"threshold"`
+	Crossings   int64     `json:"crossings"`
+	Active      bool      `json:"active"`
+	LastCrossed time.Time `json:"lastCrossed,omitempty"`
+}
+
+var (
+	limitWarnings   = make(map[string]*LimitWarningStats)
+	limitWarningsMu sync.Mutex
+)
+
+// GetLimitWarnings returns the warning state of every limit with a warning
+// threshold
+func GetLimitWarnings() map[string]LimitWarningStats {
+	limitWarningsMu.Lock()
+	defer limitWarningsMu.Unlock()
+
+	stats := make(map[string]LimitWarningStats, len(limitWarnings))
+	for name, s := range limitWarnings {
+		stats[name] = *s
+	}
+	return stats
+}
+
+// softLimit warns when the use of a limit crosses a threshold below it, so
+// operators hear about a limit before requests are rejected by it
+type softLimit struct {
+	name      string
+	threshold float64
+	above     int32
+	lastLog   int64
+}
+
+// newSoftLimit creates the warning threshold of a limit, or returns nil if
+// the threshold is not set
+func newSoftLimit(name string, threshold float64) *softLimit {
+	if threshold <= 0 {
+		return nil
+	}
+
+	limitWarningsMu.Lock()
+	if _, ok := limitWarnings[name]; !ok {
+		limitWarnings[name] = &LimitWarningStats{}
+	}
+	limitWarnings[name].Threshold = threshold
+	limitWarningsMu.Unlock()
+
+	return &softLimit{name: name, threshold: threshold}
+}
+
+// observe records the use of the limit as a fraction of it
+func (s *softLimit) observe(usage float64) {
+	if s == nil {
+		return
+	}
+
+	if usage < s.threshold {
+		if atomic.CompareAndSwapInt32(&s.above, 1, 0) {
+			limitWarningsMu.Lock()
+			limitWarnings[s.name].Active = false
+			limitWarningsMu.Unlock()
+		}
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&s.above, 0, 1) {
+		return
+	}
+
+	now := time.Now()
+	limitWarningsMu.Lock()
+	stats := limitWarnings[s.name]
+	stats.Crossings++
+	stats.Active = true
+	stats.LastCrossed = now
+	limitWarningsMu.Unlock()
+
+	// Use can hover around the threshold, so warnings are logged sparingly
+	last := atomic.LoadInt64(&s.lastLog)
+	if now.UnixNano()-last >= int64(softLimitLogInterval) && atomic.CompareAndSwapInt64(&s.lastLog, last, now.UnixNano()) {
+		logger.Log.Warn("Limit warning threshold crossed",
+			zap.String("limit", s.name),
+			zap.Float64("usage", usage),
+			zap.Float64("threshold", s.threshold))
+	}
+}
@@ -0,0 +1,203 @@
+package balancer
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// splitBuckets is the number of buckets traffic is split into, a
+	// hundredth of a percent each
+	splitBuckets = 10000
+	// SplitHeader tells the backend which pool of a split a request went to
+	SplitHeader = "X-LB-Split"
+)
+
+// SplitConfig divides a route's traffic between pools by percentage
+type SplitConfig struct {
+	Pools    []string
+	Percents []float64
+	// Cookie keeps each client on the same side of the split, if set
+	Cookie string
+	// Key assigns clients to pools by a hash of their client_ip,
+	// header:<name> or cookie:<name> instead of at random, if set
+	Key string
+}
+
+// parseSplit parses the <percent>% <pool> pairs following split in a route
+// directive and returns how many arguments they took
+func parseSplit(args []string) (SplitConfig, int, error) {
+	config := SplitConfig{}
+	total := 0.0
+
+	used := 0
+	for used+1 < len(args) && strings.HasSuffix(args[used], "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(args[used], "%"), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return SplitConfig{}, 0, fmt.Errorf("invalid split percentage: %s", args[used])
+		}
+		config.Percents = append(config.Percents, percent)
+		config.Pools = append(config.Pools, args[used+1])
+		total += percent
+		used += 2
+	}
+
+	if len(config.Pools) < 2 {
+		return SplitConfig{}, 0, fmt.Errorf("split requires at least two <percent>%% <pool> pairs")
+	}
+	if math.Abs(total-100) > 0.001 {
+		return SplitConfig{}, 0, fmt.Errorf("split percentages must add up to 100%%, got %g%%", total)
+	}
+
+	return config, used, nil
+}
+
+var (
+	splitStats   = make(map[string]map[string]int64)
+	splitStatsMu sync.Mutex
+)
+
+func countSplitDecision(route, pool string) {
+	splitStatsMu.Lock()
+	defer splitStatsMu.Unlock()
+
+	if splitStats[route] == nil {
+		splitStats[route] = make(map[string]int64)
+	}
+	splitStats[route][pool]++
+}
+
+// GetSplitStats returns the number of requests of each split route sent to
+// each of its pools
+func GetSplitStats() map[string]map[string]int64 {
+	splitStatsMu.Lock()
+	defer splitStatsMu.Unlock()
+
+	stats := make(map[string]map[string]int64, len(splitStats))
+	for route, pools := range splitStats {
+		stats[route] = make(map[string]int64, len(pools))
+		for pool, count := range pools {
+			stats[route][pool] = count
+		}
+	}
+	return stats
+}
+
+// TrafficSplitter divides a route's requests between pools by percentage,
+// independently of the weights of their backends. Each request falls into
+// one of 10000 buckets, and the pools take consecutive ranges of buckets in
+// the order they are listed. With a user key, such as for A/B tests, a
+// client's bucket is a hash of the key rather than random. With a sticky
+// cookie a client keeps its bucket, so shifting percentages only moves the
+// clients whose bucket changes pool.
+type TrafficSplitter struct {
+	pools  []LoadBalancerStrategy
+	bounds []int
+	route  string
+	config SplitConfig
+}
+
+// NewTrafficSplitter creates a splitter over the given pools, in the order of
+// the split configuration
+func NewTrafficSplitter(pools []LoadBalancerStrategy, route string, config SplitConfig) LoadBalancerStrategy {
+	splitter := &TrafficSplitter{
+		pools:  pools,
+		route:  route,
+		config: config,
+	}
+
+	cumulative := 0.0
+	for _, percent := range config.Percents {
+		cumulative += percent
+		splitter.bounds = append(splitter.bounds, int(math.Round(cumulative*splitBuckets/100)))
+	}
+	return splitter
+}
+
+// bucket returns the bucket of the request: the one in its sticky cookie,
+// the one its user key hashes into, or a random one
+func (ts *TrafficSplitter) bucket(r *http.Request) (int, bool) {
+	if ts.config.Cookie != "" {
+		if cookie, err := r.Cookie(ts.config.Cookie); err == nil {
+			if bucket, err := strconv.Atoi(cookie.Value); err == nil && bucket >= 0 && bucket < splitBuckets {
+				return bucket, true
+			}
+		}
+	}
+
+	if ts.config.Key != "" {
+		if key := userKey(r, ts.config.Key); key != "" {
+			// The route is part of the hash so experiments on different
+			// routes assign users independently
+			h := fnv.New32a()
+			h.Write([]byte(ts.route))
+			h.Write([]byte{0})
+			h.Write([]byte(key))
+			return int(h.Sum32() % splitBuckets), false
+		}
+	}
+
+	return rand.Intn(splitBuckets), false
+}
+
+// pick returns the index of the pool taking a bucket
+func (ts *TrafficSplitter) pick(bucket int) int {
+	for i, bound := range ts.bounds {
+		if bucket < bound {
+			return i
+		}
+	}
+	return len(ts.pools) - 1
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (ts *TrafficSplitter) GetNextInstance(r *http.Request) (*url.URL, error) {
+	bucket, _ := ts.bucket(r)
+	return ts.pools[ts.pick(bucket)].GetNextInstance(r)
+}
+
+// ProxyRequest proxies the request to the pool its bucket falls into
+func (ts *TrafficSplitter) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	bucket, sticky := ts.bucket(r)
+	i := ts.pick(bucket)
+	pool := ts.config.Pools[i]
+	countSplitDecision(ts.route, pool)
+
+	if ts.config.Cookie != "" && !sticky {
+		http.SetCookie(w, &http.Cookie{
+			Name:     ts.config.Cookie,
+			Value:    strconv.Itoa(bucket),
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+		})
+	}
+
+	// Backends of an experiment can tell which variant a request is in
+	r.Header.Set(SplitHeader, pool)
+
+	annotateRoute(r, "", pool)
+	ts.pools[i].ProxyRequest(w, r)
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (ts *TrafficSplitter) SupportsWebSockets() bool {
+	for _, pool := range ts.pools {
+		if !pool.SupportsWebSockets() {
+			return false
+		}
+	}
+	return true
+}
+
+// Unwrap returns the first pool of the split
+func (ts *TrafficSplitter) Unwrap() LoadBalancerStrategy {
+	return ts.pools[0]
+}
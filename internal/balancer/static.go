@@ -0,0 +1,170 @@
+package balancer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// staticPrefix marks the target of a route serving files instead of a pool
+const staticPrefix = "static:"
+
+// defaultStaticMaxAge is how long clients may cache static files when the
+// route sets no max_age
+const defaultStaticMaxAge = time.Hour
+
+// StaticConfig holds the settings of a route serving files from disk
+type StaticConfig struct {
+	// Root is the directory the files are served from
+	Root string
+	// MaxAge is how long clients may cache the files without asking again;
+	// zero makes them revalidate every time
+	MaxAge time.Duration
+}
+
+// errStaticRouteNoBackend is returned when asking a static route for a backend
+var errStaticRouteNoBackend = errors.New("static route has no backend")
+
+// StaticFiles serves the files of a directory in place of a pool, for small
+// deployments without a pool of file servers. Responses carry Last-Modified,
+// an ETag and Cache-Control, conditional and range requests are answered,
+// and directories serve their index.html. Hidden files, and files that
+// symbolic links lead to outside the directory, are not served.
+type StaticFiles struct {
+	config StaticConfig
+	// root is the directory with its symbolic links resolved
+	root string
+	// prefix is the path prefix of a path route, taken off request paths
+	prefix       string
+	cacheControl string
+}
+
+// NewStaticFiles creates the file server of a static route
+func NewStaticFiles(route RouteConfig) (*StaticFiles, error) {
+	info, err := os.Stat(route.Static.Root)
+	if err != nil {
+		return nil, fmt.Errorf("static route directory not found: %s", route.Static.Root)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("static route root is not a directory: %s", route.Static.Root)
+	}
+	root, err := filepath.Abs(route.Static.Root)
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("static route directory not found: %s", route.Static.Root)
+	}
+
+	sf := &StaticFiles{
+		config:       route.Static,
+		root:         root,
+		cacheControl: "no-cache",
+	}
+	if route.Type == PathRoute {
+		sf.prefix = strings.TrimSuffix(route.Pattern, "/")
+	}
+	if route.Static.MaxAge > 0 {
+		sf.cacheControl = "public, max-age=" + strconv.Itoa(int(route.Static.MaxAge.Seconds()))
+	}
+	return sf, nil
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface; files are
+// not served by a backend
+func (sf *StaticFiles) GetNextInstance(r *http.Request) (*url.URL, error) {
+	return nil, errStaticRouteNoBackend
+}
+
+// ProxyRequest serves the file the request's path names
+func (sf *StaticFiles) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The prefix must end at a path segment, so /assets does not serve /assetsX
+	rest, ok := strings.CutPrefix(r.URL.Path, sf.prefix)
+	if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+		http.NotFound(w, r)
+		return
+	}
+
+	name := path.Clean("/" + rest)
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	file, info, err := sf.open(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Cache-Control", sf.cacheControl)
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
+
+// open opens a file of the directory, or the index.html of a directory
+func (sf *StaticFiles) open(name string) (*os.File, fs.FileInfo, error) {
+	file, err := sf.openInRoot(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if !info.IsDir() {
+		return file, info, nil
+	}
+
+	file.Close()
+	// Directories are not listed
+	file, err = sf.openInRoot(path.Join(name, "index.html"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if info, err = file.Stat(); err != nil || info.IsDir() {
+		file.Close()
+		return nil, nil, fs.ErrNotExist
+	}
+	return file, info, nil
+}
+
+// openInRoot opens a file of the directory after resolving its symbolic
+// links, failing with fs.ErrNotExist if they lead outside the directory
+func (sf *StaticFiles) openInRoot(name string) (*os.File, error) {
+	resolved, err := filepath.EvalSymlinks(filepath.Join(sf.root, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, err
+	}
+	if rel, err := filepath.Rel(sf.root, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fs.ErrNotExist
+	}
+	return os.Open(resolved)
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (sf *StaticFiles) SupportsWebSockets() bool {
+	return false
+}
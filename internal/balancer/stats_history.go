@@ -0,0 +1,239 @@
+package balancer
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// latencyBounds are the upper bounds of the response time buckets that
+// percentiles are estimated from, growing by a quarter from 1ms to about
+// a minute
+var latencyBounds = func() []time.Duration {
+	var bounds []time.Duration
+	for bound := float64(time.Millisecond); bound < float64(time.Minute); bound *= 1.25 {
+		bounds = append(bounds, time.Duration(bound))
+	}
+	return bounds
+}()
+
+// periodStats counts the requests of a backend since the last stats
+// snapshot, with their response times in buckets
+type periodStats struct {
+	mu       sync.Mutex
+	requests int64
+	errors   int64
+	buckets  []int64
+}
+
+func (ps *periodStats) add(status int, d time.Duration) {
+	bucket := sort.Search(len(latencyBounds), func(i int) bool { return latencyBounds[i] >= d })
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.buckets == nil {
+		ps.buckets = make([]int64, len(latencyBounds)+1)
+	}
+	ps.requests++
+	if status >= 500 {
+		ps.errors++
+	}
+	ps.buckets[bucket]++
+}
+
+// take returns the counts of the period and starts a new one
+func (ps *periodStats) take() (requests, errors int64, buckets []int64) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	requests, errors, buckets = ps.requests, ps.errors, ps.buckets
+	ps.requests, ps.errors, ps.buckets = 0, 0, nil
+	return requests, errors, buckets
+}
+
+// latencyPercentile estimates a percentile of the response times counted
+// in buckets, in milliseconds, as the upper bound of the bucket it falls in
+func latencyPercentile(buckets []int64, total int64, percentile float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(float64(total) * percentile))
+	var seen int64
+	for i, count := range buckets {
+		seen += count
+		if seen >= rank {
+			if i == len(latencyBounds) {
+				return float64(time.Minute) / float64(time.Millisecond)
+			}
+			return float64(latencyBounds[i]) / float64(time.Millisecond)
+		}
+	}
+	return 0
+}
+
+// StatsSnapshot holds the requests of one interval
+type StatsSnapshot struct {
+	// Time is when the interval ended, in Unix milliseconds
+	Time     int64                      `json:"time"`
+	Requests int64                      `json:"requests"`
+	Errors   int64                      `json:"errors"`
+	Backends map[string]BackendSnapshot `json:"backends"`
+}
+
+// BackendSnapshot holds the requests of a backend during one interval.
+// Errors are 5xx responses, including requests the backend failed to
+// answer, and latencies are in milliseconds.
+type BackendSnapshot struct {
+	Alive    bool    `json:"alive"`
+	Requests int64   `json:"requests"`
+	Errors   int64   `json:"errors"`
+	P50      float64 `json:"p50"`
+	P90      float64 `json:"p90"`
+	P99      float64 `json:"p99"`
+}
+
+// StatsHistory holds the stats snapshots of the last intervals, oldest first
+type StatsHistory struct {
+	IntervalSeconds int             `json:"intervalSeconds"`
+	Snapshots       []StatsSnapshot `json:"snapshots"`
+}
+
+// StatsRecorder takes a stats snapshot every interval into a fixed-size
+// in-memory ring buffer, so charts of recent traffic need no external
+// storage
+type StatsRecorder struct {
+	lb        LoadBalancerStrategy
+	interval  time.Duration
+	mu        sync.RWMutex
+	snapshots []StatsSnapshot
+	next      int
+	count     int
+	stop      chan struct{}
+}
+
+// NewStatsRecorder creates a recorder keeping the given number of snapshots
+func NewStatsRecorder(lb LoadBalancerStrategy, interval time.Duration, size int) *StatsRecorder {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	if size <= 0 {
+		size = 1440
+	}
+	return &StatsRecorder{
+		lb:        lb,
+		interval:  interval,
+		snapshots: make([]StatsSnapshot, size),
+		stop:      make(chan struct{}),
+	}
+}
+
+// Start begins taking snapshots in the background. Requests before Start
+// count towards the first snapshot.
+func (sr *StatsRecorder) Start() {
+	go func() {
+		ticker := time.NewTicker(sr.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				sr.Snapshot(now)
+			case <-sr.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends taking snapshots
+func (sr *StatsRecorder) Stop() {
+	close(sr.stop)
+}
+
+// Snapshot records the requests since the last snapshot as ending at now
+func (sr *StatsRecorder) Snapshot(now time.Time) {
+	snapshot := StatsSnapshot{
+		Time:     now.UnixMilli(),
+		Backends: make(map[string]BackendSnapshot),
+	}
+	for _, p := range strategyProcesses(sr.lb) {
+		requests, errors, buckets := p.period.take()
+		snapshot.Backends[p.URL.Redacted()] = BackendSnapshot{
+			Alive:    p.IsAlive(),
+			Requests: requests,
+			Errors:   errors,
+			P50:      latencyPercentile(buckets, requests, 0.5),
+			P90:      latencyPercentile(buckets, requests, 0.9),
+			P99:      latencyPercentile(buckets, requests, 0.99),
+		}
+		snapshot.Requests += requests
+		snapshot.Errors += errors
+	}
+
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	sr.snapshots[sr.next] = snapshot
+	sr.next = (sr.next + 1) % len(sr.snapshots)
+	if sr.count < len(sr.snapshots) {
+		sr.count++
+	}
+}
+
+// History returns the snapshots taken within a range of now, or all of them
+// if the range is 0
+func (sr *StatsRecorder) History(within time.Duration, now time.Time) StatsHistory {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	history := StatsHistory{
+		IntervalSeconds: int(sr.interval / time.Second),
+		Snapshots:       make([]StatsSnapshot, 0, sr.count),
+	}
+
+	since := now.Add(-within).UnixMilli()
+	start := (sr.next - sr.count + len(sr.snapshots)) % len(sr.snapshots)
+	for i := 0; i < sr.count; i++ {
+		snapshot := sr.snapshots[(start+i)%len(sr.snapshots)]
+		if within > 0 && snapshot.Time <= since {
+			continue
+		}
+		history.Snapshots = append(history.Snapshots, snapshot)
+	}
+
+	return history
+}
+
+// StatsHistoryHandler serves the stats snapshots, limited to a range such as
+// ?range=1h
+func StatsHistoryHandler(sr *StatsRecorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var within time.Duration
+		if value := r.URL.Query().Get("range"); value != "" {
+			var err error
+			if within, err = time.ParseDuration(value); err != nil || within <= 0 {
+				http.Error(w, "invalid range: "+value, http.StatusBadRequest)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(sr.History(within, time.Now())); err != nil {
+			logger.Admin.Error("Failed to encode stats history", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package balancer
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// statsdPacketSize keeps packets within the usual network MTU
+const statsdPacketSize = 1432
+
+// StatsdConfig holds the settings of the StatsD exporter
+type StatsdConfig struct {
+	// Address is the host:port of the StatsD agent; the exporter is off if
+	// it is empty
+	Address string
+	// Prefix is put in front of every metric name
+	Prefix string
+	// Tags are key:value tags added to every metric
+	Tags []string
+	// Interval is how often counters and gauges are sent and buffered
+	// timings flushed
+	Interval time.Duration
+	// Format is dogstatsd, which tags metrics, or statsd, which cannot
+	Format string
+}
+
+// parseStatsd parses the arguments of a statsd directive
+func parseStatsd(parts []string) (StatsdConfig, error) {
+	if len(parts) < 2 {
+		return StatsdConfig{}, fmt.Errorf("statsd directive requires an address")
+	}
+
+	config := StatsdConfig{
+		Address:  strings.TrimSuffix(parts[1], ";"),
+		Prefix:   "lb.",
+		Interval: 10 * time.Second,
+		Format:   "dogstatsd",
+	}
+	if _, _, err := net.SplitHostPort(config.Address); err != nil {
+		return StatsdConfig{}, fmt.Errorf("invalid statsd address, expected host:port: %s", config.Address)
+	}
+
+	for _, part := range parts[2:] {
+		key, value, _ := strings.Cut(strings.TrimSuffix(part, ";"), "=")
+		switch key {
+		case "prefix":
+			config.Prefix = value
+		case "tags":
+			for _, tag := range strings.Split(value, ",") {
+				if tag == "" || strings.ContainsAny(tag, "|#") {
+					return StatsdConfig{}, fmt.Errorf("invalid statsd tag: %s", tag)
+				}
+				config.Tags = append(config.Tags, tag)
+			}
+		case "interval":
+			interval, err := time.ParseDuration(value)
+			if err != nil || interval <= 0 {
+				return StatsdConfig{}, fmt.Errorf("invalid statsd interval: %s", value)
+			}
+			config.Interval = interval
+		case "format":
+			if value != "dogstatsd" && value != "statsd" {
+				return StatsdConfig{}, fmt.Errorf("invalid statsd format, expected dogstatsd or statsd: %s", value)
+			}
+			config.Format = value
+		default:
+			return StatsdConfig{}, fmt.Errorf("unknown statsd option: %s", part)
+		}
+	}
+
+	return config, nil
+}
+
+// StatsdExporter pushes request counts and timings and backend gauges to a
+// StatsD or DogStatsD agent over UDP, for monitoring stacks such as Datadog
+// that do not scrape Prometheus. Requests are tagged with the labels of the
+// metrics directive. Timings are buffered into packets; counters and gauges
+// are sent every interval.
+type StatsdExporter struct {
+	lb     LoadBalancerStrategy
+	config StatsdConfig
+	conn   net.Conn
+	stop   chan struct{}
+	done   chan struct{}
+
+	mu     sync.Mutex
+	counts map[string]int64
+	packet []byte
+}
+
+// NewStatsdExporter creates an exporter, or returns nil if no StatsD agent
+// is configured
+func NewStatsdExporter(lb LoadBalancerStrategy, config StatsdConfig) (*StatsdExporter, error) {
+	if config.Address == "" {
+		return nil, nil
+	}
+	conn, err := net.Dial("udp", config.Address)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsdExporter{
+		lb:     lb,
+		config: config,
+		conn:   conn,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		counts: make(map[string]int64),
+	}, nil
+}
+
+var statsdExporter atomic.Pointer[StatsdExporter]
+
+// Start begins exporting the requests proxied from now on
+func (se *StatsdExporter) Start() {
+	if se == nil {
+		return
+	}
+	statsdExporter.Store(se)
+
+	go func() {
+		defer close(se.done)
+		ticker := time.NewTicker(se.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				se.flush()
+			case <-se.stop:
+				se.flush()
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends exporting after sending what is left
+func (se *StatsdExporter) Stop() {
+	if se == nil {
+		return
+	}
+	statsdExporter.CompareAndSwap(se, nil)
+	close(se.stop)
+	<-se.done
+	se.conn.Close()
+}
+
+// recordStatsdRequest exports a request to the running exporter, if any
+func recordStatsdRequest(labels, values []string, duration time.Duration) {
+	if se := statsdExporter.Load(); se != nil {
+		se.record(labels, values, duration)
+	}
+}
+
+func (se *StatsdExporter) record(labels, values []string, duration time.Duration) {
+	var tags []string
+	for i, label := range labels {
+		tags = append(tags, label+":"+statsdTagValue(values[i]))
+	}
+	tagList := strings.Join(tags, ",")
+	milliseconds := strconv.FormatFloat(float64(duration)/float64(time.Millisecond), 'f', 3, 64)
+
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	se.counts[tagList]++
+	se.write(se.line("request.duration", milliseconds, "ms", tagList))
+}
+
+var statsdTagEscaper = strings.NewReplacer(",", "_", "|", "_", "#", "_", ":", "_", "\n", "_")
+
+// statsdTagValue replaces the characters that end a tag or a metric
+func statsdTagValue(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return statsdTagEscaper.Replace(value)
+}
+
+// line formats a metric with the configured prefix and tags. Tags are left
+// out in the statsd format.
+func (se *StatsdExporter) line(name, value, kind, tags string) string {
+	line := se.config.Prefix + name + ":" + value + "|" + kind
+	if se.config.Format != "dogstatsd" {
+		return line
+	}
+	all := se.config.Tags
+	if tags != "" {
+		all = append(append([]string(nil), all...), tags)
+	}
+	if len(all) > 0 {
+		line += "|#" + strings.Join(all, ",")
+	}
+	return line
+}
+
+// write adds a line to the packet, sending the packet first if the line
+// does not fit. The caller holds mu.
+func (se *StatsdExporter) write(line string) {
+	if len(se.packet) > 0 && len(se.packet)+1+len(line) > statsdPacketSize {
+		se.send()
+	}
+	if len(se.packet) > 0 {
+		se.packet = append(se.packet, '\n')
+	}
+	se.packet = append(se.packet, line...)
+}
+
+// send sends the packet. Metrics are lost if the agent is unreachable, as
+// StatsD clients do. The caller holds mu.
+func (se *StatsdExporter) send() {
+	if len(se.packet) == 0 {
+		return
+	}
+	if _, err := se.conn.Write(se.packet); err != nil {
+		logger.Log.Debug("Failed to send metrics to StatsD",
+			zap.String("address", se.config.Address),
+			zap.Error(err))
+	}
+	se.packet = se.packet[:0]
+}
+
+// flush sends the request counts of the interval, the backend gauges and
+// the buffered timings
+func (se *StatsdExporter) flush() {
+	alive, total := 0, 0
+	for _, p := range strategyProcesses(se.lb) {
+		total++
+		if p.IsAlive() {
+			alive++
+		}
+	}
+
+	se.mu.Lock()
+	defer se.mu.Unlock()
+
+	tagLists := make([]string, 0, len(se.counts))
+	for tagList := range se.counts {
+		tagLists = append(tagLists, tagList)
+	}
+	sort.Strings(tagLists)
+	for _, tagList := range tagLists {
+		se.write(se.line("requests", strconv.FormatInt(se.counts[tagList], 10), "c", tagList))
+	}
+	se.counts = make(map[string]int64)
+
+	se.write(se.line("backends.alive", strconv.Itoa(alive), "g", ""))
+	se.write(se.line("backends.total", strconv.Itoa(total), "g", ""))
+	se.send()
+}
@@ -0,0 +1,80 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type streamingContextKey struct{}
+
+// StreamingRoute proxies the requests of a route whose responses are
+// streamed, such as Server-Sent Events and long polls. Each chunk is flushed
+// to the client as soon as the backend sends it, and requests are exempt
+// from their deadlines, as a stream may stay open for as long as the client
+// listens.
+type StreamingRoute struct {
+	next LoadBalancerStrategy
+}
+
+// NewStreamingRoute makes a strategy stream its responses, or returns it
+// unchanged if enabled is false
+func NewStreamingRoute(next LoadBalancerStrategy, enabled bool) LoadBalancerStrategy {
+	if !enabled {
+		return next
+	}
+	return &StreamingRoute{next: next}
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (s *StreamingRoute) GetNextInstance(r *http.Request) (*url.URL, error) {
+	return s.next.GetNextInstance(r)
+}
+
+// ProxyRequest proxies the request without a deadline, flushing its
+// response through
+func (s *StreamingRoute) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	// The server's write timeout would cut the stream short
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	ctx, cancel := withoutDeadline(r.Context())
+	defer cancel()
+	s.next.ProxyRequest(w, r.WithContext(context.WithValue(ctx, streamingContextKey{}, true)))
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (s *StreamingRoute) SupportsWebSockets() bool {
+	return s.next.SupportsWebSockets()
+}
+
+// Unwrap returns the wrapped strategy
+func (s *StreamingRoute) Unwrap() LoadBalancerStrategy {
+	return s.next
+}
+
+// isStreaming reports whether a request is proxied by a streaming route
+func isStreaming(r *http.Request) bool {
+	streaming, _ := r.Context().Value(streamingContextKey{}).(bool)
+	return streaming
+}
+
+// withoutDeadline returns a context with the values of parent that is
+// canceled with it, but not when its deadline passes
+func withoutDeadline(parent context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := parent.Deadline(); !ok {
+		return context.WithCancel(parent)
+	}
+
+	ctx, cancel := context.WithCancel(context.WithoutCancel(parent))
+	stop := context.AfterFunc(parent, func() {
+		if !errors.Is(parent.Err(), context.DeadlineExceeded) {
+			cancel()
+		}
+	})
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
@@ -0,0 +1,75 @@
+package balancer
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SubsetConfig holds the settings for deterministic subsetting of a large pool
+type SubsetConfig struct {
+	Size       int
+	InstanceID int
+}
+
+// parseSubset parses the arguments of a subset directive
+func parseSubset(parts []string) (SubsetConfig, error) {
+	if len(parts) < 2 {
+		return SubsetConfig{}, fmt.Errorf("subset directive requires a size")
+	}
+
+	size, err := strconv.Atoi(parts[1])
+	if err != nil || size <= 0 {
+		return SubsetConfig{}, fmt.Errorf("invalid subset size: %s", parts[1])
+	}
+
+	subset := SubsetConfig{Size: size, InstanceID: defaultInstanceID()}
+
+	for i := 2; i < len(parts); i++ {
+		if strings.HasPrefix(parts[i], "instance=") {
+			idStr := strings.TrimPrefix(parts[i], "instance=")
+			id, err := strconv.Atoi(idStr)
+			if err != nil || id < 0 {
+				return SubsetConfig{}, fmt.Errorf("invalid subset instance: %s", idStr)
+			}
+			subset.InstanceID = id
+		}
+	}
+
+	return subset, nil
+}
+
+// defaultInstanceID derives the instance ID from the LB_INSTANCE_ID
+// environment variable, falling back to a hash of the hostname
+func defaultInstanceID() int {
+	if id, err := strconv.Atoi(os.Getenv("LB_INSTANCE_ID")); err == nil && id >= 0 {
+		return id
+	}
+	hostname, _ := os.Hostname()
+	return int(crc32.ChecksumIEEE([]byte(hostname)) & 0x7fffffff)
+}
+
+// SubsetBackends returns the deterministic subset of backends used by one
+// balancer instance. Instances with consecutive IDs share a shuffled ordering
+// of the pool and take disjoint slices of it, so every backend receives
+// connections from roughly the same number of instances.
+func SubsetBackends(backends []BackendConfig, subset SubsetConfig) []BackendConfig {
+	if subset.Size <= 0 || subset.Size >= len(backends) {
+		return backends
+	}
+
+	subsetCount := len(backends) / subset.Size
+	round := subset.InstanceID / subsetCount
+
+	shuffled := make([]BackendConfig, len(backends))
+	copy(shuffled, backends)
+	rand.New(rand.NewSource(int64(round))).Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+
+	start := (subset.InstanceID % subsetCount) * subset.Size
+	return shuffled[start : start+subset.Size]
+}
@@ -0,0 +1,210 @@
+package balancer
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// redactedValue replaces secrets in a sanitized configuration
+const redactedValue = "[REDACTED]"
+
+// secretOptions are the options of directives whose values are secrets
+var secretOptions = map[string]map[string]bool{
+	"routing_override": {"token": true},
+}
+
+// urlPassword matches the password of a URL in free text, such as a log entry
+var urlPassword = regexp.MustCompile(`(://[^/\s:@"]+:)[^/\s@"]+@`)
+
+// sanitizeConfig removes secrets from a configuration file so it can be
+// shared: header values, which often carry credentials, secret options such
+// as the routing override token, and passwords in URLs
+func sanitizeConfig(data []byte) []byte {
+	lines := strings.Split(string(data), "\n")
+
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		parts := strings.Fields(trimmed)
+		changed := false
+
+		switch parts[0] {
+		case "set_header", "add_header", "set_response_header", "add_response_header", "header":
+			if len(parts) >= 3 {
+				parts = append(parts[:2], redactedValue)
+				changed = true
+			}
+		}
+
+		if secrets, ok := secretOptions[parts[0]]; ok {
+			for j, part := range parts[1:] {
+				if name, _, ok := strings.Cut(part, "="); ok && secrets[name] {
+					parts[j+1] = name + "=" + redactedValue
+					changed = true
+				}
+			}
+		}
+
+		for j, part := range parts {
+			if u, err := url.Parse(part); err == nil && u.User != nil {
+				// A URL without a password may carry a token as its user
+				if _, ok := u.User.Password(); !ok {
+					u.User = url.User("xxxxx")
+				}
+				parts[j] = u.Redacted()
+				changed = true
+			}
+		}
+
+		if changed {
+			indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+			lines[i] = indent + strings.Join(parts, " ")
+		}
+	}
+
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// supportBundleInfo describes the process a support bundle was taken from
+type supportBundleInfo struct {
+	CreatedAt  time.Time       `json:"createdAt"`
+	StartTime  time.Time       `json:"startTime"`
+	Uptime     string          `json:"uptime"`
+	GoVersion  string          `json:"goVersion"`
+	OS         string          `json:"os"`
+	Arch       string          `json:"arch"`
+	CPUs       int             `json:"cpus"`
+	Goroutines int             `json:"goroutines"`
+	LogLevel   string          `json:"logLevel"`
+	Features   map[string]bool `json:"features"`
+}
+
+// WriteSupportBundle writes a zip archive for bug reports: the sanitized
+// configuration, recent logs, a stats snapshot, a goroutine dump and the
+// backend health history
+func WriteSupportBundle(w io.Writer, lb LoadBalancerStrategy, configPath string) error {
+	archive := zip.NewWriter(w)
+	now := time.Now()
+
+	add := func(name string, write func(io.Writer) error) error {
+		f, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: now,
+		})
+		if err != nil {
+			return err
+		}
+		return write(f)
+	}
+	addJSON := func(name string, v interface{}) error {
+		return add(name, func(f io.Writer) error {
+			encoder := json.NewEncoder(f)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(v)
+		})
+	}
+
+	info := supportBundleInfo{
+		CreatedAt:  now,
+		StartTime:  startTime,
+		Uptime:     time.Since(startTime).String(),
+		GoVersion:  runtime.Version(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		CPUs:       runtime.NumCPU(),
+		Goroutines: runtime.NumGoroutine(),
+		LogLevel:   logger.Level.String(),
+		Features:   GetFeatures(),
+	}
+	if err := addJSON("info.json", info); err != nil {
+		return err
+	}
+
+	// A missing configuration is noted rather than failing the bundle
+	err := add("config.conf", func(f io.Writer) error {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			_, err = fmt.Fprintf(f, "# configuration unavailable: %v\n", err)
+			return err
+		}
+		_, err = f.Write(sanitizeConfig(data))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	err = add("logs.jsonl", func(f io.Writer) error {
+		for _, line := range logger.RecentLogs() {
+			line = urlPassword.ReplaceAllString(line, "${1}xxxxx@")
+			if _, err := io.WriteString(f, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Backend URLs may carry credentials
+	stats := GetStats(lb)
+	stats.Backends = append([]BackendStats(nil), stats.Backends...)
+	for i := range stats.Backends {
+		if u, err := url.Parse(stats.Backends[i].URL); err == nil {
+			stats.Backends[i].URL = u.Redacted()
+		}
+	}
+	if err := addJSON("stats.json", stats); err != nil {
+		return err
+	}
+	if err := addJSON("health.json", GetHealthHistory()); err != nil {
+		return err
+	}
+
+	err = add("goroutines.txt", func(f io.Writer) error {
+		return pprof.Lookup("goroutine").WriteTo(f, 2)
+	})
+	if err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// SupportBundleHandler serves a support bundle as a zip download
+func SupportBundleHandler(lb LoadBalancerStrategy, configPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		filename := fmt.Sprintf("golb-support-%s.zip", time.Now().UTC().Format("20060102-150405"))
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+		if err := WriteSupportBundle(w, lb, configPath); err != nil {
+			logger.Admin.Error("Failed to write support bundle", zap.Error(err))
+			return
+		}
+		logger.Admin.Info("Support bundle downloaded", zap.String("remote", r.RemoteAddr))
+	}
+}
@@ -0,0 +1,96 @@
+package balancer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// RequestTimeout bounds the time the balancer spends on a request. When the
+// budget runs out the request to the backend is canceled, closing its
+// connection, and the client gets a 504 with a JSON body, unless the response
+// has started.
+type RequestTimeout struct {
+	next    LoadBalancerStrategy
+	timeout time.Duration
+	// replace drops any deadline the request already has, so a route's
+	// timeout replaces the global one instead of only shortening it
+	replace bool
+}
+
+// NewRequestTimeout bounds the requests of a strategy by a timeout, or
+// returns it unchanged if the timeout is 0
+func NewRequestTimeout(next LoadBalancerStrategy, timeout time.Duration) LoadBalancerStrategy {
+	if timeout <= 0 {
+		return next
+	}
+	return &RequestTimeout{next: next, timeout: timeout}
+}
+
+// newRouteTimeout bounds the requests of a route by its own timeout, in
+// place of the global one
+func newRouteTimeout(next LoadBalancerStrategy, timeout time.Duration) LoadBalancerStrategy {
+	if timeout <= 0 {
+		return next
+	}
+	return &RequestTimeout{next: next, timeout: timeout, replace: true}
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (t *RequestTimeout) GetNextInstance(r *http.Request) (*url.URL, error) {
+	return t.next.GetNextInstance(r)
+}
+
+// ProxyRequest proxies the request within the timeout. WebSockets are not
+// bounded, as they stay open for as long as they are used.
+func (t *RequestTimeout) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	if IsWebSocketRequest(r) {
+		t.next.ProxyRequest(w, r)
+		return
+	}
+
+	parent, cancelParent := r.Context(), context.CancelFunc(func() {})
+	if t.replace {
+		parent, cancelParent = withoutDeadline(parent)
+	}
+	defer cancelParent()
+	ctx, cancel := context.WithTimeout(parent, t.timeout)
+	defer cancel()
+
+	tw := &errorPageWriter{ResponseWriter: w, codes: map[int]bool{http.StatusGatewayTimeout: true}}
+	t.next.ProxyRequest(tw, r.WithContext(ctx))
+	if !tw.intercepted {
+		return
+	}
+
+	// Other gateway timeouts, such as of a shorter deadline of the client,
+	// pass through as they are
+	if tw.Header().Get(RejectReasonHeader) != string(RejectDeadlineExceeded) || !errors.Is(ctx.Err(), context.DeadlineExceeded) || parent.Err() != nil {
+		w.WriteHeader(tw.status)
+		w.Write(tw.message.Bytes())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(http.StatusGatewayTimeout)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   "Request timed out",
+		"status":  http.StatusGatewayTimeout,
+		"reason":  RejectDeadlineExceeded,
+		"timeout": t.timeout.String(),
+	})
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (t *RequestTimeout) SupportsWebSockets() bool {
+	return t.next.SupportsWebSockets()
+}
+
+// Unwrap returns the wrapped strategy
+func (t *RequestTimeout) Unwrap() LoadBalancerStrategy {
+	return t.next
+}
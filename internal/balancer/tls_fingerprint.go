@@ -0,0 +1,137 @@
+package balancer
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type fingerprintContextKey struct{}
+
+// fingerprintHolder receives the TLS fingerprint once the handshake has run
+type fingerprintHolder struct {
+	mu          sync.RWMutex
+	fingerprint string
+}
+
+func (h *fingerprintHolder) set(fingerprint string) {
+	h.mu.Lock()
+	h.fingerprint = fingerprint
+	h.mu.Unlock()
+}
+
+func (h *fingerprintHolder) get() string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.fingerprint
+}
+
+// TLSFingerprinter records a JA3-style fingerprint of each client's TLS
+// ClientHello and makes it available to request handlers
+type TLSFingerprinter struct {
+	pending sync.Map
+}
+
+// NewTLSFingerprinter creates a TLS fingerprinter
+func NewTLSFingerprinter() *TLSFingerprinter {
+	return &TLSFingerprinter{}
+}
+
+// Configure hooks the fingerprinter into a TLS config and HTTP server
+func (f *TLSFingerprinter) Configure(tlsConfig *tls.Config, server *http.Server) {
+	base := tlsConfig.GetConfigForClient
+	tlsConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if holder, ok := f.pending.LoadAndDelete(hello.Conn); ok {
+			holder.(*fingerprintHolder).set(clientHelloFingerprint(hello))
+		}
+		if base != nil {
+			return base(hello)
+		}
+		return nil, nil
+	}
+
+	baseConnContext := server.ConnContext
+	server.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		if baseConnContext != nil {
+			ctx = baseConnContext(ctx, c)
+		}
+		if tlsConn, ok := c.(*tls.Conn); ok {
+			holder := &fingerprintHolder{}
+			f.pending.Store(tlsConn.NetConn(), holder)
+			ctx = context.WithValue(ctx, fingerprintContextKey{}, holder)
+		}
+		return ctx
+	}
+
+	baseConnState := server.ConnState
+	server.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateClosed || state == http.StateHijacked {
+			if tlsConn, ok := c.(*tls.Conn); ok {
+				f.pending.Delete(tlsConn.NetConn())
+			}
+		}
+		if baseConnState != nil {
+			baseConnState(c, state)
+		}
+	}
+}
+
+// TLSFingerprintFromRequest returns the TLS fingerprint of the connection the
+// request arrived on, or an empty string for plain HTTP connections
+func TLSFingerprintFromRequest(r *http.Request) string {
+	holder, ok := r.Context().Value(fingerprintContextKey{}).(*fingerprintHolder)
+	if !ok {
+		return ""
+	}
+	return holder.get()
+}
+
+// clientHelloFingerprint hashes the parts of a ClientHello that identify the
+// client's TLS stack, in the spirit of JA3
+func clientHelloFingerprint(hello *tls.ClientHelloInfo) string {
+	join := func(values []string) string {
+		return strings.Join(values, "-")
+	}
+
+	var versions, ciphers, curves, points []string
+	for _, v := range hello.SupportedVersions {
+		versions = append(versions, fmt.Sprint(v))
+	}
+	for _, c := range hello.CipherSuites {
+		ciphers = append(ciphers, fmt.Sprint(c))
+	}
+	for _, c := range hello.SupportedCurves {
+		curves = append(curves, fmt.Sprint(c))
+	}
+	for _, p := range hello.SupportedPoints {
+		points = append(points, fmt.Sprint(p))
+	}
+
+	raw := strings.Join([]string{join(versions), join(ciphers), join(curves), join(points)}, ",")
+	hash := md5.Sum([]byte(raw))
+	return hex.EncodeToString(hash[:])
+}
+
+// clientFingerprint identifies a client without cookies. It combines the
+// client IP with the TLS fingerprint, or with stable request headers when the
+// connection is not TLS. The fingerprint identifies the client's TLS stack,
+// not the client: users of the same browser version behind one NAT share a
+// fingerprint, and so a backend. TLS session IDs and tickets are not used, as
+// a client's first connection carries none and browsers rotate them.
+func clientFingerprint(r *http.Request) string {
+	fingerprint := TLSFingerprintFromRequest(r)
+	if fingerprint == "" {
+		fingerprint = strings.Join([]string{
+			r.Header.Get("User-Agent"),
+			r.Header.Get("Accept-Language"),
+			r.Header.Get("Accept-Encoding"),
+		}, "|")
+	}
+	return getClientIP(r) + "|" + fingerprint
+}
@@ -0,0 +1,156 @@
+package balancer
+
+import (
+	"crypto/tls"
+	"sync"
+)
+
+// TLSDistribution counts TLS connections by negotiated protocol version,
+// cipher suite and ALPN protocol
+type TLSDistribution struct {
+	Connections  int64            `json:"connections"`
+	Versions     map[string]int64 `json:"versions"`
+	CipherSuites map[string]int64 `json:"cipherSuites"`
+	ALPN         map[string]int64 `json:"alpn"`
+}
+
+// TLSStats holds the TLS parameters negotiated with clients, where TLS
+// terminates at the load balancer, and with backends. They tell how much
+// traffic would break if an old TLS version or cipher suite was turned off.
+type TLSStats struct {
+	Client TLSDistribution `json:"client"`
+	// ClientMaxVersions counts client handshakes by the highest version the
+	// client offered, including handshakes that failed
+	ClientMaxVersions map[string]int64 `json:"clientMaxVersions"`
+	// ClientOfferedALPN counts client handshakes by each ALPN protocol the
+	// client offered, whether or not it was negotiated
+	ClientOfferedALPN map[string]int64 `json:"clientOfferedAlpn"`
+	Backend           TLSDistribution  `json:"backend"`
+}
+
+var (
+	tlsStats   = newTLSStats()
+	tlsStatsMu sync.Mutex
+)
+
+func newTLSStats() TLSStats {
+	return TLSStats{
+		Client:            newTLSDistribution(),
+		ClientMaxVersions: make(map[string]int64),
+		ClientOfferedALPN: make(map[string]int64),
+		Backend:           newTLSDistribution(),
+	}
+}
+
+func newTLSDistribution() TLSDistribution {
+	return TLSDistribution{
+		Versions:     make(map[string]int64),
+		CipherSuites: make(map[string]int64),
+		ALPN:         make(map[string]int64),
+	}
+}
+
+// count adds a connection with the given negotiated parameters
+func (d *TLSDistribution) count(state tls.ConnectionState) {
+	d.Connections++
+	d.Versions[tls.VersionName(state.Version)]++
+	d.CipherSuites[tls.CipherSuiteName(state.CipherSuite)]++
+	d.ALPN[alpnName(state.NegotiatedProtocol)]++
+}
+
+func (d TLSDistribution) copy() TLSDistribution {
+	return TLSDistribution{
+		Connections:  d.Connections,
+		Versions:     copyCounts(d.Versions),
+		CipherSuites: copyCounts(d.CipherSuites),
+		ALPN:         copyCounts(d.ALPN),
+	}
+}
+
+func copyCounts(counts map[string]int64) map[string]int64 {
+	copied := make(map[string]int64, len(counts))
+	for key, count := range counts {
+		copied[key] = count
+	}
+	return copied
+}
+
+// alpnName names a negotiated ALPN protocol; none means the client did not
+// offer ALPN or offered no protocol in common
+func alpnName(protocol string) string {
+	if protocol == "" {
+		return "none"
+	}
+	return protocol
+}
+
+// GetTLSStats returns the distribution of the TLS parameters negotiated
+// since startup
+func GetTLSStats() TLSStats {
+	tlsStatsMu.Lock()
+	defer tlsStatsMu.Unlock()
+
+	return TLSStats{
+		Client:            tlsStats.Client.copy(),
+		ClientMaxVersions: copyCounts(tlsStats.ClientMaxVersions),
+		ClientOfferedALPN: copyCounts(tlsStats.ClientOfferedALPN),
+		Backend:           tlsStats.Backend.copy(),
+	}
+}
+
+// CountClientTLS hooks into the TLS config of a listener to count the
+// parameters of every client handshake
+func CountClientTLS(tlsConfig *tls.Config) {
+	baseGetConfig := tlsConfig.GetConfigForClient
+	tlsConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		countClientHello(hello)
+		if baseGetConfig != nil {
+			return baseGetConfig(hello)
+		}
+		return nil, nil
+	}
+
+	baseVerify := tlsConfig.VerifyConnection
+	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+		tlsStatsMu.Lock()
+		tlsStats.Client.count(state)
+		tlsStatsMu.Unlock()
+
+		if baseVerify != nil {
+			return baseVerify(state)
+		}
+		return nil
+	}
+}
+
+// countClientHello counts the highest TLS version and the ALPN protocols a
+// client offers
+func countClientHello(hello *tls.ClientHelloInfo) {
+	var maxVersion uint16
+	for _, version := range hello.SupportedVersions {
+		maxVersion = max(maxVersion, version)
+	}
+
+	tlsStatsMu.Lock()
+	defer tlsStatsMu.Unlock()
+
+	if maxVersion != 0 {
+		tlsStats.ClientMaxVersions[tls.VersionName(maxVersion)]++
+	}
+	if len(hello.SupportedProtos) == 0 {
+		tlsStats.ClientOfferedALPN[alpnName("")]++
+	}
+	for _, protocol := range hello.SupportedProtos {
+		tlsStats.ClientOfferedALPN[protocol]++
+	}
+}
+
+// countBackendTLS counts the parameters of a handshake with a backend. It
+// runs as the VerifyConnection callback of backend TLS configs, after the
+// certificate checks, and never fails the handshake.
+func countBackendTLS(state tls.ConnectionState) error {
+	tlsStatsMu.Lock()
+	tlsStats.Backend.count(state)
+	tlsStatsMu.Unlock()
+	return nil
+}
@@ -0,0 +1,500 @@
+package balancer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// TracingConfig holds the settings of the tracing block
+type TracingConfig struct {
+	Enabled     bool
+	Endpoint    string
+	ServiceName string
+	SampleRate  float64
+	Propagation []string
+	Headers     map[string]string
+}
+
+// parseTracingDirective applies a directive inside a tracing block
+func parseTracingDirective(cfg *TracingConfig, parts []string) error {
+	if len(parts) < 2 {
+		return fmt.Errorf("%s directive requires a value", parts[0])
+	}
+
+	switch parts[0] {
+	case "endpoint":
+		cfg.Endpoint = parts[1]
+	case "service_name":
+		cfg.ServiceName = parts[1]
+	case "sample_rate":
+		rate, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return fmt.Errorf("invalid sample_rate: %s", parts[1])
+		}
+		cfg.SampleRate = rate
+	case "propagation":
+		cfg.Propagation = nil
+		for _, p := range parts[1:] {
+			if p != "w3c" && p != "b3" {
+				return fmt.Errorf("unknown propagation format: %s", p)
+			}
+			cfg.Propagation = append(cfg.Propagation, p)
+		}
+	case "header":
+		if len(parts) < 3 {
+			return fmt.Errorf("header directive requires a name and value")
+		}
+		cfg.Headers[parts[1]] = strings.Join(parts[2:], " ")
+	default:
+		return fmt.Errorf("unknown tracing directive: %s", parts[0])
+	}
+
+	return nil
+}
+
+// Span is a single traced request
+type Span struct {
+	TraceID      [16]byte
+	SpanID       [8]byte
+	ParentSpanID [8]byte
+	Sampled      bool
+	Name         string
+	Start        time.Time
+	End          time.Time
+	mu           sync.Mutex
+	attributes   map[string]interface{}
+	status       int
+}
+
+// SetAttribute records an attribute on the span
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	s.attributes[key] = value
+	s.mu.Unlock()
+}
+
+func (s *Span) incrementAttribute(key string) {
+	s.mu.Lock()
+	count, _ := s.attributes[key].(int)
+	s.attributes[key] = count + 1
+	s.mu.Unlock()
+}
+
+type spanContextKey struct{}
+
+// SpanFromRequest returns the span of a traced request, or nil
+func SpanFromRequest(r *http.Request) *Span {
+	span, _ := r.Context().Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// annotateBackend records the backend chosen for a request on its span,
+// access log record and attempt history
+func annotateBackend(r *http.Request, backend *url.URL) {
+	if span := SpanFromRequest(r); span != nil {
+		span.SetAttribute("lb.backend", backend.String())
+	}
+	if record := accessRecordFromRequest(r); record != nil {
+		record.mu.Lock()
+		record.backend = backend.String()
+		record.mu.Unlock()
+	}
+	if recorder := requestInfoFromRequest(r); recorder != nil {
+		recorder.mu.Lock()
+		recorder.info.Attempts = append(recorder.info.Attempts, Attempt{Backend: backend.String(), Start: time.Now()})
+		recorder.mu.Unlock()
+	}
+}
+
+// annotateRetry counts a retry attempt on the span and access log record of
+// a request
+func annotateRetry(r *http.Request) {
+	if span := SpanFromRequest(r); span != nil {
+		span.incrementAttribute("lb.retries")
+	}
+	if record := accessRecordFromRequest(r); record != nil {
+		record.mu.Lock()
+		record.retries++
+		record.mu.Unlock()
+	}
+}
+
+// annotateHedge counts a copy of a slow request sent to another backend on
+// the span and access log record of the request
+func annotateHedge(r *http.Request) {
+	if span := SpanFromRequest(r); span != nil {
+		span.incrementAttribute("lb.hedges")
+	}
+	if record := accessRecordFromRequest(r); record != nil {
+		record.mu.Lock()
+		record.hedges++
+		record.mu.Unlock()
+	}
+}
+
+// Tracer creates spans and exports them to an OTLP/HTTP collector
+type Tracer struct {
+	config TracingConfig
+	spans  chan *Span
+	// stop ends the exporter and closed stops queuing spans. The spans
+	// channel is never closed, as requests outliving the shutdown, such as
+	// WebSockets, still finish their spans.
+	stop     chan struct{}
+	stopOnce sync.Once
+	closed   atomic.Bool
+	done     chan struct{}
+	client   *http.Client
+}
+
+// NewTracer creates a tracer and starts its exporter, or returns nil if
+// tracing is disabled
+func NewTracer(config TracingConfig) *Tracer {
+	if !config.Enabled {
+		return nil
+	}
+	if config.ServiceName == "" {
+		config.ServiceName = "go-load-balancer"
+	}
+	if len(config.Propagation) == 0 {
+		config.Propagation = []string{"w3c"}
+	}
+
+	t := &Tracer{
+		config: config,
+		spans:  make(chan *Span, 4096),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	go t.exportLoop()
+	return t
+}
+
+// startSpan starts a span continuing any trace propagated by the client
+func (t *Tracer) startSpan(r *http.Request) *Span {
+	span := &Span{
+		Name:       r.Method + " " + r.URL.Path,
+		Start:      time.Now(),
+		attributes: make(map[string]interface{}),
+	}
+
+	if traceID, parentID, sampled, ok := extractTraceContext(r.Header); ok {
+		span.TraceID = traceID
+		span.ParentSpanID = parentID
+		span.Sampled = sampled
+	} else {
+		rand.Read(span.TraceID[:])
+		span.Sampled = sampleTrace(span.TraceID, t.config.SampleRate)
+	}
+	rand.Read(span.SpanID[:])
+
+	return span
+}
+
+// sampleTrace makes a deterministic sampling decision from the trace ID
+func sampleTrace(traceID [16]byte, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	var value uint64
+	for _, b := range traceID[8:] {
+		value = value<<8 | uint64(b)
+	}
+	return float64(value>>11)/float64(1<<53) < rate
+}
+
+// extractTraceContext reads a W3C traceparent or B3 trace context
+func extractTraceContext(header http.Header) ([16]byte, [8]byte, bool, bool) {
+	var traceID [16]byte
+	var spanID [8]byte
+
+	decode := func(traceHex, spanHex string) bool {
+		if len(traceHex) == 16 {
+			traceHex = strings.Repeat("0", 16) + traceHex
+		}
+		t, err1 := hex.DecodeString(traceHex)
+		s, err2 := hex.DecodeString(spanHex)
+		if err1 != nil || err2 != nil || len(t) != 16 || len(s) != 8 {
+			return false
+		}
+		copy(traceID[:], t)
+		copy(spanID[:], s)
+		return true
+	}
+
+	if tp := header.Get("traceparent"); tp != "" {
+		parts := strings.Split(tp, "-")
+		if len(parts) == 4 && decode(parts[1], parts[2]) {
+			flags, err := strconv.ParseUint(parts[3], 16, 8)
+			return traceID, spanID, err == nil && flags&1 == 1, true
+		}
+	}
+
+	if b3 := header.Get("b3"); b3 != "" {
+		parts := strings.Split(b3, "-")
+		if len(parts) >= 2 && decode(parts[0], parts[1]) {
+			sampled := len(parts) < 3 || parts[2] == "1" || parts[2] == "d"
+			return traceID, spanID, sampled, true
+		}
+	}
+
+	if decode(header.Get("X-B3-TraceId"), header.Get("X-B3-SpanId")) {
+		sampled := header.Get("X-B3-Sampled") != "0"
+		return traceID, spanID, sampled, true
+	}
+
+	return traceID, spanID, false, false
+}
+
+// injectTraceContext writes the span's trace context to the upstream headers
+func (t *Tracer) injectTraceContext(header http.Header, span *Span) {
+	traceID := hex.EncodeToString(span.TraceID[:])
+	spanID := hex.EncodeToString(span.SpanID[:])
+	sampled := "0"
+	if span.Sampled {
+		sampled = "1"
+	}
+
+	for _, format := range t.config.Propagation {
+		switch format {
+		case "w3c":
+			header.Set("traceparent", fmt.Sprintf("00-%s-%s-0%s", traceID, spanID, sampled))
+		case "b3":
+			header.Set("b3", fmt.Sprintf("%s-%s-%s", traceID, spanID, sampled))
+			header.Set("X-B3-TraceId", traceID)
+			header.Set("X-B3-SpanId", spanID)
+			header.Set("X-B3-Sampled", sampled)
+			if span.ParentSpanID != [8]byte{} {
+				header.Set("X-B3-ParentSpanId", hex.EncodeToString(span.ParentSpanID[:]))
+			}
+		}
+	}
+}
+
+func (t *Tracer) finish(span *Span) {
+	span.End = time.Now()
+	if !span.Sampled || t.closed.Load() {
+		return
+	}
+
+	select {
+	case t.spans <- span:
+	default:
+		logger.Log.Warn("Trace export queue full, dropping span")
+	}
+}
+
+// Shutdown flushes pending spans and stops the exporter. Spans finished
+// afterwards are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	t.closed.Store(true)
+	t.stopOnce.Do(func() { close(t.stop) })
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+	}
+}
+
+func (t *Tracer) exportLoop() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	var batch []*Span
+	for {
+		select {
+		case span := <-t.spans:
+			batch = append(batch, span)
+			if len(batch) >= 512 {
+				t.export(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			t.export(batch)
+			batch = nil
+		case <-t.stop:
+			// Flush the spans queued before the shutdown
+			for {
+				select {
+				case span := <-t.spans:
+					batch = append(batch, span)
+				default:
+					t.export(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func otlpAttribute(key string, value interface{}) map[string]interface{} {
+	var v map[string]interface{}
+	switch typed := value.(type) {
+	case int:
+		v = map[string]interface{}{"intValue": strconv.Itoa(typed)}
+	case bool:
+		v = map[string]interface{}{"boolValue": typed}
+	default:
+		v = map[string]interface{}{"stringValue": fmt.Sprint(typed)}
+	}
+	return map[string]interface{}{"key": key, "value": v}
+}
+
+// export sends a batch of spans using the OTLP/HTTP JSON encoding
+func (t *Tracer) export(batch []*Span) {
+	if len(batch) == 0 || t.config.Endpoint == "" {
+		return
+	}
+
+	spans := make([]map[string]interface{}, 0, len(batch))
+	for _, span := range batch {
+		span.mu.Lock()
+		attributes := make([]map[string]interface{}, 0, len(span.attributes))
+		for key, value := range span.attributes {
+			attributes = append(attributes, otlpAttribute(key, value))
+		}
+		status := span.status
+		span.mu.Unlock()
+
+		statusCode := 1 // OK
+		if status >= 500 {
+			statusCode = 2 // ERROR
+		}
+
+		encoded := map[string]interface{}{
+			"traceId":           hex.EncodeToString(span.TraceID[:]),
+			"spanId":            hex.EncodeToString(span.SpanID[:]),
+			"name":              span.Name,
+			"kind":              2, // SERVER
+			"startTimeUnixNano": strconv.FormatInt(span.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.End.UnixNano(), 10),
+			"attributes":        attributes,
+			"status":            map[string]interface{}{"code": statusCode},
+		}
+		if span.ParentSpanID != [8]byte{} {
+			encoded["parentSpanId"] = hex.EncodeToString(span.ParentSpanID[:])
+		}
+		spans = append(spans, encoded)
+	}
+
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []interface{}{otlpAttribute("service.name", t.config.ServiceName)},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "go-load-balancer"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logger.Log.Error("Failed to encode spans", zap.Error(err))
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		logger.Log.Error("Failed to create trace export request", zap.Error(err))
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range t.config.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		logger.Log.Warn("Failed to export spans", zap.Error(err))
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		logger.Log.Warn("Trace collector rejected spans", zap.Int("status", resp.StatusCode))
+	}
+}
+
+// TracingMiddleware starts a span for every proxied request and propagates
+// the trace context to the backends
+type TracingMiddleware struct {
+	next   LoadBalancerStrategy
+	tracer *Tracer
+}
+
+// NewTracingMiddleware wraps a strategy with tracing.
+// The strategy is returned unchanged if the tracer is nil.
+func NewTracingMiddleware(next LoadBalancerStrategy, tracer *Tracer) LoadBalancerStrategy {
+	if tracer == nil {
+		return next
+	}
+	return &TracingMiddleware{
+		next:   next,
+		tracer: tracer,
+	}
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (tm *TracingMiddleware) GetNextInstance(r *http.Request) (*url.URL, error) {
+	return tm.next.GetNextInstance(r)
+}
+
+// ProxyRequest proxies the request inside a span
+func (tm *TracingMiddleware) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	if !featureEnabled(FeatureTracing) {
+		tm.next.ProxyRequest(w, r)
+		return
+	}
+
+	span := tm.tracer.startSpan(r)
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.target", r.URL.RequestURI())
+	span.SetAttribute("http.client_ip", getClientIP(r))
+
+	r = r.Clone(context.WithValue(r.Context(), spanContextKey{}, span))
+	tm.tracer.injectTraceContext(r.Header, span)
+
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	tm.next.ProxyRequest(recorder, r)
+
+	span.SetAttribute("http.status_code", recorder.status)
+	span.mu.Lock()
+	span.status = recorder.status
+	span.mu.Unlock()
+
+	tm.tracer.finish(span)
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (tm *TracingMiddleware) SupportsWebSockets() bool {
+	return tm.next.SupportsWebSockets()
+}
+
+// Unwrap returns the wrapped strategy
+func (tm *TracingMiddleware) Unwrap() LoadBalancerStrategy {
+	return tm.next
+}
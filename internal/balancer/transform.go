@@ -0,0 +1,333 @@
+package balancer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+// defaultTransformMaxSize is the largest response body transformed when the
+// transform sets no max_size
+const defaultTransformMaxSize = 1 << 20
+
+var defaultTransformTypes = []string{"text/html"}
+
+// TransformOp is a single rewrite of a response body
+type TransformOp struct {
+	// Kind is replace, regex or inject
+	Kind string
+	// From is the string replaced, the pattern matched, or where HTML is
+	// injected: head, body_start or body_end
+	From string
+	// To is the replacement or the injected HTML
+	To string
+
+	pattern *regexp.Regexp
+}
+
+// ResponseTransformConfig holds a named set of rewrites of the response
+// bodies of the routes using it
+type ResponseTransformConfig struct {
+	Name string
+	Ops  []TransformOp
+	// Types are the media types transformed; a type ending in /* matches
+	// all of its subtypes
+	Types []string
+	// MaxSize is the largest body transformed; larger bodies pass through
+	MaxSize int64
+}
+
+// parseResponseTransform parses a response_transform directive into the
+// transform it adds a rewrite or options to. Arguments holding spaces are
+// quoted.
+func parseResponseTransform(line string, transforms map[string]ResponseTransformConfig) (ResponseTransformConfig, error) {
+	parts, err := quotedFields(strings.TrimSuffix(line, ";"))
+	if err != nil {
+		return ResponseTransformConfig{}, err
+	}
+	if len(parts) < 3 {
+		return ResponseTransformConfig{}, fmt.Errorf("response_transform directive requires a name and a rewrite or option")
+	}
+
+	config, ok := transforms[parts[1]]
+	if !ok {
+		config = ResponseTransformConfig{Name: parts[1], Types: defaultTransformTypes, MaxSize: defaultTransformMaxSize}
+	}
+
+	args := parts[2:]
+	for len(args) > 0 {
+		arg := args[0]
+		switch {
+		case strings.HasPrefix(arg, "types="):
+			config.Types = strings.Split(strings.TrimPrefix(arg, "types="), ",")
+			args = args[1:]
+		case strings.HasPrefix(arg, "max_size="):
+			size, err := parseByteSize(strings.TrimPrefix(arg, "max_size="))
+			if err != nil || size <= 0 {
+				return ResponseTransformConfig{}, fmt.Errorf("invalid response_transform max_size: %s", strings.TrimPrefix(arg, "max_size="))
+			}
+			config.MaxSize = size
+			args = args[1:]
+		case arg == "replace" || arg == "regex" || arg == "inject":
+			if len(args) < 3 {
+				return ResponseTransformConfig{}, fmt.Errorf("%s rewrite requires two arguments", arg)
+			}
+			op := TransformOp{Kind: arg, From: args[1], To: args[2]}
+			switch arg {
+			case "replace":
+				if op.From == "" {
+					return ResponseTransformConfig{}, fmt.Errorf("replace rewrite requires a string to replace")
+				}
+			case "regex":
+				if op.pattern, err = regexp.Compile(op.From); err != nil {
+					return ResponseTransformConfig{}, fmt.Errorf("invalid regex rewrite: %v", err)
+				}
+			case "inject":
+				if op.From != "head" && op.From != "body_start" && op.From != "body_end" {
+					return ResponseTransformConfig{}, fmt.Errorf("invalid inject position, expected head, body_start or body_end: %s", op.From)
+				}
+			}
+			config.Ops = append(config.Ops, op)
+			args = args[3:]
+		default:
+			return ResponseTransformConfig{}, fmt.Errorf("unknown response_transform option: %s", arg)
+		}
+	}
+
+	return config, nil
+}
+
+// quotedFields splits a line on spaces, keeping the spaces of the parts
+// quoted with double or single quotes
+func quotedFields(line string) ([]string, error) {
+	var fields []string
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return fields, nil
+		}
+		if quote := line[0]; quote == '"' || quote == '\'' {
+			end := strings.IndexByte(line[1:], quote)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quoted argument: %s", line)
+			}
+			fields = append(fields, line[1:end+1])
+			line = line[end+2:]
+			continue
+		}
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			end = len(line)
+		}
+		fields = append(fields, line[:end])
+		line = line[end:]
+	}
+}
+
+// rewrite applies the rewrites to a body in order
+func (c ResponseTransformConfig) rewrite(body []byte) []byte {
+	for _, op := range c.Ops {
+		switch op.Kind {
+		case "replace":
+			body = bytes.ReplaceAll(body, []byte(op.From), []byte(op.To))
+		case "regex":
+			body = op.pattern.ReplaceAll(body, []byte(op.To))
+		case "inject":
+			body = injectHTML(body, op.From, []byte(op.To))
+		}
+	}
+	return body
+}
+
+var (
+	headEndTag   = regexp.MustCompile(`(?i)</head\s*>`)
+	bodyStartTag = regexp.MustCompile(`(?i)<body(\s[^>]*)?>`)
+	bodyEndTag   = regexp.MustCompile(`(?i)</body\s*>`)
+)
+
+// injectHTML puts HTML at the end of the head, right after the opening body
+// tag or right before the closing one. Pages without the tag are left alone.
+func injectHTML(body []byte, position string, html []byte) []byte {
+	var at int
+	switch position {
+	case "head":
+		loc := headEndTag.FindIndex(body)
+		if loc == nil {
+			return body
+		}
+		at = loc[0]
+	case "body_start":
+		loc := bodyStartTag.FindIndex(body)
+		if loc == nil {
+			return body
+		}
+		at = loc[1]
+	case "body_end":
+		locs := bodyEndTag.FindAllIndex(body, -1)
+		if locs == nil {
+			return body
+		}
+		at = locs[len(locs)-1][0]
+	}
+
+	injected := make([]byte, 0, len(body)+len(html))
+	injected = append(injected, body[:at]...)
+	injected = append(injected, html...)
+	return append(injected, body[at:]...)
+}
+
+// TransformStats counts the responses of a response transform
+type TransformStats struct {
+	Transformed int64 `json:"transformed"`
+	// Oversize counts the responses passed through because their body was
+	// larger than the transform's max_size
+	Oversize int64 `json:"oversize"`
+}
+
+var responseTransformStats sync.Map
+
+func transformStats(name string) *TransformStats {
+	stats, _ := responseTransformStats.LoadOrStore(name, &TransformStats{})
+	return stats.(*TransformStats)
+}
+
+// GetTransformStats returns the response counts of every transform
+// that saw a response
+func GetTransformStats() map[string]TransformStats {
+	result := make(map[string]TransformStats)
+	responseTransformStats.Range(func(name, value interface{}) bool {
+		stats := value.(*TransformStats)
+		result[name.(string)] = TransformStats{
+			Transformed: atomic.LoadInt64(&stats.Transformed),
+			Oversize:    atomic.LoadInt64(&stats.Oversize),
+		}
+		return true
+	})
+	return result
+}
+
+// transformResponse rewrites the body of a backend response before it is
+// sent on. Bodies that are encoded, of another media type or larger than
+// max_size pass through unchanged.
+func (c ResponseTransformConfig) transformResponse(resp *http.Response) error {
+	if resp.Body == nil || resp.Body == http.NoBody || resp.Request.Method == http.MethodHead ||
+		resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return nil
+	}
+	if !mediaTypeMatches(resp.Header.Get("Content-Type"), c.Types) {
+		return nil
+	}
+
+	stats := transformStats(c.Name)
+	if resp.ContentLength > c.MaxSize {
+		atomic.AddInt64(&stats.Oversize, 1)
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, c.MaxSize+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > c.MaxSize {
+		atomic.AddInt64(&stats.Oversize, 1)
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return nil
+	}
+	resp.Body.Close()
+
+	transformed := c.rewrite(body)
+	if !bytes.Equal(transformed, body) {
+		// The validators describe the body the backend sent
+		resp.Header.Del("ETag")
+		resp.Header.Del("Content-MD5")
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(transformed))
+	resp.ContentLength = int64(len(transformed))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(transformed)))
+	resp.TransferEncoding = nil
+	atomic.AddInt64(&stats.Transformed, 1)
+	return nil
+}
+
+type responseTransformContextKey struct{}
+
+// ResponseTransformer rewrites the response bodies of a route, such as to
+// replace internal URLs or inject a banner into HTML pages. Bodies are
+// rewritten as they come from the backend, in the proxy's ModifyResponse.
+type ResponseTransformer struct {
+	next   LoadBalancerStrategy
+	config ResponseTransformConfig
+}
+
+// NewResponseTransformer wraps a route's strategy with response rewrites.
+// The strategy is returned unchanged if no transform is configured.
+func NewResponseTransformer(next LoadBalancerStrategy, config ResponseTransformConfig) LoadBalancerStrategy {
+	if len(config.Ops) == 0 {
+		return next
+	}
+	return &ResponseTransformer{
+		next:   next,
+		config: config,
+	}
+}
+
+// GetNextInstance implements the LoadBalancerStrategy interface
+func (t *ResponseTransformer) GetNextInstance(r *http.Request) (*url.URL, error) {
+	return t.next.GetNextInstance(r)
+}
+
+// ProxyRequest proxies the request, asking the backend for a body the
+// transport can decompress so it can be rewritten
+func (t *ResponseTransformer) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	if IsWebSocketRequest(r) {
+		t.next.ProxyRequest(w, r)
+		return
+	}
+
+	r = r.Clone(context.WithValue(r.Context(), responseTransformContextKey{}, &t.config))
+	r.Header.Del("Accept-Encoding")
+	t.next.ProxyRequest(w, r)
+}
+
+// SupportsWebSockets implements the LoadBalancerStrategy interface
+func (t *ResponseTransformer) SupportsWebSockets() bool {
+	return t.next.SupportsWebSockets()
+}
+
+// Unwrap returns the wrapped strategy
+func (t *ResponseTransformer) Unwrap() LoadBalancerStrategy {
+	return t.next
+}
+
+// transformResponseFor rewrites a backend response with the transform of
+// the route a request came through, if any
+func transformResponseFor(r *http.Request, resp *http.Response) error {
+	config, _ := r.Context().Value(responseTransformContextKey{}).(*ResponseTransformConfig)
+	if config == nil {
+		return nil
+	}
+	if err := config.transformResponse(resp); err != nil {
+		logger.Proxy.Warn("Failed to read response for transform",
+			zap.String("transform", config.Name),
+			zap.Error(err))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package balancer
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// inheritedSocketsEnv lists the sockets handed over by the process being
+	// upgraded as name=fd pairs, e.g. "http=3,admin=4"
+	inheritedSocketsEnv = "GOLB_INHERITED_SOCKETS"
+	// upgradeParentEnv holds the pid of the process being upgraded
+	upgradeParentEnv = "GOLB_UPGRADE_PARENT"
+)
+
+// socketFiler is implemented by the listeners and packet connections that can
+// be handed over to a new process
+type socketFiler interface {
+	File() (*os.File, error)
+}
+
+// SocketHandover lets a new binary take over the listening sockets of a
+// running one, the way nginx and HAProxy upgrade without dropping
+// connections. Upgrade starts the new binary with the sockets as inherited
+// file descriptors; once it serves, Ready asks the old process to shut down,
+// which then drains its in-flight requests as on any other shutdown.
+type SocketHandover struct {
+	mu        sync.Mutex
+	inherited map[string]*os.File
+	sockets   map[string]socketFiler
+	names     []string
+	parent    int
+}
+
+// NewSocketHandover creates a handover, picking up the sockets inherited
+// from the process being upgraded, if any
+func NewSocketHandover() *SocketHandover {
+	h := &SocketHandover{
+		inherited: make(map[string]*os.File),
+		sockets:   make(map[string]socketFiler),
+	}
+
+	for _, pair := range strings.Split(os.Getenv(inheritedSocketsEnv), ",") {
+		name, fdStr, found := strings.Cut(pair, "=")
+		if !found {
+			continue
+		}
+		fd, err := strconv.Atoi(fdStr)
+		if err != nil || fd < 3 {
+			logger.Log.Warn("Ignoring invalid inherited socket", zap.String("socket", pair))
+			continue
+		}
+		h.inherited[name] = os.NewFile(uintptr(fd), name)
+	}
+	h.parent, _ = strconv.Atoi(os.Getenv(upgradeParentEnv))
+
+	// Processes started by this one must not inherit the settings
+	os.Unsetenv(inheritedSocketsEnv)
+	os.Unsetenv(upgradeParentEnv)
+
+	return h
+}
+
+// Listen returns the inherited TCP listener with the given name, or listens
+// on addr if there is none
+func (h *SocketHandover) Listen(name, addr string) (net.Listener, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var listener net.Listener
+	var err error
+	if file, ok := h.inherited[name]; ok {
+		listener, err = net.FileListener(file)
+		file.Close()
+		delete(h.inherited, name)
+		if err == nil {
+			logger.Log.Info("Inherited listener", zap.String("name", name), zap.String("addr", listener.Addr().String()))
+		}
+	} else {
+		listener, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if filer, ok := listener.(socketFiler); ok {
+		h.track(name, filer)
+	}
+	return listener, nil
+}
+
+// ListenPacket returns the inherited UDP socket with the given name, or
+// listens on addr if there is none
+func (h *SocketHandover) ListenPacket(name, addr string) (net.PacketConn, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var conn net.PacketConn
+	var err error
+	if file, ok := h.inherited[name]; ok {
+		conn, err = net.FilePacketConn(file)
+		file.Close()
+		delete(h.inherited, name)
+	} else {
+		conn, err = net.ListenPacket("udp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if filer, ok := conn.(socketFiler); ok {
+		h.track(name, filer)
+	}
+	return conn, nil
+}
+
+func (h *SocketHandover) track(name string, filer socketFiler) {
+	if _, ok := h.sockets[name]; !ok {
+		h.names = append(h.names, name)
+	}
+	h.sockets[name] = filer
+}
+
+// Upgrade starts the current binary again with the same arguments, handing
+// it every socket opened through the handover
+func (h *SocketHandover) Upgrade() (*os.Process, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	files := []*os.File{os.Stdin, os.Stdout, os.Stderr}
+	defer func() {
+		for _, file := range files[3:] {
+			file.Close()
+		}
+	}()
+
+	var pairs []string
+	for _, name := range h.names {
+		file, err := h.sockets[name].File()
+		if err != nil {
+			return nil, fmt.Errorf("socket %s cannot be handed over: %v", name, err)
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%d", name, len(files)))
+		files = append(files, file)
+	}
+
+	env := append(os.Environ(),
+		inheritedSocketsEnv+"="+strings.Join(pairs, ","),
+		upgradeParentEnv+"="+strconv.Itoa(os.Getpid()))
+
+	return os.StartProcess(executable, os.Args, &os.ProcAttr{
+		Env:   env,
+		Files: files,
+	})
+}
+
+// Ready closes the inherited sockets that were not taken over and, if this
+// process is an upgrade, asks the process it replaces to shut down
+func (h *SocketHandover) Ready() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for name, file := range h.inherited {
+		file.Close()
+		delete(h.inherited, name)
+	}
+
+	if h.parent == 0 {
+		return nil
+	}
+
+	parent, err := os.FindProcess(h.parent)
+	if err != nil {
+		return err
+	}
+	h.parent = 0
+
+	return parent.Signal(syscall.SIGTERM)
+}
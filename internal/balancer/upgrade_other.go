@@ -0,0 +1,11 @@
+//go:build !unix
+
+package balancer
+
+import "os"
+
+// UpgradeSignals returns the signals that trigger a binary upgrade. Socket
+// handover is not supported on this platform.
+func UpgradeSignals() []os.Signal {
+	return nil
+}
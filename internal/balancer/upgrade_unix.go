@@ -0,0 +1,13 @@
+//go:build unix
+
+package balancer
+
+import (
+	"os"
+	"syscall"
+)
+
+// UpgradeSignals returns the signals that trigger a binary upgrade
+func UpgradeSignals() []os.Signal {
+	return []os.Signal{syscall.SIGUSR2}
+}
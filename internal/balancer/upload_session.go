@@ -0,0 +1,116 @@
+package balancer
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// uploadSessions pins every request of an upload session to the backend that
+// received its first request, for backends that stage multi-request uploads
+// on local disk. A session expires once no request used it for the TTL.
+type uploadSessions struct {
+	header    string
+	query     string
+	ttl       time.Duration
+	mu        sync.Mutex
+	pins      map[string]uploadPin
+	lastSweep time.Time
+}
+
+type uploadPin struct {
+	backend *Process
+	expires time.Time
+}
+
+// newUploadSessions creates the upload session table from the persistence
+// attributes, reading the session identifier from a header, a query
+// parameter, or both
+func newUploadSessions(attrs map[string]string) (*uploadSessions, error) {
+	sessions := &uploadSessions{
+		header: attrs["upload_header"],
+		query:  attrs["upload_query"],
+		ttl:    time.Hour,
+		pins:   make(map[string]uploadPin),
+	}
+	if sessions.header == "" && sessions.query == "" {
+		sessions.header = "X-Upload-Session"
+	}
+
+	if ttlStr, ok := attrs["upload_ttl"]; ok {
+		ttl, err := time.ParseDuration(ttlStr)
+		if err != nil || ttl <= 0 {
+			return nil, ErrInvalidConfig{Message: "invalid upload session ttl: " + ttlStr}
+		}
+		sessions.ttl = ttl
+	}
+
+	return sessions, nil
+}
+
+// sessionID returns the upload session of a request, or "" if it has none
+func (u *uploadSessions) sessionID(r *http.Request) string {
+	if u.header != "" {
+		if id := r.Header.Get(u.header); id != "" {
+			return id
+		}
+	}
+	if u.query != "" {
+		return r.URL.Query().Get(u.query)
+	}
+	return ""
+}
+
+// lookup returns the backend an upload session is pinned to, or nil
+func (u *uploadSessions) lookup(id string) *Process {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	pin, ok := u.pins[id]
+	if !ok || time.Now().After(pin.expires) {
+		return nil
+	}
+	return pin.backend
+}
+
+// pin binds an upload session to a backend, extending it by the TTL
+func (u *uploadSessions) pin(id string, backend *Process) {
+	now := time.Now()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.pins[id] = uploadPin{backend: backend, expires: now.Add(u.ttl)}
+
+	// Forget expired sessions once per TTL
+	if now.Sub(u.lastSweep) >= u.ttl {
+		u.lastSweep = now
+		for id, pin := range u.pins {
+			if now.After(pin.expires) {
+				delete(u.pins, id)
+			}
+		}
+	}
+}
+
+// migrate moves the upload sessions pinned to a backend to the backends
+// chosen by pick and returns how many moved. Sessions pick has no backend
+// for are forgotten.
+func (u *uploadSessions) migrate(from *Process, pick func() *Process) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	moved := 0
+	for id, pin := range u.pins {
+		if pin.backend != from {
+			continue
+		}
+		if pin.backend = pick(); pin.backend == nil {
+			delete(u.pins, id)
+			continue
+		}
+		u.pins[id] = pin
+		moved++
+	}
+	return moved
+}
@@ -0,0 +1,122 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// resolveTimeout bounds how long validation waits to resolve a backend host
+const resolveTimeout = 5 * time.Second
+
+// ValidateConfig checks a configuration file the way the load balancer would
+// load it, without starting anything: it parses the file, builds the pools
+// and routes, compiling regex routes, checks every backend URL and, with
+// resolve, that its host resolves. It returns every error found, in line
+// order, rather than the first.
+func ValidateConfig(filename string, resolve bool) []error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return []error{err}
+	}
+	lines := strings.Split(string(data), "\n")
+
+	// A line in error is blanked out and the file checked again, so the
+	// errors of the lines after it are found as well
+	var errs []error
+	var cfg *Config
+	for {
+		cfg, err = checkConfig(strings.Join(lines, "\n"))
+		if err == nil {
+			break
+		}
+		errs = append(errs, err)
+
+		var invalid ErrInvalidConfig
+		if !errors.As(err, &invalid) || invalid.Line < 1 || invalid.Line > len(lines) || lines[invalid.Line-1] == "" {
+			cfg = nil
+			break
+		}
+		lines[invalid.Line-1] = ""
+	}
+
+	if cfg != nil {
+		errs = append(errs, backendErrors(cfg, resolve)...)
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool { return errorLine(errs[i]) < errorLine(errs[j]) })
+	return errs
+}
+
+// checkConfig parses a configuration and builds its pools and routes
+func checkConfig(config string) (*Config, error) {
+	cfg, err := parseConfig(strings.NewReader(config))
+	if err != nil {
+		return nil, err
+	}
+	router, err := CreatePathRouter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := NewSNIRouter(router, cfg.SNI); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// backendErrors checks that the URL of every backend is valid and, with
+// resolve, that its host resolves
+func backendErrors(cfg *Config, resolve bool) []error {
+	var errs []error
+	resolved := make(map[string]error)
+
+	for _, backends := range cfg.BackendPools {
+		for _, backend := range backends {
+			u, err := url.Parse(backend.URL)
+			if err != nil {
+				errs = append(errs, configErrorf(backend.Line, "invalid server URL: %s", backend.URL))
+				continue
+			}
+			if u.Scheme != "http" && u.Scheme != "https" {
+				errs = append(errs, configErrorf(backend.Line, "server URL must be http or https: %s", backend.URL))
+				continue
+			}
+			if u.Hostname() == "" {
+				errs = append(errs, configErrorf(backend.Line, "server URL has no host: %s", backend.URL))
+				continue
+			}
+
+			host := u.Hostname()
+			if !resolve || net.ParseIP(host) != nil {
+				continue
+			}
+			err, ok := resolved[host]
+			if !ok {
+				ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+				_, err = net.DefaultResolver.LookupHost(ctx, host)
+				cancel()
+				resolved[host] = err
+			}
+			if err != nil {
+				errs = append(errs, configError(backend.Line, fmt.Errorf("cannot resolve server host %s: %w", host, err)))
+			}
+		}
+	}
+
+	return errs
+}
+
+// errorLine returns the line of a configuration error, or 0 if it has none
+func errorLine(err error) int {
+	var invalid ErrInvalidConfig
+	if errors.As(err, &invalid) {
+		return invalid.Line
+	}
+	return 0
+}
@@ -1,7 +1,12 @@
 package balancer
 
 import (
+	"context"
+	"crypto/tls"
+	"io"
 	"net/http"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -10,12 +15,28 @@ import (
 	"go.uber.org/zap"
 )
 
+// webSocketHandshakeHeaders are set by the dialer for the handshake with the
+// backend, which fails if they are given twice
+var webSocketHandshakeHeaders = map[string]bool{
+	"Upgrade":                  true,
+	"Connection":               true,
+	"Sec-Websocket-Key":        true,
+	"Sec-Websocket-Version":    true,
+	"Sec-Websocket-Extensions": true,
+}
+
+// webSocketDraining is set once the server starts draining WebSocket connections
+var webSocketDraining int32
+
 type WebSocketProxy struct {
-	backend        *Process
-	upgrader       websocket.Upgrader
-	dialer         *websocket.Dialer
-	connMap        *WebSocketConnectionMap
-	errorHandler   func(backend *Process)
+	backend      *Process
+	upgrader     websocket.Upgrader
+	dialer       *websocket.Dialer
+	connMap      *WebSocketConnectionMap
+	errorHandler func(backend *Process)
+	// onClose is called once the socket is closed or failed to open
+	onClose        func()
+	closeOnce      sync.Once
 	connectionTTL  time.Duration
 	pingInterval   time.Duration
 	pongWait       time.Duration
@@ -23,42 +44,53 @@ type WebSocketProxy struct {
 	maxMessageSize int64
 }
 
+// NewWebSocketProxy creates a proxy to a backend with the default limits
 func NewWebSocketProxy(backend *Process, errorHandler func(backend *Process)) *WebSocketProxy {
+	return newWebSocketProxy(backend, errorHandler, defaultWebSocketConfig)
+}
+
+func newWebSocketProxy(backend *Process, errorHandler func(backend *Process), config WebSocketConfig) *WebSocketProxy {
+	tlsConfig := &tls.Config{
+		ServerName:       backend.ServerName,
+		VerifyConnection: countBackendTLS,
+	}
+
 	return &WebSocketProxy{
 		backend: backend,
 		upgrader: websocket.Upgrader{
-			ReadBufferSize:  1024,
-			WriteBufferSize: 1024,
+			ReadBufferSize:  config.ReadBufferSize,
+			WriteBufferSize: config.WriteBufferSize,
 			CheckOrigin:     func(r *http.Request) bool { return true },
 		},
 		dialer: &websocket.Dialer{
-			ReadBufferSize:  1024,
-			WriteBufferSize: 1024,
+			ReadBufferSize:  config.ReadBufferSize,
+			WriteBufferSize: config.WriteBufferSize,
 			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
 		},
-		connMap:        NewWebSocketConnectionMap(),
+		connMap:        webSocketConnections,
 		errorHandler:   errorHandler,
-		connectionTTL:  3 * time.Hour,
-		pingInterval:   30 * time.Second,
-		pongWait:       60 * time.Second,
-		writeWait:      10 * time.Second,
-		maxMessageSize: 1024 * 1024,
+		connectionTTL:  config.ConnectionTTL,
+		pingInterval:   config.PingInterval,
+		pongWait:       config.PongWait,
+		writeWait:      config.WriteWait,
+		maxMessageSize: config.MaxMessageSize,
 	}
 }
 
 func (wp *WebSocketProxy) ProxyWebSocket(w http.ResponseWriter, r *http.Request) {
-	clientConn, err := wp.upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		logger.Log.Error("Failed to upgrade client connection", zap.Error(err))
+	established := false
+	defer func() {
+		if !established {
+			wp.closed()
+		}
+	}()
+
+	if atomic.LoadInt32(&webSocketDraining) == 1 {
+		rejectRequest(w, RejectDraining, "Server is shutting down", http.StatusServiceUnavailable)
 		return
 	}
 
-	clientConn.SetReadLimit(wp.maxMessageSize)
-	clientConn.SetPongHandler(func(string) error {
-		clientConn.SetReadDeadline(time.Now().Add(wp.pongWait))
-		return nil
-	})
-
 	backendURL := *wp.backend.URL
 	if backendURL.Scheme == "http" {
 		backendURL.Scheme = "ws"
@@ -66,124 +98,289 @@ func (wp *WebSocketProxy) ProxyWebSocket(w http.ResponseWriter, r *http.Request)
 		backendURL.Scheme = "wss"
 	}
 
-	backendURL.Path = r.URL.Path
+	backendURL.Path = prefixPath(wp.backend.Prefix, r.URL.Path)
 	backendURL.RawQuery = r.URL.RawQuery
 
+	// Subprotocols offered by the client are passed on as they are; the
+	// dialer offers compression itself when the client does
 	requestHeader := http.Header{}
 	for k, vs := range r.Header {
+		if webSocketHandshakeHeaders[k] {
+			continue
+		}
 		for _, v := range vs {
 			requestHeader.Add(k, v)
 		}
 	}
 
+	if wp.backend.Host != "" {
+		requestHeader.Set("Host", wp.backend.Host)
+	}
+
+	// The backend is dialed first so the client is offered what the backend
+	// negotiated
+	wp.dialer.EnableCompression = offersCompression(r.Header)
 	backendConn, resp, err := wp.dialer.Dial(backendURL.String(), requestHeader)
 	if err != nil {
-		logger.Log.Error("Failed to connect to backend",
+		if resp != nil {
+			// The backend refused the upgrade: the client gets its answer
+			relayHandshakeResponse(w, resp)
+			return
+		}
+
+		logger.Proxy.Error("Failed to connect to backend",
 			zap.String("backend", backendURL.String()),
 			zap.Error(err))
-		clientConn.Close()
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
 
-		atomic.AddInt32(&wp.backend.ErrorCount, 1)
-		if atomic.LoadInt32(&wp.backend.ErrorCount) >= 3 {
-			wp.backend.SetAlive(false)
+		if wp.backend.recordFailure(err) {
 			wp.errorHandler(wp.backend)
 		}
 
 		return
 	}
+	resp.Body.Close()
 
-	if resp != nil && resp.Body != nil {
-		resp.Body.Close()
+	// The upgrade response carries the headers set so far, such as the
+	// session affinity cookie, the backend's handshake headers and the
+	// subprotocol it selected
+	responseHeader := w.Header().Clone()
+	for k, vs := range resp.Header {
+		if webSocketHandshakeHeaders[k] || k == "Sec-Websocket-Accept" || k == "Sec-Websocket-Protocol" {
+			continue
+		}
+		for _, v := range vs {
+			responseHeader.Add(k, v)
+		}
+	}
+	if subprotocol := backendConn.Subprotocol(); subprotocol != "" {
+		responseHeader.Set("Sec-Websocket-Protocol", subprotocol)
 	}
+	wp.upgrader.EnableCompression = offersCompression(resp.Header)
+
+	clientConn, err := wp.upgrader.Upgrade(w, r, responseHeader)
+	if err != nil {
+		logger.Proxy.Error("Failed to upgrade client connection", zap.Error(err))
+		backendConn.Close()
+		return
+	}
+
+	clientConn.SetReadLimit(wp.maxMessageSize)
+	clientConn.SetReadDeadline(time.Now().Add(wp.pongWait))
+	clientConn.SetPongHandler(func(string) error {
+		clientConn.SetReadDeadline(time.Now().Add(wp.pongWait))
+		return nil
+	})
 
-	connID := wp.connMap.Add(clientConn, backendConn)
-	logger.Log.Info("WebSocket connection established",
-		zap.String("connID", connID),
+	conn := wp.connMap.AddBackendConnection(clientConn, backendConn, wp.backend)
+	logger.Proxy.Info("WebSocket connection established",
+		zap.String("connID", conn.ID),
 		zap.String("backend", backendURL.String()))
 
 	backendConn.SetReadLimit(wp.maxMessageSize)
+	backendConn.SetReadDeadline(time.Now().Add(wp.pongWait))
 	backendConn.SetPongHandler(func(string) error {
 		backendConn.SetReadDeadline(time.Now().Add(wp.pongWait))
 		return nil
 	})
 
-	go wp.pumpToClient(clientConn, backendConn, connID)
-	go wp.pumpToBackend(clientConn, backendConn, connID)
-	go wp.pingConnection(clientConn, backendConn, connID)
+	established = true
+	go wp.pumpToClient(conn)
+	go wp.pumpToBackend(conn)
+	go wp.pingConnection(conn)
 }
 
-func (wp *WebSocketProxy) pumpToClient(clientConn, backendConn *websocket.Conn, connID string) {
+// closed calls onClose the first time the socket is found closed
+func (wp *WebSocketProxy) closed() {
+	wp.closeOnce.Do(func() {
+		if wp.onClose != nil {
+			wp.onClose()
+		}
+	})
+}
+
+func (wp *WebSocketProxy) pumpToClient(conn *WebSocketConnection) {
+	clientConn, backendConn := conn.ClientConn, conn.BackendConn
 	defer func() {
 		clientConn.Close()
 		backendConn.Close()
-		wp.connMap.Remove(connID)
-		logger.Log.Info("WebSocket connection closed", zap.String("connID", connID))
+		wp.connMap.Remove(conn.ID)
+		wp.closed()
+		logger.Proxy.Info("WebSocket connection closed", zap.String("connID", conn.ID))
 	}()
 
 	for {
 		messageType, message, err := backendConn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				logger.Log.Error("Backend WebSocket error", zap.Error(err))
+				logger.Proxy.Error("Backend WebSocket error", zap.Error(err))
 			}
 			break
 		}
+		backendConn.SetReadDeadline(time.Now().Add(wp.pongWait))
 
 		clientConn.SetWriteDeadline(time.Now().Add(wp.writeWait))
 		if err := clientConn.WriteMessage(messageType, message); err != nil {
 			break
 		}
+		atomic.AddInt64(&conn.MessagesToClient, 1)
 	}
 }
 
-func (wp *WebSocketProxy) pumpToBackend(clientConn, backendConn *websocket.Conn, connID string) {
+func (wp *WebSocketProxy) pumpToBackend(conn *WebSocketConnection) {
+	clientConn, backendConn := conn.ClientConn, conn.BackendConn
 	defer func() {
 		clientConn.Close()
 		backendConn.Close()
-		wp.connMap.Remove(connID)
+		wp.connMap.Remove(conn.ID)
+		wp.closed()
 	}()
 
 	for {
 		messageType, message, err := clientConn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				logger.Log.Error("Client WebSocket error", zap.Error(err))
+				logger.Proxy.Error("Client WebSocket error", zap.Error(err))
 			}
 			break
 		}
+		clientConn.SetReadDeadline(time.Now().Add(wp.pongWait))
 
 		backendConn.SetWriteDeadline(time.Now().Add(wp.writeWait))
 		if err := backendConn.WriteMessage(messageType, message); err != nil {
 			break
 		}
+		atomic.AddInt64(&conn.MessagesToBackend, 1)
 	}
 }
 
-func (wp *WebSocketProxy) pingConnection(clientConn, backendConn *websocket.Conn, connID string) {
+// pingConnection pings both ends of a socket until it closes, and closes
+// it once it has been open for its TTL
+func (wp *WebSocketProxy) pingConnection(conn *WebSocketConnection) {
+	clientConn, backendConn := conn.ClientConn, conn.BackendConn
 	ticker := time.NewTicker(wp.pingInterval)
 	defer func() {
 		ticker.Stop()
 		clientConn.Close()
 		backendConn.Close()
-		wp.connMap.Remove(connID)
+		wp.connMap.Remove(conn.ID)
+		wp.closed()
 	}()
 
+	var expired <-chan time.Time
+	if wp.connectionTTL > 0 {
+		ttl := time.NewTimer(wp.connectionTTL)
+		defer ttl.Stop()
+		expired = ttl.C
+	}
+
 	for {
 		select {
 		case <-ticker.C:
-			clientConn.SetWriteDeadline(time.Now().Add(wp.writeWait))
-			if err := clientConn.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
+			// Control messages may be written while a pump writes a message
+			deadline := time.Now().Add(wp.writeWait)
+			if err := clientConn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
 				return
 			}
-
-			backendConn.SetWriteDeadline(time.Now().Add(wp.writeWait))
-			if err := backendConn.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
+			if err := backendConn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
 				return
 			}
+		case <-expired:
+			logger.Proxy.Info("Closing WebSocket connection that reached its TTL",
+				zap.String("connID", conn.ID),
+				zap.Duration("ttl", wp.connectionTTL))
+			closeMessage := websocket.FormatCloseMessage(websocket.CloseGoingAway, "connection TTL exceeded")
+			deadline := time.Now().Add(wp.writeWait)
+			clientConn.WriteControl(websocket.CloseMessage, closeMessage, deadline)
+			backendConn.WriteControl(websocket.CloseMessage, closeMessage, deadline)
+			return
 		}
 	}
 }
 
+// DrainWebSockets stops accepting new WebSocket upgrades, asks both ends of
+// every active connection to close, and waits for them to do so. Connections
+// still open when the context is done are closed forcibly.
+func DrainWebSockets(ctx context.Context) {
+	atomic.StoreInt32(&webSocketDraining, 1)
+
+	connections := webSocketConnections.All()
+	if len(connections) == 0 {
+		return
+	}
+	logger.Proxy.Info("Draining WebSocket connections", zap.Int("connections", len(connections)))
+
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	deadline := time.Now().Add(time.Second)
+	for _, conn := range connections {
+		conn.ClientConn.WriteControl(websocket.CloseMessage, closeMessage, deadline)
+		conn.BackendConn.WriteControl(websocket.CloseMessage, closeMessage, deadline)
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for webSocketConnections.Count() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			remaining := webSocketConnections.All()
+			logger.Proxy.Warn("Closing WebSocket connections that did not drain in time",
+				zap.Int("connections", len(remaining)))
+			for _, conn := range remaining {
+				conn.ClientConn.Close()
+				conn.BackendConn.Close()
+			}
+			return
+		}
+	}
+}
+
+// offersCompression reports whether handshake headers offer or accept
+// permessage-deflate
+func offersCompression(header http.Header) bool {
+	for _, extension := range header.Values("Sec-Websocket-Extensions") {
+		if strings.Contains(extension, "permessage-deflate") {
+			return true
+		}
+	}
+	return false
+}
+
+// relayHandshakeResponse answers the client with the response of a backend
+// that refused a WebSocket upgrade
+func relayHandshakeResponse(w http.ResponseWriter, resp *http.Response) {
+	defer resp.Body.Close()
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// canServe reports whether a backend can take a request: WebSocket upgrades
+// need a backend below its WebSocket limit. Whether the request is an
+// upgrade is worked out once per pick, not once per backend.
+func canServe(webSocket bool, p *Process) bool {
+	return !webSocket || p.HasWebSocketCapacity()
+}
+
+// servable returns the eligibility of backends for a request, as a function
+// shared by every pick so picking allocates nothing
+func servable(webSocket bool) func(*Process) bool {
+	if webSocket {
+		return (*Process).HasWebSocketCapacity
+	}
+	return anyBackend
+}
+
+// anyBackend makes every backend eligible
+func anyBackend(*Process) bool {
+	return true
+}
+
 func IsWebSocketRequest(r *http.Request) bool {
 	contains := func(key, val string) bool {
 		values := r.Header.Values(key)
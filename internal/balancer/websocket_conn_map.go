@@ -4,38 +4,99 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// webSocketConnections tracks the WebSocket connections proxied by every strategy
+var webSocketConnections = NewWebSocketConnectionMap()
+
+// webSocketDurationBuckets are the upper bounds of the connection duration histogram
+var webSocketDurationBuckets = []struct {
+	label string
+	limit time.Duration
+}{
+	{"1m", time.Minute},
+	{"10m", 10 * time.Minute},
+	{"1h", time.Hour},
+	{"6h", 6 * time.Hour},
+	{"+Inf", 0},
+}
+
+func webSocketDurationBucket(d time.Duration) int {
+	for i, bucket := range webSocketDurationBuckets {
+		if bucket.limit == 0 || d < bucket.limit {
+			return i
+		}
+	}
+	return len(webSocketDurationBuckets) - 1
+}
+
 type WebSocketConnection struct {
+	// Message counters come first so they stay aligned for atomic access on
+	// 32-bit platforms
+	MessagesToBackend int64
+	MessagesToClient  int64
+
+	ID          string
 	ClientConn  *websocket.Conn
 	BackendConn *websocket.Conn
+	Backend     *Process
+	StartTime   time.Time
+}
+
+// WebSocketStats summarizes proxied WebSocket connections
+type WebSocketStats struct {
+	Active            int              `json:"active"`
+	ActiveByBackend   map[string]int   `json:"activeByBackend"`
+	Total             int64            `json:"total"`
+	MessagesToBackend int64            `json:"messagesToBackend"`
+	MessagesToClient  int64            `json:"messagesToClient"`
+	ActiveAges        map[string]int64 `json:"activeAges"`
+	ClosedDurations   map[string]int64 `json:"closedDurations"`
 }
 
 type WebSocketConnectionMap struct {
 	connections map[string]*WebSocketConnection
 	mu          sync.RWMutex
+
+	// Totals over connections that have been closed
+	total             int64
+	messagesToBackend int64
+	messagesToClient  int64
+	closedDurations   []int64
 }
 
 func NewWebSocketConnectionMap() *WebSocketConnectionMap {
 	return &WebSocketConnectionMap{
-		connections: make(map[string]*WebSocketConnection),
+		connections:     make(map[string]*WebSocketConnection),
+		closedDurations: make([]int64, len(webSocketDurationBuckets)),
 	}
 }
 
 func (cm *WebSocketConnectionMap) Add(clientConn, backendConn *websocket.Conn) string {
-	connID := generateConnID()
-
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
+	return cm.AddBackendConnection(clientConn, backendConn, nil).ID
+}
 
-	cm.connections[connID] = &WebSocketConnection{
+// AddBackendConnection tracks a connection proxied to the given backend
+func (cm *WebSocketConnectionMap) AddBackendConnection(clientConn, backendConn *websocket.Conn, backend *Process) *WebSocketConnection {
+	conn := &WebSocketConnection{
+		ID:          generateConnID(),
 		ClientConn:  clientConn,
 		BackendConn: backendConn,
+		Backend:     backend,
+		StartTime:   time.Now(),
 	}
 
-	return connID
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	cm.connections[conn.ID] = conn
+	cm.total++
+
+	return conn
 }
 
 func (cm *WebSocketConnectionMap) Get(connID string) (*WebSocketConnection, bool) {
@@ -50,7 +111,15 @@ func (cm *WebSocketConnectionMap) Remove(connID string) {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
+	conn, exists := cm.connections[connID]
+	if !exists {
+		return
+	}
 	delete(cm.connections, connID)
+
+	cm.messagesToBackend += atomic.LoadInt64(&conn.MessagesToBackend)
+	cm.messagesToClient += atomic.LoadInt64(&conn.MessagesToClient)
+	cm.closedDurations[webSocketDurationBucket(time.Since(conn.StartTime))]++
 }
 
 func (cm *WebSocketConnectionMap) Count() int {
@@ -60,6 +129,70 @@ func (cm *WebSocketConnectionMap) Count() int {
 	return len(cm.connections)
 }
 
+// All returns the active connections
+func (cm *WebSocketConnectionMap) All() []*WebSocketConnection {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	connections := make([]*WebSocketConnection, 0, len(cm.connections))
+	for _, conn := range cm.connections {
+		connections = append(connections, conn)
+	}
+	return connections
+}
+
+// CountByBackend returns the number of active connections to a backend
+func (cm *WebSocketConnectionMap) CountByBackend(backend *Process) int {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	count := 0
+	for _, conn := range cm.connections {
+		if conn.Backend == backend {
+			count++
+		}
+	}
+	return count
+}
+
+// Stats returns a summary of active and closed connections
+func (cm *WebSocketConnectionMap) Stats() WebSocketStats {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	stats := WebSocketStats{
+		Active:            len(cm.connections),
+		ActiveByBackend:   make(map[string]int),
+		Total:             cm.total,
+		MessagesToBackend: cm.messagesToBackend,
+		MessagesToClient:  cm.messagesToClient,
+		ActiveAges:        make(map[string]int64, len(webSocketDurationBuckets)),
+		ClosedDurations:   make(map[string]int64, len(webSocketDurationBuckets)),
+	}
+
+	for i, bucket := range webSocketDurationBuckets {
+		stats.ActiveAges[bucket.label] = 0
+		stats.ClosedDurations[bucket.label] = cm.closedDurations[i]
+	}
+
+	now := time.Now()
+	for _, conn := range cm.connections {
+		if conn.Backend != nil {
+			stats.ActiveByBackend[conn.Backend.URL.String()]++
+		}
+		stats.MessagesToBackend += atomic.LoadInt64(&conn.MessagesToBackend)
+		stats.MessagesToClient += atomic.LoadInt64(&conn.MessagesToClient)
+		stats.ActiveAges[webSocketDurationBuckets[webSocketDurationBucket(now.Sub(conn.StartTime))].label]++
+	}
+
+	return stats
+}
+
+// GetWebSocketStats returns a summary of the proxied WebSocket connections
+func GetWebSocketStats() WebSocketStats {
+	return webSocketConnections.Stats()
+}
+
 func generateConnID() string {
 	b := make([]byte, 16)
 	rand.Read(b)
@@ -0,0 +1,109 @@
+package balancer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// WebSocketConfig holds the limits of the WebSockets proxied to a pool
+type WebSocketConfig struct {
+	// MaxMessageSize is the largest message accepted from either end
+	MaxMessageSize int64
+	// PingInterval is how often both ends are pinged, and PongWait how long
+	// an end may stay silent before its socket is closed
+	PingInterval time.Duration
+	PongWait     time.Duration
+	// WriteWait bounds the time taken to write a message to either end
+	WriteWait time.Duration
+	// ConnectionTTL is how long a socket may stay open, or zero for no limit
+	ConnectionTTL   time.Duration
+	ReadBufferSize  int
+	WriteBufferSize int
+}
+
+// defaultWebSocketConfig is used by pools without a websocket directive
+var defaultWebSocketConfig = WebSocketConfig{
+	MaxMessageSize:  1 << 20,
+	PingInterval:    30 * time.Second,
+	PongWait:        60 * time.Second,
+	WriteWait:       10 * time.Second,
+	ConnectionTTL:   3 * time.Hour,
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// parseWebSocketLimits parses the arguments of a websocket directive
+func parseWebSocketLimits(parts []string) (WebSocketConfig, error) {
+	config := defaultWebSocketConfig
+
+	durations := map[string]*time.Duration{
+		"ping":       &config.PingInterval,
+		"pong_wait":  &config.PongWait,
+		"write_wait": &config.WriteWait,
+	}
+	sizes := map[string]*int{
+		"read_buffer":  &config.ReadBufferSize,
+		"write_buffer": &config.WriteBufferSize,
+	}
+
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(strings.TrimSuffix(part, ";"), "=")
+		switch {
+		case durations[key] != nil:
+			duration, err := time.ParseDuration(value)
+			if err != nil || duration <= 0 {
+				return WebSocketConfig{}, fmt.Errorf("invalid websocket %s: %s", key, value)
+			}
+			*durations[key] = duration
+		case sizes[key] != nil:
+			size, err := parseByteSize(value)
+			if err != nil || size <= 0 || size > 1<<30 {
+				return WebSocketConfig{}, fmt.Errorf("invalid websocket %s: %s", key, value)
+			}
+			*sizes[key] = int(size)
+		case key == "max_message":
+			size, err := parseByteSize(value)
+			if err != nil || size <= 0 {
+				return WebSocketConfig{}, fmt.Errorf("invalid websocket max_message: %s", value)
+			}
+			config.MaxMessageSize = size
+		case key == "ttl":
+			if value == "off" {
+				config.ConnectionTTL = 0
+				continue
+			}
+			ttl, err := time.ParseDuration(value)
+			if err != nil || ttl <= 0 {
+				return WebSocketConfig{}, fmt.Errorf("invalid websocket ttl, expected a duration or off: %s", value)
+			}
+			config.ConnectionTTL = ttl
+		default:
+			return WebSocketConfig{}, fmt.Errorf("unknown websocket parameter: %s", part)
+		}
+	}
+
+	// Each end must have time to answer a ping before its socket is closed
+	if config.PingInterval >= config.PongWait {
+		return WebSocketConfig{}, fmt.Errorf("websocket ping (%s) must be shorter than pong_wait (%s)", config.PingInterval, config.PongWait)
+	}
+	return config, nil
+}
+
+// String formats the limits as the arguments of a websocket directive
+func (c WebSocketConfig) String() string {
+	ttl := "off"
+	if c.ConnectionTTL > 0 {
+		ttl = c.ConnectionTTL.String()
+	}
+	return fmt.Sprintf("max_message=%dB ping=%s pong_wait=%s write_wait=%s ttl=%s read_buffer=%dB write_buffer=%dB",
+		c.MaxMessageSize, c.PingInterval, c.PongWait, c.WriteWait, ttl, c.ReadBufferSize, c.WriteBufferSize)
+}
+
+// setWebSocketLimits makes the balancer behind a strategy proxy WebSockets
+// with the given limits
+func setWebSocketLimits(strategy LoadBalancerStrategy, config WebSocketConfig) {
+	if ps, ok := strategy.(*PoolStrategy); ok {
+		ps.pool.Settings().WebSocket = &config
+	}
+}
@@ -2,10 +2,8 @@ package balancer
 
 import (
 	"net/http"
-	"net/http/httputil"
 	"net/url"
-	"sync/atomic"
-	"time"
+	"sync"
 
 	"github.com/The-iyed/go-load-balancer/internal/logger"
 	"go.uber.org/zap"
@@ -19,9 +17,12 @@ func init() {
 }
 
 type WeightedRoundRobinBalancer struct {
-	ProcessPack []*Process
 	Current     uint64
+	Generation  uint64
+	ProcessPack []*Process
 	TotalWeight int
+	PoolSettings
+	mu sync.Mutex
 }
 
 func NewLoadBalancer(configs []BackendConfig) *WeightedRoundRobinBalancer {
@@ -41,38 +42,81 @@ func NewLoadBalancer(configs []BackendConfig) *WeightedRoundRobinBalancer {
 		}
 
 		process := &Process{
-			URL:        parsed,
-			Alive:      true,
-			ErrorCount: 0,
-			Weight:     weight,
+			URL:           parsed,
+			Alive:         true,
+			ErrorCount:    0,
+			Weight:        weight,
+			MaxConns:      int32(config.MaxConns),
+			MaxWebSockets: int32(config.MaxWebSockets),
+			Host:          config.Host,
+			ServerName:    config.ServerName,
+			Prefix:        config.Prefix,
+			Zone:          config.Zone,
+			Region:        config.Region,
+			Priority:      config.Priority,
 		}
-		process.ResetCurrentWeight()
 
 		processes = append(processes, process)
 		totalWeight += weight
 	}
 
-	return &WeightedRoundRobinBalancer{
+	lb := &WeightedRoundRobinBalancer{
 		ProcessPack: processes,
 		TotalWeight: totalWeight,
 	}
+	lb.tiered = hasPriorities(processes)
+	return lb
 }
 
+// GetNextInstance picks a backend with smooth weighted round robin: every
+// eligible backend earns its weight in credit, the one with the most credit
+// wins and pays back the total earned. Ties go to the first backend, so the
+// schedule is deterministic. Picks are serialized, so concurrent requests
+// follow the same schedule as sequential ones.
 func (lb *WeightedRoundRobinBalancer) GetNextInstance(r *http.Request) *Process {
-	if len(lb.ProcessPack) == 0 {
-		return nil
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	return lb.nextLocked(r)
+}
+
+// nextLocked picks the next backend of the schedule; the caller holds the lock
+func (lb *WeightedRoundRobinBalancer) nextLocked(r *http.Request) *Process {
+	return pickAvoidingZones(r, avoidedZones(r, lb.AntiAffinity), lb.priorityTier(lb.ProcessPack), lb.nextWeighted)
+}
+
+// peek returns the backend a pick would return without moving the schedule
+// on, by undoing the credit the pick earned and paid
+func (lb *WeightedRoundRobinBalancer) peek(pick func() *Process) *Process {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	credits := make([]int, len(lb.ProcessPack))
+	for i, p := range lb.ProcessPack {
+		credits[i] = p.Current
 	}
+	defer func() {
+		for i, p := range lb.ProcessPack {
+			p.Current = credits[i]
+		}
+	}()
+	return pick()
+}
 
+// nextWeighted runs one round of the schedule among the eligible backends
+// of priority tier or below. Backends that are not eligible earn no credit.
+func (lb *WeightedRoundRobinBalancer) nextWeighted(eligible func(*Process) bool, tier int) *Process {
 	var selected *Process
-	maxCurrent := 0
+	total := 0
 
 	for _, p := range lb.ProcessPack {
-		if !p.IsAlive() {
+		if p.Priority > tier || !p.Available() || !eligible(p) {
 			continue
 		}
 
-		if p.Current > maxCurrent {
-			maxCurrent = p.Current
+		p.Current += p.Weight
+		total += p.Weight
+		if selected == nil || p.Current > selected.Current {
 			selected = p
 		}
 	}
@@ -81,58 +125,103 @@ func (lb *WeightedRoundRobinBalancer) GetNextInstance(r *http.Request) *Process
 		return nil
 	}
 
-	for _, p := range lb.ProcessPack {
-		if p.IsAlive() {
-			p.Current += p.Weight
-		}
-	}
-
-	selected.Current -= lb.TotalWeight
+	selected.Current -= total
 	return selected
 }
 
-func (lb *WeightedRoundRobinBalancer) ProxyRequest(w http.ResponseWriter, r *http.Request) {
-	target := lb.GetNextInstance(r)
-	if target == nil {
-		http.Error(w, "No healthy backends available", http.StatusServiceUnavailable)
-		return
+// UpdateBackends swaps in a new generation of backends and returns its number.
+// Backends that survive the swap keep their health, counters and scheduling
+// credit. The credit of removed backends is handed to the survivors by weight,
+// so credits keep summing to zero and the new generation continues the old
+// schedule instead of restarting it with a burst onto the heaviest backend.
+func (lb *WeightedRoundRobinBalancer) UpdateBackends(configs []BackendConfig) uint64 {
+	fresh := NewLoadBalancer(configs).ProcessPack
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	processes, survivors, removed := mergeBackends(lb.ProcessPack, fresh, lb.DrainTimeout)
+	totalWeight, survivorWeight := 0, 0
+	for _, p := range processes {
+		totalWeight += p.Weight
 	}
-
-	if IsWebSocketRequest(r) && lb.SupportsWebSockets() {
-		wsProxy := NewWebSocketProxy(target, func(p *Process) {
-			go lb.reviveLater(p)
-		})
-		wsProxy.ProxyWebSocket(w, r)
-		return
+	for _, p := range survivors {
+		survivorWeight += p.Weight
 	}
 
-	proxy := httputil.NewSingleHostReverseProxy(target.URL)
-	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
-		logger.Log.Error("Request failed",
-			zap.String("backend", target.URL.String()),
-			zap.Error(err),
-		)
-
-		atomic.AddInt32(&target.ErrorCount, 1)
-		if atomic.LoadInt32(&target.ErrorCount) >= 3 {
-			target.SetAlive(false)
-			logger.Log.Warn("Backend marked dead", zap.String("backend", target.URL.String()))
-			go lb.reviveLater(target)
+	removedCredit := 0
+	for _, p := range removed {
+		removedCredit += p.Current
+	}
+	if survivorWeight > 0 && removedCredit != 0 {
+		remaining := removedCredit
+		for _, p := range survivors {
+			share := removedCredit * p.Weight / survivorWeight
+			p.Current += share
+			remaining -= share
 		}
-
-		lb.ProxyRequest(w, r)
+		survivors[0].Current += remaining
 	}
 
-	proxy.ServeHTTP(w, r)
+	lb.ProcessPack = processes
+	lb.TotalWeight = totalWeight
+	lb.tiered = hasPriorities(processes)
+	lb.Generation++
+	lb.forgetRevivals(removed)
+
+	logger.Log.Info("Backend pool swapped",
+		zap.Uint64("generation", lb.Generation),
+		zap.Int("backends", len(processes)),
+		zap.Int("kept", len(survivors)))
+
+	return lb.Generation
+}
+
+// SetWeight changes the weight of one of the pool's backends, which takes
+// its new share of the schedule from the next round on
+func (lb *WeightedRoundRobinBalancer) SetWeight(p *Process, weight int) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	lb.TotalWeight += weight - p.Weight
+	p.Weight = weight
+}
+
+// Weights returns the weights of the pool's backends
+func (lb *WeightedRoundRobinBalancer) Weights() map[*Process]int {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return processWeights(lb.ProcessPack)
+}
+
+// Backends returns the backends of the current generation
+func (lb *WeightedRoundRobinBalancer) Backends() []*Process {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.ProcessPack
+}
+
+func (lb *WeightedRoundRobinBalancer) ProxyRequest(w http.ResponseWriter, r *http.Request) {
+	proxyToPool(lb, w, r)
 }
 
 func (lb *WeightedRoundRobinBalancer) SupportsWebSockets() bool {
 	return true
 }
 
-func (lb *WeightedRoundRobinBalancer) reviveLater(p *Process) {
-	time.Sleep(10 * time.Second)
-	p.SetAlive(true)
-	atomic.StoreInt32(&p.ErrorCount, 0)
-	logger.Log.Info("Backend revived", zap.String("backend", p.URL.String()))
+// Pick implements the Picker interface
+func (lb *WeightedRoundRobinBalancer) Pick(r *http.Request) *Process {
+	return lb.GetNextInstance(r)
+}
+
+func (lb *WeightedRoundRobinBalancer) Method() string {
+	return "Weighted Round Robin"
+}
+
+func (lb *WeightedRoundRobinBalancer) Persistence() PersistenceMethod {
+	return NoPersistence
+}
+
+func (lb *WeightedRoundRobinBalancer) Settings() *PoolSettings {
+	return &lb.PoolSettings
 }
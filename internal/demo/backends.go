@@ -0,0 +1,81 @@
+// Package demo runs mock backends for the demo command, so the load balancer
+// can be tried without servers of its own
+package demo
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Backend is a mock backend answering every request with its ID
+type Backend struct {
+	ID  int
+	URL string
+	// Delay is how long the backend takes to answer
+	Delay  time.Duration
+	server *http.Server
+}
+
+// Cluster is a set of mock backends listening on the loopback interface
+type Cluster struct {
+	Backends []*Backend
+}
+
+// StartCluster starts count mock backends, numbered from 1. The backend at
+// index i takes delays[i] to answer, if set.
+func StartCluster(count int, delays []time.Duration) (*Cluster, error) {
+	cluster := &Cluster{}
+	for i := 0; i < count; i++ {
+		backend := &Backend{ID: i + 1}
+		if i < len(delays) {
+			backend.Delay = delays[i]
+		}
+		if err := backend.start(); err != nil {
+			cluster.Close()
+			return nil, err
+		}
+		cluster.Backends = append(cluster.Backends, backend)
+	}
+	return cluster, nil
+}
+
+func (b *Backend) start() error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("failed to start mock backend %d: %w", b.ID, err)
+	}
+
+	b.URL = "http://" + listener.Addr().String()
+	b.server = &http.Server{
+		Handler:           http.HandlerFunc(b.serveHTTP),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go b.server.Serve(listener)
+	return nil
+}
+
+func (b *Backend) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if b.Delay > 0 {
+		time.Sleep(b.Delay)
+	}
+	w.Header().Set("X-Backend-ID", fmt.Sprintf("%d", b.ID))
+	fmt.Fprintf(w, "Response from backend %d\n", b.ID)
+}
+
+// URLs returns the URLs of the backends
+func (c *Cluster) URLs() []string {
+	urls := make([]string, len(c.Backends))
+	for i, backend := range c.Backends {
+		urls[i] = backend.URL
+	}
+	return urls
+}
+
+// Close stops the backends
+func (c *Cluster) Close() {
+	for _, backend := range c.Backends {
+		backend.server.Close()
+	}
+}
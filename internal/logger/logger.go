@@ -1,15 +1,345 @@
 package logger
 
 import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 var Log *zap.Logger
 
+// Component loggers. Their entries are logged at the level set for the
+// component, or at the global level if none is.
+var (
+	Proxy  *zap.Logger
+	Health *zap.Logger
+	Admin  *zap.Logger
+)
+
+// Components are the names of the component loggers
+var Components = []string{"proxy", "health", "admin"}
+
+// Level is the minimum level logged. It can be changed at runtime through
+// the admin log level endpoint.
+var Level = zap.NewAtomicLevel()
+
+// recent keeps the last log entries in memory for support bundles
+var recent = newLogRing(1000)
+
+// Config holds the settings of the log
+type Config struct {
+	// Level is the minimum level logged
+	Level zapcore.Level
+	// Format is json or console
+	Format string
+	// Output is stderr, stdout or a file
+	Output string
+	// MaxSize and MaxAge rotate the output file once it grows past the size
+	// or has been written to for the duration, if set. MaxBackups is the
+	// number of rotated files kept, or 0 to keep them all.
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+	// Components holds the levels of the components logged apart from the
+	// global level
+	Components map[string]zapcore.Level
+}
+
+// DefaultConfig returns the settings of the log when none are configured:
+// JSON entries at info level on stderr
+func DefaultConfig() Config {
+	return Config{
+		Level:  zapcore.InfoLevel,
+		Format: "json",
+		Output: "stderr",
+	}
+}
+
+// levels are the component levels of the loggers in use
+var (
+	levels   componentLevels
+	levelsMu sync.Mutex
+)
+
+// output is the file the log is written to, closed when it is replaced
+var (
+	output   io.Closer
+	outputMu sync.Mutex
+)
+
+// current is the core the loggers write through. Configure replaces it
+// rather than the loggers, which are read without synchronization.
+var (
+	current     atomic.Pointer[zapcore.Core]
+	loggersOnce sync.Once
+)
+
 func InitLogger() {
-	var err error
-	Log, err = zap.NewProduction()
-	if err != nil {
+	config := DefaultConfig()
+	config.Level = Level.Level()
+	if err := Configure(config); err != nil {
 		panic(err)
 	}
 }
+
+// Configure sets up the log with the given settings, replacing the loggers
+func Configure(config Config) error {
+	configured := componentLevels{global: Level, components: make(map[string]*componentLevel)}
+	for _, component := range Components {
+		configured.components[component] = &componentLevel{level: zap.NewAtomicLevel()}
+	}
+	for component, level := range config.Components {
+		c, ok := configured.components[component]
+		if !ok {
+			return fmt.Errorf("unknown log component: %s", component)
+		}
+		c.level.SetLevel(level)
+		c.set.Store(true)
+	}
+
+	encoderConfig := zap.NewProductionEncoderConfig()
+	var encoder zapcore.Encoder
+	switch config.Format {
+	case "", "json":
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	case "console":
+		encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+		encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	default:
+		return fmt.Errorf("invalid log format, expected json or console: %s", config.Format)
+	}
+
+	var out zapcore.WriteSyncer
+	var closer io.Closer
+	switch config.Output {
+	case "", "stderr":
+		out = zapcore.Lock(os.Stderr)
+	case "stdout":
+		out = zapcore.Lock(os.Stdout)
+	default:
+		file, err := openRotatingFile(config.Output, config.MaxSize, config.MaxAge, config.MaxBackups)
+		if err != nil {
+			return err
+		}
+		out, closer = file, file
+	}
+
+	// Entries are copied to the in-memory ring as well as the output. Only
+	// the output is sampled, as the production configuration does.
+	core := zapcore.NewSamplerWithOptions(zapcore.NewCore(encoder, out, configured), time.Second, 100, 100)
+	ring := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), recent, configured)
+	var configuredCore zapcore.Core = componentCore{Core: zapcore.NewTee(core, ring), levels: configured}
+	current.Store(&configuredCore)
+	loggersOnce.Do(func() {
+		Log = zap.New(swappingCore{},
+			zap.AddCaller(),
+			zap.AddStacktrace(zapcore.ErrorLevel),
+			zap.ErrorOutput(zapcore.Lock(os.Stderr)))
+		Proxy = Log.Named("proxy")
+		Health = Log.Named("health")
+		Admin = Log.Named("admin")
+	})
+	Level.SetLevel(config.Level)
+
+	levelsMu.Lock()
+	levels = configured
+	levelsMu.Unlock()
+
+	outputMu.Lock()
+	previous := output
+	output = closer
+	outputMu.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
+}
+
+// componentLevels holds the global level and the levels of the components,
+// which follow the global level until one is set for them
+type componentLevels struct {
+	global     zap.AtomicLevel
+	components map[string]*componentLevel
+}
+
+type componentLevel struct {
+	level zap.AtomicLevel
+	set   atomic.Bool
+}
+
+// Enabled reports whether any logger logs at the level
+func (l componentLevels) Enabled(level zapcore.Level) bool {
+	if l.global.Enabled(level) {
+		return true
+	}
+	for _, component := range l.components {
+		if component.set.Load() && component.level.Enabled(level) {
+			return true
+		}
+	}
+	return false
+}
+
+// enabledFor reports whether a logger logs at the level. The component of
+// a logger is the first part of its name.
+func (l componentLevels) enabledFor(name string, level zapcore.Level) bool {
+	component, _, _ := strings.Cut(name, ".")
+	if c, ok := l.components[component]; ok && c.set.Load() {
+		return c.level.Enabled(level)
+	}
+	return l.global.Enabled(level)
+}
+
+// ComponentLevels returns the levels of the components that have their own
+func ComponentLevels() map[string]zapcore.Level {
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+
+	result := make(map[string]zapcore.Level)
+	for name, component := range levels.components {
+		if component.set.Load() {
+			result[name] = component.level.Level()
+		}
+	}
+	return result
+}
+
+// SetComponentLevel sets the level of a component at runtime
+func SetComponentLevel(name string, level zapcore.Level) error {
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+
+	component, ok := levels.components[name]
+	if !ok {
+		return fmt.Errorf("unknown log component: %s", name)
+	}
+	component.level.SetLevel(level)
+	component.set.Store(true)
+	return nil
+}
+
+// ClearComponentLevel makes a component follow the global level again
+func ClearComponentLevel(name string) error {
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+
+	component, ok := levels.components[name]
+	if !ok {
+		return fmt.Errorf("unknown log component: %s", name)
+	}
+	component.set.Store(false)
+	return nil
+}
+
+// componentCore drops the entries below the level of the component that
+// logged them
+type componentCore struct {
+	zapcore.Core
+	levels componentLevels
+}
+
+func (c componentCore) Enabled(level zapcore.Level) bool {
+	return c.levels.Enabled(level)
+}
+
+func (c componentCore) With(fields []zapcore.Field) zapcore.Core {
+	return componentCore{Core: c.Core.With(fields), levels: c.levels}
+}
+
+func (c componentCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.levels.enabledFor(entry.LoggerName, entry.Level) {
+		return checked
+	}
+	return c.Core.Check(entry, checked)
+}
+
+// swappingCore writes through the core Configure last set up, so loggers
+// made once keep logging with the settings of the latest configuration
+type swappingCore struct {
+	// fields are the fields added with With, added to whichever core is
+	// current when an entry is logged
+	fields []zapcore.Field
+}
+
+func (s swappingCore) core() zapcore.Core {
+	core := *current.Load()
+	if len(s.fields) > 0 {
+		return core.With(s.fields)
+	}
+	return core
+}
+
+func (s swappingCore) Enabled(level zapcore.Level) bool {
+	return (*current.Load()).Enabled(level)
+}
+
+func (s swappingCore) With(fields []zapcore.Field) zapcore.Core {
+	return swappingCore{fields: append(s.fields[:len(s.fields):len(s.fields)], fields...)}
+}
+
+func (s swappingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return s.core().Check(entry, checked)
+}
+
+func (s swappingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	return s.core().Write(entry, fields)
+}
+
+func (s swappingCore) Sync() error {
+	return (*current.Load()).Sync()
+}
+
+// RecentLogs returns the most recent log entries as JSON lines, oldest first
+func RecentLogs() []string {
+	return recent.entries()
+}
+
+// logRing is a write syncer keeping the last entries written to it
+type logRing struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	count int
+}
+
+func newLogRing(size int) *logRing {
+	return &logRing{lines: make([]string, size)}
+}
+
+// Write stores one encoded entry; zap writes each entry in a single call
+func (lr *logRing) Write(p []byte) (int, error) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	lr.lines[lr.next] = string(p)
+	lr.next = (lr.next + 1) % len(lr.lines)
+	if lr.count < len(lr.lines) {
+		lr.count++
+	}
+	return len(p), nil
+}
+
+// Sync implements zapcore.WriteSyncer
+func (lr *logRing) Sync() error {
+	return nil
+}
+
+func (lr *logRing) entries() []string {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	entries := make([]string, 0, lr.count)
+	start := (lr.next - lr.count + len(lr.lines)) % len(lr.lines)
+	for i := 0; i < lr.count; i++ {
+		entries = append(entries, lr.lines[(start+i)%len(lr.lines)])
+	}
+	return entries
+}
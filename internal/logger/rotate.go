@@ -0,0 +1,134 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedSuffix is the time layout appended to the name of a rotated file
+const rotatedSuffix = "2006-01-02T15-04-05.000000000"
+
+// rotatingFile is a log file that is renamed aside and started anew once it
+// grows past a size or has been written to for a while
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// openRotatingFile opens a log file, appending to it if it exists. The file
+// is not rotated by size or age if the matching limit is 0.
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	rf := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rf.file, rf.size, rf.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+// Write appends one encoded entry, rotating the file first if it is due
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.size > 0 && ((rf.maxSize > 0 && rf.size+int64(len(p)) > rf.maxSize) ||
+		(rf.maxAge > 0 && time.Since(rf.opened) >= rf.maxAge)) {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate renames the file aside with the time as suffix, opens a new one
+// and removes the oldest rotated files over the number kept
+func (rf *rotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(rf.path, rf.path+"."+time.Now().Format(rotatedSuffix)); err != nil {
+		return err
+	}
+	if err := rf.open(); err != nil {
+		return err
+	}
+
+	if rf.maxBackups > 0 {
+		backups := rf.backups()
+		for len(backups) > rf.maxBackups {
+			os.Remove(backups[0])
+			backups = backups[1:]
+		}
+	}
+	return nil
+}
+
+// backups returns the rotated files, oldest first
+func (rf *rotatingFile) backups() []string {
+	dir, name := filepath.Split(rf.path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		suffix, ok := strings.CutPrefix(entry.Name(), name+".")
+		if !ok {
+			continue
+		}
+		if _, err := time.Parse(rotatedSuffix, suffix); err == nil {
+			backups = append(backups, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(backups)
+	return backups
+}
+
+// Sync implements zapcore.WriteSyncer
+func (rf *rotatingFile) Sync() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.file.Sync()
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.file.Close()
+}
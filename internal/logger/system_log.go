@@ -0,0 +1,131 @@
+package logger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// systemLogIdentifier names the load balancer in the operating system's log
+const systemLogIdentifier = "go-load-balancer"
+
+// systemLogWriter writes to the operating system's log
+type systemLogWriter interface {
+	write(level zapcore.Level, event, message string, fields map[string]string) error
+	close() error
+}
+
+var (
+	systemLog   systemLogWriter
+	systemLogMu sync.Mutex
+
+	eventHooks   []EventHook
+	eventHooksMu sync.RWMutex
+)
+
+// EventHook is called with every lifecycle event and its fields, besides the
+// event name, as strings
+type EventHook func(level zapcore.Level, event, message string, fields map[string]string)
+
+// OnEvent registers a hook called with every lifecycle event, e.g. to push
+// them to admin clients
+func OnEvent(hook EventHook) {
+	eventHooksMu.Lock()
+	defer eventHooksMu.Unlock()
+	eventHooks = append(eventHooks, hook)
+}
+
+// InitSystemLog opens the operating system's log for critical events: the
+// systemd journal or syslog on Unix systems and the event log on Windows
+func InitSystemLog() error {
+	writer, err := openSystemLog()
+	if err != nil {
+		return err
+	}
+
+	systemLogMu.Lock()
+	defer systemLogMu.Unlock()
+	if systemLog != nil {
+		systemLog.close()
+	}
+	systemLog = writer
+	return nil
+}
+
+// CloseSystemLog stops sending events to the operating system's log
+func CloseSystemLog() {
+	systemLogMu.Lock()
+	defer systemLogMu.Unlock()
+	if systemLog != nil {
+		systemLog.close()
+		systemLog = nil
+	}
+}
+
+// Event logs a critical lifecycle event, such as startup, shutdown, a
+// configuration change or a pool losing all its backends. Besides the usual
+// log, it goes to the operating system's log so platform tooling captures it
+// even when log shipping is broken. Fatal events exit after both are written.
+func Event(level zapcore.Level, event, message string, fields ...zap.Field) {
+	fields = append(fields, zap.String("event", event))
+
+	systemLogMu.Lock()
+	if systemLog != nil {
+		if err := systemLog.write(level, event, message, fieldStrings(fields)); err != nil && Log != nil {
+			Log.Debug("Failed to write to the system log", zap.Error(err))
+		}
+	}
+	systemLogMu.Unlock()
+
+	eventHooksMu.RLock()
+	hooks := eventHooks
+	eventHooksMu.RUnlock()
+	if len(hooks) > 0 {
+		values := fieldStrings(fields[:len(fields)-1])
+		for _, hook := range hooks {
+			hook(level, event, message, values)
+		}
+	}
+
+	if Log == nil {
+		return
+	}
+	if entry := Log.Check(level, message); entry != nil {
+		entry.Write(fields...)
+	}
+}
+
+// fieldStrings renders zap fields as strings
+func fieldStrings(fields []zap.Field) map[string]string {
+	encoder := zapcore.NewMapObjectEncoder()
+	for _, field := range fields {
+		field.AddTo(encoder)
+	}
+
+	values := make(map[string]string, len(encoder.Fields))
+	for key, value := range encoder.Fields {
+		values[key] = fmt.Sprint(value)
+	}
+	return values
+}
+
+// formatSystemLogMessage appends the fields to a message for logs that only
+// take text, sorted by key
+func formatSystemLogMessage(message string, fields map[string]string) string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(message)
+	for _, key := range keys {
+		fmt.Fprintf(&b, " %s=%q", key, fields[key])
+	}
+	return b.String()
+}
@@ -0,0 +1,72 @@
+//go:build linux
+
+package logger
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// journalSocket is where the systemd journal receives native protocol entries
+const journalSocket = "/run/systemd/journal/socket"
+
+// journalWriter sends entries to the systemd journal with their fields as
+// journal fields, so they can be queried with journalctl LB_EVENT=startup
+type journalWriter struct {
+	conn *net.UnixConn
+}
+
+func openSystemLog() (systemLogWriter, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		// Without a journal, such as outside systemd, fall back to syslog
+		return openSyslog()
+	}
+	return &journalWriter{conn: conn}, nil
+}
+
+func (j *journalWriter) write(level zapcore.Level, event, message string, fields map[string]string) error {
+	var b strings.Builder
+	writeJournalField(&b, "MESSAGE", message)
+	writeJournalField(&b, "PRIORITY", strconv.Itoa(syslogPriority(level)))
+	writeJournalField(&b, "SYSLOG_IDENTIFIER", systemLogIdentifier)
+	for key, value := range fields {
+		writeJournalField(&b, "LB_"+journalFieldName(key), value)
+	}
+	return j.send(b.String())
+}
+
+func (j *journalWriter) send(entry string) error {
+	_, err := j.conn.Write([]byte(entry))
+	return err
+}
+
+func (j *journalWriter) close() error {
+	return j.conn.Close()
+}
+
+// writeJournalField writes a field in the journal native protocol. Values
+// spanning lines are not expected in events and are joined into one.
+func writeJournalField(b *strings.Builder, name, value string) {
+	b.WriteString(name)
+	b.WriteByte('=')
+	b.WriteString(strings.ReplaceAll(value, "\n", " "))
+	b.WriteByte('\n')
+}
+
+// journalFieldName turns a field key into a journal field name: upper case
+// letters, digits and underscores
+func journalFieldName(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+}
@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package logger
+
+import "errors"
+
+func openSystemLog() (systemLogWriter, error) {
+	return nil, errors.New("no system log on this platform")
+}
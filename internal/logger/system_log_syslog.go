@@ -0,0 +1,57 @@
+//go:build unix
+
+package logger
+
+import (
+	"log/syslog"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// syslogWriter sends entries to the local syslog daemon
+type syslogWriter struct {
+	writer *syslog.Writer
+}
+
+func openSyslog() (systemLogWriter, error) {
+	writer, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, systemLogIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogWriter{writer: writer}, nil
+}
+
+func (s *syslogWriter) write(level zapcore.Level, event, message string, fields map[string]string) error {
+	message = formatSystemLogMessage(message, fields)
+	switch syslogPriority(level) {
+	case 2:
+		return s.writer.Crit(message)
+	case 3:
+		return s.writer.Err(message)
+	case 4:
+		return s.writer.Warning(message)
+	case 6:
+		return s.writer.Info(message)
+	default:
+		return s.writer.Debug(message)
+	}
+}
+
+func (s *syslogWriter) close() error {
+	return s.writer.Close()
+}
+
+// syslogPriority maps a log level to a syslog priority
+func syslogPriority(level zapcore.Level) int {
+	switch {
+	case level >= zapcore.DPanicLevel:
+		return 2
+	case level == zapcore.ErrorLevel:
+		return 3
+	case level == zapcore.WarnLevel:
+		return 4
+	case level == zapcore.InfoLevel:
+		return 6
+	}
+	return 7
+}
@@ -0,0 +1,7 @@
+//go:build unix && !linux
+
+package logger
+
+func openSystemLog() (systemLogWriter, error) {
+	return openSyslog()
+}
@@ -0,0 +1,70 @@
+//go:build windows
+
+package logger
+
+import (
+	"syscall"
+	"unsafe"
+
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	advapi32                  = syscall.NewLazyDLL("advapi32.dll")
+	procRegisterEventSourceW  = advapi32.NewProc("RegisterEventSourceW")
+	procReportEventW          = advapi32.NewProc("ReportEventW")
+	procDeregisterEventSource = advapi32.NewProc("DeregisterEventSource")
+)
+
+// Event log entry types
+const (
+	eventlogErrorType       = 0x0001
+	eventlogWarningType     = 0x0002
+	eventlogInformationType = 0x0004
+)
+
+// eventLogWriter reports entries to the Windows event log under the
+// go-load-balancer source
+type eventLogWriter struct {
+	handle uintptr
+}
+
+func openSystemLog() (systemLogWriter, error) {
+	source, err := syscall.UTF16PtrFromString(systemLogIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	handle, _, err := procRegisterEventSourceW.Call(0, uintptr(unsafe.Pointer(source)))
+	if handle == 0 {
+		return nil, err
+	}
+	return &eventLogWriter{handle: handle}, nil
+}
+
+func (e *eventLogWriter) write(level zapcore.Level, event, message string, fields map[string]string) error {
+	text, err := syscall.UTF16PtrFromString(formatSystemLogMessage(message, fields))
+	if err != nil {
+		return err
+	}
+
+	eventType := eventlogInformationType
+	switch {
+	case level >= zapcore.ErrorLevel:
+		eventType = eventlogErrorType
+	case level == zapcore.WarnLevel:
+		eventType = eventlogWarningType
+	}
+
+	strings := []*uint16{text}
+	ok, _, err := procReportEventW.Call(e.handle, uintptr(eventType), 0, 1, 0, 1, 0,
+		uintptr(unsafe.Pointer(&strings[0])), 0)
+	if ok == 0 {
+		return err
+	}
+	return nil
+}
+
+func (e *eventLogWriter) close() error {
+	procDeregisterEventSource.Call(e.handle)
+	return nil
+}
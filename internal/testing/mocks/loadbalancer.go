@@ -141,6 +141,7 @@ func (t *TestLoadBalancer) GetBackendByPath(path string) *MockBackend {
 }
 
 func (t *TestLoadBalancer) Shutdown() {
+	balancer.StopRevivals(t.LoadBalancer)
 	for _, backend := range t.Backends {
 		backend.Close()
 	}
@@ -245,6 +246,9 @@ func (c *LoadBalancerTestClient) Close() {
 		defer cancel()
 		c.httpServer.Shutdown(ctx)
 	}
+	if c.LB != nil {
+		balancer.StopRevivals(c.LB)
+	}
 
 	if c.ConfigFilePath != "" {
 		_ = os.Remove(c.ConfigFilePath)
@@ -316,3 +316,32 @@ func BenchmarkHighLoad(b *testing.B) {
 		})
 	}
 }
+
+// BenchmarkWeightedRoundRobinSelection measures picking backends alone,
+// sequentially and from every CPU at once, to catch the cost of keeping
+// concurrent picks on one schedule
+func BenchmarkWeightedRoundRobinSelection(b *testing.B) {
+	configs := make([]balancer.BackendConfig, 10)
+	for i := range configs {
+		configs[i] = balancer.BackendConfig{URL: fmt.Sprintf("http://backend%d:8080", i+1), Weight: i + 1}
+	}
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+
+	b.Run("Sequential", func(b *testing.B) {
+		lb := balancer.NewLoadBalancer(configs)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			lb.GetNextInstance(req)
+		}
+	})
+
+	b.Run("Parallel", func(b *testing.B) {
+		lb := balancer.NewLoadBalancer(configs)
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				lb.GetNextInstance(req)
+			}
+		})
+	})
+}
@@ -0,0 +1,116 @@
+package unit
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestAccessLogUpstreamBreakdown(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	// Nothing listens on this backend, so requests sent to it are retried
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	logPath := filepath.Join(t.TempDir(), "access.log")
+	config := `access_log ` + logPath + `
+	route path /api api
+
+	upstream api {
+		server ` + down.URL + `
+		server ` + backend.URL + `
+	}
+
+	upstream web {
+		server ` + backend.URL + `
+	}`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	cfg.DefaultBackend = "web"
+
+	router, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	lb, err := balancer.NewAccessLogger(router, cfg.AccessLog)
+	if err != nil {
+		t.Fatalf("Failed to create access logger: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, httptest.NewRequest("GET", "http://localhost/api/items", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+	}
+
+	file, err := os.Open(logPath)
+	if err != nil {
+		t.Fatalf("Failed to open access log: %v", err)
+	}
+	defer file.Close()
+
+	type entry struct {
+		URI              string  `json:"uri"`
+		Status           int     `json:"status"`
+		Route            string  `json:"route"`
+		Pool             string  `json:"pool"`
+		Upstream         string  `json:"upstream"`
+		Retries          int     `json:"upstream_retries"`
+		RequestTime      float64 `json:"request_time"`
+		HeaderTime       float64 `json:"upstream_header_time"`
+		UpstreamResponse float64 `json:"upstream_response_time"`
+	}
+
+	var entries []entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("Invalid access log record %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 access log records, got %d", len(entries))
+	}
+
+	// Smooth weighted round robin sends both requests to the dead backend first
+	for _, e := range entries {
+		if e.URI != "/api/items" || e.Status != 200 || e.Route != "/api" || e.Pool != "api" {
+			t.Errorf("Unexpected access log record: %+v", e)
+		}
+		if e.Upstream != backend.URL {
+			t.Errorf("Expected final upstream %s, got %s", backend.URL, e.Upstream)
+		}
+		if e.HeaderTime < 0.02 || e.UpstreamResponse < e.HeaderTime || e.RequestTime < e.UpstreamResponse {
+			t.Errorf("Inconsistent timing breakdown: %+v", e)
+		}
+		if e.Retries != 1 {
+			t.Errorf("Expected 1 retry, got %d", e.Retries)
+		}
+	}
+}
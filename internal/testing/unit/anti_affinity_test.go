@@ -0,0 +1,81 @@
+package unit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestRetryAntiAffinity(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(2)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	var down []string
+	for i := 0; i < 4; i++ {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+		down = append(down, server.URL)
+	}
+
+	// Both pools lose rack a; only the first steers its retries off it
+	config := `upstream backend {
+		anti_affinity on
+		server ` + down[0] + ` zone=rack-a
+		server ` + down[1] + ` zone=rack-a
+		server ` + backends[0] + ` zone=rack-b
+	}
+
+	upstream plain {
+		server ` + down[2] + ` zone=rack-a
+		server ` + down[3] + ` zone=rack-a
+		server ` + backends[1] + ` zone=rack-b
+	}
+
+	route path /plain/ plain`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if !cfg.PoolAntiAffinity["backend"] || cfg.PoolAntiAffinity["plain"] {
+		t.Fatalf("Expected anti-affinity on the backend pool only, got %v", cfg.PoolAntiAffinity)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	attempts := func(path string) []string {
+		ctx := balancer.WithRequestInfo(context.Background())
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, httptest.NewRequest("GET", "http://localhost"+path, nil).WithContext(ctx))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected %s to be served after retries, got %d", path, rec.Code)
+		}
+
+		info, _ := balancer.RequestInfoFromContext(ctx)
+		var backends []string
+		for _, attempt := range info.Attempts {
+			backends = append(backends, attempt.Backend)
+		}
+		return backends
+	}
+
+	if got := attempts("/"); len(got) != 2 || got[0] != down[0] || got[1] != backends[0] {
+		t.Errorf("Expected the retry to leave rack a at once, got attempts %v", got)
+	}
+	if got := attempts("/plain/"); len(got) != 3 || got[1] != down[3] {
+		t.Errorf("Expected the retry to stay in rack a without anti-affinity, got attempts %v", got)
+	}
+}
@@ -0,0 +1,135 @@
+package unit
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestRouteAuthentication(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-User", r.Header.Get("X-User"))
+	}))
+	defer backend.Close()
+
+	authService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Original-URI") != "/api/orders?page=2" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.Header.Get("Authorization") {
+		case "Bearer good":
+			w.Header().Set("X-User", "alice")
+		case "Bearer denied":
+			w.WriteHeader(http.StatusForbidden)
+		case "Bearer broken":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer authService.Close()
+
+	// alice's password is hashed with apr1, bob's with SHA-1
+	sum := sha1.Sum([]byte("s3cret"))
+	htpasswd := filepath.Join(t.TempDir(), "htpasswd")
+	users := "alice:$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/\nbob:{SHA}" + base64.StdEncoding.EncodeToString(sum[:]) + "\n"
+	if err := os.WriteFile(htpasswd, []byte(users), 0600); err != nil {
+		t.Fatalf("Failed to write htpasswd file: %v", err)
+	}
+
+	config := `auth admins basic file=` + htpasswd + ` realm=Admin
+	auth sso request url=` + authService.URL + `/check set_headers=X-User
+
+	upstream backend {
+		server ` + backend.URL + `
+	}
+
+	route path /admin/ backend auth=admins
+	route path /api/ backend auth=sso
+	route path / backend`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	send := func(path string, setup func(*http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://localhost"+path, nil)
+		setup(req)
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req)
+		return rec
+	}
+	basic := func(user, password string) func(*http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(user, password) }
+	}
+	bearer := func(token string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+
+	if rec := send("/", func(*http.Request) {}); rec.Code != http.StatusOK {
+		t.Errorf("Expected open route to be served, got %d", rec.Code)
+	}
+
+	rec := send("/admin/", func(*http.Request) {})
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") != `Basic realm="Admin"` {
+		t.Errorf("Expected a basic auth challenge, got %d %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+	for _, c := range []struct {
+		user, password string
+		status         int
+	}{
+		{"alice", "myPassword", http.StatusOK},
+		{"bob", "s3cret", http.StatusOK},
+		{"alice", "wrong", http.StatusUnauthorized},
+		{"carol", "myPassword", http.StatusUnauthorized},
+	} {
+		if rec := send("/admin/", basic(c.user, c.password)); rec.Code != c.status {
+			t.Errorf("%s: expected status %d, got %d", c.user, c.status, rec.Code)
+		}
+	}
+
+	rec = send("/api/orders?page=2", bearer("good"))
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Seen-User") != "alice" {
+		t.Errorf("Expected authorized request with the user header, got %d %q", rec.Code, rec.Header().Get("X-Seen-User"))
+	}
+
+	rec = send("/api/orders?page=2", func(*http.Request) {})
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") != `Bearer realm="api"` {
+		t.Errorf("Expected the service's challenge, got %d %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+	if rec := send("/api/orders?page=2", bearer("denied")); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", rec.Code)
+	}
+	rec = send("/api/orders?page=2", bearer("broken"))
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get(balancer.RejectReasonHeader) != string(balancer.RejectAuthUnavailable) {
+		t.Errorf("Expected status 500 for a failing service, got %d", rec.Code)
+	}
+
+	// Only hashes the balancer can check are accepted
+	if err := os.WriteFile(htpasswd, []byte("dave:$2y$05$abcdefghijklmnopqrstuv\n"), 0600); err != nil {
+		t.Fatalf("Failed to write htpasswd file: %v", err)
+	}
+	if _, err := balancer.CreatePathRouter(cfg); err == nil {
+		t.Errorf("Expected unsupported password hashes to be rejected")
+	}
+}
@@ -0,0 +1,117 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestBackendHealthOverrides(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(1)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	configPath, err := testutils.CreateTempConfig(`upstream backend {
+		method weighted_round_robin
+		server ` + down.URL + ` weight=5
+		server ` + backends[0] + ` weight=1
+	}
+
+	route path / backend`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	defer balancer.StopRevivals(lb)
+
+	send := func(n int) {
+		for i := 0; i < n; i++ {
+			lb.ProxyRequest(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		}
+	}
+	health := func() map[string]balancer.BackendHealth {
+		rec := httptest.NewRecorder()
+		balancer.BackendsHandler(lb)(rec, httptest.NewRequest("GET", "/api/backends", nil))
+		var list []balancer.BackendHealth
+		if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+			t.Fatalf("Failed to decode backend health: %v", err)
+		}
+		byID := make(map[string]balancer.BackendHealth)
+		for _, h := range list {
+			byID[h.ID] = h
+		}
+		return byID
+	}
+	override := func(path string) int {
+		rec := httptest.NewRecorder()
+		balancer.BackendOverrideHandler(lb)(rec, httptest.NewRequest("POST", path, nil))
+		return rec.Code
+	}
+
+	send(10)
+	h := health()
+	if h["backend-0"].State != "dead" || h["backend-0"].Failures < 3 || h["backend-0"].LastFailure == nil {
+		t.Fatalf("Expected the unreachable backend dead with its failures, got %+v", h["backend-0"])
+	}
+	if !strings.Contains(h["backend-0"].LastFailure.Reason, "refused") {
+		t.Errorf("Expected the connection failure as the reason, got %q", h["backend-0"].LastFailure.Reason)
+	}
+	if h["backend-1"].State != "alive" || h["backend-1"].Failures != 0 {
+		t.Errorf("Expected the reachable backend alive, got %+v", h["backend-1"])
+	}
+
+	// An enabled backend stays in rotation however it fails
+	if code := override("/api/backends/backend-0/enable"); code != http.StatusOK {
+		t.Fatalf("Expected enable to succeed, got %d", code)
+	}
+	failures := health()["backend-0"].Failures
+	send(10)
+	h = health()
+	if h["backend-0"].State != "alive" || h["backend-0"].Override != "enable" || h["backend-0"].Failures <= failures {
+		t.Errorf("Expected the enabled backend to fail but stay alive, got %+v", h["backend-0"])
+	}
+
+	// A drained backend ignores its own readiness announcements
+	if code := override("/api/backends/backend-1/drain"); code != http.StatusOK {
+		t.Fatalf("Expected drain to succeed, got %d", code)
+	}
+	form := url.Values{"backend": {backends[0]}, "state": {"ready"}}
+	req := httptest.NewRequest("POST", "/api/backends/drain", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	balancer.DrainHandler(lb)(httptest.NewRecorder(), req)
+	if h := health()["backend-1"]; h.State != "draining" || h.Override != "drain" {
+		t.Errorf("Expected the backend to stay drained, got %+v", h)
+	}
+
+	if code := override("/api/backends/backend-1/auto"); code != http.StatusOK {
+		t.Fatalf("Expected auto to succeed, got %d", code)
+	}
+	if h := health()["backend-1"]; h.State != "alive" || h.Override != "none" {
+		t.Errorf("Expected the backend back in rotation, got %+v", h)
+	}
+
+	if code := override("/api/backends/backend-9/drain"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown backend, got %d", code)
+	}
+	if code := override("/api/backends/backend-0/restart"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown action, got %d", code)
+	}
+}
@@ -0,0 +1,125 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestBotFilter(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(1)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	newBalancer := func(rules string) (balancer.LoadBalancerStrategy, error) {
+		configPath, err := testutils.CreateTempConfig(rules + `
+
+		upstream backend {
+			server ` + backends[0] + `
+		}`)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		cfg, err := balancer.ParseConfig(configPath)
+		if err != nil {
+			return nil, err
+		}
+		router, err := balancer.CreatePathRouter(cfg)
+		if err != nil {
+			t.Fatalf("Failed to create path router: %v", err)
+		}
+		return balancer.ApplyGlobalMiddleware(router, cfg), nil
+	}
+
+	lb, err := newBalancer(`block user_agent "sqlmap|Nikto scanner"
+	block path /internal
+	block scanner_paths drop
+	block rate 5/s burst=5 ban=200ms`)
+	if err != nil {
+		t.Fatalf("Failed to create balancer: %v", err)
+	}
+	send := func(ip, path, userAgent string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://localhost"+path, nil)
+		req.RemoteAddr = ip + ":12345"
+		req.Header.Set("User-Agent", userAgent)
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req)
+		return rec
+	}
+
+	for _, tc := range []struct {
+		path, userAgent string
+		code            int
+	}{
+		{"/", "Mozilla/5.0", http.StatusOK},
+		{"/", "sqlmap/1.7", http.StatusForbidden},
+		{"/", "Mozilla/5.0 (nikto SCANNER)", http.StatusForbidden},
+		{"/internal/metrics", "curl/8.0", http.StatusForbidden},
+		{"/internal", "curl/8.0", http.StatusForbidden},
+		{"/internals", "curl/8.0", http.StatusOK},
+		{"/api//../internal/", "curl/8.0", http.StatusForbidden},
+	} {
+		rec := send("10.4.0.1", tc.path, tc.userAgent)
+		if rec.Code != tc.code {
+			t.Errorf("%s with %q: expected status %d, got %d", tc.path, tc.userAgent, tc.code, rec.Code)
+		}
+		if tc.code == http.StatusForbidden && rec.Header().Get(balancer.RejectReasonHeader) != "blocked" {
+			t.Errorf("%s: expected the blocked reason, got %q", tc.path, rec.Header().Get(balancer.RejectReasonHeader))
+		}
+	}
+
+	// Scanner paths are dropped without a response
+	server := httptest.NewServer(http.HandlerFunc(lb.ProxyRequest))
+	defer server.Close()
+	if resp, err := http.Get(server.URL + "/.env"); err == nil {
+		resp.Body.Close()
+		t.Errorf("Expected the connection to be closed, got status %d", resp.StatusCode)
+	}
+	if resp, err := http.Get(server.URL + "/WP-Admin/install.php"); err == nil {
+		resp.Body.Close()
+		t.Errorf("Expected the connection to be closed, got status %d", resp.StatusCode)
+	}
+
+	// A client over the rate is banned for a while, others are not
+	for i := 0; i < 5; i++ {
+		if rec := send("10.4.0.2", "/", ""); rec.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected status 200, got %d", i+1, rec.Code)
+		}
+	}
+	if rec := send("10.4.0.2", "/", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected the client over the rate to be blocked, got %d", rec.Code)
+	}
+	if rec := send("10.4.0.3", "/", ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected another client to pass, got %d", rec.Code)
+	}
+	time.Sleep(250 * time.Millisecond)
+	if rec := send("10.4.0.2", "/", ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected the ban to be over, got %d", rec.Code)
+	}
+
+	blocks := balancer.GetStats(lb).Blocks
+	if blocks["user_agent sqlmap|Nikto scanner"] != 2 || blocks["path /internal"] != 3 ||
+		blocks["scanner_paths"] != 2 || blocks["rate 5/s"] != 1 {
+		t.Errorf("Expected the blocks to be counted per rule, got %v", blocks)
+	}
+
+	for _, tc := range []struct{ rule, want string }{
+		{"block user_agent (", "invalid user_agent pattern"},
+		{"block path internal", "expected an absolute path"},
+		{"block rate fast", "invalid rate"},
+		{"block rate 5/s ban=forever", "invalid ban"},
+		{"block referer spam", "unknown block rule"},
+		{"block scanner_paths quietly", "unknown block option"},
+	} {
+		if _, err := newBalancer(tc.rule); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.rule, tc.want, err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestBlueGreenSwitchover(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(1)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	var failing atomic.Bool
+	green := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Backend-ID", "green")
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer green.Close()
+
+	config := `upstream backend {
+		server ` + backends[0] + `
+	}
+
+	upstream app_green {
+		server ` + green.URL + `
+	}
+
+	route path /app/ backend green=app_green`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	handler := balancer.BlueGreenHandler(lb)
+
+	backendOf := func() string {
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, httptest.NewRequest("GET", "http://localhost/app/", nil))
+		return rec.Header().Get("X-Backend-ID")
+	}
+	switchTo := func(form url.Values) (*httptest.ResponseRecorder, balancer.BlueGreenStatus) {
+		req := httptest.NewRequest("POST", "/api/routes/switch", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var status balancer.BlueGreenStatus
+		json.NewDecoder(rec.Body).Decode(&status)
+		return rec, status
+	}
+
+	if got := backendOf(); got != "1" {
+		t.Fatalf("Expected the blue pool before the switch, got backend %q", got)
+	}
+
+	// A healthy green pool keeps the traffic
+	rec, status := switchTo(url.Values{"route": {"/app/"}, "to": {"green"}, "probation": {"1m"}})
+	if rec.Code != http.StatusOK || status.Active != "green" || status.ProbationUntil == nil {
+		t.Fatalf("Expected the route on green and on probation, got %d %+v", rec.Code, status)
+	}
+	for i := 0; i < 20; i++ {
+		if got := backendOf(); got != "green" {
+			t.Fatalf("Expected the green pool after the switch, got backend %q", got)
+		}
+	}
+
+	// Switching back and forth again, a failing green pool is rolled back
+	switchTo(url.Values{"route": {"/app/"}, "to": {"blue"}})
+	failing.Store(true)
+	switchTo(url.Values{"route": {"/app/"}, "probation": {"1m"}, "max_error_rate": {"50%"}})
+	for i := 0; i < 10; i++ {
+		backendOf()
+	}
+	if got := backendOf(); got != "1" {
+		t.Errorf("Expected the failing green pool to be rolled back, got backend %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/routes/switch", nil))
+	var statuses []balancer.BlueGreenStatus
+	if err := json.NewDecoder(rec.Body).Decode(&statuses); err != nil || len(statuses) != 1 {
+		t.Fatalf("Expected the status of one route, got %v", err)
+	}
+	if statuses[0].Active != "blue" || !statuses[0].RolledBack || statuses[0].Green != "app_green" {
+		t.Errorf("Expected a rolled back switch, got %+v", statuses[0])
+	}
+
+	rec, _ = switchTo(url.Values{"route": {"/missing/"}})
+	if rec.Code != http.StatusNotFound || rec.Header().Get(balancer.ErrorCodeHeader) != "route_not_found" {
+		t.Errorf("Expected a route_not_found 404, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,136 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestRouteResponseCache(t *testing.T) {
+	var fetches, revalidations int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/static/private" {
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&revalidations, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(&fetches, 1)
+		w.Write([]byte("content of " + r.URL.Path))
+	}))
+	defer backend.Close()
+
+	config := `cache static_unit ttl=100ms max_size=1MB
+	cache disk_unit ttl=1m max_size=1MB dir=` + t.TempDir() + `
+	route path /static/ static cache=static_unit
+	route path /disk/ static cache=disk_unit
+
+	upstream static {
+		server ` + backend.URL + `
+	}`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	// Zones outlive routers, so start from an empty cache
+	balancer.PurgeCache("/")
+	before := balancer.GetCacheStats()["static_unit"]
+
+	send := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://localhost"+path, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req)
+		return rec
+	}
+
+	expectCache := func(rec *httptest.ResponseRecorder, state string) {
+		t.Helper()
+		if got := rec.Header().Get(balancer.CacheHeader); got != state {
+			t.Errorf("Expected %s %q, got %q", balancer.CacheHeader, state, got)
+		}
+	}
+
+	expectCache(send("/static/app.js", nil), "MISS")
+	rec := send("/static/app.js", nil)
+	expectCache(rec, "HIT")
+	if rec.Body.String() != "content of /static/app.js" {
+		t.Errorf("Unexpected cached body: %q", rec.Body.String())
+	}
+	if got := atomic.LoadInt32(&fetches); got != 1 {
+		t.Errorf("Expected 1 fetch from the backend, got %d", got)
+	}
+
+	// Conditional requests matching the cached ETag get a 304
+	if rec := send("/static/app.js", http.Header{"If-None-Match": {`"v1"`}}); rec.Code != http.StatusNotModified {
+		t.Errorf("Expected status 304 for a matching ETag, got %d", rec.Code)
+	}
+
+	// Stale responses are revalidated with their ETag
+	time.Sleep(150 * time.Millisecond)
+	rec = send("/static/app.js", nil)
+	expectCache(rec, "REVALIDATED")
+	if rec.Code != http.StatusOK || rec.Body.String() != "content of /static/app.js" {
+		t.Errorf("Expected the revalidated cached response, got %d: %q", rec.Code, rec.Body.String())
+	}
+	if got := atomic.LoadInt32(&revalidations); got != 1 {
+		t.Errorf("Expected 1 revalidation, got %d", got)
+	}
+
+	// Uncacheable responses and authorized requests go to the backend
+	send("/static/private", nil)
+	expectCache(send("/static/private", nil), "MISS")
+	if rec := send("/static/app.js", http.Header{"Authorization": {"Bearer token"}}); rec.Header().Get(balancer.CacheHeader) != "" {
+		t.Errorf("Expected authorized requests to bypass the cache")
+	}
+
+	// Disk zones serve hits the same way
+	send("/disk/logo.png", nil)
+	rec = send("/disk/logo.png", nil)
+	expectCache(rec, "HIT")
+	if rec.Body.String() != "content of /disk/logo.png" {
+		t.Errorf("Unexpected body from disk cache: %q", rec.Body.String())
+	}
+
+	// Purging by prefix empties the matching entries of every zone
+	form := url.Values{"prefix": {"/static/"}}
+	req := httptest.NewRequest("POST", "/api/cache/purge", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	purgeRec := httptest.NewRecorder()
+	balancer.CachePurgeHandler()(purgeRec, req)
+	if !strings.Contains(purgeRec.Body.String(), `"purged":1`) {
+		t.Errorf("Expected 1 purged response, got %s", purgeRec.Body.String())
+	}
+
+	expectCache(send("/static/app.js", nil), "MISS")
+	expectCache(send("/disk/logo.png", nil), "HIT")
+
+	stats := balancer.GetCacheStats()["static_unit"]
+	if stats.Hits-before.Hits != 2 || stats.Revalidated-before.Revalidated != 1 || stats.Entries != 1 {
+		t.Errorf("Unexpected cache stats: %+v", stats)
+	}
+}
@@ -0,0 +1,87 @@
+package unit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestRouteCanaryByUser(t *testing.T) {
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+	}
+	stable, canary := newBackend("stable"), newBackend("canary")
+	defer stable.Close()
+	defer canary.Close()
+
+	config := `route path /api stable canary=canary:30 canary_key=header:X-User-ID
+
+	upstream stable {
+		server ` + stable.URL + `
+	}
+
+	upstream canary {
+		server ` + canary.URL + `
+	}`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	send := func(user string) string {
+		req := httptest.NewRequest("GET", "http://localhost/api", nil)
+		if user != "" {
+			req.Header.Set("X-User-ID", user)
+		}
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req)
+		return rec.Body.String()
+	}
+
+	before := balancer.GetCanaryStats()["/api"]
+
+	users := 500
+	canaryUsers := 0
+	for i := 0; i < users; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		first := send(user)
+		for j := 0; j < 3; j++ {
+			if got := send(user); got != first {
+				t.Fatalf("User %s moved from %s to %s", user, first, got)
+			}
+		}
+		if first == "canary" {
+			canaryUsers++
+		}
+	}
+
+	if share := float64(canaryUsers) / float64(users); share < 0.2 || share > 0.4 {
+		t.Errorf("Expected about 30%% of users in the canary, got %.1f%%", share*100)
+	}
+
+	if got := send(""); got != "stable" {
+		t.Errorf("Expected requests without a user key to go to the stable pool, got %s", got)
+	}
+
+	stats := balancer.GetCanaryStats()["/api"]
+	if stats.Canary-before.Canary != int64(canaryUsers*4) || stats.Stable-before.Stable != int64((users-canaryUsers)*4+1) {
+		t.Errorf("Unexpected canary stats: %+v", stats)
+	}
+}
@@ -0,0 +1,162 @@
+package unit
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+// writeCertificate writes a self-signed certificate for a name and its key
+func writeCertificate(t *testing.T, dir, name string, serial int64) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	// Rewritten files are told apart by their modification time
+	modified := time.Now().Add(time.Duration(serial) * time.Second)
+	os.Chtimes(certFile, modified, modified)
+	os.Chtimes(keyFile, modified, modified)
+	return certFile, keyFile
+}
+
+func TestCertificateStore(t *testing.T) {
+	dir := t.TempDir()
+	apiCert, apiKey := writeCertificate(t, dir, "api.example.com", 1)
+	webCert, webKey := writeCertificate(t, dir, "web.example.com", 2)
+
+	configPath, err := testutils.CreateTempConfig(`tls_certificate ` + apiCert + ` ` + apiKey + `
+	tls_certificate ` + webCert + ` ` + webKey + `
+	tls_reload 20ms
+	upstream backend {
+		server http://localhost:8081
+	}`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if len(cfg.TLSCertificates) != 2 || cfg.TLSCertFile != apiCert || cfg.TLSReload != 20*time.Millisecond {
+		t.Fatalf("Unexpected TLS settings: %+v, %s, %v", cfg.TLSCertificates, cfg.TLSCertFile, cfg.TLSReload)
+	}
+
+	store, err := balancer.NewCertificateStore(cfg.TLSCertificates, cfg.TLSReload)
+	if err != nil {
+		t.Fatalf("Failed to load certificates: %v", err)
+	}
+	store.Start()
+	defer store.Stop()
+
+	tlsConfig := &tls.Config{}
+	store.Configure(tlsConfig)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	served := func(serverName string) *x509.Certificate {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("%s: handshake failed: %v", serverName, err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0]
+	}
+
+	// Each name gets its own certificate, and unknown names the first
+	for serverName, want := range map[string]string{
+		"api.example.com":   "api.example.com",
+		"web.example.com":   "web.example.com",
+		"other.example.com": "api.example.com",
+	} {
+		if got := served(serverName).Subject.CommonName; got != want {
+			t.Errorf("%s: expected the certificate of %s, got %s", serverName, want, got)
+		}
+	}
+
+	// A rotated certificate is served once its files change
+	writeCertificate(t, dir, "web.example.com", 3)
+	testutils.AssertEventually(t, func() bool {
+		return served("web.example.com").SerialNumber.Int64() == 3
+	}, 2*time.Second, "Expected the rotated certificate to be served")
+
+	// A broken certificate keeps the previous one in service
+	os.WriteFile(apiKey, []byte("not a key"), 0600)
+	store.Reload()
+	if got := served("api.example.com").SerialNumber.Int64(); got != 1 {
+		t.Errorf("Expected the previous certificate to be kept, got serial %d", got)
+	}
+
+	for _, tc := range []struct{ directive, want string }{
+		{"tls_certificate " + apiCert, "requires a certificate and key file"},
+		{"tls_reload soon", "invalid tls_reload interval"},
+		{"tls_reload", "requires an interval or off"},
+	} {
+		configPath, err := testutils.CreateTempConfig(tc.directive + `
+		upstream backend {
+			server http://localhost:8081
+		}`)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.directive, tc.want, err)
+		}
+	}
+
+	if _, err := balancer.NewCertificateStore([]balancer.TLSCertificateConfig{{CertFile: filepath.Join(dir, "missing.crt"), KeyFile: apiKey}}, 0); err == nil {
+		t.Error("Expected a missing certificate to fail to load")
+	}
+}
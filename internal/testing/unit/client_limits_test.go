@@ -0,0 +1,109 @@
+package unit
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestClientLimits(t *testing.T) {
+	configPath, err := testutils.CreateTempConfig(`client_timeouts header=100ms idle=1m
+	client_max_header_size 4KB
+	client_conn_limit 2
+
+	upstream backend {
+		server http://localhost:8081
+	}`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	limits := cfg.ClientLimits
+	if limits.HeaderTimeout != 100*time.Millisecond || limits.IdleTimeout != time.Minute || limits.MaxHeaderBytes != 4096 || limits.MaxConnsPerIP != 2 {
+		t.Fatalf("Unexpected client limits: %+v", limits)
+	}
+
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})}
+	balancer.ConfigureClientLimits(server, limits)
+	go server.Serve(balancer.NewConnLimitListener(raw, limits.MaxConnsPerIP))
+	defer server.Close()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", raw.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		return conn
+	}
+	closed := func(conn net.Conn, within time.Duration) bool {
+		conn.SetReadDeadline(time.Now().Add(within))
+		_, err := conn.Read(make([]byte, 1024))
+		return err == io.EOF
+	}
+
+	// A client sending its headers too slowly is cut off
+	slow := dial()
+	defer slow.Close()
+	io.WriteString(slow, "GET / HTTP/1.1\r\nHost: localhost\r\n")
+	if !closed(slow, time.Second) {
+		t.Error("Expected the connection of a slow client to be closed")
+	}
+
+	// A client holds at most two connections at once
+	first, second := dial(), dial()
+	defer first.Close()
+	defer second.Close()
+	if third := dial(); !closed(third, time.Second) {
+		t.Error("Expected the third connection of the client to be closed")
+	}
+	if balancer.GetConnsRefused() < 1 {
+		t.Error("Expected the refused connection to be counted")
+	}
+	first.Close()
+	testutils.AssertEventually(t, func() bool {
+		conn := dial()
+		defer conn.Close()
+		io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+		body, _ := io.ReadAll(conn)
+		return strings.HasSuffix(string(body), "ok")
+	}, time.Second, "Expected a connection once another one is closed")
+
+	// Headers over the limit are refused
+	large := dial()
+	defer large.Close()
+	io.WriteString(large, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Large: "+strings.Repeat("a", 8192)+"\r\n\r\n")
+	response, _ := io.ReadAll(large)
+	if !strings.Contains(string(response), "431") {
+		t.Errorf("Expected headers over the limit to be refused, got %q", response)
+	}
+
+	for _, tc := range []struct{ directive, want string }{
+		{"client_timeouts header=soon", "invalid client header timeout"},
+		{"client_timeouts linger=1s", "unknown client_timeouts option"},
+		{"client_max_header_size huge", "invalid client_max_header_size"},
+		{"client_conn_limit 0", "invalid client_conn_limit"},
+	} {
+		configPath, err := testutils.CreateTempConfig(tc.directive)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.directive, tc.want, err)
+		}
+	}
+}
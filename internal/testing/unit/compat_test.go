@@ -0,0 +1,138 @@
+package unit
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+// startLegacyBackend starts a backend speaking plain HTTP/1.0 that sends the
+// raw requests it receives to the returned channel
+func startLegacyBackend(t *testing.T) (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	requests := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			reader := bufio.NewReader(conn)
+			var raw strings.Builder
+			length := 0
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					break
+				}
+				raw.WriteString(line)
+				if name, value, ok := strings.Cut(strings.TrimSpace(line), ": "); ok && strings.EqualFold(name, "Content-Length") {
+					length, _ = strconv.Atoi(value)
+				}
+				if line == "\r\n" {
+					break
+				}
+			}
+			body := make([]byte, length)
+			io.ReadFull(reader, body)
+			raw.Write(body)
+			requests <- raw.String()
+
+			conn.Write([]byte("HTTP/1.0 200 OK\r\nContent-Type: text/plain\r\n\r\nlegacy ok"))
+			conn.Close()
+		}
+	}()
+
+	return "http://" + listener.Addr().String(), requests
+}
+
+func TestCompatibilityMode(t *testing.T) {
+	legacyURL, requests := startLegacyBackend(t)
+
+	modern := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Length", strconv.FormatInt(r.ContentLength, 10))
+		w.Header().Set("X-Seen-Encoding", strings.Join(r.TransferEncoding, ","))
+	}))
+	defer modern.Close()
+
+	config := `upstream backend {
+		server ` + legacyURL + `
+		compat http1.0 header_case=SOAPAction
+	}
+
+	upstream modern {
+		server ` + modern.URL + `
+		compat no_chunked
+	}
+
+	route path /modern/ modern
+	route path / backend`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	// A body of unknown length would be sent chunked by default
+	send := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "http://localhost"+path, io.NopCloser(strings.NewReader("ping")))
+		req.ContentLength = -1
+		req.Header.Set("SOAPAction", "urn:ping")
+		req.Header.Set("Connection", "keep-alive, X-Hop")
+		req.Header.Set("X-Hop", "1")
+		req.Header.Set("Keep-Alive", "timeout=5")
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req)
+		return rec
+	}
+
+	rec := send("/svc")
+	if rec.Code != http.StatusOK || rec.Body.String() != "legacy ok" {
+		t.Fatalf("Expected the legacy backend's response, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	raw := <-requests
+	if !strings.HasPrefix(raw, "POST /svc HTTP/1.0\r\n") {
+		t.Errorf("Expected an HTTP/1.0 request line, got:\n%s", raw)
+	}
+	for _, expected := range []string{"\r\nSOAPAction: urn:ping\r\n", "\r\nContent-Length: 4\r\n", "\r\nConnection: close\r\n", "\r\n\r\nping"} {
+		if !strings.Contains(raw, expected) {
+			t.Errorf("Expected %q in the request, got:\n%s", expected, raw)
+		}
+	}
+	for _, unexpected := range []string{"Transfer-Encoding", "X-Hop", "Keep-Alive", "keep-alive"} {
+		if strings.Contains(raw, unexpected) {
+			t.Errorf("Expected no %q in the request, got:\n%s", unexpected, raw)
+		}
+	}
+
+	rec = send("/modern/")
+	if rec.Header().Get("X-Seen-Length") != "4" || rec.Header().Get("X-Seen-Encoding") != "" {
+		t.Errorf("Expected an unchunked body with a length, got length %s and encoding %q",
+			rec.Header().Get("X-Seen-Length"), rec.Header().Get("X-Seen-Encoding"))
+	}
+}
@@ -0,0 +1,106 @@
+package unit
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestResponseCompression(t *testing.T) {
+	large := strings.Repeat("compress me ", 200)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("tiny"))
+		case "/encoded":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "identity")
+			w.Write([]byte(large))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(large))
+		default:
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Header().Set("ETag", `"abc"`)
+			w.Write([]byte(large))
+		}
+	}))
+	defer backend.Close()
+
+	config := `compression gzip deflate min_size=1KB
+
+	upstream backend {
+		server ` + backend.URL + `
+	}`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	lb = balancer.ApplyGlobalMiddleware(lb, cfg)
+
+	send := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://localhost"+path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req)
+		return rec
+	}
+
+	rec := send("/data", "br;q=1, gzip;q=0.8")
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected gzip encoding, got %q", rec.Header().Get("Content-Encoding"))
+	}
+	if rec.Header().Get("Vary") != "Accept-Encoding" || rec.Header().Get("ETag") != `W/"abc"` {
+		t.Errorf("Unexpected headers on compressed response: %v", rec.Header())
+	}
+	reader, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("Invalid gzip body: %v", err)
+	}
+	body, _ := io.ReadAll(reader)
+	if string(body) != large {
+		t.Errorf("Decompressed body does not match the original")
+	}
+
+	if rec := send("/data", "deflate"); rec.Header().Get("Content-Encoding") != "deflate" {
+		t.Errorf("Expected deflate encoding, got %q", rec.Header().Get("Content-Encoding"))
+	}
+
+	// Small responses, other media types, encoded responses and clients
+	// refusing every configured encoding are passed through unchanged
+	for _, c := range []struct{ path, accept string }{
+		{"/small", "gzip"},
+		{"/image", "gzip"},
+		{"/encoded", "gzip"},
+		{"/data", "gzip;q=0, identity"},
+		{"/data", ""},
+	} {
+		rec := send(c.path, c.accept)
+		if encoding := rec.Header().Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+			t.Errorf("%s with Accept-Encoding %q: expected no encoding, got %q", c.path, c.accept, encoding)
+		}
+		if c.path == "/small" && rec.Body.String() != "tiny" {
+			t.Errorf("Expected small body to be passed through, got %q", rec.Body.String())
+		}
+	}
+}
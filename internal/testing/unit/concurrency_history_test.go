@@ -0,0 +1,45 @@
+package unit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+)
+
+func TestConcurrencyHistoryRemovedBackend(t *testing.T) {
+	pool := balancer.NewLoadBalancer([]balancer.BackendConfig{
+		{URL: "http://backend1:8080", Weight: 1},
+		{URL: "http://backend2:8080", Weight: 1},
+	})
+	sampler := balancer.NewConcurrencySampler(balancer.NewPoolStrategy(pool), 3)
+
+	removed := pool.Backends()[1]
+	removed.IncrementConnections()
+	defer removed.DecrementConnections()
+	start := time.Now()
+	sampler.Sample(start)
+	sampler.Sample(start.Add(time.Second))
+
+	// Once removed, the backend samples zero, so the ring wrapping around
+	// shows no phantom load from its old samples against new timestamps
+	pool.UpdateBackends([]balancer.BackendConfig{{URL: "http://backend1:8080", Weight: 1}})
+	sampler.Sample(start.Add(2 * time.Second))
+	sampler.Sample(start.Add(3 * time.Second))
+
+	history := sampler.History()
+	if got := history.Backends["http://backend2:8080"]; !reflect.DeepEqual(got, []int32{1, 0, 0}) {
+		t.Errorf("Expected the removed backend to sample zero once removed, got %v", got)
+	}
+
+	// Once its samples wrap out of the ring, the backend is dropped
+	sampler.Sample(start.Add(4 * time.Second))
+	history = sampler.History()
+	if _, ok := history.Backends["http://backend2:8080"]; ok {
+		t.Errorf("Expected the removed backend to be dropped, got %v", history.Backends)
+	}
+	if got := history.Backends["http://backend1:8080"]; len(got) != 3 || len(history.Timestamps) != 3 {
+		t.Errorf("Expected 3 samples of the remaining backend, got %v at %v", got, history.Timestamps)
+	}
+}
@@ -0,0 +1,96 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestEffectiveConfigEndpoint(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(3)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	config := `upstream backend {
+		method least_connections
+		server ` + backends[0] + ` weight=3
+		server ` + backends[1] + `
+	}
+
+	upstream app_green {
+		server ` + backends[2] + `
+	}
+
+	route path /app/ backend green=app_green`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	// Change the running configuration through the admin API
+	post := func(handler http.HandlerFunc, form url.Values) {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Admin request %v failed with %d: %s", form, rec.Code, rec.Body.String())
+		}
+	}
+	post(balancer.DrainHandler(lb), url.Values{"backend": {backends[1]}, "state": {"drain"}})
+	post(balancer.BlueGreenHandler(lb), url.Values{"route": {"/app/"}, "to": {"green"}, "probation": {"0s"}})
+
+	rec := httptest.NewRecorder()
+	balancer.ConfigHandler(lb, cfg)(rec, httptest.NewRequest("GET", "/api/config", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+
+	var effective balancer.EffectiveConfig
+	if err := json.NewDecoder(rec.Body).Decode(&effective); err != nil {
+		t.Fatalf("Failed to decode configuration: %v", err)
+	}
+
+	if len(effective.Pools) != 2 || effective.Pools[0].Name != "app_green" || effective.Pools[1].Name != "backend" {
+		t.Fatalf("Expected the app_green and backend pools, got %+v", effective.Pools)
+	}
+	pool := effective.Pools[1]
+	if pool.Method != "Least Connections" || len(pool.Backends) != 2 {
+		t.Fatalf("Expected the backend pool to balance 2 backends by least connections, got %+v", pool)
+	}
+	for _, backend := range pool.Backends {
+		switch backend.URL {
+		case backends[0]:
+			if backend.Weight != 3 || backend.Draining {
+				t.Errorf("Expected %s to have weight 3 and not drain, got %+v", backend.URL, backend)
+			}
+		case backends[1]:
+			if !backend.Draining {
+				t.Errorf("Expected %s to be draining, got %+v", backend.URL, backend)
+			}
+		default:
+			t.Errorf("Unexpected backend %s", backend.URL)
+		}
+	}
+
+	if len(effective.Routes) != 1 || effective.Routes[0].ActivePool != "app_green" {
+		t.Errorf("Expected the route to be switched to app_green, got %+v", effective.Routes)
+	}
+}
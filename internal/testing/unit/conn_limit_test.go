@@ -0,0 +1,163 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func createBlockingLoadBalancer(t *testing.T, serverParams, directives string) (balancer.LoadBalancerStrategy, chan struct{}, chan struct{}, func()) {
+	started := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-unblock
+	}))
+
+	config := `upstream backend {
+		method least_connections
+		server ` + backend.URL + ` ` + serverParams + `
+		` + directives + `
+	}`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	return lb, started, unblock, backend.Close
+}
+
+func TestMaxConnsRejectsWhenSaturated(t *testing.T) {
+	lb, started, unblock, cleanup := createBlockingLoadBalancer(t, "max_conn=1", "")
+	defer cleanup()
+
+	done := make(chan struct{})
+	go func() {
+		lb.ProxyRequest(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/", nil))
+		close(done)
+	}()
+	<-started
+
+	rec := httptest.NewRecorder()
+	lb.ProxyRequest(rec, httptest.NewRequest("GET", "http://localhost/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", rec.Code)
+	}
+	if reason := rec.Header().Get(balancer.RejectReasonHeader); reason != string(balancer.RejectBackendsSaturated) {
+		t.Errorf("Expected reject reason %q, got %q", balancer.RejectBackendsSaturated, reason)
+	}
+
+	close(unblock)
+	<-done
+}
+
+func TestMaxConnsQueuesWhenSaturated(t *testing.T) {
+	lb, started, unblock, cleanup := createBlockingLoadBalancer(t, "max_conn=1", "queue 10 timeout=5s")
+	defer cleanup()
+
+	go lb.ProxyRequest(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/", nil))
+	<-started
+
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, httptest.NewRequest("GET", "http://localhost/", nil))
+		done <- rec.Code
+	}()
+
+	select {
+	case <-started:
+		t.Fatalf("Queued request reached the backend before a slot was free")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	close(unblock)
+	<-started
+
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("Expected queued request to succeed, got status %d", code)
+	}
+}
+
+func TestPoolConcurrencyCeiling(t *testing.T) {
+	lb, started, unblock, cleanup := createBlockingLoadBalancer(t, "", "pool_limit max_concurrent=1")
+	defer cleanup()
+
+	done := make(chan struct{})
+	go func() {
+		lb.ProxyRequest(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/", nil))
+		close(done)
+	}()
+	<-started
+
+	rec := httptest.NewRecorder()
+	lb.ProxyRequest(rec, httptest.NewRequest("GET", "http://localhost/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", rec.Code)
+	}
+	if reason := rec.Header().Get(balancer.RejectReasonHeader); reason != string(balancer.RejectPoolConcurrency) {
+		t.Errorf("Expected reject reason %q, got %q", balancer.RejectPoolConcurrency, reason)
+	}
+
+	close(unblock)
+	<-done
+}
+
+func TestConnectionAccountingAcrossRetriesAndStreaming(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	var lb *balancer.LeastConnectionsBalancer
+	var duringDown, duringUp int32
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		duringDown = lb.ProcessPack[0].GetActiveConnections()
+		duringUp = lb.ProcessPack[1].GetActiveConnections()
+
+		// A streamed response written in several chunks
+		for i := 0; i < 3; i++ {
+			w.Write([]byte("chunk\n"))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer up.Close()
+
+	// The unreachable backend wins ties on weight, so it is tried first
+	lb = balancer.NewLeastConnectionsBalancer([]balancer.BackendConfig{
+		{URL: down.URL, Weight: 2},
+		{URL: up.URL, Weight: 1},
+	})
+	defer balancer.StopRevivals(balancer.NewPoolStrategy(lb))
+
+	rec := httptest.NewRecorder()
+	lb.ProxyRequest(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "chunk\nchunk\nchunk\n" {
+		t.Fatalf("Expected the retry to stream the response, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	if duringDown != 0 {
+		t.Errorf("Expected the failed backend to release its connection before the retry, got %d", duringDown)
+	}
+	if duringUp != 1 {
+		t.Errorf("Expected the serving backend to count one connection, got %d", duringUp)
+	}
+	for _, p := range lb.ProcessPack {
+		if active := p.GetActiveConnections(); active != 0 {
+			t.Errorf("Expected no connections to %s once the response completed, got %d", p.URL, active)
+		}
+	}
+}
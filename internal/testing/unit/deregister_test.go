@@ -0,0 +1,94 @@
+package unit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func hasHealthEvent(backend, state string) bool {
+	for _, event := range balancer.GetHealthHistory() {
+		if event.Backend == backend && event.State == state {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDeadBackendDeregistration(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(1)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	decommissioned := httptest.NewServer(http.NotFoundHandler())
+	decommissioned.Close()
+
+	config := `deregister_after 200ms
+
+	upstream backend {
+		persistence consistent_hash
+		server ` + backends[0] + `
+		server ` + decommissioned.URL + `
+	}`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	defer balancer.StopRevivals(lb)
+
+	// Requests hashed to the decommissioned backend spill over to the live one
+	for i := 0; i < 30; i++ {
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, httptest.NewRequest("GET", fmt.Sprintf("http://localhost/item/%d", i), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+	}
+	if !hasHealthEvent(decommissioned.URL, "down") {
+		t.Fatalf("Expected the decommissioned backend to be marked dead")
+	}
+
+	deregisterer := balancer.NewDeregisterer(lb, cfg.DeregisterAfter)
+	deregisterer.Start()
+	defer deregisterer.Stop()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !hasHealthEvent(decommissioned.URL, "deregistered") {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the decommissioned backend to be deregistered")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	stats := balancer.GetStats(lb)
+	if len(stats.Backends) != 1 || stats.Backends[0].URL != backends[0] {
+		t.Errorf("Expected only the live backend in stats, got %+v", stats.Backends)
+	}
+	if hasHealthEvent(backends[0], "deregistered") {
+		t.Errorf("Expected the live backend to stay registered")
+	}
+
+	rec := httptest.NewRecorder()
+	lb.ProxyRequest(rec, httptest.NewRequest("GET", "http://localhost/item/1", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200 after deregistration, got %d", rec.Code)
+	}
+}
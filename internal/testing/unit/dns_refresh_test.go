@@ -0,0 +1,142 @@
+package unit
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+// fakeNameserver answers A queries with the address in ip and a TTL of one
+// second, and AAAA queries with no answer
+func fakeNameserver(t *testing.T, ip *atomic.Value) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			query := buf[:n]
+			qtype := binary.BigEndian.Uint16(query[n-4 : n-2])
+
+			resp := append([]byte(nil), query...)
+			// Response, recursion available
+			binary.BigEndian.PutUint16(resp[2:4], 0x8180)
+			if qtype == 1 {
+				binary.BigEndian.PutUint16(resp[6:8], 1)
+				resp = append(resp, 0xC0, 12, 0, 1, 0, 1, 0, 0, 0, 1, 0, 4)
+				resp = append(resp, ip.Load().(net.IP).To4()...)
+			}
+			conn.WriteTo(resp, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// namedBackend serves a body on an address
+func namedBackend(t *testing.T, addr, body string) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to listen on %s: %v", addr, err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	server.Listener.Close()
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+}
+
+func TestDNSRefreshFollowsAddressChanges(t *testing.T) {
+	first, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	_, port, _ := net.SplitHostPort(first.Addr().String())
+	first.Close()
+	namedBackend(t, "127.0.0.1:"+port, "first")
+	namedBackend(t, "127.0.0.2:"+port, "second")
+
+	var ip atomic.Value
+	ip.Store(net.ParseIP("127.0.0.1"))
+	nameserver := fakeNameserver(t, &ip)
+
+	configPath, err := testutils.CreateTempConfig(`dns_refresh interval=1m min_ttl=5s nameserver=` + nameserver + `
+
+	upstream backend {
+		method round_robin
+		server http://api.internal:` + port + `
+	}
+
+	route path / backend`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create balancer: %v", err)
+	}
+
+	get := func() string {
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, httptest.NewRequest("GET", "/", nil))
+		return rec.Body.String()
+	}
+
+	refresher := balancer.NewDNSRefresher(lb, cfg.DNSRefresh)
+	refresher.Start()
+	defer refresher.Stop()
+	if body := get(); body != "first" {
+		t.Fatalf("Expected the backend at the first address, got %q", body)
+	}
+
+	// The pooled connection to the old address is not reused
+	ip.Store(net.ParseIP("127.0.0.2"))
+	refresher.Refresh()
+	if body := get(); body != "second" {
+		t.Fatalf("Expected the backend at the new address, got %q", body)
+	}
+}
+
+func TestDNSRefreshConfig(t *testing.T) {
+	if refresher := balancer.NewDNSRefresher(nil, balancer.DNSRefreshConfig{}); refresher != nil {
+		t.Fatalf("Expected no refresher without dns_refresh")
+	}
+
+	for _, directive := range []string{
+		"dns_refresh interval=never",
+		"dns_refresh min_ttl=1m max_ttl=10s",
+		"dns_refresh ttl=5s",
+	} {
+		configPath, err := testutils.CreateTempConfig(directive + `
+
+	upstream backend {
+		server http://localhost:8080
+	}`)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil || !strings.Contains(err.Error(), "dns_refresh") {
+			t.Errorf("Expected a dns_refresh error for %q, got %v", directive, err)
+		}
+	}
+}
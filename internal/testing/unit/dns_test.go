@@ -0,0 +1,116 @@
+package unit
+
+import (
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+// dnsQuery sends an A query for name and returns the response code and answers
+func dnsQuery(t *testing.T, addr net.Addr, name string) (int, []net.IP) {
+	query := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(name, ".") {
+		query = append(query, byte(len(label)))
+		query = append(query, label...)
+	}
+	query = append(query, 0, 0, 1, 0, 1)
+
+	conn, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatalf("Failed to dial DNS responder: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write(query); err != nil {
+		t.Fatalf("Failed to send DNS query: %v", err)
+	}
+
+	resp := make([]byte, 512)
+	n, err := conn.Read(resp)
+	if err != nil {
+		t.Fatalf("Failed to read DNS response: %v", err)
+	}
+	resp = resp[:n]
+
+	if binary.BigEndian.Uint16(resp[0:2]) != 0x1234 {
+		t.Fatalf("DNS response ID does not match the query")
+	}
+
+	var ips []net.IP
+	offset := len(query)
+	for i := 0; i < int(binary.BigEndian.Uint16(resp[6:8])); i++ {
+		length := int(binary.BigEndian.Uint16(resp[offset+10 : offset+12]))
+		ips = append(ips, net.IP(resp[offset+12:offset+12+length]))
+		offset += 12 + length
+	}
+
+	return int(resp[3] & 0x0F), ips
+}
+
+func TestDNSResponderAnswersHealthyBackends(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	config := `dns_listen 127.0.0.1:0
+	dns_name api.example.com pool=backend ttl=10
+	dns_name down.example.com pool=down
+	route path /down/ down
+
+	upstream backend {
+		server ` + backend.URL + `
+	}
+
+	upstream down {
+		server http://127.0.0.2:1
+	}`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	defer balancer.StopRevivals(lb)
+
+	responder := balancer.NewDNSResponder(lb, cfg.DNS)
+	if err := responder.Start(); err != nil {
+		t.Fatalf("Failed to start DNS responder: %v", err)
+	}
+	defer responder.Stop()
+
+	rcode, ips := dnsQuery(t, responder.Addr(), "API.example.com")
+	if rcode != 0 || len(ips) != 1 || !ips[0].Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("Expected 127.0.0.1, got rcode %d and %v", rcode, ips)
+	}
+
+	if rcode, _ := dnsQuery(t, responder.Addr(), "unknown.example.com"); rcode != 3 {
+		t.Errorf("Expected NXDOMAIN for unknown name, got rcode %d", rcode)
+	}
+
+	if rcode, ips := dnsQuery(t, responder.Addr(), "down.example.com"); rcode != 0 || len(ips) != 1 {
+		t.Errorf("Expected the backend before it failed, got rcode %d and %v", rcode, ips)
+	}
+
+	// A failed request marks the only backend of the pool down
+	lb.ProxyRequest(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/down/", nil))
+
+	if rcode, ips := dnsQuery(t, responder.Addr(), "down.example.com"); rcode != 2 || len(ips) != 0 {
+		t.Errorf("Expected SERVFAIL without healthy backends, got rcode %d and %v", rcode, ips)
+	}
+}
@@ -0,0 +1,113 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestBackendDrainSignal(t *testing.T) {
+	var draining int32
+	var hits [2]int32
+
+	newBackend := func(id int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if id == 0 && atomic.LoadInt32(&draining) == 1 {
+				w.Header().Set("X-Drain", "true")
+			}
+			if r.Method != http.MethodHead {
+				atomic.AddInt32(&hits[id], 1)
+			}
+		}))
+	}
+	backend1, backend2 := newBackend(0), newBackend(1)
+	defer backend1.Close()
+	defer backend2.Close()
+
+	config := `drain_signal header=X-Drain recheck=50ms
+
+	upstream backend {
+		server ` + backend1.URL + `
+		server ` + backend2.URL + `
+	}`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	balancer.EnableDrainSignal(cfg.DrainSignal)
+	defer balancer.EnableDrainSignal(balancer.DrainSignalConfig{})
+
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	send := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, httptest.NewRequest("GET", "http://localhost/", nil))
+		return rec
+	}
+
+	// The first request goes to backend1, which asks to be drained
+	atomic.StoreInt32(&draining, 1)
+	if rec := send(); rec.Header().Get("X-Drain") != "" {
+		t.Errorf("Drain header should not be passed to clients")
+	}
+
+	atomic.StoreInt32(&hits[0], 0)
+	for i := 0; i < 4; i++ {
+		send()
+	}
+	if got := atomic.LoadInt32(&hits[0]); got != 0 {
+		t.Errorf("Expected no requests to the draining backend, got %d", got)
+	}
+
+	// Once it stops asking, the recheck puts it back in rotation
+	atomic.StoreInt32(&draining, 0)
+	testutils.AssertEventually(t, func() bool {
+		send()
+		return atomic.LoadInt32(&hits[0]) > 0
+	}, 2*time.Second, "Expected the backend to return to rotation once ready")
+
+	// Backends can also announce draining through the admin API
+	handler := balancer.DrainHandler(lb)
+	form := url.Values{"backend": {backend2.URL}, "state": {"drain"}}
+	req := httptest.NewRequest("POST", "/api/backends/drain", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 from drain handler, got %d", rec.Code)
+	}
+
+	atomic.StoreInt32(&hits[1], 0)
+	for i := 0; i < 4; i++ {
+		send()
+	}
+	if got := atomic.LoadInt32(&hits[1]); got != 0 {
+		t.Errorf("Expected no requests to the backend drained through the API, got %d", got)
+	}
+
+	form.Set("backend", "http://unknown:1")
+	req = httptest.NewRequest("POST", "/api/backends/drain", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown backend, got %d", rec.Code)
+	}
+}
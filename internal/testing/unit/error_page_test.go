@@ -0,0 +1,104 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestRouteErrorPages(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "backend maintenance", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	page := filepath.Join(t.TempDir(), "unavailable.html")
+	if err := os.WriteFile(page, []byte("<h1>{{.Status}} {{.StatusText}}</h1><p>{{.Path}}</p>\n"), 0644); err != nil {
+		t.Fatalf("Failed to write error page: %v", err)
+	}
+
+	config := `error_page api json
+	error_page site file=` + page + `
+	error_page inline_page codes=503 type=text/plain inline Sorry, {{.Method}} {{.Path}} failed: {{.Reason}}
+
+	upstream backend {
+		server ` + failing.URL + `
+	}
+
+	upstream down_pool {
+		server ` + down.URL + `
+	}
+
+	route path /api/ down_pool error_page=api
+	route path /site/ down_pool error_page=site
+	route path /text/ down_pool error_page=inline_page
+	route path /plain/ down_pool
+	route path / backend error_page=api`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	defer balancer.StopRevivals(lb)
+
+	send := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, httptest.NewRequest("GET", "http://localhost"+path, nil))
+		return rec
+	}
+
+	rec := send("/api/users")
+	var body struct {
+		Error  string `json:"error"`
+		Status int    `json:"status"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected a JSON error body, got %q: %v", rec.Body.String(), err)
+	}
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Content-Type") != "application/json" ||
+		body.Status != http.StatusServiceUnavailable || body.Reason != rec.Header().Get(balancer.RejectReasonHeader) || body.Error == "" {
+		t.Errorf("Unexpected JSON error page: %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = send("/site/<script>")
+	if rec.Code != http.StatusServiceUnavailable || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") ||
+		rec.Body.String() != "<h1>503 Service Unavailable</h1><p>/site/&lt;script&gt;</p>\n" {
+		t.Errorf("Unexpected HTML error page: %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = send("/text/x")
+	if !strings.HasPrefix(rec.Body.String(), "Sorry, GET /text/x failed: ") || rec.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("Unexpected inline error page: %q", rec.Body.String())
+	}
+
+	if rec := send("/plain/"); !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Expected the plain error without a page, got %q", rec.Header().Get("Content-Type"))
+	}
+
+	// Errors returned by backends are their own
+	rec = send("/")
+	if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "backend maintenance\n" {
+		t.Errorf("Expected the backend's error to be passed through, got %d %q", rec.Code, rec.Body.String())
+	}
+}
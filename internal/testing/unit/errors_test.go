@@ -0,0 +1,70 @@
+package unit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestTypedErrors(t *testing.T) {
+	parse := func(config string) (*balancer.Config, error) {
+		configPath, err := testutils.CreateTempConfig(config)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		return balancer.ParseConfig(configPath)
+	}
+
+	// Configuration errors carry the line at fault
+	for _, c := range []struct {
+		name   string
+		config string
+		line   int
+	}{
+		{"unknown directive", "upstream backend {\n  server http://localhost:9001\n}\nfrobnicate on", 4},
+		{"bad server option", "upstream backend {\n  server http://localhost:9001 weight=heavy\n}", 2},
+		{"unknown limit policy", "upstream backend {\n  server http://localhost:9001\n}\n\nroute path /api/ backend limit=missing", 5},
+	} {
+		_, err := parse(c.config)
+		if !errors.Is(err, balancer.ErrConfigInvalid) {
+			t.Errorf("%s: expected a configuration error, got %v", c.name, err)
+			continue
+		}
+		var configErr balancer.ErrInvalidConfig
+		if !errors.As(err, &configErr) || configErr.Line != c.line {
+			t.Errorf("%s: expected the error on line %d, got %v", c.name, c.line, err)
+		}
+	}
+
+	// Routes to undefined pools are both configuration and pool errors
+	cfg, err := parse("upstream backend {\n  server http://localhost:9001\n}\nroute path /api/ missing")
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	_, err = balancer.CreatePathRouter(cfg)
+	var configErr balancer.ErrInvalidConfig
+	if !errors.Is(err, balancer.ErrPoolNotFound) || !errors.As(err, &configErr) || configErr.Line != 4 {
+		t.Errorf("Expected a pool not found error on line 4, got %v", err)
+	}
+
+	// A pool without backends has no healthy backend
+	lb, err := balancer.CreateLoadBalancer(balancer.RoundRobin, nil, balancer.NoPersistence, nil)
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	_, err = lb.GetNextInstance(httptest.NewRequest("GET", "/", nil))
+	if !errors.Is(err, balancer.ErrNoHealthyBackend) || balancer.ErrorCode(err) != "no_healthy_backend" {
+		t.Errorf("Expected ErrNoHealthyBackend, got %v", err)
+	}
+
+	// The admin API reports the category of its errors
+	rec := httptest.NewRecorder()
+	balancer.DrainHandler(lb).ServeHTTP(rec, httptest.NewRequest("POST", "/api/backends/drain?backend=http://nowhere&state=drain", nil))
+	if rec.Code != http.StatusNotFound || rec.Header().Get(balancer.ErrorCodeHeader) != "backend_not_found" {
+		t.Errorf("Expected a backend_not_found 404, got %d %q", rec.Code, rec.Header().Get(balancer.ErrorCodeHeader))
+	}
+}
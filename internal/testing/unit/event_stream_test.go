@@ -0,0 +1,160 @@
+package unit
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestEventStream(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(2)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	configPath, err := testutils.CreateTempConfig(`upstream backend {
+		server ` + backends[0] + `
+		server ` + backends[1] + `
+	}
+
+	route path / backend`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	stream := balancer.NewEventStream(lb, 50*time.Millisecond)
+	server := httptest.NewServer(stream)
+	defer server.Close()
+	// Open streams would keep the server from closing
+	defer stream.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to open the event stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %q", ct)
+	}
+
+	// Events are read in the background; each is its type and data
+	type event struct{ eventType, data string }
+	events := make(chan event, 100)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		var current event
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				current.eventType = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				current.data = strings.TrimPrefix(line, "data: ")
+			case line == "":
+				events <- current
+				current = event{}
+			}
+		}
+	}()
+	waitFor := func(eventType string, match func(data string) bool) string {
+		t.Helper()
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case e, ok := <-events:
+				if !ok {
+					t.Fatalf("Stream ended waiting for a %s event", eventType)
+				}
+				if e.eventType == eventType && match(e.data) {
+					return e.data
+				}
+			case <-timeout:
+				t.Fatalf("Timed out waiting for a %s event", eventType)
+			}
+		}
+	}
+	anyData := func(string) bool { return true }
+
+	waitFor(balancer.StatsEventType, anyData)
+
+	// Request rates
+	for i := 0; i < 5; i++ {
+		lb.ProxyRequest(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	data := waitFor(balancer.StatsEventType, func(data string) bool {
+		return strings.Contains(data, `"totalRequests":5`)
+	})
+	var snapshot balancer.RequestRateSnapshot
+	if err := json.Unmarshal([]byte(data), &snapshot); err != nil {
+		t.Fatalf("Failed to decode the stats event: %v", err)
+	}
+	if len(snapshot.Backends) != 2 {
+		t.Errorf("Expected the rate of 2 backends, got %v", snapshot.Backends)
+	}
+
+	// Backend state changes
+	rec := httptest.NewRecorder()
+	balancer.BackendOverrideHandler(lb)(rec, httptest.NewRequest("POST", "/api/backends/backend-0/drain", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Failed to drain the backend: %d", rec.Code)
+	}
+	waitFor(balancer.BackendEventType, func(data string) bool {
+		return strings.Contains(data, `"state":"draining"`) && strings.Contains(data, backends[0])
+	})
+
+	// Configuration changes
+	form := url.Values{"name": {"debug_headers"}, "enabled": {"true"}}
+	req := httptest.NewRequest("POST", "/api/features", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	balancer.FeatureHandler()(httptest.NewRecorder(), req)
+	defer balancer.SetFeature("debug_headers", false)
+	waitFor(balancer.ConfigEventType, func(data string) bool {
+		return strings.Contains(data, `"name":"debug_headers"`) && strings.Contains(data, `"value":"true"`)
+	})
+
+	// Lifecycle events
+	logger.Event(zapcore.InfoLevel, "config_applied", "Configuration applied", zap.Int("pools", 1))
+	data = waitFor(balancer.LifecycleEventType, anyData)
+	var lifecycle balancer.LifecycleEvent
+	if err := json.Unmarshal([]byte(data), &lifecycle); err != nil {
+		t.Fatalf("Failed to decode the lifecycle event: %v", err)
+	}
+	if lifecycle.Event != "config_applied" || lifecycle.Fields["pools"] != "1" {
+		t.Errorf("Expected the config_applied event with its fields, got %+v", lifecycle)
+	}
+
+	// Closing ends open streams
+	stream.Close()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("Expected the stream to end when closed")
+		}
+	}
+}
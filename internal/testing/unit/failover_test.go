@@ -0,0 +1,64 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestFailoverChain(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(2)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	// The primary pool points at a server that is no longer listening
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	config := `upstream primary {
+		server ` + dead.URL + `
+	}
+
+	upstream secondary {
+		server ` + backends[0] + `
+		server ` + backends[1] + `
+	}
+
+	failover primary secondary static:503 fail_after=1 recover_after=1m
+	default_backend primary`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	router, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	defer balancer.StopRevivals(router)
+
+	// The first request discovers that the primary is down
+	router.ProxyRequest(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/", nil))
+
+	for i := 0; i < 5; i++ {
+		rec := httptest.NewRecorder()
+		router.ProxyRequest(rec, httptest.NewRequest("GET", "http://localhost/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected the secondary pool to answer, got status %d", i+1, rec.Code)
+		}
+		if rec.Header().Get("X-Backend-ID") == "" {
+			t.Errorf("Request %d: expected a response from the secondary pool", i+1)
+		}
+	}
+}
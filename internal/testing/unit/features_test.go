@@ -0,0 +1,82 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"github.com/The-iyed/go-load-balancer/internal/testing/mocks"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestFeatureToggles(t *testing.T) {
+	cluster := mocks.NewBackendCluster(1, nil, nil)
+	defer cluster.Close()
+
+	lb, err := balancer.CreateLoadBalancer(balancer.WeightedRoundRobin,
+		[]balancer.BackendConfig{{URL: cluster.URLs()[0], Weight: 1}}, balancer.NoPersistence, nil)
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	handler := balancer.FeatureHandler()
+	toggle := func(name, enabled string) *httptest.ResponseRecorder {
+		form := url.Values{"name": {name}, "enabled": {enabled}}
+		req := httptest.NewRequest("POST", "/api/features", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+	send := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, httptest.NewRequest("GET", "http://localhost/", nil))
+		return rec
+	}
+
+	if rec := send(); rec.Header().Get(balancer.DebugBackendHeader) != "" {
+		t.Errorf("Debug headers should be off by default")
+	}
+
+	rec := toggle(balancer.FeatureDebugHeaders, "true")
+	defer balancer.SetFeature(balancer.FeatureDebugHeaders, false)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 from feature handler, got %d", rec.Code)
+	}
+	var states map[string]bool
+	if err := json.Unmarshal(rec.Body.Bytes(), &states); err != nil || !states[balancer.FeatureDebugHeaders] {
+		t.Errorf("Expected debug headers to be reported on, got %s", rec.Body.String())
+	}
+
+	if got := send().Header().Get(balancer.DebugBackendHeader); got != cluster.URLs()[0] {
+		t.Errorf("Expected debug header %q, got %q", cluster.URLs()[0], got)
+	}
+
+	if rec := toggle("capture", "true"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown feature, got %d", rec.Code)
+	}
+	if rec := toggle(balancer.FeatureTracing, "maybe"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid state, got %d", rec.Code)
+	}
+}
+
+func TestLogLevelEndpoint(t *testing.T) {
+	defer logger.Level.SetLevel(logger.Level.Level())
+
+	req := httptest.NewRequest("PUT", "/api/log-level", strings.NewReader(`{"level":"debug"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	logger.Level.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if logger.Level.Level() != zapcore.DebugLevel {
+		t.Errorf("Expected debug level, got %s", logger.Level.Level())
+	}
+}
@@ -0,0 +1,109 @@
+package unit
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/mocks"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestTLSFingerprint(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, balancer.TLSFingerprintFromRequest(r))
+	}))
+	server.TLS = &tls.Config{}
+	balancer.NewTLSFingerprinter().Configure(server.TLS, server.Config)
+	server.StartTLS()
+	defer server.Close()
+
+	fingerprints := make([]string, 2)
+	for i := range fingerprints {
+		resp, err := server.Client().Get(server.URL)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		fingerprints[i] = string(body)
+	}
+
+	if fingerprints[0] == "" {
+		t.Fatalf("Expected a TLS fingerprint")
+	}
+	if fingerprints[0] != fingerprints[1] {
+		t.Errorf("Expected the same client to get the same fingerprint, got %q and %q", fingerprints[0], fingerprints[1])
+	}
+}
+
+// Clients on the same TLS stack behind one address, such as users of the
+// same browser behind a NAT, share a fingerprint and so a backend
+func TestTLSFingerprintSharedAddress(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, balancer.TLSFingerprintFromRequest(r))
+	}))
+	server.TLS = &tls.Config{}
+	balancer.NewTLSFingerprinter().Configure(server.TLS, server.Config)
+	server.StartTLS()
+	defer server.Close()
+
+	fingerprints := make([]string, 2)
+	for i := range fingerprints {
+		// A client of its own, with its own connections and TLS sessions
+		transport := server.Client().Transport.(*http.Transport).Clone()
+		client := &http.Client{Transport: transport}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		transport.CloseIdleConnections()
+		fingerprints[i] = string(body)
+	}
+
+	if fingerprints[0] == "" || fingerprints[0] != fingerprints[1] {
+		t.Errorf("Expected clients on the same TLS stack to share a fingerprint, got %q and %q", fingerprints[0], fingerprints[1])
+	}
+}
+
+func TestFingerprintPersistence(t *testing.T) {
+	cluster := mocks.NewBackendCluster(3, nil, nil)
+	defer cluster.Close()
+
+	configs := make([]balancer.BackendConfig, 0, 3)
+	for _, url := range cluster.URLs() {
+		configs = append(configs, balancer.BackendConfig{URL: url, Weight: 1})
+	}
+
+	lb, err := balancer.CreateLoadBalancer(balancer.WeightedRoundRobin, configs, balancer.FingerprintPersistence, nil)
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	for _, userAgent := range []string{"client-a", "client-b", "client-c"} {
+		var firstBackend int
+		for i := 0; i < 5; i++ {
+			req := httptest.NewRequest("GET", "http://localhost/", nil)
+			req.RemoteAddr = "192.0.2.1:4000"
+			req.Header.Set("User-Agent", userAgent)
+			rec := httptest.NewRecorder()
+			lb.ProxyRequest(rec, req)
+
+			backendID, err := testutils.ParseBackendResponse(rec.Result())
+			if err != nil {
+				t.Fatalf("Failed to parse backend ID: %v", err)
+			}
+
+			if i == 0 {
+				firstBackend = backendID
+			} else if backendID != firstBackend {
+				t.Errorf("Client %s: expected backend %d, got %d", userAgent, firstBackend, backendID)
+			}
+		}
+	}
+}
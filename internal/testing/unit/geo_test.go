@@ -0,0 +1,193 @@
+package unit
+
+import (
+	"bytes"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+// writeGeoIPDatabase writes an IPv4 MaxMind DB with 24 bit records locating
+// each network at a country and a continent
+func writeGeoIPDatabase(t *testing.T, networks map[string][2]string) string {
+	t.Helper()
+
+	// A record is a node index, -1 when empty, or -2-offset for data
+	nodes := [][2]int{{-1, -1}}
+	var data bytes.Buffer
+	writeString := func(s string) {
+		data.WriteByte(2<<5 | byte(len(s)))
+		data.WriteString(s)
+	}
+	for cidr, location := range networks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("Invalid network %s: %v", cidr, err)
+		}
+		ones, _ := network.Mask.Size()
+
+		offset := data.Len()
+		data.WriteByte(7<<5 | 2)
+		writeString("country")
+		data.WriteByte(7<<5 | 1)
+		writeString("iso_code")
+		writeString(location[0])
+		writeString("continent")
+		data.WriteByte(7<<5 | 1)
+		writeString("code")
+		writeString(location[1])
+
+		ip, node := network.IP.To4(), 0
+		for i := 0; i < ones; i++ {
+			bit := ip[i/8] >> (7 - i%8) & 1
+			if i == ones-1 {
+				nodes[node][bit] = -2 - offset
+				break
+			}
+			if nodes[node][bit] < 0 {
+				nodes = append(nodes, [2]int{-1, -1})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	var file bytes.Buffer
+	count := len(nodes)
+	for _, node := range nodes {
+		for _, record := range node {
+			value := record
+			if record == -1 {
+				value = count
+			} else if record < -1 {
+				value = count + 16 + (-2 - record)
+			}
+			file.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	file.Write(make([]byte, 16))
+	file.Write(data.Bytes())
+
+	file.WriteString("\xab\xcd\xefMaxMind.com")
+	file.WriteByte(7<<5 | 3)
+	file.WriteString("\x4anode_count")
+	file.Write([]byte{6<<5 | 4, byte(count >> 24), byte(count >> 16), byte(count >> 8), byte(count)})
+	file.WriteString("\x4brecord_size")
+	file.Write([]byte{5<<5 | 1, 24})
+	file.WriteString("\x4aip_version")
+	file.Write([]byte{5<<5 | 1, 4})
+
+	path := filepath.Join(t.TempDir(), "geo.mmdb")
+	if err := os.WriteFile(path, file.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write the GeoIP database: %v", err)
+	}
+	return path
+}
+
+func TestGeoRouting(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(4)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	geoip := writeGeoIPDatabase(t, map[string][2]string{
+		"10.1.0.0/16": {"DE", "EU"},
+		"10.2.0.0/16": {"US", "NA"},
+		"10.3.0.0/16": {"FR", "EU"},
+	})
+	db, err := balancer.OpenGeoIPDatabase(geoip)
+	if err != nil {
+		t.Fatalf("Failed to open the GeoIP database: %v", err)
+	}
+	if location := db.Lookup(net.ParseIP("10.2.3.4")); location != (balancer.GeoLocation{Country: "US", Continent: "NA"}) {
+		t.Errorf("Expected 10.2.3.4 in US, NA, got %+v", location)
+	}
+	if location := db.Lookup(net.ParseIP("192.168.1.1")); location != (balancer.GeoLocation{}) {
+		t.Errorf("Expected 192.168.1.1 to be unknown, got %+v", location)
+	}
+
+	configPath, err := testutils.CreateTempConfig(`geoip ` + geoip + `
+	method geo
+
+	upstream eu_pool {
+		server ` + backends[0] + `
+	}
+	upstream backend {
+		server ` + backends[1] + ` region=de
+		server ` + backends[2] + ` region=NA
+		server ` + backends[3] + `
+	}
+
+	route geo country=FR,AT eu_pool
+	route path / backend`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	send := func(client string) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = net.JoinHostPort(client, "1234")
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req)
+		return rec.Header().Get("X-Backend-ID")
+	}
+
+	// Geo routes match the client's country, and geo-weighted pools prefer
+	// the backends of its region
+	for _, tc := range []struct{ client, want string }{
+		{"10.3.0.1", "1"},
+		{"10.1.0.1", "2"},
+		{"10.1.0.2", "2"},
+		{"10.2.0.1", "3"},
+		{"10.2.0.2", "3"},
+	} {
+		if got := send(tc.client); got != tc.want {
+			t.Errorf("Client %s: expected backend %s, got %s", tc.client, tc.want, got)
+		}
+	}
+
+	// Clients of unknown location are spread over every backend
+	seen := make(map[string]bool)
+	for i := 0; i < 6; i++ {
+		seen[send("192.168.1.1")] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("Expected clients of unknown location on 3 backends, got %v", seen)
+	}
+
+	notDB := filepath.Join(t.TempDir(), "geo.mmdb")
+	if err := os.WriteFile(notDB, []byte("not a database"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	for _, tc := range []struct{ config, want string }{
+		{"route geo country=DE backend", "requires a geoip database"},
+		{"geoip " + geoip + "\nroute geo planet=earth backend", "invalid geo route selector"},
+		{"geoip " + geoip + "\nroute geo country=DEU backend", "invalid country code"},
+		{"geoip " + notDB, "invalid MaxMind DB file"},
+	} {
+		configPath, err := testutils.CreateTempConfig(`upstream backend {
+			server ` + backends[1] + `
+		}
+		` + tc.config)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.config, tc.want, err)
+		}
+	}
+}
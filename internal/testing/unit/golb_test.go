@@ -0,0 +1,92 @@
+package unit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+	"github.com/The-iyed/go-load-balancer/pkg/golb"
+)
+
+func TestEmbeddedBalancer(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(2)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	for _, method := range []golb.Method{golb.RoundRobin, golb.LeastConnections} {
+		lb, err := golb.New(method, golb.Backend{URL: backends[0]})
+		if err != nil {
+			t.Fatalf("Failed to create the balancer: %v", err)
+		}
+		events, unsubscribe := lb.Subscribe()
+
+		// waitFor waits for a state change of a backend
+		waitFor := func(backend, state string) {
+			t.Helper()
+			timeout := time.After(2 * time.Second)
+			for {
+				select {
+				case e := <-events:
+					if e.Backend == backend && e.State == state {
+						return
+					}
+				case <-timeout:
+					t.Fatalf("Timed out waiting for %s to be %s", backend, state)
+				}
+			}
+		}
+		served := func() map[string]int {
+			counts := make(map[string]int)
+			for i := 0; i < 6; i++ {
+				rec := httptest.NewRecorder()
+				lb.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+				if rec.Code != http.StatusOK {
+					t.Fatalf("Expected 200, got %d", rec.Code)
+				}
+				counts[rec.Header().Get("X-Backend-ID")]++
+			}
+			return counts
+		}
+
+		if err := lb.AddBackend(golb.Backend{URL: backends[1]}); err != nil {
+			t.Fatalf("Failed to add a backend: %v", err)
+		}
+		waitFor(backends[1], "registered")
+		// Least connections keeps to the first backend while requests do
+		// not overlap
+		if counts := served(); method == golb.RoundRobin && (counts["1"] != 3 || counts["2"] != 3) {
+			t.Errorf("Expected requests spread over both backends, got %v", counts)
+		}
+		if statuses := lb.Backends(); len(statuses) != 2 || !statuses[1].Alive || statuses[1].Weight != 1 {
+			t.Errorf("Expected two live backends, got %+v", statuses)
+		}
+
+		if err := lb.RemoveBackend(backends[0]); err != nil {
+			t.Fatalf("Failed to remove a backend: %v", err)
+		}
+		waitFor(backends[0], "deregistered")
+		if counts := served(); counts["1"] != 0 {
+			t.Errorf("Expected no requests on the removed backend, got %v", counts)
+		}
+
+		if err := lb.AddBackend(golb.Backend{URL: backends[1]}); !errors.Is(err, golb.ErrDuplicateBackend) {
+			t.Errorf("Expected a duplicate backend error, got %v", err)
+		}
+		if err := lb.RemoveBackend(backends[0]); !errors.Is(err, golb.ErrUnknownBackend) {
+			t.Errorf("Expected an unknown backend error, got %v", err)
+		}
+		if err := lb.AddBackend(golb.Backend{URL: "ftp://example.com"}); err == nil {
+			t.Error("Expected a non-HTTP backend to be rejected")
+		}
+
+		// Unsubscribing closes the channel
+		unsubscribe()
+		for range events {
+		}
+	}
+}
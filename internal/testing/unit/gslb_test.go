@@ -0,0 +1,112 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestGSLBHealthExport(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(3)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	reports := make(chan balancer.GSLBReport, 4)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report balancer.GSLBReport
+		if err := json.NewDecoder(r.Body).Decode(&report); err == nil {
+			select {
+			case reports <- report:
+			default:
+			}
+		}
+	}))
+	defer collector.Close()
+
+	config := `gslb min_score=0.8 instance=eu-west-1 push=` + collector.URL + ` interval=1h
+
+	upstream web {
+		server ` + backends[0] + ` weight=3
+		server ` + backends[1] + ` weight=1
+	}
+
+	upstream api {
+		server ` + backends[2] + `
+	}
+
+	route path /api/ api
+	route path / web`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	// Draining the lighter web backend leaves three quarters of the pool
+	drain := httptest.NewRequest("POST", "/api/backends/drain",
+		strings.NewReader(url.Values{"backend": {backends[1]}, "state": {"drain"}}.Encode()))
+	drain.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	balancer.DrainHandler(lb)(httptest.NewRecorder(), drain)
+
+	handler := balancer.GSLBHandler(lb, cfg.GSLB)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	rec := get("/api/gslb")
+	var report balancer.GSLBReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if report.Instance != "eu-west-1" || len(report.Pools) != 2 {
+		t.Fatalf("Expected a report of two pools for eu-west-1, got %+v", report)
+	}
+	web := report.Pools[1]
+	if web.Pool != "web" || web.HealthyBackends != 1 || web.HealthyFraction != 0.75 || web.Score != 0.75 || web.Healthy {
+		t.Errorf("Unexpected web pool health: %+v", web)
+	}
+
+	if rec := get("/api/gslb/web"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the degraded pool to answer 503, got %d", rec.Code)
+	}
+	if rec := get("/api/gslb/api"); rec.Code != http.StatusOK {
+		t.Errorf("Expected the healthy pool to answer 200, got %d", rec.Code)
+	}
+	if rec := get("/api/gslb/missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown pool to answer 404, got %d", rec.Code)
+	}
+
+	pusher := balancer.NewGSLBPusher(lb, cfg.GSLB)
+	pusher.Start()
+	defer pusher.Stop()
+
+	select {
+	case pushed := <-reports:
+		if pushed.Instance != "eu-west-1" || len(pushed.Pools) != 2 {
+			t.Errorf("Unexpected pushed report: %+v", pushed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("Expected the report to be pushed")
+	}
+}
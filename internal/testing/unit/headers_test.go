@@ -0,0 +1,201 @@
+package unit
+
+import (
+	"crypto/tls"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestHeaderManipulation(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "backend")
+		w.Header().Set("X-Seen-Proto", r.Header.Get("X-Forwarded-Proto"))
+		w.Header().Set("X-Seen-Debug", r.Header.Get("X-Debug"))
+		w.Header().Set("X-Seen-Pool", r.Header.Get("X-Pool"))
+	}))
+	defer backend.Close()
+
+	config := `set_header X-Forwarded-Proto https
+	remove_header X-Debug
+	hide_header Server
+
+	upstream backend {
+		server ` + backend.URL + `
+		set_header X-Pool backend
+		set_response_header X-Powered-By go load balancer
+	}`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	if len(cfg.Headers.Request) != 2 || len(cfg.Headers.Response) != 1 {
+		t.Fatalf("Unexpected global header rules: %+v", cfg.Headers)
+	}
+
+	router, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	lb := balancer.NewHeaderRewriter(router, cfg.Headers)
+
+	req := httptest.NewRequest("GET", "http://localhost/", nil)
+	req.Header.Set("X-Debug", "1")
+	rec := httptest.NewRecorder()
+	lb.ProxyRequest(rec, req)
+
+	expected := map[string]string{
+		"Server":       "",
+		"X-Seen-Proto": "https",
+		"X-Seen-Debug": "",
+		"X-Seen-Pool":  "backend",
+		"X-Powered-By": "go load balancer",
+	}
+	for name, value := range expected {
+		if got := rec.Header().Get(name); got != value {
+			t.Errorf("Header %s: expected %q, got %q", name, value, got)
+		}
+	}
+
+	if req.Header.Get("X-Debug") != "1" {
+		t.Errorf("Original request headers should not be modified")
+	}
+}
+
+func TestBackendHostOverride(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Host", r.Host)
+	}))
+	defer plain.Close()
+
+	// The balancer does not trust the test certificate, so only the server
+	// name of the handshake is checked
+	serverNames := make(chan string, 1)
+	secure := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	secure.TLS = &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			select {
+			case serverNames <- hello.ServerName:
+			default:
+			}
+			return nil, nil
+		},
+	}
+	secure.Config.ErrorLog = log.New(io.Discard, "", 0)
+	secure.StartTLS()
+	defer secure.Close()
+
+	config := `upstream plain {
+		server ` + plain.URL + ` host=internal-api.local:8080
+	}
+
+	upstream secure {
+		server ` + secure.URL + ` host=internal-api.local sni=tls.internal
+	}
+
+	route path /secure/ secure
+	route path / plain`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if backend := cfg.BackendPools["plain"][0]; backend.ServerName != "internal-api.local" {
+		t.Errorf("Expected the server name to default to the host, got %q", backend.ServerName)
+	}
+
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	defer balancer.StopRevivals(lb)
+
+	rec := httptest.NewRecorder()
+	lb.ProxyRequest(rec, httptest.NewRequest("GET", "http://lb.example.com/", nil))
+	if host := rec.Header().Get("X-Seen-Host"); host != "internal-api.local:8080" {
+		t.Errorf("Expected the configured Host header, got %q", host)
+	}
+
+	lb.ProxyRequest(httptest.NewRecorder(), httptest.NewRequest("GET", "http://lb.example.com/secure/", nil))
+	select {
+	case name := <-serverNames:
+		if name != "tls.internal" {
+			t.Errorf("Expected the configured TLS server name, got %q", name)
+		}
+	default:
+		t.Errorf("Expected a TLS handshake with the backend")
+	}
+}
+
+func TestBackendPathPrefix(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Host", r.Host)
+		w.Header().Set("X-Seen-URI", r.RequestURI)
+	}))
+	defer backend.Close()
+
+	configPath, err := testutils.CreateTempConfig(`upstream backend {
+		server ` + backend.URL + ` host=api.internal prefix=/v2/
+	}
+	upstream based {
+		server ` + backend.URL + `/base prefix=/v2
+	}
+
+	route path /based/ based`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	for _, tc := range []struct{ path, uri, host string }{
+		{"/users?page=2", "/v2/users?page=2", "api.internal"},
+		{"/files/a%2Fb", "/v2/files/a%2Fb", "api.internal"},
+		{"/based/users", "/base/v2/based/users", "lb.example.com"},
+	} {
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, httptest.NewRequest("GET", "http://lb.example.com"+tc.path, nil))
+		if uri := rec.Header().Get("X-Seen-URI"); uri != tc.uri {
+			t.Errorf("%s: expected the backend to get %s, got %q", tc.path, tc.uri, uri)
+		}
+		if host := rec.Header().Get("X-Seen-Host"); host != tc.host {
+			t.Errorf("%s: expected the Host header %s, got %q", tc.path, tc.host, host)
+		}
+	}
+
+	for _, prefix := range []string{"v2", "/v2?x=1", "/v%202"} {
+		configPath, err := testutils.CreateTempConfig(`upstream backend {
+			server ` + backend.URL + ` prefix=` + prefix + `
+		}`)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil || !strings.Contains(err.Error(), "invalid prefix") {
+			t.Errorf("%s: expected an invalid prefix error, got %v", prefix, err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package unit
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestListenerRoutes(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(3)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	configPath, err := testutils.CreateTempConfig(`upstream web {
+		server ` + backends[0] + `
+	}
+
+	upstream shop {
+		server ` + backends[1] + `
+	}
+
+	upstream shop_api {
+		server ` + backends[2] + `
+	}
+
+	default_backend web
+	route path /api/ shop_api
+
+	listener shop {
+		listen 8443
+		default_backend shop
+		route path /api/ shop_api
+		route path /blog/ web
+	}`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if len(cfg.Listeners) != 1 || cfg.Listeners[0].Listen != ":8443" || len(cfg.Listeners[0].Routes) != 2 {
+		t.Fatalf("Expected one listener on :8443 with two routes, got %+v", cfg.Listeners)
+	}
+	if len(cfg.Routes) != 1 {
+		t.Errorf("Expected the listener's routes to stay out of the global ones, got %d", len(cfg.Routes))
+	}
+
+	router, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	lb := balancer.ApplyGlobalMiddleware(router, cfg)
+
+	for _, tc := range []struct {
+		listener, path, backend string
+	}{
+		{"", "/", "1"},
+		{"", "/api/orders", "3"},
+		{"", "/blog/post", "1"},
+		{"shop", "/", "2"},
+		{"shop", "/api/orders", "3"},
+		{"shop", "/blog/post", "1"},
+		{"unknown", "/", "1"},
+	} {
+		r := httptest.NewRequest("GET", tc.path, nil)
+		if tc.listener != "" {
+			r = balancer.WithListener(r, tc.listener)
+		}
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, r)
+		if got := rec.Header().Get("X-Backend-ID"); got != tc.backend {
+			t.Errorf("Expected %s on listener %q to reach backend %s, got %q", tc.path, tc.listener, tc.backend, got)
+		}
+	}
+
+	// Listeners share the pools, so every backend is counted once
+	if stats := balancer.GetStats(lb); len(stats.Backends) != 3 {
+		t.Errorf("Expected 3 backends in stats, got %d", len(stats.Backends))
+	}
+}
+
+func TestListenerConfigErrors(t *testing.T) {
+	for _, tc := range []struct {
+		listener, err string
+	}{
+		{"listener app {\n\t\tdefault_backend backend\n\t}", "requires a listen address"},
+		{"listener app {\n\t\tlisten not-an-address\n\t}", "invalid listen address"},
+		{"listener app {\n\t\tlisten 8443\n\t\ttimeout 5s\n\t}", "must not be inside a listener block"},
+		{"listener app {\n\t\tlisten 8443\n\t}\n\tlistener app {\n\t\tlisten 8444\n\t}", "duplicate listener"},
+		{"listener app {\n\t\tlisten 8443", "not closed"},
+	} {
+		configPath, err := testutils.CreateTempConfig(`upstream backend {
+		server http://localhost:8080
+	}
+
+	` + tc.listener)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("Expected an error containing %q, got %v", tc.err, err)
+		}
+	}
+
+	configPath, err := testutils.CreateTempConfig(`upstream backend {
+		server http://localhost:8080
+	}
+
+	listener app {
+		listen 8443
+		default_backend missing
+	}`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if _, err := balancer.CreatePathRouter(cfg); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("Expected an unknown pool error, got %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLoggerConfiguration(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "lb.log")
+
+	configPath, err := testutils.CreateTempConfig(`log level=warn format=console output=` + output + ` max_size=1KB max_backups=2
+	log proxy=debug admin=error
+
+	upstream backend {
+		server http://localhost:8081
+	}`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	config := cfg.Log
+	if config.Level != zapcore.WarnLevel || config.Format != "console" || config.Output != output ||
+		config.MaxSize != 1024 || config.MaxBackups != 2 {
+		t.Fatalf("Unexpected log config: %+v", config)
+	}
+	if config.Components["proxy"] != zapcore.DebugLevel || config.Components["admin"] != zapcore.ErrorLevel {
+		t.Fatalf("Unexpected component levels: %v", config.Components)
+	}
+
+	if err := logger.Configure(config); err != nil {
+		t.Fatalf("Failed to configure logger: %v", err)
+	}
+	defer func() {
+		logger.Level.SetLevel(zapcore.InfoLevel)
+		logger.InitLogger()
+	}()
+
+	// Components log at their own level and the rest at the global one
+	logger.Proxy.Debug("proxy debug")
+	logger.Admin.Warn("admin warn")
+	logger.Health.Info("health info")
+	logger.Health.Warn("health warn")
+	logger.Log.Info("global info")
+	logger.Log.Warn("global warn")
+	logger.Log.Sync()
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	log := string(data)
+	for _, message := range []string{"proxy debug", "health warn", "global warn"} {
+		if !strings.Contains(log, message) {
+			t.Errorf("Expected %q in the log, got:\n%s", message, log)
+		}
+	}
+	for _, message := range []string{"admin warn", "health info", "global info"} {
+		if strings.Contains(log, message) {
+			t.Errorf("Expected no %q in the log, got:\n%s", message, log)
+		}
+	}
+	if !strings.Contains(log, "\tWARN\t") {
+		t.Errorf("Expected console encoded entries, got:\n%s", log)
+	}
+
+	// The file is rotated once it outgrows max_size, keeping two backups
+	for i := 0; i < 100; i++ {
+		logger.Log.Warn("filling the log", zap.Int("line", i))
+	}
+	logger.Log.Sync()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to list log directory: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected the log and 2 rotated files, got %d files", len(entries))
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", entry.Name(), err)
+		}
+		if info.Size() > 1024 {
+			t.Errorf("Expected %s to be at most 1KB, got %d bytes", entry.Name(), info.Size())
+		}
+	}
+}
+
+// Reconfiguring the log leaves the loggers in place, so requests logging
+// through them while the log is reconfigured do not race with it
+func TestLoggerReconfigureWhileLogging(t *testing.T) {
+	dir := t.TempDir()
+	defer func() {
+		logger.Level.SetLevel(zapcore.InfoLevel)
+		logger.InitLogger()
+	}()
+
+	proxy := logger.Proxy
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			logger.Proxy.Debug("proxied", zap.Int("request", i))
+			logger.Log.With(zap.Int("request", i)).Debug("logged")
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		config := logger.DefaultConfig()
+		config.Level = zapcore.WarnLevel
+		config.Output = filepath.Join(dir, "lb.log")
+		if err := logger.Configure(config); err != nil {
+			t.Fatalf("Failed to configure logger: %v", err)
+		}
+	}
+	<-done
+
+	if logger.Proxy != proxy {
+		t.Errorf("Expected reconfiguring the log to keep the loggers")
+	}
+
+	// Loggers made before a reconfiguration log with the new settings
+	withField := logger.Log.With(zap.String("component", "test"))
+	config := logger.DefaultConfig()
+	config.Output = filepath.Join(dir, "reconfigured.log")
+	if err := logger.Configure(config); err != nil {
+		t.Fatalf("Failed to configure logger: %v", err)
+	}
+	withField.Info("after reconfiguring")
+	logger.Log.Sync()
+	data, err := os.ReadFile(config.Output)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if !strings.Contains(string(data), "after reconfiguring") || !strings.Contains(string(data), `"component":"test"`) {
+		t.Errorf("Expected the entry with its field in the new log, got:\n%s", data)
+	}
+}
+
+func TestLoggerConfigurationErrors(t *testing.T) {
+	for _, directive := range []string{
+		"log level=verbose",
+		"log format=xml",
+		"log max_size=big",
+		"log max_backups=-1",
+		"log database=debug",
+	} {
+		configPath, err := testutils.CreateTempConfig(directive + `
+
+		upstream backend {
+			server http://localhost:8081
+		}`)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil {
+			t.Errorf("Expected %q to be rejected", directive)
+		}
+	}
+}
+
+func TestLogLevelHandler(t *testing.T) {
+	defer func() {
+		logger.Level.SetLevel(zapcore.InfoLevel)
+		logger.InitLogger()
+	}()
+	logger.InitLogger()
+	handler := balancer.LogLevelHandler()
+
+	put := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/loglevel", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+	levels := func(rec *httptest.ResponseRecorder) balancer.LogLevels {
+		var levels balancer.LogLevels
+		if err := json.NewDecoder(rec.Body).Decode(&levels); err != nil {
+			t.Fatalf("Failed to decode log levels: %v", err)
+		}
+		return levels
+	}
+
+	if rec := put(`{"level":"warn"}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if logger.Level.Level() != zapcore.WarnLevel {
+		t.Errorf("Expected warn level, got %s", logger.Level.Level())
+	}
+
+	rec := put(`{"level":"debug","component":"proxy"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := levels(rec); got.Level != "warn" || got.Components["proxy"] != "debug" {
+		t.Errorf("Unexpected log levels: %+v", got)
+	}
+	if logger.Proxy.Check(zapcore.DebugLevel, "") == nil {
+		t.Error("Expected proxy debug logs to be enabled")
+	}
+	if logger.Health.Check(zapcore.InfoLevel, "") != nil {
+		t.Error("Expected health info logs to follow the global warn level")
+	}
+
+	// An empty level makes the component follow the global level again
+	put(`{"component":"proxy"}`)
+	if logger.Proxy.Check(zapcore.DebugLevel, "") != nil {
+		t.Error("Expected proxy debug logs to be disabled")
+	}
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/api/loglevel", nil))
+	if got := levels(rec); got.Level != "warn" || len(got.Components) != 0 {
+		t.Errorf("Unexpected log levels: %+v", got)
+	}
+
+	if rec := put(`{"level":"loud"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid level, got %d", rec.Code)
+	}
+	if rec := put(`{"level":"debug","component":"database"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown component, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,177 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestRequestMetrics(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(1)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	configPath, err := testutils.CreateTempConfig(`metrics buckets=50ms,1s,10s labels=route,backend,status,method
+
+	upstream backend {
+		server ` + backends[0] + `
+	}
+
+	route path /api backend`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if len(cfg.Metrics.Buckets) != 3 || cfg.Metrics.Buckets[0] != 0.05 || cfg.Metrics.Buckets[2] != 10 {
+		t.Fatalf("Unexpected metrics buckets: %v", cfg.Metrics.Buckets)
+	}
+	router, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	lb := balancer.ApplyGlobalMiddleware(router, cfg)
+
+	for _, method := range []string{"GET", "GET", "POST", "PURGE"} {
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, httptest.NewRequest(method, "http://localhost/api/items", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	balancer.MetricsHandler()(rec, httptest.NewRequest("GET", "/metrics", nil))
+	metrics := rec.Body.String()
+
+	labels := `route="/api",backend="` + backends[0] + `",status="2xx"`
+	for _, line := range []string{
+		"# TYPE lb_request_duration_seconds histogram",
+		`lb_request_duration_seconds_bucket{` + labels + `,method="GET",le="+Inf"} 2`,
+		`lb_request_duration_seconds_count{` + labels + `,method="GET"} 2`,
+		`lb_request_duration_seconds_count{` + labels + `,method="POST"} 1`,
+		// Methods outside the standard ones share a series
+		`lb_request_duration_seconds_count{` + labels + `,method="OTHER"} 1`,
+	} {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Errorf("Expected %q in the metrics, got:\n%s", line, metrics)
+		}
+	}
+	if !strings.Contains(metrics, `method="GET",le="0.05"}`) || !strings.Contains(metrics, `method="GET",le="10"}`) {
+		t.Errorf("Expected the configured buckets, got:\n%s", metrics)
+	}
+}
+
+func TestRequestMetricsWithoutLabels(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(1)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	configPath, err := testutils.CreateTempConfig(`metrics labels=none
+
+	upstream backend {
+		server ` + backends[0] + `
+	}`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	router, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	lb := balancer.ApplyGlobalMiddleware(router, cfg)
+
+	for i := 0; i < 3; i++ {
+		lb.ProxyRequest(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/", nil))
+	}
+
+	rec := httptest.NewRecorder()
+	balancer.MetricsHandler()(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if metrics := rec.Body.String(); !strings.Contains(metrics, "lb_request_duration_seconds_count 3\n") ||
+		!strings.Contains(metrics, `lb_request_duration_seconds_bucket{le="+Inf"} 3`) {
+		t.Errorf("Expected a single series of 3 requests, got:\n%s", metrics)
+	}
+}
+
+func TestRejectionMetrics(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(1)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	configPath, err := testutils.CreateTempConfig(`rate_limit 1r/m burst=1 key=client_ip
+
+	upstream backend {
+		server ` + backends[0] + `
+	}`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	router, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	lb := balancer.ApplyGlobalMiddleware(router, cfg)
+
+	for i := 0; i < 2; i++ {
+		lb.ProxyRequest(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/", nil))
+	}
+
+	rec := httptest.NewRecorder()
+	balancer.MetricsHandler()(rec, httptest.NewRequest("GET", "/metrics", nil))
+	metrics := rec.Body.String()
+	rejected := balancer.GetRejectionCounts()[string(balancer.RejectRateLimited)]
+	for _, line := range []string{
+		"# TYPE lb_rejections_total counter",
+		`lb_rejections_total{reason="rate_limited"} ` + strconv.FormatInt(rejected, 10),
+	} {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Errorf("Expected %q in the metrics, got:\n%s", line, metrics)
+		}
+	}
+	if rejected == 0 {
+		t.Errorf("Expected the rate limited request to be counted")
+	}
+}
+
+func TestMetricsConfigErrors(t *testing.T) {
+	for _, directive := range []string{
+		"metrics buckets=1s,100ms",
+		"metrics buckets=fast",
+		"metrics labels=route,client",
+		"metrics labels=route,route",
+		"metrics sample=1",
+	} {
+		configPath, err := testutils.CreateTempConfig(directive + `
+
+		upstream backend {
+			server http://localhost:8081
+		}`)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil {
+			t.Errorf("Expected %q to be rejected", directive)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+	"github.com/The-iyed/go-load-balancer/pkg/golb"
+)
+
+func TestRouteMiddleware(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(1)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	// token rejects requests without a token; trace records the middleware
+	// a request went through
+	balancer.RegisterMiddleware("token", func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Token") == "" {
+				http.Error(w, "missing token", http.StatusForbidden)
+				return
+			}
+			w.Header().Add("X-Trace", "token")
+			next.ServeHTTP(w, r)
+		})
+	})
+	golb.RegisterMiddleware("trace", func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Trace", "trace")
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	configPath, err := testutils.CreateTempConfig(`upstream backend {
+		server ` + backends[0] + `
+	}
+
+	route path /api/ backend middlewares=token,trace
+	route path / backend`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	send := func(handler func(http.ResponseWriter, *http.Request), path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			req.Header.Set("X-Token", token)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	if rec := send(lb.ProxyRequest, "/api/users", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected the token middleware to reject the request, got %d", rec.Code)
+	}
+	rec := send(lb.ProxyRequest, "/api/users", "secret")
+	if rec.Code != http.StatusOK || strings.Join(rec.Header().Values("X-Trace"), ",") != "token,trace" {
+		t.Errorf("Expected the request through token then trace, got %d with %v", rec.Code, rec.Header().Values("X-Trace"))
+	}
+	if rec := send(lb.ProxyRequest, "/", ""); rec.Code != http.StatusOK || rec.Header().Get("X-Trace") != "" {
+		t.Errorf("Expected other routes to skip the middleware, got %d with %v", rec.Code, rec.Header().Values("X-Trace"))
+	}
+
+	// Embedded balancers use the same registry
+	embedded, err := golb.New(golb.RoundRobin, golb.Backend{URL: backends[0]})
+	if err != nil {
+		t.Fatalf("Failed to create the balancer: %v", err)
+	}
+	if err := embedded.Use("token"); err != nil {
+		t.Fatalf("Failed to use the middleware: %v", err)
+	}
+	if rec := send(embedded.ServeHTTP, "/", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected the embedded balancer to reject the request, got %d", rec.Code)
+	}
+	if err := embedded.Use("missing"); err == nil {
+		t.Error("Expected an unknown middleware to be rejected")
+	}
+
+	configPath, err = testutils.CreateTempConfig(`upstream backend {
+		server ` + backends[0] + `
+	}
+
+	route path / backend middlewares=token,missing`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	if _, err := balancer.ParseConfig(configPath); err == nil || !strings.Contains(err.Error(), "unknown middleware: missing") {
+		t.Errorf("Expected an unknown middleware error, got %v", err)
+	}
+}
+
+func TestMiddlewarePluginErrors(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.so")
+	for _, tc := range []struct {
+		directive string
+		want      string
+	}{
+		{"plugin tenant", "requires a name and a path"},
+		{"plugin tenant " + missing, "plugin tenant"},
+		{"plugin tenant " + missing + " region", "expected key=value"},
+	} {
+		configPath, err := testutils.CreateTempConfig(tc.directive + `
+		upstream backend {
+			server http://localhost:8001
+		}`)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.directive, tc.want, err)
+		}
+	}
+}
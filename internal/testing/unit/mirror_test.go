@@ -0,0 +1,100 @@
+package unit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestMirrorToShadowPool(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Mirrored", r.Header.Get(balancer.MirrorHeader))
+		w.Write(body)
+	}))
+	defer primary.Close()
+
+	type mirrored struct {
+		body   string
+		header string
+	}
+	received := make(chan mirrored, 10)
+	release := make(chan struct{})
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- mirrored{body: string(body), header: r.Header.Get(balancer.MirrorHeader)}
+		<-release
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer shadow.Close()
+
+	config := `mirror backend shadow percent=100%
+
+	upstream backend {
+		server ` + primary.URL + `
+	}
+
+	upstream shadow {
+		server ` + shadow.URL + `
+	}`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	before := balancer.GetMirrorStats()["backend"]
+
+	// The client is answered while the shadow pool is still busy
+	rec := httptest.NewRecorder()
+	lb.ProxyRequest(rec, httptest.NewRequest("POST", "http://localhost/orders", strings.NewReader(`{"id":1}`)))
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"id":1}` || rec.Header().Get("X-Mirrored") != "" {
+		t.Errorf("Expected the primary to get the untouched request, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	select {
+	case m := <-received:
+		if m.body != `{"id":1}` || m.header != "true" {
+			t.Errorf("Expected the shadow to get a marked copy of the request, got %+v", m)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected the request to be mirrored")
+	}
+	close(release)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		after := balancer.GetMirrorStats()["backend"]
+		if after.Mirrored-before.Mirrored == 1 && after.Failed-before.Failed == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected one mirrored request failing on the shadow, got %+v", after)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	invalid, err := testutils.CreateTempConfig("mirror backend backend")
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	if _, err := balancer.ParseConfig(invalid); err == nil {
+		t.Errorf("Expected a pool mirroring to itself to be rejected")
+	}
+}
@@ -0,0 +1,76 @@
+package unit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestOutageMonitor(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(1)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	config := `system_log off
+
+	upstream backend {
+		server ` + backends[0] + `
+	}
+
+	upstream reports {
+		server ` + down.URL + `
+	}
+
+	route path /reports/ reports`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if cfg.SystemLog {
+		t.Errorf("Expected system_log off to disable the system log")
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	defer balancer.StopRevivals(lb)
+
+	monitor := balancer.NewOutageMonitor(lb)
+	if down := monitor.Check(); len(down) != 0 {
+		t.Fatalf("Expected no pool to be down, got %v", down)
+	}
+
+	// The reports pool loses its only backend
+	lb.ProxyRequest(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/reports/daily", nil))
+
+	if down := monitor.Check(); len(down) != 1 || down[0] != "reports" {
+		t.Errorf("Expected the reports pool to be down, got %v", down)
+	}
+	if down := monitor.Check(); len(down) != 1 {
+		t.Errorf("Expected the reports pool to stay down, got %v", down)
+	}
+}
+
+func TestSystemLogDirective(t *testing.T) {
+	configPath, err := testutils.CreateTempConfig("system_log maybe")
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	if _, err := balancer.ParseConfig(configPath); !errors.Is(err, balancer.ErrConfigInvalid) {
+		t.Errorf("Expected an invalid system_log value to be rejected, got %v", err)
+	}
+}
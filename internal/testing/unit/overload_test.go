@@ -0,0 +1,118 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestLoadShedding(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+	var releaseOnce sync.Once
+	defer releaseOnce.Do(func() { close(release) })
+
+	configPath, err := testutils.CreateTempConfig(`overload max_inflight=1 shed=low:100% retry_after=3s
+
+	upstream backend {
+		server ` + backend.URL + `
+	}
+
+	route path /reports/ backend class=low
+	route path /checkout/ backend class=critical`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	router, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	lb := balancer.ApplyGlobalMiddleware(router, cfg)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	// One request in flight is the most the server takes on
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		get("/slow")
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for balancer.GetOverloadStats().InFlight == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	rec := get("/reports/daily")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "3" ||
+		rec.Header().Get(balancer.RejectReasonHeader) != "overloaded" {
+		t.Fatalf("Expected a low priority request to be shed, got %d %v", rec.Code, rec.Header())
+	}
+	for _, path := range []string{"/checkout/cart", "/home"} {
+		if rec := get(path); rec.Code != http.StatusOK {
+			t.Fatalf("Expected %s to be served under overload, got %d", path, rec.Code)
+		}
+	}
+	if stats := balancer.GetOverloadStats(); stats.Shed["low"] != 1 || !strings.Contains(stats.Reason, "in-flight") {
+		t.Fatalf("Expected one shed low request, got %+v", stats)
+	}
+
+	releaseOnce.Do(func() { close(release) })
+	<-done
+	if rec := get("/reports/daily"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected low priority requests once the overload is over, got %d", rec.Code)
+	}
+}
+
+func TestOverloadConfig(t *testing.T) {
+	for _, directive := range []string{
+		"overload shed=low:50%",
+		"overload max_inflight=0",
+		"overload max_memory=lots",
+		"overload max_inflight=10 shed=critical:10%",
+		"overload max_inflight=10 shed=low:150%",
+	} {
+		configPath, err := testutils.CreateTempConfig(directive + `
+
+	upstream backend {
+		server http://localhost:8080
+	}`)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil || !strings.Contains(err.Error(), "overload") {
+			t.Errorf("Expected an overload error for %q, got %v", directive, err)
+		}
+	}
+
+	configPath, err := testutils.CreateTempConfig(`upstream backend {
+		server http://localhost:8080
+	}
+
+	route path /reports/ backend class=bulk`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	if _, err := balancer.ParseConfig(configPath); err == nil || !strings.Contains(err.Error(), "route class") {
+		t.Errorf("Expected a route class error, got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+// reverseRoundRobin is a custom balancing algorithm cycling through the
+// backends from the last one
+type reverseRoundRobin struct {
+	backends []*balancer.Process
+	next     uint32
+}
+
+func (rr *reverseRoundRobin) Pick(r *http.Request) *balancer.Process {
+	for range rr.backends {
+		i := int(atomic.AddUint32(&rr.next, 1)-1) % len(rr.backends)
+		if p := rr.backends[len(rr.backends)-1-i]; p.Available() {
+			return p
+		}
+	}
+	return nil
+}
+
+func TestPickerPool(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(3)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	newPool := func(urls ...string) balancer.LoadBalancerStrategy {
+		configs := make([]balancer.BackendConfig, len(urls))
+		for i, url := range urls {
+			configs[i] = balancer.BackendConfig{URL: url, Weight: 1}
+		}
+		return balancer.NewPickerPool("Reverse Round Robin", configs, func(backends []*balancer.Process) balancer.Picker {
+			return &reverseRoundRobin{backends: backends}
+		})
+	}
+	send := func(lb balancer.LoadBalancerStrategy, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req)
+		return rec
+	}
+
+	lb := newPool(backends...)
+	for i, want := range []string{"3", "2", "1", "3"} {
+		if got := send(lb, nil).Header().Get("X-Backend-ID"); got != want {
+			t.Errorf("Request %d: expected backend %s, got %s", i, want, got)
+		}
+	}
+	if stats := balancer.GetStats(lb); stats.Method != "Reverse Round Robin" || stats.PersistenceType != "None" {
+		t.Errorf("Expected the custom method in stats, got %q with persistence %q", stats.Method, stats.PersistenceType)
+	}
+
+	// A failed backend is retried on the backend picked next
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	rec := send(newPool(backends[0], down.URL), nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Backend-ID") != "1" {
+		t.Errorf("Expected the retry to reach backend 1, got %d from %q", rec.Code, rec.Header().Get("X-Backend-ID"))
+	}
+
+	// Persistence is layered over the custom method
+	persistent, err := balancer.NewSessionPersistence(newPool(backends...), balancer.CookiePersistence, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session persistence: %v", err)
+	}
+	rec = send(persistent, nil)
+	cookies := rec.Result().Cookies()
+	if rec.Header().Get("X-Backend-ID") != "3" || len(cookies) == 0 {
+		t.Fatalf("Expected backend 3 to issue a session cookie, got %q with %v", rec.Header().Get("X-Backend-ID"), cookies)
+	}
+	for i := 0; i < 3; i++ {
+		if got := send(persistent, cookies[0]).Header().Get("X-Backend-ID"); got != "3" {
+			t.Errorf("Expected the session to stay on backend 3, got %s", got)
+		}
+	}
+	if got := send(persistent, nil).Header().Get("X-Backend-ID"); got != "2" {
+		t.Errorf("Expected a new session on backend 2, got %s", got)
+	}
+	if stats := balancer.GetStats(persistent); stats.Method != "Reverse Round Robin" || stats.PersistenceType != "Cookie" {
+		t.Errorf("Expected the custom method with cookie persistence in stats, got %q with persistence %q", stats.Method, stats.PersistenceType)
+	}
+}
@@ -0,0 +1,97 @@
+package unit
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestPreconnect(t *testing.T) {
+	var connections int32
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			// Keeps the warm-up requests in flight together
+			time.Sleep(50 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	backend.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+
+	configPath, err := testutils.CreateTempConfig(`preconnect connections=3 path=/healthz
+
+	upstream backend {
+		method round_robin
+		server ` + backend.URL + `
+	}
+
+	route path / backend`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if cfg.Preconnect.Connections != 3 || cfg.Preconnect.Path != "/healthz" {
+		t.Fatalf("Expected 3 connections to /healthz, got %+v", cfg.Preconnect)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create balancer: %v", err)
+	}
+
+	preconnector := balancer.NewPreconnector(lb, cfg.Preconnect)
+	preconnector.Start()
+	preconnector.Stop()
+	if opened := atomic.LoadInt32(&connections); opened != 3 {
+		t.Fatalf("Expected 3 connections to be opened ahead of requests, got %d", opened)
+	}
+
+	// Requests find the warmed connections idle in the pool
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+	}
+	if opened := atomic.LoadInt32(&connections); opened != 3 {
+		t.Errorf("Expected requests to reuse the warmed connections, got %d connections", opened)
+	}
+	stats := balancer.GetStats(lb).Connections
+	pool := stats.Pools[backend.URL]
+	if stats.Preconnected < 3 || pool.Reused != 3 || pool.Opened != 0 || pool.HitRate != 1 {
+		t.Errorf("Expected 3 preconnected connections and a full pool hit rate, got %+v", stats)
+	}
+
+	if balancer.NewPreconnector(lb, balancer.PreconnectConfig{}) != nil {
+		t.Error("Expected no preconnector when warm-up is disabled")
+	}
+
+	for _, directive := range []string{"preconnect connections=0", "preconnect timeout=never", "preconnect warm=yes"} {
+		configPath, err := testutils.CreateTempConfig(directive + `
+
+		upstream backend {
+			server ` + backend.URL + `
+		}`)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil || !strings.Contains(err.Error(), "preconnect") {
+			t.Errorf("%s: expected a preconnect error, got %v", directive, err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package unit
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestPriorityTiers(t *testing.T) {
+	configPath, err := testutils.CreateTempConfig(`upstream backend {
+		min_alive 2
+		server http://primary-1:8080
+		server http://primary-2:8080
+		server http://primary-3:8080 priority=0
+		server http://backup-1:8080 backup
+		server http://backup-2:8080 priority=2
+	}`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if cfg.PoolMinAlive["backend"] != 2 {
+		t.Fatalf("Expected min_alive 2, got %d", cfg.PoolMinAlive["backend"])
+	}
+
+	pools := map[string]balancer.Pool{
+		"weighted":          balancer.NewLoadBalancer(cfg.BackendPools["backend"]),
+		"least connections": balancer.NewLeastConnectionsBalancer(cfg.BackendPools["backend"]),
+	}
+	for name, pool := range pools {
+		pool.Settings().MinAlive = cfg.PoolMinAlive["backend"]
+		backends := pool.Backends()
+		picked := func() map[string]bool {
+			hosts := make(map[string]bool)
+			for i := 0; i < 10; i++ {
+				p := pool.Pick(httptest.NewRequest("GET", "/", nil))
+				if p == nil {
+					t.Fatalf("%s: expected a backend", name)
+				}
+				// Least connections picks the same idle backend every time
+				p.IncrementConnections()
+				defer p.DecrementConnections()
+				hosts[strings.Split(p.URL.Host, ":")[0]] = true
+			}
+			return hosts
+		}
+		expect := func(step string, want ...string) {
+			t.Helper()
+			got := picked()
+			if len(got) != len(want) {
+				t.Errorf("%s, %s: expected %v, got %v", name, step, want, got)
+				return
+			}
+			for _, host := range want {
+				if !got[host] {
+					t.Errorf("%s, %s: expected %v, got %v", name, step, want, got)
+					return
+				}
+			}
+		}
+
+		expect("all alive", "primary-1", "primary-2", "primary-3")
+		backends[0].SetAlive(false)
+		expect("one primary down", "primary-2", "primary-3")
+
+		// Fewer than 2 primaries alive brings the first backup tier in
+		backends[1].SetAlive(false)
+		expect("two primaries down", "primary-3", "backup-1")
+		backends[2].SetAlive(false)
+		backends[3].SetDraining(true)
+		expect("every primary down, backup draining", "backup-2")
+
+		// Recovered primaries take the traffic back
+		backends[0].SetAlive(true)
+		backends[3].SetDraining(false)
+		expect("one primary back", "primary-1", "backup-1")
+		backends[1].SetAlive(true)
+		expect("two primaries back", "primary-1", "primary-2")
+	}
+
+	configPath, err = testutils.CreateTempConfig(`upstream backend {
+		min_alive 0
+		server http://primary-1:8080
+	}`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	if _, err := balancer.ParseConfig(configPath); err == nil || !strings.Contains(err.Error(), "invalid min_alive") {
+		t.Errorf("Expected an invalid min_alive error, got %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestQueuePriorityClasses(t *testing.T) {
+	arrived := make(chan string, 10)
+	unblock := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- r.URL.Path
+		<-unblock
+	}))
+	defer backend.Close()
+	var unblockOnce sync.Once
+	defer unblockOnce.Do(func() { close(unblock) })
+
+	configPath, err := testutils.CreateTempConfig(`upstream backend {
+		server ` + backend.URL + ` max_conn=1
+		queue 10 timeout=5s
+	}
+
+	route path /static/ backend class=low
+	route path /api/payments backend class=critical`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	send := func(path string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lb.ProxyRequest(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		}()
+	}
+
+	// The first request takes the only slot, the others queue
+	send("/hold")
+	<-arrived
+	send("/static/app.js")
+	send("/static/app.css")
+	time.Sleep(100 * time.Millisecond)
+	send("/api/payments")
+	time.Sleep(100 * time.Millisecond)
+
+	var order []string
+	for i := 0; i < 3; i++ {
+		unblock <- struct{}{}
+		select {
+		case path := <-arrived:
+			order = append(order, path)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("No queued request reached the backend, got %v", order)
+		}
+	}
+	unblockOnce.Do(func() { close(unblock) })
+	wg.Wait()
+
+	if order[0] != "/api/payments" {
+		t.Errorf("Expected the critical request to be served first, got %v", order)
+	}
+}
+
+func TestQueuePriorityConfig(t *testing.T) {
+	for _, priority := range []string{"bulk:2", "low:0", "normal:x"} {
+		configPath, err := testutils.CreateTempConfig(`upstream backend {
+		server http://localhost:8080 max_conn=1
+		queue 10 priority=` + priority + `
+	}`)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil || !strings.Contains(err.Error(), "queue priority") {
+			t.Errorf("Expected a queue priority error for %q, got %v", priority, err)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestRateLimit(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(1)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	config := `rate_limit 1r/m burst=2 key=client_ip
+
+	upstream backend {
+		server ` + backends[0] + `
+	}`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	router, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	lb := balancer.ApplyGlobalMiddleware(router, cfg)
+
+	send := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://localhost/", nil)
+		req.RemoteAddr = ip + ":12345"
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := send("10.0.0.1"); rec.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected status 200, got %d", i+1, rec.Code)
+		}
+	}
+
+	rec := send("10.0.0.1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected Retry-After header on rate limited response")
+	}
+	if reason := rec.Header().Get(balancer.RejectReasonHeader); reason != string(balancer.RejectRateLimited) {
+		t.Errorf("Expected reject reason %q, got %q", balancer.RejectRateLimited, reason)
+	}
+	if balancer.GetRejectionCounts()[string(balancer.RejectRateLimited)] == 0 {
+		t.Errorf("Expected rate limited rejection to be counted")
+	}
+
+	if rec := send("10.0.0.2"); rec.Code != http.StatusOK {
+		t.Errorf("Other clients should not be limited, got status %d", rec.Code)
+	}
+}
+
+func TestNamedLimitPolicies(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(2)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	config := `route path /api/ api limit=api_default
+	route path /admin/ api limit=api_default
+	limit api_default 1r/m burst=1 key=header:X-API-Key
+
+	upstream backend {
+		server ` + backends[0] + `
+		rate_limit api_default
+	}
+
+	upstream api {
+		server ` + backends[1] + `
+	}`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	if limit := cfg.PoolRateLimits["backend"]; limit.Rate == 0 || limit.Key != "header:X-API-Key" {
+		t.Fatalf("Expected pool limit to use the policy settings, got %+v", limit)
+	}
+
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	send := func(path string) int {
+		req := httptest.NewRequest("GET", "http://localhost"+path, nil)
+		req.Header.Set("X-API-Key", "key1")
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req)
+		return rec.Code
+	}
+
+	// Each attachment has its own buckets
+	for _, path := range []string{"/api/", "/admin/", "/"} {
+		if code := send(path); code != http.StatusOK {
+			t.Errorf("First request to %s: expected status 200, got %d", path, code)
+		}
+		if code := send(path); code != http.StatusTooManyRequests {
+			t.Errorf("Second request to %s: expected status 429, got %d", path, code)
+		}
+	}
+
+	stats := balancer.GetRateLimitPolicyStats()["api_default"]
+	if stats.Allowed != 3 || stats.Limited != 3 {
+		t.Errorf("Expected 3 allowed and 3 limited requests, got %+v", stats)
+	}
+
+	configPath, err = testutils.CreateTempConfig(`upstream backend {
+		server ` + backends[0] + `
+		rate_limit missing
+	}`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	if _, err := balancer.ParseConfig(configPath); err == nil {
+		t.Errorf("Expected an error for an unknown limit policy")
+	}
+}
+
+func TestSoftLimitWarnings(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(1)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	config := `rate_limit 1r/m burst=4 key=global warn=40%
+
+	upstream backend {
+		server ` + backends[0] + `
+		pool_limit max_concurrent=2 warn=50%
+	}`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	router, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	lb := balancer.ApplyGlobalMiddleware(router, cfg)
+
+	before := balancer.GetLimitWarnings()
+	rejectedBefore := balancer.GetRejectionCounts()[string(balancer.RejectPoolConcurrency)]
+
+	send := func() int {
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, httptest.NewRequest("GET", "http://localhost/", nil))
+		return rec.Code
+	}
+
+	// The first request uses a quarter of the burst, the second half of it
+	if code := send(); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if warnings := balancer.GetLimitWarnings(); warnings["rate_limit"].Crossings != before["rate_limit"].Crossings {
+		t.Errorf("Expected no rate limit warning below the threshold")
+	}
+	if code := send(); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+
+	warnings := balancer.GetLimitWarnings()
+	rate := warnings["rate_limit"]
+	if rate.Crossings != before["rate_limit"].Crossings+1 || !rate.Active || rate.Threshold != 0.4 {
+		t.Errorf("Expected an active rate limit warning, got %+v", rate)
+	}
+
+	// Each request takes half of the pool's concurrency and releases it
+	concurrency := warnings["pool_limit:backend:concurrency"]
+	if concurrency.Crossings != before["pool_limit:backend:concurrency"].Crossings+2 || concurrency.Active {
+		t.Errorf("Expected two cleared pool concurrency warnings, got %+v", concurrency)
+	}
+
+	if balancer.GetRejectionCounts()[string(balancer.RejectPoolConcurrency)] != rejectedBefore {
+		t.Errorf("Warnings must not reject requests")
+	}
+
+	for _, directive := range []string{"rate_limit 10r/s warn=100%", "rate_limit 10r/s warn=x"} {
+		configPath, err := testutils.CreateTempConfig(directive)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil {
+			t.Errorf("Expected %q to be rejected", directive)
+		}
+	}
+}
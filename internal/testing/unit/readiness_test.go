@@ -0,0 +1,87 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestReadinessEndpoints(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(1)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	config := `readiness min_backends=1 on_main
+
+	upstream backend {
+		server ` + backends[0] + `
+	}
+
+	upstream reports {
+		server ` + down.URL + `
+	}
+
+	route path /reports/ reports`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	defer balancer.StopRevivals(lb)
+	handler := balancer.WithHealthEndpoints(http.HandlerFunc(lb.ProxyRequest), lb, cfg.Readiness)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost"+path, nil))
+		return rec
+	}
+
+	if rec := get("/readyz"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the load balancer to be ready, got %d", rec.Code)
+	}
+
+	// The reports pool loses its only backend
+	get("/reports/daily")
+
+	rec := get("/readyz")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected the load balancer not to be ready, got %d", rec.Code)
+	}
+	var readiness balancer.Readiness
+	if err := json.NewDecoder(rec.Body).Decode(&readiness); err != nil {
+		t.Fatalf("Failed to decode readiness: %v", err)
+	}
+	if len(readiness.Pools) != 2 || !readiness.Pools[0].Ready || readiness.Pools[1].Pool != "reports" || readiness.Pools[1].Ready {
+		t.Errorf("Expected only the reports pool not to be ready, got %+v", readiness.Pools)
+	}
+
+	if rec := get("/healthz"); rec.Code != http.StatusOK {
+		t.Errorf("Expected the load balancer to be live, got %d", rec.Code)
+	}
+	if rec := get("/"); rec.Header().Get("X-Backend-ID") != "1" {
+		t.Errorf("Expected other paths to be proxied, got %d", rec.Code)
+	}
+
+	// Without min_backends nothing is required of the pools
+	cfg.Readiness.MinBackends = 0
+	if !balancer.GetReadiness(lb, cfg.Readiness).Ready {
+		t.Errorf("Expected the load balancer to be ready with min_backends=0")
+	}
+}
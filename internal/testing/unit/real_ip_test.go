@@ -0,0 +1,95 @@
+package unit
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestRealClientIP(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(2)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	config := `upstream backend {
+		server ` + backends[0] + `
+	}
+	upstream client {
+		server ` + backends[1] + `
+	}
+
+	route expr 'request.client_ip == "203.0.113.7"' client`
+	parse := func(directives string) *balancer.Config {
+		t.Helper()
+		configPath, err := testutils.CreateTempConfig(directives + "\n" + config)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		cfg, err := balancer.ParseConfig(configPath)
+		if err != nil {
+			t.Fatalf("Failed to parse config: %v", err)
+		}
+		return cfg
+	}
+	defer balancer.SetRealIP(balancer.RealIPConfig{})
+
+	for _, tc := range []struct {
+		name       string
+		directives string
+		remoteAddr string
+		headers    map[string][]string
+		client     bool
+	}{
+		{"direct client", "", "203.0.113.7:1234", nil, true},
+		{"untrusted peer spoofing", "", "198.51.100.1:1234", map[string][]string{"X-Forwarded-For": {"203.0.113.7"}}, false},
+		{"untrusted peer spoofing with a trusted list", "trusted_proxies 10.0.0.0/8", "198.51.100.1:1234", map[string][]string{"X-Real-IP": {"203.0.113.7"}}, false},
+		{"trusted proxy", "trusted_proxies 10.0.0.0/8", "10.0.0.2:1234", map[string][]string{"X-Forwarded-For": {"203.0.113.7"}}, true},
+		// The client may prepend anything; the addresses proxies appended
+		// are read from the right
+		{"spoofed forwarded entry", "trusted_proxies 10.0.0.0/8", "10.0.0.2:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.9, 203.0.113.7, 10.0.0.5"}}, true},
+		{"spoofed forwarded client", "trusted_proxies 10.0.0.0/8", "10.0.0.2:1234", map[string][]string{"X-Forwarded-For": {"203.0.113.7, 198.51.100.9"}}, false},
+		{"forwarded lines", "trusted_proxies 10.0.0.2 10.0.0.5", "10.0.0.2:1234", map[string][]string{"X-Forwarded-For": {"203.0.113.7", "10.0.0.5"}}, true},
+		{"cloudflare first", "trusted_proxies 10.0.0.0/8", "10.0.0.2:1234", map[string][]string{"Cf-Connecting-Ip": {"203.0.113.7"}, "X-Forwarded-For": {"198.51.100.9"}}, true},
+		{"configured order", "trusted_proxies 10.0.0.0/8\nreal_ip_headers X-Forwarded-For X-Real-IP", "10.0.0.2:1234", map[string][]string{"X-Real-Ip": {"203.0.113.7"}, "X-Forwarded-For": {"198.51.100.9"}}, false},
+		{"malformed header", "trusted_proxies 10.0.0.0/8\nreal_ip_headers X-Real-IP", "10.0.0.2:1234", map[string][]string{"X-Real-Ip": {"unknown"}}, false},
+		{"ipv6 proxy", "trusted_proxies fd00::/8", "[fd00::1]:1234", map[string][]string{"X-Real-Ip": {"203.0.113.7"}}, true},
+	} {
+		cfg := parse(tc.directives)
+		balancer.SetRealIP(cfg.RealIP)
+		lb, err := balancer.CreatePathRouter(cfg)
+		if err != nil {
+			t.Fatalf("Failed to create path router: %v", err)
+		}
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tc.remoteAddr
+		for name, values := range tc.headers {
+			req.Header[name] = values
+		}
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req)
+		if got := rec.Header().Get("X-Backend-ID") == "2"; got != tc.client {
+			t.Errorf("%s: expected the client IP to be 203.0.113.7: %v, got %v", tc.name, tc.client, got)
+		}
+	}
+
+	for _, tc := range []struct{ directive, want string }{
+		{"trusted_proxies", "requires at least one address or network"},
+		{"trusted_proxies 10.0.0.0/33", "invalid trusted proxy"},
+		{"trusted_proxies proxy.local", "invalid trusted proxy"},
+		{"real_ip_headers", "requires at least one header"},
+	} {
+		configPath, err := testutils.CreateTempConfig(tc.directive + "\n" + config)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.directive, tc.want, err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestRewriteRedirects(t *testing.T) {
+	var backendURL string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/absolute":
+			http.Redirect(w, r, backendURL+"/v2/login?next=%2F", http.StatusFound)
+		case "/v2/virtual":
+			http.Redirect(w, r, "http://api.internal/v2/home", http.StatusMovedPermanently)
+		case "/v2/relative":
+			w.Header().Set("Location", "/v2/login")
+			w.WriteHeader(http.StatusSeeOther)
+		case "/v2/external":
+			http.Redirect(w, r, "https://example.com/v2/login", http.StatusFound)
+		case "/v2/created":
+			w.Header().Set("Location", backendURL+"/v2/items/1")
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer backend.Close()
+	backendURL = backend.URL
+
+	newBalancer := func(rewrite string) balancer.LoadBalancerStrategy {
+		configPath, err := testutils.CreateTempConfig(`upstream backend {
+			rewrite_redirects ` + rewrite + `
+			server ` + backend.URL + ` host=api.internal prefix=/v2
+		}
+
+		route path / backend`)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		cfg, err := balancer.ParseConfig(configPath)
+		if err != nil {
+			t.Fatalf("Failed to parse config: %v", err)
+		}
+		lb, err := balancer.CreatePathRouter(cfg)
+		if err != nil {
+			t.Fatalf("Failed to create balancer: %v", err)
+		}
+		return lb
+	}
+	location := func(lb balancer.LoadBalancerStrategy, target string, tls bool) string {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Host = "lb.example.com"
+		if tls {
+			req = httptest.NewRequest("GET", "https://lb.example.com"+target, nil)
+		}
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req)
+		return rec.Header().Get("Location")
+	}
+
+	lb := newBalancer("on")
+	for _, tc := range []struct {
+		path string
+		tls  bool
+		want string
+	}{
+		{"/absolute", false, "http://lb.example.com/login?next=%2F"},
+		{"/absolute", true, "https://lb.example.com/login?next=%2F"},
+		{"/virtual", false, "http://lb.example.com/home"},
+		{"/relative", false, "/login"},
+		{"/external", false, "https://example.com/v2/login"},
+		{"/created", false, backend.URL + "/v2/items/1"},
+	} {
+		if got := location(lb, tc.path, tc.tls); got != tc.want {
+			t.Errorf("%s (tls %v): expected Location %q, got %q", tc.path, tc.tls, tc.want, got)
+		}
+	}
+
+	if got := location(newBalancer("off"), "/absolute", false); got != backend.URL+"/v2/login?next=%2F" {
+		t.Errorf("Expected redirects to be left alone when off, got %q", got)
+	}
+
+	configPath, err := testutils.CreateTempConfig(`rewrite_redirects on
+
+	upstream backend {
+		server ` + backend.URL + `
+	}`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	if _, err := balancer.ParseConfig(configPath); err == nil || !strings.Contains(err.Error(), "inside an upstream block") {
+		t.Errorf("Expected rewrite_redirects outside an upstream block to fail, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/pkg/golb"
+)
+
+// createDrainBackends starts a backend holding its requests until release
+// is closed and one answering at once, each writing its name
+func createDrainBackends(t *testing.T) (slow, fast *httptest.Server, started chan struct{}, release func()) {
+	started = make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	var once sync.Once
+	release = func() { once.Do(func() { close(unblock) }) }
+
+	slow = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		select {
+		case <-unblock:
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte("slow"))
+	}))
+	fast = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	}))
+	return slow, fast, started, release
+}
+
+func TestRemovedBackendFinishesInFlightRequests(t *testing.T) {
+	slow, fast, started, release := createDrainBackends(t)
+	defer slow.Close()
+	defer fast.Close()
+	defer release()
+
+	lb, err := golb.New(golb.LeastConnections, golb.Backend{URL: slow.URL}, golb.Backend{URL: fast.URL})
+	if err != nil {
+		t.Fatalf("Failed to create the balancer: %v", err)
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		lb.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		done <- rec
+	}()
+	<-started
+
+	if err := lb.RemoveBackend(slow.URL); err != nil {
+		t.Fatalf("Failed to remove a backend: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	lb.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Body.String() != "fast" {
+		t.Errorf("Expected new requests to skip the removed backend, got %q", rec.Body.String())
+	}
+
+	release()
+	if rec := <-done; rec.Code != http.StatusOK || rec.Body.String() != "slow" {
+		t.Errorf("Expected the in-flight request to finish on the removed backend, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRemovedBackendDrainTimeout(t *testing.T) {
+	slow, fast, started, release := createDrainBackends(t)
+	defer slow.Close()
+	defer fast.Close()
+	defer release()
+
+	lb, err := golb.New(golb.LeastConnections, golb.Backend{URL: slow.URL}, golb.Backend{URL: fast.URL})
+	if err != nil {
+		t.Fatalf("Failed to create the balancer: %v", err)
+	}
+	lb.SetDrainTimeout(200 * time.Millisecond)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		lb.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		done <- rec
+	}()
+	<-started
+
+	if err := lb.RemoveBackend(slow.URL); err != nil {
+		t.Fatalf("Failed to remove a backend: %v", err)
+	}
+
+	select {
+	case rec := <-done:
+		if rec.Body.String() == "slow" {
+			t.Errorf("Expected the request to be cut off from the removed backend")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Expected the request to be cut off once the drain timeout passed")
+	}
+	if statuses := lb.Backends(); len(statuses) != 1 || statuses[0].URL != fast.URL {
+		t.Errorf("Expected only the remaining backend, got %+v", statuses)
+	}
+}
@@ -0,0 +1,104 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestContextAwareProxying(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(1)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	config := `upstream backend {
+		server ` + down.URL + `
+		server ` + backends[0] + `
+	}
+
+	upstream slow {
+		server ` + slow.URL + `
+	}
+
+	route path /slow/ slow`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	// A failed attempt shows up in the history of the retried request
+	retried := false
+	for i := 0; i < 4 && !retried; i++ {
+		ctx := balancer.WithRequestInfo(context.Background())
+		rec := httptest.NewRecorder()
+		balancer.ProxyRequestContext(ctx, lb, rec, httptest.NewRequest("GET", "http://localhost/", nil))
+		if rec.Header().Get("X-Backend-ID") != "1" {
+			t.Fatalf("Expected the request to be answered by the live backend, got %d", rec.Code)
+		}
+
+		info, ok := balancer.RequestInfoFromContext(ctx)
+		if !ok || info.Pool != "backend" || len(info.Attempts) == 0 {
+			t.Fatalf("Expected the request info to name the pool and attempts, got %+v", info)
+		}
+		if last := info.Attempts[len(info.Attempts)-1]; last.Backend != backends[0] || last.Err != nil {
+			t.Errorf("Expected the last attempt to succeed on %s, got %+v", backends[0], last)
+		}
+		retried = len(info.Attempts) == 2 && info.Attempts[0].Backend == down.URL && info.Attempts[0].Err != nil
+	}
+	if !retried {
+		t.Errorf("Expected a request to record its failed attempt on the down backend")
+	}
+
+	// Requests past their deadline are not retried nor held against the backend
+	for i := 0; i < 3; i++ {
+		timeout, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		ctx := balancer.WithRequestInfo(timeout)
+		rec := httptest.NewRecorder()
+		balancer.ProxyRequestContext(ctx, lb, rec, httptest.NewRequest("GET", "http://localhost/slow/", nil))
+		cancel()
+
+		if rec.Code != http.StatusGatewayTimeout || rec.Header().Get(balancer.RejectReasonHeader) != "deadline_exceeded" {
+			t.Fatalf("Expected a deadline_exceeded 504, got %d", rec.Code)
+		}
+		if info, _ := balancer.RequestInfoFromContext(ctx); info.Route != "/slow/" || len(info.Attempts) != 1 || info.Attempts[0].Err == nil {
+			t.Errorf("Expected a single failed attempt on the slow route, got %+v", info)
+		}
+	}
+	if _, err := balancer.GetNextInstanceContext(context.Background(), lb, httptest.NewRequest("GET", "http://localhost/slow/", nil)); err != nil {
+		t.Errorf("Expected the slow backend to stay alive, got %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := balancer.GetNextInstanceContext(canceled, lb, httptest.NewRequest("GET", "http://localhost/", nil)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected selection to fail with the context's error, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestRetriesAndHedging(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(1)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	var down []string
+	for i := 0; i < 6; i++ {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+		down = append(down, server.URL)
+	}
+
+	// The slow backend answers after 300ms unless the request is canceled
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(300 * time.Millisecond):
+			w.Header().Set("X-Backend-ID", "slow")
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+
+	configPath, err := testutils.CreateTempConfig(`upstream limited {
+		retries 1
+		server ` + down[0] + `
+		server ` + down[1] + `
+		server ` + down[2] + `
+		server ` + backends[0] + `
+	}
+	upstream backend {
+		server ` + down[3] + `
+		server ` + down[4] + `
+		server ` + down[5] + `
+		server ` + backends[0] + `
+	}
+	upstream hedged {
+		retries 2 hedge=20ms
+		server ` + slow.URL + `
+		server ` + backends[0] + `
+	}
+
+	route path /limited/ limited
+	route path /hedged/ hedged`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	send := func(method, path string) (*httptest.ResponseRecorder, time.Duration) {
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		lb.ProxyRequest(rec, httptest.NewRequest(method, path, nil))
+		return rec, time.Since(start)
+	}
+
+	// One retry is allowed after the first backend fails
+	if rec, _ := send("GET", "/limited/"); rec.Code != http.StatusBadGateway || rec.Header().Get(balancer.RejectReasonHeader) != "retries_exhausted" {
+		t.Errorf("Expected the retries to be exhausted, got %d %q", rec.Code, rec.Header().Get(balancer.RejectReasonHeader))
+	}
+	// Pools retry as long as a backend is alive by default
+	if rec, _ := send("GET", "/"); rec.Code != http.StatusOK {
+		t.Errorf("Expected unlimited retries to reach the live backend, got %d", rec.Code)
+	}
+
+	// A slow GET is hedged on the other backend, which answers first
+	rec, elapsed := send("GET", "/hedged/")
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Backend-ID") != "1" || elapsed >= 250*time.Millisecond {
+		t.Errorf("Expected the hedge to answer quickly, got %d from %q in %v", rec.Code, rec.Header().Get("X-Backend-ID"), elapsed)
+	}
+	// A POST is never hedged, so it waits for the slow backend
+	rec, elapsed = send("POST", "/hedged/")
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Backend-ID") != "slow" || elapsed < 250*time.Millisecond {
+		t.Errorf("Expected the POST to wait for the slow backend, got %d from %q in %v", rec.Code, rec.Header().Get("X-Backend-ID"), elapsed)
+	}
+
+	for _, tc := range []struct{ directive, want string }{
+		{"retries", "requires a number of retries"},
+		{"retries -1", "invalid retries"},
+		{"retries 2 hedge=fast", "invalid hedge delay"},
+		{"retries 2 hedge=10ms max_hedges=0", "invalid max_hedges"},
+		{"retries 2 backoff=1s", "unknown retries option"},
+	} {
+		configPath, err := testutils.CreateTempConfig(tc.directive + `
+		upstream backend {
+			server ` + backends[0] + `
+		}`)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.directive, tc.want, err)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package unit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestBackendRevivalBackoff(t *testing.T) {
+	var failing atomic.Bool
+	var probes atomic.Int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			probes.Add(1)
+		}
+		if failing.Load() {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer flaky.Close()
+
+	config := `revive initial=50ms max=100ms multiplier=2 probe=/health
+
+	upstream backend {
+		server ` + flaky.URL + `
+	}`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if cfg.Revival.Initial != 50*time.Millisecond || cfg.Revival.Max != 100*time.Millisecond || cfg.Revival.ProbePath != "/health" {
+		t.Fatalf("Unexpected revival settings: %+v", cfg.Revival)
+	}
+	balancer.SetRevival(cfg.Revival)
+	defer balancer.SetRevival(balancer.RevivalConfig{Initial: 10 * time.Second, Max: 5 * time.Minute, Multiplier: 2, Probe: true, ProbePath: "/"})
+
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	defer balancer.StopRevivals(lb)
+
+	failing.Store(true)
+	rec := httptest.NewRecorder()
+	lb.ProxyRequest(rec, httptest.NewRequest("GET", "http://localhost/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected the failing backend to be marked dead, got %d", rec.Code)
+	}
+
+	// Failed probes keep the backend out of rotation
+	time.Sleep(400 * time.Millisecond)
+	if _, err := lb.GetNextInstance(httptest.NewRequest("GET", "http://localhost/", nil)); !errors.Is(err, balancer.ErrNoHealthyBackend) {
+		t.Fatalf("Expected the backend to stay dead while its probes fail, got %v", err)
+	}
+	if n := probes.Load(); n < 2 || n > 6 {
+		t.Errorf("Expected a few backed off probes, got %d", n)
+	}
+
+	failing.Store(false)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := lb.GetNextInstance(httptest.NewRequest("GET", "http://localhost/", nil)); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the backend to be revived once its probe succeeds")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	for _, invalid := range []string{"revive initial=soon", "revive multiplier=0.5", "revive jitter=2", "revive probe=health"} {
+		configPath, err := testutils.CreateTempConfig(invalid)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestPoolRevivalSettings(t *testing.T) {
+	// failingBackend drops every connection and counts revival probes
+	failingBackend := func(failing *atomic.Bool, probes *atomic.Int32) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				probes.Add(1)
+			}
+			if failing.Load() {
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err == nil {
+					conn.Close()
+				}
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+	}
+	var failing atomic.Bool
+	var fastProbes, slowProbes atomic.Int32
+	failing.Store(true)
+	fast := failingBackend(&failing, &fastProbes)
+	defer fast.Close()
+	slow := failingBackend(&failing, &slowProbes)
+	defer slow.Close()
+
+	config := `upstream fast {
+		revive initial=100ms max=100ms jitter=0 probe=/health
+		server ` + fast.URL + `
+	}
+
+	upstream slow {
+		server ` + slow.URL + `
+	}
+
+	route path /fast fast
+	route path /slow slow`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if revive := cfg.PoolRevivals["fast"]; revive.Initial != 100*time.Millisecond || revive.Jitter != 0 {
+		t.Fatalf("Unexpected revival settings of the fast pool: %+v", revive)
+	}
+	if _, ok := cfg.PoolRevivals["slow"]; ok || cfg.Revival.Initial != 10*time.Second {
+		t.Fatalf("Expected the slow pool to use the global revival settings")
+	}
+
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	defer balancer.StopRevivals(lb)
+	for _, path := range []string{"/fast", "/slow"} {
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, httptest.NewRequest("GET", "http://localhost"+path, nil))
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("Expected the backend of %s to be marked dead, got %d", path, rec.Code)
+		}
+	}
+
+	// The fast pool probes its dead backend on its own schedule
+	time.Sleep(550 * time.Millisecond)
+	if n := fastProbes.Load(); n < 3 || n > 6 {
+		t.Errorf("Expected a probe every 100ms in the fast pool, got %d", n)
+	}
+	if n := slowProbes.Load(); n != 0 {
+		t.Errorf("Expected no probe yet in the slow pool, got %d", n)
+	}
+
+	failing.Store(false)
+	deadline := time.Now().Add(time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, httptest.NewRequest("GET", "http://localhost/fast", nil))
+		if rec.Code == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the fast pool's backend to be revived once its probe succeeds")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	rec := httptest.NewRecorder()
+	lb.ProxyRequest(rec, httptest.NewRequest("GET", "http://localhost/slow", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the slow pool's backend to wait for its revival, got %d", rec.Code)
+	}
+}
+
+func TestStopRevivals(t *testing.T) {
+	var probes atomic.Int32
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			probes.Add(1)
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer dead.Close()
+
+	config := `upstream backend {
+		revive initial=50ms max=50ms jitter=0 probe=/health
+		server ` + dead.URL + `
+	}`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	lb.ProxyRequest(rec, httptest.NewRequest("GET", "http://localhost/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected the backend to be marked dead, got %d", rec.Code)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if probes.Load() == 0 {
+		t.Fatalf("Expected the dead backend to be probed")
+	}
+
+	// Once stopped, the pool no longer probes its dead backend
+	balancer.StopRevivals(lb)
+	time.Sleep(100 * time.Millisecond)
+	stopped := probes.Load()
+	time.Sleep(300 * time.Millisecond)
+	if n := probes.Load(); n != stopped {
+		t.Errorf("Expected no probe after the revivals were stopped, got %d more", n-stopped)
+	}
+}
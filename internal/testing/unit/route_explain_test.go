@@ -0,0 +1,147 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestRouteExplain(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(4)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	configPath, err := testutils.CreateTempConfig(`upstream backend {
+		method round_robin
+		server ` + backends[0] + `
+		server ` + backends[1] + `
+	}
+
+	upstream api {
+		method round_robin
+		server ` + backends[2] + `
+		server ` + backends[3] + `
+	}
+
+	route path /api/ api`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	router, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	lb := balancer.ApplyGlobalMiddleware(router, cfg)
+	handler := balancer.RouteExplainHandler(lb)
+
+	explain := func(body string) balancer.RouteExplanation {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("POST", "/api/route/explain", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var explanation balancer.RouteExplanation
+		if err := json.NewDecoder(rec.Body).Decode(&explanation); err != nil {
+			t.Fatalf("Failed to decode explanation: %v", err)
+		}
+		return explanation
+	}
+
+	explanation := explain(`{"method":"GET","path":"/api/users?page=2","clientIp":"203.0.113.7"}`)
+	if explanation.Route == nil || explanation.Route.Name != "/api/" || explanation.Route.Type != "path" {
+		t.Fatalf("Expected the /api/ path route, got %+v", explanation.Route)
+	}
+	if explanation.Pool != "api" || explanation.Backend != backends[2] || len(explanation.Steps) != 2 {
+		t.Fatalf("Expected the first api backend in two steps, got %+v", explanation)
+	}
+
+	// Explaining does not move the schedule on, proxying does
+	if again := explain(`{"path":"/api/users"}`); again.Backend != backends[2] {
+		t.Fatalf("Expected the explanation to leave the schedule alone, got %s", again.Backend)
+	}
+	rec := httptest.NewRecorder()
+	lb.ProxyRequest(rec, httptest.NewRequest("GET", "/api/users", nil))
+	if rec.Header().Get("X-Backend-ID") != "3" {
+		t.Fatalf("Expected the explained backend to serve the request, got %s", rec.Header().Get("X-Backend-ID"))
+	}
+	if next := explain(`{"path":"/api/users"}`); next.Backend != backends[3] {
+		t.Fatalf("Expected the next backend of the schedule, got %s", next.Backend)
+	}
+
+	if fallback := explain(`{"path":"/home"}`); fallback.Route != nil || fallback.Pool != "backend" || fallback.Backend != backends[0] {
+		t.Fatalf("Expected the default pool, got %+v", fallback)
+	}
+
+	for _, body := range []string{`{"path":"api"}`, `{"clientIp":"nowhere"}`, `not json`} {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("POST", "/api/route/explain", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, rec.Code)
+		}
+	}
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/api/route/explain", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected status 405, got %d", rec.Code)
+	}
+}
+
+func TestRouteExplainSession(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(2)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	config, err := testutils.CreateLoadBalancerConfig(balancer.RoundRobin, balancer.CookiePersistence, backends, nil)
+	if err != nil {
+		t.Fatalf("Failed to create config: %v", err)
+	}
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	// A session pinned to the second backend
+	lb.ProxyRequest(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	rec := httptest.NewRecorder()
+	lb.ProxyRequest(rec, httptest.NewRequest("GET", "/", nil))
+	cookies := rec.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatalf("Expected a session cookie")
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+	explanation := balancer.ExplainRoute(lb, req)
+	if explanation.Backend != backends[1] || explanation.Persistence != "Cookie" {
+		t.Fatalf("Expected the session's backend, got %+v", explanation)
+	}
+	if step := explanation.Steps[len(explanation.Steps)-1]; !strings.Contains(step, "pins the backend") {
+		t.Fatalf("Expected the session cookie to explain the pick, got %q", step)
+	}
+
+	explanation = balancer.ExplainRoute(lb, httptest.NewRequest("GET", "/", nil))
+	if step := explanation.Steps[len(explanation.Steps)-1]; !strings.HasPrefix(step, "no session") {
+		t.Fatalf("Expected a new session, got %q", step)
+	}
+}
@@ -0,0 +1,96 @@
+package unit
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestExprRoutes(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(4)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	configPath, err := testutils.CreateTempConfig(`upstream eu {
+		server ` + backends[0] + `
+	}
+	upstream beta {
+		server ` + backends[1] + `
+	}
+	upstream reads {
+		server ` + backends[2] + `
+	}
+	upstream backend {
+		server ` + backends[3] + `
+	}
+
+	route expr 'request.path.startsWith("/api") && request.header["X-Region"] == "eu"' eu
+	route expr "request.cookie['channel'].lower() == 'beta' || int(request.query['v']) >= 2" beta
+	route expr '!(request.method in ["POST", "PUT"]) && request.path.matches("^/items/[0-9]+$")' reads
+	route path / backend`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	for _, tc := range []struct {
+		method, target string
+		headers        map[string]string
+		want           string
+	}{
+		{"GET", "/api/users", map[string]string{"X-Region": "eu"}, "1"},
+		{"GET", "/api/users", map[string]string{"X-Region": "us"}, "4"},
+		{"GET", "/web", map[string]string{"X-Region": "eu"}, "4"},
+		{"GET", "/web", map[string]string{"Cookie": "channel=BETA"}, "2"},
+		{"GET", "/web?v=2", nil, "2"},
+		{"GET", "/web?v=1", nil, "4"},
+		{"GET", "/items/42", nil, "3"},
+		{"POST", "/items/42", nil, "4"},
+		{"GET", "/items/new", nil, "4"},
+	} {
+		req := httptest.NewRequest(tc.method, tc.target, nil)
+		for name, value := range tc.headers {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req)
+		if got := rec.Header().Get("X-Backend-ID"); got != tc.want {
+			t.Errorf("%s %s %v: expected backend %s, got %s", tc.method, tc.target, tc.headers, tc.want, got)
+		}
+	}
+
+	// Expressions are checked when the configuration is parsed
+	for _, tc := range []struct{ route, want string }{
+		{`route expr request.path == "/" backend`, "must be quoted"},
+		{`route expr 'request.path == "/" backend`, "unterminated"},
+		{`route expr 'request.path' backend`, "must be a bool"},
+		{`route expr 'request.path == 1' backend`, "compares a string with a number"},
+		{`route expr 'request.body == ""' backend`, "unknown request attribute"},
+		{`route expr 'request.path.startsWith(1)' backend`, "startsWith expects one string"},
+		{`route expr 'request.path.matches("(")' backend`, "invalid regex"},
+		{`route expr 'request.path == "/" &&' backend`, "unexpected"},
+	} {
+		configPath, err := testutils.CreateTempConfig(`upstream backend {
+			server ` + backends[3] + `
+		}
+		` + tc.route)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.route, tc.want, err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package unit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestRoutingOverride(t *testing.T) {
+	var servers []string
+	for i := 0; i < 3; i++ {
+		id := i
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-GOLB-Backend") != "" || r.Header.Get("X-GOLB-Token") != "" {
+				t.Errorf("Expected the override headers to be removed")
+			}
+			fmt.Fprintf(w, "backend %d", id)
+		}))
+		defer backend.Close()
+		servers = append(servers, "server "+backend.URL)
+	}
+
+	configPath, err := testutils.CreateTempConfig(`routing_override token=s3cret allow=10.0.0.0/8
+
+	upstream backend {
+		method round_robin
+		` + strings.Join(servers, "\n\t\t") + `
+	}`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	router, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	lb := balancer.ApplyGlobalMiddleware(router, cfg)
+	backendURL := strings.TrimPrefix(servers[0], "server ")
+
+	send := func(remoteAddr string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{"position with token", "192.0.2.1:1234", map[string]string{"X-GOLB-Backend": "2", "X-GOLB-Token": "s3cret"}, "backend 2"},
+		{"url with token", "192.0.2.1:1234", map[string]string{"X-GOLB-Backend": backendURL, "X-GOLB-Token": "s3cret"}, "backend 0"},
+		{"allowed network", "10.1.2.3:1234", map[string]string{"X-GOLB-Backend": "1"}, "backend 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Round robin would spread the requests over every backend
+			for i := 0; i < 3; i++ {
+				rec := send(tt.remoteAddr, tt.headers)
+				if rec.Code != http.StatusOK || rec.Body.String() != tt.expected {
+					t.Fatalf("Expected %q, got %d %q", tt.expected, rec.Code, rec.Body.String())
+				}
+			}
+		})
+	}
+
+	t.Run("unauthorized client", func(t *testing.T) {
+		seen := make(map[string]bool)
+		for i := 0; i < 3; i++ {
+			rec := send("192.0.2.1:1234", map[string]string{"X-GOLB-Backend": "2", "X-GOLB-Token": "wrong"})
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", rec.Code)
+			}
+			seen[rec.Body.String()] = true
+		}
+		if len(seen) != 3 {
+			t.Fatalf("Expected the override to be ignored, got %v", seen)
+		}
+	})
+
+	t.Run("unknown backend", func(t *testing.T) {
+		rec := send("10.1.2.3:1234", map[string]string{"X-GOLB-Backend": "9"})
+		if rec.Code != http.StatusBadRequest || rec.Header().Get(balancer.RejectReasonHeader) != "unknown_backend" {
+			t.Fatalf("Expected 400 unknown_backend, got %d %q", rec.Code, rec.Header().Get(balancer.RejectReasonHeader))
+		}
+	})
+}
+
+func TestRoutingOverrideConfig(t *testing.T) {
+	for _, directive := range []string{
+		"routing_override",
+		"routing_override allow=nowhere",
+		"routing_override token=s3cret mode=debug",
+	} {
+		configPath, err := testutils.CreateTempConfig(directive + `
+
+	upstream backend {
+		server http://localhost:8080
+	}`)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil || !strings.Contains(err.Error(), "routing_override") {
+			t.Errorf("Expected a routing_override error for %q, got %v", directive, err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestRouteSchemaValidation(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("bad") != "" {
+			w.Write([]byte(`{"id": "not a number"}`))
+			return
+		}
+		w.Write([]byte(`{"id": 42}`))
+	}))
+	defer backend.Close()
+
+	dir := t.TempDir()
+	requestSchema := filepath.Join(dir, "request.json")
+	responseSchema := filepath.Join(dir, "response.json")
+	os.WriteFile(requestSchema, []byte(`{
+		"type": "object",
+		"required": ["name"],
+		"additionalProperties": false,
+		"properties": {
+			"name": {"type": "string", "minLength": 1},
+			"tags": {"type": "array", "items": {"type": "string"}}
+		}
+	}`), 0644)
+	os.WriteFile(responseSchema, []byte(`{
+		"type": "object",
+		"properties": {"id": {"type": "integer"}}
+	}`), 0644)
+
+	config := `route path /users api request_schema=` + requestSchema + ` response_schema=` + responseSchema + `
+
+	upstream api {
+		server ` + backend.URL + `
+	}`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	send := func(target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "http://localhost"+target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req)
+		return rec
+	}
+
+	if rec := send("/users", `{"name": "ada", "tags": ["admin"]}`); rec.Code != http.StatusOK || rec.Body.String() != `{"id": 42}` {
+		t.Errorf("Expected valid request to pass, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec := send("/users", `{"tags": [1], "extra": true}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for invalid request, got %d", rec.Code)
+	}
+	if reason := rec.Header().Get(balancer.RejectReasonHeader); reason != string(balancer.RejectSchemaViolation) {
+		t.Errorf("Expected reject reason %q, got %q", balancer.RejectSchemaViolation, reason)
+	}
+
+	var body struct {
+		Violations []string `json:"violations"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected a JSON error body: %v", err)
+	}
+	expected := []string{
+		`$: missing required property "name"`,
+		`$: unexpected property "extra"`,
+		`$.tags[0]: expected string, got number`,
+	}
+	if strings.Join(body.Violations, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected violations:\n%s", strings.Join(body.Violations, "\n"))
+	}
+
+	if rec := send("/users?bad=1", `{"name": "ada"}`); rec.Code != http.StatusBadGateway {
+		t.Errorf("Expected status 502 for invalid response, got %d", rec.Code)
+	}
+
+	// Bodies larger than the validator reads are rejected as too large,
+	// not as schema violations
+	rec = send("/users", `{"name": "`+strings.Repeat("a", 1<<20)+`"}`)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status 413 for a body over the size limit, got %d", rec.Code)
+	}
+	if reason := rec.Header().Get(balancer.RejectReasonHeader); reason != string(balancer.RejectBodyTooLarge) {
+		t.Errorf("Expected reject reason %q, got %q", balancer.RejectBodyTooLarge, reason)
+	}
+
+	violations := balancer.GetSchemaViolations()["/users"]
+	if violations.Request != 1 || violations.Response != 1 {
+		t.Errorf("Expected 1 request and 1 response violation, got %+v", violations)
+	}
+}
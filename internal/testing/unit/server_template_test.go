@@ -0,0 +1,57 @@
+package unit
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestServerTemplates(t *testing.T) {
+	config := `upstream backend {
+		server http://10.0.0.5:{8001-8003} weight=2
+		server http://{web,api}{08-10}.internal:80
+	}`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	var urls []string
+	for _, backend := range cfg.Backends {
+		urls = append(urls, backend.URL)
+		if backend.URL == "http://10.0.0.5:8002" && backend.Weight != 2 {
+			t.Errorf("Expected expanded servers to keep their options, got weight %d", backend.Weight)
+		}
+	}
+	expected := []string{
+		"http://10.0.0.5:8001", "http://10.0.0.5:8002", "http://10.0.0.5:8003",
+		"http://web08.internal:80", "http://web09.internal:80", "http://web10.internal:80",
+		"http://api08.internal:80", "http://api09.internal:80", "http://api10.internal:80",
+	}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Errorf("Expected servers %v, got %v", expected, urls)
+	}
+
+	for _, invalid := range []string{
+		"http://10.0.0.5:{8008-8001}",
+		"http://10.0.0.5:{8001-8008",
+		"http://10.0.0.5:{8001}",
+		"http://10.0.0.{0-255}:{8000-8099}",
+	} {
+		configPath, err := testutils.CreateTempConfig("upstream backend {\n server " + invalid + "\n}")
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil {
+			t.Errorf("Expected %s to be rejected", invalid)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestSessionMigration(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(3)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	newLB := func(persistence string) balancer.LoadBalancerStrategy {
+		config := `upstream backend {
+			persistence ` + persistence + `
+			server ` + backends[0] + `
+			server ` + backends[1] + `
+			server ` + backends[2] + `
+		}`
+		configPath, err := testutils.CreateTempConfig(config)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		cfg, err := balancer.ParseConfig(configPath)
+		if err != nil {
+			t.Fatalf("Failed to parse config: %v", err)
+		}
+		lb, err := balancer.CreatePathRouter(cfg)
+		if err != nil {
+			t.Fatalf("Failed to create path router: %v", err)
+		}
+		return lb
+	}
+
+	migrate := func(lb balancer.LoadBalancerStrategy, form url.Values) (*httptest.ResponseRecorder, balancer.SessionMigrationResult) {
+		req := httptest.NewRequest("POST", "/api/sessions/migrate", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		balancer.SessionMigrationHandler(lb)(rec, req)
+
+		var result balancer.SessionMigrationResult
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		return rec, result
+	}
+
+	t.Run("cookie sessions are re-pinned on their next request", func(t *testing.T) {
+		lb := newLB("cookie")
+
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, httptest.NewRequest("GET", "http://localhost/", nil))
+		cookies := rec.Result().Cookies()
+		if len(cookies) != 1 || rec.Header().Get("X-Backend-ID") != "1" {
+			t.Fatalf("Expected a session pinned to backend 1, got %v", rec.Header())
+		}
+
+		if rec, _ := migrate(lb, url.Values{"backend": {backends[0]}, "to": {backends[2]}}); rec.Code != http.StatusOK {
+			t.Fatalf("Expected migration to succeed, got %d %s", rec.Code, rec.Body.String())
+		}
+
+		for i := 0; i < 3; i++ {
+			req := httptest.NewRequest("GET", "http://localhost/", nil)
+			req.AddCookie(cookies[0])
+			rec = httptest.NewRecorder()
+			lb.ProxyRequest(rec, req)
+			if rec.Header().Get("X-Backend-ID") != "3" {
+				t.Errorf("Expected the migrated session on backend 3, got %s", rec.Header().Get("X-Backend-ID"))
+			}
+			if len(rec.Result().Cookies()) != 1 {
+				t.Errorf("Expected the session cookie to be re-pinned")
+			}
+		}
+	})
+
+	t.Run("IP sessions are rewritten at once", func(t *testing.T) {
+		lb := newLB("ip_hash")
+
+		pinned := make(map[string]string)
+		for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5", "10.0.0.6"} {
+			req := httptest.NewRequest("GET", "http://localhost/", nil)
+			req.RemoteAddr = ip + ":1234"
+			rec := httptest.NewRecorder()
+			lb.ProxyRequest(rec, req)
+			pinned[ip] = rec.Header().Get("X-Backend-ID")
+		}
+
+		rec, result := migrate(lb, url.Values{"backend": {backends[1]}})
+		if rec.Code != http.StatusOK || result.IPSessions != 2 || len(result.Targets) != 2 {
+			t.Fatalf("Expected two IP sessions moved to two backends, got %d %s", rec.Code, rec.Body.String())
+		}
+
+		for ip, before := range pinned {
+			req := httptest.NewRequest("GET", "http://localhost/", nil)
+			req.RemoteAddr = ip + ":1234"
+			rec := httptest.NewRecorder()
+			lb.ProxyRequest(rec, req)
+
+			after := rec.Header().Get("X-Backend-ID")
+			if after == "2" || (before != "2" && after != before) {
+				t.Errorf("%s: expected only sessions of backend 2 to move, got %s -> %s", ip, before, after)
+			}
+		}
+	})
+
+	lb := newLB("cookie")
+	if rec, _ := migrate(lb, url.Values{"backend": {"http://unknown:80"}}); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown backend to be rejected, got %d", rec.Code)
+	}
+	if rec, _ := migrate(lb, url.Values{"backend": {backends[0]}, "to": {"http://elsewhere:80"}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected targets outside the pool to be rejected, got %d", rec.Code)
+	}
+}
@@ -1,12 +1,18 @@
 package unit
 
 import (
+	"crypto/tls"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/The-iyed/go-load-balancer/internal/balancer"
 	"github.com/The-iyed/go-load-balancer/internal/testing/mocks"
 	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+	"github.com/gorilla/websocket"
 )
 
 func TestCookiePersistence(t *testing.T) {
@@ -78,6 +84,72 @@ func TestCookiePersistence(t *testing.T) {
 	}
 }
 
+func TestCookieReissuedOnFailover(t *testing.T) {
+	cluster := mocks.NewBackendCluster(2, nil, nil)
+	defer cluster.Close()
+
+	configs := make([]balancer.BackendConfig, 0, 2)
+	for _, url := range cluster.URLs() {
+		configs = append(configs, balancer.BackendConfig{URL: url, Weight: 1})
+	}
+
+	lb, err := balancer.CreateLoadBalancer(balancer.WeightedRoundRobin, configs, balancer.CookiePersistence, nil)
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	send := func(cookie *http.Cookie) *http.Response {
+		req := httptest.NewRequest("GET", "http://localhost/", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req)
+		return rec.Result()
+	}
+
+	resp := send(nil)
+	pinnedID, err := testutils.ParseBackendResponse(resp)
+	if err != nil {
+		t.Fatalf("Failed to parse backend ID: %v", err)
+	}
+	cookie, found := testutils.CookieFromResponse(resp, "GOLB_SESSION")
+	if !found {
+		t.Fatalf("Session cookie not found in response")
+	}
+
+	// Take the pinned backend out of rotation
+	pinnedURL := cluster.URLs()[pinnedID-1]
+	form := url.Values{"backend": {pinnedURL}, "state": {"drain"}}
+	req := httptest.NewRequest("POST", "/api/backends/drain", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	balancer.DrainHandler(lb)(httptest.NewRecorder(), req)
+
+	before := balancer.GetSessionRepins()[pinnedURL]
+
+	resp = send(cookie)
+	if backendID, _ := testutils.ParseBackendResponse(resp); backendID == pinnedID {
+		t.Fatalf("Expected the session to move off the drained backend")
+	}
+	if values := resp.Header.Values("Set-Cookie"); len(values) != 1 {
+		t.Errorf("Expected exactly one Set-Cookie header, got %d", len(values))
+	}
+	newCookie, found := testutils.CookieFromResponse(resp, "GOLB_SESSION")
+	if !found || newCookie.Value == cookie.Value {
+		t.Fatalf("Expected the session cookie to be re-issued for the new backend")
+	}
+
+	if got := balancer.GetSessionRepins()[pinnedURL] - before; got != 1 {
+		t.Errorf("Expected 1 session repin, got %d", got)
+	}
+
+	// Clients holding the new cookie are no longer moved
+	send(newCookie)
+	if got := balancer.GetSessionRepins()[pinnedURL] - before; got != 1 {
+		t.Errorf("Expected no further repins with the re-issued cookie, got %d", got)
+	}
+}
+
 func TestIPHashPersistence(t *testing.T) {
 	cluster := mocks.NewBackendCluster(3, nil, nil)
 	defer cluster.Close()
@@ -238,3 +310,511 @@ func TestConsistentHashPersistence(t *testing.T) {
 		t.Errorf("Expected at least 2 backends to be used for different paths, got %d", backendsUsed)
 	}
 }
+
+type headerAffinity struct {
+	header string
+}
+
+func (h *headerAffinity) Select(r *http.Request, backends []*balancer.Process) *balancer.Process {
+	value := r.Header.Get(h.header)
+	if value == "" {
+		return nil
+	}
+	return backends[int(value[0])%len(backends)]
+}
+
+func (h *headerAffinity) Bind(w http.ResponseWriter, r *http.Request, backend *balancer.Process) {
+	w.Header().Set("X-Bound-Backend", backend.URL.String())
+}
+
+func TestUploadSessionPersistence(t *testing.T) {
+	cluster := mocks.NewBackendCluster(3, nil, nil)
+	defer cluster.Close()
+
+	config := "upstream backend {\n    persistence upload_session header=Upload-ID query=upload_id ttl=1m\n"
+	for _, url := range cluster.URLs() {
+		config += "    server " + url + "\n"
+	}
+	config += "}\n"
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	lb, err := balancer.CreateLoadBalancer(cfg.Method, cfg.PoolBackends("backend"), cfg.PersistenceType, cfg.PersistenceAttrs)
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	send := func(header, query string) int {
+		target := "http://localhost/upload"
+		if query != "" {
+			target += "?upload_id=" + query
+		}
+		req := httptest.NewRequest("PUT", target, nil)
+		if header != "" {
+			req.Header.Set("Upload-ID", header)
+		}
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req)
+
+		backendID, err := testutils.ParseBackendResponse(rec.Result())
+		if err != nil {
+			t.Fatalf("Failed to parse backend ID: %v", err)
+		}
+		return backendID
+	}
+
+	// Interleaved chunks of several uploads each stay on their first backend
+	sessions := map[string]int{}
+	for chunk := 0; chunk < 4; chunk++ {
+		for _, id := range []string{"a", "b", "c"} {
+			backendID := send(id, "")
+			if first, ok := sessions[id]; !ok {
+				sessions[id] = backendID
+			} else if backendID != first {
+				t.Errorf("Upload %s chunk %d: expected backend %d, got %d", id, chunk, first, backendID)
+			}
+		}
+	}
+	if sessions["a"] == sessions["b"] && sessions["b"] == sessions["c"] {
+		t.Errorf("Expected uploads to be spread across backends, all went to %d", sessions["a"])
+	}
+
+	// The session identifier can also come from the query string
+	first := send("", "q1")
+	for i := 0; i < 3; i++ {
+		if backendID := send("", "q1"); backendID != first {
+			t.Errorf("Query upload: expected backend %d, got %d", first, backendID)
+		}
+	}
+
+	// An upload in progress finishes on its backend even while it drains
+	pinnedURL := cluster.URLs()[sessions["a"]-1]
+	form := url.Values{"backend": {pinnedURL}, "state": {"drain"}}
+	req := httptest.NewRequest("POST", "/api/backends/drain", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	balancer.DrainHandler(lb)(httptest.NewRecorder(), req)
+
+	if backendID := send("a", ""); backendID != sessions["a"] {
+		t.Errorf("Expected upload a to stay on draining backend %d, got %d", sessions["a"], backendID)
+	}
+}
+
+func TestCustomPersistenceMethod(t *testing.T) {
+	balancer.RegisterPersistenceMethod("header_affinity", func(attrs map[string]string) (balancer.PersistenceProvider, error) {
+		return &headerAffinity{header: attrs["header"]}, nil
+	})
+
+	cluster := mocks.NewBackendCluster(3, nil, nil)
+	defer cluster.Close()
+
+	config := "upstream backend {\n    persistence header_affinity header=X-Tenant\n"
+	for _, url := range cluster.URLs() {
+		config += "    server " + url + "\n"
+	}
+	config += "}\n"
+
+	client := mocks.NewLoadBalancerTestClient()
+	defer client.Close()
+
+	if err := client.Initialize(config); err != nil {
+		t.Fatalf("Failed to initialize load balancer: %v", err)
+	}
+
+	for _, tenant := range []string{"a", "b", "c"} {
+		req, _ := http.NewRequest("GET", client.ServerURL+"/", nil)
+		req.Header.Set("X-Tenant", tenant)
+
+		var firstBackend int
+		for i := 0; i < 5; i++ {
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Failed to send request: %v", err)
+			}
+
+			backendID, err := testutils.ParseBackendResponse(resp)
+			if err != nil {
+				t.Fatalf("Failed to parse backend ID: %v", err)
+			}
+			if resp.Header.Get("X-Bound-Backend") == "" {
+				t.Errorf("Expected provider to bind the backend")
+			}
+
+			if i == 0 {
+				firstBackend = backendID
+			} else if backendID != firstBackend {
+				t.Errorf("Tenant %s: expected backend %d, got %d", tenant, firstBackend, backendID)
+			}
+		}
+	}
+}
+
+func TestConsistentHashSpillover(t *testing.T) {
+	ring := balancer.NewConsistentHashRing([]balancer.BackendConfig{
+		{URL: "http://backend1:80", Weight: 1},
+		{URL: "http://backend2:80", Weight: 1},
+		{URL: "http://backend3:80", Weight: 1},
+	})
+
+	primary := ring.GetNode("/cache/key")
+	if primary == nil {
+		t.Fatalf("Expected a node for the key")
+	}
+
+	exclude := map[*balancer.Process]bool{primary: true}
+	next := ring.GetNodeWithin("/cache/key", exclude, 1)
+	if next == nil || next == primary {
+		t.Fatalf("Expected the next node clockwise, got %v", next)
+	}
+
+	// The spillover node must be stable for the same key
+	if again := ring.GetNodeWithin("/cache/key", exclude, 1); again != next {
+		t.Errorf("Expected spillover to %s, got %s", next.URL, again.URL)
+	}
+
+	exclude[next] = true
+	if node := ring.GetNodeWithin("/cache/key", exclude, 1); node != nil {
+		t.Errorf("Expected no node within one hop, got %s", node.URL)
+	}
+	if node := ring.GetNodeWithin("/cache/key", exclude, 2); node == nil {
+		t.Errorf("Expected a node within two hops")
+	}
+}
+
+func TestConsistentHashHopsExhausted(t *testing.T) {
+	lb := balancer.NewSessionPersistenceBalancer([]balancer.BackendConfig{
+		{URL: "http://backend1:80", Weight: 1},
+		{URL: "http://backend2:80", Weight: 1},
+		{URL: "http://backend3:80", Weight: 1},
+	}, balancer.WeightedRoundRobin, balancer.ConsistentHashPersistence)
+	lb.MaxHops = 1
+
+	// The key's node and the next one clockwise are down
+	primary := lb.ConsistentHashRing.GetNode("/cache/key")
+	next := lb.ConsistentHashRing.GetNodeWithin("/cache/key", map[*balancer.Process]bool{primary: true}, 1)
+	primary.SetAlive(false)
+	next.SetAlive(false)
+
+	rec := httptest.NewRecorder()
+	lb.ProxyRequest(rec, httptest.NewRequest("GET", "/cache/key", nil))
+	if reason := rec.Header().Get(balancer.RejectReasonHeader); reason != string(balancer.RejectHashHopsExhausted) {
+		t.Errorf("Expected reject reason %q, got %q", balancer.RejectHashHopsExhausted, reason)
+	}
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "max_hops") {
+		t.Errorf("Expected a 503 naming max_hops, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	for _, p := range lb.Backends() {
+		p.SetAlive(false)
+	}
+	rec = httptest.NewRecorder()
+	lb.ProxyRequest(rec, httptest.NewRequest("GET", "/cache/key", nil))
+	if reason := rec.Header().Get(balancer.RejectReasonHeader); reason != string(balancer.RejectNoBackend) {
+		t.Errorf("Expected reject reason %q with every backend down, got %q", balancer.RejectNoBackend, reason)
+	}
+}
+
+func TestConsistentHashMembership(t *testing.T) {
+	ring := balancer.NewConsistentHashRing([]balancer.BackendConfig{
+		{URL: "http://backend1:80", Weight: 1},
+		{URL: "http://backend2:80", Weight: 1},
+		{URL: "http://backend3:80", Weight: 1},
+	})
+	keys := make([]string, 1000)
+	before := make([]*balancer.Process, len(keys))
+	for i := range keys {
+		keys[i] = fmt.Sprintf("/objects/%d", i)
+		before[i] = ring.GetNode(keys[i])
+	}
+
+	added := &balancer.Process{URL: &url.URL{Scheme: "http", Host: "backend4:80"}, Alive: true, Weight: 1}
+	if !ring.AddNode(added) || ring.AddNode(added) || len(ring.Nodes()) != 4 {
+		t.Fatalf("Expected the node to be added once, got %d nodes", len(ring.Nodes()))
+	}
+
+	// Only the keys landing on the new node move
+	moved := 0
+	for i, key := range keys {
+		node := ring.GetNode(key)
+		if node == added {
+			moved++
+		} else if node != before[i] {
+			t.Fatalf("Key %s moved between existing nodes", key)
+		}
+	}
+	if moved < 150 || moved > 350 {
+		t.Errorf("Expected about a quarter of the keys to move to the new node, got %d", moved)
+	}
+
+	if !ring.RemoveNode(added) || ring.RemoveNode(added) {
+		t.Fatalf("Expected the node to be removed once")
+	}
+	for i, key := range keys {
+		if node := ring.GetNode(key); node != before[i] {
+			t.Fatalf("Expected key %s back on its node once the new node left", key)
+		}
+	}
+}
+
+func TestSessionPersistenceUpdateBackends(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(3)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	configs := func(urls ...string) []balancer.BackendConfig {
+		var configs []balancer.BackendConfig
+		for _, u := range urls {
+			configs = append(configs, balancer.BackendConfig{URL: u, Weight: 1})
+		}
+		return configs
+	}
+	lb := balancer.NewSessionPersistenceBalancer(configs(backends[0], backends[1]), balancer.WeightedRoundRobin, balancer.CookiePersistence)
+
+	rec := httptest.NewRecorder()
+	lb.ProxyRequest(rec, httptest.NewRequest("GET", "/", nil))
+	cookie, ok := testutils.CookieFromResponse(rec.Result(), lb.CookieName)
+	if !ok {
+		t.Fatalf("Expected a session cookie")
+	}
+	pinned := rec.Header().Get("X-Backend-ID")
+
+	// The pinned backend is kept at another index, the other one is removed
+	kept := backends[0]
+	if pinned == "2" {
+		kept = backends[1]
+	}
+	lb.UpdateBackends(configs(backends[2], kept))
+
+	if urls := lb.Backends(); len(urls) != 2 || urls[0].URL.String() != backends[2] || urls[1].URL.String() != kept {
+		t.Fatalf("Expected the new generation of backends, got %v", urls)
+	}
+	if nodes := lb.ConsistentHashRing.Nodes(); len(nodes) != 2 {
+		t.Errorf("Expected the hash ring to be rebuilt over 2 backends, got %d", len(nodes))
+	}
+
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req)
+		if backendID := rec.Header().Get("X-Backend-ID"); backendID != pinned {
+			t.Fatalf("Expected the session to stay on backend %s, got %s", pinned, backendID)
+		}
+		if reissued, ok := testutils.CookieFromResponse(rec.Result(), lb.CookieName); !ok || !strings.HasPrefix(reissued.Value, "1:") {
+			t.Errorf("Expected the cookie to be reissued with the backend's new index, got %v", reissued)
+		}
+	}
+}
+
+func TestConsistentHashBoundedLoad(t *testing.T) {
+	ring := balancer.NewConsistentHashRing([]balancer.BackendConfig{
+		{URL: "http://backend1:80", Weight: 1},
+		{URL: "http://backend2:80", Weight: 1},
+		{URL: "http://backend3:80", Weight: 1},
+	})
+	primary := ring.GetNode("/hot/key")
+	for i := 0; i < 5; i++ {
+		primary.IncrementConnections()
+	}
+
+	// Unbounded, the hot key stays on its node however loaded it is
+	if node := ring.GetNode("/hot/key"); node != primary {
+		t.Fatalf("Expected the key to stay on %s, got %s", primary.URL, node.URL)
+	}
+
+	// At 1.25 times the average of 6 connections over 3 nodes, a node
+	// takes 3 connections, so the key spills to the next node
+	ring.SetLoadFactor(1.25)
+	spill := ring.GetNode("/hot/key")
+	if spill == primary {
+		t.Fatalf("Expected the key to spill off its full node")
+	}
+	if again := ring.GetNode("/hot/key"); again != spill {
+		t.Errorf("Expected the key to spill to %s consistently, got %s", spill.URL, again.URL)
+	}
+
+	for i := 0; i < 5; i++ {
+		primary.DecrementConnections()
+	}
+	if node := ring.GetNode("/hot/key"); node != primary {
+		t.Errorf("Expected the key back on its node once it has room, got %s", node.URL)
+	}
+
+	for _, factor := range []string{"0.5", "many"} {
+		configPath, err := testutils.CreateTempConfig(`upstream backend {
+			persistence consistent_hash bounded_load=` + factor + `
+			server http://backend1:80
+		}`)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		cfg, err := balancer.ParseConfig(configPath)
+		if err != nil {
+			t.Fatalf("Failed to parse config: %v", err)
+		}
+		if _, err := balancer.CreatePathRouter(cfg); err == nil || !strings.Contains(err.Error(), "invalid bounded_load") {
+			t.Errorf("bounded_load=%s: expected an invalid bounded_load error, got %v", factor, err)
+		}
+	}
+}
+
+func TestWebSocketSessionAffinity(t *testing.T) {
+	a := newNamedWebSocketBackend("a")
+	defer a.Close()
+	b := newNamedWebSocketBackend("b")
+	defer b.Close()
+
+	for _, persistence := range []string{"cookie", "ip_hash"} {
+		t.Run(persistence, func(t *testing.T) {
+			configPath, err := testutils.CreateTempConfig(`persistence ` + persistence + `
+
+			upstream backend {
+				server ` + a.URL + `
+				server ` + b.URL + `
+			}
+
+			route path / backend`)
+			if err != nil {
+				t.Fatalf("Failed to create config file: %v", err)
+			}
+			cfg, err := balancer.ParseConfig(configPath)
+			if err != nil {
+				t.Fatalf("Failed to parse config: %v", err)
+			}
+			lb, err := balancer.CreatePathRouter(cfg)
+			if err != nil {
+				t.Fatalf("Failed to create path router: %v", err)
+			}
+			proxy := httptest.NewServer(http.HandlerFunc(lb.ProxyRequest))
+			defer proxy.Close()
+			wsURL := "ws" + strings.TrimPrefix(proxy.URL, "http")
+
+			dial := func(header http.Header) (string, *http.Response) {
+				conn, resp, err := websocket.DefaultDialer.Dial(wsURL, header)
+				if err != nil {
+					t.Fatalf("Failed to open a WebSocket: %v", err)
+				}
+				defer conn.Close()
+				return echoBackend(t, conn), resp
+			}
+
+			first, resp := dial(nil)
+			header := http.Header{}
+			if persistence == "cookie" {
+				cookies := resp.Cookies()
+				if len(cookies) == 0 || cookies[0].Name != "GOLB_SESSION" {
+					t.Fatalf("Expected the upgrade response to set the affinity cookie, got %v", resp.Header)
+				}
+				header.Set("Cookie", cookies[0].Name+"="+cookies[0].Value)
+			}
+
+			// Reconnecting sockets return to the same backend
+			for i := 0; i < 4; i++ {
+				if got, _ := dial(header); got != first {
+					t.Errorf("Reconnect %d: expected backend %s, got %s", i, first, got)
+				}
+			}
+		})
+	}
+}
+
+func TestCookieAttributes(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(3)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	newBalancer := func(options string) (balancer.LoadBalancerStrategy, error) {
+		configPath, err := testutils.CreateTempConfig(`upstream backend {
+			persistence cookie ` + options + `
+			server ` + backends[0] + `
+			server ` + backends[1] + `
+			server ` + backends[2] + `
+		}`)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		cfg, err := balancer.ParseConfig(configPath)
+		if err != nil {
+			return nil, err
+		}
+		return balancer.CreatePathRouter(cfg)
+	}
+	sessionCookie := func(lb balancer.LoadBalancerStrategy, name string, secureConn bool) *http.Cookie {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/", nil)
+		if secureConn {
+			req.TLS = &tls.ConnectionState{}
+		}
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req)
+		for _, cookie := range rec.Result().Cookies() {
+			if cookie.Name == name {
+				return cookie
+			}
+		}
+		t.Fatalf("Expected a %s cookie, got %v", name, rec.Result().Cookies())
+		return nil
+	}
+
+	// The defaults stay as they were
+	lb, err := newBalancer("")
+	if err != nil {
+		t.Fatalf("Failed to create balancer: %v", err)
+	}
+	cookie := sessionCookie(lb, "GOLB_SESSION", false)
+	if cookie.Path != "/" || cookie.MaxAge != 86400 || cookie.Secure || cookie.Domain != "" || cookie.SameSite != 0 || !cookie.HttpOnly {
+		t.Errorf("Unexpected default cookie: %s", cookie)
+	}
+	if cookie := sessionCookie(lb, "GOLB_SESSION", true); !cookie.Secure {
+		t.Errorf("Expected the cookie to be Secure over TLS, got %s", cookie)
+	}
+
+	lb, err = newBalancer("name=route ttl=30m domain=example.com path=/app samesite=Strict secure=on")
+	if err != nil {
+		t.Fatalf("Failed to create balancer: %v", err)
+	}
+	cookie = sessionCookie(lb, "route", false)
+	if cookie.Path != "/app" || cookie.MaxAge != 1800 || !cookie.Secure || cookie.Domain != "example.com" || cookie.SameSite != http.SameSiteStrictMode {
+		t.Errorf("Unexpected configured cookie: %s", cookie)
+	}
+
+	// Sessions stick by the configured cookie
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "route", Value: cookie.Value})
+	first := httptest.NewRecorder()
+	lb.ProxyRequest(first, req)
+	for i := 0; i < 5; i++ {
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req.Clone(req.Context()))
+		if rec.Header().Get("X-Backend-ID") != first.Header().Get("X-Backend-ID") {
+			t.Fatalf("Expected the session to stay on backend %s, got %s", first.Header().Get("X-Backend-ID"), rec.Header().Get("X-Backend-ID"))
+		}
+	}
+
+	for _, tc := range []struct{ options, want string }{
+		{"name=bad;name", "invalid session cookie"},
+		{"ttl=forever", "invalid cookie ttl"},
+		{"ttl=500ms", "invalid cookie ttl"},
+		{"domain=exa_mple..com", "invalid session cookie"},
+		{"path=app", "invalid cookie path"},
+		{"samesite=loose", "invalid cookie samesite"},
+		{"secure=yes", "invalid cookie secure"},
+		{"samesite=none", "requires secure=on"},
+		{"expires=1h", "unknown cookie persistence option"},
+	} {
+		if _, err := newBalancer(tc.options); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.options, tc.want, err)
+		}
+	}
+}
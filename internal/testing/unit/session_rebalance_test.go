@@ -0,0 +1,157 @@
+package unit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestSessionRebalance(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(2)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	// Rebalances are timed by a clock the test moves on
+	var elapsed atomic.Int64
+	start := time.Now()
+	balancer.SetSessionRebalanceClock(func() time.Time { return start.Add(time.Duration(elapsed.Load())) })
+	defer balancer.SetSessionRebalanceClock(nil)
+	advance := func(d time.Duration) { elapsed.Add(int64(d)) }
+
+	newBalancer := func(persistence string) (balancer.LoadBalancerStrategy, error) {
+		configPath, err := testutils.CreateTempConfig(`upstream backend {
+			method weighted_round_robin
+			persistence ` + persistence + `
+			server ` + backends[0] + `
+			server ` + backends[1] + `
+		}
+
+		route path / backend`)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		cfg, err := balancer.ParseConfig(configPath)
+		if err != nil {
+			return nil, err
+		}
+		return balancer.CreatePathRouter(cfg)
+	}
+
+	for _, persistence := range []string{"cookie", "ip_hash"} {
+		t.Run(persistence, func(t *testing.T) {
+			// The whole rebalance takes a minute
+			lb, err := newBalancer(persistence + " rebalance=100%")
+			if err != nil {
+				t.Fatalf("Failed to create balancer: %v", err)
+			}
+
+			const clients = 200
+			cookies := make([]string, clients)
+			pinned := make([]string, clients)
+			round := func() {
+				for i := 0; i < clients; i++ {
+					req := httptest.NewRequest("GET", "/", nil)
+					req.RemoteAddr = fmt.Sprintf("10.1.%d.%d:1234", i/250, i%250+1)
+					if cookies[i] != "" {
+						req.Header.Set("Cookie", cookies[i])
+					}
+					rec := httptest.NewRecorder()
+					lb.ProxyRequest(rec, req)
+					if set := rec.Result().Cookies(); len(set) > 0 {
+						cookies[i] = set[0].Name + "=" + set[0].Value
+					}
+					pinned[i] = rec.Header().Get("X-Backend-ID")
+				}
+			}
+			round()
+			before := append([]string(nil), pinned...)
+
+			// Backend 2 takes three quarters of the sessions from now on, so
+			// half the sessions of backend 1 move to it
+			form := url.Values{"weight": {"3"}}
+			req := httptest.NewRequest("POST", "/api/backends/backend-1/weight", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			balancer.BackendOverrideHandler(lb)(rec, req)
+			if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"weight":3`) {
+				t.Fatalf("Expected the backend to be reweighted, got %d: %s", rec.Code, rec.Body.String())
+			}
+
+			moved := func() (int, int) {
+				toSecond, toFirst := 0, 0
+				for i := range pinned {
+					switch {
+					case before[i] == "1" && pinned[i] == "2":
+						toSecond++
+					case before[i] == "2" && pinned[i] == "1":
+						toFirst++
+					}
+				}
+				return toSecond, toFirst
+			}
+			// The new weight is seen at the next check, which starts the
+			// rebalance without moving any session yet
+			advance(time.Second)
+			round()
+			if toSecond, toFirst := moved(); toSecond != 0 || toFirst != 0 {
+				t.Fatalf("Expected no session to move when the rebalance starts, got %d and %d", toSecond, toFirst)
+			}
+
+			// Sessions move as they are seen during the rebalance: halfway
+			// through, about half the sessions to move have moved
+			advance(30 * time.Second)
+			round()
+			if halfway, _ := moved(); halfway < 10 || halfway > 40 {
+				t.Errorf("Expected about a quarter of the sessions of backend 1 to have moved halfway through, got %d", halfway)
+			}
+			advance(29 * time.Second)
+			round()
+			toSecond, toFirst := moved()
+			if toSecond < 30 || toSecond > 70 || toFirst != 0 {
+				t.Errorf("Expected about half the sessions of backend 1 to move, got %d moved to backend 2 and %d to backend 1", toSecond, toFirst)
+			}
+
+			// Moved sessions stay moved once the rebalance is over. The
+			// request ending it may still move its own session.
+			advance(2 * time.Second)
+			round()
+			ended, _ := moved()
+			if ended > toSecond+1 {
+				t.Errorf("Expected at most the session ending the rebalance to move, got %d moved instead of %d", ended, toSecond)
+			}
+			round()
+			if after, back := moved(); after != ended || back != 0 {
+				t.Errorf("Expected the sessions to stay put after the rebalance, got %d moved to backend 2 and %d to backend 1", after, back)
+			}
+		})
+	}
+
+	lb, err := newBalancer("cookie")
+	if err != nil {
+		t.Fatalf("Failed to create balancer: %v", err)
+	}
+	for _, weight := range []string{"0", "heavy"} {
+		req := httptest.NewRequest("POST", "/api/backends/backend-1/weight?weight="+weight, nil)
+		rec := httptest.NewRecorder()
+		balancer.BackendOverrideHandler(lb)(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("weight=%s: expected status 400, got %d", weight, rec.Code)
+		}
+	}
+
+	for _, persistence := range []string{"cookie rebalance=10", "cookie rebalance=0%", "ip_hash rebalance=fast"} {
+		if _, err := newBalancer(persistence); err == nil || !strings.Contains(err.Error(), "invalid rebalance rate") {
+			t.Errorf("%s: expected an invalid rebalance rate error, got %v", persistence, err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package unit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestSessionTable(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(2)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	newBalancer := func(persistence string) (balancer.LoadBalancerStrategy, error) {
+		configPath, err := testutils.CreateTempConfig(`upstream backend {
+			method weighted_round_robin
+			persistence ` + persistence + `
+			server ` + backends[0] + `
+			server ` + backends[1] + `
+		}
+
+		route path / backend`)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		cfg, err := balancer.ParseConfig(configPath)
+		if err != nil {
+			return nil, err
+		}
+		return balancer.CreatePathRouter(cfg)
+	}
+	sessions := func(lb balancer.LoadBalancerStrategy, query string) balancer.PoolSessions {
+		rec := httptest.NewRecorder()
+		balancer.SessionsHandler(lb)(rec, httptest.NewRequest("GET", "/api/sessions"+query, nil))
+		var pools []balancer.PoolSessions
+		if err := json.NewDecoder(rec.Body).Decode(&pools); err != nil || len(pools) != 1 {
+			t.Fatalf("Expected the sessions of one pool, got %v: %s", err, rec.Body.String())
+		}
+		return pools[0]
+	}
+
+	lb, err := newBalancer("cookie")
+	if err != nil {
+		t.Fatalf("Failed to create balancer: %v", err)
+	}
+	cookies := make([]string, 4)
+	send := func(i int) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = fmt.Sprintf("10.2.0.%d:1234", i+1)
+		if cookies[i] != "" {
+			req.Header.Set("Cookie", cookies[i])
+		}
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req)
+		if set := rec.Result().Cookies(); len(set) > 0 {
+			cookies[i] = set[0].Name + "=" + set[0].Value
+		}
+		return rec.Header().Get("X-Backend-ID")
+	}
+	for i := range cookies {
+		send(i)
+	}
+
+	all := sessions(lb, "")
+	if all.Persistence != "Cookie" || len(all.Sessions) != 4 || all.Counts[backends[0]] != 2 || all.Counts[backends[1]] != 2 {
+		t.Fatalf("Expected two sessions on each backend, got %+v", all)
+	}
+	if first := sessions(lb, "?backend="+backends[0]); len(first.Sessions) != 2 || len(first.Counts) != 1 {
+		t.Errorf("Expected the two sessions of the first backend, got %+v", first)
+	}
+
+	// Evicted sessions are balanced afresh, the others stay pinned
+	rec := httptest.NewRecorder()
+	balancer.SessionsHandler(lb)(rec, httptest.NewRequest("DELETE", "/api/sessions?backend="+backends[0], nil))
+	var result balancer.SessionEvictionResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil || result.Sessions != 2 {
+		t.Fatalf("Expected two sessions to be evicted, got %d: %v", result.Sessions, err)
+	}
+	for i := range cookies {
+		send(i)
+	}
+	if after := sessions(lb, ""); len(after.Sessions) != 4 || after.Counts[backends[0]] != 1 || after.Counts[backends[1]] != 3 {
+		t.Errorf("Expected the evicted sessions to be spread over both backends, got %+v", after.Counts)
+	}
+
+	for _, tc := range []struct {
+		query string
+		code  int
+	}{
+		{"", http.StatusBadRequest},
+		{"?backend=http://unknown:80", http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		balancer.SessionsHandler(lb)(rec, httptest.NewRequest("DELETE", "/api/sessions"+tc.query, nil))
+		if rec.Code != tc.code {
+			t.Errorf("DELETE %q: expected status %d, got %d", tc.query, tc.code, rec.Code)
+		}
+	}
+
+	// IP hash sessions expire once unused for their TTL
+	lb, err = newBalancer("ip_hash ttl=50ms")
+	if err != nil {
+		t.Fatalf("Failed to create balancer: %v", err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.2.1.1:1234"
+	lb.ProxyRequest(httptest.NewRecorder(), req)
+	if ip := sessions(lb, ""); ip.Persistence != "IP Hash" || len(ip.Sessions) != 1 || ip.Sessions[0].Client != "10.2.1.1" {
+		t.Fatalf("Expected the session of the client, got %+v", ip)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if ip := sessions(lb, ""); len(ip.Sessions) != 0 {
+		t.Errorf("Expected the session to expire, got %+v", ip.Sessions)
+	}
+
+	// A full table forgets the least recently used sessions
+	lb, err = newBalancer("ip_hash max_sessions=2")
+	if err != nil {
+		t.Fatalf("Failed to create balancer: %v", err)
+	}
+	for _, client := range []string{"10.2.2.1", "10.2.2.2", "10.2.2.1", "10.2.2.3"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = client + ":1234"
+		lb.ProxyRequest(httptest.NewRecorder(), req)
+	}
+	ip := sessions(lb, "")
+	if len(ip.Sessions) != 2 || ip.Sessions[0].Client != "10.2.2.1" || ip.Sessions[1].Client != "10.2.2.3" {
+		t.Errorf("Expected the least recently used session to be forgotten, got %+v", ip.Sessions)
+	}
+	stats := balancer.GetStats(lb).SessionTables["backend"]
+	if stats.Entries != 2 || stats.MaxEntries != 2 || stats.Dropped != 1 {
+		t.Errorf("Expected a full table of 2 sessions with 1 dropped, got %+v", stats)
+	}
+
+	for _, tc := range []struct{ persistence, want string }{
+		{"ip_hash ttl=soon", "invalid ip_hash session ttl"},
+		{"ip_hash max_sessions=0", "invalid max_sessions"},
+		{"cookie max_sessions=many", "invalid max_sessions"},
+	} {
+		if _, err := newBalancer(tc.persistence); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.persistence, tc.want, err)
+		}
+	}
+}
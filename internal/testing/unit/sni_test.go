@@ -0,0 +1,126 @@
+package unit
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestSNIPassthrough(t *testing.T) {
+	newBackend := func(id string) *httptest.Server {
+		return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Backend-ID", id)
+		}))
+	}
+	api, web, other := newBackend("api"), newBackend("web"), newBackend("other")
+	defer api.Close()
+	defer web.Close()
+	defer other.Close()
+
+	configPath, err := testutils.CreateTempConfig(`sni_listen 127.0.0.1:0
+	sni_route api.example.com api
+	sni_route *.web.example.com web
+	upstream api {
+		server ` + api.URL + `
+	}
+	upstream web {
+		server ` + web.URL + `
+	}
+	upstream other {
+		server ` + other.URL + `
+	}`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	router, err := balancer.NewSNIRouter(lb, cfg.SNI)
+	if err != nil {
+		t.Fatalf("Failed to create SNI router: %v", err)
+	}
+	listener, err := net.Listen("tcp", cfg.SNI.Listen)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	router.Serve(listener)
+	defer router.Stop()
+
+	send := func(serverName string) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{ServerName: serverName, InsecureSkipVerify: true},
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "tcp", router.Addr().String())
+			},
+		}}
+		defer client.CloseIdleConnections()
+		resp, err := client.Get("https://" + serverName + "/")
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		return resp, nil
+	}
+
+	for _, tc := range []struct {
+		serverName string
+		backend    *httptest.Server
+		id         string
+	}{
+		{"api.example.com", api, "api"},
+		{"API.Example.com", api, "api"},
+		{"shop.web.example.com", web, "web"},
+	} {
+		resp, err := send(tc.serverName)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", tc.serverName, err)
+		}
+		if resp.Header.Get("X-Backend-ID") != tc.id {
+			t.Errorf("%s: expected backend %q, got %q", tc.serverName, tc.id, resp.Header.Get("X-Backend-ID"))
+		}
+		// TLS is not terminated, so the client sees the backend's certificate
+		if !resp.TLS.PeerCertificates[0].Equal(tc.backend.Certificate()) {
+			t.Errorf("%s: expected the backend's certificate", tc.serverName)
+		}
+	}
+
+	// A wildcard matches a single label, and names without a route are
+	// refused
+	for _, serverName := range []string{"a.shop.web.example.com", "web.example.com", "example.org"} {
+		if _, err := send(serverName); err == nil {
+			t.Errorf("%s: expected the connection to be refused", serverName)
+		}
+	}
+
+	for _, tc := range []struct{ config, want string }{
+		{"sni_route api.example.com", "requires a server name and a backend pool"},
+		{"sni_route api.*.com api", "invalid sni server name"},
+		{"sni_route api.example.com api\nsni_route API.example.com. api", "duplicate sni route"},
+		{"sni_route api.example.com missing", "sni route backend pool not found: missing"},
+	} {
+		configPath, err := testutils.CreateTempConfig(`sni_listen 127.0.0.1:0
+		upstream api {
+			server ` + api.URL + `
+		}
+		` + tc.config)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		errs := balancer.ValidateConfig(configPath, false)
+		if len(errs) == 0 || !strings.Contains(errs[0].Error(), tc.want) {
+			t.Errorf("%q: expected an error containing %q, got %v", tc.config, tc.want, errs)
+		}
+	}
+}
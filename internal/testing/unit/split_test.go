@@ -0,0 +1,191 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestTrafficSplitRoutes(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(2)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	newLB := func(split string) balancer.LoadBalancerStrategy {
+		config := `upstream backend {
+			server ` + backends[0] + `
+		}
+
+		upstream canary {
+			server ` + backends[1] + `
+		}
+
+		route path /app/ split ` + split + ` sticky=cookie:app_split
+		route path /api/ split 80% backend 20% canary`
+
+		configPath, err := testutils.CreateTempConfig(config)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		cfg, err := balancer.ParseConfig(configPath)
+		if err != nil {
+			t.Fatalf("Failed to parse config: %v", err)
+		}
+		lb, err := balancer.CreatePathRouter(cfg)
+		if err != nil {
+			t.Fatalf("Failed to create path router: %v", err)
+		}
+		return lb
+	}
+
+	send := func(lb balancer.LoadBalancerStrategy, path string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://localhost"+path, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req)
+		return rec
+	}
+
+	lb := newLB("90% backend 10% canary")
+
+	canary := 0
+	for i := 0; i < 2000; i++ {
+		if send(lb, "/api/", nil).Header().Get("X-Backend-ID") == "2" {
+			canary++
+		}
+	}
+	if canary < 300 || canary > 500 {
+		t.Errorf("Expected about 20%% of requests on the canary, got %d of 2000", canary)
+	}
+
+	// A sticky client keeps its side of the split
+	rec := send(lb, "/app/", nil)
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "app_split" {
+		t.Fatalf("Expected a sticky split cookie, got %v", cookies)
+	}
+	side := rec.Header().Get("X-Backend-ID")
+	for i := 0; i < 20; i++ {
+		rec := send(lb, "/app/", cookies[0])
+		if rec.Header().Get("X-Backend-ID") != side || len(rec.Result().Cookies()) != 0 {
+			t.Fatalf("Expected the sticky client to stay on backend %s", side)
+		}
+	}
+
+	// Growing the canary keeps its clients and moves some stable ones
+	shifted := newLB("50% backend 50% canary")
+	for _, c := range []struct {
+		bucket        string
+		before, after string
+	}{
+		{"9500", "2", "2"},
+		{"7000", "1", "2"},
+		{"100", "1", "1"},
+	} {
+		cookie := &http.Cookie{Name: "app_split", Value: c.bucket}
+		if got := send(lb, "/app/", cookie).Header().Get("X-Backend-ID"); got != c.before {
+			t.Errorf("Bucket %s: expected backend %s before the shift, got %s", c.bucket, c.before, got)
+		}
+		if got := send(shifted, "/app/", cookie).Header().Get("X-Backend-ID"); got != c.after {
+			t.Errorf("Bucket %s: expected backend %s after the shift, got %s", c.bucket, c.after, got)
+		}
+	}
+
+	configPath, err := testutils.CreateTempConfig("route path / split 90% backend 20% canary")
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	if _, err := balancer.ParseConfig(configPath); err == nil {
+		t.Errorf("Expected percentages not adding up to 100%% to be rejected")
+	}
+}
+
+func TestTrafficSplitByUserKey(t *testing.T) {
+	variant := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Variant", r.Header.Get(balancer.SplitHeader))
+	}
+	a := httptest.NewServer(http.HandlerFunc(variant))
+	defer a.Close()
+	b := httptest.NewServer(http.HandlerFunc(variant))
+	defer b.Close()
+
+	config := `upstream variant_a {
+		server ` + a.URL + `
+	}
+
+	upstream variant_b {
+		server ` + b.URL + `
+	}
+
+	route path /exp/ split 50% variant_a 50% variant_b split_key=header:X-User-ID`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	send := func(user string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://localhost/exp/", nil)
+		req.Header.Set("X-User-ID", user)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req)
+		return rec
+	}
+
+	// Users are assigned by their key alone, and told their bucket
+	inB := 0
+	for i := 0; i < 200; i++ {
+		user := "user-" + strconv.Itoa(i)
+		rec := send(user, nil)
+		assigned := rec.Header().Get("X-Variant")
+		if assigned != "variant_a" && assigned != "variant_b" {
+			t.Fatalf("Expected the backend to see the variant, got %q", assigned)
+		}
+		if cookies := rec.Result().Cookies(); len(cookies) != 1 || cookies[0].Name != "GOLB_SPLIT" {
+			t.Fatalf("Expected a bucket cookie, got %v", cookies)
+		}
+		for j := 0; j < 3; j++ {
+			if got := send(user, nil).Header().Get("X-Variant"); got != assigned {
+				t.Fatalf("Expected %s to stay on %s, got %s", user, assigned, got)
+			}
+		}
+		if assigned == "variant_b" {
+			inB++
+		}
+	}
+	if inB < 70 || inB > 130 {
+		t.Errorf("Expected about half of the users on variant_b, got %d of 200", inB)
+	}
+
+	// The bucket cookie wins over the key
+	if got := send("user-0", &http.Cookie{Name: "GOLB_SPLIT", Value: "9999"}).Header().Get("X-Variant"); got != "variant_b" {
+		t.Errorf("Expected the bucket cookie to assign variant_b, got %s", got)
+	}
+
+	configPath, err = testutils.CreateTempConfig("route path / backend split_key=header:X-User-ID")
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	if _, err := balancer.ParseConfig(configPath); err == nil {
+		t.Errorf("Expected split_key without a split to be rejected")
+	}
+}
@@ -0,0 +1,161 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestStaticRoute(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"app.js":          "console.log('hello world')",
+		"css/index.html":  "<h1>styles</h1>",
+		".env":            "SECRET=1",
+		"img/.hidden.png": "hidden",
+	} {
+		file := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	// Links within the directory are followed, links out of it are not
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	for link, target := range map[string]string{
+		"app-link.js": filepath.Join(root, "app.js"),
+		"secret.txt":  filepath.Join(outside, "secret.txt"),
+		"outside":     outside,
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+	}
+
+	configPath, err := testutils.CreateTempConfig(`upstream backend {
+		server http://localhost:8080
+	}
+
+	route path /assets/ static:` + root + ` max_age=10m
+	route path /files/ static:` + root + `
+	route path /docs static:` + root)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	get := func(method, path string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, r)
+		return rec
+	}
+
+	rec := get("GET", "/assets/app.js", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "console.log('hello world')" {
+		t.Fatalf("Expected the file to be served, got %d %q", rec.Code, rec.Body.String())
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "public, max-age=600" {
+		t.Errorf("Expected a 10 minute max-age, got %q", cc)
+	}
+	if !strings.Contains(rec.Header().Get("Content-Type"), "javascript") {
+		t.Errorf("Expected a JavaScript content type, got %q", rec.Header().Get("Content-Type"))
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" || rec.Header().Get("Last-Modified") == "" {
+		t.Errorf("Expected ETag and Last-Modified, got %v", rec.Header())
+	}
+
+	if rec := get("GET", "/assets/app.js", http.Header{"If-None-Match": {etag}}); rec.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for a matching ETag, got %d", rec.Code)
+	}
+	rec = get("GET", "/assets/app.js", http.Header{"Range": {"bytes=0-6"}})
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "console" {
+		t.Errorf("Expected the first 7 bytes, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	if rec := get("GET", "/files/app.js", nil); rec.Header().Get("Cache-Control") != "public, max-age=3600" {
+		t.Errorf("Expected the default max-age, got %q", rec.Header().Get("Cache-Control"))
+	}
+	if rec := get("GET", "/assets/css/", nil); rec.Code != http.StatusOK || rec.Body.String() != "<h1>styles</h1>" {
+		t.Errorf("Expected the directory's index.html, got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := get("GET", "/docs/app.js", nil); rec.Code != http.StatusOK {
+		t.Errorf("Expected a route without a trailing slash to serve its files, got %d", rec.Code)
+	}
+	if rec := get("GET", "/assets/app-link.js", nil); rec.Code != http.StatusOK || rec.Body.String() != "console.log('hello world')" {
+		t.Errorf("Expected a link within the directory to be followed, got %d %q", rec.Code, rec.Body.String())
+	}
+	for _, path := range []string{
+		"/assets/missing.js", "/assets/.env", "/assets/img/.hidden.png", "/assets/img/", "/assets/../static_test.go",
+		"/assets/secret.txt", "/assets/outside/secret.txt", "/docsapp.js",
+	} {
+		if rec := get("GET", path, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for %s, got %d", path, rec.Code)
+		}
+	}
+	if rec := get("POST", "/assets/app.js", nil); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for a POST, got %d", rec.Code)
+	}
+}
+
+func TestStaticRouteConfig(t *testing.T) {
+	for _, tc := range []struct {
+		route, err string
+	}{
+		{"route path /assets/ static:", "requires a directory"},
+		{"route path /assets/ backend max_age=1h", "max_age requires a static route"},
+		{"route path /assets/ static:/tmp max_age=soon", "invalid static route max_age"},
+		{"route path /assets/ static:/tmp canary=backend:5", "cannot have a canary"},
+	} {
+		configPath, err := testutils.CreateTempConfig(`upstream backend {
+		server http://localhost:8080
+	}
+
+	` + tc.route)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("Expected an error containing %q for %q, got %v", tc.err, tc.route, err)
+		}
+	}
+
+	configPath, err := testutils.CreateTempConfig(`upstream backend {
+		server http://localhost:8080
+	}
+
+	route path /assets/ static:/nonexistent/assets`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if _, err := balancer.CreatePathRouter(cfg); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected a missing directory error, got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestStatsHistory(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			time.Sleep(30 * time.Millisecond)
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer backend.Close()
+
+	configPath, err := testutils.CreateTempConfig(`upstream backend {
+		server ` + backend.URL + `
+	}`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	send := func(paths ...string) {
+		for _, path := range paths {
+			lb.ProxyRequest(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost"+path, nil))
+		}
+	}
+
+	recorder := balancer.NewStatsRecorder(lb, time.Minute, 2)
+	start := time.Now()
+
+	// The oldest snapshot is dropped once the ring is full
+	send("/")
+	recorder.Snapshot(start.Add(-2 * time.Hour))
+	send("/", "/", "/", "/", "/", "/", "/", "/", "/slow", "/broken")
+	recorder.Snapshot(start.Add(-30 * time.Minute))
+	send("/")
+	recorder.Snapshot(start)
+
+	history := recorder.History(0, start)
+	if history.IntervalSeconds != 60 || len(history.Snapshots) != 2 {
+		t.Fatalf("Expected 2 snapshots a minute apart, got %+v", history)
+	}
+
+	snapshot := history.Snapshots[0]
+	if snapshot.Time != start.Add(-30*time.Minute).UnixMilli() || snapshot.Requests != 10 || snapshot.Errors != 1 {
+		t.Errorf("Unexpected snapshot: %+v", snapshot)
+	}
+	backendSnapshot := snapshot.Backends[backend.URL]
+	if !backendSnapshot.Alive || backendSnapshot.Requests != 10 || backendSnapshot.Errors != 1 {
+		t.Errorf("Unexpected backend snapshot: %+v", backendSnapshot)
+	}
+	if backendSnapshot.P50 >= 30 || backendSnapshot.P99 < 30 || backendSnapshot.P90 > backendSnapshot.P99 {
+		t.Errorf("Expected p50 under 30ms and p99 over it, got %+v", backendSnapshot)
+	}
+	if history.Snapshots[1].Requests != 1 {
+		t.Errorf("Expected 1 request in the last snapshot, got %d", history.Snapshots[1].Requests)
+	}
+
+	// Snapshots older than the range are left out
+	rec := httptest.NewRecorder()
+	balancer.StatsHistoryHandler(recorder)(rec, httptest.NewRequest("GET", "/api/stats/history?range=10m", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	var ranged balancer.StatsHistory
+	if err := json.NewDecoder(rec.Body).Decode(&ranged); err != nil {
+		t.Fatalf("Failed to decode stats history: %v", err)
+	}
+	if len(ranged.Snapshots) != 1 || ranged.Snapshots[0].Time != start.UnixMilli() {
+		t.Errorf("Expected the last snapshot only, got %+v", ranged.Snapshots)
+	}
+
+	rec = httptest.NewRecorder()
+	balancer.StatsHistoryHandler(recorder)(rec, httptest.NewRequest("GET", "/api/stats/history?range=recent", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid range, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,109 @@
+package unit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestStatsCountBackendRequests(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer backend.Close()
+
+	config := `upstream backend {
+		server ` + backend.URL + `
+	}`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	for _, path := range []string{"/", "/", "/missing"} {
+		lb.ProxyRequest(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost"+path, nil))
+	}
+
+	stats := balancer.GetStats(lb)
+	if len(stats.Backends) != 1 {
+		t.Fatalf("Expected 1 backend in stats, got %d", len(stats.Backends))
+	}
+
+	backendStats := stats.Backends[0]
+	if backendStats.RequestCount != 3 || stats.TotalRequests != 3 {
+		t.Errorf("Expected 3 requests, got %d (total %d)", backendStats.RequestCount, stats.TotalRequests)
+	}
+	if backendStats.StatusCodes["2xx"] != 2 || backendStats.StatusCodes["4xx"] != 1 {
+		t.Errorf("Unexpected status code counts: %v", backendStats.StatusCodes)
+	}
+	if backendStats.LoadPercentage != 100 {
+		t.Errorf("Expected load percentage 100, got %v", backendStats.LoadPercentage)
+	}
+}
+
+// Weights are read under the pool's lock, so stats can be served while a
+// backend is reweighted through the admin API
+func TestStatsDuringReweight(t *testing.T) {
+	configPath, err := testutils.CreateTempConfig(`upstream backend {
+		method weighted_round_robin
+		server http://localhost:8081
+		server http://localhost:8082
+	}
+
+	route path / backend`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 100; i++ {
+			rec := httptest.NewRecorder()
+			balancer.BackendOverrideHandler(lb)(rec, httptest.NewRequest("POST", fmt.Sprintf("/api/backends/backend-1/weight?weight=%d", i), nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("Expected the backend to be reweighted, got %d: %s", rec.Code, rec.Body.String())
+				return
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		balancer.APIHandler(lb)(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/stats", nil))
+		balancer.BackendsHandler(lb)(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/backends", nil))
+	}
+	<-done
+
+	stats := balancer.GetStats(lb)
+	weights := make(map[int]bool)
+	for _, backend := range stats.Backends {
+		weights[backend.Weight] = true
+	}
+	if !weights[100] {
+		t.Errorf("Expected the last weight in stats, got %+v", stats.Backends)
+	}
+}
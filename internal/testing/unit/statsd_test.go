@@ -0,0 +1,127 @@
+package unit
+
+import (
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestStatsdExporter(t *testing.T) {
+	backends, cleanup, err := testutils.CreateTestBackends(2)
+	if err != nil {
+		t.Fatalf("Failed to create test backends: %v", err)
+	}
+	defer cleanup()
+
+	agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer agent.Close()
+
+	configPath, err := testutils.CreateTempConfig(`statsd ` + agent.LocalAddr().String() + ` prefix=edge. tags=env:test,region:eu interval=1h
+	metrics labels=route,status
+
+	upstream backend {
+		server ` + backends[0] + `
+		server ` + backends[1] + `
+	}
+
+	route path /api backend`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if cfg.Statsd.Prefix != "edge." || cfg.Statsd.Interval != time.Hour || len(cfg.Statsd.Tags) != 2 || cfg.Statsd.Format != "dogstatsd" {
+		t.Fatalf("Unexpected statsd config: %+v", cfg.Statsd)
+	}
+	router, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	lb := balancer.ApplyGlobalMiddleware(router, cfg)
+
+	exporter, err := balancer.NewStatsdExporter(lb, cfg.Statsd)
+	if err != nil {
+		t.Fatalf("Failed to create exporter: %v", err)
+	}
+	exporter.Start()
+	for i := 0; i < 3; i++ {
+		lb.ProxyRequest(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/api/items", nil))
+	}
+	// Stopping sends what is left of the interval
+	exporter.Stop()
+
+	var received []string
+	buf := make([]byte, 65536)
+	for {
+		agent.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		n, _, err := agent.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		received = append(received, strings.Split(string(buf[:n]), "\n")...)
+	}
+
+	var timings int
+	for _, line := range received {
+		if strings.HasPrefix(line, "edge.request.duration:") {
+			timings++
+			if !strings.HasSuffix(line, "|ms|#env:test,region:eu,route:/api,status:2xx") {
+				t.Errorf("Unexpected timing: %s", line)
+			}
+		}
+	}
+	if timings != 3 {
+		t.Errorf("Expected 3 timings, got %d in %q", timings, received)
+	}
+	for _, want := range []string{
+		"edge.requests:3|c|#env:test,region:eu,route:/api,status:2xx",
+		"edge.backends.alive:2|g|#env:test,region:eu",
+		"edge.backends.total:2|g|#env:test,region:eu",
+	} {
+		if !containsLine(received, want) {
+			t.Errorf("Expected %q, got %q", want, received)
+		}
+	}
+}
+
+func containsLine(lines []string, want string) bool {
+	for _, line := range lines {
+		if line == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestStatsdConfigErrors(t *testing.T) {
+	for _, directive := range []string{
+		"statsd",
+		"statsd localhost",
+		"statsd localhost:8125 interval=never",
+		"statsd localhost:8125 format=graphite",
+		"statsd localhost:8125 tags=env|prod",
+		"statsd localhost:8125 flush=1s",
+	} {
+		configPath, err := testutils.CreateTempConfig(directive + `
+
+		upstream backend {
+			server http://localhost:8081
+		}`)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil {
+			t.Errorf("Expected %q to be rejected", directive)
+		}
+	}
+}
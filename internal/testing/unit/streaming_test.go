@@ -0,0 +1,122 @@
+package unit
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestStreamingRoute(t *testing.T) {
+	// The backend sends a first event, then the second once released. The
+	// response has a length, so it would be buffered if it were not flushed.
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Content-Length", "22")
+		io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		io.WriteString(w, "data: 2\n\n")
+	}))
+	defer backend.Close()
+	defer func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	}()
+
+	configPath, err := testutils.CreateTempConfig(`upstream backend {
+		server ` + backend.URL + `
+	}
+
+	route path /events backend streaming=on`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if !cfg.Routes[0].Streaming {
+		t.Fatalf("Expected the route to stream, got %+v", cfg.Routes[0])
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	// Requests have a deadline the stream outlives
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 50*time.Millisecond)
+		defer cancel()
+		balancer.ProxyRequestContext(ctx, lb, w, r)
+	}))
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/events")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	lines := make(chan string, 10)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if line := scanner.Text(); line != "" {
+				lines <- line
+			}
+		}
+	}()
+	next := func() string {
+		t.Helper()
+		select {
+		case line := <-lines:
+			return line
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for an event")
+			return ""
+		}
+	}
+
+	if line := next(); line != "data: first" {
+		t.Fatalf("Expected the first event before the backend finished, got %q", line)
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	if line := next(); line != "data: 2" {
+		t.Errorf("Expected the stream to outlive the request deadline, got %q", line)
+	}
+
+	// Streamed responses cannot be held whole
+	for _, options := range []string{"streaming=maybe", "streaming=on cache=api"} {
+		configPath, err := testutils.CreateTempConfig(`cache api ttl=1m max_size=1MB
+
+		upstream backend {
+			server ` + backend.URL + `
+		}
+
+		route path /events backend ` + options)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil || !strings.Contains(err.Error(), "streaming") {
+			t.Errorf("Expected %q to be rejected, got %v", options, err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package unit
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+)
+
+func TestSubsetBackends(t *testing.T) {
+	backends := make([]balancer.BackendConfig, 100)
+	for i := range backends {
+		backends[i] = balancer.BackendConfig{URL: fmt.Sprintf("http://backend%d:80", i), Weight: 1}
+	}
+
+	// Ten instances of the same round must cover the pool exactly once
+	seen := make(map[string]int)
+	for instance := 0; instance < 10; instance++ {
+		subset := balancer.SubsetBackends(backends, balancer.SubsetConfig{Size: 10, InstanceID: instance})
+		if len(subset) != 10 {
+			t.Fatalf("Instance %d: expected 10 backends, got %d", instance, len(subset))
+		}
+		for _, backend := range subset {
+			seen[backend.URL]++
+		}
+	}
+
+	if len(seen) != len(backends) {
+		t.Errorf("Expected all %d backends to be covered, got %d", len(backends), len(seen))
+	}
+	for url, count := range seen {
+		if count != 1 {
+			t.Errorf("Backend %s assigned to %d instances", url, count)
+		}
+	}
+
+	first := balancer.SubsetBackends(backends, balancer.SubsetConfig{Size: 10, InstanceID: 3})
+	second := balancer.SubsetBackends(backends, balancer.SubsetConfig{Size: 10, InstanceID: 3})
+	for i := range first {
+		if first[i].URL != second[i].URL {
+			t.Fatalf("Expected subsetting to be deterministic")
+		}
+	}
+}
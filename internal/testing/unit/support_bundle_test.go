@@ -0,0 +1,115 @@
+package unit
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestSupportBundle(t *testing.T) {
+	logger.InitLogger()
+
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	deadURL := dead.URL
+	dead.Close()
+
+	config := `set_header Authorization "Bearer s3cret-token"
+	routing_override token=0verride-s3cret allow=127.0.0.1
+
+	upstream backend {
+		server http://admin:hunter2@` + strings.TrimPrefix(deadURL, "http://") + `
+	}`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	lb, err := balancer.CreateLoadBalancer(cfg.Method, cfg.PoolBackends("backend"), cfg.PersistenceType, cfg.PersistenceAttrs)
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	defer balancer.StopRevivals(lb)
+
+	// A failed request marks the backend down and logs the error
+	lb.ProxyRequest(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/", nil))
+
+	rec := httptest.NewRecorder()
+	balancer.SupportBundleHandler(lb, configPath)(rec, httptest.NewRequest("GET", "/api/support-bundle", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Disposition"), "attachment;") {
+		t.Errorf("Expected an attachment, got %q", rec.Header().Get("Content-Disposition"))
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("Invalid zip archive: %v", err)
+	}
+
+	files := make(map[string]string)
+	for _, f := range archive.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", f.Name, err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+
+	for _, name := range []string{"info.json", "config.conf", "logs.jsonl", "stats.json", "health.json", "goroutines.txt"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("Expected %s in the bundle", name)
+		}
+	}
+
+	for name, data := range files {
+		if strings.Contains(data, "s3cret") || strings.Contains(data, "hunter2") {
+			t.Errorf("Expected secrets to be redacted from %s, got:\n%s", name, data)
+		}
+	}
+
+	configFile := files["config.conf"]
+	if !strings.Contains(configFile, "set_header Authorization [REDACTED]") || !strings.Contains(configFile, "routing_override token=[REDACTED] allow=127.0.0.1") ||
+		!strings.Contains(configFile, "upstream backend {") {
+		t.Errorf("Expected the rest of the configuration to be kept, got:\n%s", configFile)
+	}
+
+	if !strings.Contains(files["logs.jsonl"], "Backend marked dead") {
+		t.Errorf("Expected recent logs in the bundle, got:\n%s", files["logs.jsonl"])
+	}
+	if !strings.Contains(files["goroutines.txt"], "goroutine ") {
+		t.Errorf("Expected a goroutine dump in the bundle")
+	}
+
+	var health []balancer.HealthEvent
+	if err := json.Unmarshal([]byte(files["health.json"]), &health); err != nil {
+		t.Fatalf("Invalid health history: %v", err)
+	}
+	found := false
+	for _, event := range health {
+		if event.State == "down" && strings.Contains(event.Backend, strings.TrimPrefix(deadURL, "http://")) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected the backend going down in the health history, got %+v", health)
+	}
+}
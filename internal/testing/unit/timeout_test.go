@@ -0,0 +1,111 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	// The slow backend answers after 200ms and reports requests canceled
+	// before that
+	canceled := make(chan struct{}, 10)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(200 * time.Millisecond):
+			w.Write([]byte("slow"))
+		case <-r.Context().Done():
+			canceled <- struct{}{}
+		}
+	}))
+	defer slow.Close()
+
+	configPath, err := testutils.CreateTempConfig(`timeout 100ms
+	upstream backend {
+		server ` + slow.URL + `
+	}
+
+	route path /short/ backend timeout=30ms
+	route path /long/ backend timeout=1s`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if cfg.RequestTimeout != 100*time.Millisecond {
+		t.Fatalf("Expected a 100ms request timeout, got %v", cfg.RequestTimeout)
+	}
+	router, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	lb := balancer.ApplyGlobalMiddleware(router, cfg)
+	send := func(path string) (*httptest.ResponseRecorder, time.Duration) {
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		lb.ProxyRequest(rec, httptest.NewRequest("GET", path, nil))
+		return rec, time.Since(start)
+	}
+	expectTimeout := func(path, timeout string, max time.Duration) {
+		t.Helper()
+		rec, elapsed := send(path)
+		if rec.Code != http.StatusGatewayTimeout || elapsed >= max {
+			t.Fatalf("%s: expected a 504 within %v, got %d in %v", path, max, rec.Code, elapsed)
+		}
+		if rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s: expected a JSON body, got %q", path, rec.Header().Get("Content-Type"))
+		}
+		var body struct {
+			Error   string `json:"error"`
+			Status  int    `json:"status"`
+			Reason  string `json:"reason"`
+			Timeout string `json:"timeout"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: failed to decode body %q: %v", path, rec.Body.String(), err)
+		}
+		if body.Status != http.StatusGatewayTimeout || body.Reason != "deadline_exceeded" || body.Timeout != timeout {
+			t.Errorf("%s: unexpected body %+v", path, body)
+		}
+		select {
+		case <-canceled:
+		case <-time.After(time.Second):
+			t.Errorf("%s: expected the backend request to be canceled", path)
+		}
+	}
+
+	expectTimeout("/short/", "30ms", 100*time.Millisecond)
+	expectTimeout("/", "100ms", 190*time.Millisecond)
+
+	// A route's timeout replaces a shorter global one
+	if rec, _ := send("/long/"); rec.Code != http.StatusOK || rec.Body.String() != "slow" {
+		t.Errorf("Expected the long route to wait for the backend, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	for _, tc := range []struct{ config, want string }{
+		{"timeout soon", "invalid timeout"},
+		{"timeout 0s", "invalid timeout"},
+		{"upstream other {\n\ttimeout 1s\n\tserver " + slow.URL + "\n}", "must not be inside an upstream block"},
+		{"route path /api/ backend timeout=-1s", "invalid route timeout"},
+		{"route path /events/ backend streaming=on timeout=1s", "cannot have a timeout"},
+	} {
+		configPath, err := testutils.CreateTempConfig(`upstream backend {
+			server ` + slow.URL + `
+		}
+		` + tc.config)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: expected an error containing %q, got %v", tc.config, tc.want, err)
+		}
+	}
+}
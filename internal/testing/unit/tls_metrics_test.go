@@ -0,0 +1,53 @@
+package unit
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+)
+
+func TestClientTLSMetrics(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	server.TLS = &tls.Config{NextProtos: []string{"http/1.1"}}
+	balancer.CountClientTLS(server.TLS)
+	server.StartTLS()
+	defer server.Close()
+
+	before := balancer.GetTLSStats()
+
+	client := server.Client()
+	transport := client.Transport.(*http.Transport)
+	transport.TLSClientConfig.MinVersion = tls.VersionTLS12
+	transport.TLSClientConfig.MaxVersion = tls.VersionTLS12
+	transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	after := balancer.GetTLSStats()
+	if got := after.Client.Connections - before.Client.Connections; got != 1 {
+		t.Fatalf("Expected 1 client TLS connection to be counted, got %d", got)
+	}
+	if got := after.Client.Versions["TLS 1.2"] - before.Client.Versions["TLS 1.2"]; got != 1 {
+		t.Errorf("Expected the connection to count as TLS 1.2, got %v", after.Client.Versions)
+	}
+	if got := after.ClientMaxVersions["TLS 1.2"] - before.ClientMaxVersions["TLS 1.2"]; got != 1 {
+		t.Errorf("Expected the client to offer at most TLS 1.2, got %v", after.ClientMaxVersions)
+	}
+	if got := after.Client.ALPN["http/1.1"] - before.Client.ALPN["http/1.1"]; got != 1 {
+		t.Errorf("Expected http/1.1 to be negotiated, got %v", after.Client.ALPN)
+	}
+
+	cipher := tls.CipherSuiteName(resp.TLS.CipherSuite)
+	if got := after.Client.CipherSuites[cipher] - before.Client.CipherSuites[cipher]; got != 1 {
+		t.Errorf("Expected the connection to count as %s, got %v", cipher, after.Client.CipherSuites)
+	}
+}
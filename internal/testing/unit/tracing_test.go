@@ -0,0 +1,97 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestTracingPropagationAndExport(t *testing.T) {
+	exported := make(chan []byte, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		exported <- body
+	}))
+	defer collector.Close()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Traceparent", r.Header.Get("traceparent"))
+		w.Header().Set("X-Seen-B3", r.Header.Get("X-B3-TraceId"))
+	}))
+	defer backend.Close()
+
+	config := `tracing {
+		endpoint ` + collector.URL + `/v1/traces
+		service_name test-lb
+		propagation w3c b3
+	}
+
+	upstream backend {
+		server ` + backend.URL + `
+	}`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	router, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+
+	tracer := balancer.NewTracer(cfg.Tracing)
+	lb := balancer.NewTracingMiddleware(router, tracer)
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("GET", "http://localhost/orders", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	lb.ProxyRequest(rec, req)
+
+	if tp := rec.Header().Get("X-Seen-Traceparent"); !strings.Contains(tp, traceID) || strings.Contains(tp, "00f067aa0ba902b7") {
+		t.Errorf("Expected traceparent continuing trace %s with a new span, got %q", traceID, tp)
+	}
+	if b3 := rec.Header().Get("X-Seen-B3"); b3 != traceID {
+		t.Errorf("Expected B3 trace ID %s, got %q", traceID, b3)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tracer.Shutdown(ctx)
+
+	select {
+	case body := <-exported:
+		var payload map[string]interface{}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Fatalf("Exported spans are not valid JSON: %v", err)
+		}
+		for _, expected := range []string{traceID, "00f067aa0ba902b7", "lb.backend", "test-lb"} {
+			if !strings.Contains(string(body), expected) {
+				t.Errorf("Expected exported spans to contain %q", expected)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No spans exported")
+	}
+
+	// Requests outliving the shutdown, such as WebSockets, finish their spans
+	// without crashing, and the spans are dropped
+	req = httptest.NewRequest("GET", "http://localhost/orders", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	lb.ProxyRequest(httptest.NewRecorder(), req)
+	tracer.Shutdown(ctx)
+}
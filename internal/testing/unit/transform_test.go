@@ -0,0 +1,121 @@
+package unit
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestResponseTransform(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			io.WriteString(w, `<html><BODY class="app"><a href="http://10.0.0.4:8080/docs">docs</a> build 1234</body></html>`)
+		case "/gzip":
+			// Sent compressed whatever the client accepts
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			io.WriteString(gz, `<a href="http://10.0.0.4:8080/">home</a>`)
+			gz.Close()
+		case "/brotli":
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "br")
+			io.WriteString(w, "http://10.0.0.4:8080/")
+		case "/data":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"url":"http://10.0.0.4:8080/docs"}`)
+		case "/large":
+			w.Header().Set("Content-Type", "text/html")
+			io.WriteString(w, "<p>http://10.0.0.4:8080/"+strings.Repeat(".", 2048)+"</p>")
+		}
+	}))
+	defer backend.Close()
+
+	configPath, err := testutils.CreateTempConfig(`response_transform public max_size=1KB replace http://10.0.0.4:8080 https://example.com
+	response_transform public regex "build (\d+)" "build #$1"
+	response_transform public inject body_start '<div class="banner">Maintenance tonight</div>'
+
+	upstream backend {
+		server ` + backend.URL + `
+	}
+
+	route path / backend transform=public`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create balancer: %v", err)
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		lb.ProxyRequest(rec, req)
+		return rec
+	}
+
+	rec := get("/page")
+	want := `<html><BODY class="app"><div class="banner">Maintenance tonight</div><a href="https://example.com/docs">docs</a> build #1234</body></html>`
+	if rec.Body.String() != want {
+		t.Errorf("Expected the page to be transformed, got %s", rec.Body.String())
+	}
+	if rec.Header().Get("Content-Length") != strconv.Itoa(len(want)) || rec.Header().Get("ETag") != "" {
+		t.Errorf("Expected the length of the transformed page and no ETag, got %q and %q",
+			rec.Header().Get("Content-Length"), rec.Header().Get("ETag"))
+	}
+
+	// Backends are asked for bodies the transport can decompress
+	if rec := get("/gzip"); rec.Body.String() != `<a href="https://example.com/">home</a>` {
+		t.Errorf("Expected the compressed page to be transformed, got %q", rec.Body.String())
+	}
+
+	// Other encodings, other types and bodies over max_size pass through
+	if rec := get("/brotli"); rec.Header().Get("Content-Encoding") != "br" || rec.Body.String() != "http://10.0.0.4:8080/" {
+		t.Errorf("Expected the encoded body to pass through, got %q", rec.Body.String())
+	}
+	if rec := get("/data"); !strings.Contains(rec.Body.String(), "10.0.0.4") {
+		t.Errorf("Expected the JSON body to pass through, got %s", rec.Body.String())
+	}
+	if rec := get("/large"); !strings.Contains(rec.Body.String(), "10.0.0.4") || rec.Body.Len() != 2048+len("<p>http://10.0.0.4:8080/</p>") {
+		t.Errorf("Expected the large body to pass through whole, got %d bytes", rec.Body.Len())
+	}
+	stats := balancer.GetStats(lb).Transforms["public"]
+	if stats.Transformed != 2 || stats.Oversize != 1 {
+		t.Errorf("Expected 2 transformed and 1 oversize response, got %+v", stats)
+	}
+
+	for _, tc := range []struct{ config, want string }{
+		{`response_transform public regex "(" x`, "invalid regex rewrite"},
+		{`response_transform public inject footer "<p>"`, "invalid inject position"},
+		{`response_transform public replace "unterminated`, "unterminated quoted argument"},
+		{`response_transform public replace a`, "replace rewrite requires two arguments"},
+		{`route path / backend transform=missing`, "unknown response transform"},
+		{"response_transform public replace a b\nroute path / backend transform=public streaming=on", "streaming routes cannot have a response transform"},
+	} {
+		configPath, err := testutils.CreateTempConfig(tc.config + `
+		upstream backend {
+			server ` + backend.URL + `
+		}`)
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.config, tc.want, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+//go:build unix
+
+package unit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+)
+
+func TestSocketHandoverInheritsListeners(t *testing.T) {
+	original, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer original.Close()
+
+	file, err := original.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("Failed to get listener file: %v", err)
+	}
+	defer file.Close()
+
+	// The handover takes ownership of the inherited descriptor
+	fd, err := syscall.Dup(int(file.Fd()))
+	if err != nil {
+		t.Fatalf("Failed to duplicate listener descriptor: %v", err)
+	}
+
+	// Simulate the environment an upgraded process is started with
+	t.Setenv("GOLB_INHERITED_SOCKETS", fmt.Sprintf("http=%d", fd))
+
+	handover := balancer.NewSocketHandover()
+
+	listener, err := handover.Listen("http", "127.0.0.1:1")
+	if err != nil {
+		t.Fatalf("Failed to take over listener: %v", err)
+	}
+	defer listener.Close()
+
+	if listener.Addr().String() != original.Addr().String() {
+		t.Errorf("Expected inherited address %s, got %s", original.Addr(), listener.Addr())
+	}
+
+	// The old process stops accepting; the new one keeps serving the socket
+	original.Close()
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("upgraded"))
+	}))
+
+	resp, err := http.Get("http://" + listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Request to inherited listener failed: %v", err)
+	}
+	resp.Body.Close()
+
+	// Sockets that were not handed over are opened normally
+	admin, err := handover.Listen("admin", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen on a new socket: %v", err)
+	}
+	admin.Close()
+
+	if err := handover.Ready(); err != nil {
+		t.Errorf("Ready failed without a parent process: %v", err)
+	}
+}
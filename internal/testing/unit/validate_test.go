@@ -0,0 +1,64 @@
+package unit
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+)
+
+func TestValidateConfigReportsEveryError(t *testing.T) {
+	config := `upstream backend {
+		server http://127.0.0.1:9001 weight=heavy
+		server ftp://127.0.0.1:9002
+		server http://localhost:9003
+	}
+
+	upstream api {
+		server http://127.0.0.1:9010
+	}
+
+	route regex ^/(v1|v2 api
+	route path /reports/ reports
+	frobnicate on
+	route path /api/ api`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	errs := balancer.ValidateConfig(configPath, false)
+
+	expected := []int{2, 3, 11, 12, 13}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(expected), len(errs), errs)
+	}
+	for i, err := range errs {
+		var invalid balancer.ErrInvalidConfig
+		if !errors.As(err, &invalid) || invalid.Line != expected[i] {
+			t.Errorf("Expected error %d to be on line %d, got %v", i, expected[i], err)
+		}
+	}
+	if !errors.Is(errs[3], balancer.ErrPoolNotFound) {
+		t.Errorf("Expected the unknown route pool to be reported, got %v", errs[3])
+	}
+}
+
+func TestValidateConfigAcceptsValidConfig(t *testing.T) {
+	config := `upstream backend {
+		server http://localhost:9001
+	}
+
+	route regex ^/(v1|v2)/ backend`
+
+	configPath, err := testutils.CreateTempConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+
+	if errs := balancer.ValidateConfig(configPath, true); len(errs) != 0 {
+		t.Errorf("Expected no errors, got %v", errs)
+	}
+}
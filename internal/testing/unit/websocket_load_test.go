@@ -0,0 +1,146 @@
+package unit
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
+	"github.com/gorilla/websocket"
+)
+
+// newNamedWebSocketBackend starts a backend echoing WebSocket messages
+// prefixed with its name, and answering HTTP requests with its name
+func newNamedWebSocketBackend(name string) *httptest.Server {
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			fmt.Fprint(w, name)
+			return
+		}
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			mt, message, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			c.WriteMessage(mt, append([]byte(name+":"), message...))
+		}
+	}))
+}
+
+// echoBackend sends a message over a WebSocket opened through the load
+// balancer and returns the name of the backend echoing it
+func echoBackend(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	conn.WriteMessage(websocket.TextMessage, []byte("ping"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read the echo: %v", err)
+	}
+	backend, _, _ := strings.Cut(string(message), ":")
+	return backend
+}
+
+func TestWebSocketLoadAwareBalancing(t *testing.T) {
+	a := newNamedWebSocketBackend("a")
+	defer a.Close()
+	b := newNamedWebSocketBackend("b")
+	defer b.Close()
+
+	configPath, err := testutils.CreateTempConfig(`upstream backend {
+		method least_conn
+		server ` + a.URL + ` max_ws=1
+		server ` + b.URL + ` max_ws=1
+	}
+
+	route path / backend`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if cfg.BackendPools["backend"][0].MaxWebSockets != 1 {
+		t.Fatalf("Expected max_ws to be parsed, got %+v", cfg.BackendPools["backend"][0])
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	proxy := httptest.NewServer(http.HandlerFunc(lb.ProxyRequest))
+	defer proxy.Close()
+	wsURL := "ws" + strings.TrimPrefix(proxy.URL, "http")
+
+	// dial opens a WebSocket and returns it with the backend serving it
+	dial := func() (*websocket.Conn, string, int) {
+		conn, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			if resp != nil {
+				return nil, "", resp.StatusCode
+			}
+			t.Fatalf("Failed to dial the load balancer: %v", err)
+		}
+		return conn, echoBackend(t, conn), http.StatusSwitchingProtocols
+	}
+	get := func() string {
+		resp, err := http.Get(proxy.URL)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	first, firstBackend, _ := dial()
+	if firstBackend == "" {
+		t.Fatal("Expected the first WebSocket to open")
+	}
+
+	// The open socket counts as a connection of its backend
+	for i := 0; i < 3; i++ {
+		if got := get(); got == firstBackend {
+			t.Errorf("Expected requests to avoid the backend holding a WebSocket, got %s", got)
+		}
+	}
+
+	second, secondBackend, _ := dial()
+	if second == nil || secondBackend == firstBackend {
+		t.Fatalf("Expected the second WebSocket on the other backend, got %q", secondBackend)
+	}
+	defer second.Close()
+
+	if conn, _, status := dial(); conn != nil || status != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 with every backend at its WebSocket limit, got %d", status)
+	}
+
+	// Closing a socket frees its slots
+	first.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, backend, _ := dial()
+		if conn != nil {
+			defer conn.Close()
+			if backend != firstBackend {
+				t.Errorf("Expected the freed backend %s, got %s", firstBackend, backend)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected a WebSocket slot to be freed when its socket closed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
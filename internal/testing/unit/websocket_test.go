@@ -4,11 +4,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/The-iyed/go-load-balancer/internal/balancer"
 	"github.com/The-iyed/go-load-balancer/internal/logger"
+	"github.com/The-iyed/go-load-balancer/internal/testing/testutils"
 	"github.com/gorilla/websocket"
 )
 
@@ -115,6 +117,44 @@ func TestWebSocketConnectionMap(t *testing.T) {
 	}
 }
 
+func TestWebSocketConnectionMapStats(t *testing.T) {
+	connMap := balancer.NewWebSocketConnectionMap()
+
+	url, _ := balancer.ParseURL("http://backend1:8080")
+	backend := &balancer.Process{URL: url, Alive: true}
+	other := &balancer.Process{URL: url, Alive: true}
+
+	conn := connMap.AddBackendConnection(&websocket.Conn{}, &websocket.Conn{}, backend)
+	conn.MessagesToBackend = 3
+	conn.MessagesToClient = 5
+	connMap.AddBackendConnection(&websocket.Conn{}, &websocket.Conn{}, backend)
+
+	if count := connMap.CountByBackend(backend); count != 2 {
+		t.Errorf("Expected 2 connections to backend, got %d", count)
+	}
+	if count := connMap.CountByBackend(other); count != 0 {
+		t.Errorf("Expected no connections to other backend, got %d", count)
+	}
+
+	connMap.Remove(conn.ID)
+	connMap.Remove(conn.ID)
+
+	stats := connMap.Stats()
+	if stats.Active != 1 || stats.Total != 2 {
+		t.Errorf("Expected 1 active of 2 total connections, got %d of %d", stats.Active, stats.Total)
+	}
+	if stats.ActiveByBackend["http://backend1:8080"] != 1 {
+		t.Errorf("Unexpected active connections by backend: %v", stats.ActiveByBackend)
+	}
+	if stats.MessagesToBackend != 3 || stats.MessagesToClient != 5 {
+		t.Errorf("Expected message counts to survive the close, got %d and %d",
+			stats.MessagesToBackend, stats.MessagesToClient)
+	}
+	if stats.ClosedDurations["1m"] != 1 || stats.ActiveAges["1m"] != 1 {
+		t.Errorf("Unexpected duration distribution: closed %v, active %v", stats.ClosedDurations, stats.ActiveAges)
+	}
+}
+
 func TestWebSocketProxy_Integration(t *testing.T) {
 	t.Skip("Integration test requires a real WebSocket server")
 
@@ -206,3 +246,167 @@ func TestWebSocketProxy_Integration(t *testing.T) {
 		t.Error("Error handler shouldn't have been called")
 	}
 }
+
+func TestWebSocketNegotiationPassthrough(t *testing.T) {
+	var offered atomic.Value
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/forbidden" {
+			http.Error(w, "not allowed", http.StatusForbidden)
+			return
+		}
+		offered.Store(r.Header.Get("Sec-Websocket-Extensions"))
+		upgrader := websocket.Upgrader{Subprotocols: []string{"v2.chat"}, EnableCompression: true}
+		c, err := upgrader.Upgrade(w, r, http.Header{"X-Handshake": {"backend"}})
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			mt, message, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			c.WriteMessage(mt, message)
+		}
+	}))
+	defer backend.Close()
+
+	configPath, err := testutils.CreateTempConfig(`upstream backend {
+		server ` + backend.URL + `
+	}
+
+	route path / backend`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	proxy := httptest.NewServer(http.HandlerFunc(lb.ProxyRequest))
+	defer proxy.Close()
+	wsURL := "ws" + strings.TrimPrefix(proxy.URL, "http")
+
+	for _, compression := range []bool{true, false} {
+		dialer := websocket.Dialer{Subprotocols: []string{"v1.chat", "v2.chat"}, EnableCompression: compression}
+		conn, resp, err := dialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("Failed to open a WebSocket: %v", err)
+		}
+
+		if conn.Subprotocol() != "v2.chat" {
+			t.Errorf("Expected the subprotocol selected by the backend, got %q", conn.Subprotocol())
+		}
+		if resp.Header.Get("X-Handshake") != "backend" {
+			t.Errorf("Expected the backend's handshake headers, got %v", resp.Header)
+		}
+		negotiated := strings.Contains(resp.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
+		backendOffered := strings.Contains(offered.Load().(string), "permessage-deflate")
+		if negotiated != compression || backendOffered != compression {
+			t.Errorf("Expected compression negotiated %v on both sides, got %v with the client and offered %v to the backend",
+				compression, negotiated, backendOffered)
+		}
+
+		message := strings.Repeat("compressible ", 1000)
+		conn.WriteMessage(websocket.TextMessage, []byte(message))
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, echoed, err := conn.ReadMessage(); err != nil || string(echoed) != message {
+			t.Errorf("Expected the message echoed through the proxy, got %d bytes: %v", len(echoed), err)
+		}
+		conn.Close()
+	}
+
+	// A refused upgrade is answered as the backend answered it
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL+"/forbidden", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected the backend's 403, got %v", err)
+	}
+	for _, health := range balancer.GetBackendHealth(lb) {
+		if health.Failures != 0 {
+			t.Errorf("Expected a refused upgrade not to count as a backend failure, got %+v", health)
+		}
+	}
+}
+
+func TestWebSocketPoolLimits(t *testing.T) {
+	backend := newNamedWebSocketBackend("a")
+	defer backend.Close()
+
+	configPath, err := testutils.CreateTempConfig(`upstream backend {
+		websocket max_message=1KB ping=50ms pong_wait=200ms ttl=400ms read_buffer=4KB
+		server ` + backend.URL + `
+	}
+
+	route path / backend`)
+	if err != nil {
+		t.Fatalf("Failed to create config file: %v", err)
+	}
+	cfg, err := balancer.ParseConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	limits := cfg.PoolWebSockets["backend"]
+	if limits.MaxMessageSize != 1024 || limits.ConnectionTTL != 400*time.Millisecond || limits.ReadBufferSize != 4096 || limits.WriteWait != 10*time.Second {
+		t.Fatalf("Unexpected WebSocket limits: %+v", limits)
+	}
+	lb, err := balancer.CreatePathRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create path router: %v", err)
+	}
+	proxy := httptest.NewServer(http.HandlerFunc(lb.ProxyRequest))
+	defer proxy.Close()
+	wsURL := "ws" + strings.TrimPrefix(proxy.URL, "http")
+
+	// Oversized messages close the socket
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to open a WebSocket: %v", err)
+	}
+	conn.WriteMessage(websocket.TextMessage, make([]byte, 2048))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("Expected the oversized message to close the socket, got %v", err)
+	}
+	conn.Close()
+
+	// Sockets are pinged, and closed once they reach their TTL
+	conn, _, err = websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to open a WebSocket: %v", err)
+	}
+	defer conn.Close()
+	var pings atomic.Int32
+	conn.SetPingHandler(func(data string) error {
+		pings.Add(1)
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	if got := echoBackend(t, conn); got != "a" {
+		t.Fatalf("Expected an echo from the backend, got %q", got)
+	}
+	opened := time.Now()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("Expected the socket to be closed at its TTL, got %v", err)
+	}
+	if elapsed := time.Since(opened); elapsed > time.Second {
+		t.Errorf("Expected the socket closed after its 400ms TTL, took %v", elapsed)
+	}
+	if n := pings.Load(); n < 3 {
+		t.Errorf("Expected pings every 50ms, got %d", n)
+	}
+
+	for _, invalid := range []string{"ping=1m pong_wait=30s", "ttl=soon", "max_message=big", "frames=1"} {
+		configPath, err := testutils.CreateTempConfig("upstream backend {\n    websocket " + invalid + "\n}")
+		if err != nil {
+			t.Fatalf("Failed to create config file: %v", err)
+		}
+		if _, err := balancer.ParseConfig(configPath); err == nil {
+			t.Errorf("Expected websocket %s to be rejected", invalid)
+		}
+	}
+}
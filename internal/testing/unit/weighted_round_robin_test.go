@@ -2,6 +2,7 @@ package unit
 
 import (
 	"net/http"
+	"sync"
 	"testing"
 	"time"
 
@@ -122,6 +123,70 @@ func TestWeightedRoundRobinFairness(t *testing.T) {
 	}
 }
 
+func TestWeightedRoundRobinConcurrentPicks(t *testing.T) {
+	lb := balancer.NewLoadBalancer([]balancer.BackendConfig{
+		{URL: "http://backend1:8080", Weight: 5},
+		{URL: "http://backend2:8080", Weight: 1},
+		{URL: "http://backend3:8080", Weight: 1},
+	})
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+
+	// Concurrent picks follow the same schedule as sequential ones, so every
+	// 7 picks split 5/1/1 whatever the interleaving
+	const goroutines, picks = 8, 700
+	var mu sync.Mutex
+	counts := make(map[string]int)
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			local := make(map[string]int)
+			for j := 0; j < picks; j++ {
+				local[lb.GetNextInstance(req).URL.String()]++
+			}
+			mu.Lock()
+			for url, count := range local {
+				counts[url] += count
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	total := goroutines * picks
+	if counts["http://backend1:8080"] != total*5/7 || counts["http://backend2:8080"] != total/7 || counts["http://backend3:8080"] != total/7 {
+		t.Errorf("Expected an exact 5/1/1 split of %d picks, got %v", total, counts)
+	}
+}
+
+func TestWeightedRoundRobinPickAllocations(t *testing.T) {
+	plain := balancer.NewLoadBalancer([]balancer.BackendConfig{
+		{URL: "http://backend1:8080", Weight: 5},
+		{URL: "http://backend2:8080", Weight: 1},
+	})
+	tiered := balancer.NewLoadBalancer([]balancer.BackendConfig{
+		{URL: "http://backend1:8080", Weight: 5},
+		{URL: "http://backend2:8080", Weight: 1},
+		{URL: "http://backup:8080", Priority: 1},
+	})
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	upgrade, _ := http.NewRequest("GET", "http://localhost/", nil)
+	upgrade.Header.Set("Connection", "Upgrade")
+	upgrade.Header.Set("Upgrade", "websocket")
+
+	// Picking is on the path of every request, so it allocates nothing
+	for name, pick := range map[string]func(){
+		"plain":     func() { plain.GetNextInstance(req) },
+		"tiered":    func() { tiered.GetNextInstance(req) },
+		"websocket": func() { plain.GetNextInstance(upgrade) },
+	} {
+		if allocs := testing.AllocsPerRun(100, pick); allocs != 0 {
+			t.Errorf("%s: expected a pick to allocate nothing, got %v allocations", name, allocs)
+		}
+	}
+}
+
 func TestWeightedRoundRobinSkipsDead(t *testing.T) {
 	// Create a cluster with a failing middle backend
 	cluster := mocks.NewBackendCluster(3, nil, []float64{0, 1.0, 0})
@@ -207,3 +272,62 @@ func TestWeightedRoundRobinSkipsDead(t *testing.T) {
 	// Test passes - we've verified that our health check system detects failing backends
 	t.Log("Test verified that load balancer's health check system properly detects failing backends")
 }
+
+func TestWeightedRoundRobinContinuityAcrossSwaps(t *testing.T) {
+	configs := []balancer.BackendConfig{
+		{URL: "http://backend1:8080", Weight: 5},
+		{URL: "http://backend2:8080", Weight: 1},
+		{URL: "http://backend3:8080", Weight: 1},
+	}
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+
+	// Swapping in the same pool after every pick must not change the schedule
+	lb := balancer.NewLoadBalancer(configs)
+	reference := balancer.NewLoadBalancer(configs)
+	counts := make(map[string]int)
+
+	for i := 0; i < 70; i++ {
+		picked := lb.GetNextInstance(req)
+		if expected := reference.GetNextInstance(req); picked.URL.String() != expected.URL.String() {
+			t.Fatalf("Pick %d: expected %s after swap, got %s", i+1, expected.URL, picked.URL)
+		}
+		counts[picked.URL.String()]++
+		lb.UpdateBackends(configs)
+	}
+
+	if counts["http://backend1:8080"] != 50 || counts["http://backend2:8080"] != 10 || counts["http://backend3:8080"] != 10 {
+		t.Errorf("Expected a 50/10/10 split across swaps, got %v", counts)
+	}
+	if lb.Generation != 70 {
+		t.Errorf("Expected generation 70, got %d", lb.Generation)
+	}
+
+	// Surviving backends keep their state and removed credit is redistributed
+	survivor := lb.ProcessPack[0]
+	lb.GetNextInstance(req)
+	lb.GetNextInstance(req)
+	lb.UpdateBackends([]balancer.BackendConfig{
+		{URL: "http://backend1:8080", Weight: 5},
+		{URL: "http://backend4:8080", Weight: 2},
+	})
+
+	if lb.ProcessPack[0] != survivor {
+		t.Errorf("Expected surviving backend to keep its state across the swap")
+	}
+
+	credit := 0
+	for _, p := range lb.ProcessPack {
+		credit += p.Current
+	}
+	if credit != 0 {
+		t.Errorf("Expected scheduling credits to sum to zero after the swap, got %d", credit)
+	}
+
+	counts = make(map[string]int)
+	for i := 0; i < 7; i++ {
+		counts[lb.GetNextInstance(req).URL.String()]++
+	}
+	if counts["http://backend1:8080"] != 5 || counts["http://backend4:8080"] != 2 {
+		t.Errorf("Expected a 5/2 split after the swap, got %v", counts)
+	}
+}
@@ -0,0 +1,382 @@
+// Package golb embeds the load balancer in a Go program. A Balancer is an
+// http.Handler proxying requests to a pool of backends that can be added and
+// removed at runtime, with the retries, connection limits, WebSocket support
+// and dead backend revival of the load balancer binary.
+//
+//	lb, err := golb.New(golb.LeastConnections,
+//		golb.Backend{URL: "http://10.0.0.1:8080"},
+//		golb.Backend{URL: "http://10.0.0.2:8080"})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	lb.AddBackend(golb.Backend{URL: "http://10.0.0.3:8080", Weight: 2})
+//	log.Fatal(http.ListenAndServe(":8080", lb))
+package golb
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/The-iyed/go-load-balancer/internal/balancer"
+)
+
+// Method is the balancing method of a Balancer
+type Method int
+
+const (
+	// RoundRobin cycles through the backends in proportion to their weights
+	RoundRobin Method = iota
+	// LeastConnections picks the backend with the fewest requests in flight
+	LeastConnections
+)
+
+var (
+	// ErrDuplicateBackend is returned when adding a backend already in the pool
+	ErrDuplicateBackend = errors.New("golb: backend already registered")
+	// ErrUnknownBackend is returned when removing a backend not in the pool
+	ErrUnknownBackend = errors.New("golb: backend not registered")
+)
+
+// Backend is a backend server of a Balancer
+type Backend struct {
+	// URL is the http or https URL requests are proxied to
+	URL string
+	// Weight is the share of requests the backend gets relative to the
+	// others, 1 if unset
+	Weight int
+	// MaxConns limits the requests in flight to the backend, if set
+	MaxConns int
+	// MaxWebSockets limits the WebSockets open to the backend, if set
+	MaxWebSockets int
+	// Host overrides the Host header sent to the backend, if set
+	Host string
+	// Zone is the failure domain of the backend, if any
+	Zone string
+}
+
+// BackendStatus is the state of a backend
+type BackendStatus struct {
+	Backend
+	Alive             bool
+	Draining          bool
+	ActiveConnections int32
+	Requests          int64
+}
+
+// Event is a change in the state of a backend: up, down, draining, ready,
+// registered or deregistered
+type Event struct {
+	Time    time.Time
+	Backend string
+	State   string
+}
+
+// Middleware wraps the handler serving requests, such as to authenticate or
+// transform them. It calls next to pass a request on to the balancer.
+type Middleware = func(next http.Handler) http.Handler
+
+// RegisterMiddleware registers a middleware under a name, for Balancer.Use
+// and the middlewares= route option of the configuration. Registering an
+// existing name replaces its middleware.
+func RegisterMiddleware(name string, middleware Middleware) {
+	balancer.RegisterMiddleware(name, middleware)
+}
+
+// SessionBackend is a backend as seen by a persistence provider
+type SessionBackend struct {
+	URL               string
+	Alive             bool
+	Draining          bool
+	ActiveConnections int32
+}
+
+// PersistenceProvider implements a custom session persistence method
+type PersistenceProvider interface {
+	// Select returns the URL of the backend the request is bound to, or ""
+	// to let the balancing method choose
+	Select(r *http.Request, backends []SessionBackend) string
+	// Bind is called with the backend chosen for the request so the
+	// provider can record or advertise the binding
+	Bind(w http.ResponseWriter, r *http.Request, backend SessionBackend)
+}
+
+// PersistenceFactory creates a persistence provider from the attributes
+// given to Balancer.SetPersistence or the persistence directive
+type PersistenceFactory func(attrs map[string]string) (PersistenceProvider, error)
+
+// RegisterPersistenceMethod registers a persistence method under a name, for
+// Balancer.SetPersistence and the persistence directive of the
+// configuration. Registering an existing name replaces its factory.
+func RegisterPersistenceMethod(name string, factory PersistenceFactory) {
+	balancer.RegisterPersistenceMethod(name, func(attrs map[string]string) (balancer.PersistenceProvider, error) {
+		provider, err := factory(attrs)
+		if err != nil {
+			return nil, err
+		}
+		return persistenceProvider{provider}, nil
+	})
+}
+
+// persistenceProvider adapts a PersistenceProvider to the balancer's
+type persistenceProvider struct {
+	provider PersistenceProvider
+}
+
+func (pp persistenceProvider) Select(r *http.Request, backends []*balancer.Process) *balancer.Process {
+	sessionBackends := make([]SessionBackend, len(backends))
+	for i, p := range backends {
+		sessionBackends[i] = sessionBackend(p)
+	}
+
+	selected := pp.provider.Select(r, sessionBackends)
+	if selected == "" {
+		return nil
+	}
+	for _, p := range backends {
+		if p.URL.String() == selected {
+			return p
+		}
+	}
+	return nil
+}
+
+func (pp persistenceProvider) Bind(w http.ResponseWriter, r *http.Request, backend *balancer.Process) {
+	pp.provider.Bind(w, r, sessionBackend(backend))
+}
+
+func sessionBackend(p *balancer.Process) SessionBackend {
+	return SessionBackend{
+		URL:               p.URL.String(),
+		Alive:             p.IsAlive(),
+		Draining:          p.IsDraining(),
+		ActiveConnections: p.GetActiveConnections(),
+	}
+}
+
+// pool is a pool whose backends are swapped at runtime
+type pool interface {
+	balancer.Pool
+	UpdateBackends(configs []balancer.BackendConfig) uint64
+}
+
+// Balancer proxies requests to a pool of backends. It is safe for
+// concurrent use.
+type Balancer struct {
+	pool pool
+	// strategy serves requests: the pool behind its middleware
+	strategy balancer.LoadBalancerStrategy
+
+	// middleware holds the names given to Use, to rebuild the strategy
+	// when the pool is replaced
+	middleware [][]string
+
+	mu       sync.Mutex
+	backends []balancer.BackendConfig
+	// known holds every backend URL ever registered, so events about a
+	// backend still reach subscribers after it is removed
+	known map[string]bool
+}
+
+// New creates a Balancer with a balancing method and initial backends
+func New(method Method, backends ...Backend) (*Balancer, error) {
+	b := &Balancer{known: make(map[string]bool)}
+
+	switch method {
+	case RoundRobin:
+		b.pool = balancer.NewLoadBalancer(nil)
+	case LeastConnections:
+		b.pool = balancer.NewLeastConnectionsBalancer(nil)
+	default:
+		return nil, fmt.Errorf("golb: unknown balancing method %d", method)
+	}
+	b.strategy = balancer.NewPoolStrategy(b.pool)
+
+	for _, backend := range backends {
+		if err := b.AddBackend(backend); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// ServeHTTP proxies a request to a backend
+func (b *Balancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.strategy.ProxyRequest(w, r)
+}
+
+// Use passes requests through the middleware registered under the given
+// names, the first being outermost, before they are proxied. Middleware
+// added by later calls runs first. Use must be called before the Balancer
+// serves requests.
+func (b *Balancer) Use(names ...string) error {
+	strategy, err := balancer.NewMiddlewareChain(b.strategy, names)
+	if err != nil {
+		return fmt.Errorf("golb: %w", err)
+	}
+	b.strategy = strategy
+	b.middleware = append(b.middleware, names)
+	return nil
+}
+
+// SetPersistence binds requests to backends with the persistence method
+// registered under a name, passing attrs to its factory. Requests the method
+// does not bind go to the backend the balancing method picks. SetPersistence
+// must be called before the Balancer serves requests.
+func (b *Balancer) SetPersistence(name string, attrs map[string]string) error {
+	method, ok := balancer.LookupPersistenceMethod(name)
+	if !ok {
+		return fmt.Errorf("golb: unknown persistence method: %s", name)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	base := b.pool
+	if persistent, ok := base.(*balancer.SessionPersistenceBalancer); ok {
+		base = persistent.BaseLB.(pool)
+	}
+	strategy, err := balancer.NewSessionPersistence(balancer.NewPoolStrategy(base), method, attrs)
+	if err != nil {
+		return fmt.Errorf("golb: %w", err)
+	}
+
+	b.pool = strategy.(*balancer.PoolStrategy).Pool().(pool)
+	b.strategy = strategy
+	for _, names := range b.middleware {
+		if b.strategy, err = balancer.NewMiddlewareChain(b.strategy, names); err != nil {
+			return fmt.Errorf("golb: %w", err)
+		}
+	}
+	return nil
+}
+
+// AddBackend adds a backend to the pool. It receives requests as soon as
+// AddBackend returns.
+func (b *Balancer) AddBackend(backend Backend) error {
+	u, err := url.Parse(backend.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("golb: invalid backend URL, expected http or https with a host: %s", backend.URL)
+	}
+
+	if backend.Weight <= 0 {
+		backend.Weight = 1
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, config := range b.backends {
+		if config.URL == u.String() {
+			return fmt.Errorf("%w: %s", ErrDuplicateBackend, backend.URL)
+		}
+	}
+
+	b.known[u.Redacted()] = true
+	b.backends = append(b.backends, balancer.BackendConfig{
+		URL:           u.String(),
+		Weight:        backend.Weight,
+		MaxConns:      backend.MaxConns,
+		MaxWebSockets: backend.MaxWebSockets,
+		Host:          backend.Host,
+		Zone:          backend.Zone,
+	})
+	b.pool.UpdateBackends(b.backends)
+	return nil
+}
+
+// RemoveBackend removes the backend with a URL from the pool. It receives no
+// new requests, but the requests and WebSockets in flight to it go on until
+// they finish or the drain timeout passes, when they are cut off.
+func (b *Balancer) RemoveBackend(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnknownBackend, rawURL)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, config := range b.backends {
+		if config.URL != u.String() {
+			continue
+		}
+		backends := append([]balancer.BackendConfig(nil), b.backends[:i]...)
+		b.backends = append(backends, b.backends[i+1:]...)
+		b.pool.UpdateBackends(b.backends)
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownBackend, rawURL)
+}
+
+// SetDrainTimeout sets how long a removed backend keeps the requests and
+// WebSockets in flight to it before they are cut off, 30 seconds by default
+func (b *Balancer) SetDrainTimeout(timeout time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pool.Settings().DrainTimeout = timeout
+}
+
+// Backends returns the state of the backends in the pool
+func (b *Balancer) Backends() []BackendStatus {
+	// Backends are updated under the lock, so their settings are read under it
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	processes := b.pool.Backends()
+	statuses := make([]BackendStatus, 0, len(processes))
+	for _, p := range processes {
+		statuses = append(statuses, BackendStatus{
+			Backend: Backend{
+				URL:           p.URL.String(),
+				Weight:        p.Weight,
+				MaxConns:      int(p.MaxConns),
+				MaxWebSockets: int(p.MaxWebSockets),
+				Host:          p.Host,
+				Zone:          p.Zone,
+			},
+			Alive:             p.IsAlive(),
+			Draining:          p.IsDraining(),
+			ActiveConnections: p.GetActiveConnections(),
+			Requests:          p.GetRequestCount(),
+		})
+	}
+	return statuses
+}
+
+// Close stops reviving the dead backends of the Balancer. It is called once
+// the Balancer no longer serves requests.
+func (b *Balancer) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	balancer.StopRevivals(b.strategy)
+}
+
+// Subscribe returns a channel receiving the state changes of the backends of
+// the Balancer, and a function ending the subscription and closing the
+// channel. Events are dropped for a subscriber that does not keep up.
+func (b *Balancer) Subscribe() (<-chan Event, func()) {
+	health, unsubscribe := balancer.SubscribeBackendEvents()
+	events := make(chan Event, cap(health))
+
+	go func() {
+		defer close(events)
+		for event := range health {
+			b.mu.Lock()
+			known := b.known[event.Backend]
+			b.mu.Unlock()
+			if !known {
+				continue
+			}
+			select {
+			case events <- Event{Time: event.Time, Backend: event.Backend, State: event.State}:
+			default:
+			}
+		}
+	}()
+	return events, unsubscribe
+}
@@ -0,0 +1,94 @@
+package golb_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/The-iyed/go-load-balancer/pkg/golb"
+)
+
+// tenantAffinity binds the requests of a tenant, named by a header, to the
+// backend that served its first request
+type tenantAffinity struct {
+	header string
+
+	mu      sync.Mutex
+	tenants map[string]string
+}
+
+func (ta *tenantAffinity) Select(r *http.Request, backends []golb.SessionBackend) string {
+	ta.mu.Lock()
+	defer ta.mu.Unlock()
+	return ta.tenants[r.Header.Get(ta.header)]
+}
+
+func (ta *tenantAffinity) Bind(w http.ResponseWriter, r *http.Request, backend golb.SessionBackend) {
+	ta.mu.Lock()
+	defer ta.mu.Unlock()
+	ta.tenants[r.Header.Get(ta.header)] = backend.URL
+	w.Header().Set("X-Bound-Backend", backend.URL)
+}
+
+func TestCustomPersistence(t *testing.T) {
+	golb.RegisterPersistenceMethod("tenant_affinity", func(attrs map[string]string) (golb.PersistenceProvider, error) {
+		return &tenantAffinity{header: attrs["header"], tenants: make(map[string]string)}, nil
+	})
+
+	var backends []string
+	for i := 1; i <= 3; i++ {
+		id := i
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%d", id)
+		}))
+		defer server.Close()
+		backends = append(backends, server.URL)
+	}
+
+	lb, err := golb.New(golb.RoundRobin, golb.Backend{URL: backends[0]}, golb.Backend{URL: backends[1]})
+	if err != nil {
+		t.Fatalf("Failed to create the balancer: %v", err)
+	}
+	defer lb.Close()
+	if err := lb.SetPersistence("no_such_method", nil); err == nil {
+		t.Errorf("Expected an unknown persistence method to be rejected")
+	}
+	if err := lb.SetPersistence("tenant_affinity", map[string]string{"header": "X-Tenant"}); err != nil {
+		t.Fatalf("Failed to set the persistence method: %v", err)
+	}
+
+	send := func(tenant string) (string, string) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Tenant", tenant)
+		rec := httptest.NewRecorder()
+		lb.ServeHTTP(rec, req)
+		return rec.Body.String(), rec.Header().Get("X-Bound-Backend")
+	}
+
+	bound := make(map[string]string)
+	for _, tenant := range []string{"a", "b", "a", "b", "a"} {
+		backend, boundTo := send(tenant)
+		if boundTo == "" {
+			t.Fatalf("Expected the provider to bind tenant %s", tenant)
+		}
+		if first, ok := bound[tenant]; ok && backend != first {
+			t.Errorf("Tenant %s: expected backend %s, got %s", tenant, first, backend)
+		}
+		bound[tenant] = backend
+	}
+
+	// Bindings to a kept backend survive changes to the pool
+	if err := lb.AddBackend(golb.Backend{URL: backends[2]}); err != nil {
+		t.Fatalf("Failed to add a backend: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if backend, _ := send("a"); backend != bound["a"] {
+			t.Errorf("Expected tenant a to stay on backend %s, got %s", bound["a"], backend)
+		}
+	}
+	if n := len(lb.Backends()); n != 3 {
+		t.Errorf("Expected 3 backends, got %d", n)
+	}
+}